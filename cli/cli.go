@@ -0,0 +1,507 @@
+package proprdbcli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Config describes how the CLI opens databases and which generated code it
+// can use. NewBundle is optional; without it the CLI falls back to packages
+// registered with rt.RegisterTables.
+type Config struct {
+	DriverName string
+	NewBundle  func(q rt.DBTX) rt.Bundle
+}
+
+type command struct {
+	name    string
+	summary string
+	run     func(cfg Config, db *sql.DB, args []string, stdout io.Writer) error
+}
+
+var commands = []command{
+	{name: "inspect", summary: "show table descriptors, object counts and disk usage", run: runInspect},
+	{name: "export", summary: "write JSONL sync records", run: runExport},
+	{name: "import", summary: "read JSONL sync records", run: runImport},
+	{name: "remotes", summary: "list, forget or rename sync remotes", run: runRemotes},
+	{name: "unknown", summary: "list rows of unknown types, decoded with stored or -descriptors", run: runUnknown},
+	{name: "compact", summary: "compact unknown rows and purge old tombstones", run: runCompact},
+	{name: "vacuum", summary: "run VACUUM on the database", run: runVacuum},
+	{name: "query", summary: "run raw SQL and print the result rows", run: runQuery},
+	{name: "verify", summary: "compare the schema with .proprdb.lock.json files and list drift", run: runVerify},
+}
+
+// Run executes the proprdb command line. args excludes the program name.
+func Run(cfg Config, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("proprdb", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dbPath := flags.String("db", "", "path to the SQLite database file")
+	flags.Usage = func() {
+		usage(flags, stderr)
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+	if *dbPath == "" {
+		return errors.New("missing -db")
+	}
+	commandName := flags.Arg(0)
+	for _, candidate := range commands {
+		if candidate.name != commandName {
+			continue
+		}
+		if cfg.DriverName == "" {
+			return errors.New("empty driver name")
+		}
+		db, err := sql.Open(cfg.DriverName, *dbPath)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", *dbPath, err)
+		}
+		runErr := candidate.run(cfg, db, flags.Args()[1:], stdout)
+		if closeErr := db.Close(); closeErr != nil {
+			if runErr != nil {
+				return fmt.Errorf("%s: %w (additionally, close database: %v)", commandName, runErr, closeErr)
+			}
+			return fmt.Errorf("close database: %w", closeErr)
+		}
+		if runErr != nil {
+			return fmt.Errorf("%s: %w", commandName, runErr)
+		}
+		return nil
+	}
+	flags.Usage()
+	return fmt.Errorf("unknown command %q", commandName)
+}
+
+func usage(flags *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "usage: proprdb -db FILE COMMAND [ARGS]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, candidate := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", candidate.name, candidate.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "flags:")
+	flags.PrintDefaults()
+}
+
+// bundleFor returns the generated tables of cfg or of the registered
+// packages. Without either, it falls back to an rt.DynamicBundle over the
+// types of descriptorsPath, or of the descriptors stored in the database
+// when it is empty.
+func bundleFor(cfg Config, db *sql.DB, descriptorsPath string) (rt.Bundle, error) {
+	newBundle := cfg.NewBundle
+	if newBundle == nil {
+		registered := rt.RegisteredBundles()
+		switch len(registered) {
+		case 0:
+			types, err := dynamicTypes(db, descriptorsPath)
+			if err != nil {
+				return nil, err
+			}
+			newBundle = func(q rt.DBTX) rt.Bundle {
+				return rt.NewDynamicBundle(q, types)
+			}
+		case 1:
+			newBundle = func(q rt.DBTX) rt.Bundle {
+				return registered[0].Factory(q, rt.Options{})
+			}
+		default:
+			return nil, fmt.Errorf("%d generated packages registered; pass Config.NewBundle to choose", len(registered))
+		}
+	}
+	bundle := newBundle(db)
+	if err := bundle.Init(); err != nil {
+		return nil, fmt.Errorf("init tables: %w", err)
+	}
+	return bundle, nil
+}
+
+// dynamicTypes loads the types of the FileDescriptorSet at descriptorsPath,
+// or of the descriptors stored in db when it is empty.
+func dynamicTypes(db *sql.DB, descriptorsPath string) (*rt.DynamicTypes, error) {
+	if descriptorsPath == "" {
+		return rt.StoredDynamicTypes(db)
+	}
+	data, err := os.ReadFile(descriptorsPath)
+	if err != nil {
+		return nil, fmt.Errorf("read descriptors: %w", err)
+	}
+	return rt.LoadDynamicTypes(data)
+}
+
+func runInspect(cfg Config, db *sql.DB, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var descriptors []rt.GeneratedTableDescriptor
+	if cfg.NewBundle != nil {
+		descriptors = cfg.NewBundle(db).TableDescriptors()
+	} else if len(rt.RegisteredBundles()) > 0 {
+		descriptors = rt.RegisteredTableDescriptors()
+	} else {
+		discovered, err := rt.DiscoverTableDescriptors(db)
+		if err != nil {
+			return err
+		}
+		descriptors = discovered
+	}
+	introspection, err := rt.IntrospectTables(db, descriptors)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TABLE\tTYPE\tCORE\tSYNC\tOBJECTS\tBYTES")
+	for _, row := range introspection {
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%t\t%t\t%d\t%d\n",
+			row.Descriptor.TableName,
+			row.Descriptor.TypeName,
+			row.Descriptor.IsCore,
+			row.Descriptor.SyncEnabled,
+			row.ObjectCount,
+			row.DiskUsageBytes,
+		)
+	}
+	return writer.Flush()
+}
+
+func runExport(cfg Config, db *sql.DB, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	remote := flags.String("remote", "", "remote name used for _sync bookkeeping (empty disables it)")
+	outputPath := flags.String("o", "", "output file (default stdout)")
+	descriptorsPath := flags.String("descriptors", "", "serialized FileDescriptorSet of the tables, without generated packages (default: the stored descriptors)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	bundle, err := bundleFor(cfg, db, *descriptorsPath)
+	if err != nil {
+		return err
+	}
+	if *outputPath == "" {
+		return bundle.WriteJSONL(*remote, stdout)
+	}
+	file, err := os.Create(*outputPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", *outputPath, err)
+	}
+	writeErr := bundle.WriteJSONL(*remote, file)
+	if closeErr := file.Close(); closeErr != nil {
+		if writeErr != nil {
+			return fmt.Errorf("%w (additionally, close %s: %v)", writeErr, *outputPath, closeErr)
+		}
+		return fmt.Errorf("close %s: %w", *outputPath, closeErr)
+	}
+	return writeErr
+}
+
+func runImport(cfg Config, db *sql.DB, args []string, _ io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	remote := flags.String("remote", "", "remote name used for _sync bookkeeping (empty disables it)")
+	inputPath := flags.String("i", "", "input file (default stdin)")
+	descriptorsPath := flags.String("descriptors", "", "serialized FileDescriptorSet of the tables, without generated packages (default: the stored descriptors)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	bundle, err := bundleFor(cfg, db, *descriptorsPath)
+	if err != nil {
+		return err
+	}
+	if *inputPath == "" {
+		return bundle.ReadJSONL(*remote, os.Stdin)
+	}
+	file, err := os.Open(*inputPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", *inputPath, err)
+	}
+	readErr := bundle.ReadJSONL(*remote, file)
+	if closeErr := file.Close(); closeErr != nil {
+		if readErr != nil {
+			return fmt.Errorf("%w (additionally, close %s: %v)", readErr, *inputPath, closeErr)
+		}
+		return fmt.Errorf("close %s: %w", *inputPath, closeErr)
+	}
+	return readErr
+}
+
+func runRemotes(cfg Config, db *sql.DB, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("remotes", flag.ContinueOnError)
+	forget := flags.String("forget", "", "delete the sync bookkeeping of this remote")
+	rename := flags.String("rename", "", "rename this remote to -to")
+	to := flags.String("to", "", "new name for -rename")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case *forget != "" && *rename != "":
+		return errors.New("-forget and -rename are exclusive")
+	case *forget != "":
+		forgotten, err := rt.ForgetRemote(db, *forget)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "forgot %d sync rows\n", forgotten)
+		return nil
+	case *rename != "":
+		return rt.RenameRemote(db, *rename, *to)
+	}
+	var tableNames []string
+	var descriptors []rt.GeneratedTableDescriptor
+	if cfg.NewBundle != nil {
+		descriptors = cfg.NewBundle(db).TableDescriptors()
+	} else if len(rt.RegisteredBundles()) > 0 {
+		descriptors = rt.RegisteredTableDescriptors()
+	}
+	for _, descriptor := range descriptors {
+		if descriptor.SyncEnabled {
+			tableNames = append(tableNames, descriptor.TableName)
+		}
+	}
+	remotes, err := rt.ListRemotes(db)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "REMOTE\tTABLE\tLAG")
+	for _, remote := range remotes {
+		lags, err := rt.SyncLag(db, remote, tableNames)
+		if err != nil {
+			return err
+		}
+		for _, lag := range lags {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", remote, lag.TableName, time.Duration(lag.LagNs))
+		}
+	}
+	return writer.Flush()
+}
+
+func runUnknown(_ Config, db *sql.DB, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("unknown", flag.ContinueOnError)
+	descriptorsPath := flags.String("descriptors", "", "serialized FileDescriptorSet defining the unknown types (default: the descriptors embedded in imported streams)")
+	typeName := flags.String("type", "", "only list rows of this type")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	types, err := dynamicTypes(db, *descriptorsPath)
+	if err != nil {
+		return err
+	}
+	decoded, err := types.DecodeUnknown(db, *typeName)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TYPE\tID\tAT_NS\tDATA")
+	for _, row := range decoded {
+		data := string(row.Record.Data)
+		switch {
+		case row.Record.Deleted:
+			data = "(deleted)"
+		case row.Err == nil:
+			encoded, err := protojson.Marshal(row.Message)
+			if err != nil {
+				return fmt.Errorf("encode %s/%s: %w", row.TypeName, row.Record.ID, err)
+			}
+			data = string(encoded)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\n", row.TypeName, row.Record.ID, row.Record.AtNs, data)
+	}
+	return writer.Flush()
+}
+
+func runCompact(_ Config, db *sql.DB, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("compact", flag.ContinueOnError)
+	tombstoneRetention := flags.Duration("tombstone-retention", 0, "purge tombstones older than this (0 keeps all tombstones)")
+	var retention rt.UnknownRetention
+	flags.Int64Var(&retention.MaxRows, "unknown-max-rows", 0, "keep at most this many unknown rows (0 for no limit)")
+	flags.DurationVar(&retention.MaxAge, "unknown-max-age", 0, "evict unknown rows older than this (0 for no limit)")
+	flags.Int64Var(&retention.MaxBytes, "unknown-max-bytes", 0, "keep at most this many bytes of unknown rows (0 for no limit)")
+	archivePath := flags.String("unknown-archive", "", "append evicted unknown rows as JSONL to this file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := rt.CompactUnknownLatest(db); err != nil {
+		return err
+	}
+	if retention.Limited() {
+		if err := evictUnknown(db, retention, *archivePath, stdout); err != nil {
+			return err
+		}
+	}
+	if *tombstoneRetention <= 0 {
+		return nil
+	}
+	purged, err := rt.PurgeTombstones(db, time.Now().Add(-*tombstoneRetention).UnixNano())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "purged %d tombstones\n", purged)
+	return nil
+}
+
+func evictUnknown(db *sql.DB, retention rt.UnknownRetention, archivePath string, stdout io.Writer) error {
+	var archive *os.File
+	if archivePath != "" {
+		var err error
+		archive, err = os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open unknown archive: %w", err)
+		}
+	}
+	var w io.Writer
+	if archive != nil {
+		w = syncedFile{archive}
+	}
+	eviction, err := rt.EvictUnknown(db, retention, time.Now().UnixNano(), w, rt.JSONLOptions{})
+	if archive != nil {
+		if closeErr := archive.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close unknown archive: %w", closeErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "evicted %d unknown rows (%d bytes)\n", eviction.Rows, eviction.Bytes)
+	return nil
+}
+
+// syncedFile makes the flush of a JSONL write durable, so that evicted rows
+// are on disk before they are deleted.
+type syncedFile struct {
+	*os.File
+}
+
+func (f syncedFile) Flush() error {
+	return f.Sync()
+}
+
+func runVacuum(_ Config, db *sql.DB, args []string, _ io.Writer) error {
+	flags := flag.NewFlagSet("vacuum", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(context.Background(), "VACUUM"); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
+
+func runQuery(_ Config, db *sql.DB, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("missing SQL statement")
+	}
+	queryArgs := make([]any, 0, flags.NArg()-1)
+	for _, arg := range flags.Args()[1:] {
+		queryArgs = append(queryArgs, arg)
+	}
+	rows, err := db.QueryContext(context.Background(), flags.Arg(0), queryArgs...)
+	if err != nil {
+		return fmt.Errorf("run query: %w", err)
+	}
+	writeErr := writeRows(rows, stdout)
+	if closeErr := rt.CloseRows(rows, "query"); closeErr != nil {
+		if writeErr != nil {
+			return fmt.Errorf("%w (additionally, %v)", writeErr, closeErr)
+		}
+		return closeErr
+	}
+	return writeErr
+}
+
+func runVerify(_ Config, db *sql.DB, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("missing schema lock file")
+	}
+	locks := make([]rt.SchemaLock, 0, flags.NArg())
+	for _, lockPath := range flags.Args() {
+		file, err := os.Open(lockPath)
+		if err != nil {
+			return fmt.Errorf("open schema lock: %w", err)
+		}
+		lock, err := rt.ReadSchemaLock(file)
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", lockPath, err)
+		}
+		locks = append(locks, lock)
+	}
+	drift, err := rt.VerifySchemaLock(context.Background(), db, locks...)
+	if err != nil {
+		return err
+	}
+	for _, difference := range drift {
+		fmt.Fprintln(stdout, difference)
+	}
+	if len(drift) > 0 {
+		return fmt.Errorf("%d differences from the schema lock", len(drift))
+	}
+	return nil
+}
+
+func writeRows(rows *sql.Rows, stdout io.Writer) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("read result columns: %w", err)
+	}
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(columns, "\t"))
+	values := make([]any, len(columns))
+	valuePointers := make([]any, len(columns))
+	for index := range values {
+		valuePointers[index] = &values[index]
+	}
+	for rows.Next() {
+		if err := rows.Scan(valuePointers...); err != nil {
+			return fmt.Errorf("scan result row: %w", err)
+		}
+		formatted := make([]string, 0, len(values))
+		for _, value := range values {
+			formatted = append(formatted, formatValue(value))
+		}
+		fmt.Fprintln(writer, strings.Join(formatted, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate result rows: %w", err)
+	}
+	return writer.Flush()
+}
+
+func formatValue(value any) string {
+	switch typed := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return "x'" + fmt.Sprintf("%x", typed) + "'"
+	case string:
+		return typed
+	default:
+		return fmt.Sprint(typed)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	proprdbsyncd "github.com/fingon/proprdb/syncd"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg := proprdbsyncd.Config{DriverName: "sqlite3"}
+	if err := proprdbsyncd.Run(ctx, cfg, os.Args[1:], os.Stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "proprdb-syncd:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	proprdbcli "github.com/fingon/proprdb/cli"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	cfg := proprdbcli.Config{DriverName: "sqlite3"}
+	if err := proprdbcli.Run(cfg, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "proprdb:", err)
+		os.Exit(1)
+	}
+}
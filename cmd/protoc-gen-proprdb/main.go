@@ -1,7 +1,9 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
 
 	"github.com/fingon/proprdb/internal/proprdbgen"
 	"google.golang.org/protobuf/compiler/protogen"
@@ -9,16 +11,48 @@ import (
 )
 
 func main() {
-	opts := protogen.Options{}
+	if len(os.Args) == 2 && os.Args[1] == "--version" {
+		fmt.Println("protoc-gen-proprdb", proprdbgen.Version)
+		return
+	}
+	var flags flag.FlagSet
+	generatorOpts := proprdbgen.Options{}
+	flags.BoolVar(&generatorOpts.HTTP, "http", false, "emit a REST http.Handler per file")
+	flags.BoolVar(&generatorOpts.SQL, "sql", false, "emit the SQL DDL of the schema per file")
+	flags.BoolVar(&generatorOpts.MemDB, "memdb", false, "emit in-memory rt/memdb tables for unit tests per file")
+	flags.BoolVar(&generatorOpts.Mock, "mock", false, "emit programmable mocks of the Store interfaces per file")
+	flags.BoolVar(&generatorOpts.OpenAPI, "openapi", false, "emit the OpenAPI 3 document of the REST routes per file")
+	flags.BoolVar(&generatorOpts.GraphQL, "graphql", false, "emit a GraphQL http.Handler and its schema per file (requires http)")
+	flags.BoolVar(&generatorOpts.Deterministic, "deterministic", false, "order tables by message full name instead of declaration order")
+	flags.BoolVar(&generatorOpts.ProtoValidate, "protovalidate", false, "check buf.validate constraints with rt.Options.ProtoValidate on writes")
+	flags.StringVar(&generatorOpts.Lock, "lock", "", "emit a schema lock file per file and check it against the one in this directory")
+	flags.Func("only", "generate only these messages, e.g. only=Person,Note", func(value string) error {
+		generatorOpts.Only = append(generatorOpts.Only, value)
+		return nil
+	})
+	lastParam := ""
+	opts := protogen.Options{ParamFunc: func(name, value string) error {
+		// protoc separates parameters with commas, so only=Person,Note
+		// arrives as only=Person followed by Note.
+		if lastParam == "only" && value == "" && flags.Lookup(name) == nil {
+			generatorOpts.Only = append(generatorOpts.Only, name)
+			return nil
+		}
+		lastParam = name
+		return flags.Set(name, value)
+	}}
 	opts.Run(func(plugin *protogen.Plugin) error {
 		plugin.SupportedFeatures |= uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		if err := proprdbgen.CheckOnly(plugin.Files, generatorOpts.Only); err != nil {
+			return err
+		}
 
 		for _, file := range plugin.Files {
 			if !file.Generate {
 				continue
 			}
 
-			if err := proprdbgen.GenerateFile(plugin, file); err != nil {
+			if err := proprdbgen.GenerateFile(plugin, file, generatorOpts); err != nil {
 				return fmt.Errorf("generate %s: %w", file.Desc.Path(), err)
 			}
 		}
@@ -1,16 +1,30 @@
 package proprdbgen
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
 
 	proprdbpb "github.com/fingon/proprdb/proto/proprdb"
+	proprdbrt "github.com/fingon/proprdb/rt"
 	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 type projectedField struct {
@@ -21,15 +35,76 @@ type projectedField struct {
 	SQLiteDefault   string
 	SchemaSignature string
 	IsOptional      bool
+	Encrypted       bool
+	// GoName names the field of the projected row struct, e.g. "AddressCity"
+	// for the path "address.city".
+	GoName string
+	// GoType is the Go type of the column in the projected row struct.
+	GoType string
+	// Path is the dotted field path of (proprdb.external_paths) columns,
+	// which are read via protoreflect instead of the getter.
+	Path string
+	// ValueFunc converts the getter result to the column value, e.g.
+	// rt.TimestampNs for google.protobuf.Timestamp fields.
+	ValueFunc string
+	// Timestamp is set for google.protobuf.Timestamp fields.
+	Timestamp bool
+	// Check is the CHECK constraint derived from the field rule.
+	Check string
+	// RenamedFrom is the column of the field before (proprdb.renamed_from),
+	// which Init renames to ColumnName.
+	RenamedFrom string
+}
+
+// fieldRule holds the declarative validation options of a field.
+type fieldRule struct {
+	ProtoFieldName string
+	HasMin         bool
+	Min            float64
+	HasMax         bool
+	Max            float64
+	Pattern        string
+	Required       bool
+}
+
+// mapProjection is a map field projected into a key-value side table.
+type mapProjection struct {
+	ProtoFieldName  string
+	GoName          string
+	GetterName      string
+	SideTableName   string
+	ValueGoType     string
+	ValueSQLiteType string
+	SchemaSignature string
+}
+
+type fieldMerge struct {
+	ProtoFieldName string
+	Merge          proprdbpb.Merge
+}
+
+type syncFilter struct {
+	Remote string
+	Where  string
 }
 
 type messageIndex struct {
-	ColumnNames []string
-	IndexName   string
-	Signature   string
+	Columns   []indexColumn
+	IndexName string
+	Signature string
+}
+
+type indexColumn struct {
+	Name       string
+	Descending bool
+	// Collation is the SQLite collation name, empty for the default BINARY.
+	Collation string
+	// Expression is set instead of Name for expression index columns.
+	Expression string
 }
 
 type messageModel struct {
+	Desc                protoreflect.MessageDescriptor
 	GoName              string
 	TableName           string
 	TypeName            string
@@ -37,36 +112,238 @@ type messageModel struct {
 	RowTypeName         string
 	ProjectionSchema    string
 	ProjectedFields     []projectedField
+	MapProjections      []mapProjection
 	Indexes             []messageIndex
 	OmitSync            bool
 	ValidateWrite       bool
 	AllowCustomIDInsert bool
+	IDFormat            proprdbpb.IdFormat
+	Compression         proprdbpb.Compression
+	ConflictStrategy    proprdbpb.ConflictStrategy
+	FieldMerges         []fieldMerge
+	FieldRules          []fieldRule
+	VersionVector       bool
+	SyncFilters         []syncFilter
+	SoftDelete          bool
+	History             bool
+	TrackTimestamps     bool
+	TTLSeconds          int64
+	// TenantColumn is the column of the (proprdb.tenant_field), if any.
+	TenantColumn string
+	// TenantGetter is the Go getter of the tenant field.
+	TenantGetter string
+	// SearchFields are the (proprdb.search) fields.
+	SearchFields []searchField
+	// VectorColumn is the column of the (proprdb.vector) field, if any.
+	VectorColumn string
+	// VectorGoType is the Go element type of the vector field.
+	VectorGoType string
+	// GeoLatColumn and GeoLngColumn are the location columns of
+	// (proprdb.geo), if any.
+	GeoLatColumn string
+	GeoLngColumn string
+	// JSONRenames maps the (proprdb.renamed_from) names of fields, proto and
+	// JSON, to their JSON names.
+	JSONRenames map[string]string
+	// Migrations are the (proprdb.migration) options of the message.
+	Migrations []proprdbrt.FieldMigration
+	// RenamedFrom maps the (proprdb.renamed_from) names of fields to the
+	// fields.
+	RenamedFrom map[string]string
+	// DroppedProjections are the fields of drop_projection migrations.
+	DroppedProjections []string
+	// ProtoValidate is set with protovalidate=true for messages with
+	// buf.validate constraints.
+	ProtoValidate bool
+}
+
+// searchField is a string field mirrored into the search index.
+type searchField struct {
+	Name     string
+	GoGetter string
+	Repeated bool
 }
 
-type modelCollector struct{}
+type modelCollector struct {
+	// only holds the names and full names of the messages of Options.Only,
+	// nil when all messages are candidates.
+	only map[string]bool
+	// defaultGenerate is the (proprdb.default_generate) of the file.
+	defaultGenerate bool
+	// typeURLPrefix is the (proprdb.type_url_prefix) of the file, if any.
+	typeURLPrefix string
+	// protoValidate is Options.ProtoValidate.
+	protoValidate bool
+}
 
 type generatorEmitter struct {
 	g *protogen.GeneratedFile
+	// typeURLPrefix is the (proprdb.type_url_prefix) of the file, if any.
+	typeURLPrefix string
 }
 
 const (
-	errNilDBTX             = "nil DBTX"
-	errNilData             = "nil data"
-	errEmptyID             = "empty id"
-	projectionOptionalFlag = ":optional"
+	errNilDBTX              = "nil DBTX"
+	errNilData              = "nil data"
+	projectionOptionalFlag  = ":optional"
+	projectionEncryptedFlag = ":encrypted"
+	projectionColumnFlag    = ":column="
+	versionVectorColumnSQL  = `"vv" TEXT NOT NULL DEFAULT '{}'`
+	deletedAtNsColumnSQL    = `"deleted_at_ns" INTEGER`
+	timestampFullName       = "google.protobuf.Timestamp"
+	createdAtNsColumn       = "created_at_ns"
+	updatedAtNsColumn       = "updated_at_ns"
 )
 
+// Version is the protoc-gen-proprdb version stamped into generated files.
+const Version = "v0.1.0"
+
+// Options are the plugin parameters of protoc-gen-proprdb.
+type Options struct {
+	// HTTP additionally emits <file>.proprdb_http.pb.go with a REST
+	// http.Handler for the CRUD bundle (parameter http=true).
+	HTTP bool
+	// SQL additionally emits <file>.proprdb.sql with the DDL of the core and
+	// generated tables (parameter sql=true).
+	SQL bool
+	// MemDB additionally emits <file>.proprdb_memdb.pb.go with in-memory
+	// rt/memdb tables for unit tests (parameter memdb=true).
+	MemDB bool
+	// Mock additionally emits <file>.proprdb_mock.pb.go with programmable
+	// mocks of the Store interfaces (parameter mock=true).
+	Mock bool
+	// OpenAPI additionally emits <file>.proprdb.openapi.json with the
+	// OpenAPI 3 document of the REST routes (parameter openapi=true).
+	OpenAPI bool
+	// GraphQL additionally emits <file>.proprdb_graphql.pb.go with a
+	// GraphQL http.Handler and <file>.proprdb.graphql with its schema
+	// (parameter graphql=true, which requires http=true).
+	GraphQL bool
+	// Only restricts generation to the messages with these names or full
+	// names (parameter only=Person,Note). Messages with (proprdb.skip)=true
+	// stay excluded.
+	Only []string
+	// Deterministic orders the tables of a file by message full name instead
+	// of declaration order, so that moving messages within a .proto file does
+	// not change the generated code (parameter deterministic=true).
+	Deterministic bool
+	// Lock additionally emits <file>.proprdb.lock.json with the projected
+	// fields of each table, and fails when a field projected in the lock
+	// file of the previous run, read from the directory Lock, is no longer
+	// projected without a drop_projection migration (parameter
+	// lock=<output directory>). It does not change the generated code, so
+	// it is not part of the header.
+	Lock string
+	// ProtoValidate makes writes of messages with buf.validate constraints
+	// check them with rt.Options.ProtoValidate (parameter
+	// protovalidate=true).
+	ProtoValidate bool
+}
+
+// parameters lists o as canonical plugin parameters, sorted by name.
+func (o Options) parameters() string {
+	parameters := make([]string, 0, 8)
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"deterministic", o.Deterministic},
+		{"graphql", o.GraphQL},
+		{"http", o.HTTP},
+		{"memdb", o.MemDB},
+		{"mock", o.Mock},
+	} {
+		if flag.set {
+			parameters = append(parameters, flag.name+"=true")
+		}
+	}
+	if len(o.Only) > 0 {
+		only := slices.Clone(o.Only)
+		slices.Sort(only)
+		parameters = append(parameters, "only="+strings.Join(slices.Compact(only), ","))
+	}
+	if o.OpenAPI {
+		parameters = append(parameters, "openapi=true")
+	}
+	if o.ProtoValidate {
+		parameters = append(parameters, "protovalidate=true")
+	}
+	if o.SQL {
+		parameters = append(parameters, "sql=true")
+	}
+	return strings.Join(parameters, ",")
+}
+
+// header returns the comment lines heading generated files: the generator
+// version and the options with their fingerprint, so that reviewers can
+// tell generator changes from schema changes.
+func (o Options) header() []string {
+	parameters := o.parameters()
+	fingerprint := sha256.Sum256([]byte(parameters))
+	if parameters == "" {
+		parameters = "none"
+	}
+	return []string{
+		"Code generated by protoc-gen-proprdb. DO NOT EDIT.",
+		"versions:",
+		"\tprotoc-gen-proprdb " + Version,
+		"options: " + parameters + " (fingerprint " + hex.EncodeToString(fingerprint[:8]) + ")",
+	}
+}
+
+// emitHeader emits the header of opts as comments starting with marker.
+func emitHeader(g *protogen.GeneratedFile, marker string, opts Options) {
+	for _, line := range opts.header() {
+		g.P(marker, " ", line)
+	}
+}
+
+// CheckOnly fails when a name of Options.Only matches no message of files.
+func CheckOnly(files []*protogen.File, only []string) error {
+	known := make(map[string]bool)
+	var collect func(messages []*protogen.Message)
+	collect = func(messages []*protogen.Message) {
+		for _, message := range messages {
+			known[string(message.Desc.Name())] = true
+			known[string(message.Desc.FullName())] = true
+			collect(message.Messages)
+		}
+	}
+	for _, file := range files {
+		if file.Generate {
+			collect(file.Messages)
+		}
+	}
+	for _, name := range only {
+		if !known[name] {
+			return fmt.Errorf("only=%s matches no message", name)
+		}
+	}
+	return nil
+}
+
 // GenerateFile generates proprdb CRUD code for one .proto file.
-func GenerateFile(plugin *protogen.Plugin, file *protogen.File) error {
-	collector := modelCollector{}
+func GenerateFile(plugin *protogen.Plugin, file *protogen.File, opts Options) error {
+	collector, err := newModelCollector(file, opts)
+	if err != nil {
+		return err
+	}
 	models, err := collector.collectModels(file)
 	if err != nil {
 		return err
 	}
+	if opts.GraphQL && !opts.HTTP {
+		return errors.New("graphql=true requires http=true")
+	}
 
 	if len(models) == 0 {
 		return nil
 	}
+	if opts.Deterministic {
+		slices.SortStableFunc(models, func(a, b messageModel) int {
+			return strings.Compare(a.TypeName, b.TypeName)
+		})
+	}
 
 	filename := file.GeneratedFilenamePrefix + ".proprdb.pb.go"
 	g := plugin.NewGeneratedFile(filename, file.GoImportPath)
@@ -80,14 +357,13 @@ func GenerateFile(plugin *protogen.Plugin, file *protogen.File) error {
 			hasOptionalProjectedFields = true
 		}
 	}
-	g.P("// Code generated by protoc-gen-proprdb. DO NOT EDIT.")
+	emitHeader(g, "//", opts)
 	g.P()
 	g.P("package ", file.GoPackageName)
 	g.P()
 	g.P("import (")
 	g.P(`"context"`)
 	g.P(`"database/sql"`)
-	g.P(`"encoding/json"`)
 	g.P(`"errors"`)
 	g.P(`"fmt"`)
 	g.P(`"io"`)
@@ -95,6 +371,7 @@ func GenerateFile(plugin *protogen.Plugin, file *protogen.File) error {
 		g.P(`"log/slog"`)
 	}
 	g.P(`"strings"`)
+	g.P(`"time"`)
 	g.P()
 	g.P(`"google.golang.org/protobuf/encoding/protojson"`)
 	g.P(`"google.golang.org/protobuf/proto"`)
@@ -102,900 +379,5427 @@ func GenerateFile(plugin *protogen.Plugin, file *protogen.File) error {
 		g.P(`"google.golang.org/protobuf/reflect/protoreflect"`)
 	}
 	g.P(`"google.golang.org/protobuf/types/known/anypb"`)
+	g.P(`"google.golang.org/protobuf/types/known/fieldmaskpb"`)
 	g.P(`rt "github.com/fingon/proprdb/rt"`)
 	g.P(")")
 	g.P()
 
-	emitter := generatorEmitter{g: g}
+	emitter := generatorEmitter{g: g, typeURLPrefix: collector.typeURLPrefix}
 	emitter.emitShared()
 	for _, model := range models {
 		emitter.emitModel(model)
 	}
 	emitter.emitWrapper(models)
+	emitter.emitRegistration(file)
 
+	if opts.HTTP {
+		generateHTTPFile(plugin, file, models, opts)
+	}
+	if opts.SQL {
+		generateSQLFile(plugin, file, models, opts)
+	}
+	if opts.MemDB {
+		generateMemDBFile(plugin, file, models, opts)
+	}
+	if opts.Mock {
+		generateMockFile(plugin, file, models, opts)
+	}
+	if opts.GraphQL {
+		generateGraphQLFiles(plugin, file, models, opts)
+	}
+	if opts.Lock != "" {
+		if err := generateLockFile(plugin, file, models, opts.Lock); err != nil {
+			return err
+		}
+	}
+	if opts.OpenAPI {
+		return generateOpenAPIFile(plugin, file, models)
+	}
 	return nil
 }
 
-func (c modelCollector) collectModels(file *protogen.File) ([]messageModel, error) {
-	models := make([]messageModel, 0)
-	for _, message := range file.Messages {
-		if err := c.appendMessageModels(&models, message); err != nil {
-			return nil, err
+// lockColumns lists projected and mapProjections by field.
+func lockColumns(projected []projectedField, mapProjections []mapProjection) []proprdbrt.SchemaLockColumn {
+	columns := make([]proprdbrt.SchemaLockColumn, 0, len(projected)+len(mapProjections))
+	for _, projection := range projected {
+		field := projection.ProtoFieldName
+		if projection.Path != "" {
+			field = projection.Path
 		}
+		columns = append(columns, proprdbrt.SchemaLockColumn{Field: field, Column: projection.ColumnName})
 	}
-
-	return models, nil
+	for _, projection := range mapProjections {
+		columns = append(columns, proprdbrt.SchemaLockColumn{Field: projection.ProtoFieldName, Column: projection.SideTableName, SideTable: true})
+	}
+	slices.SortFunc(columns, func(a, b proprdbrt.SchemaLockColumn) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+	return columns
 }
 
-func (c modelCollector) appendMessageModels(models *[]messageModel, message *protogen.Message) error {
-	if !message.Desc.IsMapEntry() {
-		model, err := c.buildModel(message)
-		if err != nil {
-			return err
-		}
-
-		if model.GoName != "" {
-			*models = append(*models, model)
+// generateLockFile checks models against the lock file of the previous run
+// in lockDir, when there is one, and emits the lock file of models.
+func generateLockFile(plugin *protogen.Plugin, file *protogen.File, models []messageModel, lockDir string) error {
+	filename := file.GeneratedFilenamePrefix + ".proprdb.lock.json"
+	lock := proprdbrt.SchemaLock{Tables: make([]proprdbrt.SchemaLockTable, 0, len(models))}
+	for _, model := range models {
+		indexes := make([]string, 0, len(model.Indexes))
+		for _, indexModel := range model.Indexes {
+			indexes = append(indexes, indexModel.IndexName)
 		}
+		lock.Tables = append(lock.Tables, proprdbrt.SchemaLockTable{
+			Table:       model.TableName,
+			Type:        model.TypeName,
+			SchemaHash:  model.ProjectionSchema,
+			Columns:     lockColumns(model.ProjectedFields, model.MapProjections),
+			IndexPrefix: model.generatedIndexPrefix(),
+			Indexes:     indexes,
+		})
 	}
-
-	for _, nested := range message.Messages {
-		if err := c.appendMessageModels(models, nested); err != nil {
+	slices.SortFunc(lock.Tables, func(a, b proprdbrt.SchemaLockTable) int {
+		return strings.Compare(a.Table, b.Table)
+	})
+	previousPath := filepath.Join(lockDir, filename)
+	encoded, err := os.ReadFile(previousPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// The first run with lock has nothing to compare with.
+	case err != nil:
+		return fmt.Errorf("read schema lock: %w", err)
+	default:
+		var previous proprdbrt.SchemaLock
+		if err := json.Unmarshal(encoded, &previous); err != nil {
+			return fmt.Errorf("decode schema lock %s: %w", previousPath, err)
+		}
+		if err := checkSchemaLock(previous, models, previousPath); err != nil {
 			return err
 		}
 	}
+	encoded, err = json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode schema lock: %w", err)
+	}
+	g := plugin.NewGeneratedFile(filename, "")
+	_, err = g.Write(append(encoded, '\n'))
+	return err
+}
 
+// checkSchemaLock fails for the first field projected in previous that
+// models no longer project, unless it was renamed or its projection
+// dropped by a migration. Tables missing from models are not checked.
+func checkSchemaLock(previous proprdbrt.SchemaLock, models []messageModel, previousPath string) error {
+	for _, table := range previous.Tables {
+		index := slices.IndexFunc(models, func(model messageModel) bool { return model.TableName == table.Table })
+		if index < 0 {
+			continue
+		}
+		model := models[index]
+		current := lockColumns(model.ProjectedFields, model.MapProjections)
+		for _, column := range table.Columns {
+			projected := func(field string) bool {
+				return slices.ContainsFunc(current, func(currentColumn proprdbrt.SchemaLockColumn) bool { return currentColumn.Field == field })
+			}
+			if projected(column.Field) || projected(model.RenamedFrom[column.Field]) || slices.Contains(model.DroppedProjections, column.Field) {
+				continue
+			}
+			return fmt.Errorf("%s: %s no longer projects %q, which %s records in column %q; add option (com.github.fingon.proprdb.migration) = {field: %q drop_projection: true} to drop it", model.TypeName, model.TableName, column.Field, previousPath, column.Column, column.Field)
+		}
+	}
 	return nil
 }
 
-func (c modelCollector) buildModel(message *protogen.Message) (messageModel, error) {
-	omitTable, err := c.messageOptionBool(message, proprdbpb.E_OmitTable)
-	if err != nil {
-		return messageModel{}, fmt.Errorf("message %s omit_table option: %w", message.Desc.FullName(), err)
-	}
-	if omitTable {
-		return messageModel{}, nil
-	}
-	omitSync, err := c.messageOptionBool(message, proprdbpb.E_OmitSync)
-	if err != nil {
-		return messageModel{}, fmt.Errorf("message %s omit_sync option: %w", message.Desc.FullName(), err)
+// schemaResources describes the HTTP resources of models for the schema
+// documents, without tables behind them.
+func schemaResources(models []messageModel) []proprdbrt.HTTPResource {
+	resources := make([]proprdbrt.HTTPResource, 0, len(models))
+	for _, model := range models {
+		desc := model.Desc
+		resources = append(resources, proprdbrt.HTTPResource{
+			Path:          model.httpPath(),
+			Columns:       model.httpColumns(),
+			FilterColumns: model.filterColumns(),
+			New: func() proto.Message {
+				return dynamicpb.NewMessage(desc)
+			},
+		})
 	}
-	validateWrite, err := c.messageOptionBool(message, proprdbpb.E_ValidateWrite)
-	if err != nil {
-		return messageModel{}, fmt.Errorf("message %s validate_write option: %w", message.Desc.FullName(), err)
+	return resources
+}
+
+// generateGraphQLFiles emits the GraphQL handler of the CRUD bundle and the
+// SDL it serves, for client tooling.
+func generateGraphQLFiles(plugin *protogen.Plugin, file *protogen.File, models []messageModel, opts Options) {
+	g := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+".proprdb_graphql.pb.go", file.GoImportPath)
+	emitHeader(g, "//", opts)
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	g.P("import (")
+	g.P(`rt "github.com/fingon/proprdb/rt"`)
+	g.P(")")
+	g.P()
+	g.P("// NewGraphQLHandler serves GraphQL queries and mutations for every table of")
+	g.P("// crud, and subscriptions to their changes with Options.ChangeFeed; see")
+	g.P("// rt.GraphQL.")
+	g.P("func NewGraphQLHandler(crud *CRUD) *rt.GraphQL {")
+	g.P("	graphQL := rt.NewGraphQL(")
+	for _, model := range models {
+		g.P("		crud.", model.GoName, ".HTTPResource(),")
 	}
-	allowCustomIDInsert, err := c.messageOptionBool(message, proprdbpb.E_AllowCustomIdInsert)
+	g.P("	)")
+	g.P("	if q, err := crud.dbtx(); err == nil && crud.opts.ChangeFeed {")
+	g.P("		graphQL.Changes = q")
+	g.P("	}")
+	g.P("	return graphQL")
+	g.P("}")
+
+	schema := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+".proprdb.graphql", "")
+	emitHeader(schema, "#", opts)
+	schema.P("# GraphQL schema of ", file.Desc.Path(), ".")
+	schema.P()
+	schema.P(proprdbrt.NewGraphQL(schemaResources(models)...).Schema())
+}
+
+// generateOpenAPIFile emits the document rt.NewHTTPHandler serves at
+// rt.OpenAPIPath, for client generators.
+func generateOpenAPIFile(plugin *protogen.Plugin, file *protogen.File, models []messageModel) error {
+	document, err := proprdbrt.OpenAPIDocument(schemaResources(models)...)
 	if err != nil {
-		return messageModel{}, fmt.Errorf("message %s allow_custom_id_insert option: %w", message.Desc.FullName(), err)
+		return err
 	}
-	projected := make([]projectedField, 0)
-	signatures := make([]string, 0)
-	fieldsByName := make(map[string]*protogen.Field)
-	projectedByName := make(map[string]bool)
+	g := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+".proprdb.openapi.json", "")
+	_, err = g.Write(document)
+	return err
+}
 
-	for _, field := range message.Fields {
-		fieldsByName[string(field.Desc.Name())] = field
+// generateSQLFile emits the DDL Init executes on an empty database, for
+// review and external migration tools.
+func generateSQLFile(plugin *protogen.Plugin, file *protogen.File, models []messageModel, opts Options) {
+	g := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+".proprdb.sql", "")
+	emitHeader(g, "--", opts)
+	g.P("-- Schema of ", file.Desc.Path(), ".")
+	g.P()
+	g.P("-- Core tables")
+	for _, statement := range proprdbrt.CoreTablesSQL() {
+		g.P(statement, ";")
 	}
-
-	for _, field := range message.Fields {
-		external, err := c.fieldExternal(field)
-		if err != nil {
-			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+	for _, model := range models {
+		g.P()
+		g.P("-- ", model.TypeName)
+		g.P(model.createTableSQL(), ";")
+		for _, indexModel := range model.Indexes {
+			g.P(model.createIndexSQL(indexModel), ";")
 		}
-
-		if !external {
-			continue
+		for _, projection := range model.MapProjections {
+			createSQL, indexSQL := proprdbrt.MapProjectionTableSQL(projection.SideTableName, projection.ValueSQLiteType)
+			g.P(createSQL, ";")
+			g.P(indexSQL, ";")
 		}
-
-		projection, err := c.projectedFieldFromProto(field)
-		if err != nil {
-			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		if model.History {
+			g.P(proprdbrt.HistoryTableSQL(model.historyTableName()), ";")
 		}
-
-		projected = append(projected, projection)
-		projectedByName[projection.ColumnName] = true
-		signatures = append(signatures, projection.SchemaSignature)
+		g.P("INSERT INTO ", proprdbrt.CoreTableSchemaStateName, " (table_name, schema_hash) VALUES (", sqlQuote(model.TableName), ", ", sqlQuote(model.ProjectionSchema), ") ON CONFLICT(table_name) DO NOTHING;")
 	}
+}
 
-	indexes, err := c.messageOptionIndexes(message, fieldsByName, projectedByName)
-	if err != nil {
-		return messageModel{}, fmt.Errorf("message %s indexes option: %w", message.Desc.FullName(), err)
+// sqlQuote returns value as an SQL string literal.
+func sqlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// generateMemDBFile emits constructors of rt/memdb tables standing in for
+// the generated tables in unit tests.
+func generateMemDBFile(plugin *protogen.Plugin, file *protogen.File, models []messageModel, opts Options) {
+	g := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+".proprdb_memdb.pb.go", file.GoImportPath)
+	needsProtoreflect := false
+	needsTime := false
+	for _, model := range models {
+		for _, projectedField := range model.memDBFields() {
+			if projectedField.IsOptional && projectedField.Path == "" {
+				needsProtoreflect = true
+			}
+			if projectedField.Timestamp {
+				needsTime = true
+			}
+		}
 	}
-	for _, indexModel := range indexes {
-		signatures = append(signatures, indexModel.Signature)
+	emitHeader(g, "//", opts)
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	g.P("import (")
+	if needsTime {
+		g.P(`"time"`)
+		g.P()
+	}
+	if needsProtoreflect {
+		g.P(`"google.golang.org/protobuf/reflect/protoreflect"`)
+	}
+	g.P(`rt "github.com/fingon/proprdb/rt"`)
+	g.P(`"github.com/fingon/proprdb/rt/memdb"`)
+	g.P(")")
+	g.P()
+	g.P("// MemCRUD holds in-memory stand-ins of every table of CRUD, for unit tests.")
+	g.P("type MemCRUD struct {")
+	for _, model := range models {
+		g.P("	", model.GoName, " *", model.GoName, "MemTable")
+	}
+	g.P("}")
+	g.P()
+	g.P("// NewMemCRUD returns empty in-memory tables.")
+	g.P("func NewMemCRUD() *MemCRUD {")
+	g.P("	return NewMemCRUDWithOptions(rt.Options{})")
+	g.P("}")
+	g.P()
+	g.P("// NewMemCRUDWithOptions returns empty in-memory tables using the IDGenerator")
+	g.P("// and Clock of opts.")
+	g.P("func NewMemCRUDWithOptions(opts rt.Options) *MemCRUD {")
+	g.P("	return &MemCRUD{")
+	for _, model := range models {
+		g.P("		", model.GoName, ": New", model.GoName, "MemTableWithOptions(opts),")
+	}
+	g.P("	}")
+	g.P("}")
+	g.P()
+	for _, model := range models {
+		g.P("// ", model.GoName, "Store returns the ", model.GoName, " table as a ", model.GoName, "Store.")
+		g.P("func (c *MemCRUD) ", model.GoName, "Store() ", model.GoName, "Store {")
+		g.P("	return c.", model.GoName)
+		g.P("}")
+		g.P()
+	}
+	emitter := generatorEmitter{g: g}
+	for _, model := range models {
+		emitter.emitMemTable(model)
 	}
+}
 
-	return messageModel{
-		GoName:              message.GoIdent.GoName,
-		TableName:           c.tableNameForMessage(message),
-		TypeName:            string(message.Desc.FullName()),
-		TableTypeName:       message.GoIdent.GoName + "Table",
-		RowTypeName:         message.GoIdent.GoName + "Row",
-		ProjectionSchema:    strings.Join(signatures, ";"),
-		ProjectedFields:     projected,
-		Indexes:             indexes,
-		OmitSync:            omitSync,
-		ValidateWrite:       validateWrite,
-		AllowCustomIDInsert: allowCustomIDInsert,
-	}, nil
+// storeMethod is a method of a generated Store interface, split from its
+// signature in storeMethods.
+type storeMethod struct {
+	Name    string
+	Params  string
+	Results string
+	// Args forwards the parameters to a function of the same signature.
+	Args []string
+	// Recorded are the parameters as recorded by rt.MockRecorder.
+	Recorded []string
 }
 
-func (c modelCollector) messageOptionIndexes(message *protogen.Message, fieldsByName map[string]*protogen.Field, projectedByName map[string]bool) ([]messageIndex, error) {
-	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
-	if !ok || messageOptions == nil {
-		return nil, nil
+// parseStoreMethod splits a signature of storeMethods such as
+// "Select(where string, args ...any) ([]PersonRow, error)".
+func parseStoreMethod(signature string) storeMethod {
+	name, rest, _ := strings.Cut(signature, "(")
+	// Parameters of function type, as in "mutate func(*Person) error", nest
+	// parentheses.
+	params := splitTopLevel(rest, ')')[0]
+	results := rest[len(params)+1:]
+	method := storeMethod{Name: name, Params: params, Results: strings.TrimSpace(results)}
+	if params == "" {
+		return method
 	}
-	if !proto.HasExtension(messageOptions, proprdbpb.E_Indexes) {
-		return nil, nil
+	// Parameters sharing a type, as in "from, to time.Time", name only the
+	// last one.
+	for _, param := range splitTopLevel(params, ',') {
+		param = strings.TrimSpace(param)
+		paramName, paramType, _ := strings.Cut(param, " ")
+		method.Recorded = append(method.Recorded, paramName)
+		if strings.HasPrefix(paramType, "...") {
+			paramName += "..."
+		}
+		method.Args = append(method.Args, paramName)
 	}
+	return method
+}
 
-	value := proto.GetExtension(messageOptions, proprdbpb.E_Indexes)
-	indexDefs, ok := value.([]*proprdbpb.Index)
-	if !ok {
-		return nil, fmt.Errorf("unexpected com.github.fingon.proprdb.indexes type %T", value)
+// splitTopLevel splits s at the occurrences of separator outside
+// parentheses.
+func splitTopLevel(s string, separator byte) []string {
+	parts := make([]string, 0, 2)
+	depth, start := 0, 0
+	for index := 0; index < len(s); index++ {
+		switch {
+		case s[index] == separator && depth == 0:
+			parts = append(parts, s[start:index])
+			start = index + 1
+		case s[index] == '(':
+			depth++
+		case s[index] == ')':
+			depth--
+		}
 	}
+	return append(parts, s[start:])
+}
 
-	indexes := make([]messageIndex, 0, len(indexDefs))
-	signatureSeen := make(map[string]bool)
-	nameSeen := make(map[string]bool)
-	tableName := c.tableNameForMessage(message)
-	for indexPosition, indexDef := range indexDefs {
-		if indexDef == nil {
-			return nil, fmt.Errorf("index %d is nil", indexPosition+1)
-		}
-		if len(indexDef.Fields) == 0 {
-			return nil, fmt.Errorf("index %d must include at least one field", indexPosition+1)
-		}
-		columnNames := make([]string, 0, len(indexDef.Fields))
-		columnSeen := make(map[string]bool)
-		for fieldPosition, rawFieldName := range indexDef.Fields {
-			fieldName := strings.TrimSpace(rawFieldName)
-			if fieldName == "" {
-				return nil, fmt.Errorf("index %d field %d is empty", indexPosition+1, fieldPosition+1)
-			}
-			if columnSeen[fieldName] {
-				return nil, fmt.Errorf("index %d has duplicate field %q", indexPosition+1, fieldName)
-			}
-			if _, ok := fieldsByName[fieldName]; !ok {
-				return nil, fmt.Errorf("index %d references unknown field %q", indexPosition+1, fieldName)
+// namedResults names the results of m r0, r1, ... so that an unset mock
+// function returns zero values.
+func (m storeMethod) namedResults() string {
+	results := strings.TrimSuffix(strings.TrimPrefix(m.Results, "("), ")")
+	named := make([]string, 0, 2)
+	for index, result := range strings.Split(results, ", ") {
+		named = append(named, "r"+strconv.Itoa(index)+" "+result)
+	}
+	return "(" + strings.Join(named, ", ") + ")"
+}
+
+// generateMockFile emits programmable mocks of the Store interfaces, for
+// unit tests of code that depends on them.
+func generateMockFile(plugin *protogen.Plugin, file *protogen.File, models []messageModel, opts Options) {
+	g := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+".proprdb_mock.pb.go", file.GoImportPath)
+	needsTime := false
+	needsFieldMask := false
+	for _, model := range models {
+		for _, signature := range model.storeMethods() {
+			if strings.Contains(signature, "time.Time") {
+				needsTime = true
 			}
-			if !projectedByName[fieldName] {
-				return nil, fmt.Errorf("index %d field %q must be marked (com.github.fingon.proprdb.external)=true", indexPosition+1, fieldName)
+			if strings.Contains(signature, "fieldmaskpb.") {
+				needsFieldMask = true
 			}
-			columnSeen[fieldName] = true
-			columnNames = append(columnNames, fieldName)
 		}
-		signature := "idx:" + strings.Join(columnNames, ",")
-		if signatureSeen[signature] {
-			return nil, fmt.Errorf("duplicate index declaration for fields %q", strings.Join(columnNames, ","))
+	}
+	emitHeader(g, "//", opts)
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	g.P("import (")
+	if needsTime {
+		g.P(`"time"`)
+		g.P()
+	}
+	if needsFieldMask {
+		g.P(`"google.golang.org/protobuf/types/known/fieldmaskpb"`)
+	}
+	g.P(`rt "github.com/fingon/proprdb/rt"`)
+	g.P(")")
+	for _, model := range models {
+		methods := make([]storeMethod, 0)
+		for _, signature := range model.storeMethods() {
+			methods = append(methods, parseStoreMethod(signature))
 		}
-		signatureSeen[signature] = true
-
-		indexName := c.generatedIndexName(tableName, columnNames)
-		if nameSeen[indexName] {
-			return nil, fmt.Errorf("index name collision for generated name %q", indexName)
+		mockName := "Mock" + model.GoName + "Store"
+		g.P()
+		g.P("// ", mockName, " is a ", model.GoName, "Store for unit tests. Each method records")
+		g.P("// its call and returns what the function field of the same name returns,")
+		g.P("// or zero values when that is nil.")
+		g.P("type ", mockName, " struct {")
+		g.P("	rt.MockRecorder")
+		g.P()
+		for _, method := range methods {
+			g.P("	", method.Name, "Func func(", method.Params, ") ", method.Results)
+		}
+		g.P("}")
+		g.P()
+		g.P("var _ ", model.GoName, "Store = (*", mockName, ")(nil)")
+		for _, method := range methods {
+			g.P()
+			g.P("func (m *", mockName, ") ", method.Name, "(", method.Params, ") ", method.namedResults(), " {")
+			if len(method.Recorded) == 0 {
+				g.P("	m.Record(", strconv.Quote(method.Name), ")")
+			} else {
+				g.P("	m.Record(", strconv.Quote(method.Name), ", ", strings.Join(method.Recorded, ", "), ")")
+			}
+			g.P("	if m.", method.Name, "Func != nil {")
+			g.P("		return m.", method.Name, "Func(", strings.Join(method.Args, ", "), ")")
+			g.P("	}")
+			g.P("	return")
+			g.P("}")
 		}
-		nameSeen[indexName] = true
-
-		indexes = append(indexes, messageIndex{
-			ColumnNames: columnNames,
-			IndexName:   indexName,
-			Signature:   signature,
-		})
 	}
-
-	return indexes, nil
 }
 
-func (c modelCollector) messageOptionBool(message *protogen.Message, extension protoreflect.ExtensionType) (bool, error) {
-	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
-	if !ok || messageOptions == nil {
-		return false, nil
+func (e generatorEmitter) emitMemTable(model messageModel) {
+	g := e.g
+	fields := model.memDBFields()
+	columns := make([]string, 0, len(fields))
+	for _, projectedField := range fields {
+		columns = append(columns, projectedField.ColumnName)
 	}
-	if !proto.HasExtension(messageOptions, extension) {
-		return false, nil
+	memTableTypeName := model.GoName + "MemTable"
+	g.P("// ", memTableTypeName, " is an in-memory ", model.GoName, "Store for unit tests.")
+	g.P("type ", memTableTypeName, " struct {")
+	g.P("	*memdb.Table[*", model.GoName, ", ", model.RowTypeName, "]")
+	g.P("}")
+	g.P()
+	g.P("var _ ", model.GoName, "Store = (*", memTableTypeName, ")(nil)")
+	g.P()
+	g.P("// New", memTableTypeName, " returns an empty in-memory stand-in for ", model.TableTypeName, ".")
+	g.P("func New", memTableTypeName, "() *", memTableTypeName, " {")
+	g.P("	return New", memTableTypeName, "WithOptions(rt.Options{})")
+	g.P("}")
+	g.P()
+	g.P("// New", memTableTypeName, "WithOptions is New", memTableTypeName, " using the IDGenerator and Clock of opts.")
+	g.P("func New", memTableTypeName, "WithOptions(opts rt.Options) *", memTableTypeName, " {")
+	e.emitIDFormatDefault(model)
+	g.P("	return &", memTableTypeName, "{memdb.NewTable(memdb.Config[*", model.GoName, ", ", model.RowTypeName, "]{")
+	g.P("		TableName: ", model.GoName, "TableName,")
+	g.P("		TypeName:  ", strconv.Quote(model.GoName), ",")
+	if model.SoftDelete {
+		g.P("		NewRow: func(id string, atNs, deletedAtNs int64, data *", model.GoName, ") ", model.RowTypeName, " {")
+		g.P("			return ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data, DeletedAtNs: deletedAtNs}")
+	} else {
+		g.P("		NewRow: func(id string, atNs, _ int64, data *", model.GoName, ") ", model.RowTypeName, " {")
+		g.P("			return ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data}")
 	}
-	value := proto.GetExtension(messageOptions, extension)
-	switch enabled := value.(type) {
-	case bool:
-		return enabled, nil
-	case *bool:
-		if enabled == nil {
-			return false, nil
+	g.P("		},")
+	g.P("		RowParts: func(row ", model.RowTypeName, ") (string, *", model.GoName, ") {")
+	g.P("			return row.ID, row.Data")
+	g.P("		},")
+	if model.ValidateWrite || len(model.FieldRules) > 0 || model.ProtoValidate {
+		g.P("		Validate: func(data *", model.GoName, ") error {")
+		if model.ValidateWrite {
+			g.P("			if err := data.Valid(); err != nil {")
+			g.P("				return err")
+			g.P("			}")
 		}
-		return *enabled, nil
-	default:
-		return false, fmt.Errorf("unexpected option type %T", value)
+		switch {
+		case model.ProtoValidate:
+			if len(model.FieldRules) > 0 {
+				g.P("			if err := rt.ValidateFieldRules(data, ", model.GoName, "FieldRules); err != nil {")
+				g.P("				return err")
+				g.P("			}")
+			}
+			g.P("			return rt.ProtoValidate(opts, data)")
+		case len(model.FieldRules) > 0:
+			g.P("			return rt.ValidateFieldRules(data, ", model.GoName, "FieldRules)")
+		default:
+			g.P("			return nil")
+		}
+		g.P("		},")
 	}
-}
-
-func (c modelCollector) fieldExternal(field *protogen.Field) (bool, error) {
-	fieldOptions, ok := field.Desc.Options().(*descriptorpb.FieldOptions)
-	if !ok || fieldOptions == nil {
-		return false, nil
+	if len(fields) > 0 {
+		g.P("		Columns: []string{", quotedList(columns), "},")
+		g.P("		Values: func(data *", model.GoName, ") []any {")
+		g.P("			values := make([]any, 0, ", len(fields), ")")
+		for _, projectedField := range fields {
+			e.emitProjectedFieldAppend("values", "data", projectedField, "\t\t\t", "")
+		}
+		g.P("			return values")
+		g.P("		},")
 	}
-
-	if !proto.HasExtension(fieldOptions, proprdbpb.E_External) {
-		return false, nil
+	if model.SoftDelete {
+		g.P("		SoftDelete: true,")
 	}
-
-	value := proto.GetExtension(fieldOptions, proprdbpb.E_External)
-
-	switch external := value.(type) {
-	case bool:
-		return external, nil
-	case *bool:
-		if external == nil {
-			return false, nil
+	if model.History {
+		g.P("		History: true,")
+	}
+	g.P("		Options: opts,")
+	g.P("	})}")
+	g.P("}")
+	g.P()
+	for _, projectedField := range fields {
+		if !projectedField.Timestamp {
+			continue
 		}
-
-		return *external, nil
-	default:
-		return false, fmt.Errorf("unexpected com.github.fingon.proprdb.external type %T", value)
+		fieldGoName := strings.TrimPrefix(projectedField.GetterName, "Get")
+		column := strconv.Quote(projectedField.ColumnName)
+		g.P("// Select", fieldGoName, "Between returns the rows with ", projectedField.ColumnName, " in [from, to), ordered by ", projectedField.ColumnName, ".")
+		g.P("func (t *", memTableTypeName, ") Select", fieldGoName, "Between(from, to time.Time) ([]", model.RowTypeName, ", error) {")
+		g.P("	opts := rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: ", column, "}}}")
+		g.P("	return t.SelectWithOptions(opts, `", column, " >= ? AND ", column, " < ?`, ", projectedField.timestampBound("from"), ", ", projectedField.timestampBound("to"), ")")
+		g.P("}")
+		g.P()
+	}
+	if model.VersionVector {
+		g.P("// VersionVector returns an empty vector, as in-memory tables do not sync.")
+		g.P("func (t *", memTableTypeName, ") VersionVector(id string) (rt.VersionVector, error) {")
+		g.P("	return rt.VersionVector{}, nil")
+		g.P("}")
+		g.P()
 	}
 }
 
-func (c modelCollector) projectedFieldFromProto(field *protogen.Field) (projectedField, error) {
-	if field.Desc.IsList() || field.Desc.IsMap() {
-		return projectedField{}, errors.New("external field must be scalar")
+// memDBFields lists the projected fields rt/memdb tables can filter and
+// sort by; encrypted columns only hold ciphertext in SQLite.
+func (m messageModel) memDBFields() []projectedField {
+	fields := make([]projectedField, 0, len(m.ProjectedFields))
+	for _, projectedField := range m.ProjectedFields {
+		if !projectedField.Encrypted {
+			fields = append(fields, projectedField)
+		}
 	}
+	return fields
+}
 
-	columnName := string(field.Desc.Name())
-	protoFieldName := string(field.Desc.Name())
-	getterName := "Get" + field.GoName
-	signature := fmt.Sprintf("%s:%s", columnName, field.Desc.Kind())
-	isOptional := field.Desc.HasOptionalKeyword()
-	if isOptional {
-		signature += projectionOptionalFlag
+func generateHTTPFile(plugin *protogen.Plugin, file *protogen.File, models []messageModel, opts Options) {
+	g := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+".proprdb_http.pb.go", file.GoImportPath)
+	emitHeader(g, "//", opts)
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	g.P("import (")
+	g.P(`"errors"`)
+	g.P(`"net/http"`)
+	g.P()
+	g.P(`"google.golang.org/protobuf/proto"`)
+	g.P(`rt "github.com/fingon/proprdb/rt"`)
+	g.P(")")
+	g.P()
+	g.P("// NewHTTPHandler serves REST routes for every table of crud; see rt.NewHTTPHandler.")
+	g.P("func NewHTTPHandler(crud *CRUD) http.Handler {")
+	g.P("	return rt.NewHTTPHandler(")
+	for _, model := range models {
+		g.P("		crud.", model.GoName, ".HTTPResource(),")
 	}
-
-	switch field.Desc.Kind() {
-	case protoreflect.BoolKind:
-		return projectedField{columnName, protoFieldName, getterName, "INTEGER", "0", signature, isOptional}, nil
-	case protoreflect.Int32Kind,
-		protoreflect.Sint32Kind,
-		protoreflect.Sfixed32Kind,
-		protoreflect.Int64Kind,
-		protoreflect.Sint64Kind,
-		protoreflect.Sfixed64Kind,
-		protoreflect.Uint32Kind,
-		protoreflect.Fixed32Kind,
-		protoreflect.Uint64Kind,
-		protoreflect.Fixed64Kind,
-		protoreflect.EnumKind:
-		return projectedField{columnName, protoFieldName, getterName, "INTEGER", "0", signature, isOptional}, nil
-	case protoreflect.FloatKind, protoreflect.DoubleKind:
-		return projectedField{columnName, protoFieldName, getterName, "REAL", "0", signature, isOptional}, nil
-	case protoreflect.StringKind:
-		return projectedField{columnName, protoFieldName, getterName, "TEXT", "''", signature, isOptional}, nil
-	case protoreflect.BytesKind:
-		return projectedField{columnName, protoFieldName, getterName, "BLOB", "X''", signature, isOptional}, nil
-	default:
-		return projectedField{}, fmt.Errorf("unsupported external field kind %s", field.Desc.Kind())
+	g.P("	)")
+	g.P("}")
+	g.P()
+	for _, model := range models {
+		emitHTTPResource(g, model)
 	}
 }
 
-func (f projectedField) createColumnSQL() string {
-	if f.IsOptional {
-		return fmt.Sprintf(`"%s" %s`, f.ColumnName, f.SQLiteType)
+func emitHTTPResource(g *protogen.GeneratedFile, model messageModel) {
+	g.P("// HTTPResource exposes the table at ", strconv.Quote(model.httpPath()), " for rt.NewHTTPHandler.")
+	g.P("func (t *", model.TableTypeName, ") HTTPResource() rt.HTTPResource {")
+	g.P("	return rt.HTTPResource{")
+	g.P("		Path: ", strconv.Quote(model.httpPath()), ",")
+	g.P("		TableName: ", model.GoName, "TableName,")
+	g.P("		Columns: []rt.HTTPColumn{")
+	for _, column := range model.httpColumns() {
+		g.P("			{Name: ", strconv.Quote(column.Name), ", SQLiteType: ", strconv.Quote(column.SQLiteType), "},")
 	}
-	return fmt.Sprintf(`"%s" %s NOT NULL DEFAULT %s`, f.ColumnName, f.SQLiteType, f.SQLiteDefault)
+	g.P("		},")
+	g.P("		FilterColumns: ", model.GoName, "FilterColumns,")
+	g.P("		New: func() proto.Message {")
+	g.P("			return &", model.GoName, "{}")
+	g.P("		},")
+	g.P("		List: func(where string, args ...any) ([]rt.HTTPObject, error) {")
+	g.P("			rows, err := t.Select(where, args...)")
+	g.P("			if err != nil {")
+	g.P("				return nil, err")
+	g.P("			}")
+	g.P("			objects := make([]rt.HTTPObject, 0, len(rows))")
+	g.P("			for _, row := range rows {")
+	g.P("				objects = append(objects, rt.HTTPObject{ID: row.ID, AtNs: row.AtNs, Data: row.Data})")
+	g.P("			}")
+	g.P("			return objects, nil")
+	g.P("		},")
+	g.P("		Get: func(id string) (rt.HTTPObject, bool, error) {")
+	g.P("			row, err := t.GetByID(id)")
+	g.P("			if errors.Is(err, rt.ErrNotFound) {")
+	g.P("				return rt.HTTPObject{}, false, nil")
+	g.P("			}")
+	g.P("			if err != nil {")
+	g.P("				return rt.HTTPObject{}, false, err")
+	g.P("			}")
+	g.P("			return rt.HTTPObject{ID: row.ID, AtNs: row.AtNs, Data: row.Data}, true, nil")
+	g.P("		},")
+	g.P("		Create: func(data proto.Message) (rt.HTTPObject, error) {")
+	g.P("			row, err := t.Insert(data.(*", model.GoName, "))")
+	g.P("			return rt.HTTPObject{ID: row.ID, AtNs: row.AtNs, Data: row.Data}, err")
+	g.P("		},")
+	g.P("		Replace: func(id string, data proto.Message) (rt.HTTPObject, error) {")
+	g.P("			row, err := t.UpdateByID(id, data.(*", model.GoName, "))")
+	g.P("			return rt.HTTPObject{ID: row.ID, AtNs: row.AtNs, Data: row.Data}, err")
+	g.P("		},")
+	g.P("		Delete: t.DeleteByID,")
+	g.P("		ReplaceIfRevision: func(id, revision string, data proto.Message) (rt.HTTPObject, error) {")
+	g.P("			row, err := t.UpdateByIDIfRevision(id, revision, data.(*", model.GoName, "))")
+	g.P("			return rt.HTTPObject{ID: row.ID, AtNs: row.AtNs, Data: row.Data}, err")
+	g.P("		},")
+	g.P("		DeleteIfRevision: t.DeleteByIDIfRevision,")
+	g.P("	}")
+	g.P("}")
+	g.P()
 }
 
-func (c modelCollector) tableNameForMessage(message *protogen.Message) string {
-	fullName := strings.ReplaceAll(string(message.Desc.FullName()), ".", "_")
-	return strings.ToLower(fullName)
+func newModelCollector(file *protogen.File, opts Options) (modelCollector, error) {
+	collector := modelCollector{defaultGenerate: true, protoValidate: opts.ProtoValidate}
+	if len(opts.Only) > 0 {
+		collector.only = make(map[string]bool, len(opts.Only))
+		for _, name := range opts.Only {
+			collector.only[name] = true
+		}
+	}
+	fileOptions, ok := file.Desc.Options().(*descriptorpb.FileOptions)
+	if ok && fileOptions != nil && proto.HasExtension(fileOptions, proprdbpb.E_DefaultGenerate) {
+		value := proto.GetExtension(fileOptions, proprdbpb.E_DefaultGenerate)
+		defaultGenerate, ok := value.(bool)
+		if !ok {
+			return modelCollector{}, fmt.Errorf("unexpected com.github.fingon.proprdb.default_generate type %T", value)
+		}
+		collector.defaultGenerate = defaultGenerate
+	}
+	if ok && fileOptions != nil && proto.HasExtension(fileOptions, proprdbpb.E_TypeUrlPrefix) {
+		value := proto.GetExtension(fileOptions, proprdbpb.E_TypeUrlPrefix)
+		typeURLPrefix, ok := value.(string)
+		if !ok {
+			return modelCollector{}, fmt.Errorf("unexpected com.github.fingon.proprdb.type_url_prefix type %T", value)
+		}
+		if typeURLPrefix == "" || strings.ContainsFunc(typeURLPrefix, unicode.IsSpace) {
+			return modelCollector{}, fmt.Errorf("com.github.fingon.proprdb.type_url_prefix %q must be non-empty without spaces", typeURLPrefix)
+		}
+		collector.typeURLPrefix = typeURLPrefix
+	}
+	return collector, nil
 }
 
-func (c modelCollector) generatedIndexName(tableName string, columnNames []string) string {
-	prefix := "idx_" + sanitizeSQLName(tableName) + "__"
-	sanitizedColumns := make([]string, 0, len(columnNames))
-	for _, columnName := range columnNames {
-		sanitizedColumns = append(sanitizedColumns, sanitizeSQLName(columnName))
+func (c modelCollector) collectModels(file *protogen.File) ([]messageModel, error) {
+	models := make([]messageModel, 0)
+	for _, message := range file.Messages {
+		if err := c.appendMessageModels(&models, message); err != nil {
+			return nil, err
+		}
 	}
-	return prefix + strings.Join(sanitizedColumns, "_")
+	tableMessages := make(map[string]string, len(models))
+	for _, model := range models {
+		if other, ok := tableMessages[model.TableName]; ok {
+			return nil, fmt.Errorf("messages %s and %s both use table %q", other, model.TypeName, model.TableName)
+		}
+		tableMessages[model.TableName] = model.TypeName
+	}
+
+	return models, nil
 }
 
-func sanitizeSQLName(value string) string {
-	builder := strings.Builder{}
-	lastUnderscore := false
-	for _, character := range strings.ToLower(value) {
-		isLower := character >= 'a' && character <= 'z'
-		isDigit := character >= '0' && character <= '9'
-		if isLower || isDigit {
-			builder.WriteRune(character)
-			lastUnderscore = false
-			continue
+func (c modelCollector) appendMessageModels(models *[]messageModel, message *protogen.Message) error {
+	if !message.Desc.IsMapEntry() {
+		model, err := c.buildModel(message)
+		if err != nil {
+			return err
 		}
-		if lastUnderscore {
-			continue
+
+		if model.GoName != "" {
+			*models = append(*models, model)
 		}
-		builder.WriteByte('_')
-		lastUnderscore = true
-	}
-	result := strings.Trim(builder.String(), "_")
-	if result == "" {
-		return "idx"
 	}
-	return result
-}
 
-func (m messageModel) hasOptionalProjectedFields() bool {
-	for _, projectedField := range m.ProjectedFields {
-		if projectedField.IsOptional {
-			return true
+	for _, nested := range message.Messages {
+		if err := c.appendMessageModels(models, nested); err != nil {
+			return err
 		}
 	}
-	return false
-}
 
-func (e generatorEmitter) emitShared() {
-	g := e.g
-	g.P("type DBTX = rt.DBTX")
-	g.P("type proprdbJSONLRecord = rt.JSONLRecord")
-	g.P()
+	return nil
 }
 
-func (e generatorEmitter) emitProjectedFieldAppend(argsName, dataName string, projectedField projectedField, indent string) {
-	g := e.g
-	if !projectedField.IsOptional {
-		g.P(indent, argsName, " = append(", argsName, ", ", dataName, ".", projectedField.GetterName, "())")
-		return
+func (c modelCollector) buildModel(message *protogen.Message) (messageModel, error) {
+	omitTable, err := c.messageOptionBool(message, proprdbpb.E_OmitTable)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s omit_table option: %w", message.Desc.FullName(), err)
 	}
-	fieldDescriptorVar := "fieldDescriptor" + projectedField.GetterName
-	g.P(indent, fieldDescriptorVar, " := ", dataName, `.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name("`, projectedField.ProtoFieldName, `"))`)
-	g.P(indent, "if ", fieldDescriptorVar, " != nil && ", dataName, ".ProtoReflect().Has(", fieldDescriptorVar, ") {")
-	g.P(indent, "\t", argsName, " = append(", argsName, ", ", dataName, ".", projectedField.GetterName, "())")
-	g.P(indent, "} else {")
-	g.P(indent, "\t", argsName, " = append(", argsName, ", nil)")
-	g.P(indent, "}")
-}
-
-func (e generatorEmitter) emitModel(model messageModel) {
-	g := e.g
-	tableNameConst := model.GoName + "TableName"
-	typeNameConst := model.GoName + "TypeName"
-	schemaConst := model.GoName + "ProjectionSchema"
-	createTableConst := model.GoName + "CreateTableSQL"
-	insertConst := model.GoName + "InsertSQL"
-	upsertConst := model.GoName + "UpsertSQL"
-	reprojectConst := model.GoName + "ReprojectSQL"
-	indexPrefixConst := model.GoName + "GeneratedIndexPrefix"
-	indexCreateConstPrefix := model.GoName + "CreateIndexSQL"
-
-	g.P("const ", tableNameConst, " = ", strconv.Quote(model.TableName))
-	g.P("const ", typeNameConst, " = ", strconv.Quote(model.TypeName))
-	g.P("const ", schemaConst, " = ", strconv.Quote(model.ProjectionSchema))
-	g.P("const ", createTableConst, " = ", strconv.Quote(model.createTableSQL()))
-	g.P("const ", insertConst, " = ", strconv.Quote(model.insertSQL(false)))
-	g.P("const ", upsertConst, " = ", strconv.Quote(model.insertSQL(true)))
-	g.P("const ", indexPrefixConst, " = ", strconv.Quote(model.generatedIndexPrefix()))
-	for indexPosition, indexModel := range model.Indexes {
-		g.P("const ", indexCreateConstPrefix, strconv.Itoa(indexPosition+1), " = ", strconv.Quote(model.createIndexSQL(indexModel)))
+	if omitTable {
+		return messageModel{}, nil
 	}
-	if len(model.ProjectedFields) > 0 {
-		g.P("const ", reprojectConst, " = ", strconv.Quote(model.reprojectSQL()))
+	selected, err := c.messageSelected(message)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s skip option: %w", message.Desc.FullName(), err)
 	}
-	g.P()
-
-	g.P("type ", model.RowTypeName, " struct {")
-	g.P("\tID string")
-	g.P("\tAtNs int64")
-	g.P("\tData *", model.GoName)
-	g.P("}")
-	g.P()
-
-	g.P("type ", model.TableTypeName, " struct {")
-	g.P("\tq DBTX")
-	g.P("}")
-	g.P()
-
-	g.P("func New", model.TableTypeName, "(q DBTX) *", model.TableTypeName, " {")
-	g.P("\treturn &", model.TableTypeName, "{q: q}")
-	g.P("}")
-	g.P()
-
-	e.emitInitMethod(model, tableNameConst, typeNameConst, schemaConst, createTableConst, indexPrefixConst, indexCreateConstPrefix)
-	e.emitSelectMethod(model, tableNameConst)
-	e.emitInsertMethod(model, tableNameConst, insertConst)
-	e.emitUpdateMethod(model, tableNameConst, upsertConst)
-	e.emitDeleteMethod(model, tableNameConst)
-	e.emitApplyWithAtNsMethods(model, tableNameConst, upsertConst)
-	if len(model.ProjectedFields) > 0 {
-		e.emitReprojectMethod(model, tableNameConst, reprojectConst)
+	if !selected {
+		return messageModel{}, nil
 	}
-	e.emitDrainUnknownMethod(model, typeNameConst)
-}
-
-func (e generatorEmitter) emitInitMethod(model messageModel, tableNameConst, typeNameConst, schemaConst, createTableConst, indexPrefixConst, indexCreateConstPrefix string) {
-	g := e.g
-	g.P("func (t *", model.TableTypeName, ") Init() error {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
-	g.P("\t}")
-	g.P("\tif err := rt.EnsureCoreTables(t.q); err != nil {")
-	g.P("\t\treturn err")
-	g.P("\t}")
-	g.P("\tctx := context.Background()")
-	g.P("\tif _, err := t.q.ExecContext(ctx, ", createTableConst, "); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"create table %s: %w\", ", tableNameConst, ", err)")
-	g.P("\t}")
-
-	if len(model.ProjectedFields) > 0 {
-		g.P("\tcolumnRows, err := t.q.QueryContext(ctx, `PRAGMA table_info(\"`+", tableNameConst, "+`\")`)")
-		g.P("\tif err != nil {")
-		g.P("\t\treturn fmt.Errorf(\"read columns for %s: %w\", ", tableNameConst, ", err)")
+	tableName, err := c.tableNameForMessage(message)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s table_name option: %w", message.Desc.FullName(), err)
+	}
+	omitSync, err := c.messageOptionBool(message, proprdbpb.E_OmitSync)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s omit_sync option: %w", message.Desc.FullName(), err)
+	}
+	validateWrite, err := c.messageOptionBool(message, proprdbpb.E_ValidateWrite)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s validate_write option: %w", message.Desc.FullName(), err)
+	}
+	allowCustomIDInsert, err := c.messageOptionBool(message, proprdbpb.E_AllowCustomIdInsert)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s allow_custom_id_insert option: %w", message.Desc.FullName(), err)
+	}
+	compression, err := c.messageOptionCompression(message)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s compression option: %w", message.Desc.FullName(), err)
+	}
+	idFormatNumber, err := c.messageOptionEnum(message, proprdbpb.E_IdFormat)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s id_format option: %w", message.Desc.FullName(), err)
+	}
+	conflictStrategy, err := c.messageOptionConflictStrategy(message)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s conflict_strategy option: %w", message.Desc.FullName(), err)
+	}
+	versionVector, err := c.messageOptionBool(message, proprdbpb.E_VersionVector)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s version_vector option: %w", message.Desc.FullName(), err)
+	}
+	softDelete, err := c.messageOptionBool(message, proprdbpb.E_SoftDelete)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s soft_delete option: %w", message.Desc.FullName(), err)
+	}
+	history, err := c.messageOptionBool(message, proprdbpb.E_History)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s history option: %w", message.Desc.FullName(), err)
+	}
+	trackTimestamps, err := c.messageOptionBool(message, proprdbpb.E_TrackTimestamps)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s track_timestamps option: %w", message.Desc.FullName(), err)
+	}
+	ttlSeconds, err := c.messageOptionInt64(message, proprdbpb.E_TtlSeconds)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s ttl_seconds option: %w", message.Desc.FullName(), err)
+	}
+	if ttlSeconds < 0 {
+		return messageModel{}, fmt.Errorf("message %s: ttl_seconds must not be negative, got %d", message.Desc.FullName(), ttlSeconds)
+	}
+	fieldMerges, err := c.fieldMerges(message)
+	if err != nil {
+		return messageModel{}, err
+	}
+	if len(fieldMerges) > 0 {
+		switch conflictStrategy {
+		case proprdbpb.ConflictStrategy_CONFLICT_STRATEGY_LAST_WRITER_WINS, proprdbpb.ConflictStrategy_CONFLICT_STRATEGY_MERGE:
+			conflictStrategy = proprdbpb.ConflictStrategy_CONFLICT_STRATEGY_MERGE
+		default:
+			return messageModel{}, fmt.Errorf("message %s: merge field options require conflict_strategy CONFLICT_STRATEGY_MERGE, got %s", message.Desc.FullName(), conflictStrategy)
+		}
+	}
+	projected := make([]projectedField, 0)
+	fieldRules := make([]fieldRule, 0)
+	mapProjections := make([]mapProjection, 0)
+	signatures := make([]string, 0)
+	fieldsByName := make(map[string]*protogen.Field)
+	projectedByName := make(map[string]bool)
+	tenantColumn := ""
+	tenantGetter := ""
+	var searchFields []searchField
+	var jsonRenames, renamedFields map[string]string
+	vectorColumn, vectorGoType := "", ""
+
+	for _, field := range message.Fields {
+		fieldsByName[string(field.Desc.Name())] = field
+	}
+
+	for _, field := range message.Fields {
+		external, err := c.fieldExternal(field)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		encrypted, err := c.fieldOptionBool(field, proprdbpb.E_Encrypted)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		rule, hasRule, err := c.fieldRuleFromProto(field)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		if hasRule {
+			fieldRules = append(fieldRules, rule)
+		}
+		renamedFrom, renamed, err := fieldOptionValue[string](field, proprdbpb.E_RenamedFrom)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		if renamed {
+			switch {
+			case !protoreflect.Name(renamedFrom).IsValid():
+				return messageModel{}, fmt.Errorf("field %s: renamed_from %q is not a field name", field.Desc.FullName(), renamedFrom)
+			case fieldsByName[renamedFrom] != nil:
+				return messageModel{}, fmt.Errorf("field %s: renamed_from %q is still a field of the message", field.Desc.FullName(), renamedFrom)
+			}
+			if jsonRenames == nil {
+				jsonRenames = make(map[string]string)
+				renamedFields = make(map[string]string)
+			}
+			renamedFields[renamedFrom] = string(field.Desc.Name())
+			jsonRenames[renamedFrom] = field.Desc.JSONName()
+			jsonRenames[jsonCamelCase(renamedFrom)] = field.Desc.JSONName()
+		}
+		tenant, err := c.fieldOptionBool(field, proprdbpb.E_TenantField)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		search, err := c.fieldOptionBool(field, proprdbpb.E_Search)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		if search {
+			switch {
+			case encrypted:
+				return messageModel{}, fmt.Errorf("field %s: encrypted fields cannot be searchable", field.Desc.FullName())
+			case field.Desc.Kind() != protoreflect.StringKind || field.Desc.IsMap():
+				return messageModel{}, fmt.Errorf("field %s: searchable field must be a string", field.Desc.FullName())
+			}
+			searchFields = append(searchFields, searchField{
+				Name:     string(field.Desc.Name()),
+				GoGetter: "Get" + field.GoName,
+				Repeated: field.Desc.IsList(),
+			})
+		}
+		if tenant {
+			switch {
+			case !external:
+				return messageModel{}, fmt.Errorf("field %s: tenant field must be marked (com.github.fingon.proprdb.external)=true", field.Desc.FullName())
+			case encrypted:
+				return messageModel{}, fmt.Errorf("field %s: tenant field cannot be encrypted", field.Desc.FullName())
+			case field.Desc.Kind() != protoreflect.StringKind || field.Desc.IsList() || field.Desc.IsMap():
+				return messageModel{}, fmt.Errorf("field %s: tenant field must be a string", field.Desc.FullName())
+			case tenantColumn != "":
+				return messageModel{}, fmt.Errorf("field %s: message already has tenant field %q", field.Desc.FullName(), tenantColumn)
+			}
+		}
+
+		columnName, hasColumnName, err := fieldOptionValue[string](field, proprdbpb.E_ColumnName)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		if hasColumnName {
+			if !external {
+				return messageModel{}, fmt.Errorf("field %s: column_name requires (com.github.fingon.proprdb.external)=true", field.Desc.FullName())
+			}
+			if err := validateColumnName(columnName); err != nil {
+				return messageModel{}, fmt.Errorf("field %s column_name option: %w", field.Desc.FullName(), err)
+			}
+		}
+
+		blobExternal, err := c.fieldOptionBool(field, proprdbpb.E_BlobExternal)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		if blobExternal {
+			switch {
+			case external || encrypted:
+				return messageModel{}, fmt.Errorf("field %s: blob_external field cannot be external or encrypted", field.Desc.FullName())
+			case field.Desc.Kind() != protoreflect.BytesKind || field.Desc.IsList() || field.Desc.IsMap():
+				return messageModel{}, fmt.Errorf("field %s: blob_external field must be a singular bytes field", field.Desc.FullName())
+			}
+		}
+
+		vector, err := c.fieldOptionBool(field, proprdbpb.E_Vector)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		if vector {
+			projection, err := vectorProjectedField(field)
+			if err != nil {
+				return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+			}
+			switch {
+			case external || encrypted:
+				return messageModel{}, fmt.Errorf("field %s: vector field cannot be external or encrypted", field.Desc.FullName())
+			case vectorColumn != "":
+				return messageModel{}, fmt.Errorf("field %s: message already has vector field %q", field.Desc.FullName(), vectorColumn)
+			case projectedByName[projection.ColumnName]:
+				return messageModel{}, fmt.Errorf("field %s: column %q is already used by another field", field.Desc.FullName(), projection.ColumnName)
+			}
+			vectorColumn = projection.ColumnName
+			vectorGoType = "float32"
+			if field.Desc.Kind() == protoreflect.DoubleKind {
+				vectorGoType = "float64"
+			}
+			projected = append(projected, projection)
+			projectedByName[projection.ColumnName] = true
+			signatures = append(signatures, projection.SchemaSignature)
+			continue
+		}
+
+		if !external {
+			if encrypted {
+				return messageModel{}, fmt.Errorf("field %s: encrypted field must be marked (com.github.fingon.proprdb.external)=true", field.Desc.FullName())
+			}
+			continue
+		}
+		if field.Desc.IsMap() {
+			if encrypted {
+				return messageModel{}, fmt.Errorf("field %s: map fields cannot be encrypted", field.Desc.FullName())
+			}
+			projection, err := c.mapProjectionFromProto(tableName, field)
+			if err != nil {
+				return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+			}
+			if hasColumnName {
+				projection.SideTableName = tableName + "__" + columnName
+				projection.SchemaSignature += projectionColumnFlag + columnName
+			}
+			if history && projection.SideTableName == tableName+proprdbrt.HistoryTableSuffix {
+				return messageModel{}, fmt.Errorf("field %s: map side table collides with the history table", field.Desc.FullName())
+			}
+			mapProjections = append(mapProjections, projection)
+			signatures = append(signatures, projection.SchemaSignature)
+			continue
+		}
+
+		projection, err := c.projectedFieldFromProto(field)
+		if err != nil {
+			return messageModel{}, fmt.Errorf("field %s: %w", field.Desc.FullName(), err)
+		}
+		if hasColumnName {
+			if projectedByName[columnName] {
+				return messageModel{}, fmt.Errorf("field %s: column %q is already used by another field", field.Desc.FullName(), columnName)
+			}
+			projection.ColumnName = columnName
+			projection.SchemaSignature += projectionColumnFlag + columnName
+		}
+		if renamed && !hasColumnName {
+			projection.RenamedFrom = renamedFrom
+		}
+		if encrypted {
+			projection.Encrypted = true
+			projection.SchemaSignature += projectionEncryptedFlag
+		} else if hasRule {
+			projection.Check = rule.checkSQL(projection)
+		}
+
+		if tenant {
+			tenantColumn = projection.ColumnName
+			tenantGetter = "Get" + field.GoName
+		}
+		projected = append(projected, projection)
+		projectedByName[projection.ColumnName] = true
+		signatures = append(signatures, projection.SchemaSignature)
+	}
+
+	pathProjections, err := c.messageOptionExternalPaths(message)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s external_paths option: %w", message.Desc.FullName(), err)
+	}
+	for _, projection := range pathProjections {
+		if projectedByName[projection.ColumnName] || fieldsByName[projection.ColumnName] != nil {
+			return messageModel{}, fmt.Errorf("message %s: external path %q column %q collides with field %q", message.Desc.FullName(), projection.Path, projection.ColumnName, projection.ColumnName)
+		}
+		projected = append(projected, projection)
+		projectedByName[projection.ColumnName] = true
+		signatures = append(signatures, projection.SchemaSignature)
+	}
+
+	indexes, err := c.messageOptionIndexes(message, tableName, fieldsByName, projected)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s indexes option: %w", message.Desc.FullName(), err)
+	}
+	geoLatColumn, geoLngColumn, err := c.messageOptionGeo(message, projected)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s geo option: %w", message.Desc.FullName(), err)
+	}
+	if geoLatColumn != "" {
+		geoColumns := []indexColumn{{Name: geoLatColumn}, {Name: geoLngColumn}}
+		signature := "idx:" + geoColumns[0].signature() + "," + geoColumns[1].signature()
+		if !slices.ContainsFunc(indexes, func(indexModel messageIndex) bool { return indexModel.Signature == signature }) {
+			indexes = append(indexes, messageIndex{
+				Columns:   geoColumns,
+				IndexName: c.generatedIndexName(tableName, []string{geoLatColumn, geoLngColumn}),
+				Signature: signature,
+			})
+		}
+	}
+	if trackTimestamps {
+		for _, columnName := range []string{createdAtNsColumn, updatedAtNsColumn} {
+			if projectedByName[columnName] {
+				return messageModel{}, fmt.Errorf("message %s: projected field %q collides with track_timestamps column", message.Desc.FullName(), columnName)
+			}
+			indexes = append(indexes, messageIndex{
+				Columns:   []indexColumn{{Name: columnName}},
+				IndexName: c.generatedIndexName(tableName, []string{columnName}),
+				Signature: "idx:" + columnName,
+			})
+		}
+	}
+	if tenantColumn != "" && !slices.ContainsFunc(indexes, func(indexModel messageIndex) bool {
+		return indexModel.Columns[0].Name == tenantColumn
+	}) {
+		indexes = append(indexes, messageIndex{
+			Columns:   []indexColumn{{Name: tenantColumn}},
+			IndexName: c.generatedIndexName(tableName, []string{tenantColumn}),
+			Signature: "idx:" + tenantColumn,
+		})
+	}
+	if ttlSeconds > 0 {
+		indexes = append(indexes, messageIndex{
+			Columns:   []indexColumn{{Name: "at_ns"}},
+			IndexName: c.generatedIndexName(tableName, []string{"at_ns"}),
+			Signature: "idx:at_ns",
+		})
+	}
+	for _, indexModel := range indexes {
+		signatures = append(signatures, indexModel.Signature)
+	}
+	migrations, droppedProjections, err := c.messageOptionMigrations(message, fieldsByName)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s migration option: %w", message.Desc.FullName(), err)
+	}
+	for _, dropped := range droppedProjections {
+		if slices.ContainsFunc(lockColumns(projected, mapProjections), func(column proprdbrt.SchemaLockColumn) bool { return column.Field == dropped }) {
+			return messageModel{}, fmt.Errorf("message %s migration option: drops the projection of %q, which is still projected", message.Desc.FullName(), dropped)
+		}
+	}
+	for _, migration := range migrations {
+		// A new migration changes the schema, so Init runs it.
+		if migration.CopyFrom != "" {
+			signatures = append(signatures, "mig:"+migration.Field+"<"+migration.CopyFrom)
+		} else {
+			signatures = append(signatures, "mig:"+migration.Field+"="+migration.DefaultJSON)
+		}
+	}
+	syncFilters, err := c.messageOptionSyncFilters(message)
+	if err != nil {
+		return messageModel{}, fmt.Errorf("message %s sync_filters option: %w", message.Desc.FullName(), err)
+	}
+	if len(syncFilters) > 0 && omitSync {
+		return messageModel{}, fmt.Errorf("message %s: sync_filters cannot be combined with omit_sync", message.Desc.FullName())
+	}
+
+	return messageModel{
+		Desc:                message.Desc,
+		GoName:              message.GoIdent.GoName,
+		TableName:           tableName,
+		TypeName:            string(message.Desc.FullName()),
+		TableTypeName:       message.GoIdent.GoName + "Table",
+		RowTypeName:         message.GoIdent.GoName + "Row",
+		ProjectionSchema:    strings.Join(signatures, ";"),
+		ProjectedFields:     projected,
+		MapProjections:      mapProjections,
+		Indexes:             indexes,
+		OmitSync:            omitSync,
+		ValidateWrite:       validateWrite,
+		AllowCustomIDInsert: allowCustomIDInsert,
+		IDFormat:            proprdbpb.IdFormat(idFormatNumber),
+		TenantColumn:        tenantColumn,
+		TenantGetter:        tenantGetter,
+		SearchFields:        searchFields,
+		VectorColumn:        vectorColumn,
+		VectorGoType:        vectorGoType,
+		GeoLatColumn:        geoLatColumn,
+		GeoLngColumn:        geoLngColumn,
+		Compression:         compression,
+		ConflictStrategy:    conflictStrategy,
+		FieldMerges:         fieldMerges,
+		FieldRules:          fieldRules,
+		ProtoValidate:       c.protoValidate && hasProtoValidateRules(message.Desc, map[protoreflect.FullName]bool{}),
+		VersionVector:       versionVector,
+		SyncFilters:         syncFilters,
+		SoftDelete:          softDelete,
+		History:             history,
+		TrackTimestamps:     trackTimestamps,
+		TTLSeconds:          ttlSeconds,
+		JSONRenames:         jsonRenames,
+		Migrations:          migrations,
+		RenamedFrom:         renamedFields,
+		DroppedProjections:  droppedProjections,
+	}, nil
+}
+
+// jsonCamelCase is the protojson name of a field named name.
+func jsonCamelCase(name string) string {
+	var builder strings.Builder
+	upper := false
+	for _, character := range name {
+		switch {
+		case character == '_':
+			upper = true
+		case upper && 'a' <= character && character <= 'z':
+			builder.WriteRune(character - 'a' + 'A')
+			upper = false
+		default:
+			builder.WriteRune(character)
+			upper = false
+		}
+	}
+	return builder.String()
+}
+
+// messageOptionMigrations returns the (proprdb.migration) options of message
+// that migrate data, and the fields of those that drop projections.
+func (c modelCollector) messageOptionMigrations(message *protogen.Message, fieldsByName map[string]*protogen.Field) ([]proprdbrt.FieldMigration, []string, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || messageOptions == nil || !proto.HasExtension(messageOptions, proprdbpb.E_Migration) {
+		return nil, nil, nil
+	}
+	value := proto.GetExtension(messageOptions, proprdbpb.E_Migration)
+	migrationDefs, ok := value.([]*proprdbpb.Migration)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected com.github.fingon.proprdb.migration type %T", value)
+	}
+	migrations := make([]proprdbrt.FieldMigration, 0, len(migrationDefs))
+	var dropped []string
+	for migrationPosition, migrationDef := range migrationDefs {
+		if migrationDef.GetDropProjection() {
+			switch {
+			case migrationDef.GetValue() != nil:
+				return nil, nil, fmt.Errorf("migration %d: drop_projection takes neither copy_from nor default_value", migrationPosition+1)
+			case migrationDef.GetField() == "":
+				return nil, nil, fmt.Errorf("migration %d: drop_projection needs a field", migrationPosition+1)
+			}
+			dropped = append(dropped, migrationDef.GetField())
+			continue
+		}
+		migration := proprdbrt.FieldMigration{Field: migrationDef.GetField()}
+		switch source := migrationDef.GetValue().(type) {
+		case *proprdbpb.Migration_CopyFrom:
+			migration.CopyFrom = source.CopyFrom
+		case *proprdbpb.Migration_DefaultValue:
+			migration.DefaultJSON = source.DefaultValue
+		default:
+			return nil, nil, fmt.Errorf("migration %d needs copy_from, default_value or drop_projection", migrationPosition+1)
+		}
+		if fieldsByName[migration.Field] == nil {
+			return nil, nil, fmt.Errorf("migration %d: no field %q", migrationPosition+1, migration.Field)
+		}
+		// Resolve the migration as the runtime does, to fail at generation.
+		if _, err := proprdbrt.ApplyFieldMigrations(dynamicpb.NewMessage(message.Desc), []proprdbrt.FieldMigration{migration}); err != nil {
+			return nil, nil, fmt.Errorf("migration %d: %w", migrationPosition+1, err)
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, dropped, nil
+}
+
+func (c modelCollector) messageOptionIndexes(message *protogen.Message, tableName string, fieldsByName map[string]*protogen.Field, projected []projectedField) ([]messageIndex, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || messageOptions == nil {
+		return nil, nil
+	}
+	if !proto.HasExtension(messageOptions, proprdbpb.E_Indexes) {
+		return nil, nil
+	}
+
+	value := proto.GetExtension(messageOptions, proprdbpb.E_Indexes)
+	indexDefs, ok := value.([]*proprdbpb.Index)
+	if !ok {
+		return nil, fmt.Errorf("unexpected com.github.fingon.proprdb.indexes type %T", value)
+	}
+
+	projectedByName := make(map[string]projectedField, len(projected))
+	projectedByField := make(map[string]projectedField, len(projected))
+	for _, projection := range projected {
+		projectedByName[projection.ColumnName] = projection
+		if projection.Path == "" {
+			projectedByField[projection.ProtoFieldName] = projection
+		}
+	}
+	indexes := make([]messageIndex, 0, len(indexDefs))
+	signatureSeen := make(map[string]bool)
+	nameSeen := make(map[string]bool)
+	for indexPosition, indexDef := range indexDefs {
+		if indexDef == nil {
+			return nil, fmt.Errorf("index %d is nil", indexPosition+1)
+		}
+		if len(indexDef.Fields) > 0 && len(indexDef.Columns) > 0 {
+			return nil, fmt.Errorf("index %d cannot combine fields and columns", indexPosition+1)
+		}
+		columnDefs := indexDef.Columns
+		for _, fieldName := range indexDef.Fields {
+			columnDefs = append(columnDefs, &proprdbpb.IndexColumn{Field: fieldName})
+		}
+		if len(columnDefs) == 0 {
+			return nil, fmt.Errorf("index %d must include at least one field", indexPosition+1)
+		}
+		columns := make([]indexColumn, 0, len(columnDefs))
+		columnNames := make([]string, 0, len(columnDefs))
+		nameParts := make([]string, 0, len(columnDefs))
+		signatureParts := make([]string, 0, len(columnDefs))
+		columnSeen := make(map[string]bool)
+		for fieldPosition, columnDef := range columnDefs {
+			fieldName := strings.TrimSpace(columnDef.GetField())
+			expression := strings.TrimSpace(columnDef.GetExpression())
+			if fieldName != "" && expression != "" {
+				return nil, fmt.Errorf("index %d field %d cannot set both field and expression", indexPosition+1, fieldPosition+1)
+			}
+			if expression != "" {
+				if err := validateIndexExpression(expression, projectedByName); err != nil {
+					return nil, fmt.Errorf("index %d expression %q: %w", indexPosition+1, expression, err)
+				}
+				fieldName = expression
+			}
+			if fieldName == "" {
+				return nil, fmt.Errorf("index %d field %d is empty", indexPosition+1, fieldPosition+1)
+			}
+			columnName := fieldName
+			if expression != "" {
+				columnName = "expr:" + expression
+			} else if strings.Contains(fieldName, ".") {
+				columnName = pathColumnName(fieldName)
+				if _, ok := projectedByName[columnName]; !ok {
+					return nil, fmt.Errorf("index %d path %q must be listed in (com.github.fingon.proprdb.external_paths)", indexPosition+1, fieldName)
+				}
+			} else {
+				if _, ok := fieldsByName[fieldName]; !ok {
+					return nil, fmt.Errorf("index %d references unknown field %q", indexPosition+1, fieldName)
+				}
+				projection, ok := projectedByField[fieldName]
+				if !ok {
+					return nil, fmt.Errorf("index %d field %q must be marked (com.github.fingon.proprdb.external)=true", indexPosition+1, fieldName)
+				}
+				columnName = projection.ColumnName
+			}
+			if columnSeen[columnName] {
+				return nil, fmt.Errorf("index %d has duplicate field %q", indexPosition+1, fieldName)
+			}
+			columnSeen[columnName] = true
+
+			column := indexColumn{Name: columnName}
+			if expression != "" {
+				column = indexColumn{Expression: expression}
+			}
+			switch columnDef.GetOrder() {
+			case proprdbpb.IndexOrder_INDEX_ORDER_ASC:
+			case proprdbpb.IndexOrder_INDEX_ORDER_DESC:
+				column.Descending = true
+			default:
+				return nil, fmt.Errorf("index %d field %q has unsupported order %v", indexPosition+1, fieldName, columnDef.GetOrder())
+			}
+			switch columnDef.GetCollation() {
+			case proprdbpb.Collation_COLLATION_BINARY:
+			case proprdbpb.Collation_COLLATION_NOCASE:
+				projection, ok := projectedByName[columnName]
+				if ok && (projection.SQLiteType != "TEXT" || projection.Encrypted) {
+					return nil, fmt.Errorf("index %d field %q: NOCASE collation requires an unencrypted string column", indexPosition+1, fieldName)
+				}
+				column.Collation = "NOCASE"
+			default:
+				return nil, fmt.Errorf("index %d field %q has unsupported collation %v", indexPosition+1, fieldName, columnDef.GetCollation())
+			}
+			columns = append(columns, column)
+			columnNames = append(columnNames, columnName)
+			nameParts = append(nameParts, column.nameSuffix())
+			signatureParts = append(signatureParts, column.signature())
+		}
+		signature := "idx:" + strings.Join(signatureParts, ",")
+		if signatureSeen[signature] {
+			return nil, fmt.Errorf("duplicate index declaration for fields %q", strings.Join(columnNames, ","))
+		}
+		signatureSeen[signature] = true
+
+		indexName := c.generatedIndexName(tableName, nameParts)
+		if nameSeen[indexName] {
+			return nil, fmt.Errorf("index name collision for generated name %q", indexName)
+		}
+		nameSeen[indexName] = true
+
+		indexes = append(indexes, messageIndex{
+			Columns:   columns,
+			IndexName: indexName,
+			Signature: signature,
+		})
+	}
+
+	return indexes, nil
+}
+
+// nameSuffix returns the column part of the generated index name. Order and
+// collation are included so that changing them creates a new index and drops
+// the stale one.
+func (c indexColumn) nameSuffix() string {
+	parts := []string{c.Name}
+	if c.Expression != "" {
+		// The hash keeps distinct expressions apart when they sanitize to
+		// the same name, e.g. "a + b" and "a - b".
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(c.Expression))
+		parts = []string{"expr", sanitizeSQLName(c.Expression), fmt.Sprintf("%08x", hash.Sum32())}
+	}
+	if c.Collation != "" {
+		parts = append(parts, strings.ToLower(c.Collation))
+	}
+	if c.Descending {
+		parts = append(parts, "desc")
+	}
+	return strings.Join(parts, "_")
+}
+
+func (c indexColumn) signature() string {
+	signature := c.Name
+	if c.Expression != "" {
+		signature = "expr=" + c.Expression
+	}
+	if c.Collation != "" {
+		signature += ":collate=" + strings.ToLower(c.Collation)
+	}
+	if c.Descending {
+		signature += ":desc"
+	}
+	return signature
+}
+
+func (c indexColumn) sql() string {
+	columnSQL := fmt.Sprintf(`"%s"`, c.Name)
+	if c.Expression != "" {
+		columnSQL = "(" + c.Expression + ")"
+	}
+	if c.Collation != "" {
+		columnSQL += " COLLATE " + c.Collation
+	}
+	if c.Descending {
+		columnSQL += " DESC"
+	}
+	return columnSQL
+}
+
+// indexExpressionFunctions are the deterministic SQLite functions accepted in
+// index expressions.
+var indexExpressionFunctions = map[string]bool{
+	"abs": true, "coalesce": true, "hex": true, "ifnull": true, "instr": true,
+	"length": true, "lower": true, "ltrim": true, "max": true, "min": true,
+	"nullif": true, "replace": true, "round": true, "rtrim": true, "substr": true,
+	"trim": true, "typeof": true, "unicode": true, "upper": true,
+}
+
+// indexExpressionKeywords are the bare words other than column names
+// accepted in index expressions.
+var indexExpressionKeywords = map[string]bool{
+	"and": true, "as": true, "between": true, "binary": true, "blob": true,
+	"case": true, "cast": true, "collate": true, "else": true, "end": true,
+	"glob": true, "in": true, "integer": true, "is": true, "like": true,
+	"nocase": true, "not": true, "null": true, "numeric": true, "or": true,
+	"real": true, "text": true, "then": true, "when": true,
+}
+
+// validateIndexExpression checks that expression is a single SQLite
+// expression over projected columns, id and at_ns using only deterministic
+// functions. It is a conservative lexical check, not a full SQL parser.
+func validateIndexExpression(expression string, projectedByName map[string]projectedField) error {
+	isColumn := func(name string) bool {
+		_, ok := projectedByName[name]
+		return ok || name == "id" || name == "at_ns"
+	}
+	depth := 0
+	columnCount := 0
+	for position := 0; position < len(expression); {
+		character := expression[position]
+		rest := expression[position:]
+		switch {
+		case character == ' ' || character == '\t' || character == '\n':
+			position++
+		case character == '\'' || character == '"':
+			end := position + 1
+			for ; end < len(expression); end++ {
+				if expression[end] != character {
+					continue
+				}
+				if end+1 < len(expression) && expression[end+1] == character {
+					end++
+					continue
+				}
+				break
+			}
+			if end >= len(expression) {
+				return errors.New("unterminated quote")
+			}
+			if character == '"' {
+				name := strings.ReplaceAll(expression[position+1:end], `""`, `"`)
+				if !isColumn(name) {
+					return fmt.Errorf("unknown column %q", name)
+				}
+				columnCount++
+			}
+			position = end + 1
+		case isIdentifierStart(character):
+			end := position + 1
+			for end < len(expression) && (isIdentifierStart(expression[end]) || (expression[end] >= '0' && expression[end] <= '9')) {
+				end++
+			}
+			word := expression[position:end]
+			lowerWord := strings.ToLower(word)
+			next := strings.TrimLeft(expression[end:], " \t\n")
+			switch {
+			case strings.HasPrefix(next, "("):
+				if !indexExpressionFunctions[lowerWord] && !indexExpressionKeywords[lowerWord] {
+					return fmt.Errorf("function %q is not allowed", word)
+				}
+			case word == "data":
+				return errors.New("data holds the serialized protobuf; project the field instead")
+			case isColumn(word):
+				columnCount++
+			case !indexExpressionKeywords[lowerWord]:
+				return fmt.Errorf("unknown column %q", word)
+			}
+			position = end
+		case character >= '0' && character <= '9':
+			position++
+			for position < len(expression) && strings.IndexByte("0123456789.eE", expression[position]) >= 0 {
+				position++
+			}
+		case strings.HasPrefix(rest, "--") || strings.HasPrefix(rest, "/*"):
+			return errors.New("comments are not allowed")
+		case character == '(':
+			depth++
+			position++
+		case character == ')':
+			depth--
+			if depth < 0 {
+				return errors.New("unbalanced parentheses")
+			}
+			position++
+		case strings.IndexByte("+-*/%<>=!|&~,.", character) >= 0:
+			position++
+		default:
+			return fmt.Errorf("unexpected character %q", character)
+		}
+	}
+	if depth != 0 {
+		return errors.New("unbalanced parentheses")
+	}
+	if columnCount == 0 {
+		return errors.New("must reference at least one column")
+	}
+	return nil
+}
+
+func isIdentifierStart(character byte) bool {
+	return character == '_' || (character >= 'a' && character <= 'z') || (character >= 'A' && character <= 'Z')
+}
+
+// messageOptionExternalPaths resolves (proprdb.external_paths) such as
+// "address.city" to projections of scalar fields of nested messages.
+func (c modelCollector) messageOptionExternalPaths(message *protogen.Message) ([]projectedField, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || messageOptions == nil {
+		return nil, nil
+	}
+	if !proto.HasExtension(messageOptions, proprdbpb.E_ExternalPaths) {
+		return nil, nil
+	}
+	value := proto.GetExtension(messageOptions, proprdbpb.E_ExternalPaths)
+	paths, ok := value.([]string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected com.github.fingon.proprdb.external_paths type %T", value)
+	}
+
+	projections := make([]projectedField, 0, len(paths))
+	pathSeen := make(map[string]bool)
+	for _, rawPath := range paths {
+		path := strings.TrimSpace(rawPath)
+		names := strings.Split(path, ".")
+		if len(names) < 2 {
+			return nil, fmt.Errorf("path %q must name a field of a nested message", rawPath)
+		}
+		if pathSeen[path] {
+			return nil, fmt.Errorf("duplicate path %q", path)
+		}
+		pathSeen[path] = true
+		current := message
+		var field *protogen.Field
+		goName := ""
+		for position, name := range names {
+			field = nil
+			for _, candidate := range current.Fields {
+				if string(candidate.Desc.Name()) == name {
+					field = candidate
+					break
+				}
+			}
+			if field == nil {
+				return nil, fmt.Errorf("path %q references unknown field %q of %s", path, name, current.Desc.FullName())
+			}
+			goName += field.GoName
+			if position == len(names)-1 {
+				break
+			}
+			if field.Message == nil || field.Desc.IsList() || field.Desc.IsMap() {
+				return nil, fmt.Errorf("path %q: %s is not a singular message field", path, field.Desc.FullName())
+			}
+			current = field.Message
+		}
+		if field.Desc.HasOptionalKeyword() || field.Message != nil {
+			return nil, fmt.Errorf("path %q: optional and message fields are not supported", path)
+		}
+		projection, err := c.projectedFieldFromProto(field)
+		if err != nil {
+			return nil, fmt.Errorf("path %q: %w", path, err)
+		}
+		projection.ColumnName = pathColumnName(path)
+		projection.ProtoFieldName = path
+		projection.GetterName = ""
+		projection.GoName = goName
+		projection.SchemaSignature = strings.Replace(projection.SchemaSignature, string(field.Desc.Name()), path, 1)
+		projection.Path = path
+		projections = append(projections, projection)
+	}
+	return projections, nil
+}
+
+// messageOptionGeo resolves the (proprdb.geo) latitude and longitude to
+// their projected columns.
+func (c modelCollector) messageOptionGeo(message *protogen.Message, projected []projectedField) (string, string, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || messageOptions == nil || !proto.HasExtension(messageOptions, proprdbpb.E_Geo) {
+		return "", "", nil
+	}
+	value := proto.GetExtension(messageOptions, proprdbpb.E_Geo)
+	geo, ok := value.(*proprdbpb.Geo)
+	if !ok {
+		return "", "", fmt.Errorf("unexpected com.github.fingon.proprdb.geo type %T", value)
+	}
+	columns := make([]string, 0, 2)
+	for _, path := range []string{geo.GetLatitude(), geo.GetLongitude()} {
+		path = strings.TrimSpace(path)
+		index := slices.IndexFunc(projected, func(projection projectedField) bool {
+			return projection.ProtoFieldName == path
+		})
+		if index < 0 || projected[index].SQLiteType != "REAL" || projected[index].Encrypted {
+			return "", "", fmt.Errorf("%q must be an unencrypted external float or double field or external path", path)
+		}
+		columns = append(columns, projected[index].ColumnName)
+	}
+	return columns[0], columns[1], nil
+}
+
+// pathColumnName names the column of an external path, e.g. "address_city"
+// for "address.city".
+func pathColumnName(path string) string {
+	return strings.ReplaceAll(path, ".", "_")
+}
+
+func (c modelCollector) messageOptionSyncFilters(message *protogen.Message) ([]syncFilter, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || messageOptions == nil {
+		return nil, nil
+	}
+	if !proto.HasExtension(messageOptions, proprdbpb.E_SyncFilters) {
+		return nil, nil
+	}
+
+	value := proto.GetExtension(messageOptions, proprdbpb.E_SyncFilters)
+	filterDefs, ok := value.([]*proprdbpb.SyncFilter)
+	if !ok {
+		return nil, fmt.Errorf("unexpected com.github.fingon.proprdb.sync_filters type %T", value)
+	}
+
+	filters := make([]syncFilter, 0, len(filterDefs))
+	remoteSeen := make(map[string]bool)
+	for filterPosition, filterDef := range filterDefs {
+		if filterDef == nil {
+			return nil, fmt.Errorf("sync filter %d is nil", filterPosition+1)
+		}
+		where := strings.TrimSpace(filterDef.GetWhere())
+		if where == "" {
+			return nil, fmt.Errorf("sync filter %d must have a where condition", filterPosition+1)
+		}
+		if remoteSeen[filterDef.GetRemote()] {
+			return nil, fmt.Errorf("duplicate sync filter for remote %q", filterDef.GetRemote())
+		}
+		remoteSeen[filterDef.GetRemote()] = true
+		filters = append(filters, syncFilter{Remote: filterDef.GetRemote(), Where: where})
+	}
+
+	return filters, nil
+}
+
+func (c modelCollector) messageOptionBool(message *protogen.Message, extension protoreflect.ExtensionType) (bool, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || messageOptions == nil {
+		return false, nil
+	}
+	if !proto.HasExtension(messageOptions, extension) {
+		return false, nil
+	}
+	value := proto.GetExtension(messageOptions, extension)
+	switch enabled := value.(type) {
+	case bool:
+		return enabled, nil
+	case *bool:
+		if enabled == nil {
+			return false, nil
+		}
+		return *enabled, nil
+	default:
+		return false, fmt.Errorf("unexpected option type %T", value)
+	}
+}
+
+func (c modelCollector) messageOptionInt64(message *protogen.Message, extension protoreflect.ExtensionType) (int64, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || messageOptions == nil {
+		return 0, nil
+	}
+	if !proto.HasExtension(messageOptions, extension) {
+		return 0, nil
+	}
+	value := proto.GetExtension(messageOptions, extension)
+	switch number := value.(type) {
+	case int64:
+		return number, nil
+	case *int64:
+		if number == nil {
+			return 0, nil
+		}
+		return *number, nil
+	default:
+		return 0, fmt.Errorf("unexpected option type %T", value)
+	}
+}
+
+func (c modelCollector) messageOptionEnum(message *protogen.Message, extension protoreflect.ExtensionType) (protoreflect.EnumNumber, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || messageOptions == nil {
+		return 0, nil
+	}
+	if !proto.HasExtension(messageOptions, extension) {
+		return 0, nil
+	}
+	value := proto.GetExtension(messageOptions, extension)
+	enumValue, ok := value.(protoreflect.Enum)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %s type %T", extension.TypeDescriptor().FullName(), value)
+	}
+	number := enumValue.Number()
+	if enumValue.Descriptor().Values().ByNumber(number) == nil {
+		return 0, fmt.Errorf("unsupported %s value %d", extension.TypeDescriptor().FullName(), number)
+	}
+	return number, nil
+}
+
+func (c modelCollector) messageOptionCompression(message *protogen.Message) (proprdbpb.Compression, error) {
+	number, err := c.messageOptionEnum(message, proprdbpb.E_Compression)
+	if err != nil {
+		return proprdbpb.Compression_COMPRESSION_NONE, err
+	}
+	return proprdbpb.Compression(number), nil
+}
+
+func (c modelCollector) messageOptionConflictStrategy(message *protogen.Message) (proprdbpb.ConflictStrategy, error) {
+	number, err := c.messageOptionEnum(message, proprdbpb.E_ConflictStrategy)
+	if err != nil {
+		return proprdbpb.ConflictStrategy_CONFLICT_STRATEGY_LAST_WRITER_WINS, err
+	}
+	return proprdbpb.ConflictStrategy(number), nil
+}
+
+func (c modelCollector) fieldMerges(message *protogen.Message) ([]fieldMerge, error) {
+	merges := make([]fieldMerge, 0)
+	for _, field := range message.Fields {
+		fieldOptions, ok := field.Desc.Options().(*descriptorpb.FieldOptions)
+		if !ok || fieldOptions == nil || !proto.HasExtension(fieldOptions, proprdbpb.E_Merge) {
+			continue
+		}
+		value := proto.GetExtension(fieldOptions, proprdbpb.E_Merge)
+		merge, ok := value.(proprdbpb.Merge)
+		if !ok {
+			return nil, fmt.Errorf("field %s: unexpected com.github.fingon.proprdb.merge type %T", field.Desc.FullName(), value)
+		}
+		if merge == proprdbpb.Merge_MERGE_NONE {
+			continue
+		}
+		runtimeMerge, ok := runtimeFieldMerges[merge]
+		if !ok {
+			return nil, fmt.Errorf("field %s: unsupported merge %s", field.Desc.FullName(), merge)
+		}
+		if err := proprdbrt.ValidateFieldMerge(field.Desc, runtimeMerge); err != nil {
+			return nil, err
+		}
+		merges = append(merges, fieldMerge{ProtoFieldName: string(field.Desc.Name()), Merge: merge})
+	}
+	return merges, nil
+}
+
+var runtimeFieldMerges = map[proprdbpb.Merge]proprdbrt.FieldMerge{
+	proprdbpb.Merge_MERGE_MAX:       proprdbrt.FieldMergeMax,
+	proprdbpb.Merge_MERGE_SUM:       proprdbrt.FieldMergeSum,
+	proprdbpb.Merge_MERGE_SET_UNION: proprdbrt.FieldMergeSetUnion,
+}
+
+var runtimeFieldMergeNames = map[proprdbpb.Merge]string{
+	proprdbpb.Merge_MERGE_MAX:       "rt.FieldMergeMax",
+	proprdbpb.Merge_MERGE_SUM:       "rt.FieldMergeSum",
+	proprdbpb.Merge_MERGE_SET_UNION: "rt.FieldMergeSetUnion",
+}
+
+func (c modelCollector) fieldTimestampFormat(field *protogen.Field) (proprdbpb.TimestampFormat, error) {
+	fieldOptions, ok := field.Desc.Options().(*descriptorpb.FieldOptions)
+	if !ok || fieldOptions == nil || !proto.HasExtension(fieldOptions, proprdbpb.E_TimestampFormat) {
+		return proprdbpb.TimestampFormat_TIMESTAMP_FORMAT_UNIX_NANOS, nil
+	}
+	value := proto.GetExtension(fieldOptions, proprdbpb.E_TimestampFormat)
+	format, ok := value.(proprdbpb.TimestampFormat)
+	if !ok {
+		return 0, fmt.Errorf("unexpected com.github.fingon.proprdb.timestamp_format type %T", value)
+	}
+	return format, nil
+}
+
+// timestampProjectedField projects a google.protobuf.Timestamp field as
+// INTEGER Unix nanoseconds or as fixed-width RFC 3339 TEXT, both of which
+// sort in time order. Unset timestamps project NULL.
+func timestampProjectedField(field *protogen.Field, format proprdbpb.TimestampFormat) projectedField {
+	projection := projectedField{
+		ColumnName:      string(field.Desc.Name()),
+		ProtoFieldName:  string(field.Desc.Name()),
+		GetterName:      "Get" + field.GoName,
+		GoName:          field.GoName,
+		GoType:          "time.Time",
+		SQLiteType:      "INTEGER",
+		SchemaSignature: fmt.Sprintf("%s:%s", field.Desc.Name(), timestampFullName),
+		IsOptional:      true,
+		ValueFunc:       "rt.TimestampNs",
+		Timestamp:       true,
+	}
+	if format == proprdbpb.TimestampFormat_TIMESTAMP_FORMAT_RFC3339 {
+		projection.SQLiteType = "TEXT"
+		projection.SchemaSignature += ":rfc3339"
+		projection.ValueFunc = "rt.TimestampText"
+	}
+	projection.SchemaSignature += projectionOptionalFlag
+	return projection
+}
+
+// vectorProjectedField projects a (proprdb.vector) field as a BLOB of
+// little-endian float32 elements, see rt.EncodeVector.
+func vectorProjectedField(field *protogen.Field) (projectedField, error) {
+	kind := field.Desc.Kind()
+	if !field.Desc.IsList() || (kind != protoreflect.FloatKind && kind != protoreflect.DoubleKind) {
+		return projectedField{}, errors.New("vector field must be repeated float or double")
+	}
+	return projectedField{
+		ColumnName:      string(field.Desc.Name()),
+		ProtoFieldName:  string(field.Desc.Name()),
+		GetterName:      "Get" + field.GoName,
+		GoName:          field.GoName,
+		GoType:          "[]byte",
+		SQLiteType:      "BLOB",
+		SQLiteDefault:   "X''",
+		SchemaSignature: fmt.Sprintf("%s:bytes:vector", field.Desc.Name()),
+		ValueFunc:       "rt.EncodeVector",
+	}, nil
+}
+
+// fieldRuleFromProto reads the (proprdb.min), (proprdb.max),
+// (proprdb.pattern) and (proprdb.required) options of field.
+func (c modelCollector) fieldRuleFromProto(field *protogen.Field) (fieldRule, bool, error) {
+	rule := fieldRule{ProtoFieldName: string(field.Desc.Name())}
+	var err error
+	if rule.Min, rule.HasMin, err = fieldOptionValue[float64](field, proprdbpb.E_Min); err != nil {
+		return rule, false, err
+	}
+	if rule.Max, rule.HasMax, err = fieldOptionValue[float64](field, proprdbpb.E_Max); err != nil {
+		return rule, false, err
+	}
+	if rule.Pattern, _, err = fieldOptionValue[string](field, proprdbpb.E_Pattern); err != nil {
+		return rule, false, err
+	}
+	if rule.Required, err = c.fieldOptionBool(field, proprdbpb.E_Required); err != nil {
+		return rule, false, err
+	}
+	if !rule.HasMin && !rule.HasMax && rule.Pattern == "" && !rule.Required {
+		return rule, false, nil
+	}
+
+	isSingular := !field.Desc.IsList() && !field.Desc.IsMap()
+	if rule.HasMin || rule.HasMax {
+		if !isSingular || !isNumericKind(field.Desc.Kind()) {
+			return rule, false, errors.New("min and max require a singular numeric field")
+		}
+		if rule.HasMin && rule.HasMax && rule.Min > rule.Max {
+			return rule, false, fmt.Errorf("min %v exceeds max %v", rule.Min, rule.Max)
+		}
+	}
+	if rule.Pattern != "" {
+		if !isSingular || field.Desc.Kind() != protoreflect.StringKind {
+			return rule, false, errors.New("pattern requires a singular string field")
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return rule, false, fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+	return rule, true, nil
+}
+
+func fieldOptionValue[T any](field *protogen.Field, extension protoreflect.ExtensionType) (T, bool, error) {
+	var zero T
+	fieldOptions, ok := field.Desc.Options().(*descriptorpb.FieldOptions)
+	if !ok || fieldOptions == nil || !proto.HasExtension(fieldOptions, extension) {
+		return zero, false, nil
+	}
+	value := proto.GetExtension(fieldOptions, extension)
+	typed, ok := value.(T)
+	if !ok {
+		return zero, false, fmt.Errorf("unexpected %s type %T", extension.TypeDescriptor().FullName(), value)
+	}
+	return typed, true, nil
+}
+
+func isNumericKind(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind,
+		protoreflect.FloatKind, protoreflect.DoubleKind:
+		return true
+	default:
+		return false
+	}
+}
+
+// bufValidateExtension is the field number of the buf.validate message,
+// oneof and field options of protovalidate.
+const bufValidateExtension = 1159
+
+// hasProtoValidateRules reports whether desc, or a message it holds, has
+// buf.validate options, which protovalidate checks. seen guards against
+// recursive messages.
+func hasProtoValidateRules(desc protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) bool {
+	if seen[desc.FullName()] {
+		return false
+	}
+	seen[desc.FullName()] = true
+	if hasBufValidateOption(desc.Options()) {
+		return true
+	}
+	for index := range desc.Oneofs().Len() {
+		if hasBufValidateOption(desc.Oneofs().Get(index).Options()) {
+			return true
+		}
+	}
+	for index := range desc.Fields().Len() {
+		field := desc.Fields().Get(index)
+		if hasBufValidateOption(field.Options()) {
+			return true
+		}
+		if field.IsMap() {
+			field = field.MapValue()
+		}
+		if field.Message() != nil && hasProtoValidateRules(field.Message(), seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBufValidateOption reports whether options sets a buf.validate option.
+// The plugin does not link protovalidate, so the option is usually an
+// unknown field.
+func hasBufValidateOption(options proto.Message) bool {
+	if options == nil {
+		return false
+	}
+	reflected := options.ProtoReflect()
+	if !reflected.IsValid() {
+		return false
+	}
+	found := false
+	reflected.Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		found = field.IsExtension() && field.FullName().Parent() == "buf.validate"
+		return !found
+	})
+	unknown := reflected.GetUnknown()
+	for !found && len(unknown) > 0 {
+		number, _, length := protowire.ConsumeField(unknown)
+		if length < 0 {
+			return false
+		}
+		found = number == bufValidateExtension
+		unknown = unknown[length:]
+	}
+	return found
+}
+
+// literal is the rt.FieldRule composite literal body of the rule.
+func (r fieldRule) literal() string {
+	parts := []string{"Field: " + strconv.Quote(r.ProtoFieldName)}
+	if r.HasMin {
+		parts = append(parts, "HasMin: true", "Min: "+strconv.FormatFloat(r.Min, 'g', -1, 64))
+	}
+	if r.HasMax {
+		parts = append(parts, "HasMax: true", "Max: "+strconv.FormatFloat(r.Max, 'g', -1, 64))
+	}
+	if r.Pattern != "" {
+		parts = append(parts, "Pattern: "+strconv.Quote(r.Pattern))
+	}
+	if r.Required {
+		parts = append(parts, "Required: true")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkSQL is the CHECK constraint of the projected column of the rule's
+// field. Patterns are only checked in Go, as SQLite has no built-in REGEXP.
+func (r fieldRule) checkSQL(projection projectedField) string {
+	column := `"` + projection.ColumnName + `"`
+	conditions := make([]string, 0, 3)
+	if r.HasMin {
+		conditions = append(conditions, column+" >= "+strconv.FormatFloat(r.Min, 'g', -1, 64))
+	}
+	if r.HasMax {
+		conditions = append(conditions, column+" <= "+strconv.FormatFloat(r.Max, 'g', -1, 64))
+	}
+	if r.Required {
+		switch {
+		case projection.IsOptional:
+			conditions = append(conditions, column+" IS NOT NULL")
+		case projection.SQLiteType == "TEXT":
+			conditions = append(conditions, column+" <> ''")
+		case projection.SQLiteType == "BLOB":
+			conditions = append(conditions, "length("+column+") > 0")
+		default:
+			conditions = append(conditions, column+" <> 0")
+		}
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+func (c modelCollector) fieldExternal(field *protogen.Field) (bool, error) {
+	return c.fieldOptionBool(field, proprdbpb.E_External)
+}
+
+func (c modelCollector) fieldOptionBool(field *protogen.Field, extension protoreflect.ExtensionType) (bool, error) {
+	fieldOptions, ok := field.Desc.Options().(*descriptorpb.FieldOptions)
+	if !ok || fieldOptions == nil {
+		return false, nil
+	}
+
+	if !proto.HasExtension(fieldOptions, extension) {
+		return false, nil
+	}
+
+	value := proto.GetExtension(fieldOptions, extension)
+
+	switch enabled := value.(type) {
+	case bool:
+		return enabled, nil
+	case *bool:
+		if enabled == nil {
+			return false, nil
+		}
+
+		return *enabled, nil
+	default:
+		return false, fmt.Errorf("unexpected %s type %T", extension.TypeDescriptor().FullName(), value)
+	}
+}
+
+func (c modelCollector) projectedFieldFromProto(field *protogen.Field) (projectedField, error) {
+	if field.Desc.IsList() || field.Desc.IsMap() {
+		return projectedField{}, errors.New("external field must be scalar")
+	}
+	timestampFormat, err := c.fieldTimestampFormat(field)
+	if err != nil {
+		return projectedField{}, err
+	}
+	if field.Message != nil && field.Message.Desc.FullName() == timestampFullName {
+		return timestampProjectedField(field, timestampFormat), nil
+	}
+	if timestampFormat != proprdbpb.TimestampFormat_TIMESTAMP_FORMAT_UNIX_NANOS {
+		return projectedField{}, errors.New("timestamp_format requires a google.protobuf.Timestamp field")
+	}
+
+	projection := projectedField{
+		ColumnName:      string(field.Desc.Name()),
+		ProtoFieldName:  string(field.Desc.Name()),
+		GetterName:      "Get" + field.GoName,
+		GoName:          field.GoName,
+		SchemaSignature: fmt.Sprintf("%s:%s", field.Desc.Name(), field.Desc.Kind()),
+		IsOptional:      field.Desc.HasOptionalKeyword(),
+	}
+	if projection.IsOptional {
+		projection.SchemaSignature += projectionOptionalFlag
+	}
+
+	switch field.Desc.Kind() {
+	case protoreflect.BoolKind:
+		projection.SQLiteType, projection.SQLiteDefault, projection.GoType = "INTEGER", "0", "bool"
+	case protoreflect.Int32Kind,
+		protoreflect.Sint32Kind,
+		protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind,
+		protoreflect.Sint64Kind,
+		protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind,
+		protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind,
+		protoreflect.Fixed64Kind,
+		protoreflect.EnumKind:
+		projection.SQLiteType, projection.SQLiteDefault, projection.GoType = "INTEGER", "0", "int64"
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		projection.SQLiteType, projection.SQLiteDefault, projection.GoType = "REAL", "0", "float64"
+	case protoreflect.StringKind:
+		projection.SQLiteType, projection.SQLiteDefault, projection.GoType = "TEXT", "''", "string"
+	case protoreflect.BytesKind:
+		projection.SQLiteType, projection.SQLiteDefault, projection.GoType = "BLOB", "X''", "[]byte"
+	default:
+		return projectedField{}, fmt.Errorf("unsupported external field kind %s", field.Desc.Kind())
+	}
+	return projection, nil
+}
+
+// mapProjectionFromProto supports map<string, string> and map<string, int64>
+// fields.
+func (c modelCollector) mapProjectionFromProto(tableName string, field *protogen.Field) (mapProjection, error) {
+	if field.Desc.MapKey().Kind() != protoreflect.StringKind {
+		return mapProjection{}, fmt.Errorf("external map field key must be string, got %s", field.Desc.MapKey().Kind())
+	}
+	projection := mapProjection{
+		ProtoFieldName:  string(field.Desc.Name()),
+		GoName:          field.GoName,
+		GetterName:      "Get" + field.GoName,
+		SideTableName:   tableName + "__" + string(field.Desc.Name()),
+		SchemaSignature: fmt.Sprintf("%s:map<string,%s>", field.Desc.Name(), field.Desc.MapValue().Kind()),
+	}
+	switch field.Desc.MapValue().Kind() {
+	case protoreflect.StringKind:
+		projection.ValueGoType = "string"
+		projection.ValueSQLiteType = "TEXT"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		projection.ValueGoType = "int64"
+		projection.ValueSQLiteType = "INTEGER"
+	default:
+		return mapProjection{}, fmt.Errorf("external map field value must be string or int64, got %s", field.Desc.MapValue().Kind())
+	}
+	return projection, nil
+}
+
+// valueExpr is the Go expression of the column value of dataName.
+func (f projectedField) valueExpr(dataName string) string {
+	value := dataName + "." + f.GetterName + "()"
+	if f.ValueFunc != "" {
+		return f.ValueFunc + "(" + value + ")"
+	}
+	return value
+}
+
+func (f projectedField) createColumnSQL() string {
+	columnSQL := fmt.Sprintf(`"%s" %s NOT NULL DEFAULT %s`, f.ColumnName, f.SQLiteType, f.SQLiteDefault)
+	if f.IsOptional {
+		columnSQL = fmt.Sprintf(`"%s" %s`, f.ColumnName, f.SQLiteType)
+	}
+	if f.Check != "" {
+		columnSQL += " CHECK (" + f.Check + ")"
+	}
+	return columnSQL
+}
+
+// messageSelected reports whether message gets a table: messages listed in
+// Options.Only when it is set, else those (proprdb.skip) or the
+// (proprdb.default_generate) of the file select. (proprdb.skip)=true always
+// excludes.
+func (c modelCollector) messageSelected(message *protogen.Message) (bool, error) {
+	skip, hasSkip := false, false
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if ok && messageOptions != nil && proto.HasExtension(messageOptions, proprdbpb.E_Skip) {
+		value := proto.GetExtension(messageOptions, proprdbpb.E_Skip)
+		if skip, ok = value.(bool); !ok {
+			return false, fmt.Errorf("unexpected com.github.fingon.proprdb.skip type %T", value)
+		}
+		hasSkip = true
+	}
+	switch {
+	case skip:
+		return false, nil
+	case c.only != nil:
+		return c.only[string(message.Desc.Name())] || c.only[string(message.Desc.FullName())], nil
+	case hasSkip:
+		return true, nil
+	}
+	return c.defaultGenerate, nil
+}
+
+// tableNameForMessage returns the (proprdb.table_name) of message, or else
+// its lower-cased full name with dots replaced by underscores.
+func (c modelCollector) tableNameForMessage(message *protogen.Message) (string, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if ok && messageOptions != nil && proto.HasExtension(messageOptions, proprdbpb.E_TableName) {
+		value := proto.GetExtension(messageOptions, proprdbpb.E_TableName)
+		tableName, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("unexpected com.github.fingon.proprdb.table_name type %T", value)
+		}
+		if err := validateSQLName(tableName); err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(tableName, "_") {
+			return "", fmt.Errorf("table name %q must not start with an underscore, which core tables use", tableName)
+		}
+		return tableName, nil
+	}
+	fullName := strings.ReplaceAll(string(message.Desc.FullName()), ".", "_")
+	return strings.ToLower(fullName), nil
+}
+
+// validateSQLName accepts the plain identifiers that need no quoting in
+// where clauses.
+func validateSQLName(name string) error {
+	if name == "" {
+		return errors.New("name must not be empty")
+	}
+	for position := range len(name) {
+		character := name[position]
+		if !isIdentifierStart(character) && (position == 0 || character < '0' || character > '9') {
+			return fmt.Errorf("name %q must match [A-Za-z_][A-Za-z0-9_]*", name)
+		}
+	}
+	return nil
+}
+
+// validateColumnName accepts (proprdb.column_name) values that do not
+// collide with the columns every table has.
+func validateColumnName(name string) error {
+	if err := validateSQLName(name); err != nil {
+		return err
+	}
+	switch name {
+	case "id", "at_ns", "data", proprdbrt.VersionVectorColumn, "deleted_at_ns":
+		return fmt.Errorf("column name %q is reserved", name)
+	}
+	return nil
+}
+
+func (c modelCollector) generatedIndexName(tableName string, columnNames []string) string {
+	prefix := "idx_" + sanitizeSQLName(tableName) + "__"
+	sanitizedColumns := make([]string, 0, len(columnNames))
+	for _, columnName := range columnNames {
+		sanitizedColumns = append(sanitizedColumns, sanitizeSQLName(columnName))
+	}
+	return prefix + strings.Join(sanitizedColumns, "_")
+}
+
+func sanitizeSQLName(value string) string {
+	builder := strings.Builder{}
+	lastUnderscore := false
+	for _, character := range strings.ToLower(value) {
+		isLower := character >= 'a' && character <= 'z'
+		isDigit := character >= '0' && character <= '9'
+		if isLower || isDigit {
+			builder.WriteRune(character)
+			lastUnderscore = false
+			continue
+		}
+		if lastUnderscore {
+			continue
+		}
+		builder.WriteByte('_')
+		lastUnderscore = true
+	}
+	result := strings.Trim(builder.String(), "_")
+	if result == "" {
+		return "idx"
+	}
+	return result
+}
+
+func (m messageModel) hasOptionalProjectedFields() bool {
+	for _, projectedField := range m.ProjectedFields {
+		if projectedField.IsOptional {
+			return true
+		}
+	}
+	return false
+}
+
+func (m messageModel) conflictStrategyExpr() string {
+	switch m.ConflictStrategy {
+	case proprdbpb.ConflictStrategy_CONFLICT_STRATEGY_REMOTE_WINS:
+		return "rt.ConflictRemoteWins"
+	case proprdbpb.ConflictStrategy_CONFLICT_STRATEGY_LOCAL_WINS:
+		return "rt.ConflictLocalWins"
+	case proprdbpb.ConflictStrategy_CONFLICT_STRATEGY_MERGE:
+		return "rt.ConflictMerge"
+	default:
+		return "rt.ConflictLastWriterWins"
+	}
+}
+
+func (m messageModel) syncFiltersExpr() string {
+	if len(m.SyncFilters) == 0 {
+		return "nil"
+	}
+	return m.GoName + "SyncFilters"
+}
+
+func (m messageModel) fieldMergesExpr() string {
+	if len(m.FieldMerges) == 0 {
+		return "nil"
+	}
+	return m.GoName + "FieldMerges"
+}
+
+// hasProjections reports whether the table has projected columns or side
+// tables that reproject must rebuild.
+// bulkImportable reports whether CRUD.ReadJSONLBulk can write the rows of m
+// with multi-row statements. Version vectors, soft deletes and map side
+// tables need per-row writes.
+func (m messageModel) bulkImportable() bool {
+	return !m.OmitSync && !m.VersionVector && !m.SoftDelete && !m.History && len(m.MapProjections) == 0
+}
+
+// historyTableName is the history table of (proprdb.history) messages.
+func (m messageModel) historyTableName() string {
+	return m.TableName + proprdbrt.HistoryTableSuffix
+}
+
+func (m messageModel) hasProjections() bool {
+	return len(m.ProjectedFields) > 0 || len(m.MapProjections) > 0
+}
+
+func (m messageModel) hasTimestampProjectedFields() bool {
+	for _, projectedField := range m.ProjectedFields {
+		if projectedField.Timestamp {
+			return true
+		}
+	}
+	return false
+}
+
+// plainProjectedFields returns the projected fields stored in the clear.
+func (m messageModel) plainProjectedFields() []projectedField {
+	fields := make([]projectedField, 0, len(m.ProjectedFields))
+	for _, projectedField := range m.ProjectedFields {
+		if !projectedField.Encrypted {
+			fields = append(fields, projectedField)
+		}
+	}
+	return fields
+}
+
+func (m messageModel) hasEncryptedProjectedFields() bool {
+	for _, projectedField := range m.ProjectedFields {
+		if projectedField.Encrypted {
+			return true
+		}
+	}
+	return false
+}
+
+func (e generatorEmitter) emitShared() {
+	g := e.g
+	g.P("type DBTX = rt.DBTX")
+	g.P("type proprdbJSONLRecord = rt.JSONLRecord")
+	g.P()
+}
+
+func (e generatorEmitter) emitProjectedFieldAppend(argsName, dataName string, projectedField projectedField, indent, errReturnPrefix string) {
+	g := e.g
+	if projectedField.Encrypted {
+		e.emitEncryptedProjectedFieldAppend(argsName, dataName, projectedField, indent, errReturnPrefix)
+		return
+	}
+	if projectedField.Path != "" {
+		g.P(indent, argsName, " = append(", argsName, ", rt.PathValue(", dataName, ", ", strconv.Quote(projectedField.Path), "))")
+		return
+	}
+	if !projectedField.IsOptional {
+		g.P(indent, argsName, " = append(", argsName, ", ", projectedField.valueExpr(dataName), ")")
+		return
+	}
+	fieldDescriptorVar := "fieldDescriptor" + projectedField.GetterName
+	g.P(indent, fieldDescriptorVar, " := ", dataName, `.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name("`, projectedField.ProtoFieldName, `"))`)
+	g.P(indent, "if ", fieldDescriptorVar, " != nil && ", dataName, ".ProtoReflect().Has(", fieldDescriptorVar, ") {")
+	g.P(indent, "\t", argsName, " = append(", argsName, ", ", projectedField.valueExpr(dataName), ")")
+	g.P(indent, "} else {")
+	g.P(indent, "\t", argsName, " = append(", argsName, ", nil)")
+	g.P(indent, "}")
+}
+
+func (e generatorEmitter) emitEncryptedProjectedFieldAppend(argsName, dataName string, projectedField projectedField, indent, errReturnPrefix string) {
+	g := e.g
+	valueVar := "value" + projectedField.GetterName
+	encryptedVar := "encrypted" + projectedField.GetterName
+	if projectedField.IsOptional {
+		fieldDescriptorVar := "fieldDescriptor" + projectedField.GetterName
+		g.P(indent, "var ", valueVar, " any")
+		g.P(indent, fieldDescriptorVar, " := ", dataName, `.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name("`, projectedField.ProtoFieldName, `"))`)
+		g.P(indent, "if ", fieldDescriptorVar, " != nil && ", dataName, ".ProtoReflect().Has(", fieldDescriptorVar, ") {")
+		g.P(indent, "\t", valueVar, " = ", projectedField.valueExpr(dataName))
+		g.P(indent, "}")
+		g.P(indent, encryptedVar, ", err := rt.EncryptColumnValue(t.opts, ", valueVar, ")")
+	} else {
+		g.P(indent, encryptedVar, ", err := rt.EncryptColumnValue(t.opts, ", projectedField.valueExpr(dataName), ")")
+	}
+	g.P(indent, "if err != nil {")
+	g.P(indent, "\treturn ", errReturnPrefix, "fmt.Errorf(\"encrypt projection column ", projectedField.ColumnName, ": %w\", err)")
+	g.P(indent, "}")
+	g.P(indent, argsName, " = append(", argsName, ", ", encryptedVar, ")")
+}
+
+// emitMapProjectionWrites replaces the side table entries of row id with
+// the map fields of dataName.
+func (e generatorEmitter) emitMapProjectionWrites(model messageModel, dataName, indent, errReturnPrefix string) {
+	for _, projection := range model.MapProjections {
+		e.g.P(indent, "if err := rt.ReplaceMapEntries(t.q, ", model.GoName, projection.GoName, "TableName, id, ", dataName, ".", projection.GetterName, "()); err != nil {")
+		e.g.P(indent, "\treturn ", errReturnPrefix, "err")
+		e.g.P(indent, "}")
+	}
+}
+
+func (e generatorEmitter) emitModel(model messageModel) {
+	g := e.g
+	tableNameConst := model.GoName + "TableName"
+	typeNameConst := model.GoName + "TypeName"
+	schemaConst := model.GoName + "ProjectionSchema"
+	createTableConst := model.GoName + "CreateTableSQL"
+	insertConst := model.GoName + "InsertSQL"
+	upsertConst := model.GoName + "UpsertSQL"
+	reprojectConst := model.GoName + "ReprojectSQL"
+	indexPrefixConst := model.GoName + "GeneratedIndexPrefix"
+	indexCreateConstPrefix := model.GoName + "CreateIndexSQL"
+
+	g.P("const ", tableNameConst, " = ", strconv.Quote(model.TableName))
+	g.P("const ", typeNameConst, " = ", strconv.Quote(model.TypeName))
+	g.P("const ", schemaConst, " = ", strconv.Quote(model.ProjectionSchema))
+	g.P("const ", createTableConst, " = ", strconv.Quote(model.createTableSQL()))
+	g.P("const ", insertConst, " = ", strconv.Quote(model.insertSQL(false)))
+	g.P("const ", upsertConst, " = ", strconv.Quote(model.insertSQL(true)))
+	g.P("const ", indexPrefixConst, " = ", strconv.Quote(model.generatedIndexPrefix()))
+	g.P("const ", model.GoName, "ConflictStrategy = ", model.conflictStrategyExpr())
+	if model.TTLSeconds > 0 {
+		g.P("const ", model.GoName, "TTLSeconds = ", strconv.FormatInt(model.TTLSeconds, 10))
+	}
+	if model.TenantColumn != "" {
+		g.P("const ", model.GoName, "TenantColumn = ", strconv.Quote(model.TenantColumn))
+	}
+	if model.History {
+		g.P("const ", model.GoName, "HistoryTableName = ", strconv.Quote(model.historyTableName()))
+	}
+	g.P()
+	g.P("// ", model.GoName, "SortColumns lists the columns SelectWithOptions can order by.")
+	g.P("var ", model.GoName, "SortColumns = []string{", quotedList(model.sortColumns()), "}")
+	if len(model.Migrations) > 0 {
+		g.P()
+		g.P("// ", model.GoName, "Migrations holds the (proprdb.migration) options, applied to")
+		g.P("// the stored rows when the schema changes.")
+		g.P("var ", model.GoName, "Migrations = []rt.FieldMigration{")
+		for _, migration := range model.Migrations {
+			if migration.CopyFrom != "" {
+				g.P("\t{Field: ", strconv.Quote(migration.Field), ", CopyFrom: ", strconv.Quote(migration.CopyFrom), "},")
+			} else {
+				g.P("\t{Field: ", strconv.Quote(migration.Field), ", DefaultJSON: ", strconv.Quote(migration.DefaultJSON), "},")
+			}
+		}
+		g.P("}")
+	}
+	if len(model.SyncFilters) > 0 {
+		g.P()
+		g.P("// ", model.GoName, "SyncFilters holds (proprdb.sync_filters) conditions by remote.")
+		g.P("var ", model.GoName, "SyncFilters = map[string]string{")
+		for _, filter := range model.SyncFilters {
+			g.P("\t", strconv.Quote(filter.Remote), ": ", strconv.Quote(filter.Where), ",")
+		}
+		g.P("}")
+	}
+	if len(model.FieldMerges) > 0 {
+		g.P()
+		g.P("// ", model.GoName, "FieldMerges lists fields merged by (proprdb.merge) on conflicts.")
+		g.P("var ", model.GoName, "FieldMerges = map[string]rt.FieldMerge{")
+		for _, merge := range model.FieldMerges {
+			g.P("\t", strconv.Quote(merge.ProtoFieldName), ": ", runtimeFieldMergeNames[merge.Merge], ",")
+		}
+		g.P("}")
+	}
+	if len(model.FieldRules) > 0 {
+		g.P()
+		g.P("// ", model.GoName, "FieldRules lists the declarative field rules checked on writes.")
+		g.P("var ", model.GoName, "FieldRules = []rt.FieldRule{")
+		for _, rule := range model.FieldRules {
+			g.P("\t{", rule.literal(), "},")
+		}
+		g.P("}")
+	}
+	for indexPosition, indexModel := range model.Indexes {
+		g.P("const ", indexCreateConstPrefix, strconv.Itoa(indexPosition+1), " = ", strconv.Quote(model.createIndexSQL(indexModel)))
+	}
+	if len(model.ProjectedFields) > 0 {
+		g.P("const ", reprojectConst, " = ", strconv.Quote(model.reprojectSQL()))
+	}
+	g.P()
+	for _, projection := range model.MapProjections {
+		sideTableConst := model.GoName + projection.GoName + "TableName"
+		g.P("// ", sideTableConst, " holds the entries of the projected ", projection.GoName, " map.")
+		g.P("const ", sideTableConst, " = ", strconv.Quote(projection.SideTableName))
+		g.P()
+		g.P("// ", model.GoName, "Where", projection.GoName, " returns a Select condition matching rows whose")
+		g.P("// ", projection.GoName, " map has key set to one of values, or set at all if values is empty.")
+		g.P("func ", model.GoName, "Where", projection.GoName, "(key string, values ...", projection.ValueGoType, ") (string, []any) {")
+		g.P("\treturn rt.MapEntryWhere(", sideTableConst, ", key, values...)")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("type ", model.RowTypeName, " struct {")
+	g.P("\tID string")
+	g.P("\tAtNs int64")
+	g.P("\tData *", model.GoName)
+	if model.SoftDelete {
+		g.P("\t// DeletedAtNs is the soft deletion time, 0 for live rows.")
+		g.P("\tDeletedAtNs int64")
+	}
+	g.P("}")
+	g.P()
+	g.P("// Revision returns the revision token of the row, for UpdateByIDIfRevision")
+	g.P("// and DeleteByIDIfRevision.")
+	g.P("func (r ", model.RowTypeName, ") Revision() string {")
+	g.P("\treturn rt.Revision(r.AtNs, r.Data)")
+	g.P("}")
+	g.P()
+	g.P("// ", model.GoName, "LazyRow is a ", model.RowTypeName, " whose data is decoded when Data is")
+	g.P("// first called, as returned by SelectLazy.")
+	g.P("type ", model.GoName, "LazyRow struct {")
+	g.P("\tID string")
+	g.P("\tAtNs int64")
+	if model.SoftDelete {
+		g.P("\t// DeletedAtNs is the soft deletion time, 0 for live rows.")
+		g.P("\tDeletedAtNs int64")
+	}
+	g.P("\t*rt.LazyData[*", model.GoName, "]")
+	g.P("}")
+	g.P()
+
+	g.P("// ", model.GoName, "Store is the data access API of ", model.TableTypeName, ", for code that")
+	g.P("// should not depend on SQLite. Schema maintenance stays on the table.")
+	g.P("type ", model.GoName, "Store interface {")
+	for _, method := range model.storeMethods() {
+		g.P("\t", method)
+	}
+	g.P("}")
+	g.P()
+	g.P("var _ ", model.GoName, "Store = (*", model.TableTypeName, ")(nil)")
+	g.P()
+
+	g.P("type ", model.TableTypeName, " struct {")
+	g.P("\tq      DBTX")
+	g.P("\treader DBTX")
+	g.P("\topts   rt.Options")
+	g.P("\tcache  *rt.RowCache[", model.RowTypeName, "]")
+	if model.TenantColumn != "" {
+		g.P("\ttenant rt.TenantScope")
+	}
+	g.P("\tschemaHooks []rt.SchemaChangeFunc")
+	g.P("}")
+	g.P()
+
+	g.P("func New", model.TableTypeName, "(q DBTX) *", model.TableTypeName, " {")
+	g.P("\treturn New", model.TableTypeName, "WithOptions(q, rt.Options{})")
+	g.P("}")
+	g.P()
+
+	g.P("func New", model.TableTypeName, "WithOptions(q DBTX, opts rt.Options) *", model.TableTypeName, " {")
+	e.emitIDFormatDefault(model)
+	if model.Compression == proprdbpb.Compression_COMPRESSION_GZIP {
+		g.P("\tif opts.DataCodec == nil {")
+		g.P("\t\topts.DataCodec = rt.GzipDataCodec{}")
+		g.P("\t}")
+	}
+	g.P("\treturn &", model.TableTypeName, "{")
+	g.P("\t\tq:      rt.ObserveDBTX(q, opts.EffectiveQueryObserver()),")
+	g.P("\t\treader: rt.ObserveDBTX(opts.ReadDBTX(q), opts.EffectiveQueryObserver()),")
+	g.P("\t\topts:   opts,")
+	g.P("\t\tcache:  rt.NewRowCache[", model.RowTypeName, "](opts.Cache),")
+	g.P("\t}")
+	g.P("}")
+	g.P()
+
+	if model.TenantColumn != "" {
+		e.emitTenantMethods(model)
+	}
+	e.emitInitMethod(model, tableNameConst, typeNameConst, schemaConst, createTableConst, indexPrefixConst, indexCreateConstPrefix)
+	e.emitSelectMethod(model, tableNameConst)
+	e.emitExplainMethod(model, tableNameConst)
+	e.emitSelectByFieldsMethod(model, tableNameConst)
+	e.emitSelectFilterMethod(model)
+	e.emitSelectProjectedMethod(model, tableNameConst)
+	e.emitSelectLazyMethod(model, tableNameConst)
+	e.emitGetByIDMethod(model)
+	e.emitTimestampRangeMethods(model)
+	if model.History {
+		e.emitHistoryMethods(model)
+	}
+	e.emitInsertMethod(model, tableNameConst, insertConst)
+	e.emitUpdateMethod(model, tableNameConst, upsertConst)
+	e.emitDeleteMethod(model, tableNameConst)
+	e.emitMutateWhereMethods(model, tableNameConst)
+	e.emitRevisionMethods(model, tableNameConst)
+	e.emitApplyWithAtNsMethods(model, tableNameConst, upsertConst)
+	e.emitSchemaHookMethods(model, tableNameConst)
+	if model.hasProjections() {
+		e.emitReprojectMethod(model, tableNameConst, reprojectConst)
+	}
+	if model.TTLSeconds > 0 {
+		e.emitExpireStaleMethod(model, tableNameConst)
+	}
+	if len(model.SearchFields) > 0 {
+		e.emitSearchMethods(model, tableNameConst)
+	}
+	if model.VectorColumn != "" {
+		e.emitNearestNeighborsMethod(model, tableNameConst)
+	}
+	if model.GeoLatColumn != "" {
+		e.emitGeoMethods(model, tableNameConst)
+	}
+	e.emitRotateEncryptionMethod(model, tableNameConst)
+	e.emitDrainUnknownMethod(model, typeNameConst)
+	e.emitPlanInitMethod(model, tableNameConst, typeNameConst, schemaConst, indexPrefixConst)
+}
+
+// emitTenantMethods emits the tenant scoping of a (proprdb.tenant_field) table.
+func (e generatorEmitter) emitTenantMethods(model messageModel) {
+	g := e.g
+	g.P("// ForTenant returns a view of the table whose reads only see rows of tenant,")
+	g.P("// and whose writes fail with rt.ErrTenantMismatch for rows of other tenants.")
+	g.P("func (t *", model.TableTypeName, ") ForTenant(tenant string) *", model.TableTypeName, " {")
+	g.P("\tscoped := *t")
+	g.P("\tscoped.tenant = rt.TenantScope{Tenant: tenant, Bound: true}")
+	g.P("\treturn &scoped")
+	g.P("}")
+	g.P()
+	g.P("// allTenants returns a view exempt from Options.RequireTenant, for sync and")
+	g.P("// snapshots.")
+	g.P("func (t *", model.TableTypeName, ") allTenants() *", model.TableTypeName, " {")
+	g.P("\tscoped := *t")
+	g.P("\tscoped.tenant = rt.TenantScope{All: true}")
+	g.P("\treturn &scoped")
+	g.P("}")
+	g.P()
+}
+
+// emitTenantWriteCheck rejects writes through a ForTenant view touching rows
+// of other tenants.
+func (e generatorEmitter) emitTenantWriteCheck(model messageModel, tableNameConst string) {
+	if model.TenantColumn == "" {
+		return
+	}
+	g := e.g
+	g.P("\tif err := t.tenant.CheckData(data.", model.TenantGetter, "()); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, err")
+	g.P("\t}")
+	g.P("\tif err := t.tenant.CheckOwner(t.q, ", tableNameConst, ", ", model.GoName, "TenantColumn, id); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, err")
+	g.P("\t}")
+}
+
+// emitPlanInitMethod emits the schema Init ensures and PlanInit reporting
+// what Init would change.
+func (e generatorEmitter) emitPlanInitMethod(model messageModel, tableNameConst, typeNameConst, schemaConst, indexPrefixConst string) {
+	g := e.g
+	g.P("// ", model.GoName, "TableSchema describes what ", model.TableTypeName, ".Init ensures.")
+	g.P("var ", model.GoName, "TableSchema = rt.TableSchema{")
+	g.P("\tTableName:        ", tableNameConst, ",")
+	g.P("\tTypeName:         ", typeNameConst, ",")
+	g.P("\tProjectionSchema: ", schemaConst, ",")
+	g.P("\tColumns: []string{")
+	if model.VersionVector {
+		g.P("\t\trt.VersionVectorColumn,")
+	}
+	if model.SoftDelete {
+		g.P("\t\t\"deleted_at_ns\",")
+	}
+	if model.TrackTimestamps {
+		g.P("\t\t", strconv.Quote(createdAtNsColumn), ",")
+		g.P("\t\t", strconv.Quote(updatedAtNsColumn), ",")
+	}
+	for _, projectedField := range model.ProjectedFields {
+		g.P("\t\t", strconv.Quote(projectedField.ColumnName), ",")
+	}
+	g.P("\t},")
+	if len(model.MapProjections) > 0 {
+		g.P("\tMapTables: []string{")
+		for _, projection := range model.MapProjections {
+			g.P("\t\t", model.GoName, projection.GoName, "TableName,")
+		}
+		g.P("\t},")
+	}
+	if model.History {
+		g.P("\tHistoryTable: ", model.GoName, "HistoryTableName,")
+	}
+	g.P("\tIndexPrefix: ", indexPrefixConst, ",")
+	g.P("\tIndexes: []string{")
+	for _, indexModel := range model.Indexes {
+		g.P("\t\t", strconv.Quote(indexModel.IndexName), ",")
+	}
+	g.P("\t},")
+	g.P("\tHasProjections: ", strconv.FormatBool(model.hasProjections()), ",")
+	g.P("}")
+	g.P()
+	g.P("// PlanInit reports what Init would change without changing anything.")
+	g.P("func (t *", model.TableTypeName, ") PlanInit() (rt.TablePlan, error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn rt.TablePlan{}, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\treturn rt.PlanTableInit(t.q, ", model.GoName, "TableSchema)")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitInitMethod(model messageModel, tableNameConst, typeNameConst, schemaConst, createTableConst, indexPrefixConst, indexCreateConstPrefix string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") Init() error {")
+	g.P("\treturn t.initTable(nil)")
+	g.P("}")
+	g.P()
+	g.P("// initTable is Init holding schemaLock while it writes _proprdb_schema.")
+	g.P("func (t *", model.TableTypeName, ") initTable(schemaLock *rt.SchemaStateLock) (err error) {")
+	g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationInit, ", tableNameConst, ")(&err)")
+	g.P("\tdefer t.cache.Purge()")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif err := rt.EnsureCoreTables(t.q); err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tctx := context.Background()")
+	g.P("\tif _, err := t.q.ExecContext(ctx, ", createTableConst, "); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"create table %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	if len(model.SearchFields) > 0 {
+		g.P("\tif t.opts.ChangeFeed || t.opts.SearchIndex != nil {")
+	} else {
+		g.P("\tif t.opts.ChangeFeed {")
+	}
+	g.P("\t\tif err := rt.EnableChangeFeed(t.q, ", tableNameConst, "); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t}")
+
+	if len(model.ProjectedFields) > 0 || model.VersionVector || model.SoftDelete || model.TrackTimestamps {
+		g.P("\tcolumnRows, err := t.q.QueryContext(ctx, `PRAGMA table_info(\"`+", tableNameConst, "+`\")`)")
+		g.P("\tif err != nil {")
+		g.P("\t\treturn fmt.Errorf(\"read columns for %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t}")
+		g.P("\texistingColumns := make(map[string]bool)")
+		g.P("\tfor columnRows.Next() {")
+		g.P("\t\tvar cid int")
+		g.P("\t\tvar name string")
+		g.P("\t\tvar colType string")
+		g.P("\t\tvar notNull int")
+		g.P("\t\tvar defaultValue any")
+		g.P("\t\tvar pk int")
+		g.P("\t\tif err := columnRows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {")
+		g.P("\t\t\tif closeErr := rt.CloseRows(columnRows, \"projection metadata\"); closeErr != nil {")
+		g.P("\t\t\t\treturn fmt.Errorf(\"scan pragma row: %w (additionally, %v)\", err, closeErr)")
+		g.P("\t\t\t}")
+		g.P("\t\t\treturn fmt.Errorf(\"scan pragma row: %w\", err)")
+		g.P("\t\t}")
+		g.P("\t\texistingColumns[name] = true")
+		g.P("\t}")
+		g.P("\tif err := columnRows.Err(); err != nil {")
+		g.P("\t\tif closeErr := rt.CloseRows(columnRows, \"projection metadata\"); closeErr != nil {")
+		g.P("\t\t\treturn fmt.Errorf(\"iterate pragma rows: %w (additionally, %v)\", err, closeErr)")
+		g.P("\t\t}")
+		g.P("\t\treturn fmt.Errorf(\"iterate pragma rows: %w\", err)")
+		g.P("\t}")
+		g.P("\tif err := rt.CloseRows(columnRows, \"projection metadata\"); err != nil {")
+		g.P("\t\treturn err")
+		g.P("\t}")
+		if model.VersionVector {
+			g.P("\tif !existingColumns[rt.VersionVectorColumn] {")
+			g.P("\t\tif _, err := t.q.ExecContext(ctx, `ALTER TABLE \"`+", tableNameConst, "+`\" ADD COLUMN ", versionVectorColumnSQL, "`); err != nil {")
+			g.P("\t\t\treturn fmt.Errorf(\"add version vector column to %s: %w\", ", tableNameConst, ", err)")
+			g.P("\t\t}")
+			g.P("\t}")
+		}
+		if model.SoftDelete {
+			g.P("\tif !existingColumns[\"deleted_at_ns\"] {")
+			g.P("\t\tif _, err := t.q.ExecContext(ctx, `ALTER TABLE \"`+", tableNameConst, "+`\" ADD COLUMN ", deletedAtNsColumnSQL, "`); err != nil {")
+			g.P("\t\t\treturn fmt.Errorf(\"add soft delete column to %s: %w\", ", tableNameConst, ", err)")
+			g.P("\t\t}")
+			g.P("\t}")
+		}
+		if model.TrackTimestamps {
+			for _, columnName := range []string{createdAtNsColumn, updatedAtNsColumn} {
+				g.P("\tif !existingColumns[", strconv.Quote(columnName), "] {")
+				g.P("\t\tif _, err := t.q.ExecContext(ctx, `ALTER TABLE \"`+", tableNameConst, "+`\" ADD COLUMN ", timestampColumnSQL(columnName), "`); err != nil {")
+				g.P("\t\t\treturn fmt.Errorf(\"add timestamp column ", columnName, " to %s: %w\", ", tableNameConst, ", err)")
+				g.P("\t\t}")
+				g.P("\t\tif _, err := t.q.ExecContext(ctx, `UPDATE \"`+", tableNameConst, "+`\" SET \"", columnName, "\" = at_ns`); err != nil {")
+				g.P("\t\t\treturn fmt.Errorf(\"backfill timestamp column ", columnName, " in %s: %w\", ", tableNameConst, ", err)")
+				g.P("\t\t}")
+				g.P("\t}")
+			}
+		}
+		for _, projectedField := range model.ProjectedFields {
+			if projectedField.RenamedFrom == "" {
+				continue
+			}
+			g.P("\tif !existingColumns[", strconv.Quote(projectedField.ColumnName), "] && existingColumns[", strconv.Quote(projectedField.RenamedFrom), "] {")
+			g.P("\t\tif _, err := t.q.ExecContext(ctx, `ALTER TABLE \"`+", tableNameConst, "+`\" RENAME COLUMN \"", projectedField.RenamedFrom, "\" TO \"", projectedField.ColumnName, "\"`); err != nil {")
+			g.P("\t\t\treturn fmt.Errorf(\"rename projection column ", projectedField.RenamedFrom, " of %s: %w\", ", tableNameConst, ", err)")
+			g.P("\t\t}")
+			g.P("\t\texistingColumns[", strconv.Quote(projectedField.ColumnName), "] = true")
+			g.P("\t}")
+		}
+		for _, projectedField := range model.ProjectedFields {
+			g.P("\tif !existingColumns[", strconv.Quote(projectedField.ColumnName), "] {")
+			g.P("\t\tif _, err := t.q.ExecContext(ctx, `ALTER TABLE \"`+", tableNameConst, "+`\" ADD COLUMN ", projectedField.createColumnSQL(), "`); err != nil {")
+			g.P("\t\t\treturn fmt.Errorf(\"add projection column ", projectedField.ColumnName, " to %s: %w\", ", tableNameConst, ", err)")
+			g.P("\t\t}")
+			g.P("\t}")
+		}
+	}
+
+	for _, projection := range model.MapProjections {
+		g.P("\tif err := rt.EnsureMapProjectionTable(t.q, ", model.GoName, projection.GoName, "TableName, ", strconv.Quote(projection.ValueSQLiteType), "); err != nil {")
+		g.P("\t\treturn err")
+		g.P("\t}")
+	}
+	if model.History {
+		g.P("\tif err := rt.EnsureHistoryTable(t.q, ", model.GoName, "HistoryTableName); err != nil {")
+		g.P("\t\treturn err")
+		g.P("\t}")
+	}
+	g.P("\tif err := rt.EnsureManagedIndexes(t.q, ", tableNameConst, ", ", indexPrefixConst, ", []string{")
+	for indexPosition := range model.Indexes {
+		g.P("\t\t", indexCreateConstPrefix, strconv.Itoa(indexPosition+1), ",")
+	}
+	g.P("\t}, []string{")
+	for _, indexModel := range model.Indexes {
+		g.P("\t\t", strconv.Quote(indexModel.IndexName), ",")
+	}
+	g.P("\t}); err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+
+	g.P("\tif err := t.initSchemaHash(ctx, schemaLock); err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tif !t.opts.SyncPolicy.Switches.Enabled(", typeNameConst, ") {")
+	g.P("\t\t// Records received while syncing is paused stay parked.")
+	g.P("\t\treturn nil")
+	g.P("\t}")
+	g.P("\tif err := t.drainUnknownRows(", typeNameConst, "); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"drain unknown rows for %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\treturn nil")
+	g.P("}")
+	g.P()
+	g.P("// initSchemaHash records the projection schema of a new table, or runs")
+	g.P("// the schema hooks and reprojection of a changed one.")
+	g.P("func (t *", model.TableTypeName, ") initSchemaHash(ctx context.Context, schemaLock *rt.SchemaStateLock) error {")
+	g.P("\tschemaLock.Lock()")
+	g.P("\tdefer schemaLock.Unlock()")
+	g.P("\tvar currentSchema string")
+	g.P("\tschemaErr := t.q.QueryRowContext(ctx, `SELECT schema_hash FROM _proprdb_schema WHERE table_name = ?`, ", tableNameConst, ").Scan(&currentSchema)")
+	g.P("\tif errors.Is(schemaErr, sql.ErrNoRows) {")
+	g.P("\t\tif err := rt.StoreSchemaHash(t.q, ", tableNameConst, ", ", schemaConst, "); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t} else if schemaErr != nil {")
+	g.P("\t\treturn fmt.Errorf(\"select schema hash for %s: %w\", ", tableNameConst, ", schemaErr)")
+	if model.hasProjections() {
+		g.P("\t} else if currentSchema != ", schemaConst, " && !t.opts.DeferReprojection {")
+		g.P("\t\tif err := t.reproject(); err != nil {")
+		g.P("\t\t\treturn fmt.Errorf(\"reproject table %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t\t}")
+	} else {
+		g.P("\t} else if currentSchema != ", schemaConst, " {")
+		g.P("\t\tif err := rt.RunSchemaHooks(ctx, t.q, ", tableNameConst, ", ", schemaConst, ", ", model.schemaHooksExpr(), "); err != nil {")
+		g.P("\t\t\treturn err")
+		g.P("\t\t}")
+	}
+	g.P("\t\tif err := rt.StoreSchemaHash(t.q, ", tableNameConst, ", ", schemaConst, "); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\treturn nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitDrainUnknownMethod(model messageModel, typeNameConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") drainUnknownRows(typeName string) error {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif typeName == \"\" {")
+	g.P("\t\treturn errors.New(\"empty type name\")")
+	g.P("\t}")
+	g.P("\treturn rt.ReplayUnknownByType(t.q, typeName, func(record proprdbJSONLRecord) error {")
+	g.P("\t\tif record.Deleted {")
+	g.P("\t\t\treturn t.tombstoneWithAtNs(record.ID, record.AtNs)")
+	g.P("\t\t}")
+	g.P("\t\tanyMessage := &anypb.Any{}")
+	g.P("\t\tif err := protojson.Unmarshal(record.Data, anyMessage); err != nil {")
+	g.P("\t\t\treturn fmt.Errorf(\"unmarshal unknown data for ", model.GoName, " %s: %w\", record.ID, err)")
+	g.P("\t\t}")
+	g.P("\t\tdata := &", model.GoName, "{}")
+	g.P("\t\tif err := anypb.UnmarshalTo(anyMessage, data, proto.UnmarshalOptions{}); err != nil {")
+	g.P("\t\t\treturn fmt.Errorf(\"unmarshal unknown payload for ", model.GoName, " %s: %w\", record.ID, err)")
+	g.P("\t\t}")
+	e.emitRecordMigrations(model, "\t\t", "")
+	g.P("\t\treturn t.upsertWithAtNs(record.ID, record.AtNs, data)")
+	g.P("\t})")
+	g.P("}")
+	g.P()
+	g.P("func (t *", model.TableTypeName, ") DrainUnknownRows() error {")
+	g.P("\treturn t.drainUnknownRows(", typeNameConst, ")")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitSelectByFieldsMethod(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("// SelectByFields returns the rows whose fields match where, which compares")
+	g.P("// protobuf field paths such as \"address.zip\" with ? placeholders, joined by")
+	g.P("// AND. The fields need not be projected, but every row is decoded to check.")
+	g.P("func (t *", model.TableTypeName, ") SelectByFields(where string, args ...any) ([]", model.RowTypeName, ", error) {")
+	g.P("\tfilter, err := rt.ParseFieldFilter((&", model.GoName, "{}).ProtoReflect().Descriptor(), where, args)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\trt.RecordFieldScan(t.opts.Instrumentation, ", tableNameConst, ", where)")
+	g.P("\trows, err := t.Select(\"\")")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\tresult := make([]", model.RowTypeName, ", 0)")
+	g.P("\tfor _, row := range rows {")
+	g.P("\t\tmatched, err := filter.MatchMessage(row.Data)")
+	g.P("\t\tif err != nil {")
+	g.P("\t\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t\t}")
+	g.P("\t\tif matched {")
+	g.P("\t\t\tresult = append(result, row)")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\treturn result, nil")
+	g.P("}")
+	g.P()
+}
+
+// emitSelectFilterMethod emits <Msg>FilterColumns and SelectFilter, which
+// compiles AIP-160 filters to conditions over the projected columns.
+func (e generatorEmitter) emitSelectFilterMethod(model messageModel) {
+	g := e.g
+	g.P("// ", model.GoName, "FilterColumns are the projected columns SelectFilter and")
+	g.P("// rt.ParseAIPFilter may compare.")
+	g.P("var ", model.GoName, "FilterColumns = []rt.FilterColumn{")
+	for _, column := range model.filterColumns() {
+		fields := []string{"Field: " + strconv.Quote(column.Field), "Column: " + strconv.Quote(column.Column), "SQLiteType: " + strconv.Quote(column.SQLiteType)}
+		if column.Nullable {
+			fields = append(fields, "Nullable: true")
+		}
+		if column.Timestamp {
+			fields = append(fields, "Timestamp: true")
+		}
+		g.P("\t{", strings.Join(fields, ", "), "},")
+	}
+	g.P("}")
+	g.P()
+	g.P("// SelectFilter returns the rows matching filter, an AIP-160 filter such as")
+	g.P("// `age >= 18 AND has(address.city)` over ", model.GoName, "FilterColumns. See")
+	g.P("// rt.ParseAIPFilter for the supported subset.")
+	g.P("func (t *", model.TableTypeName, ") SelectFilter(filter string) ([]", model.RowTypeName, ", error) {")
+	g.P("\twhere, args, err := rt.ParseAIPFilter(filter, ", model.GoName, "FilterColumns)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\treturn t.Select(where, args...)")
+	g.P("}")
+	g.P()
+}
+
+// emitSelectProjectedMethod emits the <Msg>ProjectedRow struct and
+// SelectProjected, which reads the unencrypted projected columns without
+// decoding data.
+func (e generatorEmitter) emitSelectProjectedMethod(model messageModel, tableNameConst string) {
+	fields := model.plainProjectedFields()
+	if len(fields) == 0 {
+		return
+	}
+	g := e.g
+	rowTypeName := model.GoName + "ProjectedRow"
+	columns := []string{"id", "at_ns"}
+	scanTargets := []string{"&row.ID", "&row.AtNs"}
+	g.P("// ", rowTypeName, " holds the id, at_ns and unencrypted projected")
+	g.P("// columns of one ", model.GoName, " row, as returned by SelectProjected.")
+	g.P("// Optional fields are nil when unset.")
+	g.P("type ", rowTypeName, " struct {")
+	g.P("\tID   string")
+	g.P("\tAtNs int64")
+	for _, field := range fields {
+		goType := field.GoType
+		if field.IsOptional {
+			goType = "*" + goType
+		}
+		g.P("\t", field.GoName, " ", goType)
+		columns = append(columns, `"`+field.ColumnName+`"`)
+		if field.Timestamp {
+			scanTargets = append(scanTargets, "&raw"+field.GoName)
+		} else {
+			scanTargets = append(scanTargets, "&row."+field.GoName)
+		}
+	}
+	g.P("}")
+	g.P()
+	g.P("// SelectProjected is Select reading only id, at_ns and the projected")
+	g.P("// columns, for listings that do not need whole messages.")
+	g.P("func (t *", model.TableTypeName, ") SelectProjected(where string, args ...any) (_ []", rowTypeName, ", err error) {")
+	g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationSelect, ", tableNameConst, ")(&err)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\twhere, args, err = t.tenant.Where(", model.GoName, "TenantColumn, t.opts.RequireTenant, where, args)")
+		g.P("\tif err != nil {")
+		g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t}")
+	}
+	if model.SoftDelete {
+		g.P("\tquery := `SELECT ", strings.Join(columns, ", "), " FROM (SELECT * FROM \"`+", tableNameConst, "+`\" WHERE deleted_at_ns IS NULL) AS \"`+", tableNameConst, "+`\"`")
+	} else {
+		g.P("\tquery := `SELECT ", strings.Join(columns, ", "), " FROM \"`+", tableNameConst, "+`\"`")
+	}
+	g.P("\tif strings.TrimSpace(where) != \"\" {")
+	g.P("\t\tquery += \" WHERE \" + where")
+	g.P("\t}")
+	g.P("\trows, err := t.reader.QueryContext(context.Background(), query, args...)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\tresult := make([]", rowTypeName, ", 0)")
+	g.P("\tfor rows.Next() {")
+	g.P("\t\tvar row ", rowTypeName)
+	for _, field := range fields {
+		if field.Timestamp {
+			g.P("\t\tvar ", "raw"+field.GoName, " any")
+		}
+	}
+	g.P("\t\tif err := rows.Scan(", strings.Join(scanTargets, ", "), "); err != nil {")
+	g.P("\t\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
+	g.P("\t\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
+	g.P("\t\t\t}")
+	g.P("\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t\t}")
+	for _, field := range fields {
+		if !field.Timestamp {
+			continue
+		}
+		g.P("\t\tif row.", field.GoName, ", err = rt.ProjectedTime(raw", field.GoName, "); err != nil {")
+		g.P("\t\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
+		g.P("\t\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
+		g.P("\t\t\t}")
+		g.P("\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t\t}")
+	}
+	g.P("\t\tresult = append(result, row)")
+	g.P("\t}")
+	g.P("\tif err := rows.Err(); err != nil {")
+	g.P("\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
+	g.P("\t\t\treturn nil, fmt.Errorf(\"iterate rows from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
+	g.P("\t\t}")
+	g.P("\t\treturn nil, fmt.Errorf(\"iterate rows from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\tif err := rt.CloseRows(rows, \"select\"); err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\treturn result, nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitSelectLazyMethod(model messageModel, tableNameConst string) {
+	g := e.g
+	rowTypeName := model.GoName + "LazyRow"
+	g.P("// SelectLazy is Select keeping the data of each row undecoded until its")
+	g.P("// Data method is called.")
+	g.P("func (t *", model.TableTypeName, ") SelectLazy(where string, args ...any) ([]", rowTypeName, ", error) {")
+	g.P("\treturn t.SelectLazyWithOptions(rt.SelectOptions{}, where, args...)")
+	g.P("}")
+	g.P()
+	g.P("// SelectLazyWithOptions is SelectLazy sorting and paging rows per opts.")
+	g.P("func (t *", model.TableTypeName, ") SelectLazyWithOptions(opts rt.SelectOptions, where string, args ...any) (_ []", rowTypeName, ", err error) {")
+	g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationSelect, ", tableNameConst, ")(&err)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\twhere, args, err = t.tenant.Where(", model.GoName, "TenantColumn, t.opts.RequireTenant, where, args)")
+		g.P("\tif err != nil {")
+		g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t}")
+	}
+	g.P("\tclause, err := opts.Clause(", model.GoName, "SortColumns)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	if model.SoftDelete {
+		g.P("\tquery := `SELECT id, at_ns, data, deleted_at_ns FROM (SELECT * FROM \"`+", tableNameConst, "+`\" WHERE deleted_at_ns IS NULL) AS \"`+", tableNameConst, "+`\"`")
+	} else {
+		g.P("\tquery := `SELECT id, at_ns, data FROM \"`+", tableNameConst, "+`\"`")
+	}
+	g.P("\tif strings.TrimSpace(where) != \"\" {")
+	g.P("\t\tquery += \" WHERE \" + where")
+	g.P("\t}")
+	g.P("\tquery += clause")
+	g.P("\trows, err := t.reader.QueryContext(context.Background(), query, args...)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\tnewMessage := func() *", model.GoName, " { return &", model.GoName, "{} }")
+	g.P("\tresult := make([]", rowTypeName, ", 0)")
+	g.P("\tfor rows.Next() {")
+	g.P("\t\tvar row ", rowTypeName)
+	g.P("\t\tvar dataBytes []byte")
+	scanTargets := "&row.ID, &row.AtNs, &dataBytes"
+	if model.SoftDelete {
+		g.P("\t\tvar deletedAtNs sql.NullInt64")
+		scanTargets += ", &deletedAtNs"
+	}
+	g.P("\t\tif err := rows.Scan(", scanTargets, "); err != nil {")
+	g.P("\t\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
+	g.P("\t\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
+	g.P("\t\t\t}")
+	g.P("\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t\t}")
+	if model.SoftDelete {
+		g.P("\t\trow.DeletedAtNs = deletedAtNs.Int64")
+	}
+	g.P("\t\trow.LazyData = rt.NewLazyData(t.opts, dataBytes, newMessage)")
+	g.P("\t\tresult = append(result, row)")
+	g.P("\t}")
+	g.P("\tif err := rows.Err(); err != nil {")
+	g.P("\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
+	g.P("\t\t\treturn nil, fmt.Errorf(\"iterate rows from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
+	g.P("\t\t}")
+	g.P("\t\treturn nil, fmt.Errorf(\"iterate rows from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\tif err := rt.CloseRows(rows, \"select\"); err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\treturn result, nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitExplainMethod(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("// Explain returns the EXPLAIN QUERY PLAN of Select(where, args...), with")
+	g.P("// suggestions for (proprdb.indexes) when it scans the whole table.")
+	g.P("func (t *", model.TableTypeName, ") Explain(where string, args ...any) (rt.QueryPlan, error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn rt.QueryPlan{}, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\twhere, args, err := t.tenant.Where(", model.GoName, "TenantColumn, t.opts.RequireTenant, where, args)")
+		g.P("\tif err != nil {")
+		g.P("\t\treturn rt.QueryPlan{}, fmt.Errorf(\"explain select from %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t}")
+	}
+	if model.SoftDelete {
+		g.P("\tquery := `SELECT id, at_ns, data, deleted_at_ns FROM (SELECT * FROM \"`+", tableNameConst, "+`\" WHERE deleted_at_ns IS NULL) AS \"`+", tableNameConst, "+`\"`")
+	} else {
+		g.P("\tquery := `SELECT id, at_ns, data FROM \"`+", tableNameConst, "+`\"`")
+	}
+	g.P("\tif strings.TrimSpace(where) != \"\" {")
+	g.P("\t\tquery += \" WHERE \" + where")
+	g.P("\t}")
+	g.P("\treturn rt.ExplainQuery(t.reader, ", tableNameConst, ", ", model.GoName, "SortColumns, query, where, args...)")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitSelectMethod(model messageModel, tableNameConst string) {
+	g := e.g
+	if model.SoftDelete {
+		g.P("// Select returns the rows matching where, excluding soft-deleted rows.")
+		g.P("func (t *", model.TableTypeName, ") Select(where string, args ...any) ([]", model.RowTypeName, ", error) {")
+		g.P("\treturn t.SelectWithOptions(rt.SelectOptions{}, where, args...)")
+		g.P("}")
+		g.P()
+		g.P("// SelectWithOptions is Select sorting and paging rows per opts.")
+		g.P("func (t *", model.TableTypeName, ") SelectWithOptions(opts rt.SelectOptions, where string, args ...any) ([]", model.RowTypeName, ", error) {")
+		g.P("\treturn t.selectRows(`SELECT id, at_ns, data, deleted_at_ns FROM (SELECT * FROM \"`+", tableNameConst, "+`\" WHERE deleted_at_ns IS NULL) AS \"`+", tableNameConst, "+`\"`, opts, where, args...)")
+		g.P("}")
+		g.P()
+		g.P("// SelectIncludingDeleted returns the rows matching where, including soft-deleted rows.")
+		g.P("func (t *", model.TableTypeName, ") SelectIncludingDeleted(where string, args ...any) ([]", model.RowTypeName, ", error) {")
+		g.P("\treturn t.selectRows(`SELECT id, at_ns, data, deleted_at_ns FROM \"`+", tableNameConst, "+`\"`, rt.SelectOptions{}, where, args...)")
+		g.P("}")
+		g.P()
+		g.P("func (t *", model.TableTypeName, ") selectRows(query string, opts rt.SelectOptions, where string, args ...any) (_ []", model.RowTypeName, ", err error) {")
+	} else {
+		g.P("func (t *", model.TableTypeName, ") Select(where string, args ...any) ([]", model.RowTypeName, ", error) {")
+		g.P("\treturn t.SelectWithOptions(rt.SelectOptions{}, where, args...)")
+		g.P("}")
+		g.P()
+		g.P("// SelectWithOptions is Select sorting and paging rows per opts.")
+		g.P("func (t *", model.TableTypeName, ") SelectWithOptions(opts rt.SelectOptions, where string, args ...any) (_ []", model.RowTypeName, ", err error) {")
+	}
+	g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationSelect, ", tableNameConst, ")(&err)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\twhere, args, err = t.tenant.Where(", model.GoName, "TenantColumn, t.opts.RequireTenant, where, args)")
+		g.P("\tif err != nil {")
+		g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t}")
+	}
+	g.P("\tclause, err := opts.Clause(", model.GoName, "SortColumns)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\tctx := context.Background()")
+	if !model.SoftDelete {
+		g.P("\tquery := `SELECT id, at_ns, data FROM \"`+", tableNameConst, "+`\"`")
+	}
+	g.P("\tif strings.TrimSpace(where) != \"\" {")
+	g.P("\t\tquery += \" WHERE \" + where")
+	g.P("\t}")
+	g.P("\tquery += clause")
+	g.P("\trows, err := t.reader.QueryContext(ctx, query, args...)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\tresult := make([]", model.RowTypeName, ", 0)")
+	g.P("\tfor rows.Next() {")
+	g.P("\t\tvar id string")
+	g.P("\t\tvar atNs int64")
+	g.P("\t\tvar dataBytes []byte")
+	scanTargets := "&id, &atNs, &dataBytes"
+	if model.SoftDelete {
+		g.P("\t\tvar deletedAtNs sql.NullInt64")
+		scanTargets += ", &deletedAtNs"
+	}
+	g.P("\t\tif err := rows.Scan(", scanTargets, "); err != nil {")
+	g.P("\t\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
+	g.P("\t\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
+	g.P("\t\t\t}")
+	g.P("\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t\t}")
+	g.P("\t\tdata := &", model.GoName, "{}")
+	g.P("\t\tif err := rt.UnmarshalData(t.opts, dataBytes, data); err != nil {")
+	g.P("\t\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
+	g.P("\t\t\t\treturn nil, fmt.Errorf(\"unmarshal ", model.GoName, " row: %w (additionally, %v)\", err, closeErr)")
+	g.P("\t\t\t}")
+	g.P("\t\t\treturn nil, fmt.Errorf(\"unmarshal ", model.GoName, " row: %w\", err)")
+	g.P("\t\t}")
+	if model.SoftDelete {
+		g.P("\t\tresult = append(result, ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data, DeletedAtNs: deletedAtNs.Int64})")
+	} else {
+		g.P("\t\tresult = append(result, ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data})")
+	}
+	g.P("\t}")
+	g.P("\tif err := rows.Err(); err != nil {")
+	g.P("\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
+	g.P("\t\t\treturn nil, fmt.Errorf(\"iterate rows from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
+	g.P("\t\t}")
+	g.P("\t\treturn nil, fmt.Errorf(\"iterate rows from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\tif err := rt.CloseRows(rows, \"select\"); err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\treturn result, nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitGetByIDMethod(model messageModel) {
+	g := e.g
+	g.P("// GetByID returns the row of id, or an error wrapping rt.ErrNotFound. With")
+	g.P("// Options.Cache, rows are served from a read-through cache invalidated by")
+	g.P("// writes through this table.")
+	g.P("func (t *", model.TableTypeName, ") GetByID(id string) (*", model.RowTypeName, ", error) {")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn nil, rt.ErrEmptyID")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\tif err := t.tenant.Check(t.opts.RequireTenant); err != nil {")
+		g.P("\t\treturn nil, fmt.Errorf(\"get %s/%s: %w\", ", model.GoName, "TableName, id, err)")
+		g.P("\t}")
+	}
+	g.P("\trow, found, err := t.cache.GetOrLoad(id, func() (", model.RowTypeName, ", bool, error) {")
+	g.P("\t\trows, err := t.Select(`id = ?`, id)")
+	g.P("\t\tif err != nil || len(rows) == 0 {")
+	g.P("\t\t\treturn ", model.RowTypeName, "{}, false, err")
+	g.P("\t\t}")
+	g.P("\t\treturn rows[0], true, nil")
+	g.P("\t})")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\t// The cache is shared by all tenant views of the table.")
+		g.P("\tif !found || !t.tenant.Allows(row.Data.", model.TenantGetter, "()) {")
+	} else {
+		g.P("\tif !found {")
+	}
+	g.P("\t\treturn nil, fmt.Errorf(\"%s/%s: %w\", ", model.GoName, "TableName, id, rt.ErrNotFound)")
+	g.P("\t}")
+	g.P("\tif t.cache != nil {")
+	g.P("\t\t// Callers own the returned message, the cache keeps its own.")
+	g.P("\t\trow.Data = proto.Clone(row.Data).(*", model.GoName, ")")
+	g.P("\t}")
+	g.P("\treturn &row, nil")
+	g.P("}")
+	g.P()
+	g.P("// MustGetByID is GetByID panicking on error, for ids known to exist.")
+	g.P("func (t *", model.TableTypeName, ") MustGetByID(id string) *", model.RowTypeName, " {")
+	g.P("\trow, err := t.GetByID(id)")
+	g.P("\tif err != nil {")
+	g.P("\t\tpanic(err)")
+	g.P("\t}")
+	g.P("\treturn row")
+	g.P("}")
+	g.P()
+	g.P("// GetManyByID returns the rows of ids, in their order and without")
+	g.P("// duplicates, using one query. Missing ids are skipped.")
+	g.P("func (t *", model.TableTypeName, ") GetManyByID(ids []string) ([]", model.RowTypeName, ", error) {")
+	g.P("\tif len(ids) == 0 {")
+	g.P("\t\treturn []", model.RowTypeName, "{}, nil")
+	g.P("\t}")
+	g.P("\twhere, args := rt.IDsWhere(ids)")
+	g.P("\trows, err := t.Select(where, args...)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\tbyID := make(map[string]", model.RowTypeName, ", len(rows))")
+	g.P("\tfor _, row := range rows {")
+	g.P("\t\tbyID[row.ID] = row")
+	g.P("\t}")
+	g.P("\tresult := make([]", model.RowTypeName, ", 0, len(rows))")
+	g.P("\tfor _, id := range ids {")
+	g.P("\t\tif row, ok := byID[id]; ok {")
+	g.P("\t\t\tresult = append(result, row)")
+	g.P("\t\t\tdelete(byID, id)")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\treturn result, nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitTimestampRangeMethods(model messageModel) {
+	g := e.g
+	for _, projectedField := range model.ProjectedFields {
+		if !projectedField.Timestamp || projectedField.Encrypted {
+			continue
+		}
+		fieldGoName := strings.TrimPrefix(projectedField.GetterName, "Get")
+		bound := projectedField.timestampBound
+		column := `"` + projectedField.ColumnName + `"`
+		g.P("// Select", fieldGoName, "Between returns the rows with ", projectedField.ColumnName, " in [from, to), ordered by ", projectedField.ColumnName, ".")
+		g.P("func (t *", model.TableTypeName, ") Select", fieldGoName, "Between(from, to time.Time) ([]", model.RowTypeName, ", error) {")
+		g.P("\treturn t.Select(`", column, " >= ? AND ", column, " < ? ORDER BY ", column, "`, ", bound("from"), ", ", bound("to"), ")")
+		g.P("}")
+		g.P()
+	}
+}
+
+// hasInsertWithID reports whether the table accepts caller supplied ids.
+func (m messageModel) hasInsertWithID() bool {
+	return m.AllowCustomIDInsert || m.IDFormat == proprdbpb.IdFormat_ID_FORMAT_CUSTOM
+}
+
+// emitIDFormatDefault defaults opts.IDGenerator to the (proprdb.id_format)
+// of the model in table constructors.
+func (e generatorEmitter) emitIDFormatDefault(model messageModel) {
+	generator := map[proprdbpb.IdFormat]string{
+		proprdbpb.IdFormat_ID_FORMAT_ULID:   "rt.ULIDGenerator{}",
+		proprdbpb.IdFormat_ID_FORMAT_CUSTOM: "rt.CustomIDs{}",
+	}[model.IDFormat]
+	if generator == "" {
+		return
+	}
+	e.g.P("\tif opts.IDGenerator == nil {")
+	e.g.P("\t\topts.IDGenerator = ", generator)
+	e.g.P("\t}")
+}
+
+// allTenantsCall returns the call exempting internal reads of a tenant table
+// from Options.RequireTenant.
+func (m messageModel) allTenantsCall() string {
+	if m.TenantColumn == "" {
+		return ""
+	}
+	return ".allTenants()"
+}
+
+// storeMethods lists the method signatures of the generated Store interface.
+func (m messageModel) storeMethods() []string {
+	methods := []string{
+		"Select(where string, args ...any) ([]" + m.RowTypeName + ", error)",
+		"SelectWithOptions(opts rt.SelectOptions, where string, args ...any) ([]" + m.RowTypeName + ", error)",
+	}
+	if m.SoftDelete {
+		methods = append(methods, "SelectIncludingDeleted(where string, args ...any) ([]"+m.RowTypeName+", error)")
+	}
+	methods = append(methods,
+		"SelectByFields(where string, args ...any) (["+"]"+m.RowTypeName+", error)",
+		"GetByID(id string) (*"+m.RowTypeName+", error)",
+		"MustGetByID(id string) *"+m.RowTypeName,
+		"GetManyByID(ids []string) (["+"]"+m.RowTypeName+", error)",
+	)
+	for _, projectedField := range m.ProjectedFields {
+		if projectedField.Timestamp && !projectedField.Encrypted {
+			methods = append(methods, "Select"+strings.TrimPrefix(projectedField.GetterName, "Get")+"Between(from, to time.Time) (["+"]"+m.RowTypeName+", error)")
+		}
+	}
+	methods = append(methods, "Insert(data *"+m.GoName+") ("+m.RowTypeName+", error)")
+	if m.hasInsertWithID() {
+		methods = append(methods,
+			"InsertWithID(id string, data *"+m.GoName+") ("+m.RowTypeName+", error)",
+			"InsertWithIDIfAbsent(id string, data *"+m.GoName+") ("+m.RowTypeName+", bool, error)",
+		)
+	}
+	methods = append(methods,
+		"UpdateByID(id string, data *"+m.GoName+") ("+m.RowTypeName+", error)",
+		"UpdateRow(row "+m.RowTypeName+") ("+m.RowTypeName+", error)",
+		"UpdateFields(id string, patch *"+m.GoName+", mask *fieldmaskpb.FieldMask) ("+m.RowTypeName+", error)",
+		"UpdateByIDIfRevision(id, revision string, data *"+m.GoName+") ("+m.RowTypeName+", error)",
+		"UpdateWhere(where string, mutate func(*"+m.GoName+") error, args ...any) (int64, error)",
+		"DeleteByID(id string) error",
+		"DeleteRow(row "+m.RowTypeName+") error",
+		"DeleteByIDIfRevision(id, revision string) error",
+		"DeleteWhere(where string, args ...any) (int64, error)",
+	)
+	if m.SoftDelete {
+		methods = append(methods, "Restore(id string) ("+m.RowTypeName+", error)")
+	}
+	if m.VersionVector {
+		methods = append(methods, "VersionVector(id string) (rt.VersionVector, error)")
+	}
+	if m.History {
+		methods = append(methods,
+			"History(id string) (["+"]"+m.RowTypeName+", error)",
+			"GetAsOf(id string, atNs int64) (*"+m.RowTypeName+", error)",
+			"RevertTo(id string, atNs int64) error",
+			"RevertTableTo(atNs int64) (int64, error)",
+		)
+	}
+	return methods
+}
+
+// timestampBound is the Go expression of the column value of the
+// time.Time variable name, for Timestamp fields.
+func (f projectedField) timestampBound(name string) string {
+	if f.SQLiteType == "TEXT" {
+		return "rt.FormatTimestampText(" + name + ")"
+	}
+	return name + ".UnixNano()"
+}
+
+// emitWriteValidation emits the (proprdb.validate_write), field rule and
+// protovalidate checks of data in Insert and Update.
+func (e generatorEmitter) emitWriteValidation(model messageModel) {
+	g := e.g
+	if model.ValidateWrite {
+		g.P("\tif err := data.Valid(); err != nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, rt.ValidationError(\"", model.GoName, "\", err)")
+		g.P("\t}")
+	}
+	if len(model.FieldRules) > 0 {
+		g.P("\tif err := rt.ValidateFieldRules(data, ", model.GoName, "FieldRules); err != nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, rt.ValidationError(\"", model.GoName, "\", err)")
+		g.P("\t}")
+	}
+	if model.ProtoValidate {
+		g.P("\tif err := rt.ProtoValidate(t.opts, data); err != nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, rt.ValidationError(\"", model.GoName, "\", err)")
+		g.P("\t}")
+	}
+}
+
+// emitRecordMigrations emits the (proprdb.migration) options applied to
+// data decoded from a record, which older replicas may have written before
+// the migrated fields existed. zero prefixes the returned error with the
+// other results.
+func (e generatorEmitter) emitRecordMigrations(model messageModel, indent, zero string) {
+	if len(model.Migrations) == 0 {
+		return
+	}
+	g := e.g
+	g.P(indent, "if _, err := rt.ApplyFieldMigrations(data, ", model.GoName, "Migrations); err != nil {")
+	g.P(indent, "\treturn ", zero, "fmt.Errorf(\"migrate ", model.GoName, " %s: %w\", record.ID, err)")
+	g.P(indent, "}")
+}
+
+// emitImportValidation emits the write validation of emitWriteValidation
+// for imported data, rejecting records that fail it. zero prefixes the
+// returned error with the other results, and opts is the rt.Options in
+// scope.
+func (e generatorEmitter) emitImportValidation(model messageModel, indent, zero, opts string) {
+	g := e.g
+	if model.ValidateWrite {
+		g.P(indent, "if err := data.Valid(); err != nil {")
+		g.P(indent, "\treturn ", zero, "rt.Reject(rt.ValidationError(\"", model.GoName, "\", err))")
+		g.P(indent, "}")
+	}
+	if len(model.FieldRules) > 0 {
+		g.P(indent, "if err := rt.ValidateFieldRules(data, ", model.GoName, "FieldRules); err != nil {")
+		g.P(indent, "\treturn ", zero, "rt.Reject(rt.ValidationError(\"", model.GoName, "\", err))")
+		g.P(indent, "}")
+	}
+	if model.ProtoValidate {
+		g.P(indent, "if err := rt.ProtoValidate(", opts, ", data); err != nil {")
+		g.P(indent, "\treturn ", zero, "rt.Reject(rt.ValidationError(\"", model.GoName, "\", err))")
+		g.P(indent, "}")
+	}
+}
+
+// emitCoordinatedMethod emits coordinated, which runs a write on the table
+// bound to the batch transaction of Options.WriteCoordinator.
+func (e generatorEmitter) emitCoordinatedMethod(model messageModel) {
+	g := e.g
+	g.P("// coordinated runs write on t bound to a batch transaction of")
+	g.P("// Options.WriteCoordinator and returns once the batch is committed.")
+	g.P("func (t *", model.TableTypeName, ") coordinated(write func(bound *", model.TableTypeName, ") (", model.RowTypeName, ", error)) (", model.RowTypeName, ", error) {")
+	g.P("\trow, err := rt.CoordinateWrite(t.opts.WriteCoordinator, func(tx DBTX) (", model.RowTypeName, ", error) {")
+	g.P("\t\tbound := *t")
+	g.P("\t\tbound.q = rt.ObserveDBTX(tx, t.opts.EffectiveQueryObserver())")
+	g.P("\t\tbound.reader = bound.q")
+	g.P("\t\tbound.opts.WriteCoordinator = nil")
+	g.P("\t\treturn write(&bound)")
+	g.P("\t})")
+	g.P("\t// Reads while the batch was open may have cached the replaced row.")
+	g.P("\tif err != nil {")
+	g.P("\t\tt.cache.Purge()")
+	g.P("\t} else {")
+	g.P("\t\tt.cache.Invalidate(row.ID)")
+	g.P("\t}")
+	g.P("\treturn row, err")
+	g.P("}")
+	g.P()
+}
+
+// emitCoordinatedWrite routes a write method through coordinated when
+// Options.WriteCoordinator is set.
+func (e generatorEmitter) emitCoordinatedWrite(model messageModel, call string) {
+	g := e.g
+	g.P("\tif t.opts.WriteCoordinator != nil {")
+	g.P("\t\treturn t.coordinated(func(bound *", model.TableTypeName, ") (", model.RowTypeName, ", error) {")
+	g.P("\t\t\treturn ", call)
+	g.P("\t\t})")
+	g.P("\t}")
+}
+
+func (e generatorEmitter) emitInsertMethod(model messageModel, tableNameConst, insertConst string) {
+	g := e.g
+	e.emitCoordinatedMethod(model)
+	g.P("func (t *", model.TableTypeName, ") Insert(data *", model.GoName, ") (_ ", model.RowTypeName, ", err error) {")
+	e.emitCoordinatedWrite(model, "bound.Insert(data)")
+	g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationInsert, ", tableNameConst, ")(&err)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+	g.P("\t}")
+	g.P("\tif data == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("\t}")
+	g.P("\tid, err := t.opts.NewID()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"generate id: %w\", err)")
+	g.P("\t}")
+	g.P("\tif err := t.opts.ValidateID(id); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"validate generated id %s: %w\", id, err)")
+	g.P("\t}")
+	g.P("\treturn t.insertWithID(id, data)")
+	g.P("}")
+	g.P()
+
+	if model.hasInsertWithID() {
+		g.P("func (t *", model.TableTypeName, ") InsertWithID(id string, data *", model.GoName, ") (_ ", model.RowTypeName, ", err error) {")
+		e.emitCoordinatedWrite(model, "bound.InsertWithID(id, data)")
+		g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationInsert, ", tableNameConst, ")(&err)")
+		g.P("\tif t.q == nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
 		g.P("\t}")
-		g.P("\texistingColumns := make(map[string]bool)")
-		g.P("\tfor columnRows.Next() {")
-		g.P("\t\tvar cid int")
-		g.P("\t\tvar name string")
-		g.P("\t\tvar colType string")
-		g.P("\t\tvar notNull int")
-		g.P("\t\tvar defaultValue any")
-		g.P("\t\tvar pk int")
-		g.P("\t\tif err := columnRows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {")
-		g.P("\t\t\tif closeErr := rt.CloseRows(columnRows, \"projection metadata\"); closeErr != nil {")
-		g.P("\t\t\t\treturn fmt.Errorf(\"scan pragma row: %w (additionally, %v)\", err, closeErr)")
-		g.P("\t\t\t}")
-		g.P("\t\t\treturn fmt.Errorf(\"scan pragma row: %w\", err)")
-		g.P("\t\t}")
-		g.P("\t\texistingColumns[name] = true")
+		g.P("\tif data == nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
 		g.P("\t}")
-		g.P("\tif err := columnRows.Err(); err != nil {")
-		g.P("\t\tif closeErr := rt.CloseRows(columnRows, \"projection metadata\"); closeErr != nil {")
-		g.P("\t\t\treturn fmt.Errorf(\"iterate pragma rows: %w (additionally, %v)\", err, closeErr)")
+		g.P("\treturn t.insertWithID(id, data)")
+		g.P("}")
+		g.P()
+
+		g.P("// InsertWithIDIfAbsent is InsertWithID returning the existing row and")
+		g.P("// false when id exists, for ingestion that may replay the same insert.")
+		g.P("func (t *", model.TableTypeName, ") InsertWithIDIfAbsent(id string, data *", model.GoName, ") (_ ", model.RowTypeName, ", inserted bool, err error) {")
+		g.P("\tif t.opts.WriteCoordinator != nil {")
+		g.P("\t\trow, err := t.coordinated(func(bound *", model.TableTypeName, ") (", model.RowTypeName, ", error) {")
+		g.P("\t\t\trow, boundInserted, err := bound.InsertWithIDIfAbsent(id, data)")
+		g.P("\t\t\tinserted = boundInserted")
+		g.P("\t\t\treturn row, err")
+		g.P("\t\t})")
+		g.P("\t\treturn row, inserted, err")
+		g.P("\t}")
+		g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationInsert, ", tableNameConst, ")(&err)")
+		g.P("\tif t.q == nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, false, errors.New(\""+errNilDBTX+"\")")
+		g.P("\t}")
+		g.P("\tif data == nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, false, errors.New(\""+errNilData+"\")")
+		g.P("\t}")
+		g.P("\t// Look the row up where it is written, not on Options.Reader.")
+		g.P("\tprimary := *t")
+		g.P("\tprimary.reader = primary.q")
+		g.P("\tif existing, err := primary.GetByID(id); err == nil {")
+		g.P("\t\treturn *existing, false, nil")
+		g.P("\t} else if !errors.Is(err, rt.ErrNotFound) {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, false, err")
+		g.P("\t}")
+		g.P("\trow, err := t.insertWithID(id, data)")
+		g.P("\tif errors.Is(err, rt.ErrUniqueViolation) {")
+		g.P("\t\t// Another writer inserted id since the lookup.")
+		g.P("\t\tif existing, getErr := primary.GetByID(id); getErr == nil {")
+		g.P("\t\t\treturn *existing, false, nil")
 		g.P("\t\t}")
-		g.P("\t\treturn fmt.Errorf(\"iterate pragma rows: %w\", err)")
 		g.P("\t}")
-		g.P("\tif err := rt.CloseRows(columnRows, \"projection metadata\"); err != nil {")
-		g.P("\t\treturn err")
+		g.P("\treturn row, err == nil, err")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("func (t *", model.TableTypeName, ") insertWithID(id string, data *", model.GoName, ") (", model.RowTypeName, ", error) {")
+	g.P("\tdefer t.cache.Invalidate(id)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+	g.P("\t}")
+	g.P("\tif data == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, rt.ErrEmptyID")
+	g.P("\t}")
+	g.P("\tif err := t.opts.ValidateID(id); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"validate id %s: %w\", id, err)")
+	g.P("\t}")
+	e.emitWriteValidation(model)
+	e.emitTenantWriteCheck(model, tableNameConst)
+	g.P("\tctx := context.Background()")
+	g.P("\tatNs := t.opts.NowNs()")
+	g.P("\tdataBytes, err := rt.MarshalData(t.opts, data)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"marshal ", model.GoName, ": %w\", err)")
+	g.P("\t}")
+	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM _deleted WHERE table_name = ? AND id = ?`, ", tableNameConst, ", id); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"delete tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\t}")
+	if model.SoftDelete {
+		g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM \"`+", tableNameConst, "+`\" WHERE id = ? AND deleted_at_ns IS NOT NULL`, id); err != nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"delete soft-deleted row %s/%s: %w\", ", tableNameConst, ", id, err)")
+		g.P("\t}")
+	}
+	g.P("\tinsertArgs := []any{", model.writeArgs(), "}")
+	for _, projectedField := range model.ProjectedFields {
+		e.emitProjectedFieldAppend("insertArgs", "data", projectedField, "\t", model.RowTypeName+"{}, ")
+	}
+	g.P("\tif _, err := t.q.ExecContext(ctx, ", insertConst, ", insertArgs...); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"insert into %s: %w\", ", tableNameConst, ", rt.ClassifySQLError(err))")
+	g.P("\t}")
+	e.emitMapProjectionWrites(model, "data", "\t", model.RowTypeName+"{}, ")
+	e.emitRecordHistory(model, "dataBytes", model.RowTypeName+"{}, ")
+	if model.VersionVector {
+		g.P("\tif err := rt.BumpVersionVector(t.q, t.opts, ", tableNameConst, ", id); err != nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, err")
+		g.P("\t}")
+	}
+	g.P("\treturn ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data}, nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitUpdateMethod(model messageModel, tableNameConst, upsertConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") UpdateByID(id string, data *", model.GoName, ") (_ ", model.RowTypeName, ", err error) {")
+	e.emitCoordinatedWrite(model, "bound.UpdateByID(id, data)")
+	g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationUpdate, ", tableNameConst, ")(&err)")
+	g.P("\tdefer t.cache.Invalidate(id)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, rt.ErrEmptyID")
+	g.P("\t}")
+	g.P("\tif err := t.opts.ValidateID(id); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"validate id %s: %w\", id, err)")
+	g.P("\t}")
+	g.P("\tif data == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("\t}")
+	e.emitWriteValidation(model)
+	e.emitTenantWriteCheck(model, tableNameConst)
+	g.P("\tctx := context.Background()")
+	g.P("\tatNs := t.opts.NowNs()")
+	g.P("\tdataBytes, err := rt.MarshalData(t.opts, data)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"marshal ", model.GoName, ": %w\", err)")
+	g.P("\t}")
+	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM _deleted WHERE table_name = ? AND id = ?`, ", tableNameConst, ", id); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"delete tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\t}")
+	g.P("\tupdateArgs := []any{", model.writeArgs(), "}")
+	for _, projectedField := range model.ProjectedFields {
+		e.emitProjectedFieldAppend("updateArgs", "data", projectedField, "\t", model.RowTypeName+"{}, ")
+	}
+	g.P("\tif _, err := t.q.ExecContext(ctx, ", upsertConst, ", updateArgs...); err != nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"upsert into %s: %w\", ", tableNameConst, ", rt.ClassifySQLError(err))")
+	g.P("\t}")
+	e.emitMapProjectionWrites(model, "data", "\t", model.RowTypeName+"{}, ")
+	e.emitRecordHistory(model, "dataBytes", model.RowTypeName+"{}, ")
+	if model.VersionVector {
+		g.P("\tif err := rt.BumpVersionVector(t.q, t.opts, ", tableNameConst, ", id); err != nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, err")
+		g.P("\t}")
+	}
+	g.P("\treturn ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data}, nil")
+	g.P("}")
+	g.P()
+
+	g.P("func (t *", model.TableTypeName, ") UpdateRow(row ", model.RowTypeName, ") (", model.RowTypeName, ", error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+	g.P("\t}")
+	g.P("\tif row.ID == \"\" {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, rt.ErrEmptyID")
+	g.P("\t}")
+	g.P("\tif row.Data == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("\t}")
+	g.P("\treturn t.UpdateByID(row.ID, row.Data)")
+	g.P("}")
+	g.P()
+
+	g.P("// UpdateFields replaces the fields of the row of id named by mask with")
+	g.P("// those of patch, keeping the other stored fields, and writes the result")
+	g.P("// as UpdateByID does. The row is read and written in one transaction, so")
+	g.P("// concurrent updates of other fields are not lost. See rt.ApplyFieldMask.")
+	g.P("func (t *", model.TableTypeName, ") UpdateFields(id string, patch *", model.GoName, ", mask *fieldmaskpb.FieldMask) (", model.RowTypeName, ", error) {")
+	e.emitCoordinatedWrite(model, "bound.UpdateFields(id, patch, mask)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, rt.ErrEmptyID")
+	g.P("\t}")
+	g.P("\tif patch == nil {")
+	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("\t}")
+	g.P("\tvar updated ", model.RowTypeName)
+	g.P("\terr := rt.WithTxRetry(context.Background(), t.q, t.opts.TxRetry, func(tx DBTX) error {")
+	g.P("\t\tbound := *t")
+	g.P("\t\tbound.q = rt.ObserveDBTX(tx, t.opts.EffectiveQueryObserver())")
+	g.P("\t\tbound.reader = bound.q")
+	g.P("\t\trows, err := bound.Select(`id = ?`, id)")
+	g.P("\t\tif err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t\tif len(rows) == 0 {")
+	g.P("\t\t\treturn fmt.Errorf(\"%s/%s: %w\", ", tableNameConst, ", id, rt.ErrNotFound)")
+	g.P("\t\t}")
+	g.P("\t\tdata := rows[0].Data")
+	g.P("\t\tif err := rt.ApplyFieldMask(data, patch, mask); err != nil {")
+	g.P("\t\t\treturn fmt.Errorf(\"update fields of %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\t\t}")
+	g.P("\t\tupdated, err = bound.UpdateByID(id, data)")
+	g.P("\t\treturn err")
+	g.P("\t})")
+	g.P("\treturn updated, err")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitDeleteMethod(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") DeleteByID(id string) (err error) {")
+	g.P("\tif t.opts.WriteCoordinator != nil {")
+	g.P("\t\t_, err := t.coordinated(func(bound *", model.TableTypeName, ") (", model.RowTypeName, ", error) {")
+	g.P("\t\t\treturn ", model.RowTypeName, "{ID: id}, bound.DeleteByID(id)")
+	g.P("\t\t})")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tdefer rt.StartOperation(t.opts.Instrumentation, rt.OperationDelete, ", tableNameConst, ")(&err)")
+	g.P("\tdefer t.cache.Invalidate(id)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn rt.ErrEmptyID")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\tif err := t.tenant.CheckOwner(t.q, ", tableNameConst, ", ", model.GoName, "TenantColumn, id); err != nil {")
+		g.P("\t\treturn err")
+		g.P("\t}")
+	}
+	g.P("\tctx := context.Background()")
+	g.P("\tatNs := t.opts.NowNs()")
+	g.P("\tif _, err := t.q.ExecContext(ctx, `INSERT INTO _deleted (table_name, id, at_ns) VALUES (?, ?, ?) ON CONFLICT(table_name, id) DO UPDATE SET at_ns = excluded.at_ns`, ", tableNameConst, ", id, atNs); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"insert tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\t}")
+	e.emitRemoveRow(model, tableNameConst)
+	g.P("\treturn nil")
+	g.P("}")
+	g.P()
+
+	g.P("func (t *", model.TableTypeName, ") DeleteRow(row ", model.RowTypeName, ") error {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif row.ID == \"\" {")
+	g.P("\t\treturn rt.ErrEmptyID")
+	g.P("\t}")
+	g.P("\treturn t.DeleteByID(row.ID)")
+	g.P("}")
+	g.P()
+
+	if model.SoftDelete {
+		g.P("// Restore undeletes a soft-deleted row. It is written with a new at_ns, so")
+		g.P("// the restore replaces the tombstone on sync.")
+		g.P("func (t *", model.TableTypeName, ") Restore(id string) (", model.RowTypeName, ", error) {")
+		g.P("\tif t.q == nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+		g.P("\t}")
+		g.P("\tif id == \"\" {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, rt.ErrEmptyID")
+		g.P("\t}")
+		g.P("\trows, err := t.SelectIncludingDeleted(`id = ?`, id)")
+		g.P("\tif err != nil {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, err")
+		g.P("\t}")
+		g.P("\tif len(rows) == 0 || rows[0].DeletedAtNs == 0 {")
+		g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"restore %s/%s: no soft-deleted row\", ", tableNameConst, ", id)")
+		g.P("\t}")
+		g.P("\treturn t.UpdateByID(id, rows[0].Data)")
+		g.P("}")
+		g.P()
+	}
+}
+
+// emitRevisionMethods emits UpdateByIDIfRevision and DeleteByIDIfRevision,
+// which write only when the row is still at the revision the caller read.
+func (e generatorEmitter) emitRevisionMethods(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("// UpdateByIDIfRevision updates the row of id as UpdateByID does if it is")
+	g.P("// still at revision, as returned by ", model.RowTypeName, ".Revision, and fails with")
+	g.P("// an error wrapping rt.ErrRevisionMismatch otherwise.")
+	g.P("func (t *", model.TableTypeName, ") UpdateByIDIfRevision(id, revision string, data *", model.GoName, ") (", model.RowTypeName, ", error) {")
+	e.emitCoordinatedWrite(model, "bound.UpdateByIDIfRevision(id, revision, data)")
+	g.P("\tvar updated ", model.RowTypeName)
+	g.P("\terr := t.ifRevision(id, revision, func(bound *", model.TableTypeName, ") error {")
+	g.P("\t\tvar err error")
+	g.P("\t\tupdated, err = bound.UpdateByID(id, data)")
+	g.P("\t\treturn err")
+	g.P("\t})")
+	g.P("\treturn updated, err")
+	g.P("}")
+	g.P()
+	g.P("// DeleteByIDIfRevision deletes the row of id as DeleteByID does if it is")
+	g.P("// still at revision, and fails with an error wrapping")
+	g.P("// rt.ErrRevisionMismatch otherwise.")
+	g.P("func (t *", model.TableTypeName, ") DeleteByIDIfRevision(id, revision string) error {")
+	g.P("\tif t.opts.WriteCoordinator != nil {")
+	g.P("\t\t_, err := t.coordinated(func(bound *", model.TableTypeName, ") (", model.RowTypeName, ", error) {")
+	g.P("\t\t\treturn ", model.RowTypeName, "{ID: id}, bound.DeleteByIDIfRevision(id, revision)")
+	g.P("\t\t})")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\treturn t.ifRevision(id, revision, func(bound *", model.TableTypeName, ") error {")
+	g.P("\t\treturn bound.DeleteByID(id)")
+	g.P("\t})")
+	g.P("}")
+	g.P()
+	g.P("// ifRevision runs write in a transaction once the row of id is found at")
+	g.P("// revision in it.")
+	g.P("func (t *", model.TableTypeName, ") ifRevision(id, revision string, write func(bound *", model.TableTypeName, ") error) error {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn rt.ErrEmptyID")
+	g.P("\t}")
+	g.P("\treturn rt.WithTxRetry(context.Background(), t.q, t.opts.TxRetry, func(tx DBTX) error {")
+	g.P("\t\tbound := *t")
+	g.P("\t\tbound.q = rt.ObserveDBTX(tx, t.opts.EffectiveQueryObserver())")
+	g.P("\t\tbound.reader = bound.q")
+	g.P("\t\trows, err := bound.Select(`id = ?`, id)")
+	g.P("\t\tif err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t\tif len(rows) == 0 {")
+	g.P("\t\t\treturn fmt.Errorf(\"%s/%s: %w\", ", tableNameConst, ", id, rt.ErrNotFound)")
+	g.P("\t\t}")
+	g.P("\t\tif err := rt.CheckRevision(", tableNameConst, ", id, rows[0].AtNs, rows[0].Data, revision); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t\treturn write(&bound)")
+	g.P("\t})")
+	g.P("}")
+	g.P()
+}
+
+// emitMutateWhereMethods emits DeleteWhere and UpdateWhere, which apply
+// DeleteByID and UpdateByID to the matching rows in batched transactions.
+func (e generatorEmitter) emitMutateWhereMethods(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("// DeleteWhere deletes the rows matching where as DeleteByID does, in")
+	g.P("// transactions of rt.DefaultMutateBatchSize rows, and returns how many it")
+	g.P("// deleted. Batches committed before a failure stay deleted.")
+	g.P("func (t *", model.TableTypeName, ") DeleteWhere(where string, args ...any) (int64, error) {")
+	g.P("\treturn t.mutateWhere(where, args, func(bound *", model.TableTypeName, ", row ", model.RowTypeName, ") error {")
+	g.P("\t\treturn bound.DeleteByID(row.ID)")
+	g.P("\t})")
+	g.P("}")
+	g.P()
+	g.P("// UpdateWhere calls mutate with the data of each row matching where and")
+	g.P("// writes it back as UpdateByID does, with a new at_ns, batched like")
+	g.P("// DeleteWhere. It returns how many rows it updated.")
+	g.P("func (t *", model.TableTypeName, ") UpdateWhere(where string, mutate func(*", model.GoName, ") error, args ...any) (int64, error) {")
+	g.P("\tif mutate == nil {")
+	g.P("\t\treturn 0, errors.New(\"nil mutate\")")
+	g.P("\t}")
+	g.P("\treturn t.mutateWhere(where, args, func(bound *", model.TableTypeName, ", row ", model.RowTypeName, ") error {")
+	g.P("\t\tif err := mutate(row.Data); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t\t_, err := bound.UpdateByID(row.ID, row.Data)")
+	g.P("\t\treturn err")
+	g.P("\t})")
+	g.P("}")
+	g.P()
+	g.P("func (t *", model.TableTypeName, ") mutateWhere(where string, args []any, mutate func(bound *", model.TableTypeName, ", row ", model.RowTypeName, ") error) (int64, error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn 0, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\t// Reads in a batch that failed may have cached rows it rolled back.")
+	g.P("\tdefer t.cache.Purge()")
+	g.P("\treturn rt.MutateInBatches(context.Background(), t.q, t.opts.TxRetry, rt.DefaultMutateBatchSize, func(tx DBTX, afterID string, limit int) (string, int, error) {")
+	g.P("\t\tbound := *t")
+	g.P("\t\tbound.q = rt.ObserveDBTX(tx, t.opts.EffectiveQueryObserver())")
+	g.P("\t\tbound.reader = bound.q")
+	g.P("\t\tbound.opts.WriteCoordinator = nil")
+	g.P("\t\tpageArgs := append(append(make([]any, 0, len(args)+1), args...), afterID)")
+	g.P("\t\trows, err := bound.SelectWithOptions(rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: \"id\"}}, Limit: limit}, rt.AfterIDWhere(where), pageArgs...)")
+	g.P("\t\tif err != nil || len(rows) == 0 {")
+	g.P("\t\t\treturn afterID, 0, err")
+	g.P("\t\t}")
+	g.P("\t\tfor _, row := range rows {")
+	g.P("\t\t\tif err := mutate(&bound, row); err != nil {")
+	g.P("\t\t\t\treturn \"\", 0, fmt.Errorf(\"%s/%s: %w\", ", tableNameConst, ", row.ID, err)")
+	g.P("\t\t\t}")
+	g.P("\t\t}")
+	g.P("\t\treturn rows[len(rows)-1].ID, len(rows), nil")
+	g.P("\t})")
+	g.P("}")
+	g.P()
+}
+
+// emitRemoveRow emits the removal of row id after its tombstone at atNs has
+// been written: soft-delete models keep the row and stamp deleted_at_ns.
+func (e generatorEmitter) emitRemoveRow(model messageModel, tableNameConst string) {
+	g := e.g
+	e.emitRecordHistory(model, "nil", "")
+	if model.SoftDelete {
+		g.P("\tif _, err := t.q.ExecContext(ctx, `UPDATE \"`+", tableNameConst, "+`\" SET deleted_at_ns = ? WHERE id = ?`, atNs, id); err != nil {")
+		g.P("\t\treturn fmt.Errorf(\"soft delete %s/%s: %w\", ", tableNameConst, ", id, err)")
+		g.P("\t}")
+		return
+	}
+	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM \"`+", tableNameConst, "+`\" WHERE id = ?`, id); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"delete from %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\t}")
+	for _, projection := range model.MapProjections {
+		g.P("\tif err := rt.DeleteMapEntries(t.q, ", model.GoName, projection.GoName, "TableName, id); err != nil {")
+		g.P("\t\treturn err")
+		g.P("\t}")
+	}
+}
+
+// emitRecordHistory emits recording the version of id written at atNs in
+// the history table of (proprdb.history) models. dataName is nil for
+// deletions.
+func (e generatorEmitter) emitRecordHistory(model messageModel, dataName, errReturnPrefix string) {
+	if !model.History {
+		return
+	}
+	g := e.g
+	g.P("\tif err := rt.RecordHistory(t.q, ", model.GoName, "HistoryTableName, id, atNs, ", dataName, "); err != nil {")
+	g.P("\t\treturn ", errReturnPrefix, "err")
+	g.P("\t}")
+}
+
+// emitHistoryMethods emits History and GetAsOf of (proprdb.history) models.
+func (e generatorEmitter) emitHistoryMethods(model messageModel) {
+	g := e.g
+	historyTableConst := model.GoName + "HistoryTableName"
+	g.P("// History returns every recorded version of id in at_ns order. Deletions are")
+	g.P("// rows with nil Data.")
+	g.P("func (t *", model.TableTypeName, ") History(id string) ([]", model.RowTypeName, ", error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn nil, rt.ErrEmptyID")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\tif err := t.tenant.Check(t.opts.RequireTenant); err != nil {")
+		g.P("\t\treturn nil, fmt.Errorf(\"history of %s/%s: %w\", ", model.GoName, "TableName, id, err)")
+		g.P("\t}")
+	}
+	g.P("\tversions, err := rt.ReadHistory(t.reader, ", historyTableConst, ", id)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\tresult := make([]", model.RowTypeName, ", 0, len(versions))")
+	g.P("\tfor _, version := range versions {")
+	g.P("\t\trow := ", model.RowTypeName, "{ID: id, AtNs: version.AtNs}")
+	g.P("\t\tif version.Data != nil {")
+	g.P("\t\t\trow.Data = &", model.GoName, "{}")
+	g.P("\t\t\tif err := rt.UnmarshalData(t.opts, version.Data, row.Data); err != nil {")
+	g.P("\t\t\t\treturn nil, fmt.Errorf(\"unmarshal ", model.GoName, " %s version %d: %w\", id, version.AtNs, err)")
+	g.P("\t\t\t}")
+	if model.TenantColumn != "" {
+		g.P("\t\t\tif !t.tenant.Allows(row.Data.", model.TenantGetter, "()) {")
+		g.P("\t\t\t\tcontinue")
+		g.P("\t\t\t}")
+	}
+	g.P("\t\t}")
+	g.P("\t\tresult = append(result, row)")
+	g.P("\t}")
+	g.P("\treturn result, nil")
+	g.P("}")
+	g.P()
+	g.P("// GetAsOf returns the version of id current at atNs, or an error wrapping")
+	g.P("// rt.ErrNotFound when the row did not exist or was deleted then.")
+	g.P("func (t *", model.TableTypeName, ") GetAsOf(id string, atNs int64) (*", model.RowTypeName, ", error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn nil, rt.ErrEmptyID")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\tif err := t.tenant.Check(t.opts.RequireTenant); err != nil {")
+		g.P("\t\treturn nil, fmt.Errorf(\"get %s/%s as of %d: %w\", ", model.GoName, "TableName, id, atNs, err)")
+		g.P("\t}")
+	}
+	g.P("\tversion, err := rt.ReadHistoryAsOf(t.reader, ", historyTableConst, ", id, atNs)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\tdata := &", model.GoName, "{}")
+	g.P("\tif err := rt.UnmarshalData(t.opts, version.Data, data); err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"unmarshal ", model.GoName, " %s version %d: %w\", id, version.AtNs, err)")
+	g.P("\t}")
+	if model.TenantColumn != "" {
+		g.P("\tif !t.tenant.Allows(data.", model.TenantGetter, "()) {")
+		g.P("\t\treturn nil, fmt.Errorf(\"%s/%s as of %d: %w\", ", model.GoName, "TableName, id, atNs, rt.ErrNotFound)")
 		g.P("\t}")
-		for _, projectedField := range model.ProjectedFields {
-			g.P("\tif !existingColumns[", strconv.Quote(projectedField.ColumnName), "] {")
-			g.P("\t\tif _, err := t.q.ExecContext(ctx, `ALTER TABLE \"`+", tableNameConst, "+`\" ADD COLUMN ", projectedField.createColumnSQL(), "`); err != nil {")
-			g.P("\t\t\treturn fmt.Errorf(\"add projection column ", projectedField.ColumnName, " to %s: %w\", ", tableNameConst, ", err)")
-			g.P("\t\t}")
-			g.P("\t}")
-		}
 	}
+	g.P("\treturn &", model.RowTypeName, "{ID: id, AtNs: version.AtNs, Data: data}, nil")
+	g.P("}")
+	g.P()
+	g.P("// RevertTo rewrites id to its version at atNs, deleting it if it did not")
+	g.P("// exist then. The revert is a new write, so it syncs like any other.")
+	g.P("func (t *", model.TableTypeName, ") RevertTo(id string, atNs int64) error {")
+	g.P("\t_, err := t.revertTo(id, atNs)")
+	g.P("\treturn err")
+	g.P("}")
+	g.P()
+	g.P("// revertTo is RevertTo reporting whether id was written.")
+	g.P("func (t *", model.TableTypeName, ") revertTo(id string, atNs int64) (bool, error) {")
+	g.P("\tcurrent, err := t.GetByID(id)")
+	g.P("\tif err != nil && !errors.Is(err, rt.ErrNotFound) {")
+	g.P("\t\treturn false, err")
+	g.P("\t}")
+	g.P("\tversion, err := t.GetAsOf(id, atNs)")
+	g.P("\tswitch {")
+	g.P("\tcase errors.Is(err, rt.ErrNotFound):")
+	g.P("\t\tif current == nil {")
+	g.P("\t\t\treturn false, nil")
+	g.P("\t\t}")
+	g.P("\t\treturn true, t.DeleteByID(id)")
+	g.P("\tcase err != nil:")
+	g.P("\t\treturn false, err")
+	g.P("\tcase current != nil && proto.Equal(current.Data, version.Data):")
+	g.P("\t\treturn false, nil")
+	g.P("\t}")
+	g.P("\t_, err = t.UpdateByID(id, version.Data)")
+	g.P("\treturn true, err")
+	g.P("}")
+	g.P()
+	g.P("// RevertTableTo reverts every row changed after atNs with RevertTo and returns")
+	g.P("// how many were written.")
+	g.P("func (t *", model.TableTypeName, ") RevertTableTo(atNs int64) (int64, error) {")
+	g.P("\tids, err := rt.HistoryChangedIDs(t.q, ", historyTableConst, ", atNs)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
+	g.P("\t}")
+	g.P("\tvar reverted int64")
+	g.P("\tfor _, id := range ids {")
+	g.P("\t\twritten, err := t.revertTo(id, atNs)")
+	g.P("\t\tif err != nil {")
+	g.P("\t\t\treturn reverted, fmt.Errorf(\"revert %s/%s: %w\", ", model.GoName, "TableName, id, err)")
+	g.P("\t\t}")
+	g.P("\t\tif written {")
+	g.P("\t\t\treverted++")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\treturn reverted, nil")
+	g.P("}")
+	g.P()
+}
 
-	g.P("\tif err := rt.EnsureManagedIndexes(t.q, ", tableNameConst, ", ", indexPrefixConst, ", []string{")
-	for indexPosition := range model.Indexes {
-		g.P("\t\t", indexCreateConstPrefix, strconv.Itoa(indexPosition+1), ",")
+func (e generatorEmitter) emitApplyWithAtNsMethods(model messageModel, tableNameConst, upsertConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") upsertWithAtNs(id string, atNs int64, data *", model.GoName, ") error {")
+	g.P("\tdefer t.cache.Invalidate(id)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn rt.ErrEmptyID")
+	g.P("\t}")
+	g.P("\tif data == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilData + "\")")
+	g.P("\t}")
+	g.P("\tctx := context.Background()")
+	g.P("\tupsertArgs, err := t.upsertArgs(id, atNs, data)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM _deleted WHERE table_name = ? AND id = ?`, ", tableNameConst, ", id); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"delete tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\t}")
+	g.P("\tif _, err := t.q.ExecContext(ctx, ", upsertConst, ", upsertArgs...); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"upsert into %s: %w\", ", tableNameConst, ", rt.ClassifySQLError(err))")
+	g.P("\t}")
+	e.emitMapProjectionWrites(model, "data", "\t", "")
+	if model.History {
+		g.P("\t// upsertArgs start with id, atNs and the stored data.")
+		g.P("\tdataBytes, _ := upsertArgs[2].([]byte)")
 	}
-	g.P("\t}, []string{")
-	for _, indexModel := range model.Indexes {
-		g.P("\t\t", strconv.Quote(indexModel.IndexName), ",")
+	e.emitRecordHistory(model, "dataBytes", "")
+	g.P("\treturn nil")
+	g.P("}")
+	g.P()
+	g.P("func (t *", model.TableTypeName, ") upsertArgs(id string, atNs int64, data *", model.GoName, ") ([]any, error) {")
+	g.P("\tdataBytes, err := rt.MarshalData(t.opts, data)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"marshal ", model.GoName, ": %w\", err)")
+	g.P("\t}")
+	g.P("\tupsertArgs := []any{", model.writeArgs(), "}")
+	for _, projectedField := range model.ProjectedFields {
+		e.emitProjectedFieldAppend("upsertArgs", "data", projectedField, "\t", "nil, ")
 	}
-	g.P("\t}); err != nil {")
+	g.P("\treturn upsertArgs, nil")
+	g.P("}")
+	g.P()
+	if model.bulkImportable() {
+		e.emitBulkTableMethod(model, tableNameConst, upsertConst)
+	}
+	g.P("func (t *", model.TableTypeName, ") applyRemote(id string, atNs, localMaxAtNs int64, data *", model.GoName, ", strategy rt.ConflictStrategy) error {")
+	g.P("\tif strategy != rt.ConflictMerge {")
+	g.P("\t\treturn t.upsertWithAtNs(id, atNs, data)")
+	g.P("\t}")
+	g.P("\tlocalRows, err := t", model.allTenantsCall(), ".Select(\"id = ?\", id)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"select local ", model.GoName, " %s for merge: %w\", id, err)")
+	g.P("\t}")
+	g.P("\tif len(localRows) == 0 {")
+	g.P("\t\tif atNs < localMaxAtNs {")
+	g.P("\t\t\treturn nil")
+	g.P("\t\t}")
+	g.P("\t\treturn t.upsertWithAtNs(id, atNs, data)")
+	g.P("\t}")
+	g.P("\tmerged, mergedAtNs, err := rt.MergeRemote(t.opts, ", model.GoName, "TypeName, ", model.fieldMergesExpr(), ", localRows[0].Data, localRows[0].AtNs, data, atNs)")
+	g.P("\tif err != nil {")
 	g.P("\t\treturn err")
 	g.P("\t}")
+	g.P("\tmergedData, ok := merged.(*", model.GoName, ")")
+	g.P("\tif !ok {")
+	g.P("\t\treturn fmt.Errorf(\"merge ", model.GoName, " %s: %w\", id, rt.UnknownTypeError(", model.GoName, "TypeName, merged))")
+	g.P("\t}")
+	g.P("\treturn t.upsertWithAtNs(id, mergedAtNs, mergedData)")
+	g.P("}")
+	g.P()
+	if model.VersionVector {
+		e.emitVersionVectorMethods(model, tableNameConst)
+	}
+	g.P("func (t *", model.TableTypeName, ") tombstoneWithAtNs(id string, atNs int64) error {")
+	g.P("\tdefer t.cache.Invalidate(id)")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif id == \"\" {")
+	g.P("\t\treturn rt.ErrEmptyID")
+	g.P("\t}")
+	g.P("\tctx := context.Background()")
+	g.P("\tif _, err := t.q.ExecContext(ctx, `INSERT INTO _deleted (table_name, id, at_ns) VALUES (?, ?, ?) ON CONFLICT(table_name, id) DO UPDATE SET at_ns = excluded.at_ns`, ", tableNameConst, ", id, atNs); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"insert tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\t}")
+	e.emitRemoveRow(model, tableNameConst)
+	g.P("\treturn nil")
+	g.P("}")
+	g.P()
+}
 
-	g.P("\tvar currentSchema string")
-	g.P("\tschemaErr := t.q.QueryRowContext(ctx, `SELECT schema_hash FROM _proprdb_schema WHERE table_name = ?`, ", tableNameConst, ").Scan(&currentSchema)")
-	g.P("\tif errors.Is(schemaErr, sql.ErrNoRows) {")
-	g.P("\t\tif _, insertErr := t.q.ExecContext(ctx, `INSERT INTO _proprdb_schema (table_name, schema_hash) VALUES (?, ?)`, ", tableNameConst, ", ", schemaConst, "); insertErr != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"insert schema hash for %s: %w\", ", tableNameConst, ", insertErr)")
+// emitBulkTableMethod emits the rt.BulkTable used by CRUD.ReadJSONLBulk.
+func (e generatorEmitter) emitBulkTableMethod(model messageModel, tableNameConst, upsertConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") bulkTable(strategy rt.ConflictStrategy) rt.BulkTable {")
+	g.P("\treturn rt.BulkTable{")
+	g.P("\t\tTableName: ", tableNameConst, ",")
+	g.P("\t\tUpsertSQL: ", upsertConst, ",")
+	g.P("\t\tStrategy:  strategy,")
+	g.P("\t\tValues: func(record proprdbJSONLRecord, lineNumber int) ([]any, error) {")
+	g.P("\t\t\tanyMessage := &anypb.Any{}")
+	g.P("\t\t\tif err := protojson.Unmarshal(record.Data, anyMessage); err != nil {")
+	g.P("\t\t\t\treturn nil, rt.Reject(fmt.Errorf(\"unmarshal jsonl data on line %d: %w\", lineNumber, err))")
+	g.P("\t\t\t}")
+	g.P("\t\t\tdata := &", model.GoName, "{}")
+	g.P("\t\t\tif err := anypb.UnmarshalTo(anyMessage, data, proto.UnmarshalOptions{}); err != nil {")
+	g.P("\t\t\t\treturn nil, rt.Reject(fmt.Errorf(\"unmarshal ", model.GoName, " data on line %d: %w\", lineNumber, err))")
+	g.P("\t\t\t}")
+	e.emitRecordMigrations(model, "\t\t\t", "nil, ")
+	e.emitImportValidation(model, "\t\t\t", "nil, ", "t.opts")
+	g.P("\t\t\treturn t.upsertArgs(record.ID, record.AtNs, data)")
+	g.P("\t\t},")
+	g.P("\t}")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitVersionVectorMethods(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") VersionVector(id string) (rt.VersionVector, error) {")
+	g.P("\tversionVector, _, err := rt.ReadVersionVector(t.q, ", tableNameConst, ", id)")
+	g.P("\treturn versionVector, err")
+	g.P("}")
+	g.P()
+	g.P("func (t *", model.TableTypeName, ") applyRemoteVersioned(id string, atNs, localMaxAtNs int64, remoteVersion rt.VersionVector, data *", model.GoName, ", strategy rt.ConflictStrategy) error {")
+	g.P("\tlocalRows, err := t", model.allTenantsCall(), ".Select(\"id = ?\", id)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"select local ", model.GoName, " %s: %w\", id, err)")
+	g.P("\t}")
+	g.P("\tif len(localRows) == 0 || len(remoteVersion) == 0 {")
+	g.P("\t\tif !rt.ShouldApplyRemote(strategy, atNs, localMaxAtNs, false) {")
+	g.P("\t\t\treturn nil")
+	g.P("\t\t}")
+	g.P("\t\tlocalVersion, _, err := rt.ReadVersionVector(t.q, ", tableNameConst, ", id)")
+	g.P("\t\tif err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t\tif err := t.applyRemote(id, atNs, localMaxAtNs, data, strategy); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t\treturn rt.WriteVersionVector(t.q, ", tableNameConst, ", id, localVersion.Merge(remoteVersion))")
+	g.P("\t}")
+	g.P("\tlocalVersion, _, err := rt.ReadVersionVector(t.q, ", tableNameConst, ", id)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tresolution, err := rt.ResolveVersioned(t.opts, strategy, ", model.fieldMergesExpr(), ", rt.ConcurrentEdit{")
+	g.P("\t\tTypeName:      ", model.GoName, "TypeName,")
+	g.P("\t\tID:            id,")
+	g.P("\t\tLocal:         localRows[0].Data,")
+	g.P("\t\tLocalAtNs:     localRows[0].AtNs,")
+	g.P("\t\tLocalVersion:  localVersion,")
+	g.P("\t\tRemote:        data,")
+	g.P("\t\tRemoteAtNs:    atNs,")
+	g.P("\t\tRemoteVersion: remoteVersion,")
+	g.P("\t})")
+	g.P("\tif err != nil || !resolution.Apply {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tresolved, ok := resolution.Data.(*", model.GoName, ")")
+	g.P("\tif !ok {")
+	g.P("\t\treturn fmt.Errorf(\"resolve ", model.GoName, " %s: %w\", id, rt.UnknownTypeError(", model.GoName, "TypeName, resolution.Data))")
+	g.P("\t}")
+	g.P("\tif err := t.upsertWithAtNs(id, resolution.AtNs, resolved); err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\treturn rt.WriteVersionVector(t.q, ", tableNameConst, ", id, resolution.VersionVector)")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitExpireStaleMethod(model messageModel, tableNameConst string) {
+	g := e.g
+	liveCondition := ""
+	if model.SoftDelete {
+		liveCondition = "deleted_at_ns IS NULL"
+	}
+	g.P("// ExpireStale tombstones rows last written more than ", model.GoName, "TTLSeconds ago, so")
+	g.P("// the deletions propagate through sync, and returns how many were expired.")
+	g.P("func (t *", model.TableTypeName, ") ExpireStale() (int64, error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn 0, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tcutoffNs := t.opts.NowNs() - ", model.GoName, "TTLSeconds*int64(time.Second)")
+	g.P("\tids, err := rt.ExpiredIDs(t.q, ", tableNameConst, ", ", strconv.Quote(liveCondition), ", cutoffNs)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
+	g.P("\t}")
+	g.P("\tfor expired, id := range ids {")
+	g.P("\t\tif err := t.DeleteByID(id); err != nil {")
+	g.P("\t\t\treturn int64(expired), fmt.Errorf(\"expire %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\treturn int64(len(ids)), nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitSearchMethods(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") searchFields(data *", model.GoName, ") map[string]string {")
+	g.P("\treturn map[string]string{")
+	for _, field := range model.SearchFields {
+		if field.Repeated {
+			g.P("\t\t", strconv.Quote(field.Name), ": strings.Join(data.", field.GoGetter, "(), \"\\n\"),")
+		} else {
+			g.P("\t\t", strconv.Quote(field.Name), ": data.", field.GoGetter, "(),")
+		}
+	}
+	g.P("\t}")
+	g.P("}")
+	g.P()
+	g.P("func (t *", model.TableTypeName, ") searchDocument(ctx context.Context, id string) (map[string]string, bool, error) {")
+	g.P("\trows, err := t.Select(`id = ?`, id)")
+	g.P("\tif err != nil || len(rows) == 0 {")
+	g.P("\t\treturn nil, false, err")
+	g.P("\t}")
+	g.P("\treturn t.searchFields(rows[0].Data), true, nil")
+	g.P("}")
+	g.P()
+	g.P("// SearchFullText returns the ids of up to limit rows matching query in")
+	g.P("// Options.SearchIndex, best match first. The index is as current as the")
+	g.P("// last CRUD.SyncSearchIndex.")
+	g.P("func (t *", model.TableTypeName, ") SearchFullText(query string, limit int) ([]string, error) {")
+	g.P("\tif t.opts.SearchIndex == nil {")
+	g.P("\t\treturn nil, rt.ErrNoSearchIndex")
+	g.P("\t}")
+	g.P("\thits, err := t.opts.SearchIndex.Search(context.Background(), ", tableNameConst, ", query, limit)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, fmt.Errorf(\"search %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t}")
+	g.P("\treturn rt.SearchIDs(hits), nil")
+	g.P("}")
+	g.P()
+	g.P("// ReindexSearch indexes every row in Options.SearchIndex, e.g. after adding")
+	g.P("// the index to an existing database, and returns how many were indexed.")
+	g.P("func (t *", model.TableTypeName, ") ReindexSearch() (int64, error) {")
+	g.P("\tif t.opts.SearchIndex == nil {")
+	g.P("\t\treturn 0, rt.ErrNoSearchIndex")
+	g.P("\t}")
+	g.P("\trows, err := t.Select(\"\")")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
+	g.P("\t}")
+	g.P("\tfor indexed, row := range rows {")
+	g.P("\t\tif err := t.opts.SearchIndex.Index(context.Background(), ", tableNameConst, ", row.ID, t.searchFields(row.Data)); err != nil {")
+	g.P("\t\t\treturn int64(indexed), fmt.Errorf(\"index %s/%s: %w\", ", tableNameConst, ", row.ID, err)")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\treturn int64(len(rows)), nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitNearestNeighborsMethod(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("// NearestNeighbors returns the ids of up to k rows whose ", model.VectorColumn, " vector is")
+	g.P("// closest to query by cosine distance, nearest first. Rows whose vector has")
+	g.P("// another dimension are skipped. See rt.NearestNeighbors.")
+	g.P("func (t *", model.TableTypeName, ") NearestNeighbors(query []", model.VectorGoType, ", k int) ([]rt.Neighbor, error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	where := ""
+	if model.SoftDelete {
+		where = "deleted_at_ns IS NULL"
+	}
+	if model.TenantColumn != "" {
+		g.P("\twhere, args, err := t.tenant.Where(", model.GoName, "TenantColumn, t.opts.RequireTenant, ", strconv.Quote(where), ", nil)")
+		g.P("\tif err != nil {")
+		g.P("\t\treturn nil, fmt.Errorf(\"nearest neighbors in %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t}")
+		g.P("\treturn rt.NearestNeighbors(t.reader, ", tableNameConst, ", ", strconv.Quote(model.VectorColumn), ", where, args, query, k)")
+	} else {
+		g.P("\treturn rt.NearestNeighbors(t.reader, ", tableNameConst, ", ", strconv.Quote(model.VectorColumn), ", ", strconv.Quote(where), ", nil, query, k)")
+	}
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitGeoMethods(model messageModel, tableNameConst string) {
+	g := e.g
+	lat, lng := strconv.Quote(model.GeoLatColumn), strconv.Quote(model.GeoLngColumn)
+	g.P("// SelectWithinBounds returns the rows located inside bounds.")
+	g.P("func (t *", model.TableTypeName, ") SelectWithinBounds(bounds rt.GeoBounds) ([]", model.RowTypeName, ", error) {")
+	g.P("\twhere, args := bounds.Where(", lat, ", ", lng, ")")
+	g.P("\treturn t.Select(where, args...)")
+	g.P("}")
+	g.P()
+	g.P("// SelectNear returns the rows located within radiusMeters of (lat, lng),")
+	g.P("// nearest first by great-circle distance.")
+	g.P("func (t *", model.TableTypeName, ") SelectNear(lat, lng, radiusMeters float64) ([]", model.RowTypeName, ", error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\thits, err := rt.GeoNear(t.reader, ", tableNameConst, ", ", lat, ", ", lng, ", lat, lng, radiusMeters)")
+	g.P("\tif err != nil || len(hits) == 0 {")
+	g.P("\t\treturn []", model.RowTypeName, "{}, err")
+	g.P("\t}")
+	g.P("\tids := make([]string, 0, len(hits))")
+	g.P("\tfor _, hit := range hits {")
+	g.P("\t\tids = append(ids, hit.ID)")
+	g.P("\t}")
+	g.P("\twhere, args := rt.IDsWhere(ids)")
+	g.P("\trows, err := t.Select(where, args...)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
+	g.P("\t}")
+	g.P("\trt.SortByIDs(rows, ids, func(row ", model.RowTypeName, ") string { return row.ID })")
+	g.P("\treturn rows, nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitRotateEncryptionMethod(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") RotateEncryption() (int64, error) {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn 0, errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\trewritten, err := rt.ReencryptDataColumn(t.q, t.opts, ", tableNameConst, ")")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
+	g.P("\t}")
+	if model.hasEncryptedProjectedFields() {
+		g.P("\tif err := t.reproject(); err != nil {")
+		g.P("\t\treturn rewritten, fmt.Errorf(\"reproject encrypted columns of %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t}")
+	}
+	g.P("\treturn rewritten, nil")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitSchemaHookMethods(model messageModel, tableNameConst string) {
+	g := e.g
+	g.P("// OnSchemaChange registers hook to run when the projection schema of the")
+	g.P("// table changed, before its rows are reprojected by Init, or by")
+	g.P("// ReprojectTable with Options.DeferReprojection. Register hooks before Init.")
+	g.P("func (t *", model.TableTypeName, ") OnSchemaChange(hook rt.SchemaChangeFunc) {")
+	g.P("\tt.schemaHooks = append(t.schemaHooks, hook)")
+	g.P("}")
+	g.P()
+	if len(model.Migrations) == 0 {
+		return
+	}
+	g.P("// migrate applies ", model.GoName, "Migrations, ahead of the OnSchemaChange hooks.")
+	g.P("func (t *", model.TableTypeName, ") migrate(_, _ string, tx DBTX) error {")
+	g.P("\treturn rt.MigrateRows(context.Background(), tx, t.opts, ", tableNameConst, ", &", model.GoName, "{}, ", model.GoName, "Migrations)")
+	g.P("}")
+	g.P()
+}
+
+// schemaHooksExpr is the hooks a table runs when its schema changed.
+func (m messageModel) schemaHooksExpr() string {
+	if len(m.Migrations) == 0 {
+		return "t.schemaHooks"
+	}
+	return "append([]rt.SchemaChangeFunc{t.migrate}, t.schemaHooks...)"
+}
+
+func (e generatorEmitter) emitReprojectMethod(model messageModel, tableNameConst, reprojectConst string) {
+	g := e.g
+	g.P("func (t *", model.TableTypeName, ") reproject() error {")
+	g.P("\tctx := context.Background()")
+	g.P("\tif err := rt.RunSchemaHooks(ctx, t.q, ", tableNameConst, ", ", model.GoName, "ProjectionSchema, ", model.schemaHooksExpr(), "); err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\treturn rt.ReprojectTable(ctx, t.q, ", tableNameConst, ", ", model.GoName, "ProjectionSchema, 0, nil, t.reprojectBatch)")
+	g.P("}")
+	g.P()
+	g.P("// ReprojectTable rebuilds the projection columns of every row in batches of")
+	g.P("// batchSize rows, calling progress after each batch. An interrupted rebuild")
+	g.P("// resumes after its last finished batch when called again.")
+	g.P("func (t *", model.TableTypeName, ") ReprojectTable(ctx context.Context, batchSize int, progress func(done, total int64)) error {")
+	g.P("\tif t.q == nil {")
+	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("\t}")
+	g.P("\tif err := rt.RunSchemaHooks(ctx, t.q, ", tableNameConst, ", ", model.GoName, "ProjectionSchema, ", model.schemaHooksExpr(), "); err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\treturn rt.ReprojectTable(ctx, t.q, ", tableNameConst, ", ", model.GoName, "ProjectionSchema, batchSize, progress, t.reprojectBatch)")
+	g.P("}")
+	g.P()
+	g.P("func (t *", model.TableTypeName, ") reprojectBatch(ctx context.Context, afterID string, limit int) (string, int, error) {")
+	g.P("\trows, err := t.q.QueryContext(ctx, `SELECT id, at_ns, data FROM \"`+", tableNameConst, "+`\" WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn \"\", 0, fmt.Errorf(\"query rows for reprojection: %w\", err)")
+	g.P("\t}")
+	g.P("\ttype reprojectRow struct {")
+	g.P("\t\tid string")
+	g.P("\t\tatNs int64")
+	g.P("\t\tdataBytes []byte")
+	g.P("\t}")
+	g.P("\trowBuffer := make([]reprojectRow, 0, limit)")
+	g.P("\tfor rows.Next() {")
+	g.P("\t\tvar row reprojectRow")
+	g.P("\t\tif err := rows.Scan(&row.id, &row.atNs, &row.dataBytes); err != nil {")
+	g.P("\t\t\tif closeErr := rt.CloseRows(rows, \"reprojection\"); closeErr != nil {")
+	g.P("\t\t\t\treturn \"\", 0, fmt.Errorf(\"scan reprojection row: %w (additionally, %v)\", err, closeErr)")
+	g.P("\t\t\t}")
+	g.P("\t\t\treturn \"\", 0, fmt.Errorf(\"scan reprojection row: %w\", err)")
+	g.P("\t\t}")
+	g.P("\t\trowBuffer = append(rowBuffer, row)")
+	g.P("\t}")
+	g.P("\tif err := rows.Err(); err != nil {")
+	g.P("\t\tif closeErr := rt.CloseRows(rows, \"reprojection\"); closeErr != nil {")
+	g.P("\t\t\treturn \"\", 0, fmt.Errorf(\"iterate reprojection rows: %w (additionally, %v)\", err, closeErr)")
+	g.P("\t\t}")
+	g.P("\t\treturn \"\", 0, fmt.Errorf(\"iterate reprojection rows: %w\", err)")
+	g.P("\t}")
+	g.P("\tif err := rt.CloseRows(rows, \"reprojection\"); err != nil {")
+	g.P("\t\treturn \"\", 0, err")
+	g.P("\t}")
+	g.P("\tfor _, row := range rowBuffer {")
+	g.P("\t\tdata := &", model.GoName, "{}")
+	g.P("\t\tif err := rt.UnmarshalData(t.opts, row.dataBytes, data); err != nil {")
+	g.P("\t\t\treturn \"\", 0, fmt.Errorf(\"unmarshal reprojection row: %w\", err)")
 	g.P("\t\t}")
-	g.P("\t} else if schemaErr != nil {")
-	g.P("\t\treturn fmt.Errorf(\"select schema hash for %s: %w\", ", tableNameConst, ", schemaErr)")
-	g.P("\t} else if currentSchema != ", schemaConst, " {")
 	if len(model.ProjectedFields) > 0 {
-		g.P("\t\tif err := t.reproject(); err != nil {")
-		g.P("\t\t\treturn fmt.Errorf(\"reproject table %s: %w\", ", tableNameConst, ", err)")
+		g.P("\t\treprojectArgs := []any{}")
+		for _, projectedField := range model.ProjectedFields {
+			e.emitProjectedFieldAppend("reprojectArgs", "data", projectedField, "\t\t", "\"\", 0, ")
+		}
+		g.P("\t\treprojectArgs = append(reprojectArgs, row.id, row.atNs)")
+		if len(model.MapProjections) == 0 {
+			g.P("\t\tif _, err := t.q.ExecContext(ctx, ", reprojectConst, ", reprojectArgs...); err != nil {")
+			g.P("\t\t\treturn \"\", 0, fmt.Errorf(\"reproject row %s: %w\", row.id, err)")
+			g.P("\t\t}")
+		} else {
+			g.P("\t\tresult, err := t.q.ExecContext(ctx, ", reprojectConst, ", reprojectArgs...)")
+			g.P("\t\tif err != nil {")
+			g.P("\t\t\treturn \"\", 0, fmt.Errorf(\"reproject row %s: %w\", row.id, err)")
+			g.P("\t\t}")
+			g.P("\t\tif affected, err := result.RowsAffected(); err != nil {")
+			g.P("\t\t\treturn \"\", 0, fmt.Errorf(\"reproject row %s: %w\", row.id, err)")
+			g.P("\t\t} else if affected == 0 {")
+			g.P("\t\t\t// Rewritten since it was read; the write projected it.")
+			g.P("\t\t\tcontinue")
+			g.P("\t\t}")
+		}
+	}
+	for _, projection := range model.MapProjections {
+		g.P("\t\tif err := rt.ReplaceMapEntries(t.q, ", model.GoName, projection.GoName, "TableName, row.id, data.", projection.GetterName, "()); err != nil {")
+		g.P("\t\t\treturn \"\", 0, fmt.Errorf(\"reproject row %s: %w\", row.id, err)")
 		g.P("\t\t}")
 	}
-	g.P("\t\tif _, err := t.q.ExecContext(ctx, `UPDATE _proprdb_schema SET schema_hash = ? WHERE table_name = ?`, ", schemaConst, ", ", tableNameConst, "); err != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"update schema hash for %s: %w\", ", tableNameConst, ", err)")
-	g.P("\t\t}")
 	g.P("\t}")
-	g.P("\tif err := t.drainUnknownRows(", typeNameConst, "); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"drain unknown rows for %s: %w\", ", tableNameConst, ", err)")
+	g.P("\tif len(rowBuffer) == 0 {")
+	g.P("\t\treturn afterID, 0, nil")
 	g.P("\t}")
-	g.P("\treturn nil")
+	g.P("\treturn rowBuffer[len(rowBuffer)-1].id, len(rowBuffer), nil")
 	g.P("}")
 	g.P()
 }
 
-func (e generatorEmitter) emitDrainUnknownMethod(model messageModel, typeNameConst string) {
+func (e generatorEmitter) emitWrapper(models []messageModel) {
 	g := e.g
-	g.P("func (t *", model.TableTypeName, ") drainUnknownRows(typeName string) error {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	syncModels := make([]messageModel, 0, len(models))
+	for _, model := range models {
+		if !model.OmitSync {
+			syncModels = append(syncModels, model)
+		}
+	}
+
+	g.P("type CRUD struct {")
+	for _, model := range models {
+		g.P("\t", model.GoName, " *", model.TableTypeName)
+	}
+	g.P("\topts rt.Options")
+	g.P("\t// diff, when set, counts what readJSONL changes for DiffJSONL.")
+	g.P("\tdiff *rt.JSONLDiff")
+	g.P("}")
+	g.P()
+	g.P("var crudGeneratedTableDescriptors = []rt.GeneratedTableDescriptor{")
+	for _, model := range models {
+		g.P("\t{TableName: ", model.GoName, "TableName, TypeName: ", model.GoName, "TypeName, IsCore: false, SyncEnabled: ", strconv.FormatBool(!model.OmitSync), "},")
+	}
+	g.P("\t{TableName: rt.CoreTableDeletedName, IsCore: true, SyncEnabled: false},")
+	g.P("\t{TableName: rt.CoreTableSyncName, IsCore: true, SyncEnabled: false},")
+	g.P("\t{TableName: rt.CoreTableSchemaStateName, IsCore: true, SyncEnabled: false},")
+	g.P("\t{TableName: rt.CoreTableUnknownName, IsCore: true, SyncEnabled: false},")
+	g.P("\t{TableName: rt.CoreTableRejectedName, IsCore: true, SyncEnabled: false},")
+	g.P("\t{TableName: rt.CoreTableDescriptorsName, IsCore: true, SyncEnabled: false},")
+	g.P("}")
+	g.P()
+	g.P("var _ rt.Bundle = (*CRUD)(nil)")
+	g.P()
+	g.P("// typeURLPrefix is the prefix of the @type of written records, empty for")
+	g.P("// rt.DefaultTypeURLPrefix.")
+	g.P("func (c *CRUD) typeURLPrefix() string {")
+	g.P("\tif c.opts.TypeURLPrefix != \"\" {")
+	g.P("\t\treturn c.opts.TypeURLPrefix")
 	g.P("\t}")
-	g.P("\tif typeName == \"\" {")
-	g.P("\t\treturn errors.New(\"empty type name\")")
+	g.P("\treturn ", strconv.Quote(e.typeURLPrefix))
+	g.P("}")
+	g.P()
+	g.P("// CRUDStore is the API of CRUD, with its tables as Store interfaces. WithTx")
+	g.P("// is left out as its callback takes *CRUD.")
+	g.P("type CRUDStore interface {")
+	g.P("\trt.Bundle")
+	g.P("\tPlanInit() (rt.InitPlan, error)")
+	g.P("\tExpireStale() (int64, error)")
+	g.P("\tWriteJSONLChunks(remote string, w io.Writer, chunkSize int, progress rt.ChunkProgressFunc) error")
+	g.P("\tReadJSONLBulk(remote string, r io.Reader, batchSize int) error")
+	g.P("\tWriteSnapshot(w io.Writer) error")
+	g.P("\tReadSnapshot(r io.Reader) error")
+	g.P("\tBackup(ctx context.Context, w io.Writer) error")
+	g.P("\tRestore(ctx context.Context, r io.Reader) error")
+	for _, model := range models {
+		g.P("\t", model.GoName, "Store() ", model.GoName, "Store")
+	}
+	g.P("}")
+	g.P()
+	g.P("var _ CRUDStore = (*CRUD)(nil)")
+	g.P()
+	g.P("func NewCRUD(q DBTX) *CRUD {")
+	g.P("\treturn NewCRUDWithOptions(q, rt.Options{})")
+	g.P("}")
+	g.P()
+	g.P("func NewCRUDWithOptions(q DBTX, opts rt.Options) *CRUD {")
+	g.P("\tif opts.SyncPolicy.Switches == nil {")
+	g.P("\t\topts.SyncPolicy.Switches = &rt.SyncSwitches{}")
+	g.P("\t}")
+	synced := make([]string, 0, len(models))
+	for _, model := range models {
+		if !model.OmitSync {
+			synced = append(synced, "&"+model.GoName+"{}")
+		}
+	}
+	if len(synced) > 0 {
+		g.P("\tif opts.JSONL.EmbedDescriptors && opts.JSONL.Descriptors == nil {")
+		g.P("\t\topts.JSONL.Descriptors = rt.DescriptorSetOf(", strings.Join(synced, ", "), ")")
+		g.P("\t}")
+	}
+	g.P("\treturn &CRUD{")
+	for _, model := range models {
+		g.P("\t\t", model.GoName, ": New", model.TableTypeName, "WithOptions(q, opts),")
+	}
+	g.P("\t\topts: opts,")
 	g.P("\t}")
-	g.P("\treturn rt.ReplayUnknownByType(t.q, typeName, func(record proprdbJSONLRecord) error {")
-	g.P("\t\tif record.Deleted {")
-	g.P("\t\t\treturn t.tombstoneWithAtNs(record.ID, record.AtNs)")
-	g.P("\t\t}")
-	g.P("\t\tanyMessage := &anypb.Any{}")
-	g.P("\t\tif err := protojson.Unmarshal(record.Data, anyMessage); err != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"unmarshal unknown data for ", model.GoName, " %s: %w\", record.ID, err)")
-	g.P("\t\t}")
-	g.P("\t\tdata := &", model.GoName, "{}")
-	g.P("\t\tif err := anypb.UnmarshalTo(anyMessage, data, proto.UnmarshalOptions{}); err != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"unmarshal unknown payload for ", model.GoName, " %s: %w\", record.ID, err)")
-	g.P("\t\t}")
-	g.P("\t\treturn t.upsertWithAtNs(record.ID, record.AtNs, data)")
-	g.P("\t})")
 	g.P("}")
 	g.P()
-	g.P("func (t *", model.TableTypeName, ") DrainUnknownRows() error {")
-	g.P("\treturn t.drainUnknownRows(", typeNameConst, ")")
+	for _, model := range models {
+		g.P("// ", model.GoName, "Store returns the ", model.GoName, " table as a ", model.GoName, "Store.")
+		g.P("func (c *CRUD) ", model.GoName, "Store() ", model.GoName, "Store {")
+		g.P("\treturn c.", model.GoName)
+		g.P("}")
+		g.P()
+	}
+	g.P("func (c *CRUD) TableDescriptors() []rt.GeneratedTableDescriptor {")
+	g.P("\tcopiedDescriptors := make([]rt.GeneratedTableDescriptor, len(crudGeneratedTableDescriptors))")
+	g.P("\tcopy(copiedDescriptors, crudGeneratedTableDescriptors)")
+	g.P("\treturn copiedDescriptors")
 	g.P("}")
 	g.P()
-}
-
-func (e generatorEmitter) emitSelectMethod(model messageModel, tableNameConst string) {
-	g := e.g
-	g.P("func (t *", model.TableTypeName, ") Select(where string, args ...any) ([]", model.RowTypeName, ", error) {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("func (c *CRUD) dbtx() (DBTX, error) {")
+	g.P("\tif c == nil {")
+	g.P("\t\treturn nil, errors.New(\"nil CRUD\")")
 	g.P("\t}")
-	g.P("\tctx := context.Background()")
-	g.P("\tquery := `SELECT id, at_ns, data FROM \"`+", tableNameConst, "+`\"`")
-	g.P("\tif strings.TrimSpace(where) != \"\" {")
-	g.P("\t\tquery += \" WHERE \" + where")
+	for _, model := range models {
+		g.P("\tif c.", model.GoName, " != nil && c.", model.GoName, ".q != nil {")
+		g.P("\t\treturn c.", model.GoName, ".q, nil")
+		g.P("\t}")
+	}
+	g.P("\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("}")
+	g.P()
+	g.P("// PlanInit reports what Init would change without changing anything, so")
+	g.P("// schema changes can be reviewed before they are applied.")
+	g.P("func (c *CRUD) PlanInit() (rt.InitPlan, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn rt.InitPlan{}, err")
 	g.P("\t}")
-	g.P("\trows, err := t.q.QueryContext(ctx, query, args...)")
+	g.P("\tplan, err := rt.PlanCoreTables(q)")
 	g.P("\tif err != nil {")
-	g.P("\t\treturn nil, fmt.Errorf(\"select from %s: %w\", ", tableNameConst, ", err)")
+	g.P("\t\treturn rt.InitPlan{}, err")
 	g.P("\t}")
-	g.P("\tresult := make([]", model.RowTypeName, ", 0)")
-	g.P("\tfor rows.Next() {")
-	g.P("\t\tvar id string")
-	g.P("\t\tvar atNs int64")
-	g.P("\t\tvar dataBytes []byte")
-	g.P("\t\tif err := rows.Scan(&id, &atNs, &dataBytes); err != nil {")
-	g.P("\t\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
-	g.P("\t\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
-	g.P("\t\t\t}")
-	g.P("\t\t\treturn nil, fmt.Errorf(\"scan row from %s: %w\", ", tableNameConst, ", err)")
-	g.P("\t\t}")
-	g.P("\t\tdata := &", model.GoName, "{}")
-	g.P("\t\tif err := proto.Unmarshal(dataBytes, data); err != nil {")
-	g.P("\t\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
-	g.P("\t\t\t\treturn nil, fmt.Errorf(\"unmarshal ", model.GoName, " row: %w (additionally, %v)\", err, closeErr)")
-	g.P("\t\t\t}")
-	g.P("\t\t\treturn nil, fmt.Errorf(\"unmarshal ", model.GoName, " row: %w\", err)")
+	g.P("\tfor _, table := range []rt.InitPlanner{")
+	for _, model := range models {
+		g.P("\t\tc.", model.GoName, ",")
+	}
+	g.P("\t} {")
+	g.P("\t\ttablePlan, err := table.PlanInit()")
+	g.P("\t\tif err != nil {")
+	g.P("\t\t\treturn rt.InitPlan{}, err")
 	g.P("\t\t}")
-	g.P("\t\tresult = append(result, ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data})")
+	g.P("\t\tplan.Tables = append(plan.Tables, tablePlan)")
 	g.P("\t}")
-	g.P("\tif err := rows.Err(); err != nil {")
-	g.P("\t\tif closeErr := rt.CloseRows(rows, \"select\"); closeErr != nil {")
-	g.P("\t\t\treturn nil, fmt.Errorf(\"iterate rows from %s: %w (additionally, %v)\", ", tableNameConst, ", err, closeErr)")
+	g.P("\treturn plan, nil")
+	g.P("}")
+	g.P()
+	g.P("func (c *CRUD) Init() (err error) {")
+	g.P("\tdefer rt.StartOperation(c.opts.Instrumentation, rt.OperationInit, \"\")(&err)")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tif err := rt.ConfigureSQLite(q, c.opts.SQLite); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"configure sqlite: %w\", err)")
+	g.P("\t}")
+	g.P("\tif err := rt.InitTables(q, c.opts.InitConcurrency, []rt.TableInit{")
+	for _, model := range models {
+		g.P("\t\t{Name: ", strconv.Quote(model.GoName), ", Init: c.", model.GoName, ".initTable},")
+	}
+	g.P("\t}); err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tif err := rt.InitDerivedTables(context.Background(), q, c.opts.DerivedTables...); err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"init derived tables: %w\", err)")
+	g.P("\t}")
+	g.P("\treturn rt.RecordInit(q, time.Now())")
+	g.P("}")
+	g.P()
+	g.P("// Stats reports the size, tombstones, sync lag and schema state of the")
+	g.P("// tables of c, e.g. for a monitoring endpoint served by rt.NewStatsHandler.")
+	g.P("func (c *CRUD) Stats() (rt.Stats, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn rt.Stats{}, err")
+	g.P("\t}")
+	g.P("\tplan, err := c.PlanInit()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn rt.Stats{}, err")
+	g.P("\t}")
+	g.P("\treturn rt.CollectStats(q, crudGeneratedTableDescriptors, plan)")
+	g.P("}")
+	g.P()
+	g.P("// RefreshDerived applies the changes recorded since the last refresh to")
+	g.P("// Options.DerivedTables and returns how many it applied.")
+	g.P("func (c *CRUD) RefreshDerived(ctx context.Context) (int64, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
+	g.P("\t}")
+	g.P("\treturn rt.RefreshDerivedTables(ctx, q, c.opts.DerivedTables...)")
+	g.P("}")
+	g.P()
+	g.P("// WithTx runs fn with a CRUD bound to a new transaction, committing when fn")
+	g.P("// returns nil and rolling back otherwise. Transactions failing because the")
+	g.P("// database is busy or locked are retried per Options.TxRetry, so fn may run")
+	g.P("// more than once. On a CRUD already bound to a *sql.Tx, fn joins it. The")
+	g.P("// row caches of c are purged once the transaction ends.")
+	g.P("func (c *CRUD) WithTx(ctx context.Context, fn func(txCRUD *CRUD) error) error {")
+	g.P("\tif fn == nil {")
+	g.P("\t\treturn errors.New(\"nil fn\")")
+	g.P("\t}")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tdefer c.purgeCaches()")
+	g.P("\treturn rt.WithTxRetry(ctx, q, c.opts.TxRetry, func(tx DBTX) error {")
+	g.P("\t\treturn fn(NewCRUDWithOptions(tx, c.txOptions()))")
+	g.P("\t})")
+	g.P("}")
+	g.P()
+	g.P("// SyncEnabled reports whether typeName is synced. See DisableSync.")
+	g.P("func (c *CRUD) SyncEnabled(typeName string) bool {")
+	g.P("\treturn c.opts.SyncPolicy.Switches.Enabled(typeName)")
+	g.P("}")
+	g.P()
+	g.P("// DisableSync pauses syncing typeName: WriteJSONL skips its rows and")
+	g.P("// tombstones, which stay pending, and ReadJSONL parks its records with the")
+	g.P("// unknown types instead of applying them.")
+	g.P("func (c *CRUD) DisableSync(typeName string) {")
+	g.P("\tc.opts.SyncPolicy.Switches.Disable(typeName)")
+	g.P("}")
+	g.P()
+	g.P("// EnableSync resumes syncing typeName and applies the records parked")
+	g.P("// while it was paused.")
+	g.P("func (c *CRUD) EnableSync(typeName string) error {")
+	g.P("\tc.opts.SyncPolicy.Switches.Enable(typeName)")
+	g.P("\treturn c.drainParkedRows()")
+	g.P("}")
+	g.P()
+	g.P("// SetSyncDefault sets whether types not passed to EnableSync or DisableSync")
+	g.P("// sync. Enabling applies the records parked for them.")
+	g.P("func (c *CRUD) SetSyncDefault(enabled bool) error {")
+	g.P("\tc.opts.SyncPolicy.Switches.SetDefault(enabled)")
+	g.P("\tif !enabled {")
+	g.P("\t\treturn nil")
+	g.P("\t}")
+	g.P("\treturn c.drainParkedRows()")
+	g.P("}")
+	g.P()
+	g.P("// drainParkedRows applies the unknown rows of the enabled synced types.")
+	g.P("func (c *CRUD) drainParkedRows() error {")
+	g.P("\tif c == nil {")
+	g.P("\t\treturn errors.New(\"nil CRUD\")")
+	g.P("\t}")
+	for _, model := range syncModels {
+		g.P("\tif c.opts.SyncPolicy.Switches.Enabled(", model.GoName, "TypeName) {")
+		g.P("\t\tif err := c.", model.GoName, ".drainUnknownRows(", model.GoName, "TypeName); err != nil {")
+		g.P("\t\t\treturn fmt.Errorf(\"drain parked ", model.GoName, " rows: %w\", err)")
+		g.P("\t\t}")
+		g.P("\t}")
+	}
+	g.P("\treturn nil")
+	g.P("}")
+	g.P()
+	g.P("// txOptions returns the options of a CRUD bound to a transaction of c,")
+	g.P("// which reads its own writes and bypasses Options.WriteCoordinator.")
+	g.P("func (c *CRUD) txOptions() rt.Options {")
+	g.P("\topts := c.opts")
+	g.P("\topts.Reader = nil")
+	g.P("\topts.WriteCoordinator = nil")
+	g.P("\treturn opts")
+	g.P("}")
+	g.P()
+	g.P("// purgeCaches drops the cached rows of all tables, after writes that")
+	g.P("// bypassed them.")
+	g.P("func (c *CRUD) purgeCaches() {")
+	for _, model := range models {
+		g.P("\tc.", model.GoName, ".cache.Purge()")
+	}
+	g.P("}")
+	g.P()
+	g.P("var _ rt.Expirer = (*CRUD)(nil)")
+	g.P()
+	g.P("// ExpireStale expires the rows of all tables with (proprdb.ttl_seconds) and")
+	g.P("// returns how many were expired.")
+	g.P("func (c *CRUD) ExpireStale() (int64, error) {")
+	g.P("\tvar expired int64")
+	g.P("\tfor _, table := range []rt.Expirer{")
+	for _, model := range models {
+		if model.TTLSeconds > 0 {
+			g.P("\t\tc.", model.GoName, ",")
+		}
+	}
+	g.P("\t} {")
+	g.P("\t\ttableExpired, err := table.ExpireStale()")
+	g.P("\t\texpired += tableExpired")
+	g.P("\t\tif err != nil {")
+	g.P("\t\t\treturn expired, err")
 	g.P("\t\t}")
-	g.P("\t\treturn nil, fmt.Errorf(\"iterate rows from %s: %w\", ", tableNameConst, ", err)")
 	g.P("\t}")
-	g.P("\tif err := rt.CloseRows(rows, \"select\"); err != nil {")
-	g.P("\t\treturn nil, err")
+	g.P("\treturn expired, nil")
+	g.P("}")
+	g.P()
+	g.P("// CollectBlobGarbage deletes the blobs of Options.BlobStore that no row")
+	g.P("// references and that were stored more than olderThan ago, and returns")
+	g.P("// how many it deleted.")
+	g.P("func (c *CRUD) CollectBlobGarbage(ctx context.Context, olderThan time.Duration) (int, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
 	g.P("\t}")
-	g.P("\treturn result, nil")
+	g.P("\treturn rt.CollectBlobGarbage(ctx, q, c.opts, olderThan)")
 	g.P("}")
 	g.P()
-}
-
-func (e generatorEmitter) emitInsertMethod(model messageModel, tableNameConst, insertConst string) {
-	g := e.g
-	g.P("func (t *", model.TableTypeName, ") Insert(data *", model.GoName, ") (", model.RowTypeName, ", error) {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+	g.P("// SearchPublisher mirrors the change feed of the tables with (proprdb.search)")
+	g.P("// fields into Options.SearchIndex.")
+	g.P("func (c *CRUD) SearchPublisher() rt.SearchPublisher {")
+	g.P("\treturn rt.SearchPublisher{Index: c.opts.SearchIndex, Tables: []rt.SearchTable{")
+	for _, model := range models {
+		if len(model.SearchFields) > 0 {
+			g.P("\t\t{TableName: ", model.GoName, "TableName, Document: c.", model.GoName, ".searchDocument},")
+		}
+	}
+	g.P("\t}}")
+	g.P("}")
+	g.P()
+	g.P("// SyncSearchIndex applies the changes since the last sync to")
+	g.P("// Options.SearchIndex and returns how many it processed.")
+	g.P("func (c *CRUD) SyncSearchIndex(ctx context.Context) (int64, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
 	g.P("\t}")
-	g.P("\tif data == nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("\treturn rt.OutboxDrainer{DB: q, Consumer: rt.SearchIndexConsumer, Publisher: c.SearchPublisher()}.Drain(ctx)")
+	g.P("}")
+	g.P()
+	g.P("func (c *CRUD) WriteJSONL(remote string, w io.Writer) error {")
+	g.P("\treturn c.WriteJSONLChunks(remote, w, 1, nil)")
+	g.P("}")
+	g.P()
+	g.P("// WriteJSONLContext is WriteJSONL stopping once ctx is done.")
+	g.P("func (c *CRUD) WriteJSONLContext(ctx context.Context, remote string, w io.Writer) error {")
+	g.P("\treturn c.WriteJSONLChunksContext(ctx, remote, w, 1, nil)")
+	g.P("}")
+	g.P()
+	g.P("// WriteJSONLChunks writes pending records in chunks of chunkSize and marks")
+	g.P("// _sync after each chunk has been written, so an interrupted export resumes")
+	g.P("// after the last complete chunk.")
+	g.P("func (c *CRUD) WriteJSONLChunks(remote string, w io.Writer, chunkSize int, progress rt.ChunkProgressFunc) error {")
+	g.P("\treturn c.WriteJSONLChunksContext(context.Background(), remote, w, chunkSize, progress)")
+	g.P("}")
+	g.P()
+	g.P("// WriteJSONLChunksContext is WriteJSONLChunks stopping before the next")
+	g.P("// chunk once ctx is done.")
+	g.P("func (c *CRUD) WriteJSONLChunksContext(ctx context.Context, remote string, w io.Writer, chunkSize int, progress rt.ChunkProgressFunc) (err error) {")
+	g.P("\tdefer rt.StartOperation(c.opts.Instrumentation, rt.OperationSyncWrite, \"\")(&err)")
+	g.P("\tif w == nil {")
+	g.P("\t\treturn errors.New(\"nil writer\")")
 	g.P("\t}")
-	g.P("\tid, err := rt.UUIDv7()")
+	g.P("\tq, err := c.dbtx()")
 	g.P("\tif err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"generate uuidv7: %w\", err)")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\tif err := rt.ValidateUUID(id); err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"validate generated id %s: %w\", id, err)")
+	g.P("\tpending, err := c.pendingJSONL(q, remote)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\treturn rt.WriteJSONLChunksContext(ctx, q, remote, w, pending, chunkSize, c.opts.JSONL, rt.CountSyncLinesWritten(c.opts.Instrumentation, progress))")
+	g.P("}")
+	g.P()
+	g.P("// WriteJSONLWithOptions is WriteJSONL configured by exportOpts, reporting")
+	g.P("// records, bytes and the current table to exportOpts.Progress.")
+	g.P("func (c *CRUD) WriteJSONLWithOptions(remote string, w io.Writer, exportOpts rt.ExportOptions) error {")
+	g.P("\treturn c.WriteJSONLWithOptionsContext(context.Background(), remote, w, exportOpts)")
+	g.P("}")
+	g.P()
+	g.P("// WriteJSONLWithOptionsContext is WriteJSONLWithOptions stopping before the")
+	g.P("// next chunk once ctx is done.")
+	g.P("func (c *CRUD) WriteJSONLWithOptionsContext(ctx context.Context, remote string, w io.Writer, exportOpts rt.ExportOptions) (err error) {")
+	g.P("\tdefer rt.StartOperation(c.opts.Instrumentation, rt.OperationSyncWrite, \"\")(&err)")
+	g.P("\tif w == nil {")
+	g.P("\t\treturn errors.New(\"nil writer\")")
+	g.P("\t}")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tpending, err := c.pendingJSONL(q, remote)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tif err := rt.CheckJSONLTables(exportOpts.Tables, jsonlTables); err != nil {")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\treturn t.insertWithID(id, data)")
+	g.P("\texportOpts.Progress = rt.CountSyncRecordsWritten(c.opts.Instrumentation, exportOpts.Progress)")
+	g.P("\treturn rt.WriteJSONLExport(ctx, q, remote, w, pending, c.opts.JSONL, exportOpts)")
 	g.P("}")
 	g.P()
-
-	if model.AllowCustomIDInsert {
-		g.P("func (t *", model.TableTypeName, ") InsertWithID(id string, data *", model.GoName, ") (", model.RowTypeName, ", error) {")
-		g.P("\tif t.q == nil {")
-		g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
-		g.P("\t}")
-		g.P("\tif data == nil {")
-		g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
-		g.P("\t}")
-		g.P("\treturn t.insertWithID(id, data)")
-		g.P("}")
-		g.P()
-	}
-
-	g.P("func (t *", model.TableTypeName, ") insertWithID(id string, data *", model.GoName, ") (", model.RowTypeName, ", error) {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+	g.P("// WriteJSONLTables is WriteJSONL limited to the records of the named tables.")
+	g.P("// The records of other tables stay pending.")
+	g.P("func (c *CRUD) WriteJSONLTables(remote string, w io.Writer, tables []string) error {")
+	g.P("\tif len(tables) == 0 {")
+	g.P("\t\treturn errors.New(\"no tables to write\")")
 	g.P("\t}")
-	g.P("\tif data == nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("\treturn c.WriteJSONLWithOptions(remote, w, rt.ExportOptions{Tables: tables})")
+	g.P("}")
+	g.P()
+	g.P("// WriteJSONLSince writes the records and tombstones changed after cursor,")
+	g.P("// followed by the cursor to pass next time, without using _sync. Cursors")
+	g.P("// are change feed positions, so it needs Options.ChangeFeed. A negative")
+	g.P("// cursor, or one whose changes were trimmed, writes everything.")
+	g.P("func (c *CRUD) WriteJSONLSince(cursor int64, w io.Writer) (err error) {")
+	g.P("\tdefer rt.StartOperation(c.opts.Instrumentation, rt.OperationSyncWrite, \"\")(&err)")
+	g.P("\tif w == nil {")
+	g.P("\t\treturn errors.New(\"nil writer\")")
 	g.P("\t}")
-	g.P("\tif id == \"\" {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errEmptyID+"\")")
+	g.P("\tif !c.opts.ChangeFeed {")
+	g.P("\t\treturn errors.New(\"WriteJSONLSince needs Options.ChangeFeed\")")
 	g.P("\t}")
-	g.P("\tif err := rt.ValidateUUID(id); err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"validate id %s: %w\", id, err)")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	if model.ValidateWrite {
-		g.P("\tif err := data.Valid(); err != nil {")
-		g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"validate ", model.GoName, ": %w\", err)")
-		g.P("\t}")
-	}
-	g.P("\tctx := context.Background()")
-	g.P("\tatNs := rt.NowNs()")
-	g.P("\tdataBytes, err := proto.Marshal(data)")
+	g.P("\t// Read before the records, so that changes racing the export are")
+	g.P("\t// written again next time rather than skipped.")
+	g.P("\tlast, stale, err := rt.ChangeFeedPosition(q, cursor)")
 	g.P("\tif err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"marshal ", model.GoName, ": %w\", err)")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM _deleted WHERE table_name = ? AND id = ?`, ", tableNameConst, ", id); err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"delete tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\tif stale {")
+	g.P("\t\tcursor = -1")
 	g.P("\t}")
-	g.P("\tinsertArgs := []any{id, atNs, dataBytes}")
-	for _, projectedField := range model.ProjectedFields {
-		e.emitProjectedFieldAppend("insertArgs", "data", projectedField, "\t")
-	}
-	g.P("\tif _, err := t.q.ExecContext(ctx, ", insertConst, ", insertArgs...); err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"insert into %s: %w\", ", tableNameConst, ", err)")
+	g.P("\tpending, err := c.pendingJSONLMulti(q, []string{\"\"}, cursor)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\treturn ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data}, nil")
+	g.P("\treturn rt.WriteJSONLSince(w, pending[\"\"], last, c.opts.JSONL)")
 	g.P("}")
 	g.P()
-}
-
-func (e generatorEmitter) emitUpdateMethod(model messageModel, tableNameConst, upsertConst string) {
-	g := e.g
-	g.P("func (t *", model.TableTypeName, ") UpdateByID(id string, data *", model.GoName, ") (", model.RowTypeName, ", error) {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
+	g.P("// WriteJSONLMulti is WriteJSONL to each of remotes, writing to and closing")
+	g.P("// the writer open returns for it. The pending records of all remotes are")
+	g.P("// selected up front with one scan per table and sync condition.")
+	g.P("func (c *CRUD) WriteJSONLMulti(remotes []string, open func(remote string) (io.WriteCloser, error)) (err error) {")
+	g.P("\tdefer rt.StartOperation(c.opts.Instrumentation, rt.OperationSyncWrite, \"\")(&err)")
+	g.P("\tif open == nil {")
+	g.P("\t\treturn errors.New(\"nil open\")")
 	g.P("\t}")
-	g.P("\tif id == \"\" {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errEmptyID+"\")")
+	g.P("\tif err := rt.CheckRemotes(remotes); err != nil {")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\tif err := rt.ValidateUUID(id); err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"validate id %s: %w\", id, err)")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\tif data == nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("\tpending, err := c.pendingJSONLMulti(q, remotes, -1)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	if model.ValidateWrite {
-		g.P("\tif err := data.Valid(); err != nil {")
-		g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"validate ", model.GoName, ": %w\", err)")
+	g.P("\treturn rt.WriteJSONLFanOut(q, remotes, pending, open, c.opts.JSONL, c.opts.Instrumentation)")
+	g.P("}")
+	g.P()
+	g.P("func (c *CRUD) pendingJSONL(q DBTX, remote string) ([]rt.PendingJSONLRecord, error) {")
+	g.P("\tpending, err := c.pendingJSONLMulti(q, []string{remote}, -1)")
+	g.P("\treturn pending[remote], err")
+	g.P("}")
+	g.P()
+	g.P("// pendingJSONLMulti selects the pending records of all remotes changed after")
+	g.P("// the change feed position afterSeq, scanning each table once per distinct")
+	g.P("// sync condition. A negative afterSeq selects all records.")
+	g.P("func (c *CRUD) pendingJSONLMulti(q DBTX, remotes []string, afterSeq int64) (map[string][]rt.PendingJSONLRecord, error) {")
+	g.P("\tpending := make(map[string][]rt.PendingJSONLRecord, len(remotes))")
+	for _, model := range syncModels {
+		syncedVar := strings.ToLower(model.GoName) + "Synced"
+		g.P("\t", syncedVar, ", err := rt.LoadSyncedAtNs(q, ", model.GoName, "TableName, remotes)")
+		g.P("\tif err != nil {")
+		g.P("\t\treturn nil, err")
+		g.P("\t}")
+		g.P("\tfor _, scan := range rt.SyncScans(c.opts.SyncPolicy, remotes, ", model.GoName, "TypeName, ", model.syncFiltersExpr(), ") {")
+		g.P("\t\twhere, args := rt.WhereChangedAfter(scan.Where, ", model.GoName, "TableName, afterSeq)")
+		g.P("\t\trows, err := c.", model.GoName, model.allTenantsCall(), ".Select(where, args...)")
+		g.P("\t\tif err != nil {")
+		g.P("\t\t\treturn nil, fmt.Errorf(\"select ", model.GoName, " rows for jsonl write: %w\", err)")
+		g.P("\t\t}")
+		g.P("\t\tfor _, row := range rows {")
+		g.P("\t\t\t// Marshaled on first use and shared by the remotes of the scan.")
+		g.P("\t\t\tvar record *proprdbJSONLRecord")
+		g.P("\t\t\tfor _, remote := range scan.Remotes {")
+		g.P("\t\t\t\tif !rt.SyncAllows(c.opts.SyncPolicy, remote, ", model.GoName, "TypeName, row.Data) {")
+		g.P("\t\t\t\t\tcontinue")
+		g.P("\t\t\t\t}")
+		if model.TenantColumn != "" {
+			g.P("\t\t\t\tif !rt.SyncAllowsTenant(c.opts.SyncPolicy, remote, row.Data.", model.TenantGetter, "()) {")
+			g.P("\t\t\t\t\tcontinue")
+			g.P("\t\t\t\t}")
+		}
+		g.P("\t\t\t\tif !", syncedVar, ".NeedsSend(remote, row.ID, row.AtNs) {")
+		g.P("\t\t\t\t\tcontinue")
+		g.P("\t\t\t\t}")
+		g.P("\t\t\t\tif record == nil {")
+		g.P("\t\t\t\t\tdataJSON, err := rt.MarshalAnyJSONWithPrefix(c.typeURLPrefix(), row.Data)")
+		g.P("\t\t\t\t\tif err != nil {")
+		g.P("\t\t\t\t\t\treturn nil, fmt.Errorf(\"marshal ", model.GoName, " %s for jsonl write: %w\", row.ID, err)")
+		g.P("\t\t\t\t\t}")
+		g.P("\t\t\t\t\trecord = &proprdbJSONLRecord{ID: row.ID, AtNs: row.AtNs, Data: dataJSON}")
+		if model.VersionVector {
+			g.P("\t\t\t\t\trecord.VersionVector, err = c.", model.GoName, ".VersionVector(row.ID)")
+			g.P("\t\t\t\t\tif err != nil {")
+			g.P("\t\t\t\t\t\treturn nil, err")
+			g.P("\t\t\t\t\t}")
+		}
+		g.P("\t\t\t\t}")
+		g.P("\t\t\t\tpending[remote] = append(pending[remote], rt.PendingJSONLRecord{TableName: ", model.GoName, "TableName, Record: *record})")
+		g.P("\t\t\t}")
+		g.P("\t\t}")
 		g.P("\t}")
 	}
-	g.P("\tctx := context.Background()")
-	g.P("\tatNs := rt.NowNs()")
-	g.P("\tdataBytes, err := proto.Marshal(data)")
-	g.P("\tif err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"marshal ", model.GoName, ": %w\", err)")
-	g.P("\t}")
-	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM _deleted WHERE table_name = ? AND id = ?`, ", tableNameConst, ", id); err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"delete tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
-	g.P("\t}")
-	g.P("\tupdateArgs := []any{id, atNs, dataBytes}")
-	for _, projectedField := range model.ProjectedFields {
-		e.emitProjectedFieldAppend("updateArgs", "data", projectedField, "\t")
+	for _, model := range syncModels {
+		syncedVar := strings.ToLower(model.GoName) + "Synced"
+		tombstonesVar := strings.ToLower(model.GoName) + "Tombstones"
+		changedVar := strings.ToLower(model.GoName) + "Changed"
+		g.P("\tvar ", tombstonesVar, " []rt.Tombstone")
+		g.P("\tvar ", changedVar, " map[string]bool")
+		g.P("\tfor _, remote := range remotes {")
+		g.P("\t\tif !rt.SyncIncludesType(c.opts.SyncPolicy, remote, ", model.GoName, "TypeName) {")
+		g.P("\t\t\tcontinue")
+		g.P("\t\t}")
+		g.P("\t\tif ", tombstonesVar, " == nil {")
+		g.P("\t\t\t", tombstonesVar, ", err = rt.ListTombstones(q, ", model.GoName, "TableName)")
+		g.P("\t\t\tif err != nil {")
+		g.P("\t\t\t\treturn nil, err")
+		g.P("\t\t\t}")
+		g.P("\t\t\tif afterSeq >= 0 {")
+		g.P("\t\t\t\t", changedVar, ", err = rt.ChangedIDs(q, ", model.GoName, "TableName, afterSeq)")
+		g.P("\t\t\t\tif err != nil {")
+		g.P("\t\t\t\t\treturn nil, err")
+		g.P("\t\t\t\t}")
+		g.P("\t\t\t}")
+		g.P("\t\t}")
+		g.P("\t\tfor _, tombstone := range ", tombstonesVar, " {")
+		g.P("\t\t\tif (afterSeq >= 0 && !", changedVar, "[tombstone.ID]) || !", syncedVar, ".NeedsSend(remote, tombstone.ID, tombstone.AtNs) {")
+		g.P("\t\t\t\tcontinue")
+		g.P("\t\t\t}")
+		g.P("\t\t\tdataJSON, err := rt.MarshalTypeOnlyAnyJSONWithPrefix(c.typeURLPrefix(), ", model.GoName, "TypeName)")
+		g.P("\t\t\tif err != nil {")
+		g.P("\t\t\t\treturn nil, fmt.Errorf(\"marshal tombstone %s/%s for jsonl write: %w\", ", model.GoName, "TableName, tombstone.ID, err)")
+		g.P("\t\t\t}")
+		g.P("\t\t\trecord := proprdbJSONLRecord{ID: tombstone.ID, Deleted: true, AtNs: tombstone.AtNs, Data: dataJSON}")
+		g.P("\t\t\tpending[remote] = append(pending[remote], rt.PendingJSONLRecord{TableName: ", model.GoName, "TableName, Record: record})")
+		g.P("\t\t}")
+		g.P("\t}")
 	}
-	g.P("\tif _, err := t.q.ExecContext(ctx, ", upsertConst, ", updateArgs...); err != nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, fmt.Errorf(\"upsert into %s: %w\", ", tableNameConst, ", err)")
-	g.P("\t}")
-	g.P("\treturn ", model.RowTypeName, "{ID: id, AtNs: atNs, Data: data}, nil")
+	g.P("\treturn pending, nil")
 	g.P("}")
 	g.P()
-
-	g.P("func (t *", model.TableTypeName, ") UpdateRow(row ", model.RowTypeName, ") (", model.RowTypeName, ", error) {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilDBTX+"\")")
-	g.P("\t}")
-	g.P("\tif row.ID == \"\" {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errEmptyID+"\")")
-	g.P("\t}")
-	g.P("\tif row.Data == nil {")
-	g.P("\t\treturn ", model.RowTypeName, "{}, errors.New(\""+errNilData+"\")")
+	g.P("// ReadJSONL imports the records of r, handling bad records according to")
+	g.P("// Options.Import.")
+	g.P("func (c *CRUD) ReadJSONL(remote string, r io.Reader) error {")
+	g.P("\t_, err := c.ReadJSONLWithOptions(remote, r, c.opts.Import)")
+	g.P("\treturn err")
+	g.P("}")
+	g.P()
+	g.P("// ReadJSONLContext is ReadJSONL stopping before the next record once ctx")
+	g.P("// is done.")
+	g.P("func (c *CRUD) ReadJSONLContext(ctx context.Context, remote string, r io.Reader) error {")
+	g.P("\t_, err := c.readJSONLWithOptions(ctx, remote, r, c.opts.Import)")
+	g.P("\treturn err")
+	g.P("}")
+	g.P()
+	g.P("// ReadJSONLTables is ReadJSONL applying only the records of the named")
+	g.P("// tables and skipping the others.")
+	g.P("func (c *CRUD) ReadJSONLTables(remote string, r io.Reader, tables []string) error {")
+	g.P("\tif len(tables) == 0 {")
+	g.P("\t\treturn errors.New(\"no tables to read\")")
 	g.P("\t}")
-	g.P("\treturn t.UpdateByID(row.ID, row.Data)")
+	g.P("\timportOpts := c.opts.Import")
+	g.P("\timportOpts.Tables = tables")
+	g.P("\t_, err := c.ReadJSONLWithOptions(remote, r, importOpts)")
+	g.P("\treturn err")
 	g.P("}")
 	g.P()
-}
-
-func (e generatorEmitter) emitDeleteMethod(model messageModel, tableNameConst string) {
-	g := e.g
-	g.P("func (t *", model.TableTypeName, ") DeleteByID(id string) error {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("// ReadJSONLWithOptions is ReadJSONL with importOpts in place of")
+	g.P("// Options.Import. It returns the bad records it skipped or quarantined.")
+	g.P("func (c *CRUD) ReadJSONLWithOptions(remote string, r io.Reader, importOpts rt.ImportOptions) (rt.ImportReport, error) {")
+	g.P("\treturn c.readJSONLWithOptions(context.Background(), remote, r, importOpts)")
+	g.P("}")
+	g.P()
+	g.P("// ReadJSONLWithOptionsContext is ReadJSONLWithOptions stopping before the")
+	g.P("// next record once ctx is done.")
+	g.P("func (c *CRUD) ReadJSONLWithOptionsContext(ctx context.Context, remote string, r io.Reader, importOpts rt.ImportOptions) (rt.ImportReport, error) {")
+	g.P("\treturn c.readJSONLWithOptions(ctx, remote, r, importOpts)")
+	g.P("}")
+	g.P()
+	g.P("func (c *CRUD) readJSONLWithOptions(ctx context.Context, remote string, r io.Reader, importOpts rt.ImportOptions) (report rt.ImportReport, err error) {")
+	g.P("\tdefer rt.StartOperation(c.opts.Instrumentation, rt.OperationSyncRead, \"\")(&err)")
+	g.P("\tif r == nil {")
+	g.P("\t\treturn rt.ImportReport{}, errors.New(\"nil reader\")")
 	g.P("\t}")
-	g.P("\tif id == \"\" {")
-	g.P("\t\treturn errors.New(\"" + errEmptyID + "\")")
+	g.P("\tif err := rt.CheckJSONLTables(importOpts.Tables, jsonlTables); err != nil {")
+	g.P("\t\treturn rt.ImportReport{}, err")
 	g.P("\t}")
-	g.P("\tctx := context.Background()")
-	g.P("\tatNs := rt.NowNs()")
-	g.P("\tif _, err := t.q.ExecContext(ctx, `INSERT INTO _deleted (table_name, id, at_ns) VALUES (?, ?, ?) ON CONFLICT(table_name, id) DO UPDATE SET at_ns = excluded.at_ns`, ", tableNameConst, ", id, atNs); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"insert tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn rt.ImportReport{}, err")
 	g.P("\t}")
-	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM \"`+", tableNameConst, "+`\" WHERE id = ?`, id); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"delete from %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\tif c.opts.JSONL.RequiresManifest() {")
+	g.P("\t\t// Import nothing unless the manifest checks out.")
+	g.P("\t\tdefer c.purgeCaches()")
+	g.P("\t\terr = rt.InTx(q, func(tx DBTX) error {")
+	g.P("\t\t\tcrud := NewCRUDWithOptions(tx, c.txOptions())")
+	g.P("\t\t\ttx = rt.ObserveDBTX(tx, c.opts.EffectiveQueryObserver())")
+	g.P("\t\t\tguard := rt.NewImportGuard(tx, importOpts, remote, c.opts.Clock)")
+	g.P("\t\t\tdefer func() { report = guard.Report }()")
+	g.P("\t\t\treturn crud.readJSONL(ctx, tx, remote, r, nil, guard)")
+	g.P("\t\t})")
+	g.P("\t\treturn report, err")
 	g.P("\t}")
-	g.P("\treturn nil")
+	g.P("\tguard := rt.NewImportGuard(q, importOpts, remote, c.opts.Clock)")
+	g.P("\terr = c.readJSONL(ctx, q, remote, r, nil, guard)")
+	g.P("\treturn guard.Report, err")
 	g.P("}")
 	g.P()
-
-	g.P("func (t *", model.TableTypeName, ") DeleteRow(row ", model.RowTypeName, ") error {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("// ReadJSONLBulk is ReadJSONL for large imports. It runs in one transaction,")
+	g.P("// begun on the CRUD's DBTX unless that is a transaction already, writes up")
+	g.P("// to batchSize records per table with multi-row statements and writes _sync")
+	g.P("// once at the end. Rows of tables using merge, version vectors, soft delete")
+	g.P("// or map projections are applied one by one within the same transaction.")
+	g.P("func (c *CRUD) ReadJSONLBulk(remote string, r io.Reader, batchSize int) error {")
+	g.P("\t_, err := c.ReadJSONLBulkWithOptions(remote, r, batchSize, c.opts.Import)")
+	g.P("\treturn err")
+	g.P("}")
+	g.P()
+	g.P("// ReadJSONLBulkContext is ReadJSONLBulk stopping before the next record")
+	g.P("// once ctx is done, which rolls back the whole import.")
+	g.P("func (c *CRUD) ReadJSONLBulkContext(ctx context.Context, remote string, r io.Reader, batchSize int) error {")
+	g.P("\t_, err := c.readJSONLBulkWithOptions(ctx, remote, r, batchSize, c.opts.Import)")
+	g.P("\treturn err")
+	g.P("}")
+	g.P()
+	g.P("// ReadJSONLBulkWithOptions is ReadJSONLBulk with importOpts in place of")
+	g.P("// Options.Import. It returns the bad records it skipped or quarantined.")
+	g.P("func (c *CRUD) ReadJSONLBulkWithOptions(remote string, r io.Reader, batchSize int, importOpts rt.ImportOptions) (rt.ImportReport, error) {")
+	g.P("\treturn c.readJSONLBulkWithOptions(context.Background(), remote, r, batchSize, importOpts)")
+	g.P("}")
+	g.P()
+	g.P("func (c *CRUD) readJSONLBulkWithOptions(ctx context.Context, remote string, r io.Reader, batchSize int, importOpts rt.ImportOptions) (report rt.ImportReport, err error) {")
+	g.P("\tdefer rt.StartOperation(c.opts.Instrumentation, rt.OperationSyncRead, \"\")(&err)")
+	g.P("\tif r == nil {")
+	g.P("\t\treturn rt.ImportReport{}, errors.New(\"nil reader\")")
 	g.P("\t}")
-	g.P("\tif row.ID == \"\" {")
-	g.P("\t\treturn errors.New(\"" + errEmptyID + "\")")
+	g.P("\tif err := rt.CheckJSONLTables(importOpts.Tables, jsonlTables); err != nil {")
+	g.P("\t\treturn rt.ImportReport{}, err")
 	g.P("\t}")
-	g.P("\treturn t.DeleteByID(row.ID)")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn rt.ImportReport{}, err")
+	g.P("\t}")
+	g.P("\tdefer c.purgeCaches()")
+	g.P("\terr = rt.InTx(q, func(tx DBTX) error {")
+	g.P("\t\tcrud := NewCRUDWithOptions(tx, c.txOptions())")
+	g.P("\t\ttx = rt.ObserveDBTX(tx, c.opts.EffectiveQueryObserver())")
+	g.P("\t\tguard := rt.NewImportGuard(tx, importOpts, remote, c.opts.Clock)")
+	g.P("\t\tdefer func() { report = guard.Report }()")
+	g.P("\t\timporter := rt.NewBulkImporter(tx, remote, batchSize)")
+	g.P("\t\timporter.Instrumentation = c.opts.Instrumentation")
+	g.P("\t\timporter.Guard = guard")
+	for _, model := range models {
+		if !model.bulkImportable() {
+			continue
+		}
+		g.P("\t\tif strategy := rt.ConflictStrategyFor(c.opts, ", model.GoName, "TypeName, ", model.GoName, "ConflictStrategy); strategy != rt.ConflictMerge {")
+		g.P("\t\t\timporter.AddTable(", model.GoName, "TypeName, crud.", model.GoName, ".bulkTable(strategy))")
+		g.P("\t\t}")
+	}
+	g.P("\t\treturn crud.readJSONL(ctx, tx, remote, r, importer, guard)")
+	g.P("\t})")
+	g.P("\treturn report, err")
 	g.P("}")
 	g.P()
-}
-
-func (e generatorEmitter) emitApplyWithAtNsMethods(model messageModel, tableNameConst, upsertConst string) {
-	g := e.g
-	g.P("func (t *", model.TableTypeName, ") upsertWithAtNs(id string, atNs int64, data *", model.GoName, ") error {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("// PreviewJSONL returns, by table name, how many records WriteJSONL would")
+	g.P("// export to remote, without exporting them.")
+	g.P("func (c *CRUD) PreviewJSONL(remote string) (map[string]int64, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
 	g.P("\t}")
-	g.P("\tif id == \"\" {")
-	g.P("\t\treturn errors.New(\"" + errEmptyID + "\")")
+	g.P("\tpending, err := c.pendingJSONL(q, remote)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
 	g.P("\t}")
-	g.P("\tif data == nil {")
-	g.P("\t\treturn errors.New(\"" + errNilData + "\")")
+	g.P("\tcounts := make(map[string]int64)")
+	g.P("\tfor _, item := range pending {")
+	g.P("\t\tcounts[item.TableName]++")
 	g.P("\t}")
-	g.P("\tctx := context.Background()")
-	g.P("\tdataBytes, err := proto.Marshal(data)")
+	g.P("\treturn counts, nil")
+	g.P("}")
+	g.P()
+	g.P("// DiffJSONL reports what ReadJSONL of r would change, by applying it in a")
+	g.P("// transaction that is rolled back.")
+	g.P("func (c *CRUD) DiffJSONL(r io.Reader) (rt.JSONLDiff, error) {")
+	g.P("\tif r == nil {")
+	g.P("\t\treturn rt.JSONLDiff{}, errors.New(\"nil reader\")")
+	g.P("\t}")
+	g.P("\tq, err := c.dbtx()")
 	g.P("\tif err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"marshal ", model.GoName, ": %w\", err)")
+	g.P("\t\treturn rt.JSONLDiff{}, err")
 	g.P("\t}")
-	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM _deleted WHERE table_name = ? AND id = ?`, ", tableNameConst, ", id); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"delete tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\tdiff := rt.JSONLDiff{Tables: make(map[string]rt.SyncChanges)}")
+	g.P("\terr = rt.DryRun(q, func(tx DBTX) error {")
+	g.P("\t\topts := c.txOptions()")
+	g.P("\t\topts.Instrumentation = nil")
+	g.P("\t\tcrud := NewCRUDWithOptions(tx, opts)")
+	g.P("\t\tcrud.diff = &diff")
+	g.P("\t\ttx = rt.ObserveDBTX(tx, c.opts.EffectiveQueryObserver())")
+	g.P("\t\treturn crud.readJSONL(context.Background(), tx, \"\", r, nil, rt.NewImportGuard(tx, c.opts.Import, \"\", c.opts.Clock))")
+	g.P("\t})")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn rt.JSONLDiff{}, err")
 	g.P("\t}")
-	g.P("\tupsertArgs := []any{id, atNs, dataBytes}")
-	for _, projectedField := range model.ProjectedFields {
-		e.emitProjectedFieldAppend("upsertArgs", "data", projectedField, "\t")
-	}
-	g.P("\tif _, err := t.q.ExecContext(ctx, ", upsertConst, ", upsertArgs...); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"upsert into %s: %w\", ", tableNameConst, ", err)")
+	g.P("\treturn diff, nil")
+	g.P("}")
+	g.P()
+	g.P("// ListRejected returns the imported records quarantined in _rejected.")
+	g.P("func (c *CRUD) ListRejected() ([]rt.RejectedRecord, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn nil, err")
 	g.P("\t}")
-	g.P("\treturn nil")
+	g.P("\treturn rt.ListRejected(q)")
 	g.P("}")
 	g.P()
-	g.P("func (t *", model.TableTypeName, ") tombstoneWithAtNs(id string, atNs int64) error {")
-	g.P("\tif t.q == nil {")
-	g.P("\t\treturn errors.New(\"" + errNilDBTX + "\")")
+	g.P("// RetryRejected imports the quarantined records again, with their remote,")
+	g.P("// and returns how many were not rejected again. Records that still fail")
+	g.P("// stay quarantined with their new error.")
+	g.P("func (c *CRUD) RetryRejected() (retried int, err error) {")
+	g.P("\tdefer rt.StartOperation(c.opts.Instrumentation, rt.OperationSyncRead, \"\")(&err)")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
 	g.P("\t}")
-	g.P("\tif id == \"\" {")
-	g.P("\t\treturn errors.New(\"" + errEmptyID + "\")")
+	g.P("\tdefer c.purgeCaches()")
+	g.P("\terr = rt.InTx(q, func(tx DBTX) error {")
+	g.P("\t\topts := c.txOptions()")
+	g.P("\t\t// Quarantined lines are single plain records without a manifest.")
+	g.P("\t\topts.JSONL = rt.JSONLOptions{}")
+	g.P("\t\tcrud := NewCRUDWithOptions(tx, opts)")
+	g.P("\t\ttx = rt.ObserveDBTX(tx, c.opts.EffectiveQueryObserver())")
+	g.P("\t\tretried, err = rt.RetryRejected(tx, func(remote string, r io.Reader) error {")
+	g.P("\t\t\treturn crud.readJSONL(context.Background(), tx, remote, r, nil, rt.NewImportGuard(tx, rt.ImportOptions{OnError: rt.ImportQuarantine}, remote, c.opts.Clock))")
+	g.P("\t\t})")
+	g.P("\t\treturn err")
+	g.P("\t})")
+	g.P("\treturn retried, err")
+	g.P("}")
+	g.P()
+	g.P("// PurgeRejected deletes the quarantined records with the given ids, or all")
+	g.P("// of them when none are given, and returns how many it deleted.")
+	g.P("func (c *CRUD) PurgeRejected(ids ...int64) (int64, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn 0, err")
 	g.P("\t}")
-	g.P("\tctx := context.Background()")
-	g.P("\tif _, err := t.q.ExecContext(ctx, `INSERT INTO _deleted (table_name, id, at_ns) VALUES (?, ?, ?) ON CONFLICT(table_name, id) DO UPDATE SET at_ns = excluded.at_ns`, ", tableNameConst, ", id, atNs); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"insert tombstone for %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\treturn rt.PurgeRejected(q, ids...)")
+	g.P("}")
+	g.P()
+	g.P("// EvictUnknown evicts the oldest rows of _unknown_types beyond")
+	g.P("// Options.UnknownRetention, writing them to archive as JSONL first when it")
+	g.P("// is not nil.")
+	g.P("func (c *CRUD) EvictUnknown(archive io.Writer) (rt.UnknownEviction, error) {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn rt.UnknownEviction{}, err")
 	g.P("\t}")
-	g.P("\tif _, err := t.q.ExecContext(ctx, `DELETE FROM \"`+", tableNameConst, "+`\" WHERE id = ?`, id); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"delete from %s/%s: %w\", ", tableNameConst, ", id, err)")
+	g.P("\teviction, err := rt.EvictUnknown(q, c.opts.UnknownRetention, c.opts.NowNs(), archive, c.opts.JSONL)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn eviction, err")
 	g.P("\t}")
-	g.P("\treturn nil")
+	g.P("\trt.RecordUnknownEviction(c.opts.Instrumentation, eviction)")
+	g.P("\treturn eviction, nil")
 	g.P("}")
 	g.P()
-}
-
-func (e generatorEmitter) emitReprojectMethod(model messageModel, tableNameConst, reprojectConst string) {
-	g := e.g
-	g.P("func (t *", model.TableTypeName, ") reproject() error {")
-	g.P("\tctx := context.Background()")
-	g.P("\trows, err := t.q.QueryContext(ctx, `SELECT id, data FROM \"`+", tableNameConst, "+`\"`)")
+	g.P("// WriteUnknownJSONL writes the rows of _unknown_types as JSONL, for")
+	g.P("// replicas that know their types.")
+	g.P("func (c *CRUD) WriteUnknownJSONL(w io.Writer) error {")
+	g.P("\tq, err := c.dbtx()")
 	g.P("\tif err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"query rows for reprojection: %w\", err)")
+	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\ttype reprojectRow struct {")
-	g.P("\t\tid string")
-	g.P("\t\tdataBytes []byte")
+	g.P("\treturn rt.WriteUnknownJSONL(q, w, c.opts.JSONL)")
+	g.P("}")
+	g.P()
+	g.P("// jsonlTables maps the synced type names to their tables, which")
+	g.P("// rt.ExportOptions.Tables and rt.ImportOptions.Tables select.")
+	g.P("var jsonlTables = map[string]string{")
+	for _, model := range syncModels {
+		g.P("\t", model.GoName, "TypeName: ", model.GoName, "TableName,")
+	}
+	g.P("}")
+	g.P()
+	if slices.ContainsFunc(syncModels, func(model messageModel) bool { return len(model.JSONRenames) > 0 }) {
+		g.P("// jsonlRenamedFields maps type names to the (proprdb.renamed_from) names of")
+		g.P("// their fields and the JSON names the fields have now.")
+		g.P("var jsonlRenamedFields = map[string]map[string]string{")
+		for _, model := range syncModels {
+			if len(model.JSONRenames) == 0 {
+				continue
+			}
+			g.P("\t", model.GoName, "TypeName: {")
+			for _, oldName := range slices.Sorted(maps.Keys(model.JSONRenames)) {
+				g.P("\t\t", strconv.Quote(oldName), ": ", strconv.Quote(model.JSONRenames[oldName]), ",")
+			}
+			g.P("\t},")
+		}
+		g.P("}")
+		g.P()
+	}
+	g.P("// readJSONL applies records one by one, or queues them in importer when it")
+	g.P("// batches their table. Bad records are left to guard.")
+	g.P("func (c *CRUD) readJSONL(ctx context.Context, q DBTX, remote string, r io.Reader, importer *rt.BulkImporter, guard *rt.ImportGuard) error {")
+	g.P("\t// Dry runs count records of other packages as unknown.")
+	g.P("\tvar dispatch *rt.RegisteredDispatch")
+	g.P("\tif c.diff == nil {")
+	g.P("\t\tdispatch = rt.NewRegisteredDispatch(q, c.opts, remote)")
 	g.P("\t}")
-	g.P("\trowBuffer := make([]reprojectRow, 0)")
-	g.P("\tfor rows.Next() {")
-	g.P("\t\tvar id string")
-	g.P("\t\tvar dataBytes []byte")
-	g.P("\t\tif err := rows.Scan(&id, &dataBytes); err != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"scan reprojection row: %w\", err)")
+	g.P("\treadErr := rt.ReadJSONLGuarded(ctx, r, c.opts.JSONL, guard, func(record proprdbJSONLRecord, lineNumber int) error {")
+	g.P("\t\tif record.ID == \"\" {")
+	g.P("\t\t\treturn rt.Reject(fmt.Errorf(\"jsonl line %d has empty id\", lineNumber))")
+	g.P("\t\t}")
+	g.P("\t\tif len(record.Data) == 0 {")
+	g.P("\t\t\treturn rt.Reject(fmt.Errorf(\"jsonl line %d has empty data\", lineNumber))")
+	g.P("\t\t}")
+	g.P("\t\ttypeName, err := rt.TypeNameFromAnyJSON(record.Data)")
+	g.P("\t\tif err != nil {")
+	g.P("\t\t\treturn rt.Reject(fmt.Errorf(\"read @type on line %d: %w\", lineNumber, err))")
+	g.P("\t\t}")
+	g.P("\t\tif !guard.Includes(jsonlTables[typeName]) {")
+	g.P("\t\t\treturn nil")
+	g.P("\t\t}")
+	if slices.ContainsFunc(syncModels, func(model messageModel) bool { return len(model.JSONRenames) > 0 }) {
+		g.P("\t\tif renames := jsonlRenamedFields[typeName]; renames != nil {")
+		g.P("\t\t\tif record.Data, err = rt.RenameJSONFields(record.Data, renames); err != nil {")
+		g.P("\t\t\t\treturn rt.Reject(fmt.Errorf(\"read renamed fields on line %d: %w\", lineNumber, err))")
+		g.P("\t\t\t}")
+		g.P("\t\t}")
+	}
+	g.P("\t\tif !c.opts.SyncPolicy.Switches.Enabled(typeName) {")
+	g.P("\t\t\t// Park the record until EnableSync replays it.")
+	g.P("\t\t\tc.diff.ObserveUnknown()")
+	g.P("\t\t\trt.RecordSyncUnknown(c.opts.Instrumentation, typeName)")
+	g.P("\t\t\treturn rt.UnknownInsert(q, typeName, record)")
+	g.P("\t\t}")
+	g.P("\t\tif queued, err := importer.Queue(typeName, record, lineNumber); queued || err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t\tswitch typeName {")
+	for _, model := range models {
+		g.P("\t\tcase ", model.GoName, "TypeName:")
+		if model.OmitSync {
+			g.P("\t\t\tslog.Error(\"ignoring unsynced jsonl record\", \"type\", typeName, \"id\", record.ID, \"remote\", remote, \"line\", lineNumber)")
+			g.P("\t\t\treturn nil")
+			continue
+		}
+		g.P("\t\t\tif c.", model.GoName, " == nil {")
+		g.P("\t\t\t\treturn errors.New(\"nil ", model.GoName, " table\")")
+		g.P("\t\t\t}")
+		g.P("\t\t\tlocalMaxAtNs, err := rt.LocalMaxAtNs(q, ", model.GoName, "TableName, record.ID)")
+		g.P("\t\t\tif err != nil {")
+		g.P("\t\t\t\treturn err")
+		g.P("\t\t\t}")
+		g.P("\t\t\trt.RecordSyncRead(c.opts.Instrumentation, ", model.GoName, "TableName, record.AtNs, localMaxAtNs)")
+		g.P("\t\t\tif err := importer.SyncUpsert(q, record.ID, ", model.GoName, "TableName, remote, record.AtNs); err != nil {")
+		g.P("\t\t\t\treturn err")
+		g.P("\t\t\t}")
+		g.P("\t\t\tstrategy := rt.ConflictStrategyFor(c.", model.GoName, ".opts, ", model.GoName, "TypeName, ", model.GoName, "ConflictStrategy)")
+		if model.VersionVector {
+			g.P("\t\t\tapplies := !record.Deleted || rt.ShouldApplyRemote(strategy, record.AtNs, localMaxAtNs, record.Deleted)")
+		} else {
+			g.P("\t\t\tapplies := rt.ShouldApplyRemote(strategy, record.AtNs, localMaxAtNs, record.Deleted)")
+		}
+		g.P("\t\t\tif err := c.diff.Observe(q, ", model.GoName, "TableName, record, applies); err != nil {")
+		g.P("\t\t\t\treturn err")
+		g.P("\t\t\t}")
+		g.P("\t\t\tif !applies {")
+		g.P("\t\t\t\treturn nil")
+		g.P("\t\t\t}")
+		g.P("\t\t\tif record.Deleted {")
+		g.P("\t\t\t\treturn c.", model.GoName, ".tombstoneWithAtNs(record.ID, record.AtNs)")
+		g.P("\t\t\t}")
+		g.P("\t\t\tanyMessage := &anypb.Any{}")
+		g.P("\t\t\tif err := protojson.Unmarshal(record.Data, anyMessage); err != nil {")
+		g.P("\t\t\t\treturn rt.Reject(fmt.Errorf(\"unmarshal jsonl data on line %d: %w\", lineNumber, err))")
+		g.P("\t\t\t}")
+		g.P("\t\t\tdata := &", model.GoName, "{}")
+		g.P("\t\t\tif err := anypb.UnmarshalTo(anyMessage, data, proto.UnmarshalOptions{}); err != nil {")
+		g.P("\t\t\t\treturn rt.Reject(fmt.Errorf(\"unmarshal ", model.GoName, " data on line %d: %w\", lineNumber, err))")
+		g.P("\t\t\t}")
+		e.emitRecordMigrations(model, "\t\t\t", "")
+		e.emitImportValidation(model, "\t\t\t", "", "c.opts")
+		if model.VersionVector {
+			g.P("\t\t\treturn c.", model.GoName, ".applyRemoteVersioned(record.ID, record.AtNs, localMaxAtNs, record.VersionVector, data, strategy)")
+		} else {
+			g.P("\t\t\treturn c.", model.GoName, ".applyRemote(record.ID, record.AtNs, localMaxAtNs, data, strategy)")
+		}
+	}
+	g.P("\t\tdefault:")
+	g.P("\t\t\tif dispatched, err := dispatch.Queue(typeName, record); dispatched || err != nil {")
+	g.P("\t\t\t\treturn err")
+	g.P("\t\t\t}")
+	g.P("\t\t\tc.diff.ObserveUnknown()")
+	g.P("\t\t\trt.RecordSyncUnknown(c.opts.Instrumentation, typeName)")
+	g.P("\t\t\treturn rt.UnknownInsert(q, typeName, record)")
 	g.P("\t\t}")
-	g.P("\t\tcopiedData := make([]byte, len(dataBytes))")
-	g.P("\t\tcopy(copiedData, dataBytes)")
-	g.P("\t\trowBuffer = append(rowBuffer, reprojectRow{id: id, dataBytes: copiedData})")
-	g.P("\t}")
-	g.P("\tif err := rows.Err(); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"iterate reprojection rows: %w\", err)")
+	g.P("\t})")
+	g.P("\tif readErr == nil {")
+	g.P("\t\treadErr = importer.Flush()")
 	g.P("\t}")
-	g.P("\tif err := rows.Close(); err != nil {")
-	g.P("\t\treturn fmt.Errorf(\"close reprojection rows: %w\", err)")
+	g.P("\tif readErr == nil {")
+	g.P("\t\treadErr = dispatch.Flush()")
 	g.P("\t}")
-	g.P("\tfor _, row := range rowBuffer {")
-	g.P("\t\tdata := &", model.GoName, "{}")
-	g.P("\t\tif err := proto.Unmarshal(row.dataBytes, data); err != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"unmarshal reprojection row: %w\", err)")
-	g.P("\t\t}")
-	g.P("\t\treprojectArgs := []any{}")
-	for _, projectedField := range model.ProjectedFields {
-		e.emitProjectedFieldAppend("reprojectArgs", "data", projectedField, "\t\t")
-	}
-	g.P("\t\treprojectArgs = append(reprojectArgs, row.id)")
-	g.P("\t\tif _, err := t.q.ExecContext(ctx, ", reprojectConst, ", reprojectArgs...); err != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"reproject row %s: %w\", row.id, err)")
+	g.P("\tcompactErr := rt.CompactUnknownLatest(q)")
+	g.P("\tif readErr != nil {")
+	g.P("\t\tif compactErr != nil {")
+	g.P("\t\t\treturn fmt.Errorf(\"read jsonl: %w (additionally, compact unknown rows: %v)\", readErr, compactErr)")
 	g.P("\t\t}")
+	g.P("\t\treturn readErr")
+	g.P("\t}")
+	g.P("\tif compactErr != nil {")
+	g.P("\t\treturn fmt.Errorf(\"compact unknown rows: %w\", compactErr)")
 	g.P("\t}")
 	g.P("\treturn nil")
 	g.P("}")
 	g.P()
+	e.emitSnapshotMethods(models)
 }
 
-func (e generatorEmitter) emitWrapper(models []messageModel) {
+func (e generatorEmitter) emitSnapshotMethods(models []messageModel) {
 	g := e.g
-	syncModels := make([]messageModel, 0, len(models))
-	for _, model := range models {
-		if !model.OmitSync {
-			syncModels = append(syncModels, model)
-		}
-	}
-
-	g.P("type CRUD struct {")
-	for _, model := range models {
-		g.P("\t", model.GoName, " *", model.TableTypeName)
-	}
-	g.P("}")
-	g.P()
-	g.P("var crudGeneratedTableDescriptors = []rt.GeneratedTableDescriptor{")
-	for _, model := range models {
-		g.P("\t{TableName: ", model.GoName, "TableName, TypeName: ", model.GoName, "TypeName, IsCore: false, SyncEnabled: ", strconv.FormatBool(!model.OmitSync), "},")
-	}
-	g.P("\t{TableName: rt.CoreTableDeletedName, IsCore: true, SyncEnabled: false},")
-	g.P("\t{TableName: rt.CoreTableSyncName, IsCore: true, SyncEnabled: false},")
-	g.P("\t{TableName: rt.CoreTableSchemaStateName, IsCore: true, SyncEnabled: false},")
-	g.P("\t{TableName: rt.CoreTableUnknownName, IsCore: true, SyncEnabled: false},")
-	g.P("}")
-	g.P()
-	g.P("func NewCRUD(q DBTX) *CRUD {")
-	g.P("\treturn &CRUD{")
-	for _, model := range models {
-		g.P("\t\t", model.GoName, ": New", model.TableTypeName, "(q),")
-	}
-	g.P("\t}")
-	g.P("}")
-	g.P()
-	g.P("func (c *CRUD) TableDescriptors() []rt.GeneratedTableDescriptor {")
-	g.P("\tcopiedDescriptors := make([]rt.GeneratedTableDescriptor, len(crudGeneratedTableDescriptors))")
-	g.P("\tcopy(copiedDescriptors, crudGeneratedTableDescriptors)")
-	g.P("\treturn copiedDescriptors")
-	g.P("}")
-	g.P()
-	g.P("func (c *CRUD) dbtx() (DBTX, error) {")
-	g.P("\tif c == nil {")
-	g.P("\t\treturn nil, errors.New(\"nil CRUD\")")
-	g.P("\t}")
-	for _, model := range models {
-		g.P("\tif c.", model.GoName, " != nil && c.", model.GoName, ".q != nil {")
-		g.P("\t\treturn c.", model.GoName, ".q, nil")
-		g.P("\t}")
-	}
-	g.P("\treturn nil, errors.New(\"" + errNilDBTX + "\")")
+	g.P("// WriteSnapshot writes the complete state, including tombstones and rows")
+	g.P("// of unknown types, without touching _sync. Use a transaction-backed CRUD")
+	g.P("// for a consistent snapshot.")
+	g.P("func (c *CRUD) WriteSnapshot(w io.Writer) error {")
+	g.P("\treturn c.writeSnapshot(w, false)")
 	g.P("}")
 	g.P()
-	g.P("func (c *CRUD) Init() error {")
-	for _, model := range models {
-		g.P("\tif err := c.", model.GoName, ".Init(); err != nil {")
-		g.P("\t\treturn fmt.Errorf(\"init ", model.GoName, " table: %w\", err)")
-		g.P("\t}")
-	}
-	g.P("\treturn nil")
+	g.P("// WriteAdminJSONL writes a snapshot that also carries the rows of")
+	g.P("// rt.AdminCoreTables, such as _sync, so ReadAdminJSONL reconstructs the")
+	g.P("// sync state of this replica, e.g. to reproduce a support case.")
+	g.P("func (c *CRUD) WriteAdminJSONL(w io.Writer) error {")
+	g.P("\treturn c.writeSnapshot(w, true)")
 	g.P("}")
 	g.P()
-	g.P("func (c *CRUD) WriteJSONL(remote string, w io.Writer) error {")
+	g.P("func (c *CRUD) writeSnapshot(w io.Writer, admin bool) error {")
 	g.P("\tif w == nil {")
 	g.P("\t\treturn errors.New(\"nil writer\")")
 	g.P("\t}")
@@ -1003,98 +5807,99 @@ func (e generatorEmitter) emitWrapper(models []messageModel) {
 	g.P("\tif err != nil {")
 	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\tencoder := json.NewEncoder(w)")
-	for _, model := range syncModels {
-		g.P("\t", strings.ToLower(model.GoName), "Rows, err := c.", model.GoName, ".Select(\"\")")
+	for _, model := range models {
+		prefix := strings.ToLower(model.GoName)
+		if model.SoftDelete {
+			g.P("\t", prefix, "Rows, err := c.", model.GoName, model.allTenantsCall(), ".SelectIncludingDeleted(\"\")")
+		} else {
+			g.P("\t", prefix, "Rows, err := c.", model.GoName, model.allTenantsCall(), ".Select(\"\")")
+		}
 		g.P("\tif err != nil {")
-		g.P("\t\treturn fmt.Errorf(\"select ", model.GoName, " rows for jsonl write: %w\", err)")
-		g.P("\t}")
-		g.P("\tfor _, row := range ", strings.ToLower(model.GoName), "Rows {")
-		g.P("\t\tneedsSend, err := rt.SyncNeedsSend(q, row.ID, ", model.GoName, "TableName, remote, row.AtNs)")
-		g.P("\t\tif err != nil {")
-		g.P("\t\t\treturn err")
-		g.P("\t\t}")
-		g.P("\t\tif !needsSend {")
-		g.P("\t\t\tcontinue")
-		g.P("\t\t}")
-		g.P("\t\tdataJSON, err := rt.MarshalAnyJSON(row.Data)")
-		g.P("\t\tif err != nil {")
-		g.P("\t\t\treturn fmt.Errorf(\"marshal ", model.GoName, " %s for jsonl write: %w\", row.ID, err)")
-		g.P("\t\t}")
-		g.P("\t\trecord := proprdbJSONLRecord{ID: row.ID, AtNs: row.AtNs, Data: dataJSON}")
-		g.P("\t\tif err := encoder.Encode(record); err != nil {")
-		g.P("\t\t\treturn fmt.Errorf(\"write jsonl row for ", model.GoName, " %s: %w\", row.ID, err)")
-		g.P("\t\t}")
-		g.P("\t\tif err := rt.SyncUpsert(q, row.ID, ", model.GoName, "TableName, remote, row.AtNs); err != nil {")
-		g.P("\t\t\treturn err")
-		g.P("\t\t}")
+		g.P("\t\treturn fmt.Errorf(\"select ", model.GoName, " rows for snapshot: %w\", err)")
 		g.P("\t}")
-	}
-	if len(syncModels) > 0 {
-		tableNameCases := make([]string, 0, len(syncModels))
-		for _, model := range syncModels {
-			tableNameCases = append(tableNameCases, model.GoName+"TableName")
-		}
-		placeholders := strings.TrimRight(strings.Repeat("?,", len(syncModels)), ",")
-		g.P("\ttombstoneRows, err := q.QueryContext(context.Background(), `SELECT table_name, id, at_ns FROM _deleted WHERE table_name IN ("+placeholders+")`, ", strings.Join(tableNameCases, ", "), ")")
+		g.P("\t", prefix, "Tombstones, err := rt.ListTombstones(q, ", model.GoName, "TableName)")
 		g.P("\tif err != nil {")
-		g.P("\t\treturn fmt.Errorf(\"select tombstones for jsonl write: %w\", err)")
+		g.P("\t\treturn err")
 		g.P("\t}")
-		g.P("\tfor tombstoneRows.Next() {")
-		g.P("\t\tvar tableName string")
-		g.P("\t\tvar id string")
-		g.P("\t\tvar atNs int64")
-		g.P("\t\tif err := tombstoneRows.Scan(&tableName, &id, &atNs); err != nil {")
-		g.P("\t\t\tif closeErr := rt.CloseRows(tombstoneRows, \"tombstone sync\"); closeErr != nil {")
-		g.P("\t\t\t\treturn fmt.Errorf(\"scan tombstone row: %w (additionally, %v)\", err, closeErr)")
-		g.P("\t\t\t}")
-		g.P("\t\t\treturn fmt.Errorf(\"scan tombstone row: %w\", err)")
-		g.P("\t\t}")
-		g.P("\t\tneedsSend, err := rt.SyncNeedsSend(q, id, tableName, remote, atNs)")
+	}
+	g.P("\tunknownRecords, err := rt.ListUnknownRecords(q)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tvar coreTables []string")
+	g.P("\tvar coreRows []rt.CoreRow")
+	g.P("\tif admin {")
+	g.P("\t\tcoreTables = rt.AdminCoreTables")
+	g.P("\t\tif coreRows, err = rt.ListCoreRows(q); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\tsnapshot, err := rt.NewSnapshotWriter(w, rt.SnapshotHeader{")
+	g.P("\t\tCreatedAtNs: c.opts.NowNs(),")
+	g.P("\t\tTables: []rt.SnapshotTable{")
+	for _, model := range models {
+		prefix := strings.ToLower(model.GoName)
+		g.P("\t\t\t{TableName: ", model.GoName, "TableName, TypeName: ", model.GoName, "TypeName, SchemaHash: ", model.GoName, "ProjectionSchema, Rows: int64(len(", prefix, "Rows)), Tombstones: int64(len(", prefix, "Tombstones))},")
+	}
+	g.P("\t\t},")
+	g.P("\t\tUnknownRecords: int64(len(unknownRecords)),")
+	g.P("\t\tCoreTables:     coreTables,")
+	g.P("\t\tCoreRows:       int64(len(coreRows)),")
+	g.P("\t\tTypeURLPrefix:  c.typeURLPrefix(),")
+	g.P("\t})")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	for _, model := range models {
+		prefix := strings.ToLower(model.GoName)
+		g.P("\tfor _, row := range ", prefix, "Rows {")
+		g.P("\t\tdataJSON, err := rt.MarshalAnyJSONWithPrefix(c.typeURLPrefix(), row.Data)")
 		g.P("\t\tif err != nil {")
-		g.P("\t\t\treturn err")
-		g.P("\t\t}")
-		g.P("\t\tif !needsSend {")
-		g.P("\t\t\tcontinue")
+		g.P("\t\t\treturn fmt.Errorf(\"marshal ", model.GoName, " %s for snapshot: %w\", row.ID, err)")
 		g.P("\t\t}")
-		g.P("\t\tvar typeName string")
-		g.P("\t\tswitch tableName {")
-		for _, model := range syncModels {
-			g.P("\t\tcase ", model.GoName, "TableName:")
-			g.P("\t\t\ttypeName = ", model.GoName, "TypeName")
+		g.P("\t\trecord := proprdbJSONLRecord{ID: row.ID, AtNs: row.AtNs, Data: dataJSON}")
+		if model.VersionVector {
+			g.P("\t\trecord.VersionVector, err = c.", model.GoName, ".VersionVector(row.ID)")
+			g.P("\t\tif err != nil {")
+			g.P("\t\t\treturn err")
+			g.P("\t\t}")
 		}
-		g.P("\t\tdefault:")
-		g.P("\t\t\treturn fmt.Errorf(\"unsupported tombstone table %s\", tableName)")
-		g.P("\t\t}")
-		g.P("\t\tdataJSON, err := rt.MarshalTypeOnlyAnyJSON(typeName)")
-		g.P("\t\tif err != nil {")
-		g.P("\t\t\treturn fmt.Errorf(\"marshal tombstone %s/%s for jsonl write: %w\", tableName, id, err)")
-		g.P("\t\t}")
-		g.P("\t\trecord := proprdbJSONLRecord{ID: id, Deleted: true, AtNs: atNs, Data: dataJSON}")
-		g.P("\t\tif err := encoder.Encode(record); err != nil {")
-		g.P("\t\t\treturn fmt.Errorf(\"write jsonl tombstone %s/%s: %w\", tableName, id, err)")
-		g.P("\t\t}")
-		g.P("\t\tif err := rt.SyncUpsert(q, id, tableName, remote, atNs); err != nil {")
-		g.P("\t\t\tif closeErr := rt.CloseRows(tombstoneRows, \"tombstone sync\"); closeErr != nil {")
-		g.P("\t\t\t\treturn fmt.Errorf(\"sync tombstone %s/%s: %w (additionally, %v)\", tableName, id, err, closeErr)")
-		g.P("\t\t\t}")
+		g.P("\t\tif err := snapshot.WriteRecord(record); err != nil {")
 		g.P("\t\t\treturn err")
 		g.P("\t\t}")
 		g.P("\t}")
-		g.P("\tif err := tombstoneRows.Err(); err != nil {")
-		g.P("\t\tif closeErr := rt.CloseRows(tombstoneRows, \"tombstone sync\"); closeErr != nil {")
-		g.P("\t\t\treturn fmt.Errorf(\"iterate tombstone rows: %w (additionally, %v)\", err, closeErr)")
-		g.P("\t\t}")
-		g.P("\t\treturn fmt.Errorf(\"iterate tombstone rows: %w\", err)")
-		g.P("\t}")
-		g.P("\tif err := rt.CloseRows(tombstoneRows, \"tombstone sync\"); err != nil {")
+		g.P("\tif err := snapshot.WriteTombstones(", model.GoName, "TypeName, ", prefix, "Tombstones); err != nil {")
 		g.P("\t\treturn err")
 		g.P("\t}")
 	}
-	g.P("\treturn nil")
+	g.P("\tfor _, record := range unknownRecords {")
+	g.P("\t\tif err := snapshot.WriteRecord(record); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\tfor _, row := range coreRows {")
+	g.P("\t\tif err := snapshot.WriteCoreRow(row); err != nil {")
+	g.P("\t\t\treturn err")
+	g.P("\t\t}")
+	g.P("\t}")
+	g.P("\treturn snapshot.Close()")
 	g.P("}")
 	g.P()
-	g.P("func (c *CRUD) ReadJSONL(remote string, r io.Reader) error {")
+
+	g.P("// ReadSnapshot restores a snapshot written by WriteSnapshot. Records older")
+	g.P("// than local state are skipped and _sync is not touched.")
+	g.P("func (c *CRUD) ReadSnapshot(r io.Reader) error {")
+	g.P("\treturn c.readSnapshot(r, false)")
+	g.P("}")
+	g.P()
+	g.P("// ReadAdminJSONL restores a snapshot written by WriteAdminJSONL like")
+	g.P("// ReadSnapshot, and replaces the rows of its rt.AdminCoreTables. Use a")
+	g.P("// transaction-backed CRUD to restore all or nothing.")
+	g.P("func (c *CRUD) ReadAdminJSONL(r io.Reader) error {")
+	g.P("\treturn c.readSnapshot(r, true)")
+	g.P("}")
+	g.P()
+	g.P("func (c *CRUD) readSnapshot(r io.Reader, admin bool) error {")
 	g.P("\tif r == nil {")
 	g.P("\t\treturn errors.New(\"nil reader\")")
 	g.P("\t}")
@@ -1102,75 +5907,203 @@ func (e generatorEmitter) emitWrapper(models []messageModel) {
 	g.P("\tif err != nil {")
 	g.P("\t\treturn err")
 	g.P("\t}")
-	g.P("\treadErr := rt.ReadJSONL(r, func(record proprdbJSONLRecord, lineNumber int) error {")
+	g.P("\tvar core rt.DBTX")
+	g.P("\tif admin {")
+	g.P("\t\tcore = q")
+	g.P("\t}")
+	g.P("\tschemaHashes := map[string]string{")
+	for _, model := range models {
+		g.P("\t\t", model.GoName, "TypeName: ", model.GoName, "ProjectionSchema,")
+	}
+	g.P("\t}")
+	g.P("\t_, err = rt.ReadSnapshotWithCore(r, schemaHashes, func(record proprdbJSONLRecord, lineNumber int) error {")
 	g.P("\t\tif record.ID == \"\" {")
-	g.P("\t\t\treturn fmt.Errorf(\"jsonl line %d has empty id\", lineNumber)")
-	g.P("\t\t}")
-	g.P("\t\tif len(record.Data) == 0 {")
-	g.P("\t\t\treturn fmt.Errorf(\"jsonl line %d has empty data\", lineNumber)")
+	g.P("\t\t\treturn fmt.Errorf(\"snapshot line %d has empty id\", lineNumber)")
 	g.P("\t\t}")
 	g.P("\t\ttypeName, err := rt.TypeNameFromAnyJSON(record.Data)")
 	g.P("\t\tif err != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"read @type on line %d: %w\", lineNumber, err)")
+	g.P("\t\t\treturn fmt.Errorf(\"read @type on snapshot line %d: %w\", lineNumber, err)")
 	g.P("\t\t}")
 	g.P("\t\tswitch typeName {")
 	for _, model := range models {
 		g.P("\t\tcase ", model.GoName, "TypeName:")
-		if model.OmitSync {
-			g.P("\t\t\tslog.Error(\"ignoring unsynced jsonl record\", \"type\", typeName, \"id\", record.ID, \"remote\", remote, \"line\", lineNumber)")
-			g.P("\t\t\treturn nil")
-			continue
-		}
+		g.P("\t\t\tif c.", model.GoName, " == nil {")
+		g.P("\t\t\t\treturn errors.New(\"nil ", model.GoName, " table\")")
+		g.P("\t\t\t}")
 		g.P("\t\t\tlocalMaxAtNs, err := rt.LocalMaxAtNs(q, ", model.GoName, "TableName, record.ID)")
 		g.P("\t\t\tif err != nil {")
 		g.P("\t\t\t\treturn err")
 		g.P("\t\t\t}")
-		g.P("\t\t\tif err := rt.SyncUpsert(q, record.ID, ", model.GoName, "TableName, remote, record.AtNs); err != nil {")
-		g.P("\t\t\t\treturn err")
-		g.P("\t\t\t}")
 		g.P("\t\t\tif record.AtNs < localMaxAtNs {")
 		g.P("\t\t\t\treturn nil")
 		g.P("\t\t\t}")
 		g.P("\t\t\tif record.Deleted {")
-		g.P("\t\t\t\tif c.", model.GoName, " == nil {")
-		g.P("\t\t\t\t\treturn errors.New(\"nil ", model.GoName, " table\")")
-		g.P("\t\t\t\t}")
 		g.P("\t\t\t\treturn c.", model.GoName, ".tombstoneWithAtNs(record.ID, record.AtNs)")
 		g.P("\t\t\t}")
-		g.P("\t\t\tif c.", model.GoName, " == nil {")
-		g.P("\t\t\t\treturn errors.New(\"nil ", model.GoName, " table\")")
-		g.P("\t\t\t}")
 		g.P("\t\t\tanyMessage := &anypb.Any{}")
 		g.P("\t\t\tif err := protojson.Unmarshal(record.Data, anyMessage); err != nil {")
-		g.P("\t\t\t\treturn fmt.Errorf(\"unmarshal jsonl data on line %d: %w\", lineNumber, err)")
+		g.P("\t\t\t\treturn fmt.Errorf(\"unmarshal snapshot data on line %d: %w\", lineNumber, err)")
 		g.P("\t\t\t}")
 		g.P("\t\t\tdata := &", model.GoName, "{}")
 		g.P("\t\t\tif err := anypb.UnmarshalTo(anyMessage, data, proto.UnmarshalOptions{}); err != nil {")
 		g.P("\t\t\t\treturn fmt.Errorf(\"unmarshal ", model.GoName, " data on line %d: %w\", lineNumber, err)")
 		g.P("\t\t\t}")
-		g.P("\t\t\treturn c.", model.GoName, ".upsertWithAtNs(record.ID, record.AtNs, data)")
+		e.emitRecordMigrations(model, "\t\t\t", "")
+		if model.VersionVector {
+			g.P("\t\t\tif err := c.", model.GoName, ".upsertWithAtNs(record.ID, record.AtNs, data); err != nil {")
+			g.P("\t\t\t\treturn err")
+			g.P("\t\t\t}")
+			g.P("\t\t\treturn rt.WriteVersionVector(q, ", model.GoName, "TableName, record.ID, record.VersionVector)")
+		} else {
+			g.P("\t\t\treturn c.", model.GoName, ".upsertWithAtNs(record.ID, record.AtNs, data)")
+		}
 	}
 	g.P("\t\tdefault:")
-	g.P("\t\t\treturn rt.UnknownInsert(q, typeName, record)")
-	g.P("\t\t}")
-	g.P("\t})")
-	g.P("\tcompactErr := rt.CompactUnknownLatest(q)")
-	g.P("\tif readErr != nil {")
-	g.P("\t\tif compactErr != nil {")
-	g.P("\t\t\treturn fmt.Errorf(\"read jsonl: %w (additionally, compact unknown rows: %v)\", readErr, compactErr)")
+	g.P("\t\t\treturn rt.RestoreUnknown(q, typeName, record)")
 	g.P("\t\t}")
-	g.P("\t\treturn readErr")
-	g.P("\t}")
-	g.P("\tif compactErr != nil {")
-	g.P("\t\treturn fmt.Errorf(\"compact unknown rows: %w\", compactErr)")
+	g.P("\t}, core)")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn fmt.Errorf(\"read snapshot: %w\", err)")
 	g.P("\t}")
 	g.P("\treturn nil")
 	g.P("}")
 	g.P()
+
+	g.P("// Backup writes a copy of the whole SQLite database to w with rt.Backup,")
+	g.P("// without blocking writers. The CRUD must not be transaction-backed.")
+	g.P("func (c *CRUD) Backup(ctx context.Context, w io.Writer) error {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\treturn rt.Backup(ctx, q, w)")
+	g.P("}")
+	g.P()
+	g.P("// Restore replaces the whole SQLite database with a backup written by Backup,")
+	g.P("// using rt.Restore. Call Init afterwards if the backup may predate the schema.")
+	g.P("func (c *CRUD) Restore(ctx context.Context, r io.Reader) error {")
+	g.P("\tq, err := c.dbtx()")
+	g.P("\tif err != nil {")
+	g.P("\t\treturn err")
+	g.P("\t}")
+	g.P("\tdefer c.purgeCaches()")
+	g.P("\treturn rt.Restore(ctx, q, r)")
+	g.P("}")
+	g.P()
+}
+
+func (e generatorEmitter) emitRegistration(file *protogen.File) {
+	g := e.g
+	g.P("func init() {")
+	g.P("\trt.RegisterTables(", strconv.Quote(string(file.GoImportPath)), ", crudGeneratedTableDescriptors, func(q rt.DBTX, opts rt.Options) rt.Bundle {")
+	g.P("\t\treturn NewCRUDWithOptions(q, opts)")
+	g.P("\t})")
+	g.P("}")
+	g.P()
+}
+
+// httpColumns lists the projected columns REST list requests may filter on:
+// encrypted and bytes columns are left out.
+func (m messageModel) httpColumns() []proprdbrt.HTTPColumn {
+	columns := make([]proprdbrt.HTTPColumn, 0, len(m.ProjectedFields))
+	for _, projectedField := range m.ProjectedFields {
+		if projectedField.Encrypted || projectedField.SQLiteType == "BLOB" {
+			continue
+		}
+		columns = append(columns, proprdbrt.HTTPColumn{Name: projectedField.ColumnName, SQLiteType: projectedField.SQLiteType})
+	}
+	return columns
+}
+
+// filterColumns lists the projected columns AIP-160 filters may compare,
+// the same columns as httpColumns.
+func (m messageModel) filterColumns() []proprdbrt.FilterColumn {
+	columns := make([]proprdbrt.FilterColumn, 0, len(m.ProjectedFields))
+	for _, projectedField := range m.ProjectedFields {
+		if projectedField.Encrypted || projectedField.SQLiteType == "BLOB" {
+			continue
+		}
+		field := projectedField.Path
+		if field == "" {
+			field = projectedField.ProtoFieldName
+		}
+		columns = append(columns, proprdbrt.FilterColumn{
+			Field:      field,
+			Column:     projectedField.ColumnName,
+			SQLiteType: projectedField.SQLiteType,
+			Nullable:   projectedField.IsOptional,
+			Timestamp:  projectedField.Timestamp,
+		})
+	}
+	return columns
+}
+
+// httpPath is the REST collection path of the message: its name in
+// snake_case, e.g. "/external_note" for ExternalNote.
+func (m messageModel) httpPath() string {
+	builder := strings.Builder{}
+	for index, character := range m.GoName {
+		if character >= 'A' && character <= 'Z' {
+			if index > 0 {
+				builder.WriteByte('_')
+			}
+			character += 'a' - 'A'
+		}
+		builder.WriteRune(character)
+	}
+	return "/" + builder.String()
+}
+
+// writeArgs lists the leading arguments of the insert and upsert statements.
+func (m messageModel) writeArgs() string {
+	if m.TrackTimestamps {
+		return "id, atNs, dataBytes, atNs, atNs"
+	}
+	return "id, atNs, dataBytes"
+}
+
+// sortColumns lists the columns rows can be ordered by. Encrypted and BLOB
+// projections have no meaningful order.
+func (m messageModel) sortColumns() []string {
+	columns := []string{"id", "at_ns"}
+	if m.SoftDelete {
+		columns = append(columns, "deleted_at_ns")
+	}
+	if m.TrackTimestamps {
+		columns = append(columns, createdAtNsColumn, updatedAtNsColumn)
+	}
+	for _, projectedField := range m.ProjectedFields {
+		if projectedField.Encrypted || projectedField.SQLiteType == "BLOB" {
+			continue
+		}
+		columns = append(columns, projectedField.ColumnName)
+	}
+	return columns
+}
+
+func quotedList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func timestampColumnSQL(columnName string) string {
+	return `"` + columnName + `" INTEGER NOT NULL DEFAULT 0`
 }
 
 func (m messageModel) createTableSQL() string {
 	columns := []string{`"id" TEXT PRIMARY KEY`, `"at_ns" INTEGER NOT NULL`, `"data" BLOB NOT NULL`}
+	if m.VersionVector {
+		columns = append(columns, versionVectorColumnSQL)
+	}
+	if m.SoftDelete {
+		columns = append(columns, deletedAtNsColumnSQL)
+	}
+	if m.TrackTimestamps {
+		columns = append(columns, timestampColumnSQL(createdAtNsColumn), timestampColumnSQL(updatedAtNsColumn))
+	}
 	for _, projectedField := range m.ProjectedFields {
 		columns = append(columns, projectedField.createColumnSQL())
 	}
@@ -1184,6 +6117,9 @@ func (m messageModel) createTableSQL() string {
 
 func (m messageModel) insertSQL(upsert bool) string {
 	columns := []string{"id", "at_ns", "data"}
+	if m.TrackTimestamps {
+		columns = append(columns, createdAtNsColumn, updatedAtNsColumn)
+	}
 	for _, projectedField := range m.ProjectedFields {
 		columns = append(columns, projectedField.ColumnName)
 	}
@@ -1209,6 +6145,9 @@ func (m messageModel) insertSQL(upsert bool) string {
 	}
 
 	updates := []string{`"at_ns" = excluded."at_ns"`, `"data" = excluded."data"`}
+	if m.TrackTimestamps {
+		updates = append(updates, `"`+updatedAtNsColumn+`" = excluded."`+updatedAtNsColumn+`"`)
+	}
 	for _, projectedField := range m.ProjectedFields {
 		updates = append(
 			updates,
@@ -1219,6 +6158,9 @@ func (m messageModel) insertSQL(upsert bool) string {
 			),
 		)
 	}
+	if m.SoftDelete {
+		updates = append(updates, `"deleted_at_ns" = NULL`)
+	}
 
 	return statement + " ON CONFLICT(id) DO UPDATE SET " + strings.Join(updates, ", ")
 }
@@ -1230,7 +6172,7 @@ func (m messageModel) reprojectSQL() string {
 	}
 
 	return fmt.Sprintf(
-		`UPDATE "%s" SET %s WHERE id = ?`,
+		`UPDATE "%s" SET %s WHERE id = ? AND at_ns = ?`,
 		m.TableName,
 		strings.Join(updates, ", "),
 	)
@@ -1241,9 +6183,9 @@ func (m messageModel) generatedIndexPrefix() string {
 }
 
 func (m messageModel) createIndexSQL(indexModel messageIndex) string {
-	quotedColumns := make([]string, 0, len(indexModel.ColumnNames))
-	for _, columnName := range indexModel.ColumnNames {
-		quotedColumns = append(quotedColumns, fmt.Sprintf(`"%s"`, columnName))
+	quotedColumns := make([]string, 0, len(indexModel.Columns))
+	for _, column := range indexModel.Columns {
+		quotedColumns = append(quotedColumns, column.sql())
 	}
 	return fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s" ON "%s" (%s)`, indexModel.IndexName, m.TableName, strings.Join(quotedColumns, ", "))
 }
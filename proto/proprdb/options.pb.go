@@ -22,16 +22,428 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Merge int32
+
+const (
+	Merge_MERGE_NONE      Merge = 0
+	Merge_MERGE_MAX       Merge = 1
+	Merge_MERGE_SUM       Merge = 2
+	Merge_MERGE_SET_UNION Merge = 3
+)
+
+// Enum value maps for Merge.
+var (
+	Merge_name = map[int32]string{
+		0: "MERGE_NONE",
+		1: "MERGE_MAX",
+		2: "MERGE_SUM",
+		3: "MERGE_SET_UNION",
+	}
+	Merge_value = map[string]int32{
+		"MERGE_NONE":      0,
+		"MERGE_MAX":       1,
+		"MERGE_SUM":       2,
+		"MERGE_SET_UNION": 3,
+	}
+)
+
+func (x Merge) Enum() *Merge {
+	p := new(Merge)
+	*p = x
+	return p
+}
+
+func (x Merge) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Merge) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_proprdb_options_proto_enumTypes[0].Descriptor()
+}
+
+func (Merge) Type() protoreflect.EnumType {
+	return &file_proto_proprdb_options_proto_enumTypes[0]
+}
+
+func (x Merge) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Merge.Descriptor instead.
+func (Merge) EnumDescriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{0}
+}
+
+type TimestampFormat int32
+
+const (
+	TimestampFormat_TIMESTAMP_FORMAT_UNIX_NANOS TimestampFormat = 0
+	TimestampFormat_TIMESTAMP_FORMAT_RFC3339    TimestampFormat = 1
+)
+
+// Enum value maps for TimestampFormat.
+var (
+	TimestampFormat_name = map[int32]string{
+		0: "TIMESTAMP_FORMAT_UNIX_NANOS",
+		1: "TIMESTAMP_FORMAT_RFC3339",
+	}
+	TimestampFormat_value = map[string]int32{
+		"TIMESTAMP_FORMAT_UNIX_NANOS": 0,
+		"TIMESTAMP_FORMAT_RFC3339":    1,
+	}
+)
+
+func (x TimestampFormat) Enum() *TimestampFormat {
+	p := new(TimestampFormat)
+	*p = x
+	return p
+}
+
+func (x TimestampFormat) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TimestampFormat) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_proprdb_options_proto_enumTypes[1].Descriptor()
+}
+
+func (TimestampFormat) Type() protoreflect.EnumType {
+	return &file_proto_proprdb_options_proto_enumTypes[1]
+}
+
+func (x TimestampFormat) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TimestampFormat.Descriptor instead.
+func (TimestampFormat) EnumDescriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{1}
+}
+
+type IndexOrder int32
+
+const (
+	IndexOrder_INDEX_ORDER_ASC  IndexOrder = 0
+	IndexOrder_INDEX_ORDER_DESC IndexOrder = 1
+)
+
+// Enum value maps for IndexOrder.
+var (
+	IndexOrder_name = map[int32]string{
+		0: "INDEX_ORDER_ASC",
+		1: "INDEX_ORDER_DESC",
+	}
+	IndexOrder_value = map[string]int32{
+		"INDEX_ORDER_ASC":  0,
+		"INDEX_ORDER_DESC": 1,
+	}
+)
+
+func (x IndexOrder) Enum() *IndexOrder {
+	p := new(IndexOrder)
+	*p = x
+	return p
+}
+
+func (x IndexOrder) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (IndexOrder) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_proprdb_options_proto_enumTypes[2].Descriptor()
+}
+
+func (IndexOrder) Type() protoreflect.EnumType {
+	return &file_proto_proprdb_options_proto_enumTypes[2]
+}
+
+func (x IndexOrder) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use IndexOrder.Descriptor instead.
+func (IndexOrder) EnumDescriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{2}
+}
+
+type Collation int32
+
+const (
+	Collation_COLLATION_BINARY Collation = 0
+	Collation_COLLATION_NOCASE Collation = 1
+)
+
+// Enum value maps for Collation.
+var (
+	Collation_name = map[int32]string{
+		0: "COLLATION_BINARY",
+		1: "COLLATION_NOCASE",
+	}
+	Collation_value = map[string]int32{
+		"COLLATION_BINARY": 0,
+		"COLLATION_NOCASE": 1,
+	}
+)
+
+func (x Collation) Enum() *Collation {
+	p := new(Collation)
+	*p = x
+	return p
+}
+
+func (x Collation) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Collation) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_proprdb_options_proto_enumTypes[3].Descriptor()
+}
+
+func (Collation) Type() protoreflect.EnumType {
+	return &file_proto_proprdb_options_proto_enumTypes[3]
+}
+
+func (x Collation) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Collation.Descriptor instead.
+func (Collation) EnumDescriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{3}
+}
+
+type Compression int32
+
+const (
+	Compression_COMPRESSION_NONE Compression = 0
+	Compression_COMPRESSION_GZIP Compression = 1
+)
+
+// Enum value maps for Compression.
+var (
+	Compression_name = map[int32]string{
+		0: "COMPRESSION_NONE",
+		1: "COMPRESSION_GZIP",
+	}
+	Compression_value = map[string]int32{
+		"COMPRESSION_NONE": 0,
+		"COMPRESSION_GZIP": 1,
+	}
+)
+
+func (x Compression) Enum() *Compression {
+	p := new(Compression)
+	*p = x
+	return p
+}
+
+func (x Compression) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Compression) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_proprdb_options_proto_enumTypes[4].Descriptor()
+}
+
+func (Compression) Type() protoreflect.EnumType {
+	return &file_proto_proprdb_options_proto_enumTypes[4]
+}
+
+func (x Compression) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Compression.Descriptor instead.
+func (Compression) EnumDescriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{4}
+}
+
+type IdFormat int32
+
+const (
+	IdFormat_ID_FORMAT_UUIDV7 IdFormat = 0
+	IdFormat_ID_FORMAT_ULID   IdFormat = 1
+	// Ids are supplied by the application through InsertWithID or
+	// rt.Options.IDGenerator, and only checked to be non-empty.
+	IdFormat_ID_FORMAT_CUSTOM IdFormat = 2
+)
+
+// Enum value maps for IdFormat.
+var (
+	IdFormat_name = map[int32]string{
+		0: "ID_FORMAT_UUIDV7",
+		1: "ID_FORMAT_ULID",
+		2: "ID_FORMAT_CUSTOM",
+	}
+	IdFormat_value = map[string]int32{
+		"ID_FORMAT_UUIDV7": 0,
+		"ID_FORMAT_ULID":   1,
+		"ID_FORMAT_CUSTOM": 2,
+	}
+)
+
+func (x IdFormat) Enum() *IdFormat {
+	p := new(IdFormat)
+	*p = x
+	return p
+}
+
+func (x IdFormat) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (IdFormat) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_proprdb_options_proto_enumTypes[5].Descriptor()
+}
+
+func (IdFormat) Type() protoreflect.EnumType {
+	return &file_proto_proprdb_options_proto_enumTypes[5]
+}
+
+func (x IdFormat) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use IdFormat.Descriptor instead.
+func (IdFormat) EnumDescriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{5}
+}
+
+type ConflictStrategy int32
+
+const (
+	ConflictStrategy_CONFLICT_STRATEGY_LAST_WRITER_WINS ConflictStrategy = 0
+	ConflictStrategy_CONFLICT_STRATEGY_REMOTE_WINS      ConflictStrategy = 1
+	ConflictStrategy_CONFLICT_STRATEGY_LOCAL_WINS       ConflictStrategy = 2
+	ConflictStrategy_CONFLICT_STRATEGY_MERGE            ConflictStrategy = 3
+)
+
+// Enum value maps for ConflictStrategy.
+var (
+	ConflictStrategy_name = map[int32]string{
+		0: "CONFLICT_STRATEGY_LAST_WRITER_WINS",
+		1: "CONFLICT_STRATEGY_REMOTE_WINS",
+		2: "CONFLICT_STRATEGY_LOCAL_WINS",
+		3: "CONFLICT_STRATEGY_MERGE",
+	}
+	ConflictStrategy_value = map[string]int32{
+		"CONFLICT_STRATEGY_LAST_WRITER_WINS": 0,
+		"CONFLICT_STRATEGY_REMOTE_WINS":      1,
+		"CONFLICT_STRATEGY_LOCAL_WINS":       2,
+		"CONFLICT_STRATEGY_MERGE":            3,
+	}
+)
+
+func (x ConflictStrategy) Enum() *ConflictStrategy {
+	p := new(ConflictStrategy)
+	*p = x
+	return p
+}
+
+func (x ConflictStrategy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ConflictStrategy) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_proprdb_options_proto_enumTypes[6].Descriptor()
+}
+
+func (ConflictStrategy) Type() protoreflect.EnumType {
+	return &file_proto_proprdb_options_proto_enumTypes[6]
+}
+
+func (x ConflictStrategy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ConflictStrategy.Descriptor instead.
+func (ConflictStrategy) EnumDescriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{6}
+}
+
+type IndexColumn struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Field     string                 `protobuf:"bytes,1,opt,name=field,proto3" json:"field,omitempty"`
+	Order     IndexOrder             `protobuf:"varint,2,opt,name=order,proto3,enum=com.github.fingon.proprdb.IndexOrder" json:"order,omitempty"`
+	Collation Collation              `protobuf:"varint,3,opt,name=collation,proto3,enum=com.github.fingon.proprdb.Collation" json:"collation,omitempty"`
+	// expression is a SQLite expression over projected columns, used instead
+	// of field.
+	Expression    string `protobuf:"bytes,4,opt,name=expression,proto3" json:"expression,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IndexColumn) Reset() {
+	*x = IndexColumn{}
+	mi := &file_proto_proprdb_options_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IndexColumn) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IndexColumn) ProtoMessage() {}
+
+func (x *IndexColumn) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_proprdb_options_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IndexColumn.ProtoReflect.Descriptor instead.
+func (*IndexColumn) Descriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *IndexColumn) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *IndexColumn) GetOrder() IndexOrder {
+	if x != nil {
+		return x.Order
+	}
+	return IndexOrder_INDEX_ORDER_ASC
+}
+
+func (x *IndexColumn) GetCollation() Collation {
+	if x != nil {
+		return x.Collation
+	}
+	return Collation_COLLATION_BINARY
+}
+
+func (x *IndexColumn) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
 type Index struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Fields        []string               `protobuf:"bytes,1,rep,name=fields,proto3" json:"fields,omitempty"`
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Fields []string               `protobuf:"bytes,1,rep,name=fields,proto3" json:"fields,omitempty"`
+	// columns is used instead of fields when a column needs a non-default
+	// order or collation.
+	Columns       []*IndexColumn `protobuf:"bytes,2,rep,name=columns,proto3" json:"columns,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Index) Reset() {
 	*x = Index{}
-	mi := &file_proto_proprdb_options_proto_msgTypes[0]
+	mi := &file_proto_proprdb_options_proto_msgTypes[1]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -43,7 +455,7 @@ func (x *Index) String() string {
 func (*Index) ProtoMessage() {}
 
 func (x *Index) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_proprdb_options_proto_msgTypes[0]
+	mi := &file_proto_proprdb_options_proto_msgTypes[1]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -56,7 +468,7 @@ func (x *Index) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Index.ProtoReflect.Descriptor instead.
 func (*Index) Descriptor() ([]byte, []int) {
-	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{0}
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{1}
 }
 
 func (x *Index) GetFields() []string {
@@ -66,6 +478,227 @@ func (x *Index) GetFields() []string {
 	return nil
 }
 
+func (x *Index) GetColumns() []*IndexColumn {
+	if x != nil {
+		return x.Columns
+	}
+	return nil
+}
+
+// Geo names the latitude and longitude of a message location, in degrees.
+// Each is an external float or double field, e.g. "lat", or an external
+// path, e.g. "location.latitude".
+type Geo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Latitude      string                 `protobuf:"bytes,1,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude     string                 `protobuf:"bytes,2,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Geo) Reset() {
+	*x = Geo{}
+	mi := &file_proto_proprdb_options_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Geo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Geo) ProtoMessage() {}
+
+func (x *Geo) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_proprdb_options_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Geo.ProtoReflect.Descriptor instead.
+func (*Geo) Descriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Geo) GetLatitude() string {
+	if x != nil {
+		return x.Latitude
+	}
+	return ""
+}
+
+func (x *Geo) GetLongitude() string {
+	if x != nil {
+		return x.Longitude
+	}
+	return ""
+}
+
+// Migration sets a top-level field in the stored rows where it is unset,
+// when Init or ReprojectTable first sees the migration, or drops its
+// projection.
+type Migration struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Field string                 `protobuf:"bytes,1,opt,name=field,proto3" json:"field,omitempty"`
+	// Types that are valid to be assigned to Value:
+	//
+	//	*Migration_CopyFrom
+	//	*Migration_DefaultValue
+	Value isMigration_Value `protobuf_oneof:"value"`
+	// drop_projection acknowledges that field, which a schema lock file
+	// records as projected, is no longer projected. It takes neither
+	// copy_from nor default_value.
+	DropProjection bool `protobuf:"varint,4,opt,name=drop_projection,json=dropProjection,proto3" json:"drop_projection,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Migration) Reset() {
+	*x = Migration{}
+	mi := &file_proto_proprdb_options_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Migration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Migration) ProtoMessage() {}
+
+func (x *Migration) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_proprdb_options_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Migration.ProtoReflect.Descriptor instead.
+func (*Migration) Descriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Migration) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *Migration) GetValue() isMigration_Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *Migration) GetCopyFrom() string {
+	if x != nil {
+		if x, ok := x.Value.(*Migration_CopyFrom); ok {
+			return x.CopyFrom
+		}
+	}
+	return ""
+}
+
+func (x *Migration) GetDefaultValue() string {
+	if x != nil {
+		if x, ok := x.Value.(*Migration_DefaultValue); ok {
+			return x.DefaultValue
+		}
+	}
+	return ""
+}
+
+func (x *Migration) GetDropProjection() bool {
+	if x != nil {
+		return x.DropProjection
+	}
+	return false
+}
+
+type isMigration_Value interface {
+	isMigration_Value()
+}
+
+type Migration_CopyFrom struct {
+	// copy_from names a field of the same type whose value is copied.
+	CopyFrom string `protobuf:"bytes,2,opt,name=copy_from,json=copyFrom,proto3,oneof"`
+}
+
+type Migration_DefaultValue struct {
+	// default_value is the value in the protojson syntax of the field,
+	// e.g. "42", "\"draft\"" or "[\"a\", \"b\"]".
+	DefaultValue string `protobuf:"bytes,3,opt,name=default_value,json=defaultValue,proto3,oneof"`
+}
+
+func (*Migration_CopyFrom) isMigration_Value() {}
+
+func (*Migration_DefaultValue) isMigration_Value() {}
+
+type SyncFilter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Remote        string                 `protobuf:"bytes,1,opt,name=remote,proto3" json:"remote,omitempty"`
+	Where         string                 `protobuf:"bytes,2,opt,name=where,proto3" json:"where,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncFilter) Reset() {
+	*x = SyncFilter{}
+	mi := &file_proto_proprdb_options_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncFilter) ProtoMessage() {}
+
+func (x *SyncFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_proprdb_options_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncFilter.ProtoReflect.Descriptor instead.
+func (*SyncFilter) Descriptor() ([]byte, []int) {
+	return file_proto_proprdb_options_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SyncFilter) GetRemote() string {
+	if x != nil {
+		return x.Remote
+	}
+	return ""
+}
+
+func (x *SyncFilter) GetWhere() string {
+	if x != nil {
+		return x.Where
+	}
+	return ""
+}
+
 var file_proto_proprdb_options_proto_extTypes = []protoimpl.ExtensionInfo{
 	{
 		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
@@ -75,6 +708,110 @@ var file_proto_proprdb_options_proto_extTypes = []protoimpl.ExtensionInfo{
 		Tag:           "varint,50001,opt,name=external",
 		Filename:      "proto/proprdb/options.proto",
 	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50008,
+		Name:          "com.github.fingon.proprdb.encrypted",
+		Tag:           "varint,50008,opt,name=encrypted",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*Merge)(nil),
+		Field:         50010,
+		Name:          "com.github.fingon.proprdb.merge",
+		Tag:           "varint,50010,opt,name=merge,enum=com.github.fingon.proprdb.Merge",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*TimestampFormat)(nil),
+		Field:         50017,
+		Name:          "com.github.fingon.proprdb.timestamp_format",
+		Tag:           "varint,50017,opt,name=timestamp_format,enum=com.github.fingon.proprdb.TimestampFormat",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*float64)(nil),
+		Field:         50018,
+		Name:          "com.github.fingon.proprdb.min",
+		Tag:           "fixed64,50018,opt,name=min",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*float64)(nil),
+		Field:         50019,
+		Name:          "com.github.fingon.proprdb.max",
+		Tag:           "fixed64,50019,opt,name=max",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*string)(nil),
+		Field:         50020,
+		Name:          "com.github.fingon.proprdb.pattern",
+		Tag:           "bytes,50020,opt,name=pattern",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50021,
+		Name:          "com.github.fingon.proprdb.required",
+		Tag:           "varint,50021,opt,name=required",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50023,
+		Name:          "com.github.fingon.proprdb.tenant_field",
+		Tag:           "varint,50023,opt,name=tenant_field",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*string)(nil),
+		Field:         50026,
+		Name:          "com.github.fingon.proprdb.column_name",
+		Tag:           "bytes,50026,opt,name=column_name",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50029,
+		Name:          "com.github.fingon.proprdb.search",
+		Tag:           "varint,50029,opt,name=search",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50030,
+		Name:          "com.github.fingon.proprdb.vector",
+		Tag:           "varint,50030,opt,name=vector",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50032,
+		Name:          "com.github.fingon.proprdb.blob_external",
+		Tag:           "varint,50032,opt,name=blob_external",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*string)(nil),
+		Field:         50033,
+		Name:          "com.github.fingon.proprdb.renamed_from",
+		Tag:           "bytes,50033,opt,name=renamed_from",
+		Filename:      "proto/proprdb/options.proto",
+	},
 	{
 		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
 		ExtensionType: (*bool)(nil),
@@ -115,42 +852,344 @@ var file_proto_proprdb_options_proto_extTypes = []protoimpl.ExtensionInfo{
 		Tag:           "bytes,50006,rep,name=indexes",
 		Filename:      "proto/proprdb/options.proto",
 	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*Compression)(nil),
+		Field:         50007,
+		Name:          "com.github.fingon.proprdb.compression",
+		Tag:           "varint,50007,opt,name=compression,enum=com.github.fingon.proprdb.Compression",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*ConflictStrategy)(nil),
+		Field:         50009,
+		Name:          "com.github.fingon.proprdb.conflict_strategy",
+		Tag:           "varint,50009,opt,name=conflict_strategy,enum=com.github.fingon.proprdb.ConflictStrategy",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50011,
+		Name:          "com.github.fingon.proprdb.version_vector",
+		Tag:           "varint,50011,opt,name=version_vector",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: ([]*SyncFilter)(nil),
+		Field:         50012,
+		Name:          "com.github.fingon.proprdb.sync_filters",
+		Tag:           "bytes,50012,rep,name=sync_filters",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50013,
+		Name:          "com.github.fingon.proprdb.soft_delete",
+		Tag:           "varint,50013,opt,name=soft_delete",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50014,
+		Name:          "com.github.fingon.proprdb.track_timestamps",
+		Tag:           "varint,50014,opt,name=track_timestamps",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*int64)(nil),
+		Field:         50015,
+		Name:          "com.github.fingon.proprdb.ttl_seconds",
+		Tag:           "varint,50015,opt,name=ttl_seconds",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: ([]string)(nil),
+		Field:         50016,
+		Name:          "com.github.fingon.proprdb.external_paths",
+		Tag:           "bytes,50016,rep,name=external_paths",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*IdFormat)(nil),
+		Field:         50022,
+		Name:          "com.github.fingon.proprdb.id_format",
+		Tag:           "varint,50022,opt,name=id_format,enum=com.github.fingon.proprdb.IdFormat",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50024,
+		Name:          "com.github.fingon.proprdb.history",
+		Tag:           "varint,50024,opt,name=history",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*string)(nil),
+		Field:         50025,
+		Name:          "com.github.fingon.proprdb.table_name",
+		Tag:           "bytes,50025,opt,name=table_name",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50027,
+		Name:          "com.github.fingon.proprdb.skip",
+		Tag:           "varint,50027,opt,name=skip",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*Geo)(nil),
+		Field:         50031,
+		Name:          "com.github.fingon.proprdb.geo",
+		Tag:           "bytes,50031,opt,name=geo",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: ([]*Migration)(nil),
+		Field:         50033,
+		Name:          "com.github.fingon.proprdb.migration",
+		Tag:           "bytes,50033,rep,name=migration",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FileOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50028,
+		Name:          "com.github.fingon.proprdb.default_generate",
+		Tag:           "varint,50028,opt,name=default_generate",
+		Filename:      "proto/proprdb/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FileOptions)(nil),
+		ExtensionType: (*string)(nil),
+		Field:         50034,
+		Name:          "com.github.fingon.proprdb.type_url_prefix",
+		Tag:           "bytes,50034,opt,name=type_url_prefix",
+		Filename:      "proto/proprdb/options.proto",
+	},
 }
 
 // Extension fields to descriptorpb.FieldOptions.
 var (
 	// optional bool external = 50001;
 	E_External = &file_proto_proprdb_options_proto_extTypes[0]
+	// optional bool encrypted = 50008;
+	E_Encrypted = &file_proto_proprdb_options_proto_extTypes[1]
+	// optional com.github.fingon.proprdb.Merge merge = 50010;
+	E_Merge = &file_proto_proprdb_options_proto_extTypes[2]
+	// optional com.github.fingon.proprdb.TimestampFormat timestamp_format = 50017;
+	E_TimestampFormat = &file_proto_proprdb_options_proto_extTypes[3]
+	// optional double min = 50018;
+	E_Min = &file_proto_proprdb_options_proto_extTypes[4]
+	// optional double max = 50019;
+	E_Max = &file_proto_proprdb_options_proto_extTypes[5]
+	// optional string pattern = 50020;
+	E_Pattern = &file_proto_proprdb_options_proto_extTypes[6]
+	// optional bool required = 50021;
+	E_Required = &file_proto_proprdb_options_proto_extTypes[7]
+	// Marks the external string field holding the tenant of each row.
+	//
+	// optional bool tenant_field = 50023;
+	E_TenantField = &file_proto_proprdb_options_proto_extTypes[8]
+	// Names the column of an external field instead of the field name.
+	//
+	// optional string column_name = 50026;
+	E_ColumnName = &file_proto_proprdb_options_proto_extTypes[9]
+	// Mirrors the string field into Options.SearchIndex for SearchFullText.
+	//
+	// optional bool search = 50029;
+	E_Search = &file_proto_proprdb_options_proto_extTypes[10]
+	// Stores a repeated float or double field as an embedding column for
+	// NearestNeighbors.
+	//
+	// optional bool vector = 50030;
+	E_Vector = &file_proto_proprdb_options_proto_extTypes[11]
+	// Spills a bytes field to Options.BlobStore, keeping its content hash in
+	// the data column.
+	//
+	// optional bool blob_external = 50032;
+	E_BlobExternal = &file_proto_proprdb_options_proto_extTypes[12]
+	// Names the field before it was renamed. JSONL records of peers still
+	// using the old name are read into this field, and Init renames the
+	// projection column of an external field instead of adding a new one.
+	//
+	// optional string renamed_from = 50033;
+	E_RenamedFrom = &file_proto_proprdb_options_proto_extTypes[13]
 )
 
 // Extension fields to descriptorpb.MessageOptions.
 var (
 	// optional bool omit_table = 50002;
-	E_OmitTable = &file_proto_proprdb_options_proto_extTypes[1]
+	E_OmitTable = &file_proto_proprdb_options_proto_extTypes[14]
 	// optional bool omit_sync = 50003;
-	E_OmitSync = &file_proto_proprdb_options_proto_extTypes[2]
+	E_OmitSync = &file_proto_proprdb_options_proto_extTypes[15]
 	// optional bool validate_write = 50004;
-	E_ValidateWrite = &file_proto_proprdb_options_proto_extTypes[3]
+	E_ValidateWrite = &file_proto_proprdb_options_proto_extTypes[16]
 	// optional bool allow_custom_id_insert = 50005;
-	E_AllowCustomIdInsert = &file_proto_proprdb_options_proto_extTypes[4]
+	E_AllowCustomIdInsert = &file_proto_proprdb_options_proto_extTypes[17]
 	// repeated com.github.fingon.proprdb.Index indexes = 50006;
-	E_Indexes = &file_proto_proprdb_options_proto_extTypes[5]
+	E_Indexes = &file_proto_proprdb_options_proto_extTypes[18]
+	// optional com.github.fingon.proprdb.Compression compression = 50007;
+	E_Compression = &file_proto_proprdb_options_proto_extTypes[19]
+	// optional com.github.fingon.proprdb.ConflictStrategy conflict_strategy = 50009;
+	E_ConflictStrategy = &file_proto_proprdb_options_proto_extTypes[20]
+	// optional bool version_vector = 50011;
+	E_VersionVector = &file_proto_proprdb_options_proto_extTypes[21]
+	// repeated com.github.fingon.proprdb.SyncFilter sync_filters = 50012;
+	E_SyncFilters = &file_proto_proprdb_options_proto_extTypes[22]
+	// optional bool soft_delete = 50013;
+	E_SoftDelete = &file_proto_proprdb_options_proto_extTypes[23]
+	// optional bool track_timestamps = 50014;
+	E_TrackTimestamps = &file_proto_proprdb_options_proto_extTypes[24]
+	// optional int64 ttl_seconds = 50015;
+	E_TtlSeconds = &file_proto_proprdb_options_proto_extTypes[25]
+	// repeated string external_paths = 50016;
+	E_ExternalPaths = &file_proto_proprdb_options_proto_extTypes[26]
+	// optional com.github.fingon.proprdb.IdFormat id_format = 50022;
+	E_IdFormat = &file_proto_proprdb_options_proto_extTypes[27]
+	// optional bool history = 50024;
+	E_History = &file_proto_proprdb_options_proto_extTypes[28]
+	// Names the table instead of the lower-cased full message name.
+	//
+	// optional string table_name = 50025;
+	E_TableName = &file_proto_proprdb_options_proto_extTypes[29]
+	// true excludes the message from generation; false includes it in files
+	// whose default_generate is false.
+	//
+	// optional bool skip = 50027;
+	E_Skip = &file_proto_proprdb_options_proto_extTypes[30]
+	// Generates SelectWithinBounds and SelectNear over the location.
+	//
+	// optional com.github.fingon.proprdb.Geo geo = 50031;
+	E_Geo = &file_proto_proprdb_options_proto_extTypes[31]
+	// Migrates the stored rows, in order, when the schema changes.
+	//
+	// repeated com.github.fingon.proprdb.Migration migration = 50033;
+	E_Migration = &file_proto_proprdb_options_proto_extTypes[32]
+)
+
+// Extension fields to descriptorpb.FileOptions.
+var (
+	// false generates only messages with (skip) = false. Defaults to true.
+	//
+	// optional bool default_generate = 50028;
+	E_DefaultGenerate = &file_proto_proprdb_options_proto_extTypes[33]
+	// Prefix of the @type of written records, e.g. "types.example.com/".
+	// Defaults to "type.googleapis.com/"; reads accept any prefix.
+	//
+	// optional string type_url_prefix = 50034;
+	E_TypeUrlPrefix = &file_proto_proprdb_options_proto_extTypes[34]
 )
 
 var File_proto_proprdb_options_proto protoreflect.FileDescriptor
 
 const file_proto_proprdb_options_proto_rawDesc = "" +
 	"\n" +
-	"\x1bproto/proprdb/options.proto\x12\x19com.github.fingon.proprdb\x1a google/protobuf/descriptor.proto\"\x1f\n" +
+	"\x1bproto/proprdb/options.proto\x12\x19com.github.fingon.proprdb\x1a google/protobuf/descriptor.proto\"\xc4\x01\n" +
+	"\vIndexColumn\x12\x14\n" +
+	"\x05field\x18\x01 \x01(\tR\x05field\x12;\n" +
+	"\x05order\x18\x02 \x01(\x0e2%.com.github.fingon.proprdb.IndexOrderR\x05order\x12B\n" +
+	"\tcollation\x18\x03 \x01(\x0e2$.com.github.fingon.proprdb.CollationR\tcollation\x12\x1e\n" +
+	"\n" +
+	"expression\x18\x04 \x01(\tR\n" +
+	"expression\"a\n" +
 	"\x05Index\x12\x16\n" +
-	"\x06fields\x18\x01 \x03(\tR\x06fields:;\n" +
-	"\bexternal\x12\x1d.google.protobuf.FieldOptions\x18ц\x03 \x01(\bR\bexternal:@\n" +
+	"\x06fields\x18\x01 \x03(\tR\x06fields\x12@\n" +
+	"\acolumns\x18\x02 \x03(\v2&.com.github.fingon.proprdb.IndexColumnR\acolumns\"?\n" +
+	"\x03Geo\x12\x1a\n" +
+	"\blatitude\x18\x01 \x01(\tR\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\x02 \x01(\tR\tlongitude\"\x99\x01\n" +
+	"\tMigration\x12\x14\n" +
+	"\x05field\x18\x01 \x01(\tR\x05field\x12\x1d\n" +
+	"\tcopy_from\x18\x02 \x01(\tH\x00R\bcopyFrom\x12%\n" +
+	"\rdefault_value\x18\x03 \x01(\tH\x00R\fdefaultValue\x12'\n" +
+	"\x0fdrop_projection\x18\x04 \x01(\bR\x0edropProjectionB\a\n" +
+	"\x05value\":\n" +
+	"\n" +
+	"SyncFilter\x12\x16\n" +
+	"\x06remote\x18\x01 \x01(\tR\x06remote\x12\x14\n" +
+	"\x05where\x18\x02 \x01(\tR\x05where*J\n" +
+	"\x05Merge\x12\x0e\n" +
+	"\n" +
+	"MERGE_NONE\x10\x00\x12\r\n" +
+	"\tMERGE_MAX\x10\x01\x12\r\n" +
+	"\tMERGE_SUM\x10\x02\x12\x13\n" +
+	"\x0fMERGE_SET_UNION\x10\x03*P\n" +
+	"\x0fTimestampFormat\x12\x1f\n" +
+	"\x1bTIMESTAMP_FORMAT_UNIX_NANOS\x10\x00\x12\x1c\n" +
+	"\x18TIMESTAMP_FORMAT_RFC3339\x10\x01*7\n" +
+	"\n" +
+	"IndexOrder\x12\x13\n" +
+	"\x0fINDEX_ORDER_ASC\x10\x00\x12\x14\n" +
+	"\x10INDEX_ORDER_DESC\x10\x01*7\n" +
+	"\tCollation\x12\x14\n" +
+	"\x10COLLATION_BINARY\x10\x00\x12\x14\n" +
+	"\x10COLLATION_NOCASE\x10\x01*9\n" +
+	"\vCompression\x12\x14\n" +
+	"\x10COMPRESSION_NONE\x10\x00\x12\x14\n" +
+	"\x10COMPRESSION_GZIP\x10\x01*J\n" +
+	"\bIdFormat\x12\x14\n" +
+	"\x10ID_FORMAT_UUIDV7\x10\x00\x12\x12\n" +
+	"\x0eID_FORMAT_ULID\x10\x01\x12\x14\n" +
+	"\x10ID_FORMAT_CUSTOM\x10\x02*\x9c\x01\n" +
+	"\x10ConflictStrategy\x12&\n" +
+	"\"CONFLICT_STRATEGY_LAST_WRITER_WINS\x10\x00\x12!\n" +
+	"\x1dCONFLICT_STRATEGY_REMOTE_WINS\x10\x01\x12 \n" +
+	"\x1cCONFLICT_STRATEGY_LOCAL_WINS\x10\x02\x12\x1b\n" +
+	"\x17CONFLICT_STRATEGY_MERGE\x10\x03:;\n" +
+	"\bexternal\x12\x1d.google.protobuf.FieldOptions\x18ц\x03 \x01(\bR\bexternal:=\n" +
+	"\tencrypted\x12\x1d.google.protobuf.FieldOptions\x18؆\x03 \x01(\bR\tencrypted:W\n" +
+	"\x05merge\x12\x1d.google.protobuf.FieldOptions\x18چ\x03 \x01(\x0e2 .com.github.fingon.proprdb.MergeR\x05merge:v\n" +
+	"\x10timestamp_format\x12\x1d.google.protobuf.FieldOptions\x18\xe1\x86\x03 \x01(\x0e2*.com.github.fingon.proprdb.TimestampFormatR\x0ftimestampFormat:1\n" +
+	"\x03min\x12\x1d.google.protobuf.FieldOptions\x18\xe2\x86\x03 \x01(\x01R\x03min:1\n" +
+	"\x03max\x12\x1d.google.protobuf.FieldOptions\x18\xe3\x86\x03 \x01(\x01R\x03max:9\n" +
+	"\apattern\x12\x1d.google.protobuf.FieldOptions\x18\xe4\x86\x03 \x01(\tR\apattern:;\n" +
+	"\brequired\x12\x1d.google.protobuf.FieldOptions\x18\xe5\x86\x03 \x01(\bR\brequired:B\n" +
+	"\ftenant_field\x12\x1d.google.protobuf.FieldOptions\x18\xe7\x86\x03 \x01(\bR\vtenantField:@\n" +
+	"\vcolumn_name\x12\x1d.google.protobuf.FieldOptions\x18\xea\x86\x03 \x01(\tR\n" +
+	"columnName:7\n" +
+	"\x06search\x12\x1d.google.protobuf.FieldOptions\x18\xed\x86\x03 \x01(\bR\x06search:7\n" +
+	"\x06vector\x12\x1d.google.protobuf.FieldOptions\x18\xee\x86\x03 \x01(\bR\x06vector:D\n" +
+	"\rblob_external\x12\x1d.google.protobuf.FieldOptions\x18\xf0\x86\x03 \x01(\bR\fblobExternal:B\n" +
+	"\frenamed_from\x12\x1d.google.protobuf.FieldOptions\x18\xf1\x86\x03 \x01(\tR\vrenamedFrom:@\n" +
 	"\n" +
 	"omit_table\x12\x1f.google.protobuf.MessageOptions\x18҆\x03 \x01(\bR\tomitTable:>\n" +
 	"\tomit_sync\x12\x1f.google.protobuf.MessageOptions\x18ӆ\x03 \x01(\bR\bomitSync:H\n" +
 	"\x0evalidate_write\x12\x1f.google.protobuf.MessageOptions\x18Ԇ\x03 \x01(\bR\rvalidateWrite:V\n" +
 	"\x16allow_custom_id_insert\x12\x1f.google.protobuf.MessageOptions\x18Ն\x03 \x01(\bR\x13allowCustomIdInsert:]\n" +
-	"\aindexes\x12\x1f.google.protobuf.MessageOptions\x18ֆ\x03 \x03(\v2 .com.github.fingon.proprdb.IndexR\aindexesB3Z1github.com/fingon/proprdb/proto/proprdb;proprdbpbb\x06proto3"
+	"\aindexes\x12\x1f.google.protobuf.MessageOptions\x18ֆ\x03 \x03(\v2 .com.github.fingon.proprdb.IndexR\aindexes:k\n" +
+	"\vcompression\x12\x1f.google.protobuf.MessageOptions\x18׆\x03 \x01(\x0e2&.com.github.fingon.proprdb.CompressionR\vcompression:{\n" +
+	"\x11conflict_strategy\x12\x1f.google.protobuf.MessageOptions\x18ن\x03 \x01(\x0e2+.com.github.fingon.proprdb.ConflictStrategyR\x10conflictStrategy:H\n" +
+	"\x0eversion_vector\x12\x1f.google.protobuf.MessageOptions\x18ۆ\x03 \x01(\bR\rversionVector:k\n" +
+	"\fsync_filters\x12\x1f.google.protobuf.MessageOptions\x18܆\x03 \x03(\v2%.com.github.fingon.proprdb.SyncFilterR\vsyncFilters:B\n" +
+	"\vsoft_delete\x12\x1f.google.protobuf.MessageOptions\x18݆\x03 \x01(\bR\n" +
+	"softDelete:L\n" +
+	"\x10track_timestamps\x12\x1f.google.protobuf.MessageOptions\x18ކ\x03 \x01(\bR\x0ftrackTimestamps:B\n" +
+	"\vttl_seconds\x12\x1f.google.protobuf.MessageOptions\x18߆\x03 \x01(\x03R\n" +
+	"ttlSeconds:H\n" +
+	"\x0eexternal_paths\x12\x1f.google.protobuf.MessageOptions\x18\xe0\x86\x03 \x03(\tR\rexternalPaths:c\n" +
+	"\tid_format\x12\x1f.google.protobuf.MessageOptions\x18\xe6\x86\x03 \x01(\x0e2#.com.github.fingon.proprdb.IdFormatR\bidFormat:;\n" +
+	"\ahistory\x12\x1f.google.protobuf.MessageOptions\x18\xe8\x86\x03 \x01(\bR\ahistory:@\n" +
+	"\n" +
+	"table_name\x12\x1f.google.protobuf.MessageOptions\x18\xe9\x86\x03 \x01(\tR\ttableName:5\n" +
+	"\x04skip\x12\x1f.google.protobuf.MessageOptions\x18\xeb\x86\x03 \x01(\bR\x04skip:S\n" +
+	"\x03geo\x12\x1f.google.protobuf.MessageOptions\x18\xef\x86\x03 \x01(\v2\x1e.com.github.fingon.proprdb.GeoR\x03geo:e\n" +
+	"\tmigration\x12\x1f.google.protobuf.MessageOptions\x18\xf1\x86\x03 \x03(\v2$.com.github.fingon.proprdb.MigrationR\tmigration:I\n" +
+	"\x10default_generate\x12\x1c.google.protobuf.FileOptions\x18\xec\x86\x03 \x01(\bR\x0fdefaultGenerate:F\n" +
+	"\x0ftype_url_prefix\x12\x1c.google.protobuf.FileOptions\x18\xf2\x86\x03 \x01(\tR\rtypeUrlPrefixB3Z1github.com/fingon/proprdb/proto/proprdb;proprdbpbb\x06proto3"
 
 var (
 	file_proto_proprdb_options_proto_rawDescOnce sync.Once
@@ -164,25 +1203,78 @@ func file_proto_proprdb_options_proto_rawDescGZIP() []byte {
 	return file_proto_proprdb_options_proto_rawDescData
 }
 
-var file_proto_proprdb_options_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_proto_proprdb_options_proto_enumTypes = make([]protoimpl.EnumInfo, 7)
+var file_proto_proprdb_options_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_proto_proprdb_options_proto_goTypes = []any{
-	(*Index)(nil),                       // 0: com.github.fingon.proprdb.Index
-	(*descriptorpb.FieldOptions)(nil),   // 1: google.protobuf.FieldOptions
-	(*descriptorpb.MessageOptions)(nil), // 2: google.protobuf.MessageOptions
+	(Merge)(0),                          // 0: com.github.fingon.proprdb.Merge
+	(TimestampFormat)(0),                // 1: com.github.fingon.proprdb.TimestampFormat
+	(IndexOrder)(0),                     // 2: com.github.fingon.proprdb.IndexOrder
+	(Collation)(0),                      // 3: com.github.fingon.proprdb.Collation
+	(Compression)(0),                    // 4: com.github.fingon.proprdb.Compression
+	(IdFormat)(0),                       // 5: com.github.fingon.proprdb.IdFormat
+	(ConflictStrategy)(0),               // 6: com.github.fingon.proprdb.ConflictStrategy
+	(*IndexColumn)(nil),                 // 7: com.github.fingon.proprdb.IndexColumn
+	(*Index)(nil),                       // 8: com.github.fingon.proprdb.Index
+	(*Geo)(nil),                         // 9: com.github.fingon.proprdb.Geo
+	(*Migration)(nil),                   // 10: com.github.fingon.proprdb.Migration
+	(*SyncFilter)(nil),                  // 11: com.github.fingon.proprdb.SyncFilter
+	(*descriptorpb.FieldOptions)(nil),   // 12: google.protobuf.FieldOptions
+	(*descriptorpb.MessageOptions)(nil), // 13: google.protobuf.MessageOptions
+	(*descriptorpb.FileOptions)(nil),    // 14: google.protobuf.FileOptions
 }
 var file_proto_proprdb_options_proto_depIdxs = []int32{
-	1, // 0: com.github.fingon.proprdb.external:extendee -> google.protobuf.FieldOptions
-	2, // 1: com.github.fingon.proprdb.omit_table:extendee -> google.protobuf.MessageOptions
-	2, // 2: com.github.fingon.proprdb.omit_sync:extendee -> google.protobuf.MessageOptions
-	2, // 3: com.github.fingon.proprdb.validate_write:extendee -> google.protobuf.MessageOptions
-	2, // 4: com.github.fingon.proprdb.allow_custom_id_insert:extendee -> google.protobuf.MessageOptions
-	2, // 5: com.github.fingon.proprdb.indexes:extendee -> google.protobuf.MessageOptions
-	0, // 6: com.github.fingon.proprdb.indexes:type_name -> com.github.fingon.proprdb.Index
-	7, // [7:7] is the sub-list for method output_type
-	7, // [7:7] is the sub-list for method input_type
-	6, // [6:7] is the sub-list for extension type_name
-	0, // [0:6] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	2,  // 0: com.github.fingon.proprdb.IndexColumn.order:type_name -> com.github.fingon.proprdb.IndexOrder
+	3,  // 1: com.github.fingon.proprdb.IndexColumn.collation:type_name -> com.github.fingon.proprdb.Collation
+	7,  // 2: com.github.fingon.proprdb.Index.columns:type_name -> com.github.fingon.proprdb.IndexColumn
+	12, // 3: com.github.fingon.proprdb.external:extendee -> google.protobuf.FieldOptions
+	12, // 4: com.github.fingon.proprdb.encrypted:extendee -> google.protobuf.FieldOptions
+	12, // 5: com.github.fingon.proprdb.merge:extendee -> google.protobuf.FieldOptions
+	12, // 6: com.github.fingon.proprdb.timestamp_format:extendee -> google.protobuf.FieldOptions
+	12, // 7: com.github.fingon.proprdb.min:extendee -> google.protobuf.FieldOptions
+	12, // 8: com.github.fingon.proprdb.max:extendee -> google.protobuf.FieldOptions
+	12, // 9: com.github.fingon.proprdb.pattern:extendee -> google.protobuf.FieldOptions
+	12, // 10: com.github.fingon.proprdb.required:extendee -> google.protobuf.FieldOptions
+	12, // 11: com.github.fingon.proprdb.tenant_field:extendee -> google.protobuf.FieldOptions
+	12, // 12: com.github.fingon.proprdb.column_name:extendee -> google.protobuf.FieldOptions
+	12, // 13: com.github.fingon.proprdb.search:extendee -> google.protobuf.FieldOptions
+	12, // 14: com.github.fingon.proprdb.vector:extendee -> google.protobuf.FieldOptions
+	12, // 15: com.github.fingon.proprdb.blob_external:extendee -> google.protobuf.FieldOptions
+	12, // 16: com.github.fingon.proprdb.renamed_from:extendee -> google.protobuf.FieldOptions
+	13, // 17: com.github.fingon.proprdb.omit_table:extendee -> google.protobuf.MessageOptions
+	13, // 18: com.github.fingon.proprdb.omit_sync:extendee -> google.protobuf.MessageOptions
+	13, // 19: com.github.fingon.proprdb.validate_write:extendee -> google.protobuf.MessageOptions
+	13, // 20: com.github.fingon.proprdb.allow_custom_id_insert:extendee -> google.protobuf.MessageOptions
+	13, // 21: com.github.fingon.proprdb.indexes:extendee -> google.protobuf.MessageOptions
+	13, // 22: com.github.fingon.proprdb.compression:extendee -> google.protobuf.MessageOptions
+	13, // 23: com.github.fingon.proprdb.conflict_strategy:extendee -> google.protobuf.MessageOptions
+	13, // 24: com.github.fingon.proprdb.version_vector:extendee -> google.protobuf.MessageOptions
+	13, // 25: com.github.fingon.proprdb.sync_filters:extendee -> google.protobuf.MessageOptions
+	13, // 26: com.github.fingon.proprdb.soft_delete:extendee -> google.protobuf.MessageOptions
+	13, // 27: com.github.fingon.proprdb.track_timestamps:extendee -> google.protobuf.MessageOptions
+	13, // 28: com.github.fingon.proprdb.ttl_seconds:extendee -> google.protobuf.MessageOptions
+	13, // 29: com.github.fingon.proprdb.external_paths:extendee -> google.protobuf.MessageOptions
+	13, // 30: com.github.fingon.proprdb.id_format:extendee -> google.protobuf.MessageOptions
+	13, // 31: com.github.fingon.proprdb.history:extendee -> google.protobuf.MessageOptions
+	13, // 32: com.github.fingon.proprdb.table_name:extendee -> google.protobuf.MessageOptions
+	13, // 33: com.github.fingon.proprdb.skip:extendee -> google.protobuf.MessageOptions
+	13, // 34: com.github.fingon.proprdb.geo:extendee -> google.protobuf.MessageOptions
+	13, // 35: com.github.fingon.proprdb.migration:extendee -> google.protobuf.MessageOptions
+	14, // 36: com.github.fingon.proprdb.default_generate:extendee -> google.protobuf.FileOptions
+	14, // 37: com.github.fingon.proprdb.type_url_prefix:extendee -> google.protobuf.FileOptions
+	0,  // 38: com.github.fingon.proprdb.merge:type_name -> com.github.fingon.proprdb.Merge
+	1,  // 39: com.github.fingon.proprdb.timestamp_format:type_name -> com.github.fingon.proprdb.TimestampFormat
+	8,  // 40: com.github.fingon.proprdb.indexes:type_name -> com.github.fingon.proprdb.Index
+	4,  // 41: com.github.fingon.proprdb.compression:type_name -> com.github.fingon.proprdb.Compression
+	6,  // 42: com.github.fingon.proprdb.conflict_strategy:type_name -> com.github.fingon.proprdb.ConflictStrategy
+	11, // 43: com.github.fingon.proprdb.sync_filters:type_name -> com.github.fingon.proprdb.SyncFilter
+	5,  // 44: com.github.fingon.proprdb.id_format:type_name -> com.github.fingon.proprdb.IdFormat
+	9,  // 45: com.github.fingon.proprdb.geo:type_name -> com.github.fingon.proprdb.Geo
+	10, // 46: com.github.fingon.proprdb.migration:type_name -> com.github.fingon.proprdb.Migration
+	47, // [47:47] is the sub-list for method output_type
+	47, // [47:47] is the sub-list for method input_type
+	38, // [38:47] is the sub-list for extension type_name
+	3,  // [3:38] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proto_proprdb_options_proto_init() }
@@ -190,18 +1282,23 @@ func file_proto_proprdb_options_proto_init() {
 	if File_proto_proprdb_options_proto != nil {
 		return
 	}
+	file_proto_proprdb_options_proto_msgTypes[3].OneofWrappers = []any{
+		(*Migration_CopyFrom)(nil),
+		(*Migration_DefaultValue)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_proprdb_options_proto_rawDesc), len(file_proto_proprdb_options_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   1,
-			NumExtensions: 6,
+			NumEnums:      7,
+			NumMessages:   5,
+			NumExtensions: 35,
 			NumServices:   0,
 		},
 		GoTypes:           file_proto_proprdb_options_proto_goTypes,
 		DependencyIndexes: file_proto_proprdb_options_proto_depIdxs,
+		EnumInfos:         file_proto_proprdb_options_proto_enumTypes,
 		MessageInfos:      file_proto_proprdb_options_proto_msgTypes,
 		ExtensionInfos:    file_proto_proprdb_options_proto_extTypes,
 	}.Build()
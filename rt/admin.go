@@ -0,0 +1,187 @@
+package proprdbrt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// AdminCoreTables are the core tables admin snapshots carry in addition to
+// the records of WriteSnapshot, which already cover _deleted and
+// _unknown_types.
+var AdminCoreTables = []string{CoreTableSyncName, CoreTableSchemaStateName, CoreTableRejectedName, CoreTableDescriptorsName}
+
+// CoreRow is one row of a core table in an admin snapshot. Values are
+// int64, float64, string, []byte or nil.
+type CoreRow struct {
+	Table   string
+	Columns []string
+	Values  []any
+}
+
+type coreRowJSON struct {
+	Table   string            `json:"table"`
+	Columns []string          `json:"columns"`
+	Values  []json.RawMessage `json:"values"`
+}
+
+type coreBlobJSON struct {
+	Base64 []byte `json:"base64"`
+}
+
+// MarshalJSON encodes BLOB values as {"base64": ...} so they stay distinct
+// from TEXT.
+func (r CoreRow) MarshalJSON() ([]byte, error) {
+	encoded := coreRowJSON{Table: r.Table, Columns: r.Columns, Values: make([]json.RawMessage, 0, len(r.Values))}
+	for _, value := range r.Values {
+		if blob, ok := value.([]byte); ok {
+			value = coreBlobJSON{Base64: blob}
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("encode %s value: %w", r.Table, err)
+		}
+		encoded.Values = append(encoded.Values, raw)
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes rows written by MarshalJSON, keeping integers exact.
+func (r *CoreRow) UnmarshalJSON(data []byte) error {
+	decoded := coreRowJSON{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	if len(decoded.Columns) != len(decoded.Values) {
+		return fmt.Errorf("%s row has %d columns and %d values", decoded.Table, len(decoded.Columns), len(decoded.Values))
+	}
+	*r = CoreRow{Table: decoded.Table, Columns: decoded.Columns, Values: make([]any, 0, len(decoded.Values))}
+	for _, raw := range decoded.Values {
+		value, err := decodeCoreValue(raw)
+		if err != nil {
+			return fmt.Errorf("decode %s value: %w", decoded.Table, err)
+		}
+		r.Values = append(r.Values, value)
+	}
+	return nil
+}
+
+func decodeCoreValue(raw json.RawMessage) (any, error) {
+	trimmed := strings.TrimSpace(string(raw))
+	switch {
+	case trimmed == "null":
+		return nil, nil
+	case strings.HasPrefix(trimmed, "{"):
+		blob := coreBlobJSON{}
+		if err := json.Unmarshal(raw, &blob); err != nil {
+			return nil, err
+		}
+		if blob.Base64 == nil {
+			blob.Base64 = []byte{}
+		}
+		return blob.Base64, nil
+	case strings.HasPrefix(trimmed, `"`):
+		var text string
+		err := json.Unmarshal(raw, &text)
+		return text, err
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err != nil {
+		return nil, err
+	}
+	if integer, err := number.Int64(); err == nil {
+		return integer, nil
+	}
+	return number.Float64()
+}
+
+// ListCoreRows returns the rows of the AdminCoreTables that exist, in
+// table and rowid order.
+func ListCoreRows(q DBTX) ([]CoreRow, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	coreRows := make([]CoreRow, 0)
+	for _, tableName := range AdminCoreTables {
+		exists, err := tableExists(q, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		rows, err := q.QueryContext(context.Background(), `SELECT * FROM `+quoteSQLiteIdentifier(tableName)+` ORDER BY rowid`)
+		if err != nil {
+			return nil, fmt.Errorf("select %s rows: %w", tableName, err)
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			if closeErr := CloseRows(rows, "core rows"); closeErr != nil {
+				return nil, fmt.Errorf("list %s columns: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("list %s columns: %w", tableName, err)
+		}
+		for rows.Next() {
+			values := make([]any, len(columns))
+			targets := make([]any, len(columns))
+			for index := range values {
+				targets[index] = &values[index]
+			}
+			if err := rows.Scan(targets...); err != nil {
+				if closeErr := CloseRows(rows, "core rows"); closeErr != nil {
+					return nil, fmt.Errorf("scan %s row: %w (additionally, %v)", tableName, err, closeErr)
+				}
+				return nil, fmt.Errorf("scan %s row: %w", tableName, err)
+			}
+			coreRows = append(coreRows, CoreRow{Table: tableName, Columns: columns, Values: values})
+		}
+		if err := rows.Err(); err != nil {
+			if closeErr := CloseRows(rows, "core rows"); closeErr != nil {
+				return nil, fmt.Errorf("iterate %s rows: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("iterate %s rows: %w", tableName, err)
+		}
+		if err := CloseRows(rows, "core rows"); err != nil {
+			return nil, err
+		}
+	}
+	return coreRows, nil
+}
+
+// clearCoreTables deletes the rows of tableNames, which must be
+// AdminCoreTables, before an admin snapshot restores them.
+func clearCoreTables(q DBTX, tableNames []string) error {
+	for _, tableName := range tableNames {
+		if !isAdminCoreTable(tableName) {
+			return fmt.Errorf("%s is not an admin core table", tableName)
+		}
+		if _, err := q.ExecContext(context.Background(), `DELETE FROM `+quoteSQLiteIdentifier(tableName)); err != nil {
+			return fmt.Errorf("clear %s: %w", tableName, err)
+		}
+	}
+	return nil
+}
+
+// restoreCoreRow inserts row, replacing a row with the same key.
+func restoreCoreRow(q DBTX, row CoreRow) error {
+	if !isAdminCoreTable(row.Table) {
+		return fmt.Errorf("%s is not an admin core table", row.Table)
+	}
+	columns := make([]string, 0, len(row.Columns))
+	for _, column := range row.Columns {
+		columns = append(columns, quoteSQLiteIdentifier(column))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	restoreSQL := `INSERT OR REPLACE INTO ` + quoteSQLiteIdentifier(row.Table) + ` (` + strings.Join(columns, ", ") + `) VALUES (` + placeholders + `)`
+	if _, err := q.ExecContext(context.Background(), restoreSQL, row.Values...); err != nil {
+		return fmt.Errorf("restore %s row: %w", row.Table, err)
+	}
+	return nil
+}
+
+func isAdminCoreTable(tableName string) bool {
+	return slices.Contains(AdminCoreTables, tableName)
+}
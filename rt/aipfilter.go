@@ -0,0 +1,343 @@
+package proprdbrt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFilter is wrapped by ParseAIPFilter for filters it cannot
+// parse or that name unknown fields.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// FilterColumn is a projected column that AIP-160 filters may name.
+type FilterColumn struct {
+	// Field is the dotted proto field path filters use, e.g. "address.city".
+	Field      string
+	Column     string
+	SQLiteType string
+	// Nullable is set for columns of optional fields, which are NULL when
+	// the field is unset.
+	Nullable bool
+	// Timestamp is set for google.protobuf.Timestamp fields, which filters
+	// compare with RFC 3339 strings.
+	Timestamp bool
+}
+
+// ParseAIPFilter compiles filter, a subset of the AIP-160 filter language,
+// to a Select condition over columns:
+//
+//	age >= 18 AND (name = "Ada" OR name = Grace)
+//	NOT has(address.city) -kind:internal
+//	occurred_at > "2024-01-01T00:00:00Z"
+//
+// Restrictions compare a field with =, !=, <, <=, > or >=; ":" is = on
+// scalar fields and presence with "*". has(field) is true for optional
+// fields that are set and other fields that are not their zero value.
+// Terms juxtaposed or joined by AND must all hold. As in AIP-160, OR binds
+// tighter than AND, so "a AND b OR c" is "a AND (b OR c)". NOT or a leading
+// "-" negates a term. Values are double or single quoted strings or bare
+// text; true and false compare with boolean columns. Wildcards and
+// traversal of repeated fields are not supported. An empty filter matches
+// every row.
+func ParseAIPFilter(filter string, columns []FilterColumn) (string, []any, error) {
+	tokens, err := lexAIPFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tokens) == 0 {
+		return "", nil, nil
+	}
+	parser := &aipFilterParser{tokens: tokens, columns: columns}
+	where, err := parser.expression()
+	if err != nil {
+		return "", nil, err
+	}
+	if token := parser.peek(); token.kind != aipEnd {
+		return "", nil, parser.errorf(token, "unexpected %q", token.text)
+	}
+	return where, parser.args, nil
+}
+
+type aipTokenKind int
+
+const (
+	aipEnd aipTokenKind = iota
+	aipText
+	aipString
+	aipComparator
+	aipOpen
+	aipClose
+)
+
+type aipToken struct {
+	kind aipTokenKind
+	text string
+	// offset is the byte offset of the token in the filter, for errors.
+	offset int
+}
+
+func lexAIPFilter(filter string) ([]aipToken, error) {
+	tokens := make([]aipToken, 0, 8)
+	for index := 0; index < len(filter); {
+		character := filter[index]
+		switch {
+		case character == ' ' || character == '\t' || character == '\n' || character == '\r':
+			index++
+		case character == '(' || character == ')':
+			kind := aipOpen
+			if character == ')' {
+				kind = aipClose
+			}
+			tokens = append(tokens, aipToken{kind: kind, text: string(character), offset: index})
+			index++
+		case character == '"' || character == '\'':
+			text, length, err := unquoteAIPString(filter[index:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: at byte %d: %w", ErrInvalidFilter, index, err)
+			}
+			tokens = append(tokens, aipToken{kind: aipString, text: text, offset: index})
+			index += length
+		case strings.ContainsRune("=!<>:", rune(character)):
+			length := 1
+			if index+1 < len(filter) && filter[index+1] == '=' && character != '=' && character != ':' {
+				length = 2
+			}
+			comparator := filter[index : index+length]
+			if comparator == "!" {
+				return nil, fmt.Errorf("%w: at byte %d: expected != ", ErrInvalidFilter, index)
+			}
+			tokens = append(tokens, aipToken{kind: aipComparator, text: comparator, offset: index})
+			index += length
+		default:
+			end := index
+			for end < len(filter) && !strings.ContainsRune(" \t\n\r()\"'=!<>:", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, aipToken{kind: aipText, text: filter[index:end], offset: index})
+			index = end
+		}
+	}
+	return tokens, nil
+}
+
+// unquoteAIPString returns the value of the string literal s starts with
+// and its length in s. Backslash escapes the next character.
+func unquoteAIPString(s string) (string, int, error) {
+	quote := s[0]
+	builder := strings.Builder{}
+	for index := 1; index < len(s); index++ {
+		switch s[index] {
+		case quote:
+			return builder.String(), index + 1, nil
+		case '\\':
+			index++
+			if index == len(s) {
+				return "", 0, errors.New("unterminated string")
+			}
+		}
+		builder.WriteByte(s[index])
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+type aipFilterParser struct {
+	tokens   []aipToken
+	position int
+	columns  []FilterColumn
+	args     []any
+}
+
+func (p *aipFilterParser) peek() aipToken {
+	if p.position == len(p.tokens) {
+		offset := 0
+		if len(p.tokens) > 0 {
+			last := p.tokens[len(p.tokens)-1]
+			offset = last.offset + len(last.text)
+		}
+		return aipToken{kind: aipEnd, offset: offset}
+	}
+	return p.tokens[p.position]
+}
+
+func (p *aipFilterParser) next() aipToken {
+	token := p.peek()
+	if token.kind != aipEnd {
+		p.position++
+	}
+	return token
+}
+
+func (p *aipFilterParser) errorf(token aipToken, format string, args ...any) error {
+	return fmt.Errorf("%w: at byte %d: %s", ErrInvalidFilter, token.offset, fmt.Sprintf(format, args...))
+}
+
+func isAIPKeyword(token aipToken, keyword string) bool {
+	return token.kind == aipText && token.text == keyword
+}
+
+// expression parses sequences joined by AND.
+func (p *aipFilterParser) expression() (string, error) {
+	terms := make([]string, 0, 2)
+	for {
+		sequence, err := p.sequence()
+		if err != nil {
+			return "", err
+		}
+		terms = append(terms, sequence)
+		if !isAIPKeyword(p.peek(), "AND") {
+			return strings.Join(terms, " AND "), nil
+		}
+		p.next()
+	}
+}
+
+// sequence parses juxtaposed factors, which must all hold.
+func (p *aipFilterParser) sequence() (string, error) {
+	factors := make([]string, 0, 2)
+	for {
+		factor, err := p.factor()
+		if err != nil {
+			return "", err
+		}
+		factors = append(factors, factor)
+		token := p.peek()
+		if token.kind == aipEnd || token.kind == aipClose || isAIPKeyword(token, "AND") {
+			return strings.Join(factors, " AND "), nil
+		}
+	}
+}
+
+// factor parses terms joined by OR.
+func (p *aipFilterParser) factor() (string, error) {
+	terms := make([]string, 0, 2)
+	for {
+		term, err := p.term()
+		if err != nil {
+			return "", err
+		}
+		terms = append(terms, term)
+		if !isAIPKeyword(p.peek(), "OR") {
+			break
+		}
+		p.next()
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", nil
+}
+
+// term parses a possibly negated restriction or parenthesized expression.
+func (p *aipFilterParser) term() (string, error) {
+	token := p.peek()
+	negated := false
+	switch {
+	case isAIPKeyword(token, "NOT"):
+		p.next()
+		negated = true
+	case token.text == "-" && p.position+1 < len(p.tokens) && p.tokens[p.position+1].kind == aipOpen:
+		p.next()
+		negated = true
+	case token.kind == aipText && len(token.text) > 1 && token.text[0] == '-':
+		p.tokens[p.position].text = token.text[1:]
+		p.tokens[p.position].offset++
+		negated = true
+	}
+	simple, err := p.simple()
+	if err != nil || !negated {
+		return simple, err
+	}
+	return "NOT (" + simple + ")", nil
+}
+
+func (p *aipFilterParser) simple() (string, error) {
+	token := p.next()
+	switch {
+	case token.kind == aipOpen:
+		expression, err := p.expression()
+		if err != nil {
+			return "", err
+		}
+		if closing := p.next(); closing.kind != aipClose {
+			return "", p.errorf(closing, "expected )")
+		}
+		return "(" + expression + ")", nil
+	case token.kind != aipText || isAIPKeyword(token, "AND") || isAIPKeyword(token, "OR") || isAIPKeyword(token, "NOT"):
+		return "", p.errorf(token, "expected a field, has() or (")
+	case token.text == "has" && p.peek().kind == aipOpen:
+		p.next()
+		field := p.next()
+		if field.kind != aipText {
+			return "", p.errorf(field, "expected a field")
+		}
+		column, err := p.column(field)
+		if err != nil {
+			return "", err
+		}
+		if closing := p.next(); closing.kind != aipClose {
+			return "", p.errorf(closing, "expected )")
+		}
+		return p.presence(column), nil
+	}
+	column, err := p.column(token)
+	if err != nil {
+		return "", err
+	}
+	comparator := p.next()
+	if comparator.kind != aipComparator {
+		return "", p.errorf(comparator, "expected a comparison of %s", token.text)
+	}
+	value := p.next()
+	if value.kind != aipText && value.kind != aipString {
+		return "", p.errorf(value, "expected a value to compare %s with", token.text)
+	}
+	operator := comparator.text
+	if operator == ":" {
+		if value.kind == aipText && value.text == "*" {
+			return p.presence(column), nil
+		}
+		operator = "="
+	}
+	arg, err := aipFilterValue(column, value.text)
+	if err != nil {
+		return "", p.errorf(value, "%s: %v", token.text, err)
+	}
+	p.args = append(p.args, arg)
+	return `"` + column.Column + `" ` + operator + ` ?`, nil
+}
+
+func (p *aipFilterParser) column(token aipToken) (FilterColumn, error) {
+	for _, column := range p.columns {
+		if column.Field == token.text {
+			return column, nil
+		}
+	}
+	return FilterColumn{}, p.errorf(token, "unknown field %q", token.text)
+}
+
+// presence is the condition of has(column).
+func (p *aipFilterParser) presence(column FilterColumn) string {
+	switch {
+	case column.Nullable || column.Timestamp:
+		return `"` + column.Column + `" IS NOT NULL`
+	case column.SQLiteType == "TEXT":
+		return `"` + column.Column + `" <> ''`
+	}
+	return `"` + column.Column + `" <> 0`
+}
+
+func aipFilterValue(column FilterColumn, value string) (any, error) {
+	if column.Timestamp {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("expected an RFC 3339 timestamp: %w", err)
+		}
+		if column.SQLiteType == "TEXT" {
+			return FormatTimestampText(parsed), nil
+		}
+		return parsed.UnixNano(), nil
+	}
+	return parseHTTPFilterValue(column.SQLiteType, value)
+}
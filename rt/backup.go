@@ -0,0 +1,225 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// backupAlias is the schema name a database being restored is attached as.
+const backupAlias = "proprdb_restore"
+
+// sqliteHeader starts every SQLite database file.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// ErrNotADatabase is returned by Restore for input that is not an SQLite
+// database.
+var ErrNotADatabase = errors.New("not an SQLite database")
+
+type connPinner interface {
+	Conn(ctx context.Context) (*sql.Conn, error)
+}
+
+type dbtxUnwrapper interface {
+	unwrapDBTX() DBTX
+}
+
+// Backup writes a consistent copy of the SQLite database of q to w. It uses
+// VACUUM INTO a temporary file, which reads a snapshot of the database
+// without blocking writers in WAL mode. q must not be a transaction.
+func Backup(ctx context.Context, q DBTX, w io.Writer) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if w == nil {
+		return errors.New("nil writer")
+	}
+	dir, err := os.MkdirTemp("", "proprdb-backup-")
+	if err != nil {
+		return fmt.Errorf("create backup directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.db")
+	if _, err := q.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("vacuum into backup: %w", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open backup: %w", err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces all tables, indexes, views and triggers of the database
+// of q with those of a database written by Backup, in one transaction, so
+// readers see either the old or the restored content. Generated tables
+// should be re-opened or their caches purged afterwards. q must not be a
+// transaction; a *sql.DB has one connection pinned for the restore.
+func Restore(ctx context.Context, q DBTX, r io.Reader) (err error) {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if r == nil {
+		return errors.New("nil reader")
+	}
+	q, release, err := pinConnection(ctx, q, "restore")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	dir, err := os.MkdirTemp("", "proprdb-restore-")
+	if err != nil {
+		return fmt.Errorf("create restore directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "restore.db")
+	if err := writeBackupFile(path, r); err != nil {
+		return err
+	}
+
+	if _, err := q.ExecContext(ctx, `ATTACH DATABASE ? AS `+backupAlias, path); err != nil {
+		return fmt.Errorf("attach backup: %w", err)
+	}
+	defer func() {
+		if _, detachErr := q.ExecContext(context.Background(), `DETACH DATABASE `+backupAlias); detachErr != nil {
+			err = errors.Join(err, fmt.Errorf("detach backup: %w", detachErr))
+		}
+	}()
+	if _, err := q.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return fmt.Errorf("begin restore: %w", err)
+	}
+	if err := replaceSchemaObjects(ctx, q); err != nil {
+		if _, rollbackErr := q.ExecContext(context.Background(), `ROLLBACK`); rollbackErr != nil {
+			return fmt.Errorf("%w (additionally, rollback: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `COMMIT`); err != nil {
+		return fmt.Errorf("commit restore: %w", err)
+	}
+	return nil
+}
+
+// pinConnection unwraps q and pins one connection of a *sql.DB, as ATTACH
+// and BEGIN only affect the connection running them. release returns the
+// connection to the pool.
+func pinConnection(ctx context.Context, q DBTX, purpose string) (pinned DBTX, release func(), err error) {
+	for {
+		unwrapper, ok := q.(dbtxUnwrapper)
+		if !ok {
+			break
+		}
+		q = unwrapper.unwrapDBTX()
+	}
+	pinner, ok := q.(connPinner)
+	if !ok {
+		return q, func() {}, nil
+	}
+	conn, err := pinner.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pin connection for %s: %w", purpose, err)
+	}
+	return conn, func() { _ = conn.Close() }, nil
+}
+
+func writeBackupFile(path string, r io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create restore file: %w", err)
+	}
+	header := make([]byte, len(sqliteHeader))
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header, sqliteHeader) {
+		return errors.Join(ErrNotADatabase, file.Close())
+	}
+	if _, err := file.Write(header); err != nil {
+		return errors.Join(fmt.Errorf("write restore file: %w", err), file.Close())
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		return errors.Join(fmt.Errorf("write restore file: %w", err), file.Close())
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close restore file: %w", err)
+	}
+	return nil
+}
+
+type schemaObject struct {
+	objectType string
+	name       string
+	sql        string
+}
+
+// replaceSchemaObjects drops the schema objects of main and recreates
+// those of the attached backup, copying table contents.
+func replaceSchemaObjects(ctx context.Context, q DBTX) error {
+	existing, err := listSchemaObjects(ctx, q, "main")
+	if err != nil {
+		return err
+	}
+	for _, object := range existing {
+		if object.objectType == "index" {
+			// Indexes are dropped with their tables.
+			continue
+		}
+		if _, err := q.ExecContext(ctx, `DROP `+strings.ToUpper(object.objectType)+` IF EXISTS main."`+object.name+`"`); err != nil {
+			return fmt.Errorf("drop %s %s: %w", object.objectType, object.name, err)
+		}
+	}
+	restored, err := listSchemaObjects(ctx, q, backupAlias)
+	if err != nil {
+		return err
+	}
+	for _, object := range restored {
+		if _, err := q.ExecContext(ctx, object.sql); err != nil {
+			return fmt.Errorf("create %s %s: %w", object.objectType, object.name, err)
+		}
+		if object.objectType != "table" {
+			continue
+		}
+		if _, err := q.ExecContext(ctx, `INSERT INTO main."`+object.name+`" SELECT * FROM `+backupAlias+`."`+object.name+`"`); err != nil {
+			return fmt.Errorf("copy table %s: %w", object.name, err)
+		}
+	}
+	return nil
+}
+
+// listSchemaObjects returns the user schema objects of schema, tables first
+// so indexes, views and triggers can refer to them.
+func listSchemaObjects(ctx context.Context, q DBTX, schema string) ([]schemaObject, error) {
+	rows, err := q.QueryContext(ctx, `SELECT type, name, sql FROM `+schema+`.sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY type <> 'table', rowid`)
+	if err != nil {
+		return nil, fmt.Errorf("list schema of %s: %w", schema, err)
+	}
+	objects := make([]schemaObject, 0)
+	for rows.Next() {
+		var object schemaObject
+		if err := rows.Scan(&object.objectType, &object.name, &object.sql); err != nil {
+			if closeErr := CloseRows(rows, "schema"); closeErr != nil {
+				return nil, fmt.Errorf("scan schema of %s: %w (additionally, %v)", schema, err, closeErr)
+			}
+			return nil, fmt.Errorf("scan schema of %s: %w", schema, err)
+		}
+		objects = append(objects, object)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "schema"); closeErr != nil {
+			return nil, fmt.Errorf("iterate schema of %s: %w (additionally, %v)", schema, err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate schema of %s: %w", schema, err)
+	}
+	if err := CloseRows(rows, "schema"); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
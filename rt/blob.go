@@ -0,0 +1,371 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	proprdbpb "github.com/fingon/proprdb/proto/proprdb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// DefaultBlobMinSize is the smallest (proprdb.blob_external) value spilled
+// to Options.BlobStore when Options.BlobMinSize is zero.
+const DefaultBlobMinSize = 4096
+
+// blobReferencePrefix starts the value a spilled field keeps in the data
+// column, followed by the hex SHA-256 of the content.
+const blobReferencePrefix = "\x00proprdb-blob:sha256:"
+
+const blobReferenceLength = len(blobReferencePrefix) + 2*sha256.Size
+
+// ErrBlobNotFound is returned by BlobStore.Get for unknown hashes.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore holds the content of (proprdb.blob_external) fields by SHA-256,
+// e.g. a directory or an object store bucket. Every replica reading the
+// rows needs access to the same blobs. Content is encrypted with
+// Options.Cipher when set; the hash is that of the plain content.
+type BlobStore interface {
+	// Put stores data under its hex SHA-256 hash. Storing an existing hash
+	// again must refresh its BlobInfo.ModTime.
+	Put(ctx context.Context, hash string, data []byte) error
+	// Get returns the content of hash, or ErrBlobNotFound.
+	Get(ctx context.Context, hash string) ([]byte, error)
+	// Delete removes hash; unknown hashes are not an error.
+	Delete(ctx context.Context, hash string) error
+	// List returns every stored blob.
+	List(ctx context.Context) ([]BlobInfo, error)
+}
+
+// BlobInfo describes one blob of a BlobStore.
+type BlobInfo struct {
+	Hash    string
+	ModTime time.Time
+}
+
+// DirBlobStore is a BlobStore keeping each blob in a file under Dir,
+// sharded by the first two hex digits of the hash.
+type DirBlobStore struct {
+	Dir string
+}
+
+func (s DirBlobStore) path(hash string) (string, error) {
+	if !validBlobHash(hash) {
+		return "", fmt.Errorf("invalid blob hash %q", hash)
+	}
+	return filepath.Join(s.Dir, hash[:2], hash), nil
+}
+
+func (s DirBlobStore) Put(_ context.Context, hash string, data []byte) error {
+	path, err := s.path(hash)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create blob directory: %w", err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), hash+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create blob %s: %w", hash, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		if closeErr := file.Close(); closeErr != nil {
+			return fmt.Errorf("write blob %s: %w (additionally, %v)", hash, err, closeErr)
+		}
+		return fmt.Errorf("write blob %s: %w", hash, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close blob %s: %w", hash, err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("rename blob %s: %w", hash, err)
+	}
+	return nil
+}
+
+func (s DirBlobStore) Get(_ context.Context, hash string) ([]byte, error) {
+	path, err := s.path(hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, hash)
+	}
+	return data, err
+}
+
+func (s DirBlobStore) Delete(_ context.Context, hash string) error {
+	path, err := s.path(hash)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete blob %s: %w", hash, err)
+	}
+	return nil
+}
+
+func (s DirBlobStore) List(_ context.Context) ([]BlobInfo, error) {
+	blobs := make([]BlobInfo, 0)
+	err := filepath.WalkDir(s.Dir, func(path string, entry fs.DirEntry, err error) error {
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && path == s.Dir:
+			return fs.SkipDir
+		case err != nil:
+			return err
+		case entry.IsDir() || !validBlobHash(entry.Name()):
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, BlobInfo{Hash: entry.Name(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list blobs in %s: %w", s.Dir, err)
+	}
+	return blobs, nil
+}
+
+func validBlobHash(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	for _, r := range hash {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+var blobFieldsByMessage sync.Map // protoreflect.FullName -> []protoreflect.FieldDescriptor
+
+// blobFields returns the (proprdb.blob_external) fields of descriptor.
+func blobFields(descriptor protoreflect.MessageDescriptor) []protoreflect.FieldDescriptor {
+	if cached, ok := blobFieldsByMessage.Load(descriptor.FullName()); ok {
+		return cached.([]protoreflect.FieldDescriptor)
+	}
+	var fields []protoreflect.FieldDescriptor
+	for index := range descriptor.Fields().Len() {
+		field := descriptor.Fields().Get(index)
+		if external, _ := proto.GetExtension(field.Options(), proprdbpb.E_BlobExternal).(bool); external {
+			fields = append(fields, field)
+		}
+	}
+	blobFieldsByMessage.Store(descriptor.FullName(), fields)
+	return fields
+}
+
+// spillBlobs returns message with its (proprdb.blob_external) values of at
+// least Options.BlobMinSize bytes moved to Options.BlobStore, or message
+// itself when nothing spills.
+func spillBlobs(opts Options, message proto.Message) (proto.Message, error) {
+	if opts.BlobStore == nil {
+		return message, nil
+	}
+	minSize := opts.BlobMinSize
+	if minSize == 0 {
+		minSize = DefaultBlobMinSize
+	}
+	var spilled protoreflect.Message
+	for _, field := range blobFields(message.ProtoReflect().Descriptor()) {
+		data := message.ProtoReflect().Get(field).Bytes()
+		if len(data) < minSize {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		sealed, err := sealData(opts.Cipher, data)
+		if err != nil {
+			return nil, fmt.Errorf("seal blob of %s: %w", field.FullName(), err)
+		}
+		if err := opts.BlobStore.Put(context.Background(), hash, sealed); err != nil {
+			return nil, fmt.Errorf("store blob of %s: %w", field.FullName(), err)
+		}
+		if spilled == nil {
+			spilled = proto.Clone(message).ProtoReflect()
+		}
+		spilled.Set(field, protoreflect.ValueOfBytes([]byte(blobReferencePrefix+hash)))
+	}
+	if spilled == nil {
+		return message, nil
+	}
+	return spilled.Interface(), nil
+}
+
+// loadBlobs replaces the blob references in message with their content.
+func loadBlobs(opts Options, message proto.Message) error {
+	reflected := message.ProtoReflect()
+	for _, field := range blobFields(reflected.Descriptor()) {
+		hash, ok := blobReference(reflected.Get(field).Bytes())
+		if !ok {
+			continue
+		}
+		if opts.BlobStore == nil {
+			return fmt.Errorf("load blob of %s: no blob store configured", field.FullName())
+		}
+		sealed, err := opts.BlobStore.Get(context.Background(), hash)
+		if err != nil {
+			return fmt.Errorf("load blob of %s: %w", field.FullName(), err)
+		}
+		data, err := openData(opts.Cipher, sealed)
+		if err != nil {
+			return fmt.Errorf("open blob of %s: %w", field.FullName(), err)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+			return fmt.Errorf("load blob of %s: content does not match hash %s", field.FullName(), hash)
+		}
+		reflected.Set(field, protoreflect.ValueOfBytes(data))
+	}
+	return nil
+}
+
+func blobReference(value []byte) (string, bool) {
+	if len(value) != blobReferenceLength || !bytes.HasPrefix(value, []byte(blobReferencePrefix)) {
+		return "", false
+	}
+	hash := string(value[len(blobReferencePrefix):])
+	return hash, validBlobHash(hash)
+}
+
+// CollectBlobGarbage deletes the blobs of opts.BlobStore that no row of the
+// data columns in q references and that were last stored more than
+// olderThan ago, and returns how many it deleted. The grace period covers
+// blobs stored by writes that have not committed yet. History tables count
+// as references, so older versions stay readable.
+func CollectBlobGarbage(ctx context.Context, q DBTX, opts Options, olderThan time.Duration) (int, error) {
+	switch {
+	case q == nil:
+		return 0, errors.New("nil DBTX")
+	case opts.BlobStore == nil:
+		return 0, errors.New("no blob store configured")
+	}
+	blobs, err := opts.BlobStore.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	referenced, err := referencedBlobs(ctx, q, opts)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	deleted := 0
+	for _, blob := range blobs {
+		if referenced[blob.Hash] || blob.ModTime.After(cutoff) {
+			continue
+		}
+		if err := opts.BlobStore.Delete(ctx, blob.Hash); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// referencedBlobs scans the data column of every table outside the core
+// tables for blob references.
+func referencedBlobs(ctx context.Context, q DBTX, opts Options) (map[string]bool, error) {
+	tableNames, err := blobDataTables(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, tableName := range tableNames {
+		rows, err := q.QueryContext(ctx, `SELECT data FROM `+quoteSQLiteIdentifier(tableName)+` WHERE data IS NOT NULL`)
+		if err != nil {
+			return nil, fmt.Errorf("scan %s for blobs: %w", tableName, err)
+		}
+		for rows.Next() {
+			var stored []byte
+			err := rows.Scan(&stored)
+			if err == nil {
+				err = collectBlobReferences(opts, stored, referenced)
+			}
+			if err != nil {
+				if closeErr := CloseRows(rows, "blob references"); closeErr != nil {
+					return nil, fmt.Errorf("scan %s for blobs: %w (additionally, %v)", tableName, err, closeErr)
+				}
+				return nil, fmt.Errorf("scan %s for blobs: %w", tableName, err)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			if closeErr := CloseRows(rows, "blob references"); closeErr != nil {
+				return nil, fmt.Errorf("scan %s for blobs: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("scan %s for blobs: %w", tableName, err)
+		}
+		if err := CloseRows(rows, "blob references"); err != nil {
+			return nil, err
+		}
+	}
+	return referenced, nil
+}
+
+func blobDataTables(ctx context.Context, q DBTX) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT m.name FROM sqlite_master m WHERE m.type = 'table'`+
+		` AND substr(m.name, 1, 1) <> '_' AND m.name NOT LIKE 'sqlite_%'`+
+		` AND EXISTS (SELECT 1 FROM pragma_table_info(m.name) WHERE name = 'data') ORDER BY m.name`)
+	if err != nil {
+		return nil, fmt.Errorf("query data tables: %w", err)
+	}
+	tableNames := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			if closeErr := CloseRows(rows, "blob data tables"); closeErr != nil {
+				return nil, fmt.Errorf("scan data table: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan data table: %w", err)
+		}
+		tableNames = append(tableNames, name)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "blob data tables"); closeErr != nil {
+			return nil, fmt.Errorf("iterate data tables: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate data tables: %w", err)
+	}
+	if err := CloseRows(rows, "blob data tables"); err != nil {
+		return nil, err
+	}
+	return tableNames, nil
+}
+
+// collectBlobReferences adds the hashes referenced by a stored data value
+// to referenced. The serialized message is searched for references rather
+// than parsed, as the type of the row may not be linked in.
+func collectBlobReferences(opts Options, stored []byte, referenced map[string]bool) error {
+	plain, err := decodeData(opts, stored)
+	if err != nil {
+		return err
+	}
+	for {
+		index := bytes.Index(plain, []byte(blobReferencePrefix))
+		if index < 0 {
+			return nil
+		}
+		plain = plain[index:]
+		if hash, ok := blobReference(plain[:min(len(plain), blobReferenceLength)]); ok {
+			referenced[hash] = true
+		}
+		plain = plain[len(blobReferencePrefix):]
+	}
+}
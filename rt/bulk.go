@@ -0,0 +1,351 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultBulkBatchSize is the number of records per table ReadJSONLBulk
+// buffers before writing them when no batch size is given.
+const DefaultBulkBatchSize = 1000
+
+// bulkMaxVariables bounds the bound parameters of one statement. It is the
+// SQLite default for builds before 3.32.0; newer builds allow more.
+const bulkMaxVariables = 999
+
+// BulkTable describes how ReadJSONLBulk writes the records of one table.
+type BulkTable struct {
+	TableName string
+	// UpsertSQL is the generated single-row upsert statement. Its VALUES
+	// group is repeated for multi-row writes.
+	UpsertSQL string
+	Strategy  ConflictStrategy
+	// Values decodes a live record into the UpsertSQL arguments. Records it
+	// fails with a RejectedError are left to BulkImporter.Guard.
+	Values func(record JSONLRecord, lineNumber int) ([]any, error)
+}
+
+type bulkRecord struct {
+	record     JSONLRecord
+	lineNumber int
+}
+
+type bulkBatch struct {
+	table   BulkTable
+	records []bulkRecord
+}
+
+type bulkSyncKey struct {
+	objectID  string
+	tableName string
+}
+
+// BulkImporter buffers remote records per table and applies them with
+// multi-row statements. Conflicts are resolved in memory against the local
+// timestamps of the whole batch, with the same outcome as applying the
+// records one by one. _sync writes are deferred until Flush.
+//
+// A nil *BulkImporter queues nothing and writes _sync immediately, so
+// generated code can share one read loop between both import modes.
+type BulkImporter struct {
+	// Instrumentation, when set, receives the sync metrics of queued records.
+	Instrumentation Instrumentation
+	// Guard, when set, handles records rejected by BulkTable.Values. When
+	// nil, they fail the import.
+	Guard *ImportGuard
+
+	q         DBTX
+	remote    string
+	batchSize int
+	batches   map[string]*bulkBatch
+	syncAtNs  map[bulkSyncKey]int64
+	syncOrder []bulkSyncKey
+}
+
+// NewBulkImporter returns an importer writing to q. A batchSize <= 0 means
+// DefaultBulkBatchSize.
+func NewBulkImporter(q DBTX, remote string, batchSize int) *BulkImporter {
+	if batchSize <= 0 {
+		batchSize = DefaultBulkBatchSize
+	}
+	return &BulkImporter{
+		q:         q,
+		remote:    remote,
+		batchSize: batchSize,
+		batches:   make(map[string]*bulkBatch),
+		syncAtNs:  make(map[bulkSyncKey]int64),
+	}
+}
+
+// AddTable enables batching for records of typeName. Records of other types
+// are left to the caller.
+func (b *BulkImporter) AddTable(typeName string, table BulkTable) {
+	b.batches[typeName] = &bulkBatch{table: table}
+}
+
+// Queue buffers record if its type was added with AddTable and reports
+// whether it did. A full batch is written before Queue returns.
+func (b *BulkImporter) Queue(typeName string, record JSONLRecord, lineNumber int) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+	batch, ok := b.batches[typeName]
+	if !ok {
+		return false, nil
+	}
+	b.deferSync(record.ID, batch.table.TableName, record.AtNs)
+	batch.records = append(batch.records, bulkRecord{record: record, lineNumber: lineNumber})
+	if len(batch.records) < b.batchSize {
+		return true, nil
+	}
+	return true, b.flushBatch(batch)
+}
+
+// SyncUpsert records that remote has objectID at atNs. It is deferred until
+// Flush unless b is nil.
+func (b *BulkImporter) SyncUpsert(q DBTX, objectID, tableName, remote string, atNs int64) error {
+	if b == nil {
+		return SyncUpsert(q, objectID, tableName, remote, atNs)
+	}
+	b.deferSync(objectID, tableName, atNs)
+	return nil
+}
+
+func (b *BulkImporter) deferSync(objectID, tableName string, atNs int64) {
+	if b.remote == "" {
+		return
+	}
+	key := bulkSyncKey{objectID: objectID, tableName: tableName}
+	previousAtNs, ok := b.syncAtNs[key]
+	if !ok {
+		b.syncOrder = append(b.syncOrder, key)
+	}
+	if !ok || atNs > previousAtNs {
+		b.syncAtNs[key] = atNs
+	}
+}
+
+// Flush writes all buffered records and the deferred _sync rows.
+func (b *BulkImporter) Flush() error {
+	if b == nil {
+		return nil
+	}
+	typeNames := make([]string, 0, len(b.batches))
+	for typeName := range b.batches {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+	for _, typeName := range typeNames {
+		if err := b.flushBatch(b.batches[typeName]); err != nil {
+			return err
+		}
+	}
+	return b.flushSync()
+}
+
+func (b *BulkImporter) flushBatch(batch *bulkBatch) error {
+	if len(batch.records) == 0 {
+		return nil
+	}
+	table := batch.table
+	records := batch.records
+	batch.records = nil
+
+	ids := make([]string, 0, len(records))
+	seen := make(map[string]bool, len(records))
+	for _, record := range records {
+		if !seen[record.record.ID] {
+			seen[record.record.ID] = true
+			ids = append(ids, record.record.ID)
+		}
+	}
+	localMaxAtNs, err := localMaxAtNsByID(b.q, table.TableName, ids)
+	if err != nil {
+		return err
+	}
+	winners := make(map[string]bulkRecord, len(ids))
+	for _, record := range records {
+		id := record.record.ID
+		RecordSyncRead(b.Instrumentation, table.TableName, record.record.AtNs, localMaxAtNs[id])
+		if !ShouldApplyRemote(table.Strategy, record.record.AtNs, localMaxAtNs[id], record.record.Deleted) {
+			continue
+		}
+		winners[id] = record
+		localMaxAtNs[id] = record.record.AtNs
+	}
+
+	upsertIDs := make([]string, 0, len(winners))
+	upsertRows := make([][]any, 0, len(winners))
+	tombstoneIDs := make([]string, 0)
+	tombstoneArgs := make([][]any, 0)
+	for _, id := range ids {
+		winner, ok := winners[id]
+		if !ok {
+			continue
+		}
+		if winner.record.Deleted {
+			tombstoneIDs = append(tombstoneIDs, id)
+			tombstoneArgs = append(tombstoneArgs, []any{table.TableName, id, winner.record.AtNs})
+			continue
+		}
+		values, err := table.Values(winner.record, winner.lineNumber)
+		if err != nil {
+			if err := b.Guard.CheckRecord(winner.record, winner.lineNumber, err); err != nil {
+				return err
+			}
+			continue
+		}
+		upsertIDs = append(upsertIDs, id)
+		upsertRows = append(upsertRows, values)
+	}
+
+	deleteTombstonesSQL := `DELETE FROM ` + CoreTableDeletedName + ` WHERE table_name = ? AND id IN (%s)`
+	if err := execForIDs(b.q, deleteTombstonesSQL, []any{table.TableName}, upsertIDs); err != nil {
+		return fmt.Errorf("delete tombstones for %s: %w", table.TableName, err)
+	}
+	if err := execMultiRow(b.q, table.UpsertSQL, upsertRows); err != nil {
+		return fmt.Errorf("bulk upsert into %s: %w", table.TableName, err)
+	}
+	insertTombstonesSQL := `INSERT INTO ` + CoreTableDeletedName + ` (table_name, id, at_ns) VALUES (?, ?, ?) ON CONFLICT(table_name, id) DO UPDATE SET at_ns = excluded.at_ns`
+	if err := execMultiRow(b.q, insertTombstonesSQL, tombstoneArgs); err != nil {
+		return fmt.Errorf("bulk insert tombstones for %s: %w", table.TableName, err)
+	}
+	deleteRowsSQL := `DELETE FROM "` + table.TableName + `" WHERE id IN (%s)`
+	if err := execForIDs(b.q, deleteRowsSQL, nil, tombstoneIDs); err != nil {
+		return fmt.Errorf("delete tombstoned rows from %s: %w", table.TableName, err)
+	}
+	return nil
+}
+
+func (b *BulkImporter) flushSync() error {
+	rows := make([][]any, 0, len(b.syncOrder))
+	for _, key := range b.syncOrder {
+		rows = append(rows, []any{key.objectID, key.tableName, b.syncAtNs[key], b.remote})
+	}
+	b.syncAtNs = make(map[bulkSyncKey]int64)
+	b.syncOrder = nil
+	upsertSyncSQL := `INSERT INTO ` + CoreTableSyncName + ` (object_id, table_name, at_ns, remote) VALUES (?, ?, ?, ?) ON CONFLICT(object_id, table_name, remote) DO UPDATE SET at_ns = CASE WHEN excluded.at_ns > at_ns THEN excluded.at_ns ELSE at_ns END`
+	if err := execMultiRow(b.q, upsertSyncSQL, rows); err != nil {
+		return fmt.Errorf("bulk upsert sync rows for %s: %w", b.remote, err)
+	}
+	return nil
+}
+
+// localMaxAtNsByID is LocalMaxAtNs for many ids. Ids without a row or
+// tombstone map to -1.
+func localMaxAtNsByID(q DBTX, tableName string, ids []string) (map[string]int64, error) {
+	maxAtNs := make(map[string]int64, len(ids))
+	for _, id := range ids {
+		maxAtNs[id] = -1
+	}
+	ctx := context.Background()
+	// Each id is bound twice, plus the table name.
+	chunkSize := (bulkMaxVariables - 1) / 2
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")
+		args := make([]any, 0, 2*len(chunk)+1)
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+		args = append(args, tableName)
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+		selectSQL := `SELECT id, at_ns FROM "` + tableName + `" WHERE id IN (` + placeholders + `)
+UNION ALL SELECT id, at_ns FROM ` + CoreTableDeletedName + ` WHERE table_name = ? AND id IN (` + placeholders + `)`
+		rows, err := q.QueryContext(ctx, selectSQL, args...)
+		if err != nil {
+			return nil, fmt.Errorf("select local timestamps for %s: %w", tableName, err)
+		}
+		if err := scanMaxAtNs(rows, maxAtNs); err != nil {
+			return nil, fmt.Errorf("select local timestamps for %s: %w", tableName, err)
+		}
+	}
+	return maxAtNs, nil
+}
+
+func scanMaxAtNs(rows *sql.Rows, maxAtNs map[string]int64) error {
+	for rows.Next() {
+		var id string
+		var atNs int64
+		if err := rows.Scan(&id, &atNs); err != nil {
+			if closeErr := CloseRows(rows, "local timestamp"); closeErr != nil {
+				return fmt.Errorf("scan: %w (additionally, %v)", err, closeErr)
+			}
+			return fmt.Errorf("scan: %w", err)
+		}
+		if atNs > maxAtNs[id] {
+			maxAtNs[id] = atNs
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "local timestamp"); closeErr != nil {
+			return fmt.Errorf("iterate: %w (additionally, %v)", err, closeErr)
+		}
+		return fmt.Errorf("iterate: %w", err)
+	}
+	return CloseRows(rows, "local timestamp")
+}
+
+// execForIDs runs queryFormat, whose %s is replaced with placeholders for
+// ids, over ids in chunks. prefixArgs precede the ids in every chunk.
+func execForIDs(q DBTX, queryFormat string, prefixArgs []any, ids []string) error {
+	ctx := context.Background()
+	chunkSize := bulkMaxVariables - len(prefixArgs)
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		args := append(make([]any, 0, len(prefixArgs)+len(chunk)), prefixArgs...)
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")
+		if _, err := q.ExecContext(ctx, fmt.Sprintf(queryFormat, placeholders), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execMultiRow runs the single-row INSERT statement singleRowSQL for all
+// rows, repeating its VALUES group to write as many rows per statement as
+// the parameter limit allows.
+func execMultiRow(q DBTX, singleRowSQL string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	valuesStart := strings.Index(singleRowSQL, " VALUES (")
+	if valuesStart < 0 {
+		return errors.New("statement has no VALUES group")
+	}
+	groupStart := valuesStart + len(" VALUES ")
+	groupLength := strings.Index(singleRowSQL[groupStart:], ")")
+	if groupLength < 0 {
+		return errors.New("statement has an unterminated VALUES group")
+	}
+	groupEnd := groupStart + groupLength + 1
+	group := singleRowSQL[groupStart:groupEnd]
+	columnCount := len(rows[0])
+	if columnCount == 0 || strings.Count(group, "?") != columnCount {
+		return fmt.Errorf("statement expects %d values per row, got %d", strings.Count(group, "?"), columnCount)
+	}
+	chunkRows := max(1, bulkMaxVariables/columnCount)
+	ctx := context.Background()
+	for start := 0; start < len(rows); start += chunkRows {
+		chunk := rows[start:min(start+chunkRows, len(rows))]
+		groups := strings.TrimSuffix(strings.Repeat(group+", ", len(chunk)), ", ")
+		query := singleRowSQL[:groupStart] + groups + singleRowSQL[groupEnd:]
+		args := make([]any, 0, len(chunk)*columnCount)
+		for _, row := range chunk {
+			args = append(args, row...)
+		}
+		if _, err := q.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
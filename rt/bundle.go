@@ -0,0 +1,74 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Bundle is implemented by generated CRUD wrappers and lets generic tooling
+// operate on them without depending on generated types.
+type Bundle interface {
+	Init() error
+	TableDescriptors() []GeneratedTableDescriptor
+	WriteJSONL(remote string, w io.Writer) error
+	ReadJSONL(remote string, r io.Reader) error
+}
+
+var coreTableNames = []string{CoreTableDeletedName, CoreTableSyncName, CoreTableSchemaStateName, CoreTableUnknownName, CoreTableRejectedName, CoreTableDescriptorsName}
+
+// DiscoverTableDescriptors lists tables recorded in _proprdb_schema plus the
+// core tables. Type names and sync flags are unknown without generated code.
+func DiscoverTableDescriptors(q DBTX) ([]GeneratedTableDescriptor, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	ctx := context.Background()
+	rows, err := q.QueryContext(ctx, `SELECT table_name FROM `+CoreTableSchemaStateName+` ORDER BY table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("select schema state tables: %w", err)
+	}
+	descriptors := make([]GeneratedTableDescriptor, 0)
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			if closeErr := CloseRows(rows, "schema state"); closeErr != nil {
+				return nil, fmt.Errorf("scan schema state row: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan schema state row: %w", err)
+		}
+		descriptors = append(descriptors, GeneratedTableDescriptor{TableName: tableName})
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "schema state"); closeErr != nil {
+			return nil, fmt.Errorf("iterate schema state rows: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate schema state rows: %w", err)
+	}
+	if err := CloseRows(rows, "schema state"); err != nil {
+		return nil, err
+	}
+	for _, tableName := range coreTableNames {
+		descriptors = append(descriptors, GeneratedTableDescriptor{TableName: tableName, IsCore: true})
+	}
+	return descriptors, nil
+}
+
+// PurgeTombstones deletes tombstones older than beforeNs. Peers that have not
+// yet received a purged deletion may resurrect the object on their next sync.
+func PurgeTombstones(q DBTX, beforeNs int64) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	ctx := context.Background()
+	result, err := q.ExecContext(ctx, `DELETE FROM `+CoreTableDeletedName+` WHERE at_ns < ?`, beforeNs)
+	if err != nil {
+		return 0, fmt.Errorf("purge tombstones: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("count purged tombstones: %w", err)
+	}
+	return purged, nil
+}
@@ -0,0 +1,137 @@
+package proprdbrt
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CacheOptions configures the read-through row cache of generated tables.
+type CacheOptions struct {
+	// Size is the maximum number of rows cached per table. Zero disables
+	// caching.
+	Size int
+	// TTL bounds how long a row stays cached. Zero keeps rows until they are
+	// evicted or invalidated.
+	TTL time.Duration
+}
+
+// RowCache is a concurrency-safe LRU cache of rows by id. A nil *RowCache
+// is valid and caches nothing.
+type RowCache[T any] struct {
+	mu         sync.Mutex
+	size       int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	order      *list.List
+	generation uint64
+}
+
+type rowCacheEntry[T any] struct {
+	id        string
+	row       T
+	expiresAt time.Time
+}
+
+// NewRowCache returns a cache configured by opts, or nil when opts.Size is
+// not positive.
+func NewRowCache[T any](opts CacheOptions) *RowCache[T] {
+	if opts.Size <= 0 {
+		return nil
+	}
+	return &RowCache[T]{
+		size:    opts.Size,
+		ttl:     opts.TTL,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// GetOrLoad returns the cached row of id, or calls load and caches its row
+// when found. A load racing with Invalidate or Purge is not cached, so the
+// cache never keeps a row read before a write it has been told about.
+func (c *RowCache[T]) GetOrLoad(id string, load func() (T, bool, error)) (T, bool, error) {
+	if c == nil {
+		return load()
+	}
+	c.mu.Lock()
+	if element, ok := c.entries[id]; ok {
+		entry := element.Value.(*rowCacheEntry[T])
+		if c.ttl <= 0 || time.Now().Before(entry.expiresAt) {
+			c.order.MoveToFront(element)
+			c.mu.Unlock()
+			return entry.row, true, nil
+		}
+		c.remove(element)
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	row, found, err := load()
+	if err != nil || !found {
+		return row, found, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.put(id, row)
+	}
+	return row, true, nil
+}
+
+// Invalidate drops the row of id.
+func (c *RowCache[T]) Invalidate(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if element, ok := c.entries[id]; ok {
+		c.remove(element)
+	}
+}
+
+// Purge drops all rows.
+func (c *RowCache[T]) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Len returns the number of cached rows, including expired ones not yet
+// dropped.
+func (c *RowCache[T]) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *RowCache[T]) put(id string, row T) {
+	entry := &rowCacheEntry[T]{id: id, row: row}
+	if c.ttl > 0 {
+		entry.expiresAt = time.Now().Add(c.ttl)
+	}
+	if element, ok := c.entries[id]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[id] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *RowCache[T]) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*rowCacheEntry[T]).id)
+}
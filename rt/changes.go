@@ -0,0 +1,272 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CoreTableChangesName is the change feed table, created by
+// EnableChangeFeed.
+const CoreTableChangesName = "_changes"
+
+const changesTableSQL = `CREATE TABLE IF NOT EXISTS ` + CoreTableChangesName + ` (seq INTEGER PRIMARY KEY AUTOINCREMENT, table_name TEXT NOT NULL, id TEXT NOT NULL, op TEXT NOT NULL, at_ns INTEGER NOT NULL)`
+
+// ChangeOp is the kind of a Change.
+type ChangeOp string
+
+const (
+	ChangeInsert ChangeOp = "insert"
+	ChangeUpdate ChangeOp = "update"
+	ChangeDelete ChangeOp = "delete"
+)
+
+// Change is a row of the change feed.
+type Change struct {
+	// Seq increases with every change and is never reused, also after
+	// TrimChanges.
+	Seq       int64
+	TableName string
+	ID        string
+	Op        ChangeOp
+	// AtNs is the at_ns of the written row, or of the tombstone for
+	// deletes that left one.
+	AtNs int64
+}
+
+// changeTriggerName returns the name of the change trigger kind of
+// tableName.
+func changeTriggerName(tableName, kind string) string {
+	return quoteSQLiteIdentifier(CoreTableChangesName + "_" + tableName + "_" + kind)
+}
+
+// softDeleteTriggerPrefix prefixes the kinds of the triggers replacing the
+// update trigger on tables with a deleted_at_ns column.
+const softDeleteTriggerPrefix = "soft_"
+
+// changeTriggers returns the names and DDL of the triggers feeding the
+// changes of tableName into _changes. Updates that keep at_ns and data, such
+// as reprojections, are not changes. With softDelete, setting deleted_at_ns
+// is a delete at the at_ns of the tombstone, rows stay deleted until an
+// update clears it, and purging them records no second delete.
+func changeTriggers(tableName string, softDelete bool) [][2]string {
+	table := quoteSQLiteIdentifier(tableName)
+	insert := `INSERT INTO ` + CoreTableChangesName + ` (table_name, id, op, at_ns) VALUES (` + quoteSQLiteString(tableName)
+	tombstoneAtNs := func(row, fallback string) string {
+		return `COALESCE((SELECT at_ns FROM ` + CoreTableDeletedName + ` WHERE table_name = ` + quoteSQLiteString(tableName) +
+			` AND id = ` + row + `.id), ` + fallback + `)`
+	}
+	trigger := func(kind, event, when, row string, op ChangeOp, atNs string) [2]string {
+		name := changeTriggerName(tableName, kind)
+		if when != "" {
+			when = ` WHEN ` + when
+		}
+		return [2]string{name, `CREATE TRIGGER IF NOT EXISTS ` + name + ` AFTER ` + event + ` ON ` + table + when + ` BEGIN ` +
+			insert + `, ` + row + `.id, '` + string(op) + `', ` + atNs + `); END`}
+	}
+	triggers := [][2]string{trigger(string(ChangeInsert), "INSERT", "", "NEW", ChangeInsert, "NEW.at_ns")}
+	if softDelete {
+		triggers = append(triggers,
+			trigger(softDeleteTriggerPrefix+string(ChangeUpdate), "UPDATE",
+				`NEW.deleted_at_ns IS NULL AND (NEW.at_ns IS NOT OLD.at_ns OR NEW.data IS NOT OLD.data OR OLD.deleted_at_ns IS NOT NULL)`,
+				"NEW", ChangeUpdate, "NEW.at_ns"),
+			trigger(softDeleteTriggerPrefix+string(ChangeDelete), "UPDATE",
+				`NEW.deleted_at_ns IS NOT NULL AND NEW.deleted_at_ns IS NOT OLD.deleted_at_ns`,
+				"NEW", ChangeDelete, tombstoneAtNs("NEW", "NEW.deleted_at_ns")),
+			trigger(softDeleteTriggerPrefix+"purge", "DELETE", `OLD.deleted_at_ns IS NULL`,
+				"OLD", ChangeDelete, tombstoneAtNs("OLD", "OLD.at_ns")))
+		return triggers
+	}
+	return append(triggers,
+		trigger(string(ChangeUpdate), "UPDATE", `NEW.at_ns IS NOT OLD.at_ns OR NEW.data IS NOT OLD.data`, "NEW", ChangeUpdate, "NEW.at_ns"),
+		trigger(string(ChangeDelete), "DELETE", "", "OLD", ChangeDelete, tombstoneAtNs("OLD", "OLD.at_ns")))
+}
+
+// hasSoftDelete reports whether tableName has the deleted_at_ns column of
+// (proprdb.soft_delete) tables.
+func hasSoftDelete(q DBTX, tableName string) (bool, error) {
+	var count int
+	if err := q.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'deleted_at_ns'`, tableName).Scan(&count); err != nil {
+		return false, fmt.Errorf("check deleted_at_ns of %s: %w", tableName, err)
+	}
+	return count > 0, nil
+}
+
+// EnableChangeFeed creates _changes and triggers recording every insert,
+// update and delete of the generated tables tableNames in it, whether made
+// by generated code, sync, restores or plain SQL. Changes commit with the
+// writes that made them, so that readers of ReadChanges never skip one.
+// Generated Init calls it when Options.ChangeFeed is set.
+func EnableChangeFeed(q DBTX, tableNames ...string) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	ctx := context.Background()
+	if _, err := q.ExecContext(ctx, changesTableSQL); err != nil {
+		return fmt.Errorf("create %s: %w", CoreTableChangesName, err)
+	}
+	for _, tableName := range tableNames {
+		softDelete, err := hasSoftDelete(q, tableName)
+		if err != nil {
+			return err
+		}
+		if softDelete {
+			// Feeds enabled before soft deletes were recorded have the
+			// plain triggers, which the soft_ triggers replace.
+			for _, kind := range []ChangeOp{ChangeUpdate, ChangeDelete} {
+				if _, err := q.ExecContext(ctx, `DROP TRIGGER IF EXISTS `+changeTriggerName(tableName, string(kind))); err != nil {
+					return fmt.Errorf("drop change trigger on %s: %w", tableName, err)
+				}
+			}
+		}
+		for _, trigger := range changeTriggers(tableName, softDelete) {
+			if _, err := q.ExecContext(ctx, trigger[1]); err != nil {
+				return fmt.Errorf("create change trigger on %s: %w", tableName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// DisableChangeFeed drops the change triggers of tableNames. _changes and
+// the changes recorded so far are kept.
+func DisableChangeFeed(q DBTX, tableNames ...string) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	for _, tableName := range tableNames {
+		for _, trigger := range append(changeTriggers(tableName, false), changeTriggers(tableName, true)...) {
+			if _, err := q.ExecContext(context.Background(), `DROP TRIGGER IF EXISTS `+trigger[0]); err != nil {
+				return fmt.Errorf("drop change trigger on %s: %w", tableName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadChanges returns up to limit changes after sinceSeq in Seq order, all
+// of them when limit <= 0. Pass the Seq of the last change as sinceSeq of
+// the next call to tail the feed; start with 0.
+func ReadChanges(q DBTX, sinceSeq int64, limit int) ([]Change, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT seq, table_name, id, op, at_ns FROM `+CoreTableChangesName+` WHERE seq > ? ORDER BY seq LIMIT ?`, sinceSeq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("select changes: %w", err)
+	}
+	changes := make([]Change, 0)
+	for rows.Next() {
+		var change Change
+		if err := rows.Scan(&change.Seq, &change.TableName, &change.ID, &change.Op, &change.AtNs); err != nil {
+			if closeErr := CloseRows(rows, "changes"); closeErr != nil {
+				return nil, fmt.Errorf("scan change: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "changes"); closeErr != nil {
+			return nil, fmt.Errorf("iterate changes: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate changes: %w", err)
+	}
+	if err := CloseRows(rows, "changes"); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// TrimChanges deletes the changes up to throughSeq, once every consumer has
+// read them, and returns how many it deleted.
+func TrimChanges(q DBTX, throughSeq int64) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	result, err := q.ExecContext(context.Background(), `DELETE FROM `+CoreTableChangesName+` WHERE seq <= ?`, throughSeq)
+	if err != nil {
+		return 0, fmt.Errorf("trim changes: %w", err)
+	}
+	trimmed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("count trimmed changes: %w", err)
+	}
+	return trimmed, nil
+}
+
+// ChangeFeedPosition returns the seq of the newest change ever recorded in
+// _changes, or 0 when there is none. stale reports that the changes after
+// sinceSeq are not all in _changes, because TrimChanges removed some or
+// sinceSeq is not a position of this feed, so a reader must start over.
+func ChangeFeedPosition(q DBTX, sinceSeq int64) (last int64, stale bool, err error) {
+	if q == nil {
+		return 0, false, errors.New("nil DBTX")
+	}
+	exists, err := tableExists(q, CoreTableChangesName)
+	if err != nil {
+		return 0, false, err
+	}
+	if !exists {
+		return 0, false, fmt.Errorf("%s does not exist; enable the change feed", CoreTableChangesName)
+	}
+	ctx := context.Background()
+	// AUTOINCREMENT keeps the newest seq in sqlite_sequence, also after the
+	// rows are trimmed.
+	err = q.QueryRowContext(ctx, `SELECT seq FROM sqlite_sequence WHERE name = ?`, CoreTableChangesName).Scan(&last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, fmt.Errorf("select change feed position: %w", err)
+	}
+	if sinceSeq > last {
+		return last, true, nil
+	}
+	var first sql.NullInt64
+	if err := q.QueryRowContext(ctx, `SELECT MIN(seq) FROM `+CoreTableChangesName).Scan(&first); err != nil {
+		return 0, false, fmt.Errorf("select oldest change: %w", err)
+	}
+	if !first.Valid {
+		return last, last > sinceSeq, nil
+	}
+	return last, first.Int64 > sinceSeq+1, nil
+}
+
+// ChangedIDs returns the ids of tableName with changes after afterSeq.
+func ChangedIDs(q DBTX, tableName string, afterSeq int64) (map[string]bool, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT DISTINCT id FROM `+CoreTableChangesName+` WHERE table_name = ? AND seq > ?`, tableName, afterSeq)
+	if err != nil {
+		return nil, fmt.Errorf("select changed ids of %s: %w", tableName, err)
+	}
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			if closeErr := CloseRows(rows, "changed ids"); closeErr != nil {
+				return nil, fmt.Errorf("scan changed id: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan changed id: %w", err)
+		}
+		ids[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "changed ids"); closeErr != nil {
+			return nil, fmt.Errorf("iterate changed ids: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate changed ids: %w", err)
+	}
+	if err := CloseRows(rows, "changed ids"); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func quoteSQLiteString(value string) string {
+	return `'` + strings.ReplaceAll(value, `'`, `''`) + `'`
+}
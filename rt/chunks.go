@@ -0,0 +1,344 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// PendingJSONLRecord is a record selected for export together with the
+// table its _sync bookkeeping belongs to.
+type PendingJSONLRecord struct {
+	TableName string
+	Record    JSONLRecord
+}
+
+// ChunkProgressFunc reports how many of total records have been written and
+// acknowledged so far.
+type ChunkProgressFunc func(sent, total int64)
+
+// JSONLProgress reports how far a JSONL export or import has come.
+type JSONLProgress struct {
+	// Records counts the records written and acknowledged, or read, so far.
+	Records int64
+	// Total is the number of records an export writes, 0 on import.
+	Total int64
+	// Bytes counts the uncompressed bytes written so far, or of the records
+	// read so far including their newlines or frame headers.
+	Bytes int64
+	// Type is the type name of the last record, naming the table being
+	// written or read.
+	Type string
+}
+
+// JSONLProgressFunc receives the progress of an export after each chunk,
+// or of an import after each record.
+type JSONLProgressFunc func(JSONLProgress)
+
+// JSONLProgress adapts f to a JSONLProgressFunc, nil when f is nil.
+func (f ChunkProgressFunc) JSONLProgress() JSONLProgressFunc {
+	if f == nil {
+		return nil
+	}
+	return func(progress JSONLProgress) {
+		f(progress.Records, progress.Total)
+	}
+}
+
+// ExportOptions configures one JSONL export.
+type ExportOptions struct {
+	// ChunkSize is the number of records written and acknowledged at a
+	// time, 1 when 0. Cancellation is checked between chunks.
+	ChunkSize int
+	// Progress, when set, is called after each chunk.
+	Progress JSONLProgressFunc
+	// Tables, when set, limits the export to the records of these tables.
+	// The records of other tables stay pending for a later export.
+	Tables []string
+}
+
+// CheckJSONLTables returns an error naming the first of tables that is not
+// one of the table names in typeTables, which maps type names to tables.
+func CheckJSONLTables(tables []string, typeTables map[string]string) error {
+	for _, table := range tables {
+		known := false
+		for _, tableName := range typeTables {
+			known = known || tableName == table
+		}
+		if !known {
+			return fmt.Errorf("unknown jsonl table %q", table)
+		}
+	}
+	return nil
+}
+
+// WriteJSONLExport is WriteJSONLChunksContext configured by exportOpts.
+func WriteJSONLExport(ctx context.Context, q DBTX, remote string, w io.Writer, pending []PendingJSONLRecord, opts JSONLOptions, exportOpts ExportOptions) error {
+	chunkSize := exportOpts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = 1
+	}
+	if len(exportOpts.Tables) > 0 {
+		pending = slices.DeleteFunc(slices.Clone(pending), func(record PendingJSONLRecord) bool {
+			return !slices.Contains(exportOpts.Tables, record.TableName)
+		})
+	}
+	return writeJSONLChunks(ctx, q, remote, w, pending, chunkSize, opts, exportOpts.Progress, nil)
+}
+
+// WriteJSONLChunks writes pending records in chunks of chunkSize. Each chunk
+// is written with a single Write call and flushed when w has a
+// Flush() error method; only then are its records marked in _sync for remote. If writing
+// fails, earlier chunks stay acknowledged and a later export resumes after
+// them.
+func WriteJSONLChunks(q DBTX, remote string, w io.Writer, pending []PendingJSONLRecord, chunkSize int, progress ChunkProgressFunc) error {
+	return WriteJSONLChunksWithOptions(q, remote, w, pending, chunkSize, JSONLOptions{}, progress)
+}
+
+// WriteJSONLChunksWithOptions is WriteJSONLChunks compressing and framing
+// the stream per opts. Compressed chunks are flushed before they are
+// acknowledged; the compressed stream is finished after the last chunk.
+func WriteJSONLChunksWithOptions(q DBTX, remote string, w io.Writer, pending []PendingJSONLRecord, chunkSize int, opts JSONLOptions, progress ChunkProgressFunc) error {
+	return writeJSONLChunks(context.Background(), q, remote, w, pending, chunkSize, opts, progress.JSONLProgress(), nil)
+}
+
+// WriteJSONLChunksContext is WriteJSONLChunksWithOptions stopping with the
+// error of ctx before the first chunk written after ctx is done. Chunks
+// written before stay acknowledged.
+func WriteJSONLChunksContext(ctx context.Context, q DBTX, remote string, w io.Writer, pending []PendingJSONLRecord, chunkSize int, opts JSONLOptions, progress ChunkProgressFunc) error {
+	return writeJSONLChunks(ctx, q, remote, w, pending, chunkSize, opts, progress.JSONLProgress(), nil)
+}
+
+// WriteJSONLSince writes pending, the records changed after a change feed
+// position, per opts and without touching _sync, followed by a
+// {"cursor": cursor} record for the next request. The cursor is covered by
+// the manifest.
+func WriteJSONLSince(w io.Writer, pending []PendingJSONLRecord, cursor int64, opts JSONLOptions) error {
+	return writeJSONLChunks(context.Background(), nil, "", w, pending, max(len(pending), 1), opts, nil, &cursor)
+}
+
+// sortPendingJSONL returns pending in the order streams are written: by
+// table, then at_ns and then id, so that exports of the same data are
+// identical and diff cleanly, and each table forms one contiguous section.
+func sortPendingJSONL(pending []PendingJSONLRecord) []PendingJSONLRecord {
+	sorted := slices.Clone(pending)
+	slices.SortStableFunc(sorted, func(a, b PendingJSONLRecord) int {
+		if order := strings.Compare(a.TableName, b.TableName); order != 0 {
+			return order
+		}
+		if a.Record.AtNs != b.Record.AtNs {
+			return cmp.Compare(a.Record.AtNs, b.Record.AtNs)
+		}
+		return strings.Compare(a.Record.ID, b.Record.ID)
+	})
+	return sorted
+}
+
+// appendJSONLSection appends the section record of the table of
+// pending[start] to buffer when it starts a table.
+func appendJSONLSection(buffer *bytes.Buffer, pending []PendingJSONLRecord, start int, opts JSONLOptions) error {
+	table := pending[start].TableName
+	if start > 0 && pending[start-1].TableName == table {
+		return nil
+	}
+	section := JSONLSection{Table: table}
+	for _, item := range pending[start:] {
+		if item.TableName != table {
+			break
+		}
+		section.Records++
+	}
+	encoded, err := json.Marshal(struct {
+		Section JSONLSection `json:"section"`
+	}{section})
+	if err != nil {
+		return fmt.Errorf("encode section: %w", err)
+	}
+	return opts.appendFrame(buffer, encoded)
+}
+
+// writeJSONLChunks implements WriteJSONLChunksContext, writing cursor
+// before the manifest when set.
+func writeJSONLChunks(ctx context.Context, q DBTX, remote string, w io.Writer, pending []PendingJSONLRecord, chunkSize int, opts JSONLOptions, progress JSONLProgressFunc, cursor *int64) error {
+	if w == nil {
+		return errors.New("nil writer")
+	}
+	if chunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	pending = sortPendingJSONL(pending)
+	total := int64(len(pending))
+	var sent, written int64
+	var buffer bytes.Buffer
+	if opts.SigningKey != nil && len(opts.SigningKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid ed25519 signing key of %d bytes", len(opts.SigningKey))
+	}
+	version, err := opts.recordVersion()
+	if err != nil {
+		return err
+	}
+	out, finish, err := opts.compress(w)
+	if err != nil {
+		return err
+	}
+	digest := newJSONLDigest()
+	var descriptors string
+	if opts.EmbedDescriptors && opts.Descriptors != nil && len(pending) > 0 {
+		if descriptors, err = writeJSONLDescriptors(out, opts); err != nil {
+			return err
+		}
+	}
+	pacer := newSyncPacer(ctx, opts)
+	var frame bytes.Buffer
+	for start := 0; start < len(pending); {
+		// A chunk ends after chunkSize records or before exceeding
+		// MaxBytesPerFlush, but always holds at least one record.
+		end := start
+		buffer.Reset()
+		for end < len(pending) && end-start < chunkSize {
+			item := pending[end]
+			record, err := downgradeJSONLRecord(item.Record, version)
+			var encoded []byte
+			if err == nil {
+				encoded, err = json.Marshal(record)
+			}
+			frame.Reset()
+			if err == nil && opts.SectionHeaders {
+				// The section goes with the first record of its table, so
+				// that it is rewritten along with that chunk.
+				err = appendJSONLSection(&frame, pending, end, opts)
+			}
+			if err == nil {
+				err = opts.appendFrame(&frame, encoded)
+			}
+			if err != nil {
+				return fmt.Errorf("encode jsonl record %s/%s: %w", item.TableName, item.Record.ID, err)
+			}
+			if end > start && opts.MaxBytesPerFlush > 0 && buffer.Len()+frame.Len() > opts.MaxBytesPerFlush {
+				break
+			}
+			buffer.Write(frame.Bytes())
+			digest.add(encoded, item.Record.Data)
+			end++
+		}
+		chunk := pending[start:end]
+		start = end
+		if err := pacer.wait(len(chunk)); err != nil {
+			return fmt.Errorf("write jsonl chunk after %d of %d records: %w", sent, total, err)
+		}
+		if _, err := out.Write(buffer.Bytes()); err != nil {
+			return fmt.Errorf("write jsonl chunk after %d of %d records: %w", sent, total, err)
+		}
+		if err := flushStream(out, w, opts.Compression != JSONLCompressionNone); err != nil {
+			return fmt.Errorf("flush jsonl chunk after %d of %d records: %w", sent, total, err)
+		}
+		for _, item := range chunk {
+			if err := SyncUpsert(q, item.Record.ID, item.TableName, remote, item.Record.AtNs); err != nil {
+				return err
+			}
+		}
+		sent += int64(len(chunk))
+		written += int64(buffer.Len())
+		if progress != nil {
+			// Records without a type fail to import anyway.
+			typeName, _ := TypeNameFromAnyJSON(chunk[len(chunk)-1].Record.Data)
+			progress(JSONLProgress{Records: sent, Total: total, Bytes: written, Type: typeName})
+		}
+	}
+	if cursor != nil {
+		buffer.Reset()
+		encoded, err := json.Marshal(struct {
+			Cursor int64 `json:"cursor"`
+		}{*cursor})
+		if err == nil {
+			err = opts.appendFrame(&buffer, encoded)
+		}
+		if err != nil {
+			return fmt.Errorf("encode jsonl cursor: %w", err)
+		}
+		if _, err := out.Write(buffer.Bytes()); err != nil {
+			return fmt.Errorf("write jsonl cursor: %w", err)
+		}
+	}
+	if opts.WritesManifest() {
+		// Streams interrupted before this point lack the manifest, which
+		// RequireManifest detects on import.
+		buffer.Reset()
+		manifest := digest.result()
+		manifest.Cursor = cursor
+		manifest.DescriptorsSHA256 = descriptors
+		if opts.SigningKey != nil {
+			if err := manifest.sign(opts.SigningKey); err != nil {
+				return err
+			}
+		}
+		encoded, err := json.Marshal(struct {
+			Manifest JSONLManifest `json:"manifest"`
+		}{manifest})
+		if err == nil {
+			err = opts.appendFrame(&buffer, encoded)
+		}
+		if err != nil {
+			return fmt.Errorf("encode jsonl manifest: %w", err)
+		}
+		if _, err := out.Write(buffer.Bytes()); err != nil {
+			return fmt.Errorf("write jsonl manifest: %w", err)
+		}
+	}
+	if err := finish(); err != nil {
+		return fmt.Errorf("finish jsonl stream: %w", err)
+	}
+	if err := flushWriter(w); err != nil {
+		return fmt.Errorf("flush jsonl stream: %w", err)
+	}
+	return nil
+}
+
+// flushStream flushes the compressor out, when compressed, and then w.
+func flushStream(out, w io.Writer, compressed bool) error {
+	if compressed {
+		if err := flushWriter(out); err != nil {
+			return err
+		}
+	}
+	return flushWriter(w)
+}
+
+func flushWriter(w io.Writer) error {
+	if flusher, ok := w.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// writeJSONLDescriptors writes the descriptors record of opts to out and
+// returns its hash for the manifest.
+func writeJSONLDescriptors(out io.Writer, opts JSONLOptions) (string, error) {
+	serialized, err := proto.MarshalOptions{Deterministic: true}.Marshal(opts.Descriptors)
+	if err != nil {
+		return "", fmt.Errorf("marshal jsonl descriptors: %w", err)
+	}
+	encoded, err := json.Marshal(struct {
+		Descriptors []byte `json:"descriptors"`
+	}{serialized})
+	var buffer bytes.Buffer
+	if err == nil {
+		err = opts.appendFrame(&buffer, encoded)
+	}
+	if err != nil {
+		return "", fmt.Errorf("encode jsonl descriptors: %w", err)
+	}
+	if _, err := out.Write(buffer.Bytes()); err != nil {
+		return "", fmt.Errorf("write jsonl descriptors: %w", err)
+	}
+	return descriptorsHash(serialized), nil
+}
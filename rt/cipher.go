@@ -0,0 +1,224 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Cipher encrypts stored payloads. KeyID names the key used for new writes;
+// Decrypt must still accept every key ID that may be present in the database.
+type Cipher interface {
+	KeyID() string
+	Encrypt(keyID string, plain []byte) ([]byte, error)
+	Decrypt(keyID string, sealed []byte) ([]byte, error)
+}
+
+// Sealed values are prefixed with 0x00, which never starts a valid protobuf
+// message or gzip stream, followed by a format version and the key ID.
+const (
+	sealedMarker  byte = 0x00
+	sealedVersion byte = 0x01
+)
+
+var errMissingCipher = errors.New("encrypted value but no cipher configured")
+
+func sealData(c Cipher, plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+	keyID := c.KeyID()
+	if len(keyID) == 0 || len(keyID) > 255 {
+		return nil, fmt.Errorf("invalid key id length %d", len(keyID))
+	}
+	sealed, err := c.Encrypt(keyID, plain)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt with key %s: %w", keyID, err)
+	}
+	result := make([]byte, 0, 3+len(keyID)+len(sealed))
+	result = append(result, sealedMarker, sealedVersion, byte(len(keyID)))
+	result = append(result, keyID...)
+	return append(result, sealed...), nil
+}
+
+func openData(c Cipher, stored []byte) ([]byte, error) {
+	keyID, sealed, ok, err := splitSealed(stored)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return stored, nil
+	}
+	if c == nil {
+		return nil, errMissingCipher
+	}
+	plain, err := c.Decrypt(keyID, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt with key %s: %w", keyID, err)
+	}
+	return plain, nil
+}
+
+func splitSealed(stored []byte) (string, []byte, bool, error) {
+	if len(stored) == 0 || stored[0] != sealedMarker {
+		return "", nil, false, nil
+	}
+	if len(stored) < 3 || stored[1] != sealedVersion {
+		return "", nil, false, errors.New("unsupported sealed value format")
+	}
+	keyIDLength := int(stored[2])
+	if len(stored) < 3+keyIDLength {
+		return "", nil, false, errors.New("truncated sealed value")
+	}
+	return string(stored[3 : 3+keyIDLength]), stored[3+keyIDLength:], true, nil
+}
+
+// StoredKeyID reports the key ID of an encrypted stored value.
+func StoredKeyID(stored []byte) (string, bool) {
+	keyID, _, ok, err := splitSealed(stored)
+	if err != nil {
+		return "", false
+	}
+	return keyID, ok
+}
+
+// EncryptColumnValue seals a projected column value when a cipher is
+// configured. Values are encrypted in their textual form; nil stays NULL.
+func EncryptColumnValue(opts Options, value any) (any, error) {
+	if opts.Cipher == nil || value == nil {
+		return value, nil
+	}
+	var plain []byte
+	switch typed := value.(type) {
+	case []byte:
+		plain = typed
+	case string:
+		plain = []byte(typed)
+	default:
+		plain = fmt.Append(nil, typed)
+	}
+	return sealData(opts.Cipher, plain)
+}
+
+// DecryptColumnValue reverses EncryptColumnValue, returning the textual form.
+func DecryptColumnValue(opts Options, stored []byte) ([]byte, error) {
+	return openData(opts.Cipher, stored)
+}
+
+// ReencryptDataColumn rewrites data blobs that were not sealed with the
+// current key of opts.Cipher. Row timestamps are left untouched.
+func ReencryptDataColumn(q DBTX, opts Options, tableName string) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	if opts.Cipher == nil {
+		return 0, errors.New("nil cipher")
+	}
+	ctx := context.Background()
+	tableNameIdentifier := quoteSQLiteIdentifier(tableName)
+	rows, err := q.QueryContext(ctx, `SELECT id, data FROM `+tableNameIdentifier)
+	if err != nil {
+		return 0, fmt.Errorf("select rows for reencryption of %s: %w", tableName, err)
+	}
+	type reencryptRow struct {
+		id     string
+		stored []byte
+	}
+	currentKeyID := opts.Cipher.KeyID()
+	pendingRows := make([]reencryptRow, 0)
+	for rows.Next() {
+		var id string
+		var stored []byte
+		if err := rows.Scan(&id, &stored); err != nil {
+			if closeErr := CloseRows(rows, "reencryption"); closeErr != nil {
+				return 0, fmt.Errorf("scan reencryption row for %s: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return 0, fmt.Errorf("scan reencryption row for %s: %w", tableName, err)
+		}
+		if keyID, ok := StoredKeyID(stored); ok && keyID == currentKeyID {
+			continue
+		}
+		pendingRows = append(pendingRows, reencryptRow{id: id, stored: bytes.Clone(stored)})
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "reencryption"); closeErr != nil {
+			return 0, fmt.Errorf("iterate reencryption rows for %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return 0, fmt.Errorf("iterate reencryption rows for %s: %w", tableName, err)
+	}
+	if err := CloseRows(rows, "reencryption"); err != nil {
+		return 0, err
+	}
+	updateSQL := `UPDATE ` + tableNameIdentifier + ` SET data = ? WHERE id = ?`
+	for _, row := range pendingRows {
+		plain, err := openData(opts.Cipher, row.stored)
+		if err != nil {
+			return 0, fmt.Errorf("open %s/%s: %w", tableName, row.id, err)
+		}
+		sealed, err := sealData(opts.Cipher, plain)
+		if err != nil {
+			return 0, fmt.Errorf("seal %s/%s: %w", tableName, row.id, err)
+		}
+		if _, err := q.ExecContext(ctx, updateSQL, sealed, row.id); err != nil {
+			return 0, fmt.Errorf("update %s/%s: %w", tableName, row.id, err)
+		}
+	}
+	return int64(len(pendingRows)), nil
+}
+
+// AESGCMCipher is a Cipher backed by AES-GCM with a set of named keys.
+type AESGCMCipher struct {
+	currentKeyID string
+	aeads        map[string]cipher.AEAD
+}
+
+func NewAESGCMCipher(currentKeyID string, keys map[string][]byte) (*AESGCMCipher, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("current key %q not present in keys", currentKeyID)
+	}
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for keyID, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("create aes cipher for key %s: %w", keyID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("create gcm for key %s: %w", keyID, err)
+		}
+		aeads[keyID] = aead
+	}
+	return &AESGCMCipher{currentKeyID: currentKeyID, aeads: aeads}, nil
+}
+
+func (c *AESGCMCipher) KeyID() string {
+	return c.currentKeyID
+}
+
+func (c *AESGCMCipher) Encrypt(keyID string, plain []byte) ([]byte, error) {
+	aead, ok := c.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (c *AESGCMCipher) Decrypt(keyID string, sealed []byte) ([]byte, error) {
+	aead, ok := c.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value shorter than nonce")
+	}
+	nonce := sealed[:aead.NonceSize()]
+	return aead.Open(nil, nonce, sealed[aead.NonceSize():], nil)
+}
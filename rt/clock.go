@@ -0,0 +1,38 @@
+package proprdbrt
+
+import "sync/atomic"
+
+// Clock supplies the at_ns of writes and the current time of expiry, set
+// via Options.Clock.
+type Clock interface {
+	NowNs() int64
+}
+
+// ClockFunc adapts a function to Clock.
+type ClockFunc func() int64
+
+// NowNs returns f().
+func (f ClockFunc) NowNs() int64 {
+	return f()
+}
+
+// StepClock is a deterministic Clock for tests. Each call returns the
+// previous time plus Step, starting at Start.
+type StepClock struct {
+	Start int64
+	Step  int64
+	calls atomic.Int64
+}
+
+// NowNs returns the next time.
+func (c *StepClock) NowNs() int64 {
+	return c.Start + (c.calls.Add(1)-1)*c.Step
+}
+
+// NowNs returns o.Clock.NowNs(), or NowNs() when unset.
+func (o Options) NowNs() int64 {
+	if o.Clock == nil {
+		return NowNs()
+	}
+	return o.Clock.NowNs()
+}
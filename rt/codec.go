@@ -0,0 +1,126 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DataCodec transforms serialized protobuf payloads stored in the data column.
+// DecodeData must accept payloads that were stored without the codec, so that
+// enabling a codec does not require rewriting existing rows.
+type DataCodec interface {
+	EncodeData(plain []byte) ([]byte, error)
+	DecodeData(stored []byte) ([]byte, error)
+}
+
+// GzipDataCodec compresses the data column with gzip.
+type GzipDataCodec struct {
+	Level int
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func (c GzipDataCodec) EncodeData(plain []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buffer bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buffer, level)
+	if err != nil {
+		return nil, fmt.Errorf("create gzip writer: %w", err)
+	}
+	if _, err := writer.Write(plain); err != nil {
+		return nil, fmt.Errorf("gzip data: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("close gzip writer: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c GzipDataCodec) DecodeData(stored []byte) ([]byte, error) {
+	return gunzipIfCompressed(stored)
+}
+
+// gunzipIfCompressed relies on protobuf wire format never starting with 0x1f
+// (wire type 7 is invalid), so gzip payloads are unambiguous.
+func gunzipIfCompressed(stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, gzipMagic) {
+		return stored, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return nil, fmt.Errorf("open gzip data: %w", err)
+	}
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		if closeErr := reader.Close(); closeErr != nil {
+			return nil, fmt.Errorf("gunzip data: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("gunzip data: %w", err)
+	}
+	if err := reader.Close(); err != nil {
+		return nil, fmt.Errorf("close gzip data: %w", err)
+	}
+	return plain, nil
+}
+
+func MarshalData(opts Options, message proto.Message) ([]byte, error) {
+	if message == nil {
+		return nil, errors.New("nil message")
+	}
+	message, err := spillBlobs(opts, message)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	encoded := plain
+	if opts.DataCodec != nil {
+		encoded, err = opts.DataCodec.EncodeData(plain)
+		if err != nil {
+			return nil, fmt.Errorf("encode data: %w", err)
+		}
+	}
+	return sealData(opts.Cipher, encoded)
+}
+
+func UnmarshalData(opts Options, stored []byte, message proto.Message) error {
+	if message == nil {
+		return errors.New("nil message")
+	}
+	plain, err := decodeData(opts, stored)
+	if err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(plain, message); err != nil {
+		return err
+	}
+	return loadBlobs(opts, message)
+}
+
+// decodeData opens and decodes a data column value to serialized protobuf.
+func decodeData(opts Options, stored []byte) ([]byte, error) {
+	encoded, err := openData(opts.Cipher, stored)
+	if err != nil {
+		return nil, err
+	}
+	var plain []byte
+	if opts.DataCodec != nil {
+		plain, err = opts.DataCodec.DecodeData(encoded)
+	} else {
+		plain, err = gunzipIfCompressed(encoded)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode data: %w", err)
+	}
+	return plain, nil
+}
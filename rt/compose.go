@@ -0,0 +1,138 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ComposedCRUD joins the generated CRUDs of several proto files or packages
+// into one Bundle, so that a schema spread over many files initializes and
+// syncs as one unit.
+//
+// Its streams hold the records of all bundles with one manifest, written per
+// the JSONLOptions given to ComposeCRUD. The bundles themselves should keep
+// the default JSONL options, as they only see plain streams.
+type ComposedCRUD struct {
+	bundles []Bundle
+	// owners maps the type names of the bundles to their index.
+	owners map[string]int
+	opts   JSONLOptions
+}
+
+var _ Bundle = (*ComposedCRUD)(nil)
+
+// ComposeCRUD composes bundles, e.g. the NewCRUD of each generated package
+// bound to the same database. It fails when two bundles share a table or
+// type name.
+func ComposeCRUD(opts JSONLOptions, bundles ...Bundle) (*ComposedCRUD, error) {
+	if len(bundles) == 0 {
+		return nil, errors.New("compose CRUD without bundles")
+	}
+	tables := make(map[string]int)
+	owners := make(map[string]int)
+	for index, bundle := range bundles {
+		if bundle == nil {
+			return nil, fmt.Errorf("nil bundle %d", index)
+		}
+		for _, descriptor := range bundle.TableDescriptors() {
+			if descriptor.IsCore {
+				continue
+			}
+			if owner, ok := tables[descriptor.TableName]; ok {
+				return nil, fmt.Errorf("table %s is in both bundle %d and %d", descriptor.TableName, owner, index)
+			}
+			tables[descriptor.TableName] = index
+			if owner, ok := owners[descriptor.TypeName]; ok {
+				return nil, fmt.Errorf("type %s is in both bundle %d and %d", descriptor.TypeName, owner, index)
+			}
+			owners[descriptor.TypeName] = index
+		}
+	}
+	return &ComposedCRUD{bundles: append([]Bundle(nil), bundles...), owners: owners, opts: opts}, nil
+}
+
+// Init initializes the bundles in order. The core tables are shared.
+func (c *ComposedCRUD) Init() error {
+	for index, bundle := range c.bundles {
+		if err := bundle.Init(); err != nil {
+			return fmt.Errorf("init bundle %d: %w", index, err)
+		}
+	}
+	return nil
+}
+
+// TableDescriptors lists the tables of all bundles, followed by the core
+// tables once.
+func (c *ComposedCRUD) TableDescriptors() []GeneratedTableDescriptor {
+	groups := make([][]GeneratedTableDescriptor, 0, len(c.bundles))
+	for _, bundle := range c.bundles {
+		groups = append(groups, bundle.TableDescriptors())
+	}
+	return mergeTableDescriptors(groups)
+}
+
+// WriteJSONL writes the pending records of all bundles to w as one stream.
+// Each bundle exports into memory first and acknowledges its records in
+// _sync once buffered, so a failing w loses them for remote as a failing
+// Write after an acknowledged chunk would.
+func (c *ComposedCRUD) WriteJSONL(remote string, w io.Writer) error {
+	if w == nil {
+		return errors.New("nil writer")
+	}
+	pending := make([]PendingJSONLRecord, 0)
+	for index, bundle := range c.bundles {
+		var buffer bytes.Buffer
+		if err := bundle.WriteJSONL(remote, &buffer); err != nil {
+			return fmt.Errorf("write bundle %d: %w", index, err)
+		}
+		if err := ReadJSONLWithOptions(&buffer, JSONLOptions{Zstd: c.opts.Zstd}, func(record JSONLRecord, _ int) error {
+			pending = append(pending, PendingJSONLRecord{Record: record})
+			return nil
+		}); err != nil {
+			return fmt.Errorf("read bundle %d: %w", index, err)
+		}
+	}
+	return writeJSONLChunks(context.Background(), nil, "", w, pending, max(len(pending), 1), c.opts, nil, nil)
+}
+
+// ReadJSONL reads a stream, checking its manifest per the options of
+// ComposeCRUD, and imports each record with the bundle owning its type.
+// Records of unknown types go to the first bundle, which parks them. The
+// stream is buffered in full, and nothing is imported when it fails to
+// read; bundles import one after another.
+func (c *ComposedCRUD) ReadJSONL(remote string, r io.Reader) error {
+	if r == nil {
+		return errors.New("nil reader")
+	}
+	routed := make([]bytes.Buffer, len(c.bundles))
+	if err := ReadJSONLWithOptions(r, c.opts, func(record JSONLRecord, lineNumber int) error {
+		owner := 0
+		if typeName, err := TypeNameFromAnyJSON(record.Data); err == nil {
+			if index, ok := c.owners[typeName]; ok {
+				owner = index
+			}
+		}
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("encode jsonl line %d: %w", lineNumber, err)
+		}
+		routed[owner].Write(encoded)
+		routed[owner].WriteByte('\n')
+		return nil
+	}); err != nil {
+		return err
+	}
+	for index, bundle := range c.bundles {
+		if routed[index].Len() == 0 {
+			continue
+		}
+		if err := bundle.ReadJSONL(remote, &routed[index]); err != nil {
+			return fmt.Errorf("read bundle %d: %w", index, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package proprdbrt
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ConflictStrategy decides how ReadJSONL treats a remote record for an
+// object that already exists locally (as a row or a tombstone).
+type ConflictStrategy int
+
+const (
+	// ConflictLastWriterWins applies the record when its at_ns is not older
+	// than the local state.
+	ConflictLastWriterWins ConflictStrategy = iota
+	// ConflictRemoteWins always applies the remote record.
+	ConflictRemoteWins
+	// ConflictLocalWins applies remote records only for unknown objects.
+	ConflictLocalWins
+	// ConflictMerge combines live local and remote versions with the merge
+	// function registered via WithMerge. Deletions use last-writer-wins.
+	ConflictMerge
+)
+
+// MergeFunc combines a local and a remote version of the same object.
+type MergeFunc func(local, remote proto.Message) (proto.Message, error)
+
+func (s ConflictStrategy) String() string {
+	switch s {
+	case ConflictLastWriterWins:
+		return "last-writer-wins"
+	case ConflictRemoteWins:
+		return "remote-wins"
+	case ConflictLocalWins:
+		return "local-wins"
+	case ConflictMerge:
+		return "merge"
+	default:
+		return fmt.Sprintf("ConflictStrategy(%d)", int(s))
+	}
+}
+
+// WithConflictStrategy overrides the generated conflict strategy of typeName.
+func WithConflictStrategy(opts Options, typeName string, strategy ConflictStrategy) Options {
+	strategies := make(map[string]ConflictStrategy, len(opts.ConflictStrategies)+1)
+	for existingTypeName, existingStrategy := range opts.ConflictStrategies {
+		strategies[existingTypeName] = existingStrategy
+	}
+	strategies[typeName] = strategy
+	opts.ConflictStrategies = strategies
+	return opts
+}
+
+// WithMerge registers the merge function used by ConflictMerge for typeName.
+func WithMerge[T proto.Message](opts Options, typeName string, merge func(local, remote T) (T, error)) Options {
+	mergers := make(map[string]MergeFunc, len(opts.Mergers)+1)
+	for existingTypeName, existingMerge := range opts.Mergers {
+		mergers[existingTypeName] = existingMerge
+	}
+	mergers[typeName] = func(local, remote proto.Message) (proto.Message, error) {
+		typedLocal, ok := local.(T)
+		if !ok {
+			return nil, fmt.Errorf("merge %s local: %w", typeName, UnknownTypeError(typeName, local))
+		}
+		typedRemote, ok := remote.(T)
+		if !ok {
+			return nil, fmt.Errorf("merge %s remote: %w", typeName, UnknownTypeError(typeName, remote))
+		}
+		return merge(typedLocal, typedRemote)
+	}
+	opts.Mergers = mergers
+	return opts
+}
+
+func ConflictStrategyFor(opts Options, typeName string, defaultStrategy ConflictStrategy) ConflictStrategy {
+	if strategy, ok := opts.ConflictStrategies[typeName]; ok {
+		return strategy
+	}
+	return defaultStrategy
+}
+
+// ShouldApplyRemote reports whether a remote record may change local state.
+// localMaxAtNs is -1 when the object is unknown locally. ConflictMerge
+// returns true for live records; the merge itself decides the outcome.
+func ShouldApplyRemote(strategy ConflictStrategy, remoteAtNs, localMaxAtNs int64, remoteDeleted bool) bool {
+	switch strategy {
+	case ConflictRemoteWins:
+		return true
+	case ConflictLocalWins:
+		return localMaxAtNs < 0
+	case ConflictMerge:
+		if !remoteDeleted {
+			return true
+		}
+		return remoteAtNs >= localMaxAtNs
+	default:
+		return remoteAtNs >= localMaxAtNs
+	}
+}
+
+// MergeRemote merges a live local row with a remote version. A merge
+// function registered via WithMerge takes precedence; otherwise fieldMerges
+// (generated from (proprdb.merge) field options) are applied with
+// MergeFields. The result is stored at the newer of both timestamps, bumped
+// by one when it differs from the remote version so that it propagates back
+// to the sender.
+func MergeRemote(opts Options, typeName string, fieldMerges map[string]FieldMerge, local proto.Message, localAtNs int64, remote proto.Message, remoteAtNs int64) (proto.Message, int64, error) {
+	var merged proto.Message
+	var err error
+	if merge, ok := opts.Mergers[typeName]; ok {
+		merged, err = merge(local, remote)
+	} else if len(fieldMerges) > 0 {
+		merged, err = MergeFields(local, localAtNs, remote, remoteAtNs, fieldMerges)
+	} else {
+		return nil, 0, ConflictError(fmt.Errorf("no merge function registered for %s", typeName))
+	}
+	if err != nil {
+		return nil, 0, ConflictError(fmt.Errorf("merge %s: %w", typeName, err))
+	}
+	if merged == nil {
+		return nil, 0, ConflictError(errors.New("merge returned nil"))
+	}
+	mergedAtNs := max(localAtNs, remoteAtNs)
+	if !proto.Equal(merged, remote) {
+		mergedAtNs++
+	}
+	return merged, mergedAtNs, nil
+}
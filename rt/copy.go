@@ -0,0 +1,295 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// copyAlias is the schema name CopyTables attaches the source database as.
+const copyAlias = "proprdb_copy"
+
+// copyIDsTable holds the ids CopyTables is copying from one table.
+const copyIDsTable = "temp.proprdb_copy_ids"
+
+// CopyReport counts what CopyTables copied.
+type CopyReport struct {
+	Rows       int64
+	Tombstones int64
+	SyncStates int64
+}
+
+// CopyTables copies the rows, tombstones and _sync state of tables written
+// after sinceNs from the SQLite database of srcDB into dstDB, in one
+// transaction of dstDB. The source file is attached to dstDB, so rows move
+// in bulk inside SQLite instead of being decoded and re-encoded like with
+// JSONL.
+//
+// Rows and tombstones merge like imports: the newer at_ns of an id wins,
+// and local rows and tombstones that are as new stay. Map projection and
+// history side tables follow their rows, and _sync keeps the newer at_ns
+// per object and remote. The tables must be initialized in both databases
+// with the same projection schema, else CopyTables fails with
+// ErrSchemaMismatch. srcDB must be file backed and dstDB must not be a
+// transaction. Caches of generated tables on dstDB should be purged
+// afterwards.
+func CopyTables(srcDB, dstDB DBTX, tables []string, sinceNs int64) (report CopyReport, err error) {
+	if srcDB == nil || dstDB == nil {
+		return report, errors.New("nil DBTX")
+	}
+	ctx := context.Background()
+	srcPath, err := mainDatabaseFile(ctx, srcDB)
+	if err != nil {
+		return report, fmt.Errorf("copy source: %w", err)
+	}
+	if srcPath == "" {
+		return report, errors.New("copy source: database has no file to attach")
+	}
+	q, release, err := pinConnection(ctx, dstDB, "copy")
+	if err != nil {
+		return report, err
+	}
+	defer release()
+	dstPath, err := mainDatabaseFile(ctx, q)
+	if err != nil {
+		return report, fmt.Errorf("copy destination: %w", err)
+	}
+	if dstPath == srcPath {
+		return report, errors.New("copy source and destination are the same database")
+	}
+
+	if _, err := q.ExecContext(ctx, `ATTACH DATABASE ? AS `+copyAlias, srcPath); err != nil {
+		return report, fmt.Errorf("attach copy source: %w", err)
+	}
+	defer func() {
+		if _, detachErr := q.ExecContext(context.Background(), `DETACH DATABASE `+copyAlias); detachErr != nil {
+			err = errors.Join(err, fmt.Errorf("detach copy source: %w", detachErr))
+		}
+	}()
+	if _, err := q.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return report, fmt.Errorf("begin copy: %w", err)
+	}
+	for _, table := range tables {
+		if err := copyTable(ctx, q, table, sinceNs, &report); err != nil {
+			if _, rollbackErr := q.ExecContext(context.Background(), `ROLLBACK`); rollbackErr != nil {
+				return CopyReport{}, fmt.Errorf("copy %s: %w (additionally, rollback: %v)", table, err, rollbackErr)
+			}
+			return CopyReport{}, fmt.Errorf("copy %s: %w", table, err)
+		}
+	}
+	if _, err := q.ExecContext(ctx, `DROP TABLE IF EXISTS `+copyIDsTable); err != nil {
+		if _, rollbackErr := q.ExecContext(context.Background(), `ROLLBACK`); rollbackErr != nil {
+			return CopyReport{}, fmt.Errorf("drop copy ids: %w (additionally, rollback: %v)", err, rollbackErr)
+		}
+		return CopyReport{}, fmt.Errorf("drop copy ids: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `COMMIT`); err != nil {
+		return CopyReport{}, fmt.Errorf("commit copy: %w", err)
+	}
+	return report, nil
+}
+
+// mainDatabaseFile returns the file of the main database of q, "" for
+// in-memory databases.
+func mainDatabaseFile(ctx context.Context, q DBTX) (string, error) {
+	var path string
+	if err := q.QueryRowContext(ctx, `SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&path); err != nil {
+		return "", fmt.Errorf("query database file: %w", err)
+	}
+	return path, nil
+}
+
+func copyTable(ctx context.Context, q DBTX, table string, sinceNs int64, report *CopyReport) error {
+	columns, err := checkCopyTable(ctx, q, table)
+	if err != nil {
+		return err
+	}
+	sideTables, err := copySideTables(ctx, q, table)
+	if err != nil {
+		return err
+	}
+	quotedTable := quoteSQLiteIdentifier(table)
+	quotedColumns := make([]string, len(columns))
+	for i, column := range columns {
+		quotedColumns[i] = quoteSQLiteIdentifier(column)
+	}
+	columnList := strings.Join(quotedColumns, ", ")
+	inCopyIDs := `id IN (SELECT id FROM ` + copyIDsTable + `)`
+
+	if _, err := q.ExecContext(ctx, `CREATE TEMP TABLE IF NOT EXISTS proprdb_copy_ids (id TEXT PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("create copy ids: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM `+copyIDsTable); err != nil {
+		return fmt.Errorf("clear copy ids: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `INSERT INTO `+copyIDsTable+` (id) SELECT s.id FROM `+copyAlias+`.`+quotedTable+` AS s WHERE s.at_ns > ?`+
+		` AND NOT EXISTS (SELECT 1 FROM main.`+quotedTable+` AS d WHERE d.id = s.id AND d.at_ns >= s.at_ns)`+
+		` AND NOT EXISTS (SELECT 1 FROM main.`+CoreTableDeletedName+` AS d WHERE d.table_name = ? AND d.id = s.id AND d.at_ns >= s.at_ns)`, sinceNs, table); err != nil {
+		return fmt.Errorf("select rows: %w", err)
+	}
+	result, err := q.ExecContext(ctx, `INSERT OR REPLACE INTO main.`+quotedTable+` (`+columnList+`) SELECT `+columnList+` FROM `+copyAlias+`.`+quotedTable+` WHERE `+inCopyIDs)
+	if err != nil {
+		return fmt.Errorf("copy rows: %w", err)
+	}
+	copied, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("count copied rows: %w", err)
+	}
+	report.Rows += copied
+	if _, err := q.ExecContext(ctx, `DELETE FROM main.`+CoreTableDeletedName+` WHERE table_name = ? AND `+inCopyIDs, table); err != nil {
+		return fmt.Errorf("delete replaced tombstones: %w", err)
+	}
+	for _, sideTable := range sideTables {
+		quotedSide := quoteSQLiteIdentifier(sideTable)
+		if strings.HasSuffix(sideTable, HistoryTableSuffix) {
+			if _, err := q.ExecContext(ctx, `INSERT OR IGNORE INTO main.`+quotedSide+` (id, at_ns, data) SELECT id, at_ns, data FROM `+copyAlias+`.`+quotedSide+` WHERE at_ns > ?`, sinceNs); err != nil {
+				return fmt.Errorf("copy %s: %w", sideTable, err)
+			}
+			continue
+		}
+		if _, err := q.ExecContext(ctx, `DELETE FROM main.`+quotedSide+` WHERE `+inCopyIDs); err != nil {
+			return fmt.Errorf("replace %s: %w", sideTable, err)
+		}
+		if _, err := q.ExecContext(ctx, `INSERT INTO main.`+quotedSide+` (id, key, value) SELECT id, key, value FROM `+copyAlias+`.`+quotedSide+` WHERE `+inCopyIDs); err != nil {
+			return fmt.Errorf("copy %s: %w", sideTable, err)
+		}
+	}
+
+	if _, err := q.ExecContext(ctx, `DELETE FROM `+copyIDsTable); err != nil {
+		return fmt.Errorf("clear copy ids: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `INSERT INTO `+copyIDsTable+` (id) SELECT s.id FROM `+copyAlias+`.`+CoreTableDeletedName+` AS s WHERE s.table_name = ? AND s.at_ns > ?`+
+		` AND NOT EXISTS (SELECT 1 FROM main.`+quotedTable+` AS d WHERE d.id = s.id AND d.at_ns >= s.at_ns)`+
+		` AND NOT EXISTS (SELECT 1 FROM main.`+CoreTableDeletedName+` AS d WHERE d.table_name = s.table_name AND d.id = s.id AND d.at_ns >= s.at_ns)`, table, sinceNs); err != nil {
+		return fmt.Errorf("select tombstones: %w", err)
+	}
+	result, err = q.ExecContext(ctx, `INSERT INTO main.`+CoreTableDeletedName+` (table_name, id, at_ns) SELECT table_name, id, at_ns FROM `+copyAlias+`.`+CoreTableDeletedName+` WHERE table_name = ? AND `+inCopyIDs+
+		` ON CONFLICT(table_name, id) DO UPDATE SET at_ns = excluded.at_ns`, table)
+	if err != nil {
+		return fmt.Errorf("copy tombstones: %w", err)
+	}
+	copied, err = result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("count copied tombstones: %w", err)
+	}
+	report.Tombstones += copied
+	for _, deleteTable := range append([]string{table}, sideTables...) {
+		if strings.HasSuffix(deleteTable, HistoryTableSuffix) {
+			continue
+		}
+		if _, err := q.ExecContext(ctx, `DELETE FROM main.`+quoteSQLiteIdentifier(deleteTable)+` WHERE `+inCopyIDs); err != nil {
+			return fmt.Errorf("delete tombstoned rows of %s: %w", deleteTable, err)
+		}
+	}
+
+	result, err = q.ExecContext(ctx, `INSERT INTO main.`+CoreTableSyncName+` (object_id, table_name, at_ns, remote) SELECT object_id, table_name, at_ns, remote FROM `+copyAlias+`.`+CoreTableSyncName+` WHERE table_name = ? AND at_ns > ?`+
+		` ON CONFLICT(object_id, table_name, remote) DO UPDATE SET at_ns = excluded.at_ns WHERE excluded.at_ns > `+CoreTableSyncName+`.at_ns`, table, sinceNs)
+	if err != nil {
+		return fmt.Errorf("copy sync state: %w", err)
+	}
+	copied, err = result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("count copied sync state: %w", err)
+	}
+	report.SyncStates += copied
+	return nil
+}
+
+// checkCopyTable returns the columns of table in the destination, failing
+// unless the source has them and the same projection schema.
+func checkCopyTable(ctx context.Context, q DBTX, table string) ([]string, error) {
+	columns, err := copyColumns(ctx, q, "main", table)
+	if err != nil {
+		return nil, err
+	}
+	srcColumns, err := copyColumns(ctx, q, copyAlias, table)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(columns) == 0:
+		return nil, fmt.Errorf("no table %s in the destination", table)
+	case len(srcColumns) == 0:
+		return nil, fmt.Errorf("no table %s in the source", table)
+	}
+	srcColumnSet := make(map[string]bool, len(srcColumns))
+	for _, column := range srcColumns {
+		srcColumnSet[column] = true
+	}
+	for _, column := range columns {
+		if !srcColumnSet[column] {
+			return nil, fmt.Errorf("%w: source table %s has no column %s", ErrSchemaMismatch, table, column)
+		}
+	}
+	schemaSQL := `SELECT COALESCE((SELECT schema_hash FROM main.` + CoreTableSchemaStateName + ` WHERE table_name = ?), ''),` +
+		` COALESCE((SELECT schema_hash FROM ` + copyAlias + `.` + CoreTableSchemaStateName + ` WHERE table_name = ?), '')`
+	var schema, srcSchema string
+	if err := q.QueryRowContext(ctx, schemaSQL, table, table).Scan(&schema, &srcSchema); err != nil {
+		return nil, fmt.Errorf("query projection schemas: %w", err)
+	}
+	if schema != srcSchema {
+		return nil, fmt.Errorf("%w: table %s has projection schema %q, source has %q", ErrSchemaMismatch, table, schema, srcSchema)
+	}
+	return columns, nil
+}
+
+func copyColumns(ctx context.Context, q DBTX, schemaName, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT name FROM pragma_table_info(?, ?) ORDER BY cid`, table, schemaName)
+	if err != nil {
+		return nil, fmt.Errorf("query columns of %s.%s: %w", schemaName, table, err)
+	}
+	columns := make([]string, 0)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			if closeErr := CloseRows(rows, "copy columns"); closeErr != nil {
+				return nil, fmt.Errorf("scan column: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan column: %w", err)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "copy columns"); closeErr != nil {
+			return nil, fmt.Errorf("iterate columns: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate columns: %w", err)
+	}
+	if err := CloseRows(rows, "copy columns"); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// copySideTables returns the map projection and history tables of table
+// that exist in both databases.
+func copySideTables(ctx context.Context, q DBTX, table string) ([]string, error) {
+	prefix := table + "__"
+	rows, err := q.QueryContext(ctx, `SELECT name FROM main.sqlite_master WHERE type = 'table' AND substr(name, 1, ?) = ?`+
+		` AND name IN (SELECT name FROM `+copyAlias+`.sqlite_master WHERE type = 'table') ORDER BY name`, len(prefix), prefix)
+	if err != nil {
+		return nil, fmt.Errorf("query side tables: %w", err)
+	}
+	sideTables := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			if closeErr := CloseRows(rows, "copy side tables"); closeErr != nil {
+				return nil, fmt.Errorf("scan side table: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan side table: %w", err)
+		}
+		sideTables = append(sideTables, name)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "copy side tables"); closeErr != nil {
+			return nil, fmt.Errorf("iterate side tables: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate side tables: %w", err)
+	}
+	if err := CloseRows(rows, "copy side tables"); err != nil {
+		return nil, err
+	}
+	return sideTables, nil
+}
@@ -0,0 +1,250 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// CoreTableDerivedName holds the definition each derived table was built
+// from. Their refresh checkpoints are OutboxDrainer checkpoints named
+// DerivedConsumerPrefix plus the table name, so that a trimming drainer
+// keeps the changes they have not applied yet.
+const CoreTableDerivedName = "_derived"
+
+// DerivedConsumerPrefix prefixes the _outbox consumer of a derived table.
+const DerivedConsumerPrefix = "derived:"
+
+const derivedTableSQL = `CREATE TABLE IF NOT EXISTS ` + CoreTableDerivedName + ` (name TEXT PRIMARY KEY, definition TEXT NOT NULL)`
+
+// DerivedTable is a table kept up to date from the changes of its Sources.
+// Exactly one of Query and Reduce is set.
+type DerivedTable struct {
+	Name string
+	// Sources are the tables whose changes refresh the derived table. Their
+	// change feed is enabled by InitDerivedTables.
+	Sources []string
+	// Query is a SELECT over Sources materialized as table Name. A refresh
+	// recomputes it when any source changed.
+	Query string
+	// Reduce applies one change of a source to the derived table, usually
+	// writing rows of a generated table for its message. It runs in the
+	// transaction that advances the checkpoint, so every change is applied
+	// exactly once.
+	Reduce func(ctx context.Context, q DBTX, change Change) error
+	// Rebuild recomputes a Reduce table from its sources. InitDerivedTables
+	// runs it for new tables and when Version changes; without it, the table
+	// only reflects changes from then on.
+	Rebuild func(ctx context.Context, q DBTX) error
+	// Version identifies the Reduce and Rebuild logic.
+	Version string
+}
+
+func (d DerivedTable) definition() (string, error) {
+	switch {
+	case d.Name == "":
+		return "", errors.New("empty derived table name")
+	case len(d.Sources) == 0:
+		return "", fmt.Errorf("derived table %s has no sources", d.Name)
+	case d.Query != "" && d.Reduce != nil:
+		return "", fmt.Errorf("derived table %s has both Query and Reduce", d.Name)
+	case d.Query != "":
+		return "query:" + d.Query, nil
+	case d.Reduce != nil:
+		return "reduce:" + d.Version, nil
+	default:
+		return "", fmt.Errorf("derived table %s has neither Query nor Reduce", d.Name)
+	}
+}
+
+// InitDerivedTables enables the change feed of the sources of tables and
+// builds each table whose definition is new or changed since the last
+// Init, in one transaction per table. Generated CRUD.Init calls it for
+// Options.DerivedTables.
+func InitDerivedTables(ctx context.Context, q DBTX, tables ...DerivedTable) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	for _, statement := range []string{derivedTableSQL, outboxTableSQL} {
+		if _, err := q.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("create derived table state: %w", err)
+		}
+	}
+	for _, table := range tables {
+		definition, err := table.definition()
+		if err != nil {
+			return err
+		}
+		if err := EnableChangeFeed(q, table.Sources...); err != nil {
+			return err
+		}
+		err = WithTxRetry(ctx, q, RetryPolicy{}, func(tx DBTX) error {
+			return initDerivedTable(ctx, tx, table, definition)
+		})
+		if err != nil {
+			return fmt.Errorf("init derived table %s: %w", table.Name, err)
+		}
+	}
+	return nil
+}
+
+func initDerivedTable(ctx context.Context, q DBTX, table DerivedTable, definition string) error {
+	var stored string
+	err := q.QueryRowContext(ctx, `SELECT definition FROM `+CoreTableDerivedName+` WHERE name = ?`, table.Name).Scan(&stored)
+	switch {
+	case err == nil && stored == definition:
+		return nil
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("read definition: %w", err)
+	}
+	if table.Query != "" {
+		if err := materializeDerived(ctx, q, table, true); err != nil {
+			return err
+		}
+	} else if table.Rebuild != nil {
+		if err := table.Rebuild(ctx, q); err != nil {
+			return fmt.Errorf("rebuild: %w", err)
+		}
+	}
+	var seq int64
+	if err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM `+CoreTableChangesName).Scan(&seq); err != nil {
+		return fmt.Errorf("read last change: %w", err)
+	}
+	if err := checkpointDerived(ctx, q, table.Name, seq); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `INSERT INTO `+CoreTableDerivedName+` (name, definition) VALUES (?, ?) ON CONFLICT(name) DO UPDATE SET definition = excluded.definition`, table.Name, definition); err != nil {
+		return fmt.Errorf("store definition: %w", err)
+	}
+	return nil
+}
+
+// materializeDerived fills a Query table, recreating it when its columns may
+// have changed.
+func materializeDerived(ctx context.Context, q DBTX, table DerivedTable, recreate bool) error {
+	name := quoteSQLiteIdentifier(table.Name)
+	if recreate {
+		if _, err := q.ExecContext(ctx, `DROP TABLE IF EXISTS `+name); err != nil {
+			return fmt.Errorf("drop: %w", err)
+		}
+		if _, err := q.ExecContext(ctx, `CREATE TABLE `+name+` AS `+table.Query); err != nil {
+			return fmt.Errorf("create: %w", err)
+		}
+		return nil
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM `+name); err != nil {
+		return fmt.Errorf("clear: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `INSERT INTO `+name+` `+table.Query); err != nil {
+		return fmt.Errorf("fill: %w", err)
+	}
+	return nil
+}
+
+func checkpointDerived(ctx context.Context, q DBTX, name string, seq int64) error {
+	if _, err := q.ExecContext(ctx, `INSERT INTO `+CoreTableOutboxName+` (consumer, seq) VALUES (?, ?) ON CONFLICT(consumer) DO UPDATE SET seq = excluded.seq`, DerivedConsumerPrefix+name, seq); err != nil {
+		return fmt.Errorf("checkpoint derived table %s at %d: %w", name, seq, err)
+	}
+	return nil
+}
+
+// RefreshDerivedTables brings tables up to date with the change feed and
+// returns how many source changes were applied. A Query table is recomputed
+// once if any source changed; a Reduce table gets each change in order.
+// Every batch of changes commits together with its checkpoint.
+func RefreshDerivedTables(ctx context.Context, q DBTX, tables ...DerivedTable) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	var applied int64
+	for _, table := range tables {
+		if _, err := table.definition(); err != nil {
+			return applied, err
+		}
+		for {
+			var batch int
+			err := WithTxRetry(ctx, q, RetryPolicy{}, func(tx DBTX) error {
+				var err error
+				batch, err = refreshDerivedBatch(ctx, tx, table)
+				return err
+			})
+			if err != nil {
+				return applied, fmt.Errorf("refresh derived table %s: %w", table.Name, err)
+			}
+			if batch < 0 {
+				break
+			}
+			applied += int64(batch)
+		}
+	}
+	return applied, nil
+}
+
+// refreshDerivedBatch applies the next batch of changes and returns how many
+// were source changes, or -1 once the table is up to date.
+func refreshDerivedBatch(ctx context.Context, q DBTX, table DerivedTable) (int, error) {
+	seq, err := OutboxCheckpoint(q, DerivedConsumerPrefix+table.Name)
+	if err != nil {
+		return 0, err
+	}
+	changes, err := ReadChanges(q, seq, DefaultOutboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(changes) == 0 {
+		return -1, nil
+	}
+	applied := 0
+	for _, change := range changes {
+		if !slices.Contains(table.Sources, change.TableName) {
+			continue
+		}
+		applied++
+		if table.Reduce == nil {
+			continue
+		}
+		if err := table.Reduce(ctx, q, change); err != nil {
+			return 0, fmt.Errorf("reduce change %d: %w", change.Seq, err)
+		}
+	}
+	if table.Query != "" && applied > 0 {
+		if err := materializeDerived(ctx, q, table, false); err != nil {
+			return 0, err
+		}
+	}
+	return applied, checkpointDerived(ctx, q, table.Name, changes[len(changes)-1].Seq)
+}
+
+// StartDerivedRefresh runs RefreshDerivedTables immediately and then every
+// interval until ctx is done. Failures are logged and retried on the next
+// tick. The returned channel is closed once the loop has stopped.
+func StartDerivedRefresh(ctx context.Context, q DBTX, interval time.Duration, tables ...DerivedTable) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			applied, err := RefreshDerivedTables(ctx, q, tables...)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				slog.Error("refresh derived tables", "applied", applied, "err", err)
+			case applied > 0:
+				slog.Debug("refreshed derived tables", "applied", applied)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
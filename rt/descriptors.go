@@ -0,0 +1,176 @@
+package proprdbrt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ErrSchemaMismatch is returned by reads of streams whose embedded
+// descriptors encode a linked-in type differently.
+var ErrSchemaMismatch = errors.New("incompatible schema")
+
+// CheckCompatible compares the messages of d with the linked-in messages of
+// the same name. It fails with ErrSchemaMismatch listing the fields whose
+// JSON differs: fields of the same name with another kind, cardinality or
+// message or enum type. Fields only one side has are compatible.
+func (d *DynamicTypes) CheckCompatible() error {
+	problems := make([]string, 0)
+	d.types.RangeMessages(func(remote protoreflect.MessageType) bool {
+		local, err := protoregistry.GlobalTypes.FindMessageByName(remote.Descriptor().FullName())
+		if err != nil {
+			return true
+		}
+		problems = append(problems, compareMessageFields(local.Descriptor(), remote.Descriptor())...)
+		return true
+	})
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrSchemaMismatch, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func compareMessageFields(local, remote protoreflect.MessageDescriptor) []string {
+	problems := make([]string, 0)
+	remoteFields := remote.Fields()
+	for i := range remoteFields.Len() {
+		remoteField := remoteFields.Get(i)
+		localField := local.Fields().ByName(remoteField.Name())
+		if localField == nil {
+			continue
+		}
+		if describeFieldType(localField) != describeFieldType(remoteField) {
+			problems = append(problems, fmt.Sprintf("%s is %s, remote has %s", localField.FullName(), describeFieldType(localField), describeFieldType(remoteField)))
+		}
+	}
+	return problems
+}
+
+// describeFieldType names the JSON-relevant type of field, e.g.
+// "repeated string" or "map<string, example.Task>".
+func describeFieldType(field protoreflect.FieldDescriptor) string {
+	if field.IsMap() {
+		return "map<" + describeFieldType(field.MapKey()) + ", " + describeFieldType(field.MapValue()) + ">"
+	}
+	name := field.Kind().String()
+	switch {
+	case field.Message() != nil:
+		name = string(field.Message().FullName())
+	case field.Enum() != nil:
+		name = string(field.Enum().FullName())
+	}
+	if field.IsList() {
+		return "repeated " + name
+	}
+	return name
+}
+
+// DescriptorsStore keeps the files of set in _descriptors, replacing
+// earlier versions of the same file.
+func DescriptorsStore(q DBTX, set *descriptorpb.FileDescriptorSet, atNs int64) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	upsertSQL := `INSERT INTO ` + CoreTableDescriptorsName + ` (file, descriptor, at_ns) VALUES (?, ?, ?) ON CONFLICT(file) DO UPDATE SET descriptor = excluded.descriptor, at_ns = excluded.at_ns`
+	for _, file := range set.GetFile() {
+		encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(file)
+		if err != nil {
+			return fmt.Errorf("marshal descriptor of %s: %w", file.GetName(), err)
+		}
+		if _, err := q.ExecContext(context.Background(), upsertSQL, file.GetName(), encoded, atNs); err != nil {
+			return fmt.Errorf("store descriptor of %s: %w", file.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// StoredDescriptors returns the files kept in _descriptors by reads of
+// streams with embedded descriptors.
+func StoredDescriptors(q DBTX) (*descriptorpb.FileDescriptorSet, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT file, descriptor FROM `+CoreTableDescriptorsName+` ORDER BY file`)
+	if err != nil {
+		return nil, fmt.Errorf("select descriptors: %w", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for rows.Next() {
+		var path string
+		var encoded []byte
+		if err := rows.Scan(&path, &encoded); err != nil {
+			if closeErr := CloseRows(rows, "descriptors"); closeErr != nil {
+				return nil, fmt.Errorf("scan descriptor: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan descriptor: %w", err)
+		}
+		file := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(encoded, file); err != nil {
+			if closeErr := CloseRows(rows, "descriptors"); closeErr != nil {
+				return nil, fmt.Errorf("unmarshal descriptor of %s: %w (additionally, %v)", path, err, closeErr)
+			}
+			return nil, fmt.Errorf("unmarshal descriptor of %s: %w", path, err)
+		}
+		set.File = append(set.File, file)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "descriptors"); closeErr != nil {
+			return nil, fmt.Errorf("iterate descriptors: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate descriptors: %w", err)
+	}
+	if err := CloseRows(rows, "descriptors"); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// StoredDynamicTypes is NewDynamicTypes of StoredDescriptors.
+func StoredDynamicTypes(q DBTX) (*DynamicTypes, error) {
+	set, err := StoredDescriptors(q)
+	if err != nil {
+		return nil, err
+	}
+	return NewDynamicTypes(set)
+}
+
+// descriptorsHash is JSONLManifest.DescriptorsSHA256 of the serialized
+// FileDescriptorSet encoded.
+func descriptorsHash(encoded []byte) string {
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkDescriptors validates the descriptors embedded in a stream against
+// the linked-in types. A guard stores them and reports them in Report.
+func (g *ImportGuard) checkDescriptors(encoded []byte) error {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(encoded, set); err != nil {
+		return fmt.Errorf("unmarshal jsonl descriptors: %w", err)
+	}
+	types, err := NewDynamicTypes(set)
+	if err != nil {
+		return fmt.Errorf("jsonl descriptors: %w", err)
+	}
+	if err := types.CheckCompatible(); err != nil {
+		return err
+	}
+	if g == nil {
+		return nil
+	}
+	if g.q != nil {
+		if err := DescriptorsStore(g.q, set, Options{Clock: g.clock}.NowNs()); err != nil {
+			return err
+		}
+	}
+	g.Report.Descriptors = types
+	return nil
+}
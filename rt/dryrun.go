@@ -0,0 +1,120 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// SyncChanges counts what importing records would change in one table.
+type SyncChanges struct {
+	Creates int64
+	Updates int64
+	Deletes int64
+	// Conflicts counts records that lose against local state, e.g. under
+	// last-writer-wins because the local row is newer.
+	Conflicts int64
+}
+
+// JSONLDiff reports what a ReadJSONL of a stream would change, without
+// applying it. Deletes of objects without a live row change nothing and are
+// not counted.
+type JSONLDiff struct {
+	// Tables holds the changes by table name.
+	Tables map[string]SyncChanges
+	// Unknown counts records of types without a table here or with syncing
+	// paused, which would be kept in _unknown_types.
+	Unknown int64
+}
+
+// String lists the changes by table in name order.
+func (d JSONLDiff) String() string {
+	var b strings.Builder
+	for _, tableName := range slices.Sorted(maps.Keys(d.Tables)) {
+		changes := d.Tables[tableName]
+		fmt.Fprintf(&b, "%s: %d creates, %d updates, %d deletes, %d conflicts\n", tableName, changes.Creates, changes.Updates, changes.Deletes, changes.Conflicts)
+	}
+	if d.Unknown > 0 {
+		fmt.Fprintf(&b, "unknown: %d records\n", d.Unknown)
+	}
+	return b.String()
+}
+
+// Observe counts record of tableName, which is applied unless applies is
+// false. It queries q, before the record is applied, whether the object has
+// a live row. A nil JSONLDiff ignores records.
+func (d *JSONLDiff) Observe(q DBTX, tableName string, record JSONLRecord, applies bool) error {
+	if d == nil {
+		return nil
+	}
+	if d.Tables == nil {
+		d.Tables = make(map[string]SyncChanges)
+	}
+	changes := d.Tables[tableName]
+	defer func() { d.Tables[tableName] = changes }()
+	if !applies {
+		changes.Conflicts++
+		return nil
+	}
+	var exists bool
+	err := q.QueryRowContext(context.Background(), `SELECT 1 FROM "`+tableName+`" WHERE id = ?`, record.ID).Scan(&exists)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("select row for %s/%s: %w", tableName, record.ID, err)
+	}
+	switch {
+	case record.Deleted && exists:
+		changes.Deletes++
+	case record.Deleted:
+	case exists:
+		changes.Updates++
+	default:
+		changes.Creates++
+	}
+	return nil
+}
+
+// ObserveUnknown counts a record kept in _unknown_types.
+func (d *JSONLDiff) ObserveUnknown() {
+	if d != nil {
+		d.Unknown++
+	}
+}
+
+// errDryRun rolls back the transaction of DryRun.
+var errDryRun = errors.New("dry run")
+
+// DryRun runs fn in a transaction, or a savepoint when q is a transaction
+// already, and rolls back whatever fn wrote.
+func DryRun(q DBTX, fn func(DBTX) error) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if _, ok := q.(txBeginner); ok {
+		err := InTx(q, func(tx DBTX) error {
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return errDryRun
+		})
+		if errors.Is(err, errDryRun) {
+			return nil
+		}
+		return err
+	}
+	ctx := context.Background()
+	if _, err := q.ExecContext(ctx, `SAVEPOINT proprdb_dry_run`); err != nil {
+		return fmt.Errorf("begin dry run savepoint: %w", err)
+	}
+	fnErr := fn(q)
+	if _, err := q.ExecContext(ctx, `ROLLBACK TO proprdb_dry_run`); err != nil {
+		return fmt.Errorf("roll back dry run savepoint: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `RELEASE proprdb_dry_run`); err != nil {
+		return fmt.Errorf("release dry run savepoint: %w", err)
+	}
+	return fnErr
+}
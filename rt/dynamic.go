@@ -0,0 +1,242 @@
+package proprdbrt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// DynamicTypes decodes record data with message types built from
+// descriptors, for tooling that lacks the generated Go types. Types it does
+// not define, such as well-known types, resolve from the types linked in.
+type DynamicTypes struct {
+	files *protoregistry.Files
+	types *protoregistry.Types
+}
+
+// NewDynamicTypes builds the message types of set. Files may depend on
+// files outside set that are linked in, e.g. google/protobuf/timestamp.proto.
+func NewDynamicTypes(set *descriptorpb.FileDescriptorSet) (*DynamicTypes, error) {
+	if set == nil {
+		return nil, errors.New("nil FileDescriptorSet")
+	}
+	dynamic := &DynamicTypes{files: new(protoregistry.Files), types: new(protoregistry.Types)}
+	byPath := make(map[string]*descriptorpb.FileDescriptorProto, len(set.GetFile()))
+	for _, file := range set.GetFile() {
+		byPath[file.GetName()] = file
+	}
+	var register func(path string, visiting map[string]bool) error
+	register = func(path string, visiting map[string]bool) error {
+		if _, err := dynamic.files.FindFileByPath(path); err == nil {
+			return nil
+		}
+		file, ok := byPath[path]
+		if !ok {
+			// Resolved from the linked-in files by protodesc.
+			return nil
+		}
+		if visiting[path] {
+			return fmt.Errorf("import cycle at %s", path)
+		}
+		visiting[path] = true
+		for _, dependency := range file.GetDependency() {
+			if err := register(dependency, visiting); err != nil {
+				return err
+			}
+		}
+		descriptor, err := protodesc.NewFile(file, dynamicResolver{dynamic.files})
+		if err != nil {
+			return fmt.Errorf("build descriptor of %s: %w", path, err)
+		}
+		if err := dynamic.files.RegisterFile(descriptor); err != nil {
+			return fmt.Errorf("register descriptor of %s: %w", path, err)
+		}
+		return dynamic.registerMessages(descriptor.Messages())
+	}
+	for _, file := range set.GetFile() {
+		if err := register(file.GetName(), make(map[string]bool)); err != nil {
+			return nil, err
+		}
+	}
+	return dynamic, nil
+}
+
+// LoadDynamicTypes is NewDynamicTypes for a serialized FileDescriptorSet, as
+// written by protoc --descriptor_set_out --include_imports or buf build.
+func LoadDynamicTypes(data []byte) (*DynamicTypes, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("unmarshal FileDescriptorSet: %w", err)
+	}
+	return NewDynamicTypes(set)
+}
+
+// DescriptorSetOf returns the files defining messages and their imports, to
+// ship with data for readers without the generated types.
+func DescriptorSetOf(messages ...proto.Message) *descriptorpb.FileDescriptorSet {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	var add func(file protoreflect.FileDescriptor)
+	add = func(file protoreflect.FileDescriptor) {
+		if seen[file.Path()] {
+			return
+		}
+		seen[file.Path()] = true
+		imports := file.Imports()
+		for i := range imports.Len() {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(file))
+	}
+	for _, message := range messages {
+		add(message.ProtoReflect().Descriptor().ParentFile())
+	}
+	return set
+}
+
+func (d *DynamicTypes) registerMessages(messages protoreflect.MessageDescriptors) error {
+	for i := range messages.Len() {
+		message := messages.Get(i)
+		if message.IsMapEntry() {
+			continue
+		}
+		if err := d.types.RegisterMessage(dynamicpb.NewMessageType(message)); err != nil {
+			return fmt.Errorf("register %s: %w", message.FullName(), err)
+		}
+		if err := d.registerMessages(message.Messages()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Knows reports whether d defines typeName.
+func (d *DynamicTypes) Knows(typeName string) bool {
+	_, err := d.types.FindMessageByName(protoreflect.FullName(typeName))
+	return err == nil
+}
+
+// FindMessageByName implements protoregistry.MessageTypeResolver.
+func (d *DynamicTypes) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	if messageType, err := d.types.FindMessageByName(name); err == nil {
+		return messageType, nil
+	}
+	return protoregistry.GlobalTypes.FindMessageByName(name)
+}
+
+// FindMessageByURL implements protoregistry.MessageTypeResolver.
+func (d *DynamicTypes) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	return d.FindMessageByName(protoreflect.FullName(TypeNameFromURL(url)))
+}
+
+// FindExtensionByName implements protoregistry.ExtensionTypeResolver.
+func (d *DynamicTypes) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByName(name)
+}
+
+// FindExtensionByNumber implements protoregistry.ExtensionTypeResolver.
+func (d *DynamicTypes) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
+
+// Decode decodes data, the google.protobuf.Any JSON of a record, into a
+// message of d, a *dynamicpb.Message unless the type is linked in only.
+func (d *DynamicTypes) Decode(data json.RawMessage) (proto.Message, error) {
+	anyMessage := &anypb.Any{}
+	if err := (protojson.UnmarshalOptions{Resolver: d}).Unmarshal(data, anyMessage); err != nil {
+		return nil, fmt.Errorf("unmarshal any json: %w", err)
+	}
+	message, err := anypb.UnmarshalNew(anyMessage, proto.UnmarshalOptions{Resolver: d})
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", anyMessage.GetTypeUrl(), err)
+	}
+	return message, nil
+}
+
+// DecodedUnknownRecord is a row of _unknown_types decoded by DynamicTypes.
+type DecodedUnknownRecord struct {
+	TypeName string
+	Record   JSONLRecord
+	// Message is the decoded data, nil for tombstones and failed decodes.
+	Message proto.Message
+	// Err is why the data did not decode, e.g. a type unknown to d too.
+	Err error
+}
+
+// DecodeUnknown decodes the rows of _unknown_types, or only those of
+// typeName when it is not empty, ordered by type, id and at_ns. Rows that
+// do not decode are returned with Err set.
+func (d *DynamicTypes) DecodeUnknown(q DBTX, typeName string) ([]DecodedUnknownRecord, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	selectSQL := `SELECT type_name, id, at_ns, deleted, data_json FROM ` + CoreTableUnknownName
+	args := make([]any, 0, 1)
+	if strings.TrimSpace(typeName) != "" {
+		selectSQL += ` WHERE type_name = ?`
+		args = append(args, typeName)
+	}
+	rows, err := q.QueryContext(context.Background(), selectSQL+` ORDER BY type_name, id, at_ns`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select unknown rows: %w", err)
+	}
+	decoded := make([]DecodedUnknownRecord, 0)
+	for rows.Next() {
+		var row DecodedUnknownRecord
+		var deletedInt int
+		var dataJSON string
+		if err := rows.Scan(&row.TypeName, &row.Record.ID, &row.Record.AtNs, &deletedInt, &dataJSON); err != nil {
+			if closeErr := CloseRows(rows, "unknown rows"); closeErr != nil {
+				return nil, fmt.Errorf("scan unknown row: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan unknown row: %w", err)
+		}
+		row.Record.Deleted = deletedInt != 0
+		row.Record.Data = json.RawMessage(dataJSON)
+		if !row.Record.Deleted {
+			row.Message, row.Err = d.Decode(row.Record.Data)
+		}
+		decoded = append(decoded, row)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "unknown rows"); closeErr != nil {
+			return nil, fmt.Errorf("iterate unknown rows: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate unknown rows: %w", err)
+	}
+	if err := CloseRows(rows, "unknown rows"); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// dynamicResolver resolves imports from files, then from the linked-in
+// files.
+type dynamicResolver struct {
+	files *protoregistry.Files
+}
+
+func (r dynamicResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if file, err := r.files.FindFileByPath(path); err == nil {
+		return file, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r dynamicResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if descriptor, err := r.files.FindDescriptorByName(name); err == nil {
+		return descriptor, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
@@ -0,0 +1,558 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	proprdbpb "github.com/fingon/proprdb/proto/proprdb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// DynamicBundle is a Bundle over the generated tables of a database for
+// binaries that do not link their generated package, such as cmd/proprdb.
+// The type of each table in _proprdb_schema is found among DynamicTypes by
+// its table name, and its projected columns are those of the projection
+// schema stored there. Tables using version vectors, soft deletes, history,
+// merges, tenants or encrypted, map or vector projections are exported but
+// not imported, as applying their records needs the generated code.
+type DynamicBundle struct {
+	q      DBTX
+	types  *DynamicTypes
+	tables []*dynamicTable
+	// byType holds the tables by type name.
+	byType map[string]*dynamicTable
+}
+
+type dynamicTable struct {
+	tableName     string
+	messageType   protoreflect.MessageType
+	syncEnabled   bool
+	typeURLPrefix string
+	strategy      ConflictStrategy
+	syncFilters   map[string]string
+	renames       map[string]string
+	rules         []FieldRule
+	opts          Options
+	columns       []dynamicColumn
+	versionVector bool
+	softDelete    bool
+	// unsupported is why records cannot be imported into the table, empty
+	// when they can.
+	unsupported string
+}
+
+// dynamicColumn is a projected column and the field path it projects.
+type dynamicColumn struct {
+	name      string
+	path      string
+	timestamp bool
+	rfc3339   bool
+	optional  bool
+}
+
+// NewDynamicBundle returns a Bundle over the tables of q whose types are in
+// types, e.g. StoredDynamicTypes(q).
+func NewDynamicBundle(q DBTX, types *DynamicTypes) *DynamicBundle {
+	return &DynamicBundle{q: q, types: types}
+}
+
+// Init matches the tables in _proprdb_schema with the types. It creates no
+// tables: the database must have been initialized by its generated package.
+func (b *DynamicBundle) Init() error {
+	if b.q == nil {
+		return errors.New("nil DBTX")
+	}
+	if b.types == nil {
+		return errors.New("nil DynamicTypes")
+	}
+	exists, err := tableExists(b.q, CoreTableSchemaStateName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%s does not exist; initialize the database with its generated package first", CoreTableSchemaStateName)
+	}
+	schemas, err := schemaStates(b.q)
+	if err != nil {
+		return err
+	}
+	messages := b.types.tableMessages()
+	b.tables = nil
+	b.byType = make(map[string]*dynamicTable)
+	for _, state := range schemas {
+		message, ok := messages[state[0]]
+		if !ok {
+			// Tables of types missing from the descriptors are left alone.
+			continue
+		}
+		table, err := newDynamicTable(b.q, b.types, state[0], message, state[1])
+		if err != nil {
+			return err
+		}
+		b.tables = append(b.tables, table)
+		b.byType[string(message.FullName())] = table
+	}
+	return nil
+}
+
+// TableDescriptors describes the matched tables.
+func (b *DynamicBundle) TableDescriptors() []GeneratedTableDescriptor {
+	descriptors := make([]GeneratedTableDescriptor, 0, len(b.tables))
+	for _, table := range b.tables {
+		descriptors = append(descriptors, GeneratedTableDescriptor{
+			TableName:   table.tableName,
+			TypeName:    string(table.messageType.Descriptor().FullName()),
+			SyncEnabled: table.syncEnabled,
+		})
+	}
+	return descriptors
+}
+
+// WriteJSONL writes the records and tombstones of the synced tables not yet
+// sent to remote, honoring (proprdb.sync_filters), and marks them in _sync.
+func (b *DynamicBundle) WriteJSONL(remote string, w io.Writer) error {
+	if w == nil {
+		return errors.New("nil writer")
+	}
+	pending := make([]PendingJSONLRecord, 0)
+	for _, table := range b.tables {
+		if !table.syncEnabled {
+			continue
+		}
+		records, err := table.pending(b.q, remote)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, records...)
+	}
+	return WriteJSONLChunks(b.q, remote, w, pending, 1, nil)
+}
+
+// ReadJSONL imports the records of r like the generated ReadJSONL: records
+// apply per the conflict strategy of their table, and records of types
+// without a table are kept as unknown. A record for a table it cannot
+// import fails the read.
+func (b *DynamicBundle) ReadJSONL(remote string, r io.Reader) error {
+	if r == nil {
+		return errors.New("nil reader")
+	}
+	guard := NewImportGuard(b.q, ImportOptions{}, remote, nil)
+	readErr := ReadJSONLGuarded(context.Background(), r, JSONLOptions{}, guard, func(record JSONLRecord, lineNumber int) error {
+		if record.ID == "" {
+			return Reject(fmt.Errorf("jsonl line %d has empty id", lineNumber))
+		}
+		if len(record.Data) == 0 {
+			return Reject(fmt.Errorf("jsonl line %d has empty data", lineNumber))
+		}
+		typeName, err := TypeNameFromAnyJSON(record.Data)
+		if err != nil {
+			return Reject(fmt.Errorf("read @type on line %d: %w", lineNumber, err))
+		}
+		table, ok := b.byType[typeName]
+		switch {
+		case !ok:
+			return UnknownInsert(b.q, typeName, record)
+		case !table.syncEnabled:
+			return nil
+		case table.unsupported != "":
+			return fmt.Errorf("import %s into %s: %s; use a binary that links its generated package", typeName, table.tableName, table.unsupported)
+		}
+		return table.apply(b.q, b.types, remote, record, lineNumber)
+	})
+	compactErr := CompactUnknownLatest(b.q)
+	if readErr != nil {
+		if compactErr != nil {
+			return fmt.Errorf("read jsonl: %w (additionally, compact unknown rows: %v)", readErr, compactErr)
+		}
+		return readErr
+	}
+	if compactErr != nil {
+		return fmt.Errorf("compact unknown rows: %w", compactErr)
+	}
+	return nil
+}
+
+// schemaStates returns the table names and projection schemas of
+// _proprdb_schema, ordered by table name.
+func schemaStates(q DBTX) ([][2]string, error) {
+	rows, err := q.QueryContext(context.Background(), `SELECT table_name, schema_hash FROM `+CoreTableSchemaStateName+` ORDER BY table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("select schema state tables: %w", err)
+	}
+	states := make([][2]string, 0)
+	for rows.Next() {
+		var state [2]string
+		if err := rows.Scan(&state[0], &state[1]); err != nil {
+			if closeErr := CloseRows(rows, "schema state"); closeErr != nil {
+				return nil, fmt.Errorf("scan schema state row: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan schema state row: %w", err)
+		}
+		states = append(states, state)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "schema state"); closeErr != nil {
+			return nil, fmt.Errorf("iterate schema state rows: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate schema state rows: %w", err)
+	}
+	if err := CloseRows(rows, "schema state"); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// tableMessages returns the messages of d by the name of their generated
+// table: (proprdb.table_name) or the lower-cased full name with dots
+// replaced by underscores.
+func (d *DynamicTypes) tableMessages() map[string]protoreflect.MessageDescriptor {
+	messages := make(map[string]protoreflect.MessageDescriptor)
+	var add func(descriptors protoreflect.MessageDescriptors)
+	add = func(descriptors protoreflect.MessageDescriptors) {
+		for index := range descriptors.Len() {
+			message := descriptors.Get(index)
+			if message.IsMapEntry() {
+				continue
+			}
+			add(message.Messages())
+			if omit, _ := proto.GetExtension(message.Options(), proprdbpb.E_OmitTable).(bool); omit {
+				continue
+			}
+			tableName, _ := proto.GetExtension(message.Options(), proprdbpb.E_TableName).(string)
+			if tableName == "" {
+				tableName = strings.ToLower(strings.ReplaceAll(string(message.FullName()), ".", "_"))
+			}
+			messages[tableName] = message
+		}
+	}
+	d.files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		add(file.Messages())
+		return true
+	})
+	return messages
+}
+
+func newDynamicTable(q DBTX, types *DynamicTypes, tableName string, message protoreflect.MessageDescriptor, projectionSchema string) (*dynamicTable, error) {
+	messageType, err := types.FindMessageByName(message.FullName())
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", tableName, err)
+	}
+	options := message.Options()
+	omitSync, _ := proto.GetExtension(options, proprdbpb.E_OmitSync).(bool)
+	prefix, _ := proto.GetExtension(message.ParentFile().Options(), proprdbpb.E_TypeUrlPrefix).(string)
+	strategy, _ := proto.GetExtension(options, proprdbpb.E_ConflictStrategy).(proprdbpb.ConflictStrategy)
+	table := &dynamicTable{
+		tableName:     tableName,
+		messageType:   messageType,
+		syncEnabled:   !omitSync,
+		typeURLPrefix: prefix,
+		strategy:      ConflictStrategy(strategy),
+	}
+	if compression, _ := proto.GetExtension(options, proprdbpb.E_Compression).(proprdbpb.Compression); compression == proprdbpb.Compression_COMPRESSION_GZIP {
+		table.opts.DataCodec = GzipDataCodec{}
+	}
+	filters, _ := proto.GetExtension(options, proprdbpb.E_SyncFilters).([]*proprdbpb.SyncFilter)
+	for _, filter := range filters {
+		if table.syncFilters == nil {
+			table.syncFilters = make(map[string]string)
+		}
+		table.syncFilters[filter.GetRemote()] = filter.GetWhere()
+	}
+	var unsupported []string
+	if table.strategy == ConflictMerge {
+		unsupported = append(unsupported, "merge conflict strategy")
+	}
+	fields := message.Fields()
+	for index := range fields.Len() {
+		field := fields.Get(index)
+		fieldOptions := field.Options()
+		if merge, _ := proto.GetExtension(fieldOptions, proprdbpb.E_Merge).(proprdbpb.Merge); merge != proprdbpb.Merge_MERGE_NONE {
+			unsupported = append(unsupported, "field merges")
+		}
+		if tenant, _ := proto.GetExtension(fieldOptions, proprdbpb.E_TenantField).(bool); tenant {
+			unsupported = append(unsupported, "tenants")
+		}
+		if renamedFrom, _ := proto.GetExtension(fieldOptions, proprdbpb.E_RenamedFrom).(string); renamedFrom != "" {
+			if table.renames == nil {
+				table.renames = make(map[string]string)
+			}
+			table.renames[renamedFrom] = field.JSONName()
+			table.renames[jsonCamelCase(renamedFrom)] = field.JSONName()
+		}
+		rule := FieldRule{Field: string(field.Name())}
+		rule.Min, rule.HasMin = fieldOptionFloat(fieldOptions, proprdbpb.E_Min)
+		rule.Max, rule.HasMax = fieldOptionFloat(fieldOptions, proprdbpb.E_Max)
+		rule.Pattern, _ = proto.GetExtension(fieldOptions, proprdbpb.E_Pattern).(string)
+		rule.Required, _ = proto.GetExtension(fieldOptions, proprdbpb.E_Required).(bool)
+		if rule.HasMin || rule.HasMax || rule.Pattern != "" || rule.Required {
+			table.rules = append(table.rules, rule)
+		}
+	}
+	for _, entry := range strings.Split(projectionSchema, ";") {
+		if entry == "" || strings.HasPrefix(entry, "idx:") || strings.HasPrefix(entry, "mig:") {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("table %s: invalid projection %q", tableName, entry)
+		}
+		flags := parts[2:]
+		if strings.HasPrefix(parts[1], "map<") || slices.Contains(flags, "encrypted") || slices.Contains(flags, "vector") {
+			unsupported = append(unsupported, fmt.Sprintf("projection %q", entry))
+			continue
+		}
+		column := dynamicColumn{
+			name:      strings.ReplaceAll(parts[0], ".", "_"),
+			path:      parts[0],
+			timestamp: parts[1] == "google.protobuf.Timestamp",
+			rfc3339:   slices.Contains(flags, "rfc3339"),
+			optional:  slices.Contains(flags, "optional"),
+		}
+		for _, flag := range flags {
+			if name, ok := strings.CutPrefix(flag, "column="); ok {
+				column.name = name
+			}
+		}
+		table.columns = append(table.columns, column)
+	}
+	columns, err := queryNames(q, `SELECT name FROM pragma_table_info(?)`, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("list columns of %s: %w", tableName, err)
+	}
+	table.versionVector = columns[VersionVectorColumn]
+	table.softDelete = columns["deleted_at_ns"]
+	switch {
+	case table.versionVector:
+		unsupported = append(unsupported, "version vectors")
+	case table.softDelete:
+		unsupported = append(unsupported, "soft deletes")
+	case columns["created_at_ns"]:
+		unsupported = append(unsupported, "tracked timestamps")
+	}
+	history, err := tableExists(q, tableName+HistoryTableSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if history {
+		unsupported = append(unsupported, "history")
+	}
+	slices.Sort(unsupported)
+	table.unsupported = strings.Join(slices.Compact(unsupported), ", ")
+	return table, nil
+}
+
+func fieldOptionFloat(options protoreflect.ProtoMessage, extension protoreflect.ExtensionType) (float64, bool) {
+	if !proto.HasExtension(options, extension) {
+		return 0, false
+	}
+	value, ok := proto.GetExtension(options, extension).(float64)
+	return value, ok
+}
+
+// jsonCamelCase is the JSON name protoc derives from a field name.
+func jsonCamelCase(name string) string {
+	var builder strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			builder.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// pending selects the records and tombstones of t not yet sent to remote.
+func (t *dynamicTable) pending(q DBTX, remote string) ([]PendingJSONLRecord, error) {
+	typeName := string(t.messageType.Descriptor().FullName())
+	synced, err := LoadSyncedAtNs(q, t.tableName, []string{remote})
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]PendingJSONLRecord, 0)
+	for _, scan := range SyncScans(SyncPolicy{}, []string{remote}, typeName, t.syncFilters) {
+		query := `SELECT id, at_ns, data FROM ` + quoteSQLiteIdentifier(t.tableName)
+		var conditions []string
+		if t.softDelete {
+			conditions = append(conditions, `deleted_at_ns IS NULL`)
+		}
+		if scan.Where != "" {
+			conditions = append(conditions, "("+scan.Where+")")
+		}
+		if len(conditions) > 0 {
+			query += ` WHERE ` + strings.Join(conditions, " AND ")
+		}
+		rows, err := q.QueryContext(context.Background(), query+` ORDER BY id`)
+		if err != nil {
+			return nil, fmt.Errorf("select %s rows for jsonl write: %w", t.tableName, err)
+		}
+		var records []JSONLRecord
+		for rows.Next() {
+			var record JSONLRecord
+			var stored []byte
+			if err := rows.Scan(&record.ID, &record.AtNs, &stored); err != nil {
+				if closeErr := CloseRows(rows, t.tableName); closeErr != nil {
+					return nil, fmt.Errorf("scan %s row: %w (additionally, %v)", t.tableName, err, closeErr)
+				}
+				return nil, fmt.Errorf("scan %s row: %w", t.tableName, err)
+			}
+			if !synced.NeedsSend(remote, record.ID, record.AtNs) {
+				continue
+			}
+			message := t.messageType.New().Interface()
+			if err := UnmarshalData(t.opts, stored, message); err != nil {
+				if closeErr := CloseRows(rows, t.tableName); closeErr != nil {
+					return nil, fmt.Errorf("unmarshal %s/%s: %w (additionally, %v)", t.tableName, record.ID, err, closeErr)
+				}
+				return nil, fmt.Errorf("unmarshal %s/%s: %w", t.tableName, record.ID, err)
+			}
+			if record.Data, err = MarshalAnyJSONWithPrefix(t.typeURLPrefix, message); err != nil {
+				if closeErr := CloseRows(rows, t.tableName); closeErr != nil {
+					return nil, fmt.Errorf("marshal %s/%s for jsonl write: %w (additionally, %v)", t.tableName, record.ID, err, closeErr)
+				}
+				return nil, fmt.Errorf("marshal %s/%s for jsonl write: %w", t.tableName, record.ID, err)
+			}
+			records = append(records, record)
+		}
+		if err := rows.Err(); err != nil {
+			if closeErr := CloseRows(rows, t.tableName); closeErr != nil {
+				return nil, fmt.Errorf("iterate %s rows: %w (additionally, %v)", t.tableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("iterate %s rows: %w", t.tableName, err)
+		}
+		if err := CloseRows(rows, t.tableName); err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if t.versionVector {
+				if record.VersionVector, _, err = ReadVersionVector(q, t.tableName, record.ID); err != nil {
+					return nil, err
+				}
+			}
+			pending = append(pending, PendingJSONLRecord{TableName: t.tableName, Record: record})
+		}
+	}
+	if !SyncIncludesType(SyncPolicy{}, remote, typeName) {
+		return pending, nil
+	}
+	tombstones, err := ListTombstones(q, t.tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, tombstone := range tombstones {
+		if !synced.NeedsSend(remote, tombstone.ID, tombstone.AtNs) {
+			continue
+		}
+		dataJSON, err := MarshalTypeOnlyAnyJSONWithPrefix(t.typeURLPrefix, typeName)
+		if err != nil {
+			return nil, fmt.Errorf("marshal tombstone %s/%s for jsonl write: %w", t.tableName, tombstone.ID, err)
+		}
+		pending = append(pending, PendingJSONLRecord{TableName: t.tableName, Record: JSONLRecord{ID: tombstone.ID, Deleted: true, AtNs: tombstone.AtNs, Data: dataJSON}})
+	}
+	return pending, nil
+}
+
+// apply imports record into t, as the generated import of the table would.
+func (t *dynamicTable) apply(q DBTX, types *DynamicTypes, remote string, record JSONLRecord, lineNumber int) error {
+	typeName := string(t.messageType.Descriptor().FullName())
+	localMaxAtNs, err := LocalMaxAtNs(q, t.tableName, record.ID)
+	if err != nil {
+		return err
+	}
+	if err := SyncUpsert(q, record.ID, t.tableName, remote, record.AtNs); err != nil {
+		return err
+	}
+	if !ShouldApplyRemote(t.strategy, record.AtNs, localMaxAtNs, record.Deleted) {
+		return nil
+	}
+	ctx := context.Background()
+	if record.Deleted {
+		if _, err := q.ExecContext(ctx, `INSERT INTO `+CoreTableDeletedName+` (table_name, id, at_ns) VALUES (?, ?, ?) ON CONFLICT(table_name, id) DO UPDATE SET at_ns = excluded.at_ns`, t.tableName, record.ID, record.AtNs); err != nil {
+			return fmt.Errorf("insert tombstone for %s/%s: %w", t.tableName, record.ID, err)
+		}
+		if _, err := q.ExecContext(ctx, `DELETE FROM `+quoteSQLiteIdentifier(t.tableName)+` WHERE id = ?`, record.ID); err != nil {
+			return fmt.Errorf("delete from %s/%s: %w", t.tableName, record.ID, err)
+		}
+		return nil
+	}
+	if record.Data, err = RenameJSONFields(record.Data, t.renames); err != nil {
+		return Reject(fmt.Errorf("read renamed fields on line %d: %w", lineNumber, err))
+	}
+	message, err := types.Decode(record.Data)
+	if err != nil {
+		return Reject(fmt.Errorf("unmarshal %s data on line %d: %w", typeName, lineNumber, err))
+	}
+	if err := ValidateFieldRules(message, t.rules); err != nil {
+		return Reject(ValidationError(typeName, err))
+	}
+	dataBytes, err := MarshalData(t.opts, message)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", typeName, err)
+	}
+	names := []string{"id", "at_ns", "data"}
+	args := []any{record.ID, record.AtNs, dataBytes}
+	for _, column := range t.columns {
+		names = append(names, column.name)
+		args = append(args, column.value(message))
+	}
+	quoted := make([]string, len(names))
+	updates := make([]string, 0, len(names)-1)
+	for index, name := range names {
+		quoted[index] = quoteSQLiteIdentifier(name)
+		if index > 0 {
+			updates = append(updates, quoted[index]+` = excluded.`+quoted[index])
+		}
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM `+CoreTableDeletedName+` WHERE table_name = ? AND id = ?`, t.tableName, record.ID); err != nil {
+		return fmt.Errorf("delete tombstone for %s/%s: %w", t.tableName, record.ID, err)
+	}
+	upsertSQL := `INSERT INTO ` + quoteSQLiteIdentifier(t.tableName) + ` (` + strings.Join(quoted, ", ") + `) VALUES (` +
+		strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ") + `) ON CONFLICT(id) DO UPDATE SET ` + strings.Join(updates, ", ")
+	if _, err := q.ExecContext(ctx, upsertSQL, args...); err != nil {
+		return fmt.Errorf("upsert into %s: %w", t.tableName, ClassifySQLError(err))
+	}
+	return nil
+}
+
+// value returns the column value projected from message, nil for unset
+// optional fields.
+func (c dynamicColumn) value(message proto.Message) any {
+	current := message.ProtoReflect()
+	names := strings.Split(c.path, ".")
+	for _, name := range names[:len(names)-1] {
+		field := current.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if field == nil || field.Message() == nil {
+			return nil
+		}
+		current = current.Get(field).Message()
+	}
+	field := current.Descriptor().Fields().ByName(protoreflect.Name(names[len(names)-1]))
+	if field == nil {
+		return nil
+	}
+	if c.optional && !current.Has(field) {
+		return nil
+	}
+	if !c.timestamp {
+		return PathValue(message, c.path)
+	}
+	timestamp := current.Get(field).Message()
+	fields := timestamp.Descriptor().Fields()
+	at := time.Unix(timestamp.Get(fields.ByName("seconds")).Int(), timestamp.Get(fields.ByName("nanos")).Int()).UTC()
+	if c.rfc3339 {
+		return FormatTimestampText(at)
+	}
+	return at.UnixNano()
+}
@@ -0,0 +1,93 @@
+package proprdbrt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors of generated code and this package wrap these sentinels, so callers
+// can tell failures apart with errors.Is.
+var (
+	// ErrNotFound is wrapped when no row has the requested id.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidID is wrapped when an id is empty or not a UUID.
+	ErrInvalidID = errors.New("invalid id")
+	// ErrValidation is wrapped when data fails its Valid() method, its
+	// declarative field rules, its buf.validate constraints or a CHECK
+	// constraint.
+	ErrValidation = errors.New("validation failed")
+	// ErrUniqueViolation is wrapped when a write violates a UNIQUE or
+	// PRIMARY KEY constraint, e.g. inserting an existing id.
+	ErrUniqueViolation = errors.New("unique violation")
+	// ErrConflict is wrapped when resolving a sync conflict fails, e.g. for
+	// a missing or failing merge function.
+	ErrConflict = errors.New("conflict")
+	// ErrUnknownType is wrapped when a message is not of the expected type.
+	ErrUnknownType = errors.New("unknown type")
+)
+
+// ErrEmptyID is returned for empty ids.
+var ErrEmptyID error = classifiedError{err: errors.New("empty id"), kind: ErrInvalidID}
+
+// classifiedError keeps the message of err while also matching kind.
+type classifiedError struct {
+	err  error
+	kind error
+}
+
+func (e classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e classifiedError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+func classify(err, kind error) error {
+	if err == nil || errors.Is(err, kind) {
+		return err
+	}
+	return classifiedError{err: err, kind: kind}
+}
+
+// InvalidIDError marks err as ErrInvalidID.
+func InvalidIDError(err error) error {
+	return classify(err, ErrInvalidID)
+}
+
+// ValidationError reports err validating a typeName message as
+// ErrValidation.
+func ValidationError(typeName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return classify(fmt.Errorf("validate %s: %w", typeName, err), ErrValidation)
+}
+
+// ConflictError marks err as ErrConflict.
+func ConflictError(err error) error {
+	return classify(err, ErrConflict)
+}
+
+// UnknownTypeError reports a message of typeName that is a got instead.
+func UnknownTypeError(typeName string, got any) error {
+	return fmt.Errorf("%w: expected %s, got %T", ErrUnknownType, typeName, got)
+}
+
+// ClassifySQLError marks SQLite constraint errors as ErrUniqueViolation or
+// ErrValidation and returns other errors unchanged.
+func ClassifySQLError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "UNIQUE constraint failed"):
+		return classify(err, ErrUniqueViolation)
+	case strings.Contains(message, "CHECK constraint failed"):
+		return classify(err, ErrValidation)
+	default:
+		return err
+	}
+}
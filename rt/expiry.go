@@ -0,0 +1,80 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Expirer is implemented by generated tables with (proprdb.ttl_seconds) and
+// by generated CRUD bundles.
+type Expirer interface {
+	// ExpireStale tombstones expired rows and returns how many it removed.
+	ExpireStale() (int64, error)
+}
+
+// ExpiredIDs returns the ids of rows of tableName last written before
+// cutoffNs. A non-empty where further restricts the rows considered.
+func ExpiredIDs(q DBTX, tableName, where string, cutoffNs int64) ([]string, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	query := `SELECT id FROM "` + tableName + `" WHERE at_ns < ?`
+	if strings.TrimSpace(where) != "" {
+		query += " AND (" + where + ")"
+	}
+	rows, err := q.QueryContext(context.Background(), query, cutoffNs)
+	if err != nil {
+		return nil, fmt.Errorf("select expired rows from %s: %w", tableName, err)
+	}
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			if closeErr := CloseRows(rows, "expiry"); closeErr != nil {
+				return nil, fmt.Errorf("scan expired row from %s: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("scan expired row from %s: %w", tableName, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "expiry"); closeErr != nil {
+			return nil, fmt.Errorf("iterate expired rows from %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate expired rows from %s: %w", tableName, err)
+	}
+	if err := CloseRows(rows, "expiry"); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// StartExpiryLoop runs expirer.ExpireStale immediately and then every
+// interval until ctx is done. Failures are logged and retried on the next
+// tick. The returned channel is closed once the loop has stopped.
+func StartExpiryLoop(ctx context.Context, interval time.Duration, expirer Expirer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			expired, err := expirer.ExpireStale()
+			if err != nil {
+				slog.Error("expire stale rows", "err", err)
+			} else if expired > 0 {
+				slog.Debug("expired stale rows", "count", expired)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
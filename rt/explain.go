@@ -0,0 +1,115 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// QueryPlanStep is one row of EXPLAIN QUERY PLAN.
+type QueryPlanStep struct {
+	ID     int
+	Parent int
+	Detail string
+}
+
+// QueryPlan is the EXPLAIN QUERY PLAN of a select with index suggestions.
+type QueryPlan struct {
+	Steps []QueryPlanStep
+	// FullScan reports whether the table is scanned without an index.
+	FullScan bool
+	// Suggestions are heuristic hints, e.g. "consider adding index on age".
+	Suggestions []string
+}
+
+// String renders the plan like the sqlite3 shell, followed by the
+// suggestions.
+func (p QueryPlan) String() string {
+	depths := make(map[int]int, len(p.Steps))
+	var b strings.Builder
+	for _, step := range p.Steps {
+		depth := 0
+		if parentDepth, ok := depths[step.Parent]; ok {
+			depth = parentDepth + 1
+		}
+		depths[step.ID] = depth
+		fmt.Fprintf(&b, "%s%s\n", strings.Repeat("  ", depth), step.Detail)
+	}
+	for _, suggestion := range p.Suggestions {
+		fmt.Fprintf(&b, "suggestion: %s\n", suggestion)
+	}
+	return b.String()
+}
+
+var (
+	sqlStringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlIdentifier    = regexp.MustCompile(`"((?:[^"]|"")+)"|[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+// ExplainQuery runs EXPLAIN QUERY PLAN for query, a select from tableName
+// filtered by where. When the table is scanned without an index, it
+// suggests indexes on the columns of indexable that where refers to, and
+// projecting fields where reads the data column.
+func ExplainQuery(q DBTX, tableName string, indexable []string, query, where string, args ...any) (QueryPlan, error) {
+	if q == nil {
+		return QueryPlan{}, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `EXPLAIN QUERY PLAN `+query, args...)
+	if err != nil {
+		return QueryPlan{}, fmt.Errorf("explain select from %s: %w", tableName, err)
+	}
+	plan := QueryPlan{Steps: make([]QueryPlanStep, 0)}
+	for rows.Next() {
+		var step QueryPlanStep
+		var unused int
+		if err := rows.Scan(&step.ID, &step.Parent, &unused, &step.Detail); err != nil {
+			if closeErr := CloseRows(rows, "query plan"); closeErr != nil {
+				return QueryPlan{}, fmt.Errorf("scan query plan of %s: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return QueryPlan{}, fmt.Errorf("scan query plan of %s: %w", tableName, err)
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "query plan"); closeErr != nil {
+			return QueryPlan{}, fmt.Errorf("iterate query plan of %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return QueryPlan{}, fmt.Errorf("iterate query plan of %s: %w", tableName, err)
+	}
+	if err := CloseRows(rows, "query plan"); err != nil {
+		return QueryPlan{}, err
+	}
+
+	for _, step := range plan.Steps {
+		detail := strings.TrimPrefix(strings.TrimPrefix(step.Detail, "SCAN "), "TABLE ")
+		if detail != step.Detail && (detail == tableName || strings.HasPrefix(detail, tableName+" ")) && !strings.Contains(detail, " USING ") {
+			plan.FullScan = true
+		}
+	}
+	if !plan.FullScan || strings.TrimSpace(where) == "" {
+		return plan, nil
+	}
+	readsData := false
+	for _, match := range sqlIdentifier.FindAllStringSubmatch(sqlStringLiteral.ReplaceAllString(where, "''"), -1) {
+		name := match[0]
+		if match[1] != "" {
+			name = strings.ReplaceAll(match[1], `""`, `"`)
+		}
+		switch {
+		case name == dataColumnName:
+			readsData = true
+		case name != "id" && slices.Contains(indexable, name):
+			suggestion := "consider adding index on " + name
+			if !slices.Contains(plan.Suggestions, suggestion) {
+				plan.Suggestions = append(plan.Suggestions, suggestion)
+			}
+		}
+	}
+	if readsData {
+		plan.Suggestions = append(plan.Suggestions, "where reads the data column; consider projecting the field with (proprdb.external) and indexing it")
+	}
+	return plan, nil
+}
@@ -0,0 +1,252 @@
+// Package export dumps generated proprdb tables to Parquet files, so
+// analytics engines such as DuckDB or Spark query proprdb data directly.
+//
+// Each table becomes one file with the columns id, at_ns, the projected
+// columns of its ProjectionSchema and data, the protojson encoding of the
+// row. Encrypted projections are left out; their values are only in data.
+// Map projections are part of data too. Soft-deleted rows are skipped.
+//
+// The writer is self-contained: pages are PLAIN encoded, optionally
+// compressed with gzip, one page per column chunk.
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	rt "github.com/fingon/proprdb/rt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// DefaultRowGroupRows is the row group size used when Options.RowGroupRows
+// is 0.
+const DefaultRowGroupRows = 65536
+
+// FileExtension is appended to the table name by WriteParquetFiles.
+const FileExtension = ".parquet"
+
+const (
+	softDeleteColumn  = "deleted_at_ns"
+	projectionIndex   = "idx:"
+	timestampKind     = "google.protobuf.Timestamp"
+	encryptedFlag     = "encrypted"
+	optionalFlag      = "optional"
+	rfc3339Flag       = "rfc3339"
+	columnFlag        = "column="
+	metadataTypeName  = "proprdb.type_name"
+	metadataSchema    = "proprdb.projection_schema"
+	metadataTableName = "proprdb.table_name"
+)
+
+// Table is a generated table to export.
+type Table struct {
+	// Schema is the generated XTableSchema of the table.
+	Schema rt.TableSchema
+	// Type decodes the data column, e.g. (&Person{}).ProtoReflect().Type().
+	Type protoreflect.MessageType
+}
+
+// Options configures WriteParquet.
+type Options struct {
+	// Data holds the Cipher and DataCodec the tables were written with.
+	Data rt.Options
+	// Gzip compresses the pages.
+	Gzip bool
+	// RowGroupRows caps the rows per row group.
+	RowGroupRows int
+}
+
+// WriteParquet writes the rows of table to out as a Parquet file, in id
+// order, and returns the number of rows written.
+func WriteParquet(q rt.DBTX, out io.Writer, table Table, opts Options) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	if table.Type == nil {
+		return 0, fmt.Errorf("table %s: nil message type", table.Schema.TableName)
+	}
+	projected, err := projectedColumns(table.Schema.ProjectionSchema)
+	if err != nil {
+		return 0, fmt.Errorf("table %s: %w", table.Schema.TableName, err)
+	}
+	columns := make([]*parquetColumn, 0, len(projected)+3)
+	columns = append(columns, &parquetColumn{name: "id", physical: parquetByteArray, converted: convertedUTF8, logical: logicalString})
+	columns = append(columns, &parquetColumn{name: "at_ns", physical: parquetInt64, converted: noConvertedType})
+	columns = append(columns, projected...)
+	columns = append(columns, &parquetColumn{name: "data", physical: parquetByteArray, converted: convertedUTF8, logical: logicalString})
+
+	writer, err := newParquetWriter(out, columns, opts.Gzip, [][2]string{
+		{metadataTableName, table.Schema.TableName},
+		{metadataTypeName, table.Schema.TypeName},
+		{metadataSchema, table.Schema.ProjectionSchema},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := writeRows(q, writer, table, projected, opts); err != nil {
+		return 0, fmt.Errorf("table %s: %w", table.Schema.TableName, err)
+	}
+	if err := writer.close(); err != nil {
+		return 0, err
+	}
+	return writer.rows, nil
+}
+
+// WriteParquetFiles writes each table to <dir>/<table name>.parquet.
+func WriteParquetFiles(q rt.DBTX, dir string, tables []Table, opts Options) error {
+	for _, table := range tables {
+		path := filepath.Join(dir, table.Schema.TableName+FileExtension)
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("create %s: %w", path, err)
+		}
+		if _, err := WriteParquet(q, file, table, opts); err != nil {
+			if closeErr := file.Close(); closeErr != nil {
+				return fmt.Errorf("%w (additionally, %v)", err, closeErr)
+			}
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("close %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func writeRows(q rt.DBTX, writer *parquetWriter, table Table, projected []*parquetColumn, opts Options) error {
+	rowGroupRows := int64(opts.RowGroupRows)
+	if rowGroupRows <= 0 {
+		rowGroupRows = DefaultRowGroupRows
+	}
+	selected := make([]string, 0, len(projected)+3)
+	selected = append(selected, "id", "at_ns", "data")
+	for _, column := range projected {
+		selected = append(selected, quoteIdentifier(column.name))
+	}
+	query := `SELECT ` + strings.Join(selected, ", ") + ` FROM ` + quoteIdentifier(table.Schema.TableName)
+	if slices.Contains(table.Schema.Columns, softDeleteColumn) {
+		query += ` WHERE ` + softDeleteColumn + ` IS NULL`
+	}
+	rows, err := q.QueryContext(context.Background(), query+` ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("select rows: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		var atNs int64
+		var stored []byte
+		values := make([]any, len(projected))
+		targets := []any{&id, &atNs, &stored}
+		for index := range values {
+			targets = append(targets, &values[index])
+		}
+		if err := rows.Scan(targets...); err != nil {
+			if closeErr := rt.CloseRows(rows, "export"); closeErr != nil {
+				return fmt.Errorf("scan row: %w (additionally, %v)", err, closeErr)
+			}
+			return fmt.Errorf("scan row: %w", err)
+		}
+		if err := appendRow(writer, table.Type, opts.Data, id, atNs, stored, values); err != nil {
+			if closeErr := rt.CloseRows(rows, "export"); closeErr != nil {
+				return fmt.Errorf("%w (additionally, %v)", err, closeErr)
+			}
+			return err
+		}
+		if writer.pending >= rowGroupRows {
+			if err := writer.flush(); err != nil {
+				if closeErr := rt.CloseRows(rows, "export"); closeErr != nil {
+					return fmt.Errorf("%w (additionally, %v)", err, closeErr)
+				}
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := rt.CloseRows(rows, "export"); closeErr != nil {
+			return fmt.Errorf("iterate rows: %w (additionally, %v)", err, closeErr)
+		}
+		return fmt.Errorf("iterate rows: %w", err)
+	}
+	return rt.CloseRows(rows, "export")
+}
+
+func appendRow(writer *parquetWriter, messageType protoreflect.MessageType, dataOpts rt.Options, id string, atNs int64, stored []byte, values []any) error {
+	message := messageType.New().Interface()
+	if err := rt.UnmarshalData(dataOpts, stored, message); err != nil {
+		return fmt.Errorf("unmarshal %s: %w", id, err)
+	}
+	dataJSON, err := protojson.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", id, err)
+	}
+	row := make([]any, 0, len(values)+3)
+	row = append(row, id, atNs)
+	row = append(row, values...)
+	row = append(row, string(dataJSON))
+	if err := writer.appendRow(row); err != nil {
+		return fmt.Errorf("row %s: %w", id, err)
+	}
+	return nil
+}
+
+// projectedColumns derives the Parquet columns of the projected columns
+// from a ProjectionSchema such as "name:string;address.zip:int32;idx:name".
+func projectedColumns(projectionSchema string) ([]*parquetColumn, error) {
+	columns := make([]*parquetColumn, 0)
+	for _, entry := range strings.Split(projectionSchema, ";") {
+		if entry == "" || strings.HasPrefix(entry, projectionIndex) {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid projection %q", entry)
+		}
+		flags := parts[2:]
+		if strings.HasPrefix(parts[1], "map<") || slices.Contains(flags, encryptedFlag) {
+			continue
+		}
+		column := &parquetColumn{
+			name:      strings.ReplaceAll(parts[0], ".", "_"),
+			optional:  slices.Contains(flags, optionalFlag),
+			converted: noConvertedType,
+		}
+		for _, flag := range flags {
+			if name, ok := strings.CutPrefix(flag, columnFlag); ok {
+				column.name = name
+			}
+		}
+		switch parts[1] {
+		case "bool":
+			column.physical = parquetBoolean
+		case "int32", "sint32", "sfixed32", "uint32", "fixed32", "int64", "sint64", "sfixed64", "uint64", "fixed64", "enum":
+			column.physical = parquetInt64
+		case "float", "double":
+			column.physical = parquetDouble
+		case "string":
+			column.physical, column.converted, column.logical = parquetByteArray, convertedUTF8, logicalString
+		case "bytes":
+			column.physical = parquetByteArray
+		case timestampKind:
+			if slices.Contains(flags, rfc3339Flag) {
+				column.physical, column.converted, column.logical = parquetByteArray, convertedUTF8, logicalString
+			} else {
+				column.physical, column.logical = parquetInt64, logicalTime
+			}
+		default:
+			return nil, fmt.Errorf("projection %q: unsupported kind %s", entry, parts[1])
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func quoteIdentifier(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
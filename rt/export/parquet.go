@@ -0,0 +1,381 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Parquet physical types.
+const (
+	parquetBoolean   int32 = 0
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Parquet encodings, converted types and codecs.
+const (
+	encodingPlain   int32 = 0
+	encodingRLE     int32 = 3
+	convertedUTF8   int32 = 0
+	repetitionReq   int32 = 0
+	repetitionOpt   int32 = 1
+	pageTypeData    int32 = 0
+	codecNone       int32 = 0
+	codecGzip       int32 = 2
+	parquetVersion  int32 = 1
+	parquetCreator        = "proprdb"
+	parquetMagic          = "PAR1"
+	maxParquetPage        = math.MaxInt32
+	logicalString   int16 = 1
+	logicalTime     int16 = 8
+	timeUnitNanos   int16 = 3
+	noConvertedType int32 = -1
+)
+
+// parquetColumn is a flat column of a Parquet file, buffering the values of
+// the current row group.
+type parquetColumn struct {
+	name      string
+	physical  int32
+	optional  bool
+	converted int32
+	// logical is the LogicalType union field, 0 for none.
+	logical int16
+
+	present []bool
+	bools   []bool
+	values  bytes.Buffer
+}
+
+// append adds value to the row group, nil for NULL.
+func (c *parquetColumn) append(value any) error {
+	if value == nil {
+		if !c.optional {
+			return fmt.Errorf("column %s: NULL in a required column", c.name)
+		}
+		c.present = append(c.present, false)
+		return nil
+	}
+	c.present = append(c.present, true)
+	switch c.physical {
+	case parquetBoolean:
+		switch value := value.(type) {
+		case bool:
+			c.bools = append(c.bools, value)
+		case int64:
+			c.bools = append(c.bools, value != 0)
+		default:
+			return fmt.Errorf("column %s: unexpected %T for BOOLEAN", c.name, value)
+		}
+	case parquetInt64:
+		integer, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("column %s: unexpected %T for INT64", c.name, value)
+		}
+		c.values.Write(binary.LittleEndian.AppendUint64(nil, uint64(integer)))
+	case parquetDouble:
+		var number float64
+		switch value := value.(type) {
+		case float64:
+			number = value
+		case int64:
+			number = float64(value)
+		default:
+			return fmt.Errorf("column %s: unexpected %T for DOUBLE", c.name, value)
+		}
+		c.values.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(number)))
+	case parquetByteArray:
+		var raw []byte
+		switch value := value.(type) {
+		case string:
+			raw = []byte(value)
+		case []byte:
+			raw = value
+		default:
+			return fmt.Errorf("column %s: unexpected %T for BYTE_ARRAY", c.name, value)
+		}
+		c.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(raw))))
+		c.values.Write(raw)
+	}
+	return nil
+}
+
+// page returns the body of a data page holding the buffered values:
+// definition levels for optional columns, then the PLAIN values.
+func (c *parquetColumn) page() []byte {
+	body := make([]byte, 0, c.values.Len()+len(c.bools)/8+16)
+	if c.optional {
+		levels := encodeDefinitionLevels(c.present)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(levels)))
+		body = append(body, levels...)
+	}
+	if c.physical == parquetBoolean {
+		packed := make([]byte, (len(c.bools)+7)/8)
+		for index, value := range c.bools {
+			if value {
+				packed[index/8] |= 1 << (index % 8)
+			}
+		}
+		return append(body, packed...)
+	}
+	return append(body, c.values.Bytes()...)
+}
+
+func (c *parquetColumn) reset() {
+	c.present = c.present[:0]
+	c.bools = c.bools[:0]
+	c.values.Reset()
+}
+
+// encodeDefinitionLevels encodes 1-bit definition levels with the RLE runs
+// of the RLE/bit-packing hybrid encoding.
+func encodeDefinitionLevels(present []bool) []byte {
+	encoded := make([]byte, 0, 8)
+	for start := 0; start < len(present); {
+		end := start + 1
+		for end < len(present) && present[end] == present[start] {
+			end++
+		}
+		encoded = binary.AppendUvarint(encoded, uint64(end-start)<<1)
+		if present[start] {
+			encoded = append(encoded, 1)
+		} else {
+			encoded = append(encoded, 0)
+		}
+		start = end
+	}
+	return encoded
+}
+
+// columnChunk is the metadata of a written column chunk.
+type columnChunk struct {
+	offset            int64
+	numValues         int64
+	uncompressedBytes int64
+	compressedBytes   int64
+}
+
+type rowGroup struct {
+	chunks []columnChunk
+	rows   int64
+}
+
+// parquetWriter writes a Parquet file of flat columns, one data page per
+// column chunk.
+type parquetWriter struct {
+	out      io.Writer
+	offset   int64
+	gzip     bool
+	columns  []*parquetColumn
+	metadata [][2]string
+	groups   []rowGroup
+	rows     int64
+	pending  int64
+}
+
+func newParquetWriter(out io.Writer, columns []*parquetColumn, gzip bool, metadata [][2]string) (*parquetWriter, error) {
+	w := &parquetWriter{out: out, gzip: gzip, columns: columns, metadata: metadata}
+	if err := w.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *parquetWriter) write(data []byte) error {
+	written, err := w.out.Write(data)
+	w.offset += int64(written)
+	if err != nil {
+		return fmt.Errorf("write parquet: %w", err)
+	}
+	return nil
+}
+
+// appendRow adds one value per column.
+func (w *parquetWriter) appendRow(values []any) error {
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("row has %d values for %d columns", len(values), len(w.columns))
+	}
+	for index, value := range values {
+		if err := w.columns[index].append(value); err != nil {
+			return err
+		}
+	}
+	w.pending++
+	return nil
+}
+
+// flush writes the buffered rows as a row group.
+func (w *parquetWriter) flush() error {
+	if w.pending == 0 {
+		return nil
+	}
+	group := rowGroup{chunks: make([]columnChunk, 0, len(w.columns)), rows: w.pending}
+	for _, column := range w.columns {
+		chunk, err := w.writeChunk(column)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", column.name, err)
+		}
+		group.chunks = append(group.chunks, chunk)
+		column.reset()
+	}
+	w.groups = append(w.groups, group)
+	w.rows += w.pending
+	w.pending = 0
+	return nil
+}
+
+func (w *parquetWriter) writeChunk(column *parquetColumn) (columnChunk, error) {
+	body := column.page()
+	stored := body
+	if w.gzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(body); err != nil {
+			return columnChunk{}, fmt.Errorf("gzip page: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return columnChunk{}, fmt.Errorf("close gzip page: %w", err)
+		}
+		stored = compressed.Bytes()
+	}
+	if len(body) > maxParquetPage || len(stored) > maxParquetPage {
+		return columnChunk{}, errors.New("page exceeds 2 GiB, lower RowGroupRows")
+	}
+	header := &compactWriter{}
+	header.begin()
+	header.i32Field(1, pageTypeData)
+	header.i32Field(2, int32(len(body)))
+	header.i32Field(3, int32(len(stored)))
+	header.structField(5)
+	header.i32Field(1, int32(w.pending))
+	header.i32Field(2, encodingPlain)
+	header.i32Field(3, encodingRLE)
+	header.i32Field(4, encodingRLE)
+	header.end()
+	header.end()
+
+	chunk := columnChunk{
+		offset:            w.offset,
+		numValues:         w.pending,
+		uncompressedBytes: int64(len(header.buf) + len(body)),
+		compressedBytes:   int64(len(header.buf) + len(stored)),
+	}
+	if err := w.write(header.buf); err != nil {
+		return columnChunk{}, err
+	}
+	if err := w.write(stored); err != nil {
+		return columnChunk{}, err
+	}
+	return chunk, nil
+}
+
+// close flushes the last row group and writes the footer.
+func (w *parquetWriter) close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	footer := w.fileMetaData()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+	return w.write(footer)
+}
+
+// fileMetaData encodes the FileMetaData struct of the file.
+func (w *parquetWriter) fileMetaData() []byte {
+	codec := codecNone
+	if w.gzip {
+		codec = codecGzip
+	}
+	meta := &compactWriter{}
+	meta.begin()
+	meta.i32Field(1, parquetVersion)
+
+	meta.listField(2, compactStruct, len(w.columns)+1)
+	meta.begin()
+	meta.stringField(4, "schema")
+	meta.i32Field(5, int32(len(w.columns)))
+	meta.end()
+	for _, column := range w.columns {
+		meta.begin()
+		meta.i32Field(1, column.physical)
+		repetition := repetitionReq
+		if column.optional {
+			repetition = repetitionOpt
+		}
+		meta.i32Field(3, repetition)
+		meta.stringField(4, column.name)
+		if column.converted != noConvertedType {
+			meta.i32Field(6, column.converted)
+		}
+		switch column.logical {
+		case logicalString:
+			meta.structField(10)
+			meta.structField(logicalString)
+			meta.end()
+			meta.end()
+		case logicalTime:
+			meta.structField(10)
+			meta.structField(logicalTime)
+			meta.boolField(1, true)
+			meta.structField(2)
+			meta.structField(timeUnitNanos)
+			meta.end()
+			meta.end()
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+
+	meta.i64Field(3, w.rows)
+
+	meta.listField(4, compactStruct, len(w.groups))
+	for _, group := range w.groups {
+		var uncompressed, compressed int64
+		meta.begin()
+		meta.listField(1, compactStruct, len(group.chunks))
+		for index, chunk := range group.chunks {
+			column := w.columns[index]
+			uncompressed += chunk.uncompressedBytes
+			compressed += chunk.compressedBytes
+			meta.begin()
+			meta.i64Field(2, chunk.offset)
+			meta.structField(3)
+			meta.i32Field(1, column.physical)
+			meta.listField(2, compactI32, 2)
+			meta.i32(encodingPlain)
+			meta.i32(encodingRLE)
+			meta.listField(3, compactBinary, 1)
+			meta.string(column.name)
+			meta.i32Field(4, codec)
+			meta.i64Field(5, chunk.numValues)
+			meta.i64Field(6, chunk.uncompressedBytes)
+			meta.i64Field(7, chunk.compressedBytes)
+			meta.i64Field(9, chunk.offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64Field(2, uncompressed)
+		meta.i64Field(3, group.rows)
+		meta.i64Field(5, group.chunks[0].offset)
+		meta.i64Field(6, compressed)
+		meta.end()
+	}
+
+	meta.listField(5, compactStruct, len(w.metadata))
+	for _, pair := range w.metadata {
+		meta.begin()
+		meta.stringField(1, pair[0])
+		meta.stringField(2, pair[1])
+		meta.end()
+	}
+	meta.stringField(6, parquetCreator)
+	meta.end()
+	return meta.buf
+}
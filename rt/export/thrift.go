@@ -0,0 +1,94 @@
+package export
+
+import (
+	"encoding/binary"
+)
+
+// Thrift compact protocol type ids, as used by the Parquet metadata.
+const (
+	compactTrue   byte = 1
+	compactFalse  byte = 2
+	compactI32    byte = 5
+	compactI64    byte = 6
+	compactBinary byte = 8
+	compactList   byte = 9
+	compactStruct byte = 12
+)
+
+// compactWriter encodes Thrift structs in the compact protocol. Structs are
+// opened with begin or structField and closed with end.
+type compactWriter struct {
+	buf []byte
+	// last holds the id of the last field written to each open struct, as
+	// field ids are delta encoded.
+	last []int16
+}
+
+func (w *compactWriter) begin() {
+	w.last = append(w.last, 0)
+}
+
+func (w *compactWriter) end() {
+	w.buf = append(w.buf, 0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *compactWriter) field(id int16, kind byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|kind)
+	} else {
+		w.buf = append(w.buf, kind)
+		w.buf = binary.AppendVarint(w.buf, int64(id))
+	}
+	*last = id
+}
+
+func (w *compactWriter) boolField(id int16, value bool) {
+	if value {
+		w.field(id, compactTrue)
+	} else {
+		w.field(id, compactFalse)
+	}
+}
+
+func (w *compactWriter) i32Field(id int16, value int32) {
+	w.field(id, compactI32)
+	w.i32(value)
+}
+
+func (w *compactWriter) i64Field(id int16, value int64) {
+	w.field(id, compactI64)
+	w.buf = binary.AppendVarint(w.buf, value)
+}
+
+func (w *compactWriter) stringField(id int16, value string) {
+	w.field(id, compactBinary)
+	w.string(value)
+}
+
+func (w *compactWriter) structField(id int16) {
+	w.field(id, compactStruct)
+	w.begin()
+}
+
+// listField starts a list of size elements, which follow as i32, string or
+// begin/end struct values.
+func (w *compactWriter) listField(id int16, elementKind byte, size int) {
+	w.field(id, compactList)
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elementKind)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elementKind)
+	w.buf = binary.AppendUvarint(w.buf, uint64(size))
+}
+
+func (w *compactWriter) i32(value int32) {
+	w.buf = binary.AppendVarint(w.buf, int64(value))
+}
+
+func (w *compactWriter) string(value string) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(value)))
+	w.buf = append(w.buf, value...)
+}
@@ -0,0 +1,157 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CheckRemotes fails for remotes listed more than once.
+func CheckRemotes(remotes []string) error {
+	seen := make(map[string]bool, len(remotes))
+	for _, remote := range remotes {
+		if seen[remote] {
+			return fmt.Errorf("duplicate remote %q", remote)
+		}
+		seen[remote] = true
+	}
+	return nil
+}
+
+// SyncScan is one Select of a table serving all remotes that export its type
+// with the same condition.
+type SyncScan struct {
+	Where   string
+	Remotes []string
+}
+
+// SyncScans groups remotes by their SyncSelection of typeName, leaving out
+// remotes that do not export it. Scans are ordered by their first remote.
+func SyncScans(policy SyncPolicy, remotes []string, typeName string, generatedWhere map[string]string) []SyncScan {
+	scans := make([]SyncScan, 0, 1)
+	for _, remote := range remotes {
+		where, included := SyncSelection(policy, remote, typeName, generatedWhere)
+		if !included {
+			continue
+		}
+		found := false
+		for i := range scans {
+			if scans[i].Where == where {
+				scans[i].Remotes = append(scans[i].Remotes, remote)
+				found = true
+				break
+			}
+		}
+		if !found {
+			scans = append(scans, SyncScan{Where: where, Remotes: []string{remote}})
+		}
+	}
+	return scans
+}
+
+// WhereChangedAfter adds the condition that the change feed recorded a
+// change of the row in tableName after afterSeq to where, unless afterSeq is
+// negative, and returns its arguments.
+func WhereChangedAfter(where, tableName string, afterSeq int64) (string, []any) {
+	if afterSeq < 0 {
+		return where, nil
+	}
+	changed := "id IN (SELECT id FROM " + CoreTableChangesName + " WHERE table_name = ? AND seq > ?)"
+	if strings.TrimSpace(where) == "" {
+		return changed, []any{tableName, afterSeq}
+	}
+	return "(" + where + ") AND " + changed, []any{tableName, afterSeq}
+}
+
+// SyncedAtNs holds the _sync at_ns of one table by remote and object id.
+type SyncedAtNs map[string]map[string]int64
+
+// LoadSyncedAtNs reads the _sync rows of tableName for remotes with one
+// query.
+func LoadSyncedAtNs(q DBTX, tableName string, remotes []string) (SyncedAtNs, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	synced := make(SyncedAtNs, len(remotes))
+	args := []any{tableName}
+	for _, remote := range remotes {
+		if remote == "" {
+			continue
+		}
+		if _, ok := synced[remote]; !ok {
+			synced[remote] = make(map[string]int64)
+			args = append(args, remote)
+		}
+	}
+	if len(synced) == 0 {
+		return synced, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(synced)), ", ")
+	selectSQL := `SELECT remote, object_id, at_ns FROM ` + CoreTableSyncName + ` WHERE table_name = ? AND remote IN (` + placeholders + `)`
+	rows, err := q.QueryContext(context.Background(), selectSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select sync rows for %s: %w", tableName, err)
+	}
+	for rows.Next() {
+		var remote, objectID string
+		var atNs int64
+		if err := rows.Scan(&remote, &objectID, &atNs); err != nil {
+			if closeErr := CloseRows(rows, "sync"); closeErr != nil {
+				return nil, fmt.Errorf("scan sync row for %s: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("scan sync row for %s: %w", tableName, err)
+		}
+		synced[remote][objectID] = atNs
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "sync"); closeErr != nil {
+			return nil, fmt.Errorf("iterate sync rows for %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate sync rows for %s: %w", tableName, err)
+	}
+	if err := CloseRows(rows, "sync"); err != nil {
+		return nil, err
+	}
+	return synced, nil
+}
+
+// NeedsSend is SyncNeedsSend against the loaded rows.
+func (s SyncedAtNs) NeedsSend(remote, objectID string, atNs int64) bool {
+	if remote == "" {
+		return true
+	}
+	syncedAtNs, ok := s[remote][objectID]
+	return !ok || syncedAtNs < atNs
+}
+
+// WriteJSONLFanOut writes pending[remote] to a writer from open for each of
+// remotes, as WriteJSONLChunksWithOptions with a chunk size of one, and
+// closes it. It stops at the first error; remotes written before it stay
+// acknowledged.
+func WriteJSONLFanOut(q DBTX, remotes []string, pending map[string][]PendingJSONLRecord, open func(remote string) (io.WriteCloser, error), opts JSONLOptions, instrumentation Instrumentation) error {
+	if open == nil {
+		return errors.New("nil open")
+	}
+	for _, remote := range remotes {
+		w, err := open(remote)
+		if err != nil {
+			return fmt.Errorf("open jsonl writer for remote %q: %w", remote, err)
+		}
+		if w == nil {
+			return fmt.Errorf("open jsonl writer for remote %q: nil writer", remote)
+		}
+		err = WriteJSONLChunksWithOptions(q, remote, w, pending[remote], 1, opts, CountSyncLinesWritten(instrumentation, nil))
+		closeErr := w.Close()
+		switch {
+		case err != nil && closeErr != nil:
+			return fmt.Errorf("write jsonl for remote %q: %w (additionally, close: %v)", remote, err, closeErr)
+		case err != nil:
+			return fmt.Errorf("write jsonl for remote %q: %w", remote, err)
+		case closeErr != nil:
+			return fmt.Errorf("close jsonl writer for remote %q: %w", remote, closeErr)
+		}
+	}
+	return nil
+}
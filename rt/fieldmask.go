@@ -0,0 +1,92 @@
+package proprdbrt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// ErrInvalidFieldMask is wrapped by ApplyFieldMask for masks that are empty
+// or name fields the message does not have.
+var ErrInvalidFieldMask = errors.New("invalid field mask")
+
+// ApplyFieldMask replaces the fields of dst named by the paths of mask with
+// those of patch, a message of the same type. A field unset in patch is
+// cleared in dst, and message, repeated and map fields are replaced as a
+// whole. Paths may descend into singular message fields, as in
+// "address.city". The path "*" replaces every field.
+func ApplyFieldMask(dst, patch proto.Message, mask *fieldmaskpb.FieldMask) error {
+	if dst == nil || patch == nil {
+		return errors.New("nil message")
+	}
+	dstMessage := dst.ProtoReflect()
+	if dstMessage.Descriptor().FullName() != patch.ProtoReflect().Descriptor().FullName() {
+		return fmt.Errorf("patch %s does not match %s", patch.ProtoReflect().Descriptor().FullName(), dstMessage.Descriptor().FullName())
+	}
+	if len(mask.GetPaths()) == 0 {
+		return fmt.Errorf("%w: no paths", ErrInvalidFieldMask)
+	}
+	if len(mask.GetPaths()) == 1 && mask.GetPaths()[0] == "*" {
+		proto.Reset(dst)
+		proto.Merge(dst, patch)
+		return nil
+	}
+	// dst only takes values from this copy, so it shares no memory with the
+	// patch of the caller.
+	source := proto.Clone(patch).ProtoReflect()
+	for _, path := range mask.GetPaths() {
+		if err := applyFieldMaskPath(dstMessage, source, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyFieldMaskPath(dst, source protoreflect.Message, path string) error {
+	segments := strings.Split(path, ".")
+	for index, segment := range segments {
+		field := dst.Descriptor().Fields().ByName(protoreflect.Name(segment))
+		if field == nil {
+			return fmt.Errorf("%w: %s has no field %q in %q", ErrInvalidFieldMask, dst.Descriptor().FullName(), segment, path)
+		}
+		if index == len(segments)-1 {
+			if source.Has(field) {
+				dst.Set(field, source.Get(field))
+			} else {
+				dst.Clear(field)
+			}
+			return nil
+		}
+		if field.Message() == nil || field.IsList() || field.IsMap() {
+			return fmt.Errorf("%w: %q descends into %s, which is not a singular message", ErrInvalidFieldMask, path, field.Name())
+		}
+		if !source.Has(field) && !dst.Has(field) {
+			// Clearing a field of an absent message leaves nothing to do.
+			return checkFieldMaskPath(field.Message(), segments[index+1:], path)
+		}
+		source = source.Get(field).Message()
+		dst = dst.Mutable(field).Message()
+	}
+	return nil
+}
+
+// checkFieldMaskPath checks the rest of a path that has nothing to apply.
+func checkFieldMaskPath(message protoreflect.MessageDescriptor, segments []string, path string) error {
+	for index, segment := range segments {
+		field := message.Fields().ByName(protoreflect.Name(segment))
+		switch {
+		case field == nil:
+			return fmt.Errorf("%w: %s has no field %q in %q", ErrInvalidFieldMask, message.FullName(), segment, path)
+		case index == len(segments)-1:
+			return nil
+		case field.Message() == nil || field.IsList() || field.IsMap():
+			return fmt.Errorf("%w: %q descends into %s, which is not a singular message", ErrInvalidFieldMask, path, field.Name())
+		}
+		message = field.Message()
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package proprdbrt
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrUnsupportedWhere is returned for where clauses a Filter cannot
+// evaluate.
+var ErrUnsupportedWhere = errors.New("unsupported where clause")
+
+// Filter is a where clause evaluated in Go: comparisons of a column with a ?
+// placeholder, IN lists and IS [NOT] NULL, joined by AND.
+type Filter struct {
+	conditions []condition
+}
+
+// condition is one AND term of a where clause.
+type condition struct {
+	column   string
+	operator string
+	args     []any
+}
+
+func (c condition) match(values map[string]any) (bool, error) {
+	value, ok := values[c.column]
+	if !ok {
+		return false, fmt.Errorf("unknown column %q: %w", c.column, ErrUnsupportedWhere)
+	}
+	switch c.operator {
+	case "IS NULL":
+		return value == nil, nil
+	case "IS NOT NULL":
+		return value != nil, nil
+	case "IN":
+		for _, arg := range c.args {
+			if value != nil && arg != nil && CompareValues(value, arg) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	// As in SQL, comparisons with NULL are never true.
+	if value == nil || c.args[0] == nil {
+		return false, nil
+	}
+	result := CompareValues(value, c.args[0])
+	switch c.operator {
+	case "=", "==":
+		return result == 0, nil
+	case "!=", "<>":
+		return result != 0, nil
+	case "<":
+		return result < 0, nil
+	case "<=":
+		return result <= 0, nil
+	case ">":
+		return result > 0, nil
+	default:
+		return result >= 0, nil
+	}
+}
+
+var comparisonOperators = []string{"<=", ">=", "!=", "<>", "==", "=", "<", ">"}
+
+// ParseFilter parses where, a conjunction of simple conditions, binding the
+// ? placeholders to args in order.
+func ParseFilter(where string, args []any) (Filter, error) {
+	where = strings.TrimSpace(where)
+	if where == "" {
+		if len(args) > 0 {
+			return Filter{}, fmt.Errorf("%d args without placeholders", len(args))
+		}
+		return Filter{}, nil
+	}
+	conditions := make([]condition, 0)
+	for _, term := range splitAnd(where) {
+		parsed, err := parseTerm(strings.TrimSpace(term))
+		if err != nil {
+			return Filter{}, err
+		}
+		placeholders := len(parsed.args)
+		if placeholders > len(args) {
+			return Filter{}, fmt.Errorf("too few args for %q", where)
+		}
+		copy(parsed.args, args[:placeholders])
+		args = args[placeholders:]
+		conditions = append(conditions, parsed)
+	}
+	if len(args) > 0 {
+		return Filter{}, fmt.Errorf("too many args for %q", where)
+	}
+	return Filter{conditions: conditions}, nil
+}
+
+// Columns returns the columns f refers to, in order of first use.
+func (f Filter) Columns() []string {
+	columns := make([]string, 0, len(f.conditions))
+	for _, condition := range f.conditions {
+		if !slices.Contains(columns, condition.column) {
+			columns = append(columns, condition.column)
+		}
+	}
+	return columns
+}
+
+// Match reports whether values, by column, satisfy every condition of f.
+func (f Filter) Match(values map[string]any) (bool, error) {
+	for _, condition := range f.conditions {
+		ok, err := condition.match(values)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func splitAnd(where string) []string {
+	terms := make([]string, 0)
+	upper := strings.ToUpper(where)
+	for {
+		index := strings.Index(upper, " AND ")
+		if index < 0 {
+			return append(terms, where)
+		}
+		terms = append(terms, where[:index])
+		where, upper = where[index+len(" AND "):], upper[index+len(" AND "):]
+	}
+}
+
+// parseTerm parses one term, leaving a nil arg per placeholder.
+func parseTerm(term string) (condition, error) {
+	upper := strings.ToUpper(term)
+	switch {
+	case strings.HasSuffix(upper, " IS NOT NULL"):
+		return condition{column: columnName(term[:len(term)-len(" IS NOT NULL")]), operator: "IS NOT NULL"}, nil
+	case strings.HasSuffix(upper, " IS NULL"):
+		return condition{column: columnName(term[:len(term)-len(" IS NULL")]), operator: "IS NULL"}, nil
+	}
+	if index := strings.Index(upper, " IN "); index >= 0 {
+		list := strings.TrimSpace(term[index+len(" IN "):])
+		if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+			return condition{}, fmt.Errorf("%q: %w", term, ErrUnsupportedWhere)
+		}
+		placeholders := strings.Split(list[1:len(list)-1], ",")
+		for _, placeholder := range placeholders {
+			if strings.TrimSpace(placeholder) != "?" {
+				return condition{}, fmt.Errorf("%q: %w", term, ErrUnsupportedWhere)
+			}
+		}
+		return condition{column: columnName(term[:index]), operator: "IN", args: make([]any, len(placeholders))}, nil
+	}
+	for _, operator := range comparisonOperators {
+		left, right, ok := strings.Cut(term, operator)
+		if !ok {
+			continue
+		}
+		if strings.TrimSpace(right) != "?" {
+			return condition{}, fmt.Errorf("%q: %w", term, ErrUnsupportedWhere)
+		}
+		return condition{column: columnName(left), operator: operator, args: make([]any, 1)}, nil
+	}
+	return condition{}, fmt.Errorf("%q: %w", term, ErrUnsupportedWhere)
+}
+
+func columnName(text string) string {
+	return strings.Trim(strings.TrimSpace(text), `"`)
+}
+
+// CompareValues orders values the way SQLite does for the types projected
+// columns hold: NULL before numbers before text.
+func CompareValues(a, b any) int {
+	aInteger, aIsInteger := integer(a)
+	bInteger, bIsInteger := integer(b)
+	aNumber, aIsNumber := number(a)
+	bNumber, bIsNumber := number(b)
+	switch {
+	case a == nil || b == nil:
+		return cmp.Compare(boolRank(a != nil), boolRank(b != nil))
+	case aIsInteger && bIsInteger:
+		// Compared exactly, as float64 loses precision of at_ns values.
+		return cmp.Compare(aInteger, bInteger)
+	case aIsNumber && bIsNumber:
+		return cmp.Compare(aNumber, bNumber)
+	case aIsNumber:
+		return -1
+	case bIsNumber:
+		return 1
+	}
+	return cmp.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func integer(value any) (int64, bool) {
+	switch typed := value.(type) {
+	case int:
+		return int64(typed), true
+	case int32:
+		return int64(typed), true
+	case int64:
+		return typed, true
+	case uint32:
+		return int64(typed), true
+	case uint64:
+		return int64(typed), true
+	case bool:
+		return int64(boolRank(typed)), true
+	}
+	return 0, false
+}
+
+func number(value any) (float64, bool) {
+	if integerValue, ok := integer(value); ok {
+		return float64(integerValue), true
+	}
+	switch typed := value.(type) {
+	case float32:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	}
+	return 0, false
+}
+
+// ParseFieldFilter parses where like ParseFilter, with protobuf field paths
+// of descriptor such as "address.zip" as columns, for MatchMessage.
+func ParseFieldFilter(descriptor protoreflect.MessageDescriptor, where string, args []any) (Filter, error) {
+	filter, err := ParseFilter(where, args)
+	if err != nil {
+		return Filter{}, err
+	}
+	for _, path := range filter.Columns() {
+		if err := checkScalarPath(descriptor, path); err != nil {
+			return Filter{}, fmt.Errorf("%q: %w: %v", where, ErrUnsupportedWhere, err)
+		}
+	}
+	return filter, nil
+}
+
+// MatchMessage reports whether the fields of message satisfy f, a filter
+// from ParseFieldFilter.
+func (f Filter) MatchMessage(message proto.Message) (bool, error) {
+	values := make(map[string]any, len(f.conditions))
+	for _, path := range f.Columns() {
+		values[path] = PathValue(message, path)
+	}
+	return f.Match(values)
+}
+
+// RecordFieldScan logs and counts towards MetricFieldScans a select of
+// tableName by fields that are not projected, which decodes every row.
+func RecordFieldScan(instrumentation Instrumentation, tableName, where string) {
+	slog.Warn("selecting by fields decodes every row; project them with (proprdb.external) to query in SQL", "table", tableName, "where", where)
+	if instrumentation != nil {
+		instrumentation.AddInt64(context.Background(), MetricFieldScans, 1, Attribute{Key: AttributeTable, Value: tableName})
+	}
+}
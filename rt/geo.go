@@ -0,0 +1,140 @@
+package proprdbrt
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// EarthRadiusMeters is the mean Earth radius used for geo distances.
+const EarthRadiusMeters = 6371008.8
+
+// GeoBounds is a latitude/longitude box in degrees. A box with MinLng
+// greater than MaxLng crosses the antimeridian.
+type GeoBounds struct {
+	MinLat, MinLng float64
+	MaxLat, MaxLng float64
+}
+
+// GeoBoundsAround returns the smallest box containing every point within
+// radiusMeters of (lat, lng). Near the poles it spans all longitudes.
+func GeoBoundsAround(lat, lng, radiusMeters float64) GeoBounds {
+	deltaLat := radiusMeters / EarthRadiusMeters * 180 / math.Pi
+	bounds := GeoBounds{MinLat: lat - deltaLat, MaxLat: lat + deltaLat, MinLng: -180, MaxLng: 180}
+	if bounds.MinLat <= -90 || bounds.MaxLat >= 90 {
+		bounds.MinLat, bounds.MaxLat = max(bounds.MinLat, -90), min(bounds.MaxLat, 90)
+		return bounds
+	}
+	deltaLng := math.Asin(math.Sin(radiusMeters/EarthRadiusMeters)/math.Cos(lat*math.Pi/180)) * 180 / math.Pi
+	if radiusMeters/EarthRadiusMeters >= math.Pi/2 || deltaLng >= 180 {
+		return bounds
+	}
+	bounds.MinLng, bounds.MaxLng = normalizeLongitude(lng-deltaLng), normalizeLongitude(lng+deltaLng)
+	return bounds
+}
+
+func normalizeLongitude(lng float64) float64 {
+	switch {
+	case lng < -180:
+		return lng + 360
+	case lng > 180:
+		return lng - 360
+	}
+	return lng
+}
+
+// Where returns a Select where clause matching rows whose latColumn and
+// lngColumn are inside b, and its arguments.
+func (b GeoBounds) Where(latColumn, lngColumn string) (string, []any) {
+	lat, lng := quoteSQLiteIdentifier(latColumn), quoteSQLiteIdentifier(lngColumn)
+	where := lat + ` BETWEEN ? AND ?`
+	args := []any{b.MinLat, b.MaxLat}
+	if b.MinLng <= b.MaxLng {
+		return where + ` AND ` + lng + ` BETWEEN ? AND ?`, append(args, b.MinLng, b.MaxLng)
+	}
+	return where + ` AND (` + lng + ` >= ? OR ` + lng + ` <= ?)`, append(args, b.MinLng, b.MaxLng)
+}
+
+// GeoDistanceMeters returns the great-circle distance between two points
+// with the haversine formula.
+func GeoDistanceMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	toRadians := math.Pi / 180
+	deltaLat := (lat2 - lat1) * toRadians
+	deltaLng := (lng2 - lng1) * toRadians
+	h := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1*toRadians)*math.Cos(lat2*toRadians)*math.Sin(deltaLng/2)*math.Sin(deltaLng/2)
+	return 2 * EarthRadiusMeters * math.Asin(math.Sqrt(min(h, 1)))
+}
+
+// GeoHit is one result of GeoNear.
+type GeoHit struct {
+	ID             string
+	DistanceMeters float64
+}
+
+// GeoNear returns the rows of tableName within radiusMeters of (lat, lng),
+// nearest first. The bounding box of the circle is matched in SQL, where an
+// index on (latColumn, lngColumn) applies, and the exact distance in Go.
+func GeoNear(q DBTX, tableName, latColumn, lngColumn string, lat, lng, radiusMeters float64) ([]GeoHit, error) {
+	switch {
+	case q == nil:
+		return nil, errors.New("nil DBTX")
+	case radiusMeters < 0 || math.IsNaN(radiusMeters):
+		return nil, fmt.Errorf("invalid radius %v", radiusMeters)
+	}
+	where, args := GeoBoundsAround(lat, lng, radiusMeters).Where(latColumn, lngColumn)
+	query := `SELECT id, ` + quoteSQLiteIdentifier(latColumn) + `, ` + quoteSQLiteIdentifier(lngColumn) + ` FROM ` + quoteSQLiteIdentifier(tableName) + ` WHERE ` + where
+	rows, err := q.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("geo near in %s: %w", tableName, err)
+	}
+	hits := make([]GeoHit, 0)
+	for rows.Next() {
+		var id string
+		var rowLat, rowLng float64
+		if err := rows.Scan(&id, &rowLat, &rowLng); err != nil {
+			if closeErr := CloseRows(rows, "geo near"); closeErr != nil {
+				return nil, fmt.Errorf("scan geo row: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan geo row: %w", err)
+		}
+		if distance := GeoDistanceMeters(lat, lng, rowLat, rowLng); distance <= radiusMeters {
+			hits = append(hits, GeoHit{ID: id, DistanceMeters: distance})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "geo near"); closeErr != nil {
+			return nil, fmt.Errorf("geo near in %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return nil, fmt.Errorf("geo near in %s: %w", tableName, err)
+	}
+	if err := CloseRows(rows, "geo near"); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(hits, func(a, b GeoHit) int {
+		return cmp.Or(cmp.Compare(a.DistanceMeters, b.DistanceMeters), strings.Compare(a.ID, b.ID))
+	})
+	return hits, nil
+}
+
+// SortByIDs orders rows like ids, e.g. the rows a Select returned for
+// ranked ids. Rows whose id is not in ids go last.
+func SortByIDs[R any](rows []R, ids []string, id func(R) string) {
+	positions := make(map[string]int, len(ids))
+	for position, value := range ids {
+		positions[value] = position
+	}
+	position := func(row R) int {
+		if value, ok := positions[id(row)]; ok {
+			return value
+		}
+		return len(ids)
+	}
+	slices.SortStableFunc(rows, func(a, b R) int {
+		return cmp.Compare(position(a), position(b))
+	})
+}
@@ -0,0 +1,776 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// GraphQLRequest is a GraphQL request as POSTed in JSON.
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// GraphQLError is an error of a GraphQL response. Path names the root field
+// it belongs to.
+type GraphQLError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// GraphQLResponse is the result of a GraphQL request. Data is nil when the
+// request did not parse.
+type GraphQLResponse struct {
+	Data   any            `json:"data,omitempty"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+type graphQLRootKind int
+
+const (
+	graphQLGet graphQLRootKind = iota
+	graphQLList
+	graphQLCreate
+	graphQLReplace
+	graphQLDelete
+	graphQLChanges
+)
+
+// graphQLRoot is a field of Query, Mutation or Subscription.
+type graphQLRoot struct {
+	kind     graphQLRootKind
+	resource HTTPResource
+	message  protoreflect.MessageDescriptor
+}
+
+// GraphQL serves the tables of HTTPResources over GraphQL. For a message
+// Person it provides:
+//
+//	query    { person(id: ID!): PersonRow
+//	           personList(<column>: [<type>!], ...): [PersonRow!] }
+//	mutation { createPerson(data: PersonInput!): PersonRow
+//	           replacePerson(id: ID!, data: PersonInput!): PersonRow
+//	           deletePerson(id: ID!): Boolean }
+//	subscription { personChanges(id: ID, since: Int64): PersonChange! }
+//
+// where PersonRow has id, atNs and data, and PersonChange the seq, op, id
+// and atNs of a change feed entry and the row as it is when the change is
+// sent. List arguments filter projected columns like the REST ?column=value
+// parameters. Message fields follow the protojson mapping. Queries may
+// introspect the schema with __schema and __type. Fragments and directives
+// are not supported.
+type GraphQL struct {
+	// Changes, when set, serves subscriptions from its change feed; see
+	// Options.ChangeFeed. Subscriptions fail without it.
+	Changes DBTX
+	// PollInterval is how often subscriptions read the change feed once
+	// they caught up, DefaultGraphQLPollInterval when 0.
+	PollInterval time.Duration
+
+	pkg           protoreflect.FullName
+	resources     []graphQLRoot
+	queries       map[string]graphQLRoot
+	mutations     map[string]graphQLRoot
+	subscriptions map[string]graphQLRoot
+}
+
+// NewGraphQL returns the GraphQL API of resources. Type names drop the proto
+// package of the first resource.
+func NewGraphQL(resources ...HTTPResource) *GraphQL {
+	g := &GraphQL{
+		queries:       make(map[string]graphQLRoot),
+		mutations:     make(map[string]graphQLRoot),
+		subscriptions: make(map[string]graphQLRoot),
+	}
+	for index, resource := range resources {
+		message := resource.New().ProtoReflect().Descriptor()
+		if index == 0 {
+			g.pkg = message.ParentFile().Package()
+		}
+		g.resources = append(g.resources, graphQLRoot{resource: resource, message: message})
+		typeName := g.typeName(message)
+		field := strings.ToLower(typeName[:1]) + typeName[1:]
+		g.queries[field] = graphQLRoot{kind: graphQLGet, resource: resource, message: message}
+		g.queries[field+"List"] = graphQLRoot{kind: graphQLList, resource: resource, message: message}
+		g.mutations["create"+typeName] = graphQLRoot{kind: graphQLCreate, resource: resource, message: message}
+		g.mutations["replace"+typeName] = graphQLRoot{kind: graphQLReplace, resource: resource, message: message}
+		g.mutations["delete"+typeName] = graphQLRoot{kind: graphQLDelete, resource: resource, message: message}
+		g.subscriptions[field+"Changes"] = graphQLRoot{kind: graphQLChanges, resource: resource, message: message}
+	}
+	return g
+}
+
+// NewGraphQLHandler is NewGraphQL as an http.Handler.
+func NewGraphQLHandler(resources ...HTTPResource) http.Handler {
+	return NewGraphQL(resources...)
+}
+
+// typeName is the GraphQL name of a message or enum: its full name without
+// the package, with dots replaced by underscores.
+func (g *GraphQL) typeName(descriptor protoreflect.Descriptor) string {
+	name := string(descriptor.FullName())
+	if g.pkg != "" {
+		name = strings.TrimPrefix(name, string(g.pkg)+".")
+	}
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+// ServeHTTP serves GraphQL over HTTP: POST of a JSON GraphQLRequest, or GET
+// with query, operationName and variables parameters for queries and
+// subscriptions. Responses are JSON GraphQLResponses; subscriptions stream
+// them as server-sent events, see serveSubscription.
+func (g *GraphQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request GraphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		parameters := r.URL.Query()
+		request.Query = parameters.Get("query")
+		request.OperationName = parameters.Get("operationName")
+		if variables := parameters.Get("variables"); variables != "" {
+			if err := decodeGraphQLJSON(strings.NewReader(variables), &request.Variables); err != nil {
+				WriteHTTPError(w, http.StatusBadRequest, fmt.Errorf("decode variables: %w", err))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := decodeGraphQLJSON(io.LimitReader(r.Body, HTTPMaxBodyBytes), &request); err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, fmt.Errorf("decode request body: %w", err))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if strings.TrimSpace(request.Query) == "" {
+		WriteHTTPError(w, http.StatusBadRequest, errors.New("missing query"))
+		return
+	}
+	operation, variables, err := parseGraphQLRequest(request)
+	switch {
+	case err != nil:
+		WriteHTTPJSON(w, http.StatusOK, graphQLErrorResponse(err))
+	case operation.subscription:
+		g.serveSubscription(w, r, operation, variables)
+	case operation.mutation && r.Method != http.MethodPost:
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, errors.New("mutations require POST"))
+	default:
+		WriteHTTPJSON(w, http.StatusOK, g.execute(operation, variables))
+	}
+}
+
+// decodeGraphQLJSON decodes JSON keeping numbers exact, as ids and 64-bit
+// fields may not fit a float64.
+func decodeGraphQLJSON(r io.Reader, value any) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+// Execute runs request. Errors of a root field leave it null and are
+// reported with its path; mutations run in order. Subscriptions need a
+// stream; see Subscribe.
+func (g *GraphQL) Execute(request GraphQLRequest) GraphQLResponse {
+	operation, variables, err := parseGraphQLRequest(request)
+	if err != nil {
+		return graphQLErrorResponse(err)
+	}
+	if operation.subscription {
+		return graphQLErrorResponse(errors.New("subscriptions need a stream; use Subscribe or ServeHTTP"))
+	}
+	return g.execute(operation, variables)
+}
+
+// parseGraphQLRequest parses the operation of request and returns it with
+// its variables, defaulted as the operation declares.
+func parseGraphQLRequest(request GraphQLRequest) (graphQLOperation, map[string]any, error) {
+	operation, err := parseGraphQL(request.Query, request.OperationName)
+	if err != nil {
+		return graphQLOperation{}, nil, err
+	}
+	variables := maps.Clone(operation.defaults)
+	maps.Copy(variables, request.Variables)
+	return operation, variables, nil
+}
+
+func graphQLErrorResponse(err error) GraphQLResponse {
+	return GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}}
+}
+
+// execute runs a query or mutation.
+func (g *GraphQL) execute(operation graphQLOperation, variables map[string]any) GraphQLResponse {
+	roots, typeName := g.queries, "Query"
+	if operation.mutation {
+		roots, typeName = g.mutations, "Mutation"
+	}
+	data := graphQLObject{}
+	var errs []GraphQLError
+	for _, selection := range graphQLFields(operation.selections, typeName) {
+		value, err := g.resolveRoot(roots, typeName, selection, variables)
+		if err != nil {
+			errs = append(errs, GraphQLError{Message: err.Error(), Path: []any{selection.key()}})
+			value = nil
+		}
+		data = append(data, graphQLEntry{key: selection.key(), value: value})
+	}
+	return GraphQLResponse{Data: data, Errors: errs}
+}
+
+func (g *GraphQL) resolveRoot(roots map[string]graphQLRoot, typeName string, selection graphQLField, variables map[string]any) (any, error) {
+	switch {
+	case selection.name == "__typename":
+		return typeName, selection.leaf(typeName)
+	case typeName == "Query" && (selection.name == "__schema" || selection.name == "__type"):
+		return g.introspect(selection, variables)
+	}
+	root, ok := roots[selection.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q on %s", selection.name, typeName)
+	}
+	args, err := selection.arguments(variables)
+	if err != nil {
+		return nil, err
+	}
+	if root.kind == graphQLList {
+		return g.list(root, selection, args)
+	}
+	allowed := map[graphQLRootKind][]string{
+		graphQLGet:     {"id"},
+		graphQLCreate:  {"data"},
+		graphQLReplace: {"id", "data"},
+		graphQLDelete:  {"id"},
+	}[root.kind]
+	for name := range args {
+		if !slices.Contains(allowed, name) {
+			return nil, fmt.Errorf("unknown argument %q of %s", name, selection.name)
+		}
+	}
+	var id string
+	var existing HTTPObject
+	if root.kind != graphQLCreate {
+		if id, err = graphQLString(args, "id"); err != nil {
+			return nil, err
+		}
+		var found bool
+		if existing, found, err = root.resource.Get(id); err != nil {
+			return nil, err
+		}
+		if !found {
+			if root.kind == graphQLGet {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%s/%s not found", root.resource.Path, id)
+		}
+	}
+	switch root.kind {
+	case graphQLGet:
+		return g.selectRow(root, existing, selection)
+	case graphQLDelete:
+		if err := selection.leaf("Boolean"); err != nil {
+			return nil, err
+		}
+		if err := root.resource.Delete(existing.ID); err != nil {
+			return nil, err
+		}
+		return true, nil
+	}
+	data, err := root.decodeData(args)
+	if err != nil {
+		return nil, err
+	}
+	var object HTTPObject
+	if root.kind == graphQLCreate {
+		object, err = root.resource.Create(data)
+	} else {
+		object, err = root.resource.Replace(existing.ID, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g.selectRow(root, object, selection)
+}
+
+// list resolves the List field, turning its arguments into the query
+// parameters HTTPWhere takes.
+func (g *GraphQL) list(root graphQLRoot, selection graphQLField, args map[string]any) (any, error) {
+	query := make(url.Values, len(args))
+	for name, value := range args {
+		if value == nil {
+			continue
+		}
+		values, ok := value.([]any)
+		if !ok {
+			values = []any{value}
+		}
+		for _, item := range values {
+			switch item.(type) {
+			case nil, []any, map[string]any:
+				return nil, fmt.Errorf("argument %s of %s takes scalars", name, selection.name)
+			}
+			query.Add(name, fmt.Sprint(item))
+		}
+	}
+	where, whereArgs, err := HTTPWhere(query, root.resource.Columns)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := root.resource.List(where, whereArgs...)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]any, 0, len(objects))
+	for _, object := range objects {
+		row, err := g.selectRow(root, object, selection)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// decodeData decodes and validates the data argument like the REST bodies.
+func (r graphQLRoot) decodeData(args map[string]any) (proto.Message, error) {
+	value, ok := args["data"]
+	if !ok || value == nil {
+		return nil, errors.New("missing argument data")
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encode data: %w", err)
+	}
+	data := r.resource.New()
+	if err := protojson.Unmarshal(encoded, data); err != nil {
+		return nil, fmt.Errorf("decode data: %w", err)
+	}
+	if validator, ok := data.(interface{ Valid() error }); ok {
+		if err := validator.Valid(); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func graphQLString(args map[string]any, name string) (string, error) {
+	switch value := args[name].(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case nil:
+		return "", fmt.Errorf("missing argument %s", name)
+	default:
+		return "", fmt.Errorf("argument %s must be a string, not %T", name, value)
+	}
+}
+
+func (g *GraphQL) selectRow(root graphQLRoot, object HTTPObject, selection graphQLField) (any, error) {
+	rowType := g.typeName(root.message) + "Row"
+	if len(selection.selections) == 0 {
+		return nil, fmt.Errorf("field %s of type %s must have a selection of subfields", selection.name, rowType)
+	}
+	row := graphQLObject{}
+	for _, field := range graphQLFields(selection.selections, rowType) {
+		if len(field.args) > 0 {
+			return nil, fmt.Errorf("field %s of %s takes no arguments", field.name, rowType)
+		}
+		var value any
+		switch field.name {
+		case "__typename":
+			value = rowType
+		case "id":
+			value = object.ID
+		case "atNs":
+			value = strconv.FormatInt(object.AtNs, 10)
+		case "data":
+			decoded, err := graphQLMessageJSON(object.Data)
+			if err != nil {
+				return nil, err
+			}
+			if g.leafMessage(root.message) {
+				value = decoded
+				err = field.leaf("JSON")
+			} else {
+				value, err = g.selectMessage(root.message, decoded, field)
+			}
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown field %q on %s", field.name, rowType)
+		}
+		if field.name != "data" {
+			if err := field.leaf("ID"); err != nil {
+				return nil, err
+			}
+		}
+		row = append(row, graphQLEntry{key: field.key(), value: value})
+	}
+	return row, nil
+}
+
+// graphQLMessageJSON returns the protojson of message, with unpopulated
+// fields, as generic JSON.
+func graphQLMessageJSON(message proto.Message) (any, error) {
+	encoded, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s: %w", message.ProtoReflect().Descriptor().FullName(), err)
+	}
+	var decoded any
+	if err := decodeGraphQLJSON(bytes.NewReader(encoded), &decoded); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", message.ProtoReflect().Descriptor().FullName(), err)
+	}
+	return decoded, nil
+}
+
+func (g *GraphQL) selectMessage(message protoreflect.MessageDescriptor, value any, selection graphQLField) (any, error) {
+	typeName := g.typeName(message)
+	if len(selection.selections) == 0 {
+		return nil, fmt.Errorf("field %s of type %s must have a selection of subfields", selection.name, typeName)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s is %T, not an object", typeName, value)
+	}
+	selected := graphQLObject{}
+	for _, fieldSelection := range graphQLFields(selection.selections, typeName) {
+		if fieldSelection.name == "__typename" {
+			selected = append(selected, graphQLEntry{key: fieldSelection.key(), value: typeName})
+			continue
+		}
+		field := message.Fields().ByJSONName(fieldSelection.name)
+		if field == nil {
+			return nil, fmt.Errorf("unknown field %q on %s", fieldSelection.name, typeName)
+		}
+		if len(fieldSelection.args) > 0 {
+			return nil, fmt.Errorf("field %s of %s takes no arguments", fieldSelection.name, typeName)
+		}
+		fieldValue, err := g.selectField(field, object[field.JSONName()], fieldSelection)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, graphQLEntry{key: fieldSelection.key(), value: fieldValue})
+	}
+	return selected, nil
+}
+
+func (g *GraphQL) selectField(field protoreflect.FieldDescriptor, value any, selection graphQLField) (any, error) {
+	if field.IsMap() || field.Message() == nil || g.leafMessage(field.Message()) {
+		return value, selection.leaf(g.fieldType(field, false, nil))
+	}
+	if !field.IsList() || value == nil {
+		return g.selectMessage(field.Message(), value, selection)
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s is %T, not a list", field.JSONName(), value)
+	}
+	selected := make([]any, 0, len(items))
+	for _, item := range items {
+		itemValue, err := g.selectMessage(field.Message(), item, selection)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, itemValue)
+	}
+	return selected, nil
+}
+
+// leafMessage reports whether message is a scalar in GraphQL: well-known
+// types protojson encodes specially, and messages without fields, which
+// GraphQL object types cannot express.
+func (g *GraphQL) leafMessage(message protoreflect.MessageDescriptor) bool {
+	_, wellKnown := graphQLWellKnown[message.FullName()]
+	return wellKnown || message.Fields().Len() == 0
+}
+
+var graphQLWellKnown = map[protoreflect.FullName]string{
+	"google.protobuf.Timestamp":   "Timestamp",
+	"google.protobuf.Duration":    "String",
+	"google.protobuf.FieldMask":   "String",
+	"google.protobuf.Struct":      "JSON",
+	"google.protobuf.Value":       "JSON",
+	"google.protobuf.ListValue":   "JSON",
+	"google.protobuf.Any":         "JSON",
+	"google.protobuf.Empty":       "JSON",
+	"google.protobuf.BoolValue":   "Boolean",
+	"google.protobuf.Int32Value":  "Int",
+	"google.protobuf.UInt32Value": "Int64",
+	"google.protobuf.Int64Value":  "Int64",
+	"google.protobuf.UInt64Value": "Int64",
+	"google.protobuf.FloatValue":  "Float",
+	"google.protobuf.DoubleValue": "Float",
+	"google.protobuf.StringValue": "String",
+	"google.protobuf.BytesValue":  "String",
+}
+
+// graphQLSchemaTypes collects the messages and enums the schema refers to,
+// in the order they are first referred to.
+type graphQLSchemaTypes struct {
+	messages []protoreflect.MessageDescriptor
+	enums    []protoreflect.EnumDescriptor
+	seen     map[protoreflect.FullName]bool
+}
+
+func (t *graphQLSchemaTypes) addMessage(message protoreflect.MessageDescriptor) {
+	if t != nil && !t.seen[message.FullName()] {
+		t.seen[message.FullName()] = true
+		t.messages = append(t.messages, message)
+	}
+}
+
+// fieldType is the GraphQL type of field, of its input type when input,
+// adding the types it refers to to types when not nil.
+func (g *GraphQL) fieldType(field protoreflect.FieldDescriptor, input bool, types *graphQLSchemaTypes) string {
+	if field.IsMap() {
+		return "JSON"
+	}
+	var name string
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		name = "Boolean"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		name = "Int"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Int64Kind, protoreflect.Sint64Kind,
+		protoreflect.Sfixed64Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		name = "Int64"
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		name = "Float"
+	case protoreflect.EnumKind:
+		name = "JSON"
+		if field.Enum().FullName() != "google.protobuf.NullValue" {
+			name = g.typeName(field.Enum())
+			if types != nil && !types.seen[field.Enum().FullName()] {
+				types.seen[field.Enum().FullName()] = true
+				types.enums = append(types.enums, field.Enum())
+			}
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		switch wellKnown, ok := graphQLWellKnown[field.Message().FullName()]; {
+		case ok:
+			name = wellKnown
+		case field.Message().Fields().Len() == 0:
+			name = "JSON"
+		default:
+			name = g.typeName(field.Message())
+			if input {
+				name += "Input"
+			}
+			types.addMessage(field.Message())
+		}
+	default:
+		name = "String"
+	}
+	if field.IsList() {
+		return "[" + name + "!]"
+	}
+	return name
+}
+
+// graphQLType is a named type of the schema, which Schema writes as SDL and
+// introspection serves. Field types are SDL type references, such as
+// "[PersonRow!]".
+type graphQLType struct {
+	kind        string
+	name        string
+	description string
+	fields      []graphQLTypeField
+	enumValues  []string
+}
+
+// graphQLTypeField is a field of an object or input type, or an argument.
+type graphQLTypeField struct {
+	name string
+	args []graphQLTypeField
+	typ  string
+}
+
+// Kinds of graphQLType, named as introspection names them.
+const (
+	graphQLScalarKind = "SCALAR"
+	graphQLObjectKind = "OBJECT"
+	graphQLInputKind  = "INPUT_OBJECT"
+	graphQLEnumKind   = "ENUM"
+)
+
+// schemaTypes returns the types of g that are not built into GraphQL, in the
+// order Schema writes them.
+func (g *GraphQL) schemaTypes() []graphQLType {
+	schemaTypes := []graphQLType{
+		{kind: graphQLScalarKind, name: "Int64", description: "An integer as protojson encodes it: a string for 64-bit fields, a number otherwise."},
+		{kind: graphQLScalarKind, name: "JSON", description: "Any JSON value."},
+		{kind: graphQLScalarKind, name: "Timestamp", description: "An RFC 3339 timestamp."},
+	}
+	types := &graphQLSchemaTypes{seen: make(map[protoreflect.FullName]bool)}
+	query := graphQLType{kind: graphQLObjectKind, name: "Query"}
+	mutation := graphQLType{kind: graphQLObjectKind, name: "Mutation"}
+	subscription := graphQLType{kind: graphQLObjectKind, name: "Subscription"}
+	var rows []graphQLType
+	for _, root := range g.resources {
+		typeName := g.typeName(root.message)
+		field := strings.ToLower(typeName[:1]) + typeName[1:]
+		filters := make([]graphQLTypeField, 0, len(root.resource.Columns))
+		for _, column := range root.resource.Columns {
+			columnType := "String"
+			switch column.SQLiteType {
+			case "INTEGER":
+				columnType = "Int64"
+			case "REAL":
+				columnType = "Float"
+			}
+			filters = append(filters, graphQLTypeField{name: column.Name, typ: "[" + columnType + "!]"})
+		}
+		id := graphQLTypeField{name: "id", typ: "ID!"}
+		query.fields = append(query.fields,
+			graphQLTypeField{name: field, args: []graphQLTypeField{id}, typ: typeName + "Row"},
+			graphQLTypeField{name: field + "List", args: filters, typ: "[" + typeName + "Row!]"},
+		)
+		dataType, inputType := "JSON", "JSON"
+		if !g.leafMessage(root.message) {
+			types.addMessage(root.message)
+			dataType, inputType = typeName, typeName+"Input"
+		}
+		data := graphQLTypeField{name: "data", typ: inputType + "!"}
+		mutation.fields = append(mutation.fields,
+			graphQLTypeField{name: "create" + typeName, args: []graphQLTypeField{data}, typ: typeName + "Row"},
+			graphQLTypeField{name: "replace" + typeName, args: []graphQLTypeField{id, data}, typ: typeName + "Row"},
+			graphQLTypeField{name: "delete" + typeName, args: []graphQLTypeField{id}, typ: "Boolean"},
+		)
+		subscription.fields = append(subscription.fields, graphQLTypeField{
+			name: field + "Changes",
+			args: []graphQLTypeField{{name: "id", typ: "ID"}, {name: "since", typ: "Int64"}},
+			typ:  typeName + "Change!",
+		})
+		rows = append(rows, graphQLType{kind: graphQLObjectKind, name: typeName + "Row", fields: []graphQLTypeField{
+			{name: "id", typ: "ID!"},
+			{name: "atNs", typ: "Int64!"},
+			{name: "data", typ: dataType + "!"},
+		}}, graphQLType{kind: graphQLObjectKind, name: typeName + "Change", fields: []graphQLTypeField{
+			{name: "seq", typ: "Int64!"},
+			{name: "op", typ: "ChangeOp!"},
+			{name: "id", typ: "ID!"},
+			{name: "atNs", typ: "Int64!"},
+			{name: "row", typ: typeName + "Row"},
+		}})
+	}
+	schemaTypes = append(schemaTypes, query, mutation, subscription)
+	schemaTypes = append(schemaTypes, rows...)
+	// Messages are added while their referrers are written.
+	for index := 0; index < len(types.messages); index++ {
+		message := types.messages[index]
+		for _, input := range []bool{false, true} {
+			messageType := graphQLType{kind: graphQLObjectKind, name: g.typeName(message)}
+			if input {
+				messageType.kind, messageType.name = graphQLInputKind, messageType.name+"Input"
+			}
+			fields := message.Fields()
+			for i := range fields.Len() {
+				messageType.fields = append(messageType.fields, graphQLTypeField{name: fields.Get(i).JSONName(), typ: g.fieldType(fields.Get(i), input, types)})
+			}
+			schemaTypes = append(schemaTypes, messageType)
+		}
+	}
+	schemaTypes = append(schemaTypes, graphQLType{
+		kind:       graphQLEnumKind,
+		name:       "ChangeOp",
+		enumValues: []string{string(ChangeInsert), string(ChangeUpdate), string(ChangeDelete)},
+	})
+	for _, enum := range types.enums {
+		enumType := graphQLType{kind: graphQLEnumKind, name: g.typeName(enum)}
+		values := enum.Values()
+		for i := range values.Len() {
+			enumType.enumValues = append(enumType.enumValues, string(values.Get(i).Name()))
+		}
+		schemaTypes = append(schemaTypes, enumType)
+	}
+	return schemaTypes
+}
+
+// Schema returns the GraphQL SDL of g.
+func (g *GraphQL) Schema() string {
+	var sdl strings.Builder
+	for _, schemaType := range g.schemaTypes() {
+		if schemaType.description != "" {
+			sdl.WriteString(strconv.Quote(schemaType.description) + "\n")
+		}
+		switch schemaType.kind {
+		case graphQLScalarKind:
+			fmt.Fprintf(&sdl, "scalar %s\n\n", schemaType.name)
+			continue
+		case graphQLEnumKind:
+			fmt.Fprintf(&sdl, "enum %s {\n", schemaType.name)
+			for _, value := range schemaType.enumValues {
+				fmt.Fprintf(&sdl, "  %s\n", value)
+			}
+			sdl.WriteString("}\n\n")
+			continue
+		case graphQLInputKind:
+			fmt.Fprintf(&sdl, "input %s {\n", schemaType.name)
+		default:
+			fmt.Fprintf(&sdl, "type %s {\n", schemaType.name)
+		}
+		for _, field := range schemaType.fields {
+			sdl.WriteString("  " + field.name)
+			if len(field.args) > 0 {
+				args := make([]string, 0, len(field.args))
+				for _, arg := range field.args {
+					args = append(args, arg.name+": "+arg.typ)
+				}
+				sdl.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			sdl.WriteString(": " + field.typ + "\n")
+		}
+		sdl.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(sdl.String(), "\n")
+}
+
+// graphQLObject is a response object, keeping the order of the selection.
+type graphQLObject []graphQLEntry
+
+type graphQLEntry struct {
+	key   string
+	value any
+}
+
+func (o graphQLObject) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for index, entry := range o {
+		if index > 0 {
+			buffer.WriteByte(',')
+		}
+		key, err := json.Marshal(entry.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
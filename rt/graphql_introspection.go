@@ -0,0 +1,227 @@
+package proprdbrt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// graphQLBuiltinScalars are the scalars every GraphQL schema has, which the
+// SDL does not declare but introspection lists.
+var graphQLBuiltinScalars = []string{"String", "Int", "Float", "Boolean", "ID"}
+
+// graphQLIntrospectionObject is an object of the introspection schema, such
+// as a __Type, by field name. Values are leaves, lists, objects or funcs
+// returning them, which resolve lazily as types refer to each other.
+type graphQLIntrospectionObject map[string]any
+
+// graphQLIntrospection serves __schema and __type over the types of a schema.
+type graphQLIntrospection struct {
+	types map[string]graphQLType
+	names []string
+}
+
+func (g *GraphQL) introspection() *graphQLIntrospection {
+	schemaTypes := g.schemaTypes()
+	for _, name := range graphQLBuiltinScalars {
+		schemaTypes = append(schemaTypes, graphQLType{kind: graphQLScalarKind, name: name})
+	}
+	introspection := &graphQLIntrospection{types: make(map[string]graphQLType, len(schemaTypes))}
+	for _, schemaType := range schemaTypes {
+		introspection.types[schemaType.name] = schemaType
+		introspection.names = append(introspection.names, schemaType.name)
+	}
+	return introspection
+}
+
+// introspect resolves the __schema or __type field of Query.
+func (g *GraphQL) introspect(selection graphQLField, variables map[string]any) (any, error) {
+	args, err := selection.arguments(variables)
+	if err != nil {
+		return nil, err
+	}
+	introspection := g.introspection()
+	if selection.name == "__schema" {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("field %s takes no arguments", selection.name)
+		}
+		return selectGraphQLIntrospection(introspection.schema(), selection)
+	}
+	for name := range args {
+		if name != "name" {
+			return nil, fmt.Errorf("unknown argument %q of %s", name, selection.name)
+		}
+	}
+	name, err := graphQLString(args, "name")
+	if err != nil {
+		return nil, err
+	}
+	return selectGraphQLIntrospection(introspection.namedType(name), selection)
+}
+
+// schema returns the __Schema. Directives are not supported, so it lists
+// none.
+func (i *graphQLIntrospection) schema() graphQLIntrospectionObject {
+	types := make([]any, 0, len(i.names))
+	for _, name := range i.names {
+		types = append(types, i.namedType(name))
+	}
+	return graphQLIntrospectionObject{
+		"__typename":       "__Schema",
+		"description":      nil,
+		"types":            types,
+		"queryType":        i.namedType("Query"),
+		"mutationType":     i.namedType("Mutation"),
+		"subscriptionType": i.namedType("Subscription"),
+		"directives":       []any{},
+	}
+}
+
+// newGraphQLIntrospectionType returns a __Type of kind with every field
+// null.
+func newGraphQLIntrospectionType(kind string) graphQLIntrospectionObject {
+	return graphQLIntrospectionObject{
+		"__typename":     "__Type",
+		"kind":           kind,
+		"name":           nil,
+		"description":    nil,
+		"specifiedByURL": nil,
+		"fields":         nil,
+		"interfaces":     nil,
+		"possibleTypes":  nil,
+		"enumValues":     nil,
+		"inputFields":    nil,
+		"ofType":         nil,
+		"isOneOf":        nil,
+	}
+}
+
+// namedType returns the __Type named name, or nil when there is none.
+func (i *graphQLIntrospection) namedType(name string) any {
+	schemaType, ok := i.types[name]
+	if !ok {
+		return nil
+	}
+	object := newGraphQLIntrospectionType(schemaType.kind)
+	object["name"] = schemaType.name
+	if schemaType.description != "" {
+		object["description"] = schemaType.description
+	}
+	switch schemaType.kind {
+	case graphQLObjectKind:
+		object["fields"] = func() any { return i.fields(schemaType.fields) }
+		object["interfaces"] = []any{}
+	case graphQLInputKind:
+		object["inputFields"] = func() any { return i.inputValues(schemaType.fields) }
+		object["isOneOf"] = false
+	case graphQLEnumKind:
+		values := make([]any, 0, len(schemaType.enumValues))
+		for _, value := range schemaType.enumValues {
+			values = append(values, graphQLIntrospectionObject{
+				"__typename":        "__EnumValue",
+				"name":              value,
+				"description":       nil,
+				"isDeprecated":      false,
+				"deprecationReason": nil,
+			})
+		}
+		object["enumValues"] = values
+	}
+	return object
+}
+
+// typeRef returns the __Type of the SDL type reference ref, wrapping the
+// named type in NON_NULL and LIST types.
+func (i *graphQLIntrospection) typeRef(ref string) any {
+	if inner, ok := strings.CutSuffix(ref, "!"); ok {
+		object := newGraphQLIntrospectionType("NON_NULL")
+		object["ofType"] = func() any { return i.typeRef(inner) }
+		return object
+	}
+	if inner, ok := strings.CutPrefix(ref, "["); ok {
+		object := newGraphQLIntrospectionType("LIST")
+		object["ofType"] = func() any { return i.typeRef(strings.TrimSuffix(inner, "]")) }
+		return object
+	}
+	return i.namedType(ref)
+}
+
+func (i *graphQLIntrospection) fields(fields []graphQLTypeField) []any {
+	objects := make([]any, 0, len(fields))
+	for _, field := range fields {
+		objects = append(objects, graphQLIntrospectionObject{
+			"__typename":        "__Field",
+			"name":              field.name,
+			"description":       nil,
+			"args":              i.inputValues(field.args),
+			"type":              func() any { return i.typeRef(field.typ) },
+			"isDeprecated":      false,
+			"deprecationReason": nil,
+		})
+	}
+	return objects
+}
+
+func (i *graphQLIntrospection) inputValues(fields []graphQLTypeField) []any {
+	objects := make([]any, 0, len(fields))
+	for _, field := range fields {
+		objects = append(objects, graphQLIntrospectionObject{
+			"__typename":        "__InputValue",
+			"name":              field.name,
+			"description":       nil,
+			"type":              func() any { return i.typeRef(field.typ) },
+			"defaultValue":      nil,
+			"isDeprecated":      false,
+			"deprecationReason": nil,
+		})
+	}
+	return objects
+}
+
+// selectGraphQLIntrospection applies selection to value, an introspection
+// value.
+func selectGraphQLIntrospection(value any, selection graphQLField) (any, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case func() any:
+		return selectGraphQLIntrospection(value(), selection)
+	case []any:
+		items := make([]any, 0, len(value))
+		for _, item := range value {
+			selected, err := selectGraphQLIntrospection(item, selection)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, selected)
+		}
+		return items, nil
+	case graphQLIntrospectionObject:
+		typeName := value["__typename"].(string)
+		if len(selection.selections) == 0 {
+			return nil, fmt.Errorf("field %s of type %s must have a selection of subfields", selection.name, typeName)
+		}
+		selected := graphQLObject{}
+		for _, field := range graphQLFields(selection.selections, typeName) {
+			for name := range field.args {
+				// Nothing is deprecated, so includeDeprecated changes nothing.
+				if name != "includeDeprecated" {
+					return nil, fmt.Errorf("unknown argument %q of %s", name, field.name)
+				}
+			}
+			fieldValue, ok := value[field.name]
+			if !ok {
+				return nil, fmt.Errorf("unknown field %q on %s", field.name, typeName)
+			}
+			fieldValue, err := selectGraphQLIntrospection(fieldValue, field)
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, graphQLEntry{key: field.key(), value: fieldValue})
+		}
+		return selected, nil
+	case bool:
+		return value, selection.leaf("Boolean")
+	default:
+		return value, selection.leaf("String")
+	}
+}
@@ -0,0 +1,599 @@
+package proprdbrt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// graphQLOperation is the executable part of a GraphQL document.
+type graphQLOperation struct {
+	name         string
+	mutation     bool
+	subscription bool
+	defaults     map[string]any
+	selections   []graphQLField
+}
+
+// graphQLField is a field selection. Argument values are parsed literals
+// that may hold graphQLVariable and graphQLEnum values. A fragment, inline
+// or spread, is a graphQLField too: its selections apply to objects of its
+// typeCondition, or to any object when that is empty; see graphQLFields.
+type graphQLField struct {
+	alias         string
+	name          string
+	args          map[string]any
+	selections    []graphQLField
+	fragment      bool
+	typeCondition string
+	// spread names the fragment of a spread until parseGraphQL resolves it.
+	spread string
+}
+
+type graphQLVariable string
+
+type graphQLEnum string
+
+// key names the field in the response.
+func (f graphQLField) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// graphQLFields returns the fields selections select on an object of
+// typeName: fragments that apply to it are inlined and fields of the same
+// response key merged.
+func graphQLFields(selections []graphQLField, typeName string) []graphQLField {
+	fields := make([]graphQLField, 0, len(selections))
+	positions := make(map[string]int)
+	var add func(selections []graphQLField)
+	add = func(selections []graphQLField) {
+		for _, field := range selections {
+			if field.fragment {
+				if field.typeCondition == "" || field.typeCondition == typeName {
+					add(field.selections)
+				}
+				continue
+			}
+			if position, ok := positions[field.key()]; ok {
+				fields[position].selections = slices.Concat(fields[position].selections, field.selections)
+				continue
+			}
+			positions[field.key()] = len(fields)
+			fields = append(fields, field)
+		}
+	}
+	add(selections)
+	return fields
+}
+
+// leaf fails when the leaf field f, of typeName, has a selection.
+func (f graphQLField) leaf(typeName string) error {
+	if len(f.selections) > 0 {
+		return fmt.Errorf("field %s of type %s must not have a selection", f.name, typeName)
+	}
+	return nil
+}
+
+// arguments resolves the arguments of f with variables: enums become their
+// names, as protojson expects.
+func (f graphQLField) arguments(variables map[string]any) (map[string]any, error) {
+	resolved := make(map[string]any, len(f.args))
+	for name, value := range f.args {
+		argument, err := resolveGraphQLValue(value, variables)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s of %s: %w", name, f.name, err)
+		}
+		resolved[name] = argument
+	}
+	return resolved, nil
+}
+
+func resolveGraphQLValue(value any, variables map[string]any) (any, error) {
+	switch value := value.(type) {
+	case graphQLVariable:
+		resolved, ok := variables[string(value)]
+		if !ok {
+			return nil, fmt.Errorf("undefined variable $%s", value)
+		}
+		return resolved, nil
+	case graphQLEnum:
+		return string(value), nil
+	case []any:
+		items := make([]any, len(value))
+		for index, item := range value {
+			resolved, err := resolveGraphQLValue(item, variables)
+			if err != nil {
+				return nil, err
+			}
+			items[index] = resolved
+		}
+		return items, nil
+	case map[string]any:
+		object := make(map[string]any, len(value))
+		for name, item := range value {
+			resolved, err := resolveGraphQLValue(item, variables)
+			if err != nil {
+				return nil, err
+			}
+			object[name] = resolved
+		}
+		return object, nil
+	default:
+		return value, nil
+	}
+}
+
+// parseGraphQL parses query and returns its operation named operationName,
+// or its only operation when operationName is empty.
+func parseGraphQL(query, operationName string) (graphQLOperation, error) {
+	parser := &graphQLParser{source: query, fragments: make(map[string]graphQLField)}
+	operations := make([]graphQLOperation, 0, 1)
+	for parser.peek() != 0 {
+		operation, isFragment, err := parser.definition()
+		if err != nil {
+			return graphQLOperation{}, err
+		}
+		if !isFragment {
+			operations = append(operations, operation)
+		}
+	}
+	var operation graphQLOperation
+	switch {
+	case len(operations) == 0:
+		return graphQLOperation{}, errors.New("no operation in query")
+	case operationName != "":
+		index := slices.IndexFunc(operations, func(operation graphQLOperation) bool { return operation.name == operationName })
+		if index < 0 {
+			return graphQLOperation{}, fmt.Errorf("unknown operation %q", operationName)
+		}
+		operation = operations[index]
+	case len(operations) > 1:
+		return graphQLOperation{}, errors.New("operationName is required for documents with several operations")
+	default:
+		operation = operations[0]
+	}
+	var err error
+	operation.selections, err = parser.resolveSpreads(operation.selections, make(map[string]bool))
+	return operation, err
+}
+
+type graphQLParser struct {
+	source    string
+	pos       int
+	fragments map[string]graphQLField
+}
+
+// resolveSpreads replaces the fragment spreads of selections with the
+// fragments they name.
+func (p *graphQLParser) resolveSpreads(selections []graphQLField, visiting map[string]bool) ([]graphQLField, error) {
+	resolved := make([]graphQLField, 0, len(selections))
+	for _, field := range selections {
+		var err error
+		if field.spread != "" {
+			fragment, ok := p.fragments[field.spread]
+			if !ok {
+				return nil, fmt.Errorf("unknown fragment %q", field.spread)
+			}
+			if visiting[field.spread] {
+				return nil, fmt.Errorf("fragment %s spreads itself", field.spread)
+			}
+			visiting[field.spread] = true
+			fragment.selections, err = p.resolveSpreads(fragment.selections, visiting)
+			delete(visiting, field.spread)
+			field = fragment
+		} else {
+			field.selections, err = p.resolveSpreads(field.selections, visiting)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, field)
+	}
+	return resolved, nil
+}
+
+func (p *graphQLParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("graphql syntax error at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// peek skips whitespace, commas and comments and returns the next byte, or 0
+// at the end.
+func (p *graphQLParser) peek() byte {
+	for p.pos < len(p.source) {
+		switch character := p.source[p.pos]; character {
+		case ' ', '\t', '\n', '\r', ',':
+			p.pos++
+		case '#':
+			for p.pos < len(p.source) && p.source[p.pos] != '\n' && p.source[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return character
+		}
+	}
+	return 0
+}
+
+func (p *graphQLParser) consume(punctuator byte) bool {
+	if p.peek() == punctuator {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *graphQLParser) expect(punctuator byte) error {
+	if !p.consume(punctuator) {
+		return p.errorf("expected %q", punctuator)
+	}
+	return nil
+}
+
+func isGraphQLNameByte(character byte, first bool) bool {
+	return character == '_' || (character >= 'a' && character <= 'z') || (character >= 'A' && character <= 'Z') ||
+		(!first && character >= '0' && character <= '9')
+}
+
+func (p *graphQLParser) name() (string, error) {
+	p.peek()
+	start := p.pos
+	for p.pos < len(p.source) && isGraphQLNameByte(p.source[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a name")
+	}
+	return p.source[start:p.pos], nil
+}
+
+// definition parses an operation, or a fragment definition, which it keeps
+// for resolveSpreads and reports with isFragment.
+func (p *graphQLParser) definition() (operation graphQLOperation, isFragment bool, err error) {
+	operation = graphQLOperation{defaults: make(map[string]any)}
+	if p.peek() == '{' {
+		operation.selections, err = p.selectionSet()
+		return operation, false, err
+	}
+	keyword, err := p.name()
+	if err != nil {
+		return operation, false, err
+	}
+	switch keyword {
+	case "query":
+	case "mutation":
+		operation.mutation = true
+	case "subscription":
+		operation.subscription = true
+	case "fragment":
+		return operation, true, p.fragmentDefinition()
+	default:
+		return operation, false, p.errorf("unexpected %q", keyword)
+	}
+	if isGraphQLNameByte(p.peek(), true) {
+		if operation.name, err = p.name(); err != nil {
+			return operation, false, err
+		}
+	}
+	if p.consume('(') {
+		for !p.consume(')') {
+			if err := p.variableDefinition(operation.defaults); err != nil {
+				return operation, false, err
+			}
+		}
+	}
+	if p.peek() == '@' {
+		return operation, false, errors.New("graphql directives are not supported")
+	}
+	operation.selections, err = p.selectionSet()
+	return operation, false, err
+}
+
+// fragmentDefinition parses "Name on Type { ... }" after "fragment".
+func (p *graphQLParser) fragmentDefinition() error {
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if name == "on" {
+		return p.errorf("fragment name expected")
+	}
+	if _, ok := p.fragments[name]; ok {
+		return fmt.Errorf("duplicate fragment %q", name)
+	}
+	fragment, err := p.typeCondition()
+	if err != nil {
+		return err
+	}
+	p.fragments[name] = fragment
+	return nil
+}
+
+// typeCondition parses "on Type { ... }" of a fragment.
+func (p *graphQLParser) typeCondition() (graphQLField, error) {
+	fragment := graphQLField{fragment: true}
+	on, err := p.name()
+	if err != nil {
+		return fragment, err
+	}
+	if on != "on" {
+		return fragment, p.errorf("expected \"on\"")
+	}
+	if fragment.typeCondition, err = p.name(); err != nil {
+		return fragment, err
+	}
+	if p.peek() == '@' {
+		return fragment, errors.New("graphql directives are not supported")
+	}
+	fragment.selections, err = p.selectionSet()
+	return fragment, err
+}
+
+// fragmentSelection parses the spread or inline fragment after "...".
+func (p *graphQLParser) fragmentSelection() (graphQLField, error) {
+	switch character := p.peek(); {
+	case character == '{':
+		selections, err := p.selectionSet()
+		return graphQLField{fragment: true, selections: selections}, err
+	case character == '@':
+		return graphQLField{}, errors.New("graphql directives are not supported")
+	case !isGraphQLNameByte(character, true):
+		return graphQLField{}, p.errorf("expected a fragment")
+	}
+	start := p.pos
+	name, err := p.name()
+	if err != nil {
+		return graphQLField{}, err
+	}
+	if name == "on" {
+		p.pos = start
+		return p.typeCondition()
+	}
+	if p.peek() == '@' {
+		return graphQLField{}, errors.New("graphql directives are not supported")
+	}
+	return graphQLField{spread: name}, nil
+}
+
+// variableDefinition parses "$name: Type = default", keeping the default,
+// or null for variables without one.
+func (p *graphQLParser) variableDefinition(defaults map[string]any) error {
+	if err := p.expect('$'); err != nil {
+		return err
+	}
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	if err := p.typeReference(); err != nil {
+		return err
+	}
+	defaults[name] = nil
+	if p.consume('=') {
+		value, err := p.value(true)
+		if err != nil {
+			return err
+		}
+		if defaults[name], err = resolveGraphQLValue(value, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeReference skips a type; arguments are checked when they are used.
+func (p *graphQLParser) typeReference() error {
+	if p.consume('[') {
+		if err := p.typeReference(); err != nil {
+			return err
+		}
+		if err := p.expect(']'); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	p.consume('!')
+	return nil
+}
+
+func (p *graphQLParser) selectionSet() ([]graphQLField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	selections := make([]graphQLField, 0)
+	for !p.consume('}') {
+		switch p.peek() {
+		case 0:
+			return nil, p.errorf("unterminated selection set")
+		case '.':
+			if !strings.HasPrefix(p.source[p.pos:], "...") {
+				return nil, p.errorf("expected \"...\"")
+			}
+			p.pos += 3
+			fragment, err := p.fragmentSelection()
+			if err != nil {
+				return nil, err
+			}
+			selections = append(selections, fragment)
+			continue
+		}
+		field := graphQLField{args: make(map[string]any)}
+		var err error
+		if field.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if p.consume(':') {
+			field.alias = field.name
+			if field.name, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if p.consume('(') {
+			for !p.consume(')') {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(':'); err != nil {
+					return nil, err
+				}
+				if field.args[name], err = p.value(false); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if p.peek() == '@' {
+			return nil, errors.New("graphql directives are not supported")
+		}
+		if p.peek() == '{' {
+			if field.selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			if len(field.selections) == 0 {
+				return nil, p.errorf("empty selection set of %s", field.name)
+			}
+		}
+		selections = append(selections, field)
+	}
+	return selections, nil
+}
+
+// value parses a literal; constant ones may not refer to variables.
+func (p *graphQLParser) value(constant bool) (any, error) {
+	switch character := p.peek(); {
+	case character == '$':
+		if constant {
+			return nil, p.errorf("variable in a constant value")
+		}
+		p.pos++
+		name, err := p.name()
+		return graphQLVariable(name), err
+	case character == '[':
+		p.pos++
+		items := make([]any, 0)
+		for !p.consume(']') {
+			if p.peek() == 0 {
+				return nil, p.errorf("unterminated list")
+			}
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case character == '{':
+		p.pos++
+		object := make(map[string]any)
+		for !p.consume('}') {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	case character == '"':
+		return p.stringValue()
+	case character == '-' || (character >= '0' && character <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.source) && strings.IndexByte("0123456789.eE+-", p.source[p.pos]) >= 0 {
+			p.pos++
+		}
+		number := p.source[start:p.pos]
+		if _, err := strconv.ParseFloat(number, 64); err != nil {
+			return nil, p.errorf("invalid number %q", number)
+		}
+		return json.Number(number), nil
+	case isGraphQLNameByte(character, true):
+		name, err := p.name()
+		switch name {
+		case "true":
+			return true, err
+		case "false":
+			return false, err
+		case "null":
+			return nil, err
+		}
+		return graphQLEnum(name), err
+	default:
+		return nil, p.errorf("expected a value")
+	}
+}
+
+func (p *graphQLParser) stringValue() (string, error) {
+	if strings.HasPrefix(p.source[p.pos:], `"""`) {
+		end := strings.Index(p.source[p.pos+3:], `"""`)
+		if end < 0 {
+			return "", p.errorf("unterminated block string")
+		}
+		value := p.source[p.pos+3 : p.pos+3+end]
+		p.pos += 3 + end + 3
+		return value, nil
+	}
+	p.pos++
+	var value strings.Builder
+	for p.pos < len(p.source) {
+		character := p.source[p.pos]
+		switch {
+		case character == '"':
+			p.pos++
+			return value.String(), nil
+		case character == '\n' || character == '\r':
+			return "", p.errorf("unterminated string")
+		case character != '\\':
+			r, size := utf8.DecodeRuneInString(p.source[p.pos:])
+			value.WriteRune(r)
+			p.pos += size
+			continue
+		}
+		if p.pos+1 >= len(p.source) {
+			break
+		}
+		escape := p.source[p.pos+1]
+		p.pos += 2
+		switch escape {
+		case '"', '\\', '/':
+			value.WriteByte(escape)
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.source) {
+				return "", p.errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(p.source[p.pos:p.pos+4], 16, 16)
+			if err != nil {
+				return "", p.errorf("invalid unicode escape")
+			}
+			value.WriteRune(rune(code))
+			p.pos += 4
+		default:
+			return "", p.errorf("invalid escape \\%c", escape)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
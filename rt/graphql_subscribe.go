@@ -0,0 +1,266 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultGraphQLPollInterval is how often subscriptions read the change feed
+// when GraphQL.PollInterval is 0.
+const DefaultGraphQLPollInterval = 250 * time.Millisecond
+
+// graphQLChangesBatchSize caps the changes a subscription reads at a time.
+const graphQLChangesBatchSize = 100
+
+// graphQLSubscription follows the changes of the table of root after seq,
+// of the row id only when id is set.
+type graphQLSubscription struct {
+	root      graphQLRoot
+	selection graphQLField
+	id        string
+	seq       int64
+}
+
+// Subscribe runs the subscription request, calling send with the seq and the
+// response of every matching change, in change feed order, until ctx is done
+// or send fails. It returns the error of send, or ctx.Err(). Without a since
+// argument a subscription starts with the changes after the current end of
+// the feed.
+func (g *GraphQL) Subscribe(ctx context.Context, request GraphQLRequest, send func(seq int64, response GraphQLResponse) error) error {
+	operation, variables, err := parseGraphQLRequest(request)
+	if err != nil {
+		return err
+	}
+	if !operation.subscription {
+		return errors.New("not a subscription")
+	}
+	subscription, err := g.subscription(operation, variables, -1)
+	if err != nil {
+		return err
+	}
+	return g.follow(ctx, subscription, send)
+}
+
+// subscription checks a subscription operation and returns what it follows:
+// the changes after resume when it is not negative, else those after its
+// since argument, else those after the current end of the feed.
+func (g *GraphQL) subscription(operation graphQLOperation, variables map[string]any, resume int64) (graphQLSubscription, error) {
+	if g.Changes == nil {
+		return graphQLSubscription{}, errors.New("subscriptions need GraphQL.Changes, a database with the change feed")
+	}
+	fields := graphQLFields(operation.selections, "Subscription")
+	if len(fields) != 1 {
+		return graphQLSubscription{}, errors.New("a subscription must select exactly one field")
+	}
+	selection := fields[0]
+	root, ok := g.subscriptions[selection.name]
+	if !ok {
+		return graphQLSubscription{}, fmt.Errorf("unknown field %q on Subscription", selection.name)
+	}
+	if len(selection.selections) == 0 {
+		return graphQLSubscription{}, fmt.Errorf("field %s of type %sChange must have a selection of subfields", selection.name, g.typeName(root.message))
+	}
+	args, err := selection.arguments(variables)
+	if err != nil {
+		return graphQLSubscription{}, err
+	}
+	subscription := graphQLSubscription{root: root, selection: selection, seq: resume}
+	for name, value := range args {
+		if value == nil {
+			continue
+		}
+		switch name {
+		case "id":
+			if subscription.id, err = graphQLString(args, name); err != nil {
+				return graphQLSubscription{}, err
+			}
+		case "since":
+			since, err := graphQLString(args, name)
+			if err != nil {
+				return graphQLSubscription{}, err
+			}
+			if subscription.seq < 0 {
+				if subscription.seq, err = strconv.ParseInt(since, 10, 64); err != nil || subscription.seq < 0 {
+					return graphQLSubscription{}, fmt.Errorf("argument since of %s must be a change feed seq, not %q", selection.name, since)
+				}
+			}
+		default:
+			return graphQLSubscription{}, fmt.Errorf("unknown argument %q of %s", name, selection.name)
+		}
+	}
+	last, stale, err := ChangeFeedPosition(g.Changes, max(subscription.seq, 0))
+	if err != nil {
+		return graphQLSubscription{}, err
+	}
+	switch {
+	case subscription.seq < 0:
+		subscription.seq = last
+	case stale:
+		return graphQLSubscription{}, fmt.Errorf("the changes after %d are no longer in the change feed", subscription.seq)
+	}
+	return subscription, nil
+}
+
+// follow polls the change feed for subscription, sending the response of
+// every matching change.
+func (g *GraphQL) follow(ctx context.Context, subscription graphQLSubscription, send func(seq int64, response GraphQLResponse) error) error {
+	interval := g.PollInterval
+	if interval <= 0 {
+		interval = DefaultGraphQLPollInterval
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		changes, err := ReadChanges(g.Changes, subscription.seq, graphQLChangesBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			subscription.seq = change.Seq
+			if change.TableName != subscription.root.resource.TableName || (subscription.id != "" && change.ID != subscription.id) {
+				continue
+			}
+			if err := send(change.Seq, g.changeResponse(subscription, change)); err != nil {
+				return err
+			}
+		}
+		if len(changes) == graphQLChangesBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// changeResponse is the response of a subscription for change. Errors
+// leave the field null, as for queries.
+func (g *GraphQL) changeResponse(subscription graphQLSubscription, change Change) GraphQLResponse {
+	key := subscription.selection.key()
+	value, err := g.selectChange(subscription.root, change, subscription.selection)
+	if err != nil {
+		return GraphQLResponse{
+			Data:   graphQLObject{{key: key, value: nil}},
+			Errors: []GraphQLError{{Message: err.Error(), Path: []any{key}}},
+		}
+	}
+	return GraphQLResponse{Data: graphQLObject{{key: key, value: value}}}
+}
+
+// selectChange selects the fields of a <Type>Change. Its row is read when
+// the change is sent, and is null once the row is gone.
+func (g *GraphQL) selectChange(root graphQLRoot, change Change, selection graphQLField) (any, error) {
+	changeType := g.typeName(root.message) + "Change"
+	selected := graphQLObject{}
+	for _, field := range graphQLFields(selection.selections, changeType) {
+		if len(field.args) > 0 {
+			return nil, fmt.Errorf("field %s of %s takes no arguments", field.name, changeType)
+		}
+		var value any
+		leafType := "Int64"
+		switch field.name {
+		case "__typename":
+			value, leafType = changeType, "String"
+		case "seq":
+			value = strconv.FormatInt(change.Seq, 10)
+		case "op":
+			value, leafType = string(change.Op), "ChangeOp"
+		case "id":
+			value, leafType = change.ID, "ID"
+		case "atNs":
+			value = strconv.FormatInt(change.AtNs, 10)
+		case "row":
+			object, found, err := root.resource.Get(change.ID)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				if value, err = g.selectRow(root, object, field); err != nil {
+					return nil, err
+				}
+			}
+			selected = append(selected, graphQLEntry{key: field.key(), value: value})
+			continue
+		default:
+			return nil, fmt.Errorf("unknown field %q on %s", field.name, changeType)
+		}
+		if err := field.leaf(leafType); err != nil {
+			return nil, err
+		}
+		selected = append(selected, graphQLEntry{key: field.key(), value: value})
+	}
+	return selected, nil
+}
+
+// serveSubscription streams a subscription as server-sent events in the
+// distinct connections mode of the GraphQL over SSE protocol: a "next"
+// event with a GraphQLResponse per change, whose id is the seq of the
+// change, so that a reconnecting EventSource resumes after it with
+// Last-Event-ID. A failing subscription ends with its error and a
+// "complete" event.
+func (g *GraphQL) serveSubscription(w http.ResponseWriter, r *http.Request, operation graphQLOperation, variables map[string]any) {
+	resume := int64(-1)
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		seq, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || seq < 0 {
+			WriteHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %q", lastEventID))
+			return
+		}
+		resume = seq
+	}
+	subscription, err := g.subscription(operation, variables, resume)
+	if err != nil {
+		WriteHTTPJSON(w, http.StatusOK, graphQLErrorResponse(err))
+		return
+	}
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+	err = g.follow(r.Context(), subscription, func(seq int64, response GraphQLResponse) error {
+		return writeGraphQLEvent(w, controller, "next", strconv.FormatInt(seq, 10), response)
+	})
+	if r.Context().Err() != nil {
+		return
+	}
+	if err := writeGraphQLEvent(w, controller, "next", "", graphQLErrorResponse(err)); err != nil {
+		return
+	}
+	_ = writeGraphQLEvent(w, controller, "complete", "", nil)
+}
+
+// writeGraphQLEvent writes and flushes a server-sent event with data as JSON,
+// or empty data when nil.
+func writeGraphQLEvent(w http.ResponseWriter, controller *http.ResponseController, event, id string, data any) error {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "event: %s\n", event)
+	if id != "" {
+		fmt.Fprintf(&buffer, "id: %s\n", id)
+	}
+	buffer.WriteString("data:")
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("encode %s event: %w", event, err)
+		}
+		buffer.WriteByte(' ')
+		buffer.Write(encoded)
+	}
+	buffer.WriteString("\n\n")
+	if _, err := w.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	return controller.Flush()
+}
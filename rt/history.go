@@ -0,0 +1,132 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// HistoryTableSuffix is appended to the table name of messages with
+// (proprdb.history) to name their history table.
+const HistoryTableSuffix = "__history"
+
+// HistoryVersion is one recorded version of an object. Data is nil for
+// deletions.
+type HistoryVersion struct {
+	AtNs int64
+	Data []byte
+}
+
+// HistoryTableSQL returns the DDL of a history table, keeping every version
+// of an object by (id, at_ns).
+func HistoryTableSQL(historyTableName string) string {
+	return `CREATE TABLE IF NOT EXISTS "` + historyTableName + `" (id TEXT NOT NULL, at_ns INTEGER NOT NULL, data BLOB, PRIMARY KEY (id, at_ns))`
+}
+
+// EnsureHistoryTable creates the history table of a (proprdb.history) table.
+func EnsureHistoryTable(q DBTX, historyTableName string) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if _, err := q.ExecContext(context.Background(), HistoryTableSQL(historyTableName)); err != nil {
+		return fmt.Errorf("create history table %s: %w", historyTableName, err)
+	}
+	return nil
+}
+
+// RecordHistory stores the version of object id written at atNs, as stored
+// in the data column. Nil data records a deletion.
+func RecordHistory(q DBTX, historyTableName, id string, atNs int64, data []byte) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	_, err := q.ExecContext(context.Background(), `INSERT INTO "`+historyTableName+`" (id, at_ns, data) VALUES (?, ?, ?) ON CONFLICT(id, at_ns) DO UPDATE SET data = excluded.data`, id, atNs, data)
+	if err != nil {
+		return fmt.Errorf("record history %s/%s: %w", historyTableName, id, err)
+	}
+	return nil
+}
+
+// ReadHistory returns the recorded versions of object id in at_ns order.
+func ReadHistory(q DBTX, historyTableName, id string) ([]HistoryVersion, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT at_ns, data FROM "`+historyTableName+`" WHERE id = ? ORDER BY at_ns`, id)
+	if err != nil {
+		return nil, fmt.Errorf("select history %s/%s: %w", historyTableName, id, err)
+	}
+	versions := make([]HistoryVersion, 0)
+	for rows.Next() {
+		var version HistoryVersion
+		if err := rows.Scan(&version.AtNs, &version.Data); err != nil {
+			if closeErr := CloseRows(rows, "history"); closeErr != nil {
+				return nil, fmt.Errorf("scan history %s/%s: %w (additionally, %v)", historyTableName, id, err, closeErr)
+			}
+			return nil, fmt.Errorf("scan history %s/%s: %w", historyTableName, id, err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "history"); closeErr != nil {
+			return nil, fmt.Errorf("iterate history %s/%s: %w (additionally, %v)", historyTableName, id, err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate history %s/%s: %w", historyTableName, id, err)
+	}
+	if err := CloseRows(rows, "history"); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// ReadHistoryAsOf returns the version of object id current at atNs: the
+// last one written at or before it. It fails with ErrNotFound when the
+// object did not exist or was deleted at atNs.
+func ReadHistoryAsOf(q DBTX, historyTableName, id string, atNs int64) (HistoryVersion, error) {
+	if q == nil {
+		return HistoryVersion{}, errors.New("nil DBTX")
+	}
+	version := HistoryVersion{}
+	err := q.QueryRowContext(context.Background(), `SELECT at_ns, data FROM "`+historyTableName+`" WHERE id = ? AND at_ns <= ? ORDER BY at_ns DESC LIMIT 1`, id, atNs).Scan(&version.AtNs, &version.Data)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && version.Data == nil) {
+		return HistoryVersion{}, fmt.Errorf("%s/%s as of %d: %w", historyTableName, id, atNs, ErrNotFound)
+	}
+	if err != nil {
+		return HistoryVersion{}, fmt.Errorf("select history %s/%s as of %d: %w", historyTableName, id, atNs, err)
+	}
+	return version, nil
+}
+
+// HistoryChangedIDs returns the ids of objects with versions recorded after
+// sinceNs, in id order.
+func HistoryChangedIDs(q DBTX, historyTableName string, sinceNs int64) ([]string, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT DISTINCT id FROM "`+historyTableName+`" WHERE at_ns > ? ORDER BY id`, sinceNs)
+	if err != nil {
+		return nil, fmt.Errorf("select changed ids from %s: %w", historyTableName, err)
+	}
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			if closeErr := CloseRows(rows, "history"); closeErr != nil {
+				return nil, fmt.Errorf("scan changed id from %s: %w (additionally, %v)", historyTableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("scan changed id from %s: %w", historyTableName, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "history"); closeErr != nil {
+			return nil, fmt.Errorf("iterate changed ids from %s: %w (additionally, %v)", historyTableName, err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate changed ids from %s: %w", historyTableName, err)
+	}
+	if err := CloseRows(rows, "history"); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
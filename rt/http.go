@@ -0,0 +1,398 @@
+package proprdbrt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// HTTPMaxBodyBytes limits request bodies accepted by generated HTTP handlers.
+const HTTPMaxBodyBytes = 16 << 20
+
+// HTTPFilterParameter is the list query parameter holding an AIP-160
+// filter, unless a column has its name.
+const HTTPFilterParameter = "filter"
+
+// HTTPColumn is a projected column that list requests may filter on.
+type HTTPColumn struct {
+	Name       string
+	SQLiteType string
+}
+
+// HTTPObject is a row passed between generated tables and the HTTP handler.
+type HTTPObject struct {
+	ID   string
+	AtNs int64
+	Data proto.Message
+}
+
+// HTTPResource exposes one generated table over REST. Generated tables
+// return it from their HTTPResource method.
+type HTTPResource struct {
+	// Path is the collection path, e.g. "/person".
+	Path string
+	// TableName is the table of the rows, whose changes GraphQL
+	// subscriptions follow.
+	TableName string
+	// Columns lists the projected columns list requests may filter on.
+	Columns []HTTPColumn
+	// FilterColumns lists the fields the AIP-160 filter parameter of list
+	// requests may compare; see ParseAIPFilter.
+	FilterColumns []FilterColumn
+	New           func() proto.Message
+	List          func(where string, args ...any) ([]HTTPObject, error)
+	Get           func(id string) (HTTPObject, bool, error)
+	Create        func(data proto.Message) (HTTPObject, error)
+	Replace       func(id string, data proto.Message) (HTTPObject, error)
+	Delete        func(id string) error
+	// ReplaceIfRevision and DeleteIfRevision serve requests with an If-Match
+	// header, writing only if the row is still at revision. When they are
+	// nil, If-Match is checked against the row read before the write.
+	ReplaceIfRevision func(id, revision string, data proto.Message) (HTTPObject, error)
+	DeleteIfRevision  func(id, revision string) error
+}
+
+// httpRow is the JSON representation of a row. Data holds the protojson
+// encoding of the object.
+type httpRow struct {
+	ID       string          `json:"id"`
+	AtNs     int64           `json:"atNs"`
+	Revision string          `json:"revision"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func newHTTPRow(object HTTPObject) (httpRow, error) {
+	dataJSON, err := protojson.Marshal(object.Data)
+	if err != nil {
+		return httpRow{}, fmt.Errorf("marshal %s: %w", object.ID, err)
+	}
+	return httpRow{ID: object.ID, AtNs: object.AtNs, Revision: Revision(object.AtNs, object.Data), Data: dataJSON}, nil
+}
+
+// NewHTTPHandler serves REST routes for resources:
+//
+//	GET    <path>       list rows, filtered by ?column=value query parameters
+//	                    and an AIP-160 ?filter= expression
+//	POST   <path>       insert the protojson body
+//	GET    <path>/{id}  read one row
+//	PUT    <path>/{id}  replace an existing row with the protojson body
+//	DELETE <path>/{id}  delete an existing row
+//
+// Rows are returned as {"id", "atNs", "revision", "data"} with data in
+// protojson. Single row responses carry the revision as their ETag, and PUT
+// and DELETE with an If-Match header fail with 412 Precondition Failed once
+// the row has been written since. The OpenAPIDocument of the routes is
+// served at OpenAPIPath.
+func NewHTTPHandler(resources ...HTTPResource) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+OpenAPIPath, serveOpenAPI(resources))
+	for _, resource := range resources {
+		mux.HandleFunc("GET "+resource.Path, resource.serveList)
+		mux.HandleFunc("POST "+resource.Path, resource.serveCreate)
+		mux.HandleFunc("GET "+resource.Path+"/{id}", resource.serveGet)
+		mux.HandleFunc("PUT "+resource.Path+"/{id}", resource.serveReplace)
+		mux.HandleFunc("DELETE "+resource.Path+"/{id}", resource.serveDelete)
+	}
+	return mux
+}
+
+func (h HTTPResource) serveList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := ""
+	if h.acceptsFilter() {
+		filter = query.Get(HTTPFilterParameter)
+		query.Del(HTTPFilterParameter)
+	}
+	where, args, err := HTTPWhere(query, h.Columns)
+	if err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	filterWhere, filterArgs, err := ParseAIPFilter(filter, h.FilterColumns)
+	if err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	if filterWhere != "" {
+		if where != "" {
+			filterWhere = where + " AND (" + filterWhere + ")"
+		}
+		where, args = filterWhere, append(args, filterArgs...)
+	}
+	objects, err := h.List(where, args...)
+	if err != nil {
+		WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	rows := make([]httpRow, 0, len(objects))
+	for _, object := range objects {
+		row, err := newHTTPRow(object)
+		if err != nil {
+			WriteHTTPError(w, http.StatusInternalServerError, err)
+			return
+		}
+		rows = append(rows, row)
+	}
+	WriteHTTPJSON(w, http.StatusOK, rows)
+}
+
+func (h HTTPResource) serveCreate(w http.ResponseWriter, r *http.Request) {
+	data, ok := h.readData(w, r)
+	if !ok {
+		return
+	}
+	object, err := h.Create(data)
+	h.writeObject(w, http.StatusCreated, object, err)
+}
+
+func (h HTTPResource) serveGet(w http.ResponseWriter, r *http.Request) {
+	object, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	h.writeObject(w, http.StatusOK, object, nil)
+}
+
+func (h HTTPResource) serveReplace(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	data, ok := h.readData(w, r)
+	if !ok {
+		return
+	}
+	revision, conditional, err := h.ifMatch(r, existing)
+	if err != nil {
+		WriteHTTPError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+	var object HTTPObject
+	switch {
+	case conditional && h.ReplaceIfRevision != nil:
+		object, err = h.ReplaceIfRevision(existing.ID, revision, data)
+	default:
+		object, err = h.Replace(existing.ID, data)
+	}
+	h.writeObject(w, http.StatusOK, object, err)
+}
+
+func (h HTTPResource) serveDelete(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	revision, conditional, err := h.ifMatch(r, existing)
+	if err != nil {
+		WriteHTTPError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+	switch {
+	case conditional && h.DeleteIfRevision != nil:
+		err = h.DeleteIfRevision(existing.ID, revision)
+	default:
+		err = h.Delete(existing.ID)
+	}
+	if status := httpWriteErrorStatus(err); status != 0 {
+		WriteHTTPError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ifMatch returns the revision the If-Match header of r requires of
+// existing, and whether it requires one at all. "*" matches any existing
+// row. Of a list of entity tags, the one naming the revision of existing is
+// returned, so that the write still fails if the row changes meanwhile.
+func (h HTTPResource) ifMatch(r *http.Request, existing HTTPObject) (string, bool, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return "", false, nil
+	}
+	current := Revision(existing.AtNs, existing.Data)
+	for tag := range strings.SplitSeq(header, ",") {
+		// If-Match compares strongly, so weak tags never match.
+		if strings.TrimSpace(tag) == httpETag(current) {
+			return current, true, nil
+		}
+	}
+	return "", false, fmt.Errorf("%s/%s is at revision %s, not %s: %w", h.Path, existing.ID, current, header, ErrRevisionMismatch)
+}
+
+// acceptsFilter reports whether list requests of h take an AIP-160 filter.
+func (h HTTPResource) acceptsFilter() bool {
+	return len(h.FilterColumns) > 0 && !slices.ContainsFunc(h.Columns, func(column HTTPColumn) bool {
+		return column.Name == HTTPFilterParameter
+	})
+}
+
+// lookup reads the row named by the id path value, writing an error response
+// if it does not exist.
+func (h HTTPResource) lookup(w http.ResponseWriter, r *http.Request) (HTTPObject, bool) {
+	// Ids are not validated here, as tables may use their own IDGenerator;
+	// malformed ids are simply not found.
+	id := r.PathValue("id")
+	object, found, err := h.Get(id)
+	if err != nil {
+		WriteHTTPError(w, http.StatusInternalServerError, err)
+		return HTTPObject{}, false
+	}
+	if !found {
+		WriteHTTPError(w, http.StatusNotFound, fmt.Errorf("%s/%s not found", h.Path, id))
+		return HTTPObject{}, false
+	}
+	return object, true
+}
+
+// readData decodes and validates the request body, writing an error response
+// if it is invalid.
+func (h HTTPResource) readData(w http.ResponseWriter, r *http.Request) (proto.Message, bool) {
+	data := h.New()
+	if err := ReadHTTPMessage(r, data); err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	if validator, ok := data.(interface{ Valid() error }); ok {
+		if err := validator.Valid(); err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, err)
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+func (h HTTPResource) writeObject(w http.ResponseWriter, status int, object HTTPObject, err error) {
+	if errorStatus := httpWriteErrorStatus(err); errorStatus != 0 {
+		WriteHTTPError(w, errorStatus, err)
+		return
+	}
+	row, err := newHTTPRow(object)
+	if err != nil {
+		WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("ETag", httpETag(row.Revision))
+	WriteHTTPJSON(w, status, row)
+}
+
+// httpWriteErrorStatus returns the response status of a failed write, 0 for
+// nil err.
+func httpWriteErrorStatus(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrInvalid) || errors.Is(err, ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUniqueViolation):
+		return http.StatusConflict
+	case errors.Is(err, ErrRevisionMismatch):
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+// httpETag quotes revision as an entity tag.
+func httpETag(revision string) string {
+	return `"` + revision + `"`
+}
+
+// HTTPWhere turns list query parameters into a Select condition. Every
+// parameter must name one of columns; repeated parameters match any of their
+// values. Boolean columns accept true and false.
+func HTTPWhere(query url.Values, columns []HTTPColumn) (string, []any, error) {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	conditions := make([]string, 0, len(names))
+	args := make([]any, 0, len(names))
+	for _, name := range names {
+		index := slices.IndexFunc(columns, func(column HTTPColumn) bool { return column.Name == name })
+		if index < 0 {
+			return "", nil, fmt.Errorf("unknown filter %q", name)
+		}
+		values := query[name]
+		placeholders := make([]string, 0, len(values))
+		for _, value := range values {
+			arg, err := parseHTTPFilterValue(columns[index].SQLiteType, value)
+			if err != nil {
+				return "", nil, fmt.Errorf("filter %s: %w", name, err)
+			}
+			args = append(args, arg)
+			placeholders = append(placeholders, "?")
+		}
+		if len(placeholders) == 1 {
+			conditions = append(conditions, `"`+name+`" = ?`)
+		} else {
+			conditions = append(conditions, `"`+name+`" IN (`+strings.Join(placeholders, ", ")+`)`)
+		}
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+func parseHTTPFilterValue(sqliteType, value string) (any, error) {
+	switch sqliteType {
+	case "INTEGER":
+		switch value {
+		case "true":
+			return 1, nil
+		case "false":
+			return 0, nil
+		}
+		return strconv.ParseInt(value, 10, 64)
+	case "REAL":
+		return strconv.ParseFloat(value, 64)
+	default:
+		return value, nil
+	}
+}
+
+// ReadHTTPMessage decodes the protojson request body into message.
+func ReadHTTPMessage(r *http.Request, message proto.Message) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, HTTPMaxBodyBytes+1))
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	if len(body) > HTTPMaxBodyBytes {
+		return fmt.Errorf("request body exceeds %d bytes", HTTPMaxBodyBytes)
+	}
+	if err := protojson.Unmarshal(body, message); err != nil {
+		return fmt.Errorf("decode request body: %w", err)
+	}
+	return nil
+}
+
+// WriteHTTPJSON writes value as a JSON response with status.
+func WriteHTTPJSON(w http.ResponseWriter, status int, value any) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		WriteHTTPError(w, http.StatusInternalServerError, fmt.Errorf("encode response: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// WriteHTTPError writes err as a JSON error response with status.
+func WriteHTTPError(w http.ResponseWriter, status int, err error) {
+	if err == nil {
+		err = errors.New(http.StatusText(status))
+	}
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
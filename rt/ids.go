@@ -0,0 +1,150 @@
+package proprdbrt
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// IDGenerator creates and validates the ids of generated tables, set via
+// Options.IDGenerator.
+type IDGenerator interface {
+	// NewID returns the id of a row added by Insert.
+	NewID() (string, error)
+	// ValidateID checks ids passed to InsertWithID and UpdateByID.
+	ValidateID(id string) error
+}
+
+// UUIDv7Generator is the default IDGenerator.
+type UUIDv7Generator struct{}
+
+// NewID returns UUIDv7().
+func (UUIDv7Generator) NewID() (string, error) {
+	return UUIDv7()
+}
+
+// ValidateID returns ValidateUUID(id).
+func (UUIDv7Generator) ValidateID(id string) error {
+	return ValidateUUID(id)
+}
+
+// ULIDGenerator is the IDGenerator of (proprdb.id_format) = ID_FORMAT_ULID.
+type ULIDGenerator struct{}
+
+// NewID returns ULID().
+func (ULIDGenerator) NewID() (string, error) {
+	return ULID()
+}
+
+// ValidateID returns ValidateULID(id).
+func (ULIDGenerator) ValidateID(id string) error {
+	return ValidateULID(id)
+}
+
+// ErrNoIDGenerator is returned by Insert of (proprdb.id_format) =
+// ID_FORMAT_CUSTOM tables without Options.IDGenerator.
+var ErrNoIDGenerator = errors.New("no id generator: use InsertWithID or set Options.IDGenerator")
+
+// CustomIDs is the IDGenerator of (proprdb.id_format) = ID_FORMAT_CUSTOM.
+// It accepts any non-empty id and generates none.
+type CustomIDs struct{}
+
+// NewID returns ErrNoIDGenerator.
+func (CustomIDs) NewID() (string, error) {
+	return "", ErrNoIDGenerator
+}
+
+// ValidateID accepts any non-empty id.
+func (CustomIDs) ValidateID(id string) error {
+	if id == "" {
+		return ErrEmptyID
+	}
+	return nil
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID returns a new ULID: a 48-bit millisecond timestamp and 80 random
+// bits in 26 Crockford base32 characters.
+func ULID() (string, error) {
+	var ulidBytes [16]byte
+	if _, err := rand.Read(ulidBytes[6:]); err != nil {
+		return "", fmt.Errorf("generate random bytes for ulid: %w", err)
+	}
+	milliseconds := uint64(time.Now().UnixMilli())
+	for index := range 6 {
+		ulidBytes[index] = byte(milliseconds >> (40 - 8*index))
+	}
+	// 26 characters hold 130 bits, the first 2 of which are zero.
+	builder := strings.Builder{}
+	for index := range 26 {
+		builder.WriteByte(crockfordAlphabet[ulidBits(ulidBytes, index*5-2)])
+	}
+	return builder.String(), nil
+}
+
+// ulidBits returns the 5 bits of value starting at bit offset, counting
+// bits before the value as zero.
+func ulidBits(value [16]byte, offset int) byte {
+	var bits byte
+	for bit := offset; bit < offset+5; bit++ {
+		bits <<= 1
+		if bit >= 0 && value[bit/8]&(0x80>>(bit%8)) != 0 {
+			bits |= 1
+		}
+	}
+	return bits
+}
+
+// ValidateULID checks that id is a canonical, upper case ULID.
+func ValidateULID(id string) error {
+	if len(id) != 26 {
+		return InvalidIDError(fmt.Errorf("invalid ulid %q: expected 26 characters", id))
+	}
+	for index := range len(id) {
+		if strings.IndexByte(crockfordAlphabet, id[index]) < 0 {
+			return InvalidIDError(fmt.Errorf("invalid ulid %q: unexpected character %q", id, id[index]))
+		}
+	}
+	if id[0] > '7' {
+		return InvalidIDError(fmt.Errorf("invalid ulid %q: timestamp overflow", id))
+	}
+	return nil
+}
+
+// SequentialUUIDs is a deterministic IDGenerator for tests. It returns
+// version 7 shaped UUIDs counting up from 1, so the ids also pass
+// ValidateUUID and sort in creation order.
+type SequentialUUIDs struct {
+	next atomic.Uint64
+}
+
+// NewID returns the next id.
+func (s *SequentialUUIDs) NewID() (string, error) {
+	value := s.next.Add(1)
+	return fmt.Sprintf("00000000-0000-7000-8000-%012x", value), nil
+}
+
+// ValidateID returns ValidateUUID(id).
+func (s *SequentialUUIDs) ValidateID(id string) error {
+	return ValidateUUID(id)
+}
+
+// NewID returns a new id from o.IDGenerator, or UUIDv7() when unset.
+func (o Options) NewID() (string, error) {
+	if o.IDGenerator == nil {
+		return UUIDv7()
+	}
+	return o.IDGenerator.NewID()
+}
+
+// ValidateID checks id with o.IDGenerator, or ValidateUUID when unset.
+func (o Options) ValidateID(id string) error {
+	if o.IDGenerator == nil {
+		return ValidateUUID(id)
+	}
+	return o.IDGenerator.ValidateID(id)
+}
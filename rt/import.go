@@ -0,0 +1,147 @@
+package proprdbrt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// ErrTooManyImportErrors is returned by reads that saw more bad records than
+// ImportOptions.MaxErrors.
+var ErrTooManyImportErrors = errors.New("too many bad jsonl records")
+
+// ImportErrorPolicy selects what reads do with bad records: records that do
+// not decode, lack an id or data, or whose data does not unmarshal or fails
+// validation.
+type ImportErrorPolicy int
+
+const (
+	// ImportFailFast fails the read on the first bad record.
+	ImportFailFast ImportErrorPolicy = iota
+	// ImportQuarantine stores bad records in _rejected and continues.
+	ImportQuarantine
+	// ImportSkip drops bad records, collecting them in the ImportReport.
+	ImportSkip
+)
+
+// ImportOptions controls how ReadJSONL and ReadJSONLBulk handle bad records.
+// Errors of the stream itself, such as invalid JSON syntax, framing,
+// compression or manifest errors, always fail the read.
+type ImportOptions struct {
+	// OnError is the policy for bad records, ImportFailFast by default.
+	OnError ImportErrorPolicy
+	// MaxErrors, when positive, fails the read with ErrTooManyImportErrors
+	// once more bad records than this were skipped or quarantined.
+	MaxErrors int
+	// Progress, when set, is called after each record, including skipped
+	// and quarantined ones.
+	Progress JSONLProgressFunc
+	// Tables, when set, limits the read to the records of these tables.
+	// Records of other types are skipped without touching the database.
+	Tables []string
+}
+
+// ImportLineError is a bad record of a read.
+type ImportLineError struct {
+	// Line is the line or frame number of the record.
+	Line int
+	// ID is the record id, empty when it did not decode.
+	ID  string
+	Err error
+}
+
+func (e ImportLineError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ImportLineError) Unwrap() error {
+	return e.Err
+}
+
+// ImportReport lists the bad records a read skipped or quarantined.
+type ImportReport struct {
+	Errors []ImportLineError
+	// Cursor is the cursor of a WriteJSONLSince stream, to pass as cursor to
+	// its next export, when HasCursor.
+	Cursor    int64
+	HasCursor bool
+	// Descriptors holds the types of the descriptors embedded in the
+	// stream, nil when it had none.
+	Descriptors *DynamicTypes
+}
+
+// Err joins the errors of r, or returns nil when there are none.
+func (r ImportReport) Err() error {
+	errs := make([]error, len(r.Errors))
+	for i, lineErr := range r.Errors {
+		errs[i] = lineErr
+	}
+	return errors.Join(errs...)
+}
+
+// ImportGuard applies ImportOptions to the bad records of one read, which
+// are reported to it as RejectedErrors. A nil *ImportGuard fails fast.
+type ImportGuard struct {
+	// Report collects the bad records that were skipped or quarantined.
+	Report ImportReport
+
+	q      DBTX
+	opts   ImportOptions
+	remote string
+	clock  Clock
+}
+
+// NewImportGuard returns a guard for a read from remote, quarantining to q
+// at the time of clock, or the system clock when nil.
+func NewImportGuard(q DBTX, opts ImportOptions, remote string, clock Clock) *ImportGuard {
+	return &ImportGuard{q: q, opts: opts, remote: remote, clock: clock}
+}
+
+// Includes reports whether the read applies records of tableName, which
+// it does for every table unless ImportOptions.Tables lists others.
+func (g *ImportGuard) Includes(tableName string) bool {
+	return g == nil || len(g.opts.Tables) == 0 || slices.Contains(g.opts.Tables, tableName)
+}
+
+// Check returns err unless it is a RejectedError for the record encoded as
+// line, which is then handled according to the policy.
+func (g *ImportGuard) Check(line []byte, id string, lineNumber int, err error) error {
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		return err
+	}
+	if g == nil || g.opts.OnError == ImportFailFast {
+		return rejected.Err
+	}
+	switch g.opts.OnError {
+	case ImportSkip:
+		slog.Warn("skipping bad jsonl record", "id", id, "remote", g.remote, "line", lineNumber, "err", rejected.Err)
+	case ImportQuarantine:
+		slog.Warn("quarantining bad jsonl record", "id", id, "remote", g.remote, "line", lineNumber, "err", rejected.Err)
+		if err := RejectedInsert(g.q, g.remote, line, rejected.Err, Options{Clock: g.clock}.NowNs()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown import error policy %d", g.opts.OnError)
+	}
+	g.Report.Errors = append(g.Report.Errors, ImportLineError{Line: lineNumber, ID: id, Err: rejected.Err})
+	if g.opts.MaxErrors > 0 && len(g.Report.Errors) > g.opts.MaxErrors {
+		return fmt.Errorf("%w: more than %d", ErrTooManyImportErrors, g.opts.MaxErrors)
+	}
+	return nil
+}
+
+// CheckRecord is Check for a record that is no longer available as read.
+func (g *ImportGuard) CheckRecord(record JSONLRecord, lineNumber int, err error) error {
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		return err
+	}
+	line, marshalErr := json.Marshal(record)
+	if marshalErr != nil {
+		return fmt.Errorf("marshal rejected record %s: %w", record.ID, marshalErr)
+	}
+	return g.Check(line, record.ID, lineNumber, err)
+}
@@ -0,0 +1,94 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// StoreSchemaHash records schemaHash as the projection schema of tableName.
+func StoreSchemaHash(q DBTX, tableName, schemaHash string) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if _, err := q.ExecContext(context.Background(), `INSERT INTO `+CoreTableSchemaStateName+` (table_name, schema_hash) VALUES (?, ?) ON CONFLICT(table_name) DO UPDATE SET schema_hash = excluded.schema_hash`, tableName, schemaHash); err != nil {
+		return fmt.Errorf("store schema hash for %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// SchemaStateLock serializes the writes to _proprdb_schema of the tables one
+// InitTables call initializes concurrently, which would otherwise contend
+// on it. A nil SchemaStateLock does not lock.
+type SchemaStateLock struct {
+	mu sync.Mutex
+}
+
+// Lock locks l unless it is nil.
+func (l *SchemaStateLock) Lock() {
+	if l != nil {
+		l.mu.Lock()
+	}
+}
+
+// Unlock unlocks l unless it is nil.
+func (l *SchemaStateLock) Unlock() {
+	if l != nil {
+		l.mu.Unlock()
+	}
+}
+
+// TableInit is the Init of one generated table, named for errors, holding
+// schemaLock while it writes _proprdb_schema.
+type TableInit struct {
+	Name string
+	Init func(schemaLock *SchemaStateLock) error
+}
+
+// InitTables runs the Init of tables, up to concurrency at a time once the
+// core tables exist. With concurrency below 2, or on a *sql.Tx, whose
+// statements run one at a time anyway, tables are initialized in order and
+// the first failure stops the rest. Concurrently, a failure stops tables
+// not started yet, and the failures are joined in table order.
+func InitTables(q DBTX, concurrency int, tables []TableInit) error {
+	if _, isTx := q.(*sql.Tx); concurrency < 2 || isTx {
+		for _, table := range tables {
+			if err := table.Init(nil); err != nil {
+				return fmt.Errorf("init %s table: %w", table.Name, err)
+			}
+		}
+		return nil
+	}
+	if err := EnsureCoreTables(q); err != nil {
+		return err
+	}
+	schemaLock := new(SchemaStateLock)
+	errs := make([]error, len(tables))
+	next := make(chan int)
+	var failed sync.Once
+	stop := make(chan struct{})
+	var workers sync.WaitGroup
+	for range min(concurrency, len(tables)) {
+		workers.Go(func() {
+			for index := range next {
+				if err := tables[index].Init(schemaLock); err != nil {
+					errs[index] = fmt.Errorf("init %s table: %w", tables[index].Name, err)
+					failed.Do(func() { close(stop) })
+				}
+			}
+		})
+	}
+feed:
+	for index := range tables {
+		select {
+		case next <- index:
+		case <-stop:
+			break feed
+		}
+	}
+	close(next)
+	workers.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,183 @@
+package proprdbrt
+
+import (
+	"context"
+	"time"
+)
+
+// Attribute is a key-value pair attached to spans and metrics.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Attribute keys.
+const (
+	AttributeTable     = "proprdb.table"
+	AttributeOperation = "proprdb.operation"
+	AttributeType      = "proprdb.type"
+)
+
+// Operations reported by generated code as span names ("proprdb." +
+// operation) and AttributeOperation values.
+const (
+	OperationInit      = "init"
+	OperationSelect    = "select"
+	OperationInsert    = "insert"
+	OperationUpdate    = "update"
+	OperationDelete    = "delete"
+	OperationSyncWrite = "sync.write"
+	OperationSyncRead  = "sync.read"
+)
+
+// Metric names.
+const (
+	// MetricRowsWritten counts rows written by Insert, Update and Delete.
+	MetricRowsWritten = "proprdb.rows.written"
+	// MetricSyncLinesWritten counts JSONL records acknowledged by WriteJSONL.
+	MetricSyncLinesWritten = "proprdb.sync.lines.written"
+	// MetricSyncLinesRead counts JSONL records of known synced types read by
+	// ReadJSONL.
+	MetricSyncLinesRead = "proprdb.sync.lines.read"
+	// MetricSyncConflicts counts records read by ReadJSONL that are older
+	// than the local state, leaving the outcome to the conflict strategy.
+	MetricSyncConflicts = "proprdb.sync.conflicts"
+	// MetricSyncUnknown counts records read by ReadJSONL that are kept in
+	// _unknown_types, as their type is unknown or not synced yet.
+	MetricSyncUnknown = "proprdb.sync.unknown"
+	// MetricUnknownEvicted counts rows EvictUnknown removed from
+	// _unknown_types, per type.
+	MetricUnknownEvicted = "proprdb.unknown.evicted"
+	// MetricUnknownEvictedBytes counts the data bytes of those rows.
+	MetricUnknownEvictedBytes = "proprdb.unknown.evicted_bytes"
+	// MetricQueryDuration is the latency histogram of executed statements.
+	MetricQueryDuration = "proprdb.query.duration"
+	// MetricFieldScans counts SelectByFields calls, which decode every row.
+	MetricFieldScans = "proprdb.select.field_scans"
+)
+
+// Instrumentation receives traces and metrics from generated code via
+// Options.Instrumentation. It keeps this module free of telemetry
+// dependencies: an OpenTelemetry adapter implements it on top of a
+// trace.Tracer and a metric.Meter.
+type Instrumentation interface {
+	// StartSpan starts a span and returns its context and a function that
+	// ends it, recording err when non-nil.
+	StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, func(err error))
+	// AddInt64 adds delta to the counter name.
+	AddInt64(ctx context.Context, name string, delta int64, attrs ...Attribute)
+	// RecordDuration records duration in the histogram name.
+	RecordDuration(ctx context.Context, name string, duration time.Duration, attrs ...Attribute)
+}
+
+// StartOperation starts the span of a generated operation on tableName,
+// which is empty for CRUD-wide operations. Defer the returned function with
+// a pointer to the operation's error. Successful inserts, updates and
+// deletes count towards MetricRowsWritten.
+func StartOperation(instrumentation Instrumentation, operation, tableName string) func(errp *error) {
+	if instrumentation == nil {
+		return func(*error) {}
+	}
+	attrs := []Attribute{{Key: AttributeOperation, Value: operation}}
+	if tableName != "" {
+		attrs = append(attrs, Attribute{Key: AttributeTable, Value: tableName})
+	}
+	ctx, end := instrumentation.StartSpan(context.Background(), "proprdb."+operation, attrs...)
+	return func(errp *error) {
+		var err error
+		if errp != nil {
+			err = *errp
+		}
+		end(err)
+		if err != nil {
+			return
+		}
+		switch operation {
+		case OperationInsert, OperationUpdate, OperationDelete:
+			instrumentation.AddInt64(ctx, MetricRowsWritten, 1, attrs...)
+		}
+	}
+}
+
+// RecordSyncRead counts a record of tableName read by ReadJSONL and, when
+// the local state at localMaxAtNs is newer, a conflict.
+func RecordSyncRead(instrumentation Instrumentation, tableName string, atNs, localMaxAtNs int64) {
+	if instrumentation == nil {
+		return
+	}
+	ctx := context.Background()
+	attr := Attribute{Key: AttributeTable, Value: tableName}
+	instrumentation.AddInt64(ctx, MetricSyncLinesRead, 1, attr)
+	if atNs < localMaxAtNs {
+		instrumentation.AddInt64(ctx, MetricSyncConflicts, 1, attr)
+	}
+}
+
+// RecordSyncUnknown counts a record of typeName read by ReadJSONL and kept
+// in _unknown_types.
+func RecordSyncUnknown(instrumentation Instrumentation, typeName string) {
+	if instrumentation == nil {
+		return
+	}
+	instrumentation.AddInt64(context.Background(), MetricSyncUnknown, 1, Attribute{Key: AttributeType, Value: typeName})
+}
+
+// RecordUnknownEviction counts the rows and bytes of eviction.
+func RecordUnknownEviction(instrumentation Instrumentation, eviction UnknownEviction) {
+	if instrumentation == nil || eviction.Rows == 0 {
+		return
+	}
+	ctx := context.Background()
+	for typeName, evicted := range eviction.Types {
+		instrumentation.AddInt64(ctx, MetricUnknownEvicted, evicted, Attribute{Key: AttributeType, Value: typeName})
+	}
+	instrumentation.AddInt64(ctx, MetricUnknownEvictedBytes, eviction.Bytes)
+}
+
+// CountSyncLinesWritten wraps progress to count acknowledged records
+// towards MetricSyncLinesWritten.
+func CountSyncLinesWritten(instrumentation Instrumentation, progress ChunkProgressFunc) ChunkProgressFunc {
+	if instrumentation == nil {
+		return progress
+	}
+	var counted int64
+	return func(sent, total int64) {
+		instrumentation.AddInt64(context.Background(), MetricSyncLinesWritten, sent-counted)
+		counted = sent
+		if progress != nil {
+			progress(sent, total)
+		}
+	}
+}
+
+// CountSyncRecordsWritten is CountSyncLinesWritten for a JSONLProgressFunc.
+func CountSyncRecordsWritten(instrumentation Instrumentation, progress JSONLProgressFunc) JSONLProgressFunc {
+	if instrumentation == nil {
+		return progress
+	}
+	var counted int64
+	return func(current JSONLProgress) {
+		instrumentation.AddInt64(context.Background(), MetricSyncLinesWritten, current.Records-counted)
+		counted = current.Records
+		if progress != nil {
+			progress(current)
+		}
+	}
+}
+
+// EffectiveQueryObserver returns the observer generated tables wrap their
+// DBTX with: QueryObserver, plus MetricQueryDuration when Instrumentation
+// is set. It is nil when neither is.
+func (o Options) EffectiveQueryObserver() QueryObserver {
+	if o.Instrumentation == nil {
+		return o.QueryObserver
+	}
+	instrumentation := o.Instrumentation
+	observer := o.QueryObserver
+	return QueryObserverFunc(func(query string, args []any, duration time.Duration, err error) {
+		instrumentation.RecordDuration(context.Background(), MetricQueryDuration, duration)
+		if observer != nil {
+			observer.OnQuery(query, args, duration, err)
+		}
+	})
+}
@@ -0,0 +1,567 @@
+package proprdbrt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ErrJSONLManifest is wrapped when a stream does not match its manifest, or
+// lacks one while JSONLOptions.RequireManifest is set.
+var ErrJSONLManifest = errors.New("jsonl manifest mismatch")
+
+// ErrJSONLSignature is wrapped when JSONLOptions.TrustedKeys is set and a
+// stream is unsigned or not signed by any of them.
+var ErrJSONLSignature = errors.New("invalid jsonl signature")
+
+// ErrJSONLLimit is wrapped when a read stream exceeds
+// JSONLOptions.MaxRecordSize or MaxRecords.
+var ErrJSONLLimit = errors.New("jsonl limit exceeded")
+
+// jsonlSignaturePrefix separates manifest signatures from other uses of a
+// key.
+const jsonlSignaturePrefix = "proprdb-jsonl-manifest-v1\n"
+
+// JSONLCompression selects the compression of streams written by WriteJSONL.
+type JSONLCompression string
+
+const (
+	JSONLCompressionNone JSONLCompression = ""
+	JSONLCompressionGzip JSONLCompression = "gzip"
+	// JSONLCompressionZstd requires JSONLOptions.Zstd.
+	JSONLCompressionZstd JSONLCompression = "zstd"
+)
+
+// JSONLFraming selects how records are delimited within a stream.
+type JSONLFraming string
+
+const (
+	// JSONLFramingLines writes one JSON record per line.
+	JSONLFramingLines JSONLFraming = ""
+	// JSONLFramingLengthPrefixed writes every record after its length as a
+	// 4 byte big-endian integer, for transports that are not line oriented.
+	JSONLFramingLengthPrefixed JSONLFraming = "length-prefixed"
+)
+
+// MaxJSONLFrameSize bounds the records of length-prefixed streams. It keeps
+// the first byte of a frame below any byte a JSON line may start with, which
+// lets ReadJSONL tell the framings apart.
+const MaxJSONLFrameSize = 64 << 20
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// StreamCodec provides a streaming compression format. proprdb does not
+// implement zstd itself, to stay free of dependencies; adapt e.g.
+// github.com/klauspost/compress/zstd.
+type StreamCodec interface {
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// JSONLOptions configures the streams of WriteJSONL and ReadJSONL. Reading
+// detects compression and framing, so it only needs Zstd for zstd streams.
+type JSONLOptions struct {
+	// Compression compresses written streams.
+	Compression JSONLCompression
+	// GzipLevel is the level of JSONLCompressionGzip, the default when 0.
+	GzipLevel int
+	// Zstd implements JSONLCompressionZstd.
+	Zstd StreamCodec
+	// Framing delimits written records.
+	Framing JSONLFraming
+	// Manifest ends written streams with a JSONLManifest record.
+	Manifest bool
+	// RequireManifest makes reading fail with ErrJSONLManifest for streams
+	// without a manifest, e.g. truncated ones. CRUD.ReadJSONL then imports
+	// the stream in one transaction, so a failed check imports nothing.
+	RequireManifest bool
+	// SigningKey, when set, signs the manifest of written streams, which
+	// implies Manifest. The manifest hash covers the records.
+	SigningKey ed25519.PrivateKey
+	// TrustedKeys, when set, makes reading fail with ErrJSONLSignature
+	// unless the manifest is signed by one of them. Like RequireManifest,
+	// CRUD.ReadJSONL then imports in one transaction.
+	TrustedKeys []ed25519.PublicKey
+	// MaxRecordsPerSecond, when positive, paces writing and reading: chunks
+	// are written, and records applied, no faster than this on average.
+	MaxRecordsPerSecond float64
+	// MaxBytesPerFlush, when positive, ends written chunks early so that
+	// each flush carries at most this many uncompressed bytes. A larger
+	// record is written in a chunk of its own.
+	MaxBytesPerFlush int
+	// EmbedDescriptors starts written streams that have records with a
+	// {"descriptors": ...} record holding Descriptors, serialized, so that
+	// readers can check the shape of the data and decode types they lack.
+	EmbedDescriptors bool
+	// Descriptors are the files embedded by EmbedDescriptors. Generated
+	// CRUDs default them to the files of their synced types.
+	Descriptors *descriptorpb.FileDescriptorSet
+	// MaxRecordSize bounds the bytes of a read record, so that a single
+	// huge line cannot exhaust memory. 0 means MaxJSONLFrameSize, which
+	// also bounds length-prefixed frames whatever the limit.
+	MaxRecordSize int
+	// MaxRecords, when positive, makes reading fail once a stream has more
+	// records than this.
+	MaxRecords int
+	// ScanLines reads line framed streams line by line rather than as a
+	// sequence of JSON values. Records then must not span lines, blank
+	// lines are skipped, and lines that are not JSON are rejected through
+	// the import error policy instead of ending the read.
+	ScanLines bool
+	// RecordVersion is the JSONLRecordVersion written, lower for peers that
+	// do not read the newest one yet. 0 writes the newest.
+	RecordVersion int
+	// SectionHeaders starts the records of each table in written streams
+	// with a {"section": ...} JSONLSection record, for tooling that splits
+	// or summarizes exports. Readers skip sections; peers that predate them
+	// reject them as invalid records.
+	SectionHeaders bool
+}
+
+// WritesManifest reports whether written streams end with a manifest.
+func (o JSONLOptions) WritesManifest() bool {
+	return o.Manifest || o.SigningKey != nil
+}
+
+// RequiresManifest reports whether reading rejects streams without a valid
+// manifest.
+func (o JSONLOptions) RequiresManifest() bool {
+	return o.RequireManifest || len(o.TrustedKeys) > 0
+}
+
+// JSONLManifest summarizes the records of a stream. It is written as the
+// last record, {"manifest": {...}}, and checked by ReadJSONL.
+type JSONLManifest struct {
+	Records int64 `json:"records"`
+	// Types counts the records per type name.
+	Types map[string]int64 `json:"types"`
+	// SHA256 is the hex SHA-256 of the JSON of the records, each followed
+	// by a newline, independent of compression and framing.
+	SHA256 string `json:"sha256"`
+	// Signature is the base64 ed25519 signature of the manifest without
+	// it, when written with JSONLOptions.SigningKey.
+	Signature string `json:"signature,omitempty"`
+	// Cursor repeats the cursor record of a WriteJSONLSince stream.
+	Cursor *int64 `json:"cursor,omitempty"`
+	// DescriptorsSHA256 is the hex SHA-256 of the embedded descriptors.
+	DescriptorsSHA256 string `json:"descriptorsSha256,omitempty"`
+}
+
+// signedBytes returns what Signature signs.
+func (m JSONLManifest) signedBytes() ([]byte, error) {
+	m.Signature = ""
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("encode jsonl manifest: %w", err)
+	}
+	return append([]byte(jsonlSignaturePrefix), encoded...), nil
+}
+
+// sign sets Signature with key, which must be a valid ed25519 key.
+func (m *JSONLManifest) sign(key ed25519.PrivateKey) error {
+	signed, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, signed))
+	return nil
+}
+
+// verifySignature checks that one of keys signed m.
+func (m JSONLManifest) verifySignature(keys []ed25519.PublicKey) error {
+	if m.Signature == "" {
+		return fmt.Errorf("%w: manifest is not signed", ErrJSONLSignature)
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: decode signature: %v", ErrJSONLSignature, err)
+	}
+	signed, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if len(key) == ed25519.PublicKeySize && ed25519.Verify(key, signed, signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: manifest is not signed by a trusted key", ErrJSONLSignature)
+}
+
+// JSONLSection heads the records of one table in streams written with
+// JSONLOptions.SectionHeaders.
+type JSONLSection struct {
+	Table string `json:"table"`
+	// Records is the number of records of the table that follow.
+	Records int64 `json:"records"`
+}
+
+// jsonlLine is a record, descriptors, a section, a cursor or a manifest as
+// read from a stream.
+type jsonlLine struct {
+	JSONLRecord
+	Descriptors []byte         `json:"descriptors,omitempty"`
+	Section     *JSONLSection  `json:"section,omitempty"`
+	Cursor      *int64         `json:"cursor,omitempty"`
+	Manifest    *JSONLManifest `json:"manifest,omitempty"`
+}
+
+// jsonlDigest accumulates the manifest of the records of a stream.
+type jsonlDigest struct {
+	hash     hash.Hash
+	manifest JSONLManifest
+}
+
+func newJSONLDigest() *jsonlDigest {
+	return &jsonlDigest{hash: sha256.New(), manifest: JSONLManifest{Types: make(map[string]int64)}}
+}
+
+// add counts the record encoded as data, of type name from its data, and
+// returns that type name.
+func (d *jsonlDigest) add(encoded []byte, data json.RawMessage) string {
+	d.hash.Write(encoded)
+	d.hash.Write([]byte{'\n'})
+	d.manifest.Records++
+	// Records without a type fail to import anyway; count them under "".
+	typeName, _ := TypeNameFromAnyJSON(data)
+	d.manifest.Types[typeName]++
+	return typeName
+}
+
+func (d *jsonlDigest) result() JSONLManifest {
+	manifest := d.manifest
+	manifest.SHA256 = hex.EncodeToString(d.hash.Sum(nil))
+	return manifest
+}
+
+// verify compares the records read so far with manifest.
+func (d *jsonlDigest) verify(manifest JSONLManifest) error {
+	actual := d.result()
+	switch {
+	case actual.Records != manifest.Records:
+		return fmt.Errorf("%w: read %d records, manifest lists %d", ErrJSONLManifest, actual.Records, manifest.Records)
+	case !maps.Equal(actual.Types, manifest.Types):
+		return fmt.Errorf("%w: read records per type %v, manifest lists %v", ErrJSONLManifest, actual.Types, manifest.Types)
+	case actual.SHA256 != manifest.SHA256:
+		return fmt.Errorf("%w: content hash %s, manifest lists %s", ErrJSONLManifest, actual.SHA256, manifest.SHA256)
+	}
+	return nil
+}
+
+// compress wraps w in the compressor of o. The returned close function
+// finishes the compressed stream without closing w.
+func (o JSONLOptions) compress(w io.Writer) (io.Writer, func() error, error) {
+	switch o.Compression {
+	case JSONLCompressionNone:
+		return w, func() error { return nil }, nil
+	case JSONLCompressionGzip:
+		level := o.GzipLevel
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		writer, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create gzip writer: %w", err)
+		}
+		return writer, writer.Close, nil
+	case JSONLCompressionZstd:
+		if o.Zstd == nil {
+			return nil, nil, errors.New("zstd compression without JSONLOptions.Zstd")
+		}
+		writer, err := o.Zstd.NewWriter(w)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create zstd writer: %w", err)
+		}
+		return writer, writer.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown jsonl compression %q", o.Compression)
+	}
+}
+
+// appendFrame appends the JSON encoded record to buffer in the framing of o.
+func (o JSONLOptions) appendFrame(buffer *bytes.Buffer, encoded []byte) error {
+	switch o.Framing {
+	case JSONLFramingLines:
+		buffer.Write(encoded)
+		buffer.WriteByte('\n')
+	case JSONLFramingLengthPrefixed:
+		if len(encoded) > MaxJSONLFrameSize {
+			return fmt.Errorf("record of %d bytes exceeds the frame size limit", len(encoded))
+		}
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(len(encoded))))
+		buffer.Write(encoded)
+	default:
+		return fmt.Errorf("unknown jsonl framing %q", o.Framing)
+	}
+	return nil
+}
+
+// ReadJSONLWithOptions is ReadJSONL using opts.Zstd for zstd streams and
+// enforcing opts.RequireManifest. A manifest is checked after the records
+// before it have been visited.
+func ReadJSONLWithOptions(r io.Reader, opts JSONLOptions, visit func(JSONLRecord, int) error) error {
+	return ReadJSONLGuarded(context.Background(), r, opts, nil, visit)
+}
+
+// ReadJSONLGuarded is ReadJSONLWithOptions passing records that do not
+// decode, and records visit fails with a RejectedError, to guard. The cursor
+// of a WriteJSONLSince stream is recorded in guard.Report. Embedded
+// descriptors fail the read with ErrSchemaMismatch when they do not match the
+// linked-in types; otherwise guard stores them in _descriptors and reports
+// them. It stops with the
+// error of ctx before the next record once ctx is done.
+func ReadJSONLGuarded(ctx context.Context, r io.Reader, opts JSONLOptions, guard *ImportGuard, visit func(JSONLRecord, int) error) (err error) {
+	buffered := bufio.NewReader(r)
+	// A short stream simply matches no magic.
+	magic, _ := buffered.Peek(len(zstdMagic))
+	var decompressor io.ReadCloser
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		if decompressor, err = gzip.NewReader(buffered); err != nil {
+			return fmt.Errorf("open gzip jsonl stream: %w", err)
+		}
+	case bytes.HasPrefix(magic, zstdMagic):
+		if opts.Zstd == nil {
+			return errors.New("zstd compressed jsonl stream without JSONLOptions.Zstd")
+		}
+		if decompressor, err = opts.Zstd.NewReader(buffered); err != nil {
+			return fmt.Errorf("open zstd jsonl stream: %w", err)
+		}
+	}
+	stream := buffered
+	if decompressor != nil {
+		defer func() {
+			if closeErr := decompressor.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("close jsonl decompressor: %w", closeErr)
+			}
+		}()
+		stream = bufio.NewReader(decompressor)
+	}
+
+	// separator is the size of the newline or frame header of a record.
+	read, unit, separator := readJSONLines, "line", int64(1)
+	if opts.ScanLines {
+		read = scanJSONLines
+	}
+	first, err := stream.Peek(1)
+	switch {
+	case errors.Is(err, io.EOF):
+		// An empty stream has no manifest either.
+	case err != nil:
+		return fmt.Errorf("read jsonl stream: %w", err)
+	case !bytes.ContainsRune([]byte("{ \t\n\r"), rune(first[0])):
+		read, unit, separator = readJSONLFrames, "frame", 4
+	}
+	digest := newJSONLDigest()
+	var manifest *JSONLManifest
+	var cursor *int64
+	var descriptors string
+	records := 0
+	var recordBytes int64
+	pacer := newSyncPacer(ctx, opts)
+	if err := read(stream, opts.maxRecordSize(), func(raw []byte, number int, offset int64) error {
+		if manifest != nil {
+			return fmt.Errorf("%w: jsonl %s %d follows the manifest", ErrJSONLManifest, unit, number)
+		}
+		var line jsonlLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			return guard.Check(raw, "", number, Reject(fmt.Errorf("decode jsonl %s %d at byte %d: %w", unit, number, offset, err)))
+		}
+		if line.Manifest != nil {
+			manifest = line.Manifest
+			return nil
+		}
+		if line.Descriptors != nil {
+			if descriptors != "" || records > 0 {
+				return fmt.Errorf("jsonl descriptors at %s %d do not start the stream", unit, number)
+			}
+			descriptors = descriptorsHash(line.Descriptors)
+			return guard.checkDescriptors(line.Descriptors)
+		}
+		if line.Section != nil {
+			// Sections only group the records for tooling; the manifest
+			// covers the records themselves.
+			return nil
+		}
+		if line.Cursor != nil {
+			if cursor != nil {
+				return fmt.Errorf("duplicate jsonl cursor at %s %d", unit, number)
+			}
+			cursor = line.Cursor
+			if guard != nil {
+				guard.Report.Cursor, guard.Report.HasCursor = *cursor, true
+			}
+			return nil
+		}
+		typeName := digest.add(raw, line.Data)
+		records++
+		recordBytes += int64(len(raw)) + separator
+		if opts.MaxRecords > 0 && records > opts.MaxRecords {
+			return fmt.Errorf("%w: jsonl %s %d at byte %d exceeds the limit of %d records", ErrJSONLLimit, unit, number, offset, opts.MaxRecords)
+		}
+		if err := pacer.wait(1); err != nil {
+			return fmt.Errorf("read jsonl %s %d: %w", unit, number, err)
+		}
+		err := upgradeJSONLRecord(&line.JSONLRecord)
+		if err == nil {
+			err = visit(line.JSONLRecord, number)
+		}
+		if err = guard.Check(raw, line.ID, number, err); err == nil && guard != nil && guard.opts.Progress != nil {
+			guard.opts.Progress(JSONLProgress{Records: int64(records), Bytes: recordBytes, Type: typeName})
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	switch {
+	case manifest == nil && len(opts.TrustedKeys) > 0:
+		return fmt.Errorf("%w: stream has no manifest", ErrJSONLSignature)
+	case manifest == nil && opts.RequireManifest:
+		return fmt.Errorf("%w: stream has no manifest", ErrJSONLManifest)
+	case manifest == nil:
+		return nil
+	case len(opts.TrustedKeys) > 0:
+		if err := manifest.verifySignature(opts.TrustedKeys); err != nil {
+			return err
+		}
+	}
+	switch {
+	case manifest.Cursor == nil && cursor == nil:
+	case manifest.Cursor == nil || cursor == nil || *manifest.Cursor != *cursor:
+		return fmt.Errorf("%w: cursor does not match the manifest", ErrJSONLManifest)
+	}
+	if manifest.DescriptorsSHA256 != descriptors {
+		return fmt.Errorf("%w: descriptors do not match the manifest", ErrJSONLManifest)
+	}
+	return digest.verify(*manifest)
+}
+
+// maxRecordSize returns the MaxRecordSize in effect.
+func (o JSONLOptions) maxRecordSize() int {
+	if o.MaxRecordSize <= 0 {
+		return MaxJSONLFrameSize
+	}
+	return o.MaxRecordSize
+}
+
+// errJSONLRecordTooLarge stops a json.Decoder reading past the size limit.
+var errJSONLRecordTooLarge = errors.New("jsonl record too large")
+
+// boundedValueReader feeds decoder, failing once the value it decodes has
+// grown beyond limit bytes, so that it never buffers much more than that.
+type boundedValueReader struct {
+	r       io.Reader
+	decoder *json.Decoder
+	read    int64
+	limit   int64
+}
+
+func (b *boundedValueReader) Read(p []byte) (int, error) {
+	if b.read-b.decoder.InputOffset() > b.limit {
+		return 0, errJSONLRecordTooLarge
+	}
+	n, err := b.r.Read(p[:min(int64(len(p)), b.limit+1)])
+	b.read += int64(n)
+	return n, err
+}
+
+// readJSONLines reads a sequence of JSON values, numbering them as lines.
+func readJSONLines(r *bufio.Reader, limit int, visit func([]byte, int, int64) error) error {
+	bounded := &boundedValueReader{r: r, limit: int64(limit)}
+	decoder := json.NewDecoder(bounded)
+	bounded.decoder = decoder
+	lineNumber := 0
+	for {
+		lineNumber++
+		// More skips the whitespace before the next value.
+		decoder.More()
+		offset := decoder.InputOffset()
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case errors.Is(err, errJSONLRecordTooLarge) || err == nil && len(raw) > limit:
+			return fmt.Errorf("%w: jsonl line %d at byte %d is larger than %d bytes", ErrJSONLLimit, lineNumber, offset, limit)
+		case err != nil:
+			return fmt.Errorf("decode jsonl line %d at byte %d: %w", lineNumber, offset, err)
+		}
+		if err := visit(raw, lineNumber, offset); err != nil {
+			return err
+		}
+	}
+}
+
+// scanJSONLines implements JSONLOptions.ScanLines.
+func scanJSONLines(r *bufio.Reader, limit int, visit func([]byte, int, int64) error) error {
+	var offset int64
+	for lineNumber := 1; ; lineNumber++ {
+		var line []byte
+		var err error
+		for {
+			var chunk []byte
+			chunk, err = r.ReadSlice('\n')
+			line = append(line, chunk...)
+			if len(bytes.TrimRight(line, "\r\n")) > limit {
+				return fmt.Errorf("%w: jsonl line %d at byte %d is larger than %d bytes", ErrJSONLLimit, lineNumber, offset, limit)
+			}
+			if !errors.Is(err, bufio.ErrBufferFull) {
+				break
+			}
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read jsonl line %d at byte %d: %w", lineNumber, offset, err)
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if visitErr := visit(trimmed, lineNumber, offset); visitErr != nil {
+				return visitErr
+			}
+		}
+		if err != nil {
+			return nil
+		}
+		offset += int64(len(line))
+	}
+}
+
+func readJSONLFrames(r *bufio.Reader, limit int, visit func([]byte, int, int64) error) error {
+	var header [4]byte
+	var offset int64
+	for frameNumber := 1; ; frameNumber++ {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read jsonl frame %d at byte %d: %w", frameNumber, offset, err)
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > MaxJSONLFrameSize {
+			return fmt.Errorf("jsonl frame %d at byte %d of %d bytes exceeds the frame size limit", frameNumber, offset, size)
+		}
+		if int(size) > limit {
+			return fmt.Errorf("%w: jsonl frame %d at byte %d is larger than %d bytes", ErrJSONLLimit, frameNumber, offset, limit)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return fmt.Errorf("read jsonl frame %d at byte %d: %w", frameNumber, offset, err)
+		}
+		if err := visit(payload, frameNumber, offset); err != nil {
+			return err
+		}
+		offset += int64(len(header)) + int64(size)
+	}
+}
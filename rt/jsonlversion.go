@@ -0,0 +1,86 @@
+package proprdbrt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// JSONLRecordVersion is the newest shape of JSONLRecord this package reads
+// and writes. Records carry it as "v"; records without one are version 1,
+// which is also written without it, so version 1 streams stay readable by
+// peers that predate versioning.
+const JSONLRecordVersion = 1
+
+// ErrJSONLRecordVersion is returned, as a RejectedError, for records newer
+// than JSONLRecordVersion, so that an ImportGuard can skip or quarantine
+// them until this peer is upgraded.
+var ErrJSONLRecordVersion = errors.New("unsupported jsonl record version")
+
+// jsonlRecordUpgrades[v] converts a version v record to version v+1 in
+// place, and jsonlRecordDowngrades[v] a version v record to version v-1. A
+// new record shape bumps JSONLRecordVersion and registers both, so that
+// streams of older peers still read, and JSONLOptions.RecordVersion keeps
+// writing the older shape until every peer reads the new one.
+var (
+	jsonlRecordUpgrades   = map[int]func(*JSONLRecord) error{}
+	jsonlRecordDowngrades = map[int]func(*JSONLRecord) error{}
+)
+
+// RecordVersion returns the version of r, 1 when it has none.
+func (r JSONLRecord) RecordVersion() int {
+	return max(r.Version, 1)
+}
+
+// setRecordVersion sets the version of record, leaving version 1 unset.
+func setRecordVersion(record *JSONLRecord, version int) {
+	record.Version = version
+	if version == 1 {
+		record.Version = 0
+	}
+}
+
+// recordVersion returns the version written with o, validating it.
+func (o JSONLOptions) recordVersion() (int, error) {
+	switch {
+	case o.RecordVersion == 0:
+		return JSONLRecordVersion, nil
+	case o.RecordVersion < 1 || o.RecordVersion > JSONLRecordVersion:
+		return 0, fmt.Errorf("%w %d: this peer writes versions 1 to %d", ErrJSONLRecordVersion, o.RecordVersion, JSONLRecordVersion)
+	}
+	return o.RecordVersion, nil
+}
+
+// upgradeJSONLRecord converts record as read to JSONLRecordVersion.
+func upgradeJSONLRecord(record *JSONLRecord) error {
+	version := record.RecordVersion()
+	if version > JSONLRecordVersion {
+		return Reject(fmt.Errorf("%w %d of %s: this peer reads versions up to %d", ErrJSONLRecordVersion, version, record.ID, JSONLRecordVersion))
+	}
+	for ; version < JSONLRecordVersion; version++ {
+		upgrade, ok := jsonlRecordUpgrades[version]
+		if !ok {
+			return fmt.Errorf("no upgrade of jsonl record version %d", version)
+		}
+		if err := upgrade(record); err != nil {
+			return Reject(fmt.Errorf("upgrade jsonl record %s from version %d: %w", record.ID, version, err))
+		}
+	}
+	setRecordVersion(record, JSONLRecordVersion)
+	return nil
+}
+
+// downgradeJSONLRecord returns record, which is JSONLRecordVersion,
+// converted to version.
+func downgradeJSONLRecord(record JSONLRecord, version int) (JSONLRecord, error) {
+	for current := JSONLRecordVersion; current > version; current-- {
+		downgrade, ok := jsonlRecordDowngrades[current]
+		if !ok {
+			return JSONLRecord{}, fmt.Errorf("no downgrade of jsonl record version %d", current)
+		}
+		if err := downgrade(&record); err != nil {
+			return JSONLRecord{}, fmt.Errorf("downgrade jsonl record %s to version %d: %w", record.ID, current-1, err)
+		}
+	}
+	setRecordVersion(&record, version)
+	return record, nil
+}
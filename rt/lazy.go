@@ -0,0 +1,40 @@
+package proprdbrt
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// LazyData holds a stored data column and decodes it when Data is first
+// called, so rows that are never read in full skip the protobuf decoding.
+type LazyData[T proto.Message] struct {
+	opts       Options
+	stored     []byte
+	newMessage func() T
+	once       sync.Once
+	data       T
+	err        error
+}
+
+// NewLazyData returns a LazyData decoding stored per opts into a message
+// allocated by newMessage.
+func NewLazyData[T proto.Message](opts Options, stored []byte, newMessage func() T) *LazyData[T] {
+	return &LazyData[T]{opts: opts, stored: stored, newMessage: newMessage}
+}
+
+// Data decodes the message on the first call and returns the cached result
+// afterwards. It is safe for concurrent use.
+func (d *LazyData[T]) Data() (T, error) {
+	d.once.Do(func() {
+		message := d.newMessage()
+		if err := UnmarshalData(d.opts, d.stored, message); err != nil {
+			d.err = fmt.Errorf("unmarshal %s row: %w", message.ProtoReflect().Descriptor().Name(), err)
+			return
+		}
+		d.data = message
+		d.stored = nil
+	})
+	return d.data, d.err
+}
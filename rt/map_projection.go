@@ -0,0 +1,89 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MapEntryValue is the value type of map fields projected with
+// (proprdb.external).
+type MapEntryValue interface {
+	~string | ~int64
+}
+
+// EnsureMapProjectionTable creates the side table holding the entries of a
+// projected map field: one (id, key, value) row per entry, indexed by
+// (key, value) for lookups by entry.
+func EnsureMapProjectionTable(q DBTX, sideTableName, valueSQLiteType string) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	ctx := context.Background()
+	createSQL, indexSQL := MapProjectionTableSQL(sideTableName, valueSQLiteType)
+	if _, err := q.ExecContext(ctx, createSQL); err != nil {
+		return fmt.Errorf("create map projection table %s: %w", sideTableName, err)
+	}
+	if _, err := q.ExecContext(ctx, indexSQL); err != nil {
+		return fmt.Errorf("create map projection index for %s: %w", sideTableName, err)
+	}
+	return nil
+}
+
+// MapProjectionTableSQL returns the DDL of a map projection side table and
+// its key/value index.
+func MapProjectionTableSQL(sideTableName, valueSQLiteType string) (createSQL, indexSQL string) {
+	createSQL = `CREATE TABLE IF NOT EXISTS "` + sideTableName + `" (id TEXT NOT NULL, key TEXT NOT NULL, value ` + valueSQLiteType + ` NOT NULL, PRIMARY KEY (id, key))`
+	indexSQL = `CREATE INDEX IF NOT EXISTS "idx_` + sideTableName + `__key_value" ON "` + sideTableName + `" (key, value)`
+	return createSQL, indexSQL
+}
+
+// ReplaceMapEntries replaces the projected entries of object id with entries.
+func ReplaceMapEntries[V MapEntryValue](q DBTX, sideTableName, id string, entries map[string]V) error {
+	if err := DeleteMapEntries(q, sideTableName, id); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	insertSQL := `INSERT INTO "` + sideTableName + `" (id, key, value) VALUES (?, ?, ?)`
+	for key, value := range entries {
+		if _, err := q.ExecContext(ctx, insertSQL, id, key, value); err != nil {
+			return fmt.Errorf("insert map entry %s/%s[%s]: %w", sideTableName, id, key, err)
+		}
+	}
+	return nil
+}
+
+// DeleteMapEntries removes the projected entries of object id.
+func DeleteMapEntries(q DBTX, sideTableName, id string) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if _, err := q.ExecContext(context.Background(), `DELETE FROM "`+sideTableName+`" WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete map entries %s/%s: %w", sideTableName, id, err)
+	}
+	return nil
+}
+
+// MapEntryWhere returns a Select condition matching objects whose projected
+// map has key set to one of values, or key set at all if values is empty.
+// Conditions combine with AND, e.g. to match several labels.
+func MapEntryWhere[V MapEntryValue](sideTableName, key string, values ...V) (string, []any) {
+	args := make([]any, 0, len(values)+1)
+	args = append(args, key)
+	condition := `id IN (SELECT id FROM "` + sideTableName + `" WHERE key = ?`
+	switch len(values) {
+	case 0:
+	case 1:
+		condition += ` AND value = ?`
+		args = append(args, values[0])
+	default:
+		placeholders := slices.Repeat([]string{"?"}, len(values))
+		condition += ` AND value IN (` + strings.Join(placeholders, ", ") + `)`
+		for _, value := range values {
+			args = append(args, value)
+		}
+	}
+	return condition + `)`, args
+}
@@ -0,0 +1,641 @@
+// Package memdb is an in-memory stand-in for generated proprdb tables,
+// backed by plain Go maps, so application unit tests run without a cgo
+// SQLite driver.
+//
+// A Table has the same write semantics as the generated table: ids are
+// UUIDv7 strings, every write gets a fresh at_ns, deletes leave tombstones
+// (or soft-delete rows), and writes are validated like the generated code
+// does. Select understands the simple where clauses applications typically
+// pass: comparisons of a column with a ? placeholder, IN lists and IS
+// [NOT] NULL, joined by AND. Anything else fails with ErrUnsupportedWhere.
+//
+// Generated code (plugin parameter memdb=true) provides NewXMemTable
+// constructors filling Config for each table.
+package memdb
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	rt "github.com/fingon/proprdb/rt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// ErrUnsupportedWhere is returned for where clauses Table cannot evaluate.
+var ErrUnsupportedWhere = rt.ErrUnsupportedWhere
+
+// Config describes one generated table to NewTable.
+type Config[T proto.Message, R any] struct {
+	TableName string
+	TypeName  string
+	// NewRow builds the generated row type. deletedAtNs is 0 for live rows.
+	NewRow func(id string, atNs, deletedAtNs int64, data T) R
+	// RowParts returns the id and data of a generated row.
+	RowParts func(row R) (string, T)
+	// Validate checks data before writes, nil when the table does not
+	// validate writes.
+	Validate func(data T) error
+	// Columns lists the projected columns where clauses and ordering may
+	// use, besides id, at_ns and deleted_at_ns.
+	Columns []string
+	// Values returns the values of Columns for data, nil for unset optional
+	// fields.
+	Values func(data T) []any
+	// SoftDelete keeps deleted rows, hidden from Select, like
+	// (proprdb.soft_delete) tables.
+	SoftDelete bool
+	// History keeps every version of rows for History and GetAsOf, like
+	// (proprdb.history) tables.
+	History bool
+	// Options supplies the IDGenerator and Clock; other options are ignored.
+	Options rt.Options
+}
+
+// Table is an in-memory table. It is safe for concurrent use.
+type Table[T proto.Message, R any] struct {
+	config     Config[T, R]
+	mu         sync.Mutex
+	rows       map[string]*entry[T]
+	tombstones map[string]int64
+	history    map[string][]version[T]
+	lastAtNs   int64
+	lastSeq    int64
+}
+
+// version is a recorded version of a row, with zero data for deletions.
+type version[T proto.Message] struct {
+	atNs int64
+	data T
+}
+
+type entry[T proto.Message] struct {
+	// seq orders rows by insertion, like the SQLite rowid.
+	seq         int64
+	id          string
+	atNs        int64
+	deletedAtNs int64
+	data        T
+}
+
+// NewTable returns an empty table described by config.
+func NewTable[T proto.Message, R any](config Config[T, R]) *Table[T, R] {
+	return &Table[T, R]{
+		config:     config,
+		rows:       make(map[string]*entry[T]),
+		tombstones: make(map[string]int64),
+		history:    make(map[string][]version[T]),
+	}
+}
+
+// Init does nothing; it exists for parity with generated tables.
+func (t *Table[T, R]) Init() error {
+	return nil
+}
+
+// Select returns the live rows matching where, in insertion order.
+func (t *Table[T, R]) Select(where string, args ...any) ([]R, error) {
+	return t.SelectWithOptions(rt.SelectOptions{}, where, args...)
+}
+
+// SelectWithOptions is Select with ordering and paging.
+func (t *Table[T, R]) SelectWithOptions(opts rt.SelectOptions, where string, args ...any) ([]R, error) {
+	return t.selectRows(false, opts, where, args...)
+}
+
+// SelectIncludingDeleted is Select including soft-deleted rows.
+func (t *Table[T, R]) SelectIncludingDeleted(where string, args ...any) ([]R, error) {
+	return t.selectRows(true, rt.SelectOptions{}, where, args...)
+}
+
+// SelectByFields returns the live rows whose protobuf fields match where,
+// like the generated SelectByFields.
+func (t *Table[T, R]) SelectByFields(where string, args ...any) ([]R, error) {
+	var zero T
+	filter, err := rt.ParseFieldFilter(zero.ProtoReflect().Descriptor(), where, args)
+	if err != nil {
+		return nil, fmt.Errorf("select from %s: %w", t.config.TableName, err)
+	}
+	rows, err := t.Select("")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]R, 0)
+	for _, row := range rows {
+		_, data := t.config.RowParts(row)
+		matched, err := filter.MatchMessage(data)
+		if err != nil {
+			return nil, fmt.Errorf("select from %s: %w", t.config.TableName, err)
+		}
+		if matched {
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+func (t *Table[T, R]) selectRows(includeDeleted bool, opts rt.SelectOptions, where string, args ...any) ([]R, error) {
+	if _, err := opts.Clause(t.sortColumns()); err != nil {
+		return nil, err
+	}
+	filter, err := rt.ParseFilter(where, args)
+	if err != nil {
+		return nil, fmt.Errorf("select from %s: %w", t.config.TableName, err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	matches := make([]*entry[T], 0, len(t.rows))
+	for _, row := range t.rows {
+		if row.deletedAtNs != 0 && !includeDeleted {
+			continue
+		}
+		matched, err := filter.Match(t.values(row))
+		if err != nil {
+			return nil, fmt.Errorf("select from %s: %w", t.config.TableName, err)
+		}
+		if matched {
+			matches = append(matches, row)
+		}
+	}
+	slices.SortFunc(matches, func(a, b *entry[T]) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	if len(opts.OrderBy) > 0 {
+		slices.SortStableFunc(matches, func(a, b *entry[T]) int {
+			aValues, bValues := t.values(a), t.values(b)
+			for _, orderBy := range opts.OrderBy {
+				result := rt.CompareValues(aValues[orderBy.Column], bValues[orderBy.Column])
+				if orderBy.Descending {
+					result = -result
+				}
+				if result != 0 {
+					return result
+				}
+			}
+			return 0
+		})
+	}
+	matches = matches[min(opts.Offset, len(matches)):]
+	if opts.Limit > 0 && len(matches) > opts.Limit {
+		matches = matches[:opts.Limit]
+	}
+	result := make([]R, 0, len(matches))
+	for _, row := range matches {
+		result = append(result, t.row(row))
+	}
+	return result, nil
+}
+
+// GetByID returns the live row of id, or an error wrapping rt.ErrNotFound.
+func (t *Table[T, R]) GetByID(id string) (*R, error) {
+	if id == "" {
+		return nil, rt.ErrEmptyID
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	row, ok := t.rows[id]
+	if !ok || row.deletedAtNs != 0 {
+		return nil, fmt.Errorf("%s/%s: %w", t.config.TableName, id, rt.ErrNotFound)
+	}
+	result := t.row(row)
+	return &result, nil
+}
+
+// MustGetByID is GetByID panicking on error, for ids known to exist.
+func (t *Table[T, R]) MustGetByID(id string) *R {
+	row, err := t.GetByID(id)
+	if err != nil {
+		panic(err)
+	}
+	return row
+}
+
+// GetManyByID returns the live rows of ids, in their order and without
+// duplicates. Missing ids are skipped.
+func (t *Table[T, R]) GetManyByID(ids []string) ([]R, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := make([]R, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		row, ok := t.rows[id]
+		if !ok || row.deletedAtNs != 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, t.row(row))
+	}
+	return result, nil
+}
+
+// Insert stores data under a new id.
+func (t *Table[T, R]) Insert(data T) (R, error) {
+	id, err := t.config.Options.NewID()
+	if err != nil {
+		var zero R
+		return zero, fmt.Errorf("generate id: %w", err)
+	}
+	return t.InsertWithID(id, data)
+}
+
+// InsertWithID stores data under id, failing with rt.ErrUniqueViolation
+// when a live row of id exists.
+func (t *Table[T, R]) InsertWithID(id string, data T) (R, error) {
+	var zero R
+	if err := t.check(id, data); err != nil {
+		return zero, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if row, ok := t.rows[id]; ok && row.deletedAtNs == 0 {
+		return zero, fmt.Errorf("insert into %s: %s: %w", t.config.TableName, id, rt.ErrUniqueViolation)
+	}
+	delete(t.rows, id)
+	return t.put(id, data), nil
+}
+
+// InsertWithIDIfAbsent is InsertWithID returning the live row of id and
+// false when there is one.
+func (t *Table[T, R]) InsertWithIDIfAbsent(id string, data T) (R, bool, error) {
+	var zero R
+	if err := t.check(id, data); err != nil {
+		return zero, false, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if row, ok := t.rows[id]; ok && row.deletedAtNs == 0 {
+		return t.row(row), false, nil
+	}
+	delete(t.rows, id)
+	return t.put(id, data), true, nil
+}
+
+// UpdateByID stores data under id, inserting the row when missing.
+func (t *Table[T, R]) UpdateByID(id string, data T) (R, error) {
+	var zero R
+	if err := t.check(id, data); err != nil {
+		return zero, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.put(id, data), nil
+}
+
+// UpdateRow is UpdateByID of the id and data of row.
+func (t *Table[T, R]) UpdateRow(row R) (R, error) {
+	id, data := t.config.RowParts(row)
+	return t.UpdateByID(id, data)
+}
+
+// UpdateFields replaces the fields of the live row of id named by mask with
+// those of patch, as the generated UpdateFields does.
+func (t *Table[T, R]) UpdateFields(id string, patch T, mask *fieldmaskpb.FieldMask) (R, error) {
+	var zero R
+	if id == "" {
+		return zero, rt.ErrEmptyID
+	}
+	if !patch.ProtoReflect().IsValid() {
+		return zero, errors.New("nil data")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stored, ok := t.rows[id]
+	if !ok || stored.deletedAtNs != 0 {
+		return zero, fmt.Errorf("%s/%s: %w", t.config.TableName, id, rt.ErrNotFound)
+	}
+	data := proto.Clone(stored.data).(T)
+	if err := rt.ApplyFieldMask(data, patch, mask); err != nil {
+		return zero, fmt.Errorf("update fields of %s/%s: %w", t.config.TableName, id, err)
+	}
+	if err := t.check(id, data); err != nil {
+		return zero, err
+	}
+	return t.put(id, data), nil
+}
+
+// UpdateWhere calls mutate with the data of each live row matching where
+// and stores it as UpdateByID does.
+func (t *Table[T, R]) UpdateWhere(where string, mutate func(T) error, args ...any) (int64, error) {
+	if mutate == nil {
+		return 0, errors.New("nil mutate")
+	}
+	rows, err := t.Select(where, args...)
+	if err != nil {
+		return 0, err
+	}
+	for updated, row := range rows {
+		id, data := t.config.RowParts(row)
+		if err := mutate(data); err != nil {
+			return int64(updated), fmt.Errorf("%s/%s: %w", t.config.TableName, id, err)
+		}
+		if _, err := t.UpdateByID(id, data); err != nil {
+			return int64(updated), fmt.Errorf("%s/%s: %w", t.config.TableName, id, err)
+		}
+	}
+	return int64(len(rows)), nil
+}
+
+// DeleteWhere deletes the live rows matching where as DeleteByID does.
+func (t *Table[T, R]) DeleteWhere(where string, args ...any) (int64, error) {
+	rows, err := t.Select(where, args...)
+	if err != nil {
+		return 0, err
+	}
+	for deleted, row := range rows {
+		id, _ := t.config.RowParts(row)
+		if err := t.DeleteByID(id); err != nil {
+			return int64(deleted), fmt.Errorf("%s/%s: %w", t.config.TableName, id, err)
+		}
+	}
+	return int64(len(rows)), nil
+}
+
+// DeleteByID removes the row of id and records its tombstone. Soft delete
+// tables keep the row with its deletion time instead.
+func (t *Table[T, R]) DeleteByID(id string) error {
+	if id == "" {
+		return rt.ErrEmptyID
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.delete(id)
+	return nil
+}
+
+// UpdateByIDIfRevision is UpdateByID when the live row of id is still at
+// revision, failing with an error wrapping rt.ErrRevisionMismatch otherwise.
+func (t *Table[T, R]) UpdateByIDIfRevision(id, revision string, data T) (R, error) {
+	var zero R
+	if err := t.check(id, data); err != nil {
+		return zero, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkRevision(id, revision); err != nil {
+		return zero, err
+	}
+	return t.put(id, data), nil
+}
+
+// DeleteByIDIfRevision is DeleteByID when the live row of id is still at
+// revision, failing with an error wrapping rt.ErrRevisionMismatch otherwise.
+func (t *Table[T, R]) DeleteByIDIfRevision(id, revision string) error {
+	if id == "" {
+		return rt.ErrEmptyID
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkRevision(id, revision); err != nil {
+		return err
+	}
+	t.delete(id)
+	return nil
+}
+
+// checkRevision checks that the live row of id is at revision. t.mu must be
+// held.
+func (t *Table[T, R]) checkRevision(id, revision string) error {
+	stored, ok := t.rows[id]
+	if !ok || stored.deletedAtNs != 0 {
+		return fmt.Errorf("%s/%s: %w", t.config.TableName, id, rt.ErrNotFound)
+	}
+	return rt.CheckRevision(t.config.TableName, id, stored.atNs, stored.data, revision)
+}
+
+// delete tombstones the row of id. t.mu must be held.
+func (t *Table[T, R]) delete(id string) {
+	atNs := t.nextAtNs()
+	t.tombstones[id] = atNs
+	if t.config.History {
+		var deleted T
+		t.history[id] = append(t.history[id], version[T]{atNs: atNs, data: deleted})
+	}
+	row, ok := t.rows[id]
+	switch {
+	case !ok:
+	case t.config.SoftDelete:
+		row.deletedAtNs = atNs
+	default:
+		delete(t.rows, id)
+	}
+}
+
+// DeleteRow is DeleteByID of the id of row.
+func (t *Table[T, R]) DeleteRow(row R) error {
+	id, _ := t.config.RowParts(row)
+	return t.DeleteByID(id)
+}
+
+// Restore undeletes a soft-deleted row with a new at_ns.
+func (t *Table[T, R]) Restore(id string) (R, error) {
+	var zero R
+	if id == "" {
+		return zero, rt.ErrEmptyID
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	row, ok := t.rows[id]
+	if !ok || row.deletedAtNs == 0 {
+		return zero, fmt.Errorf("restore %s/%s: no soft-deleted row", t.config.TableName, id)
+	}
+	return t.put(id, proto.Clone(row.data).(T)), nil
+}
+
+// History returns every recorded version of id in at_ns order. Deletions are
+// rows with nil data. Only Config.History tables record versions.
+func (t *Table[T, R]) History(id string) ([]R, error) {
+	if id == "" {
+		return nil, rt.ErrEmptyID
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	versions := t.history[id]
+	result := make([]R, 0, len(versions))
+	for _, recorded := range versions {
+		result = append(result, t.versionRow(id, recorded))
+	}
+	return result, nil
+}
+
+// GetAsOf returns the version of id current at atNs, or an error wrapping
+// rt.ErrNotFound when the row did not exist or was deleted then.
+func (t *Table[T, R]) GetAsOf(id string, atNs int64) (*R, error) {
+	if id == "" {
+		return nil, rt.ErrEmptyID
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	recorded, ok := t.versionAsOf(id, atNs)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s as of %d: %w", t.config.TableName, id, atNs, rt.ErrNotFound)
+	}
+	result := t.versionRow(id, recorded)
+	return &result, nil
+}
+
+// RevertTo rewrites id to its version at atNs, deleting it if it did not
+// exist then. The revert is a new write with a new at_ns.
+func (t *Table[T, R]) RevertTo(id string, atNs int64) error {
+	_, err := t.revertTo(id, atNs)
+	return err
+}
+
+// revertTo is RevertTo reporting whether id was written.
+func (t *Table[T, R]) revertTo(id string, atNs int64) (bool, error) {
+	if id == "" {
+		return false, rt.ErrEmptyID
+	}
+	t.mu.Lock()
+	recorded, found := t.versionAsOf(id, atNs)
+	current, live := t.rows[id]
+	live = live && current.deletedAtNs == 0
+	unchanged := found && live && proto.Equal(current.data, recorded.data)
+	t.mu.Unlock()
+	switch {
+	case !found && !live, unchanged:
+		return false, nil
+	case !found:
+		return true, t.DeleteByID(id)
+	}
+	_, err := t.UpdateByID(id, recorded.data)
+	return true, err
+}
+
+// RevertTableTo reverts every row changed after atNs with RevertTo and
+// returns how many were written.
+func (t *Table[T, R]) RevertTableTo(atNs int64) (int64, error) {
+	t.mu.Lock()
+	ids := make([]string, 0)
+	for id, versions := range t.history {
+		if len(versions) > 0 && versions[len(versions)-1].atNs > atNs {
+			ids = append(ids, id)
+		}
+	}
+	t.mu.Unlock()
+	slices.Sort(ids)
+	var reverted int64
+	for _, id := range ids {
+		written, err := t.revertTo(id, atNs)
+		if err != nil {
+			return reverted, fmt.Errorf("revert %s/%s: %w", t.config.TableName, id, err)
+		}
+		if written {
+			reverted++
+		}
+	}
+	return reverted, nil
+}
+
+// versionAsOf returns the live version of id at atNs. t.mu must be held.
+func (t *Table[T, R]) versionAsOf(id string, atNs int64) (version[T], bool) {
+	versions := t.history[id]
+	position, _ := slices.BinarySearchFunc(versions, atNs+1, func(recorded version[T], target int64) int {
+		return cmp.Compare(recorded.atNs, target)
+	})
+	if position == 0 || !versions[position-1].data.ProtoReflect().IsValid() {
+		return version[T]{}, false
+	}
+	return versions[position-1], true
+}
+
+func (t *Table[T, R]) versionRow(id string, recorded version[T]) R {
+	data := recorded.data
+	if data.ProtoReflect().IsValid() {
+		data = proto.Clone(data).(T)
+	}
+	return t.config.NewRow(id, recorded.atNs, 0, data)
+}
+
+// Tombstones returns the deletion at_ns by id of deleted rows.
+func (t *Table[T, R]) Tombstones() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tombstones := make(map[string]int64, len(t.tombstones))
+	for id, atNs := range t.tombstones {
+		tombstones[id] = atNs
+	}
+	return tombstones
+}
+
+// Len returns the number of stored rows, including soft-deleted ones.
+func (t *Table[T, R]) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.rows)
+}
+
+func (t *Table[T, R]) check(id string, data T) error {
+	if id == "" {
+		return rt.ErrEmptyID
+	}
+	if err := t.config.Options.ValidateID(id); err != nil {
+		return fmt.Errorf("validate id %s: %w", id, err)
+	}
+	if !data.ProtoReflect().IsValid() {
+		return errors.New("nil data")
+	}
+	if t.config.Validate != nil {
+		if err := t.config.Validate(data); err != nil {
+			return rt.ValidationError(t.config.TypeName, err)
+		}
+	}
+	return nil
+}
+
+// put stores a copy of data under id with a new at_ns, clearing any
+// tombstone. Replaced rows keep their place in insertion order. t.mu must be
+// held.
+func (t *Table[T, R]) put(id string, data T) R {
+	row := &entry[T]{id: id, atNs: t.nextAtNs(), data: proto.Clone(data).(T)}
+	if existing, ok := t.rows[id]; ok {
+		row.seq = existing.seq
+	} else {
+		t.lastSeq++
+		row.seq = t.lastSeq
+	}
+	delete(t.tombstones, id)
+	t.rows[id] = row
+	if t.config.History {
+		t.history[id] = append(t.history[id], version[T]{atNs: row.atNs, data: proto.Clone(data).(T)})
+	}
+	return t.config.NewRow(row.id, row.atNs, 0, data)
+}
+
+// nextAtNs returns the time of the configured clock, bumped to keep at_ns
+// strictly increasing within the table. t.mu must be held.
+func (t *Table[T, R]) nextAtNs() int64 {
+	t.lastAtNs = max(t.config.Options.NowNs(), t.lastAtNs+1)
+	return t.lastAtNs
+}
+
+// row returns the generated row of stored, with a copy of its data.
+func (t *Table[T, R]) row(stored *entry[T]) R {
+	return t.config.NewRow(stored.id, stored.atNs, stored.deletedAtNs, proto.Clone(stored.data).(T))
+}
+
+func (t *Table[T, R]) values(stored *entry[T]) map[string]any {
+	values := map[string]any{"id": stored.id, "at_ns": stored.atNs}
+	if t.config.SoftDelete {
+		values["deleted_at_ns"] = nil
+		if stored.deletedAtNs != 0 {
+			values["deleted_at_ns"] = stored.deletedAtNs
+		}
+	}
+	if t.config.Values != nil {
+		for index, value := range t.config.Values(stored.data) {
+			values[t.config.Columns[index]] = value
+		}
+	}
+	return values
+}
+
+func (t *Table[T, R]) sortColumns() []string {
+	columns := []string{"id", "at_ns"}
+	if t.config.SoftDelete {
+		columns = append(columns, "deleted_at_ns")
+	}
+	return append(columns, t.config.Columns...)
+}
@@ -0,0 +1,220 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// FieldMerge selects how a single field is combined by MergeFields.
+type FieldMerge int
+
+const (
+	// FieldMergeMax keeps the larger of two scalar numeric (or bool) values.
+	FieldMergeMax FieldMerge = iota + 1
+	// FieldMergeSum treats a map<string, integer> field as a grow-only
+	// counter keyed by replica. Entries merge by per-key maximum, so the
+	// counter value (see CounterTotal) is the sum over all replicas.
+	FieldMergeSum
+	// FieldMergeSetUnion keeps the sorted, de-duplicated union of two
+	// repeated scalar fields.
+	FieldMergeSetUnion
+)
+
+func (m FieldMerge) String() string {
+	switch m {
+	case FieldMergeMax:
+		return "max"
+	case FieldMergeSum:
+		return "sum"
+	case FieldMergeSetUnion:
+		return "set-union"
+	default:
+		return fmt.Sprintf("FieldMerge(%d)", int(m))
+	}
+}
+
+// CounterTotal returns the value of a FieldMergeSum counter.
+func CounterTotal[V ~int32 | ~int64 | ~uint32 | ~uint64](counter map[string]V) V {
+	var total V
+	for _, value := range counter {
+		total += value
+	}
+	return total
+}
+
+// MergeFields merges two versions of the same object field by field. Fields
+// listed in fieldMerges (keyed by proto field name) are combined; all other
+// fields come from the newer version. Equal timestamps are broken by
+// comparing the deterministic encodings, so the result does not depend on
+// which side is local.
+func MergeFields(local proto.Message, localAtNs int64, remote proto.Message, remoteAtNs int64, fieldMerges map[string]FieldMerge) (proto.Message, error) {
+	if local.ProtoReflect().Descriptor().FullName() != remote.ProtoReflect().Descriptor().FullName() {
+		return nil, fmt.Errorf("merge %s with %s", local.ProtoReflect().Descriptor().FullName(), remote.ProtoReflect().Descriptor().FullName())
+	}
+	newer, older := remote, local
+	localNewer, err := isNewerVersion(local, localAtNs, remote, remoteAtNs)
+	if err != nil {
+		return nil, err
+	}
+	if localNewer {
+		newer, older = local, remote
+	}
+
+	merged := proto.Clone(newer)
+	mergedReflect := merged.ProtoReflect()
+	olderReflect := older.ProtoReflect()
+	fields := mergedReflect.Descriptor().Fields()
+	for fieldName, fieldMerge := range fieldMerges {
+		field := fields.ByName(protoreflect.Name(fieldName))
+		if field == nil {
+			return nil, fmt.Errorf("merge %s: unknown field %q", mergedReflect.Descriptor().FullName(), fieldName)
+		}
+		if err := ValidateFieldMerge(field, fieldMerge); err != nil {
+			return nil, err
+		}
+		switch fieldMerge {
+		case FieldMergeMax:
+			mergeFieldMax(mergedReflect, olderReflect, field)
+		case FieldMergeSum:
+			mergeFieldCounter(mergedReflect, olderReflect, field)
+		case FieldMergeSetUnion:
+			mergeFieldSetUnion(mergedReflect, olderReflect, field)
+		}
+	}
+	return merged, nil
+}
+
+// ValidateFieldMerge reports whether fieldMerge can be applied to field.
+func ValidateFieldMerge(field protoreflect.FieldDescriptor, fieldMerge FieldMerge) error {
+	switch fieldMerge {
+	case FieldMergeMax:
+		if field.Cardinality() == protoreflect.Repeated || !isOrderedScalarKind(field.Kind()) {
+			return fmt.Errorf("field %s: max merge requires a singular numeric or bool field", field.FullName())
+		}
+	case FieldMergeSum:
+		if !field.IsMap() || field.MapKey().Kind() != protoreflect.StringKind || !isIntegerKind(field.MapValue().Kind()) {
+			return fmt.Errorf("field %s: sum merge requires a map<string, integer> field", field.FullName())
+		}
+	case FieldMergeSetUnion:
+		if !field.IsList() || !isSetElementKind(field.Kind()) {
+			return fmt.Errorf("field %s: set union merge requires a repeated string, bytes or integer field", field.FullName())
+		}
+	default:
+		return fmt.Errorf("field %s: unsupported merge %s", field.FullName(), fieldMerge)
+	}
+	return nil
+}
+
+func isNewerVersion(candidate proto.Message, candidateAtNs int64, other proto.Message, otherAtNs int64) (bool, error) {
+	if candidateAtNs != otherAtNs {
+		return candidateAtNs > otherAtNs, nil
+	}
+	marshalOptions := proto.MarshalOptions{Deterministic: true}
+	candidateBytes, err := marshalOptions.Marshal(candidate)
+	if err != nil {
+		return false, fmt.Errorf("marshal merge candidate: %w", err)
+	}
+	otherBytes, err := marshalOptions.Marshal(other)
+	if err != nil {
+		return false, fmt.Errorf("marshal merge candidate: %w", err)
+	}
+	return bytes.Compare(candidateBytes, otherBytes) > 0, nil
+}
+
+func mergeFieldMax(merged, older protoreflect.Message, field protoreflect.FieldDescriptor) {
+	olderValue := older.Get(field)
+	if compareScalar(field.Kind(), olderValue, merged.Get(field)) > 0 {
+		merged.Set(field, olderValue)
+	}
+}
+
+func mergeFieldCounter(merged, older protoreflect.Message, field protoreflect.FieldDescriptor) {
+	olderMap := older.Get(field).Map()
+	if olderMap.Len() == 0 {
+		return
+	}
+	mergedMap := merged.Mutable(field).Map()
+	valueKind := field.MapValue().Kind()
+	olderMap.Range(func(key protoreflect.MapKey, olderValue protoreflect.Value) bool {
+		if !mergedMap.Has(key) || compareScalar(valueKind, olderValue, mergedMap.Get(key)) > 0 {
+			mergedMap.Set(key, olderValue)
+		}
+		return true
+	})
+}
+
+func mergeFieldSetUnion(merged, older protoreflect.Message, field protoreflect.FieldDescriptor) {
+	values := make([]protoreflect.Value, 0)
+	for _, list := range []protoreflect.List{merged.Get(field).List(), older.Get(field).List()} {
+		for index := range list.Len() {
+			values = append(values, list.Get(index))
+		}
+	}
+	kind := field.Kind()
+	slices.SortFunc(values, func(a, b protoreflect.Value) int {
+		return compareScalar(kind, a, b)
+	})
+	values = slices.CompactFunc(values, func(a, b protoreflect.Value) bool {
+		return compareScalar(kind, a, b) == 0
+	})
+	if len(values) == 0 {
+		merged.Clear(field)
+		return
+	}
+	list := merged.NewField(field).List()
+	for _, value := range values {
+		list.Append(value)
+	}
+	merged.Set(field, protoreflect.ValueOfList(list))
+}
+
+func compareScalar(kind protoreflect.Kind, a, b protoreflect.Value) int {
+	switch kind {
+	case protoreflect.BoolKind:
+		return cmp.Compare(boolRank(a.Bool()), boolRank(b.Bool()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return cmp.Compare(a.Int(), b.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return cmp.Compare(a.Float(), b.Float())
+	case protoreflect.StringKind:
+		return cmp.Compare(a.String(), b.String())
+	case protoreflect.BytesKind:
+		return bytes.Compare(a.Bytes(), b.Bytes())
+	default:
+		return 0
+	}
+}
+
+func boolRank(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func isIntegerKind(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return true
+	default:
+		return false
+	}
+}
+
+func isOrderedScalarKind(kind protoreflect.Kind) bool {
+	return isIntegerKind(kind) || kind == protoreflect.BoolKind || kind == protoreflect.FloatKind || kind == protoreflect.DoubleKind
+}
+
+func isSetElementKind(kind protoreflect.Kind) bool {
+	return isIntegerKind(kind) || kind == protoreflect.StringKind || kind == protoreflect.BytesKind
+}
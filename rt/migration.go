@@ -0,0 +1,186 @@
+package proprdbrt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// FieldMigration is a (proprdb.migration) of a generated message. It sets
+// Field where it is unset, to the value of CopyFrom or else to DefaultJSON.
+type FieldMigration struct {
+	// Field is the name of the top-level field to set.
+	Field string
+	// CopyFrom names the field to copy, empty for a default value.
+	CopyFrom string
+	// DefaultJSON is the value in the protojson syntax of Field.
+	DefaultJSON string
+}
+
+// preparedMigration is a FieldMigration resolved against a message type.
+type preparedMigration struct {
+	field protoreflect.FieldDescriptor
+	// from is the field to copy, nil for a default value.
+	from         protoreflect.FieldDescriptor
+	defaultValue protoreflect.Value
+}
+
+func prepareMigrations(descriptor protoreflect.MessageDescriptor, template protoreflect.Message, migrations []FieldMigration) ([]preparedMigration, error) {
+	prepared := make([]preparedMigration, 0, len(migrations))
+	for _, migration := range migrations {
+		field := descriptor.Fields().ByName(protoreflect.Name(migration.Field))
+		if field == nil {
+			return nil, fmt.Errorf("migrate %s: no field %q", descriptor.FullName(), migration.Field)
+		}
+		next := preparedMigration{field: field}
+		if migration.CopyFrom != "" {
+			from := descriptor.Fields().ByName(protoreflect.Name(migration.CopyFrom))
+			switch {
+			case from == nil:
+				return nil, fmt.Errorf("migrate %s: no field %q", descriptor.FullName(), migration.CopyFrom)
+			case from.Kind() != field.Kind() || from.Cardinality() != field.Cardinality() || from.IsMap() != field.IsMap() || from.Message() != nil && field.Message() != nil && from.Message().FullName() != field.Message().FullName():
+				return nil, fmt.Errorf("migrate %s: cannot copy %s to %s of another type", descriptor.FullName(), from.Name(), field.Name())
+			}
+			next.from = from
+		} else {
+			parsed := template.New()
+			encoded := `{` + strconv.Quote(field.JSONName()) + `:` + migration.DefaultJSON + `}`
+			if err := protojson.Unmarshal([]byte(encoded), parsed.Interface()); err != nil {
+				return nil, fmt.Errorf("migrate %s: default value of %s: %w", descriptor.FullName(), field.Name(), err)
+			}
+			next.defaultValue = parsed.Get(field)
+		}
+		prepared = append(prepared, next)
+	}
+	return prepared, nil
+}
+
+func applyMigrations(message protoreflect.Message, migrations []preparedMigration) bool {
+	changed := false
+	for _, migration := range migrations {
+		switch {
+		case message.Has(migration.field):
+		case migration.from == nil:
+			message.Set(migration.field, migration.defaultValue)
+			changed = true
+		case message.Has(migration.from):
+			message.Set(migration.field, message.Get(migration.from))
+			changed = true
+		}
+	}
+	return changed
+}
+
+// ApplyFieldMigrations applies migrations to message in order and reports
+// whether any of them changed it.
+func ApplyFieldMigrations(message proto.Message, migrations []FieldMigration) (bool, error) {
+	reflected := message.ProtoReflect()
+	prepared, err := prepareMigrations(reflected.Descriptor(), reflected, migrations)
+	if err != nil {
+		return false, err
+	}
+	return applyMigrations(reflected, prepared), nil
+}
+
+// MigrateRows applies migrations to the data of every row of tableName,
+// whose type template is, rewriting the rows they change. The rows keep
+// their at_ns: every replica migrates its own rows, so nothing is synced.
+// The generated tables run it as their first schema change hook.
+func MigrateRows(ctx context.Context, q DBTX, opts Options, tableName string, template proto.Message, migrations []FieldMigration) error {
+	reflected := template.ProtoReflect()
+	prepared, err := prepareMigrations(reflected.Descriptor(), reflected, migrations)
+	if err != nil {
+		return err
+	}
+	rows, err := q.QueryContext(ctx, `SELECT id, data FROM "`+tableName+`"`)
+	if err != nil {
+		return fmt.Errorf("query rows of %s for migration: %w", tableName, err)
+	}
+	type migratedRow struct {
+		id   string
+		data []byte
+	}
+	var migrated []migratedRow
+	for rows.Next() {
+		var id string
+		var dataBytes []byte
+		if err := rows.Scan(&id, &dataBytes); err != nil {
+			if closeErr := CloseRows(rows, "migration"); closeErr != nil {
+				return fmt.Errorf("scan migration row: %w (additionally, %v)", err, closeErr)
+			}
+			return fmt.Errorf("scan migration row: %w", err)
+		}
+		message := reflected.New()
+		if err := UnmarshalData(opts, dataBytes, message.Interface()); err != nil {
+			if closeErr := CloseRows(rows, "migration"); closeErr != nil {
+				return fmt.Errorf("unmarshal %s/%s for migration: %w (additionally, %v)", tableName, id, err, closeErr)
+			}
+			return fmt.Errorf("unmarshal %s/%s for migration: %w", tableName, id, err)
+		}
+		if !applyMigrations(message, prepared) {
+			continue
+		}
+		encoded, err := MarshalData(opts, message.Interface())
+		if err != nil {
+			if closeErr := CloseRows(rows, "migration"); closeErr != nil {
+				return fmt.Errorf("marshal %s/%s for migration: %w (additionally, %v)", tableName, id, err, closeErr)
+			}
+			return fmt.Errorf("marshal %s/%s for migration: %w", tableName, id, err)
+		}
+		migrated = append(migrated, migratedRow{id: id, data: encoded})
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "migration"); closeErr != nil {
+			return fmt.Errorf("iterate migration rows: %w (additionally, %v)", err, closeErr)
+		}
+		return fmt.Errorf("iterate migration rows: %w", err)
+	}
+	if err := CloseRows(rows, "migration"); err != nil {
+		return err
+	}
+	for _, row := range migrated {
+		if _, err := q.ExecContext(ctx, `UPDATE "`+tableName+`" SET data = ? WHERE id = ?`, row.data, row.id); err != nil {
+			return fmt.Errorf("migrate %s/%s: %w", tableName, row.id, err)
+		}
+	}
+	return nil
+}
+
+// RenameJSONFields returns data, the protojson of a message, with its keys
+// renamed per renames, from a (proprdb.renamed_from) name to the JSON name
+// of the field. Keys whose new name is present already are dropped. data
+// is returned unchanged when it has none of the keys.
+func RenameJSONFields(data json.RawMessage, renames map[string]string) (json.RawMessage, error) {
+	if len(renames) == 0 {
+		return data, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("decode data for renamed fields: %w", err)
+	}
+	renamed := false
+	for oldName, newName := range renames {
+		value, ok := fields[oldName]
+		if !ok {
+			continue
+		}
+		delete(fields, oldName)
+		if _, exists := fields[newName]; !exists {
+			fields[newName] = value
+		}
+		renamed = true
+	}
+	if !renamed {
+		return data, nil
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("encode data with renamed fields: %w", err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,53 @@
+package proprdbrt
+
+import "sync"
+
+// MockCall is a call recorded by a generated mock: the method name and its
+// arguments, with variadic arguments as one slice.
+type MockCall struct {
+	Method string
+	Args   []any
+}
+
+// MockRecorder records the calls of the mocks generated with mock=true. It
+// is safe for concurrent use.
+type MockRecorder struct {
+	mu    sync.Mutex
+	calls []MockCall
+}
+
+// Record appends a call of method.
+func (r *MockRecorder) Record(method string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, MockCall{Method: method, Args: args})
+}
+
+// Calls returns the recorded calls in order.
+func (r *MockRecorder) Calls() []MockCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := make([]MockCall, len(r.calls))
+	copy(calls, r.calls)
+	return calls
+}
+
+// CallsTo returns the recorded calls of method in order.
+func (r *MockRecorder) CallsTo(method string) []MockCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := make([]MockCall, 0)
+	for _, call := range r.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// ResetCalls forgets the recorded calls.
+func (r *MockRecorder) ResetCalls() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
@@ -0,0 +1,60 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// DefaultMutateBatchSize is the number of rows generated DeleteWhere and
+// UpdateWhere change per transaction.
+const DefaultMutateBatchSize = 500
+
+// MutateBatchFunc changes at most limit rows with ids after afterID, in id
+// order, through tx. It returns the last id it visited and the number of
+// rows visited; fewer than limit rows means no rows are left.
+type MutateBatchFunc func(tx DBTX, afterID string, limit int) (string, int, error)
+
+// MutateInBatches runs batch in one transaction per batchSize rows, retried
+// per policy, until a batch visits fewer rows, and returns the number of
+// rows visited. Batches committed before a failure stay committed. On a
+// *sql.Tx all batches join it.
+func MutateInBatches(ctx context.Context, q DBTX, policy RetryPolicy, batchSize int, batch MutateBatchFunc) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultMutateBatchSize
+	}
+	var total int64
+	afterID := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		var lastID string
+		var visited int
+		err := WithTxRetry(ctx, q, policy, func(tx DBTX) error {
+			var err error
+			lastID, visited, err = batch(tx, afterID, batchSize)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int64(visited)
+		if visited < batchSize {
+			return total, nil
+		}
+		afterID = lastID
+	}
+}
+
+// AfterIDWhere restricts where to ids after a trailing argument, for the
+// keyset paging of a MutateBatchFunc.
+func AfterIDWhere(where string) string {
+	if strings.TrimSpace(where) == "" {
+		return "id > ?"
+	}
+	return "(" + where + ") AND id > ?"
+}
@@ -0,0 +1,233 @@
+// Package notify posts the change feed of generated proprdb tables to
+// webhooks, so low-code tools react to writes without polling.
+//
+// A Notifier is an rt.OutboxPublisher: run it under an rt.OutboxDrainer,
+// which reads the _changes feed (rt.Options.ChangeFeed) and checkpoints
+// what was delivered. Each change of a table with webhooks becomes one POST
+// of a JSON Event whose data is the protojson encoding of the row as it is
+// when the change is delivered. Deliveries are at-least-once; receivers
+// deduplicate by seq.
+//
+// The message type of a table is looked up in the table registry and the
+// global protobuf registry, so the generated package must be linked in.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body,
+// keyed with Webhook.Secret.
+const SignatureHeader = "X-Proprdb-Signature"
+
+// DefaultMaxAttempts is the number of POST attempts per delivery when
+// Notifier.MaxAttempts is 0.
+const DefaultMaxAttempts = 3
+
+// DefaultBackoff is the delay before the first retry when Notifier.Backoff
+// is 0. Each further retry doubles it.
+const DefaultBackoff = 100 * time.Millisecond
+
+// Webhook is one receiver of the changes of a table.
+type Webhook struct {
+	URL string
+	// Secret, when set, signs each body in SignatureHeader.
+	Secret []byte
+}
+
+// Event is the JSON body of a POST.
+type Event struct {
+	Seq       int64       `json:"seq"`
+	TableName string      `json:"table"`
+	TypeName  string      `json:"type"`
+	ID        string      `json:"id"`
+	Op        rt.ChangeOp `json:"op"`
+	AtNs      int64       `json:"atNs"`
+	// Data is the protojson encoding of the row; it is omitted when the
+	// row no longer exists, e.g. after a hard delete.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Notifier posts changes to the webhooks of their table.
+type Notifier struct {
+	DB rt.DBTX
+	// Webhooks maps table names to their receivers. Changes of other
+	// tables are skipped.
+	Webhooks map[string][]Webhook
+	// Options decodes the data column; it needs the Cipher and DataCodec of
+	// the tables.
+	Options rt.Options
+	// Client sends the requests. When nil, http.DefaultClient is used.
+	Client *http.Client
+	// MaxAttempts caps the POST attempts per delivery.
+	MaxAttempts int
+	// Backoff is the delay before the first retry.
+	Backoff time.Duration
+}
+
+// Publish posts each change to every webhook of its table in order. Network
+// errors and 429 and 5xx responses are retried; when a delivery still fails,
+// Publish returns the error and the drainer publishes the batch again later.
+func (n *Notifier) Publish(ctx context.Context, changes []rt.Change) error {
+	if n.DB == nil {
+		return errors.New("nil DBTX")
+	}
+	types := registeredTypes()
+	for _, change := range changes {
+		webhooks := n.Webhooks[change.TableName]
+		if len(webhooks) == 0 {
+			continue
+		}
+		body, err := n.eventBody(ctx, change, types[change.TableName])
+		if err != nil {
+			return fmt.Errorf("change %d: %w", change.Seq, err)
+		}
+		for _, webhook := range webhooks {
+			if err := n.post(ctx, webhook, body); err != nil {
+				return fmt.Errorf("change %d to %s: %w", change.Seq, webhook.URL, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Sign returns the SignatureHeader value of body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the SignatureHeader value of body.
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func registeredTypes() map[string]string {
+	types := make(map[string]string)
+	for _, descriptor := range rt.RegisteredTableDescriptors() {
+		types[descriptor.TableName] = descriptor.TypeName
+	}
+	return types
+}
+
+func (n *Notifier) eventBody(ctx context.Context, change rt.Change, typeName string) ([]byte, error) {
+	if typeName == "" {
+		return nil, fmt.Errorf("table %s is not registered", change.TableName)
+	}
+	event := Event{
+		Seq:       change.Seq,
+		TableName: change.TableName,
+		TypeName:  typeName,
+		ID:        change.ID,
+		Op:        change.Op,
+		AtNs:      change.AtNs,
+	}
+	data, err := n.rowJSON(ctx, change, typeName)
+	if err != nil {
+		return nil, err
+	}
+	event.Data = data
+	return json.Marshal(event)
+}
+
+func (n *Notifier) rowJSON(ctx context.Context, change rt.Change, typeName string) ([]byte, error) {
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(typeName))
+	if err != nil {
+		return nil, fmt.Errorf("find message type %s: %w", typeName, err)
+	}
+	var stored []byte
+	query := `SELECT data FROM "` + strings.ReplaceAll(change.TableName, `"`, `""`) + `" WHERE id = ?`
+	if err := n.DB.QueryRowContext(ctx, query, change.ID).Scan(&stored); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("select row: %w", err)
+	}
+	message := messageType.New().Interface()
+	if err := rt.UnmarshalData(n.Options, stored, message); err != nil {
+		return nil, fmt.Errorf("decode row: %w", err)
+	}
+	data, err := protojson.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("marshal row: %w", err)
+	}
+	return data, nil
+}
+
+func (n *Notifier) post(ctx context.Context, webhook Webhook, body []byte) error {
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	attempts := n.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+	backoff := n.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	var lastErr error
+	for attempt := range attempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w (additionally, %v)", ctx.Err(), lastErr)
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		retry, err := send(ctx, client, webhook, body)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("after %d attempts: %w", attempts, lastErr)
+}
+
+// send posts body once and reports whether a failure is worth retrying.
+func send(ctx context.Context, client *http.Client, webhook Webhook, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if len(webhook.Secret) > 0 {
+		request.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("post: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	if err := response.Body.Close(); err != nil {
+		return true, fmt.Errorf("close response: %w", err)
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return retry, fmt.Errorf("status %s", response.Status)
+}
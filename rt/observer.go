@@ -0,0 +1,104 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// QueryObserver is notified after each statement generated code executes
+// through a DBTX wrapped by ObserveDBTX, e.g. via Options.QueryObserver.
+// For queries, duration covers executing the statement but not iterating
+// the rows. OnQuery is called synchronously and must be safe for
+// concurrent use.
+type QueryObserver interface {
+	OnQuery(query string, args []any, duration time.Duration, err error)
+}
+
+// QueryObserverFunc adapts a function to QueryObserver.
+type QueryObserverFunc func(query string, args []any, duration time.Duration, err error)
+
+func (f QueryObserverFunc) OnQuery(query string, args []any, duration time.Duration, err error) {
+	f(query, args, duration, err)
+}
+
+// SlogQueryObserver logs statements to Logger: failed ones at error level,
+// ones taking at least SlowThreshold at warn level and the rest at debug
+// level. Arguments are not logged as they may hold encrypted or private data.
+type SlogQueryObserver struct {
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+	// SlowThreshold disables slow query warnings when zero.
+	SlowThreshold time.Duration
+}
+
+func (o SlogQueryObserver) OnQuery(query string, args []any, duration time.Duration, err error) {
+	logger := o.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	switch {
+	case err != nil:
+		logger.Error("sql statement failed", "query", query, "args", len(args), "duration", duration, "err", err)
+	case o.SlowThreshold > 0 && duration >= o.SlowThreshold:
+		logger.Warn("slow sql statement", "query", query, "args", len(args), "duration", duration)
+	default:
+		logger.Debug("sql statement", "query", query, "args", len(args), "duration", duration)
+	}
+}
+
+// ObserveDBTX returns q reporting every statement to observer. It returns q
+// itself when either is nil. The result can begin transactions if q can;
+// statements of such transactions are only observed once the *sql.Tx is
+// wrapped too, as generated WithTx does.
+func ObserveDBTX(q DBTX, observer QueryObserver) DBTX {
+	if q == nil || observer == nil {
+		return q
+	}
+	observed := observedDBTX{q: q, observer: observer}
+	if beginner, ok := q.(txBeginner); ok {
+		return observedBeginnerDBTX{observedDBTX: observed, beginner: beginner}
+	}
+	return observed
+}
+
+type observedDBTX struct {
+	q        DBTX
+	observer QueryObserver
+}
+
+func (o observedDBTX) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	result, err := o.q.ExecContext(ctx, query, args...)
+	o.observer.OnQuery(query, args, time.Since(start), err)
+	return result, err
+}
+
+func (o observedDBTX) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := o.q.QueryContext(ctx, query, args...)
+	o.observer.OnQuery(query, args, time.Since(start), err)
+	return rows, err
+}
+
+func (o observedDBTX) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	start := time.Now()
+	row := o.q.QueryRowContext(ctx, query, args...)
+	// sql.ErrNoRows is only reported by Scan, so it is not seen here.
+	o.observer.OnQuery(query, args, time.Since(start), row.Err())
+	return row
+}
+
+func (o observedDBTX) unwrapDBTX() DBTX {
+	return o.q
+}
+
+type observedBeginnerDBTX struct {
+	observedDBTX
+	beginner txBeginner
+}
+
+func (o observedBeginnerDBTX) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return o.beginner.BeginTx(ctx, opts)
+}
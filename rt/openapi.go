@@ -0,0 +1,322 @@
+package proprdbrt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// OpenAPIPath is where NewHTTPHandler serves OpenAPIDocument.
+const OpenAPIPath = "/openapi.json"
+
+const (
+	openAPIErrorSchema = "proprdb.Error"
+	// Rows are named by prefixing their message, as no proto package is
+	// called proprdb.Row.
+	openAPIRowPrefix = "proprdb.Row."
+)
+
+// openAPISchema is a JSON Schema object of an OpenAPI 3 document.
+type openAPISchema map[string]any
+
+// OpenAPIDocument returns the OpenAPI 3 document of the routes NewHTTPHandler
+// serves for resources, with the message schemas in their protojson mapping,
+// for generating clients. It is titled by the proto package of the first
+// resource.
+func OpenAPIDocument(resources ...HTTPResource) ([]byte, error) {
+	title := "proprdb"
+	schemas := map[string]openAPISchema{
+		openAPIErrorSchema: {
+			"type":       "object",
+			"required":   []string{"error"},
+			"properties": map[string]openAPISchema{"error": {"type": "string"}},
+		},
+	}
+	paths := make(map[string]map[string]any, 2*len(resources))
+	for index, resource := range resources {
+		message := resource.New().ProtoReflect().Descriptor()
+		if index == 0 && message.ParentFile().Package() != "" {
+			title = string(message.ParentFile().Package())
+		}
+		addOpenAPIMessage(schemas, message)
+		rowName := openAPIRowPrefix + string(message.FullName())
+		schemas[rowName] = openAPISchema{
+			"type":     "object",
+			"required": []string{"id", "atNs", "revision", "data"},
+			"properties": map[string]openAPISchema{
+				"id":       {"type": "string"},
+				"atNs":     {"type": "integer", "format": "int64"},
+				"revision": {"type": "string"},
+				"data":     openAPIRef(string(message.FullName())),
+			},
+		}
+		paths[resource.Path] = resource.openAPICollection(string(message.FullName()), rowName)
+		paths[resource.Path+"/{id}"] = resource.openAPIItem(string(message.FullName()), rowName)
+	}
+	document := map[string]any{
+		"openapi":    "3.0.3",
+		"info":       map[string]string{"title": title, "version": "1"},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+	encoded, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode openapi document: %w", err)
+	}
+	return append(encoded, '\n'), nil
+}
+
+// serveOpenAPI serves the OpenAPIDocument of resources.
+func serveOpenAPI(resources []HTTPResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		document, err := OpenAPIDocument(resources...)
+		if err != nil {
+			WriteHTTPError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(document)
+	}
+}
+
+func (h HTTPResource) openAPICollection(messageName, rowName string) map[string]any {
+	name := h.operationName()
+	parameters := make([]openAPISchema, 0, len(h.Columns))
+	for _, column := range h.Columns {
+		itemType := "string"
+		switch column.SQLiteType {
+		case "INTEGER":
+			itemType = "integer"
+		case "REAL":
+			itemType = "number"
+		}
+		parameters = append(parameters, openAPISchema{
+			"name":    column.Name,
+			"in":      "query",
+			"style":   "form",
+			"explode": true,
+			"schema":  openAPISchema{"type": "array", "items": openAPISchema{"type": itemType}},
+		})
+	}
+	if h.acceptsFilter() {
+		parameters = append(parameters, openAPISchema{
+			"name":        HTTPFilterParameter,
+			"in":          "query",
+			"description": "AIP-160 filter expression",
+			"schema":      openAPISchema{"type": "string"},
+		})
+	}
+	return map[string]any{
+		"get": map[string]any{
+			"operationId": "list_" + name,
+			"parameters":  parameters,
+			"responses": map[string]any{
+				"200":     openAPIResponse("rows", openAPISchema{"type": "array", "items": openAPIRef(rowName)}),
+				"400":     openAPIErrorResponse("unknown or invalid filter"),
+				"default": openAPIErrorResponse("error"),
+			},
+		},
+		"post": map[string]any{
+			"operationId": "create_" + name,
+			"requestBody": openAPIBody(messageName),
+			"responses": map[string]any{
+				"201":     openAPIResponse("created row", openAPIRef(rowName)),
+				"400":     openAPIErrorResponse("invalid body"),
+				"409":     openAPIErrorResponse("unique constraint violated"),
+				"default": openAPIErrorResponse("error"),
+			},
+		},
+	}
+}
+
+func (h HTTPResource) openAPIItem(messageName, rowName string) map[string]any {
+	name := h.operationName()
+	notFound := openAPIErrorResponse("row not found")
+	revisionChanged := openAPIErrorResponse("row changed since the If-Match revision")
+	ifMatch := []openAPISchema{{"name": "If-Match", "in": "header", "schema": openAPISchema{"type": "string"}}}
+	return map[string]any{
+		"parameters": []openAPISchema{{"name": "id", "in": "path", "required": true, "schema": openAPISchema{"type": "string"}}},
+		"get": map[string]any{
+			"operationId": "get_" + name,
+			"responses": map[string]any{
+				"200":     openAPIResponse("row", openAPIRef(rowName)),
+				"404":     notFound,
+				"default": openAPIErrorResponse("error"),
+			},
+		},
+		"put": map[string]any{
+			"operationId": "replace_" + name,
+			"parameters":  ifMatch,
+			"requestBody": openAPIBody(messageName),
+			"responses": map[string]any{
+				"200":     openAPIResponse("replaced row", openAPIRef(rowName)),
+				"400":     openAPIErrorResponse("invalid body"),
+				"404":     notFound,
+				"409":     openAPIErrorResponse("unique constraint violated"),
+				"412":     revisionChanged,
+				"default": openAPIErrorResponse("error"),
+			},
+		},
+		"delete": map[string]any{
+			"operationId": "delete_" + name,
+			"parameters":  ifMatch,
+			"responses": map[string]any{
+				"204":     map[string]any{"description": "deleted"},
+				"404":     notFound,
+				"412":     revisionChanged,
+				"default": openAPIErrorResponse("error"),
+			},
+		},
+	}
+}
+
+// operationName derives the operation ids of h from its path.
+func (h HTTPResource) operationName() string {
+	return strings.ReplaceAll(strings.Trim(h.Path, "/"), "/", "_")
+}
+
+func openAPIRef(name string) openAPISchema {
+	return openAPISchema{"$ref": "#/components/schemas/" + name}
+}
+
+func openAPIBody(messageName string) map[string]any {
+	return map[string]any{
+		"required": true,
+		"content":  map[string]any{"application/json": map[string]any{"schema": openAPIRef(messageName)}},
+	}
+}
+
+func openAPIResponse(description string, schema openAPISchema) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+func openAPIErrorResponse(description string) map[string]any {
+	return openAPIResponse(description, openAPIRef(openAPIErrorSchema))
+}
+
+// addOpenAPIMessage adds the schema of message, and of the messages and
+// enums it refers to, to schemas.
+func addOpenAPIMessage(schemas map[string]openAPISchema, message protoreflect.MessageDescriptor) {
+	name := string(message.FullName())
+	if _, ok := schemas[name]; ok {
+		return
+	}
+	properties := make(map[string]openAPISchema, message.Fields().Len())
+	schemas[name] = openAPISchema{"type": "object", "properties": properties}
+	fields := message.Fields()
+	for i := range fields.Len() {
+		field := fields.Get(i)
+		properties[field.JSONName()] = openAPIField(schemas, field)
+	}
+}
+
+// openAPIField returns the schema of field in the protojson mapping.
+func openAPIField(schemas map[string]openAPISchema, field protoreflect.FieldDescriptor) openAPISchema {
+	switch {
+	case field.IsMap():
+		return openAPISchema{"type": "object", "additionalProperties": openAPIValue(schemas, field.MapValue())}
+	case field.IsList():
+		return openAPISchema{"type": "array", "items": openAPIValue(schemas, field)}
+	}
+	return openAPIValue(schemas, field)
+}
+
+// openAPIValue returns the schema of a single value of field.
+func openAPIValue(schemas map[string]openAPISchema, field protoreflect.FieldDescriptor) openAPISchema {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return openAPISchema{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return openAPISchema{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return openAPISchema{"type": "integer", "format": "uint32", "minimum": 0}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return openAPISchema{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return openAPISchema{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return openAPISchema{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return openAPISchema{"type": "number", "format": "double"}
+	case protoreflect.BytesKind:
+		return openAPISchema{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		return openAPIEnum(schemas, field.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if schema, ok := openAPIWellKnown(field.Message().FullName()); ok {
+			return schema
+		}
+		addOpenAPIMessage(schemas, field.Message())
+		return openAPIRef(string(field.Message().FullName()))
+	default:
+		return openAPISchema{"type": "string"}
+	}
+}
+
+func openAPIEnum(schemas map[string]openAPISchema, enum protoreflect.EnumDescriptor) openAPISchema {
+	if enum.FullName() == "google.protobuf.NullValue" {
+		return openAPISchema{"nullable": true}
+	}
+	name := string(enum.FullName())
+	if _, ok := schemas[name]; !ok {
+		values := make([]string, 0, enum.Values().Len())
+		for i := range enum.Values().Len() {
+			values = append(values, string(enum.Values().Get(i).Name()))
+		}
+		schemas[name] = openAPISchema{"type": "string", "enum": values}
+	}
+	return openAPIRef(name)
+}
+
+// openAPIWellKnown returns the schema of the well-known types that protojson
+// encodes specially.
+func openAPIWellKnown(name protoreflect.FullName) (openAPISchema, bool) {
+	switch name {
+	case "google.protobuf.Timestamp":
+		return openAPISchema{"type": "string", "format": "date-time"}, true
+	case "google.protobuf.Duration":
+		return openAPISchema{"type": "string", "pattern": `^-?[0-9]+(\.[0-9]+)?s$`}, true
+	case "google.protobuf.FieldMask":
+		return openAPISchema{"type": "string"}, true
+	case "google.protobuf.Struct":
+		return openAPISchema{"type": "object", "additionalProperties": true}, true
+	case "google.protobuf.ListValue":
+		return openAPISchema{"type": "array", "items": openAPISchema{}}, true
+	case "google.protobuf.Value":
+		return openAPISchema{}, true
+	case "google.protobuf.Empty":
+		return openAPISchema{"type": "object"}, true
+	case "google.protobuf.Any":
+		return openAPISchema{
+			"type":                 "object",
+			"required":             []string{"@type"},
+			"properties":           map[string]openAPISchema{"@type": {"type": "string"}},
+			"additionalProperties": true,
+		}, true
+	case "google.protobuf.BoolValue":
+		return openAPISchema{"type": "boolean", "nullable": true}, true
+	case "google.protobuf.Int32Value":
+		return openAPISchema{"type": "integer", "format": "int32", "nullable": true}, true
+	case "google.protobuf.UInt32Value":
+		return openAPISchema{"type": "integer", "format": "uint32", "minimum": 0, "nullable": true}, true
+	case "google.protobuf.Int64Value":
+		return openAPISchema{"type": "string", "format": "int64", "nullable": true}, true
+	case "google.protobuf.UInt64Value":
+		return openAPISchema{"type": "string", "format": "uint64", "nullable": true}, true
+	case "google.protobuf.FloatValue":
+		return openAPISchema{"type": "number", "format": "float", "nullable": true}, true
+	case "google.protobuf.DoubleValue":
+		return openAPISchema{"type": "number", "format": "double", "nullable": true}, true
+	case "google.protobuf.StringValue":
+		return openAPISchema{"type": "string", "nullable": true}, true
+	case "google.protobuf.BytesValue":
+		return openAPISchema{"type": "string", "format": "byte", "nullable": true}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,92 @@
+package proprdbrt
+
+// Options configures generated tables and CRUD wrappers.
+type Options struct {
+	// DataCodec transforms the data column on write and read. When nil, the
+	// message default from (proprdb.compression) applies.
+	DataCodec DataCodec
+	// Cipher encrypts the data column and (proprdb.encrypted) projections.
+	Cipher Cipher
+	// ConflictStrategies overrides (proprdb.conflict_strategy) by type name.
+	ConflictStrategies map[string]ConflictStrategy
+	// Mergers holds merge functions for ConflictMerge by type name.
+	Mergers map[string]MergeFunc
+	// DeviceID identifies this replica in version vectors. Required for
+	// writes to tables generated with (proprdb.version_vector).
+	DeviceID string
+	// OnConcurrentEdit resolves concurrent edits detected by version
+	// vectors. When nil, the conflict strategy of the type decides.
+	OnConcurrentEdit ConcurrentEditFunc
+	// SyncPolicy filters what WriteJSONL exports per remote.
+	SyncPolicy SyncPolicy
+	// TxRetry controls how CRUD.WithTx retries busy transactions.
+	TxRetry RetryPolicy
+	// QueryObserver, when set, is notified of every statement generated
+	// tables execute.
+	QueryObserver QueryObserver
+	// Instrumentation, when set, receives spans and metrics of generated
+	// operations.
+	Instrumentation Instrumentation
+	// Cache enables the read-through row cache of GetByID per table.
+	Cache CacheOptions
+	// ProtoValidate checks the buf.validate constraints of messages of tables
+	// generated with protovalidate=true; see ProtoValidate.
+	ProtoValidate ProtoValidateFunc
+	// IDGenerator creates and validates row ids. When nil, ids are UUIDv7.
+	IDGenerator IDGenerator
+	// Clock supplies at_ns of writes. When nil, the system clock is used.
+	Clock Clock
+	// RequireTenant makes reads of tables with a (proprdb.tenant_field) fail
+	// with ErrTenantRequired unless scoped with ForTenant.
+	RequireTenant bool
+	// DeferReprojection makes Init leave projections of tables whose
+	// projection schema changed stale until ReprojectTable rebuilds them.
+	DeferReprojection bool
+	// InitConcurrency, when 2 or more, makes CRUD.Init initialize up to this
+	// many tables at a time, for backends where each statement is a round
+	// trip. Core tables are still created first, and each Init stores the
+	// schema hashes of its tables one at a time.
+	InitConcurrency int
+	// SQLite holds the PRAGMAs CRUD.Init applies, e.g. ConcurrentSQLite.
+	SQLite SQLiteOptions
+	// Reader, when set, serves Select, GetByID and the other read-only
+	// methods, e.g. a WAL read pool next to a single writer connection.
+	// Writes, and everything within WithTx, use the writer.
+	Reader DBTX
+	// WriteCoordinator, when set, batches Insert, InsertWithID, UpdateByID
+	// and DeleteByID into group commits.
+	WriteCoordinator *WriteCoordinator
+	// JSONL selects the compression and framing WriteJSONL writes.
+	JSONL JSONLOptions
+	// TypeURLPrefix overrides (proprdb.type_url_prefix), the prefix of the
+	// @type of the records WriteJSONL and snapshots write, e.g.
+	// "types.example.com/". Reads accept any prefix.
+	TypeURLPrefix string
+	// Import controls how ReadJSONL and ReadJSONLBulk handle bad records.
+	Import ImportOptions
+	// UnknownRetention bounds _unknown_types when CRUD.EvictUnknown runs.
+	UnknownRetention UnknownRetention
+	// ChangeFeed makes Init record the changes of its tables in _changes;
+	// see EnableChangeFeed.
+	ChangeFeed bool
+	// DerivedTables are built by Init and refreshed by
+	// CRUD.RefreshDerived; see InitDerivedTables.
+	DerivedTables []DerivedTable
+	// SearchIndex, when set, makes Init record the changes of tables with
+	// (proprdb.search) fields, which CRUD.SyncSearchIndex mirrors into it.
+	SearchIndex SearchIndex
+	// BlobStore, when set, holds the (proprdb.blob_external) values of at
+	// least BlobMinSize bytes, or DefaultBlobMinSize when zero, in place of
+	// the data column; see CollectBlobGarbage.
+	BlobStore   BlobStore
+	BlobMinSize int
+}
+
+// ReadDBTX returns the handle reads of a table writing through q use:
+// Reader when set, else q.
+func (o Options) ReadDBTX(q DBTX) DBTX {
+	if o.Reader != nil {
+		return o.Reader
+	}
+	return q
+}
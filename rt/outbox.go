@@ -0,0 +1,144 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// CoreTableOutboxName holds the checkpoint of each OutboxDrainer consumer.
+const CoreTableOutboxName = "_outbox"
+
+const outboxTableSQL = `CREATE TABLE IF NOT EXISTS ` + CoreTableOutboxName + ` (consumer TEXT PRIMARY KEY, seq INTEGER NOT NULL)`
+
+// DefaultOutboxBatchSize is the batch size of an OutboxDrainer when
+// BatchSize is 0.
+const DefaultOutboxBatchSize = 100
+
+// OutboxPublisher delivers changes to an external system such as Kafka, NATS
+// or a webhook. Publish returns nil only once the whole batch is delivered.
+type OutboxPublisher interface {
+	Publish(ctx context.Context, changes []Change) error
+}
+
+// OutboxPublisherFunc adapts a function to OutboxPublisher.
+type OutboxPublisherFunc func(ctx context.Context, changes []Change) error
+
+func (f OutboxPublisherFunc) Publish(ctx context.Context, changes []Change) error {
+	return f(ctx, changes)
+}
+
+// OutboxDrainer delivers the change feed to Publisher with at-least-once
+// semantics: the changes are the outbox entries, committed with the writes
+// that made them, and the checkpoint of Consumer only advances after a batch
+// was published. A crash in between publishes the batch again.
+type OutboxDrainer struct {
+	DB DBTX
+	// Consumer names the checkpoint, so that several drainers can each
+	// deliver the whole feed.
+	Consumer  string
+	Publisher OutboxPublisher
+	// BatchSize caps the changes per Publish call.
+	BatchSize int
+	// Trim deletes changes once every consumer with a checkpoint has
+	// published them.
+	Trim bool
+}
+
+// Drain publishes the changes after the checkpoint in batches until none
+// are left, and returns how many it published.
+func (d OutboxDrainer) Drain(ctx context.Context) (int64, error) {
+	switch {
+	case d.DB == nil:
+		return 0, errors.New("nil DBTX")
+	case d.Publisher == nil:
+		return 0, errors.New("nil outbox publisher")
+	case d.Consumer == "":
+		return 0, errors.New("empty outbox consumer")
+	}
+	batchSize := d.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultOutboxBatchSize
+	}
+	if _, err := d.DB.ExecContext(ctx, outboxTableSQL); err != nil {
+		return 0, fmt.Errorf("create %s: %w", CoreTableOutboxName, err)
+	}
+	seq, err := OutboxCheckpoint(d.DB, d.Consumer)
+	if err != nil {
+		return 0, err
+	}
+	var published int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return published, err
+		}
+		changes, err := ReadChanges(d.DB, seq, batchSize)
+		if err != nil {
+			return published, err
+		}
+		if len(changes) == 0 {
+			break
+		}
+		if err := d.Publisher.Publish(ctx, changes); err != nil {
+			return published, fmt.Errorf("publish changes after %d: %w", seq, err)
+		}
+		seq = changes[len(changes)-1].Seq
+		if _, err := d.DB.ExecContext(ctx, `INSERT INTO `+CoreTableOutboxName+` (consumer, seq) VALUES (?, ?) ON CONFLICT(consumer) DO UPDATE SET seq = excluded.seq`, d.Consumer, seq); err != nil {
+			return published, fmt.Errorf("checkpoint outbox %s at %d: %w", d.Consumer, seq, err)
+		}
+		published += int64(len(changes))
+	}
+	if d.Trim {
+		if _, err := d.DB.ExecContext(ctx, `DELETE FROM `+CoreTableChangesName+` WHERE seq <= (SELECT MIN(seq) FROM `+CoreTableOutboxName+`)`); err != nil {
+			return published, fmt.Errorf("trim changes: %w", err)
+		}
+	}
+	return published, nil
+}
+
+// Start runs Drain immediately and then every interval until ctx is done.
+// Failures are logged and retried on the next tick. The returned channel is
+// closed once the loop has stopped.
+func (d OutboxDrainer) Start(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			published, err := d.Drain(ctx)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				slog.Error("drain outbox", "consumer", d.Consumer, "published", published, "err", err)
+			case published > 0:
+				slog.Debug("drained outbox", "consumer", d.Consumer, "published", published)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// OutboxCheckpoint returns the seq of the last change published to consumer,
+// 0 before the first.
+func OutboxCheckpoint(q DBTX, consumer string) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	var seq int64
+	err := q.QueryRowContext(context.Background(), `SELECT seq FROM `+CoreTableOutboxName+` WHERE consumer = ?`, consumer).Scan(&seq)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("read outbox checkpoint of %s: %w", consumer, err)
+	}
+	return seq, nil
+}
@@ -0,0 +1,57 @@
+package proprdbrt
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// PathValue returns the value of the scalar field at the dotted path below
+// message, e.g. "address.city", for (proprdb.external_paths) columns. Unset
+// intermediate messages yield the zero value of the field; unknown fields
+// yield nil. Enums are returned as their number.
+func PathValue(message proto.Message, path string) any {
+	current := message.ProtoReflect()
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		field := current.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if field == nil || field.Message() == nil {
+			return nil
+		}
+		current = current.Get(field).Message()
+	}
+	field := current.Descriptor().Fields().ByName(protoreflect.Name(names[len(names)-1]))
+	if field == nil {
+		return nil
+	}
+	value := current.Get(field)
+	if field.Kind() == protoreflect.EnumKind {
+		return int64(value.Enum())
+	}
+	return value.Interface()
+}
+
+// checkScalarPath fails unless path names a singular scalar field of
+// descriptor, reached through singular message fields.
+func checkScalarPath(descriptor protoreflect.MessageDescriptor, path string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		field := descriptor.Fields().ByName(protoreflect.Name(name))
+		switch {
+		case field == nil:
+			return fmt.Errorf("%s has no field %s", descriptor.FullName(), strings.Join(names[:i+1], "."))
+		case field.IsList() || field.IsMap():
+			return fmt.Errorf("field %s of %s is repeated", strings.Join(names[:i+1], "."), descriptor.FullName())
+		case i < len(names)-1:
+			if field.Message() == nil {
+				return fmt.Errorf("field %s of %s is not a message", strings.Join(names[:i+1], "."), descriptor.FullName())
+			}
+			descriptor = field.Message()
+		case field.Message() != nil:
+			return fmt.Errorf("field %s of %s is a message", path, descriptor.FullName())
+		}
+	}
+	return nil
+}
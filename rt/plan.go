@@ -0,0 +1,266 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TableSchema describes what Init of a generated table ensures, for
+// PlanTableInit.
+type TableSchema struct {
+	TableName        string
+	TypeName         string
+	ProjectionSchema string
+	// Columns lists the columns Init adds to an existing table when missing.
+	Columns []string
+	// MapTables lists the map projection side tables.
+	MapTables []string
+	// HistoryTable is the history table of (proprdb.history) tables.
+	HistoryTable   string
+	IndexPrefix    string
+	Indexes        []string
+	HasProjections bool
+}
+
+// InitPlanner is implemented by generated tables.
+type InitPlanner interface {
+	PlanInit() (TablePlan, error)
+}
+
+// InitPlan reports what Init would change, as returned by generated
+// CRUD.PlanInit.
+type InitPlan struct {
+	// CreateCoreTables lists the missing _deleted, _sync, _proprdb_schema
+	// and _unknown_types tables.
+	CreateCoreTables []string
+	Tables           []TablePlan
+}
+
+// Empty reports whether Init would change nothing.
+func (p InitPlan) Empty() bool {
+	if len(p.CreateCoreTables) > 0 {
+		return false
+	}
+	for _, table := range p.Tables {
+		if !table.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// String lists the changes of p, one per line.
+func (p InitPlan) String() string {
+	builder := strings.Builder{}
+	for _, tableName := range p.CreateCoreTables {
+		fmt.Fprintf(&builder, "create table %s\n", tableName)
+	}
+	for _, table := range p.Tables {
+		builder.WriteString(table.String())
+	}
+	return builder.String()
+}
+
+// TablePlan reports what Init of one generated table would change.
+type TablePlan struct {
+	TableName       string
+	CreateTable     bool
+	AddColumns      []string
+	CreateMapTables []string
+	// CreateHistoryTable names the missing history table, if any.
+	CreateHistoryTable string
+	CreateIndexes      []string
+	DropIndexes        []string
+	// RebuildProjections is set when the projection schema changed, so Init
+	// recomputes projected columns of all rows.
+	RebuildProjections bool
+	// DrainUnknownRows counts rows stored while the type was unknown that
+	// Init moves into the table.
+	DrainUnknownRows int64
+}
+
+// Empty reports whether Init would change nothing.
+func (p TablePlan) Empty() bool {
+	return !p.CreateTable && len(p.AddColumns) == 0 && len(p.CreateMapTables) == 0 && p.CreateHistoryTable == "" &&
+		len(p.CreateIndexes) == 0 && len(p.DropIndexes) == 0 && !p.RebuildProjections && p.DrainUnknownRows == 0
+}
+
+// String lists the changes of p, one per line.
+func (p TablePlan) String() string {
+	builder := strings.Builder{}
+	if p.CreateTable {
+		fmt.Fprintf(&builder, "create table %s\n", p.TableName)
+	}
+	for _, column := range p.AddColumns {
+		fmt.Fprintf(&builder, "add column %s.%s\n", p.TableName, column)
+	}
+	for _, tableName := range p.CreateMapTables {
+		fmt.Fprintf(&builder, "create table %s\n", tableName)
+	}
+	if p.CreateHistoryTable != "" {
+		fmt.Fprintf(&builder, "create table %s\n", p.CreateHistoryTable)
+	}
+	for _, indexName := range p.CreateIndexes {
+		fmt.Fprintf(&builder, "create index %s\n", indexName)
+	}
+	for _, indexName := range p.DropIndexes {
+		fmt.Fprintf(&builder, "drop index %s\n", indexName)
+	}
+	if p.RebuildProjections {
+		fmt.Fprintf(&builder, "rebuild projections of %s\n", p.TableName)
+	}
+	if p.DrainUnknownRows > 0 {
+		fmt.Fprintf(&builder, "drain %d unknown rows into %s\n", p.DrainUnknownRows, p.TableName)
+	}
+	return builder.String()
+}
+
+// PlanCoreTables returns a plan listing the missing core tables.
+func PlanCoreTables(q DBTX) (InitPlan, error) {
+	if q == nil {
+		return InitPlan{}, errors.New("nil DBTX")
+	}
+	plan := InitPlan{}
+	for _, table := range coreTables {
+		exists, err := tableExists(q, table.name)
+		if err != nil {
+			return InitPlan{}, err
+		}
+		if !exists {
+			plan.CreateCoreTables = append(plan.CreateCoreTables, table.name)
+		}
+	}
+	return plan, nil
+}
+
+// PlanTableInit reports what Init would change for schema, reading the
+// database without writing to it.
+func PlanTableInit(q DBTX, schema TableSchema) (TablePlan, error) {
+	if q == nil {
+		return TablePlan{}, errors.New("nil DBTX")
+	}
+	plan := TablePlan{TableName: schema.TableName}
+	exists, err := tableExists(q, schema.TableName)
+	if err != nil {
+		return TablePlan{}, err
+	}
+	for _, tableName := range schema.MapTables {
+		mapExists, err := tableExists(q, tableName)
+		if err != nil {
+			return TablePlan{}, err
+		}
+		if !mapExists {
+			plan.CreateMapTables = append(plan.CreateMapTables, tableName)
+		}
+	}
+	if schema.HistoryTable != "" {
+		historyExists, err := tableExists(q, schema.HistoryTable)
+		if err != nil {
+			return TablePlan{}, err
+		}
+		if !historyExists {
+			plan.CreateHistoryTable = schema.HistoryTable
+		}
+	}
+	plan.DrainUnknownRows, err = countUnknownRows(q, schema.TypeName)
+	if err != nil {
+		return TablePlan{}, err
+	}
+	if !exists {
+		plan.CreateTable = true
+		plan.CreateIndexes = append(plan.CreateIndexes, schema.Indexes...)
+		return plan, nil
+	}
+
+	existingColumns, err := queryNames(q, `SELECT name FROM pragma_table_info(?)`, schema.TableName)
+	if err != nil {
+		return TablePlan{}, fmt.Errorf("read columns for %s: %w", schema.TableName, err)
+	}
+	for _, column := range schema.Columns {
+		if !existingColumns[column] {
+			plan.AddColumns = append(plan.AddColumns, column)
+		}
+	}
+	existingIndexes, err := queryNames(q, `SELECT name FROM pragma_index_list(?)`, schema.TableName)
+	if err != nil {
+		return TablePlan{}, fmt.Errorf("read indexes for %s: %w", schema.TableName, err)
+	}
+	desiredIndexes := make(map[string]bool, len(schema.Indexes))
+	for _, indexName := range schema.Indexes {
+		desiredIndexes[indexName] = true
+		if !existingIndexes[indexName] {
+			plan.CreateIndexes = append(plan.CreateIndexes, indexName)
+		}
+	}
+	for indexName := range existingIndexes {
+		if strings.HasPrefix(indexName, schema.IndexPrefix) && !desiredIndexes[indexName] {
+			plan.DropIndexes = append(plan.DropIndexes, indexName)
+		}
+	}
+	slices.Sort(plan.DropIndexes)
+
+	if schema.HasProjections {
+		schemaStateExists, err := tableExists(q, CoreTableSchemaStateName)
+		if err != nil {
+			return TablePlan{}, err
+		}
+		if schemaStateExists {
+			var currentSchema string
+			err := q.QueryRowContext(context.Background(), `SELECT schema_hash FROM `+CoreTableSchemaStateName+` WHERE table_name = ?`, schema.TableName).Scan(&currentSchema)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return TablePlan{}, fmt.Errorf("select schema hash for %s: %w", schema.TableName, err)
+			}
+			plan.RebuildProjections = err == nil && currentSchema != schema.ProjectionSchema
+		}
+	}
+	return plan, nil
+}
+
+func tableExists(q DBTX, tableName string) (bool, error) {
+	var count int
+	if err := q.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, tableName).Scan(&count); err != nil {
+		return false, fmt.Errorf("check table %s: %w", tableName, err)
+	}
+	return count > 0, nil
+}
+
+func countUnknownRows(q DBTX, typeName string) (int64, error) {
+	exists, err := tableExists(q, CoreTableUnknownName)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var count int64
+	if err := q.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM `+CoreTableUnknownName+` WHERE type_name = ?`, typeName).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count unknown rows for %s: %w", typeName, err)
+	}
+	return count, nil
+}
+
+func queryNames(q DBTX, query string, args ...any) (map[string]bool, error) {
+	rows, err := q.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			if closeErr := CloseRows(rows, "name"); closeErr != nil {
+				return nil, fmt.Errorf("scan name: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan name: %w", err)
+		}
+		names[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "name"); closeErr != nil {
+			return nil, fmt.Errorf("iterate names: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate names: %w", err)
+	}
+	return names, CloseRows(rows, "name")
+}
@@ -0,0 +1,206 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// BundleFactory creates a generated CRUD wrapper bound to q.
+type BundleFactory func(q DBTX, opts Options) Bundle
+
+// RegisteredBundle is one generated package known to the registry.
+type RegisteredBundle struct {
+	GoImportPath string
+	Descriptors  []GeneratedTableDescriptor
+	Factory      BundleFactory
+}
+
+var registry = struct {
+	sync.RWMutex
+	bundles []RegisteredBundle
+	tables  map[string]string
+}{tables: make(map[string]string)}
+
+// RegisterTables records the tables of a generated package. Generated code
+// calls it from init; registering the same table twice panics.
+func RegisterTables(goImportPath string, descriptors []GeneratedTableDescriptor, factory BundleFactory) {
+	if factory == nil {
+		panic("proprdb: RegisterTables with nil factory for " + goImportPath)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	for _, descriptor := range descriptors {
+		if descriptor.IsCore {
+			continue
+		}
+		if owner, ok := registry.tables[descriptor.TableName]; ok {
+			panic(fmt.Sprintf("proprdb: table %s registered by both %s and %s", descriptor.TableName, owner, goImportPath))
+		}
+	}
+	for _, descriptor := range descriptors {
+		if !descriptor.IsCore {
+			registry.tables[descriptor.TableName] = goImportPath
+		}
+	}
+	copiedDescriptors := make([]GeneratedTableDescriptor, len(descriptors))
+	copy(copiedDescriptors, descriptors)
+	registry.bundles = append(registry.bundles, RegisteredBundle{
+		GoImportPath: goImportPath,
+		Descriptors:  copiedDescriptors,
+		Factory:      factory,
+	})
+}
+
+// RegisteredBundles returns all registered packages ordered by import path.
+func RegisteredBundles() []RegisteredBundle {
+	registry.RLock()
+	defer registry.RUnlock()
+	bundles := make([]RegisteredBundle, 0, len(registry.bundles))
+	for _, bundle := range registry.bundles {
+		copiedDescriptors := make([]GeneratedTableDescriptor, len(bundle.Descriptors))
+		copy(copiedDescriptors, bundle.Descriptors)
+		bundle.Descriptors = copiedDescriptors
+		bundles = append(bundles, bundle)
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		return bundles[i].GoImportPath < bundles[j].GoImportPath
+	})
+	return bundles
+}
+
+// RegisteredTableDescriptors returns the tables of all registered packages,
+// followed by the core tables once.
+func RegisteredTableDescriptors() []GeneratedTableDescriptor {
+	bundles := RegisteredBundles()
+	groups := make([][]GeneratedTableDescriptor, 0, len(bundles))
+	for _, bundle := range bundles {
+		groups = append(groups, bundle.Descriptors)
+	}
+	return mergeTableDescriptors(groups)
+}
+
+// mergeTableDescriptors lists the generated tables of groups followed by
+// the core tables once.
+func mergeTableDescriptors(groups [][]GeneratedTableDescriptor) []GeneratedTableDescriptor {
+	descriptors := make([]GeneratedTableDescriptor, 0)
+	coreDescriptors := make([]GeneratedTableDescriptor, 0, len(coreTableNames))
+	coreSeen := make(map[string]bool)
+	for _, group := range groups {
+		for _, descriptor := range group {
+			if !descriptor.IsCore {
+				descriptors = append(descriptors, descriptor)
+				continue
+			}
+			if coreSeen[descriptor.TableName] {
+				continue
+			}
+			coreSeen[descriptor.TableName] = true
+			coreDescriptors = append(coreDescriptors, descriptor)
+		}
+	}
+	return append(descriptors, coreDescriptors...)
+}
+
+// RegisteredDispatch hands records of types another registered package
+// generates to that package's CRUD, so that ReadJSONL of one package does not
+// park them in _unknown_types. Records are only dispatched when the table of
+// their type is initialized in the database; others stay unknown. A nil
+// RegisteredDispatch dispatches nothing.
+type RegisteredDispatch struct {
+	q      DBTX
+	opts   Options
+	remote string
+	// owners maps type names to registered bundles and their table, built
+	// on first use.
+	owners map[string]dispatchOwner
+	// initialized caches whether tables are in _proprdb_schema.
+	initialized map[string]bool
+	pending     map[string]*bytes.Buffer
+	order       []RegisteredBundle
+}
+
+type dispatchOwner struct {
+	bundle    RegisteredBundle
+	tableName string
+}
+
+// NewRegisteredDispatch dispatches records read for remote. The bundles are
+// bound to q with opts, less its JSONL options, as they read plain streams.
+func NewRegisteredDispatch(q DBTX, opts Options, remote string) *RegisteredDispatch {
+	opts.JSONL = JSONLOptions{}
+	return &RegisteredDispatch{q: q, opts: opts, remote: remote, initialized: make(map[string]bool), pending: make(map[string]*bytes.Buffer)}
+}
+
+// Queue keeps record for Flush when a registered package with an
+// initialized table owns typeName, and reports whether it did.
+func (d *RegisteredDispatch) Queue(typeName string, record JSONLRecord) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+	if d.owners == nil {
+		d.owners = make(map[string]dispatchOwner)
+		for _, bundle := range RegisteredBundles() {
+			for _, descriptor := range bundle.Descriptors {
+				if !descriptor.IsCore && descriptor.SyncEnabled {
+					d.owners[descriptor.TypeName] = dispatchOwner{bundle: bundle, tableName: descriptor.TableName}
+				}
+			}
+		}
+	}
+	owner, ok := d.owners[typeName]
+	if !ok {
+		return false, nil
+	}
+	initialized, ok := d.initialized[owner.tableName]
+	if !ok {
+		var found int
+		err := d.q.QueryRowContext(context.Background(), `SELECT 1 FROM `+CoreTableSchemaStateName+` WHERE table_name = ?`, owner.tableName).Scan(&found)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return false, fmt.Errorf("look up table %s: %w", owner.tableName, err)
+		default:
+			initialized = true
+		}
+		d.initialized[owner.tableName] = initialized
+	}
+	if !initialized {
+		return false, nil
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return false, fmt.Errorf("encode %s record %s: %w", typeName, record.ID, err)
+	}
+	path := owner.bundle.GoImportPath
+	buffer, ok := d.pending[path]
+	if !ok {
+		buffer = &bytes.Buffer{}
+		d.pending[path] = buffer
+		d.order = append(d.order, owner.bundle)
+	}
+	buffer.Write(encoded)
+	buffer.WriteByte('\n')
+	return true, nil
+}
+
+// Flush imports the queued records with the CRUD of each package, in the
+// order the packages were first queued.
+func (d *RegisteredDispatch) Flush() error {
+	if d == nil {
+		return nil
+	}
+	for _, bundle := range d.order {
+		if err := bundle.Factory(d.q, d.opts).ReadJSONL(d.remote, d.pending[bundle.GoImportPath]); err != nil {
+			return fmt.Errorf("dispatch records to %s: %w", bundle.GoImportPath, err)
+		}
+		delete(d.pending, bundle.GoImportPath)
+	}
+	d.order = d.order[:0]
+	return nil
+}
@@ -0,0 +1,144 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RejectedError marks an imported record that can never be applied as is,
+// such as data that does not unmarshal or fails validation. Reads keep such
+// records in _rejected instead of failing.
+type RejectedError struct {
+	Err error
+}
+
+// Reject wraps err in a RejectedError.
+func Reject(err error) error {
+	return &RejectedError{Err: err}
+}
+
+func (e *RejectedError) Error() string {
+	return "rejected: " + e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// RejectedRecord is a row of _rejected.
+type RejectedRecord struct {
+	ID     int64
+	Remote string
+	// Line is the record as read, without the trailing newline. It is not
+	// valid JSON only for records that did not decode.
+	Line         json.RawMessage
+	Error        string
+	RejectedAtNs int64
+}
+
+// RejectedInsert stores the record line in _rejected with the error that
+// rejected it.
+func RejectedInsert(q DBTX, remote string, line []byte, cause error, atNs int64) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if cause == nil {
+		return errors.New("nil error")
+	}
+	insertSQL := `INSERT INTO ` + CoreTableRejectedName + ` (remote, line, error, rejected_at_ns) VALUES (?, ?, ?, ?)`
+	if _, err := q.ExecContext(context.Background(), insertSQL, remote, string(line), cause.Error(), atNs); err != nil {
+		return fmt.Errorf("insert rejected record: %w", err)
+	}
+	return nil
+}
+
+// ListRejected returns the rows of _rejected, oldest first.
+func ListRejected(q DBTX) ([]RejectedRecord, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT id, remote, line, error, rejected_at_ns FROM `+CoreTableRejectedName+` ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("select rejected rows: %w", err)
+	}
+	records := make([]RejectedRecord, 0)
+	for rows.Next() {
+		var record RejectedRecord
+		var line string
+		if err := rows.Scan(&record.ID, &record.Remote, &line, &record.Error, &record.RejectedAtNs); err != nil {
+			if closeErr := CloseRows(rows, "rejected"); closeErr != nil {
+				return nil, fmt.Errorf("scan rejected row: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan rejected row: %w", err)
+		}
+		record.Line = json.RawMessage(line)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "rejected"); closeErr != nil {
+			return nil, fmt.Errorf("iterate rejected rows: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate rejected rows: %w", err)
+	}
+	if err := CloseRows(rows, "rejected"); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// PurgeRejected deletes the rows of _rejected with the given ids, or all
+// rows when none are given, and returns how many it deleted.
+func PurgeRejected(q DBTX, ids ...int64) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	deleteSQL := `DELETE FROM ` + CoreTableRejectedName
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	if len(ids) > 0 {
+		deleteSQL += ` WHERE id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + `)`
+	}
+	result, err := q.ExecContext(context.Background(), deleteSQL, args...)
+	if err != nil {
+		return 0, fmt.Errorf("purge rejected rows: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("purge rejected rows: %w", err)
+	}
+	return purged, nil
+}
+
+// RetryRejected removes every row of _rejected and reads its line again with
+// read, which quarantines the records that still fail. It returns how many
+// records were not rejected again. Run it in a transaction so that an error
+// keeps the rows.
+func RetryRejected(q DBTX, read func(remote string, r io.Reader) error) (int, error) {
+	if read == nil {
+		return 0, errors.New("nil read")
+	}
+	records, err := ListRejected(q)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := PurgeRejected(q); err != nil {
+		return 0, err
+	}
+	for _, record := range records {
+		if err := read(record.Remote, bytes.NewReader(record.Line)); err != nil {
+			return 0, fmt.Errorf("retry rejected record %d: %w", record.ID, err)
+		}
+	}
+	var remaining int
+	if err := q.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM `+CoreTableRejectedName).Scan(&remaining); err != nil {
+		return 0, fmt.Errorf("count rejected rows: %w", err)
+	}
+	return len(records) - remaining, nil
+}
@@ -0,0 +1,143 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrRemoteExists is returned by RenameRemote when the new name already has
+// _sync rows.
+var ErrRemoteExists = errors.New("remote already exists")
+
+// ListRemotes returns the remotes with rows in _sync, ordered by name.
+func ListRemotes(q DBTX) ([]string, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT DISTINCT remote FROM `+CoreTableSyncName+` ORDER BY remote`)
+	if err != nil {
+		return nil, fmt.Errorf("select remotes: %w", err)
+	}
+	remotes := make([]string, 0)
+	for rows.Next() {
+		var remote string
+		if err := rows.Scan(&remote); err != nil {
+			if closeErr := CloseRows(rows, "remotes"); closeErr != nil {
+				return nil, fmt.Errorf("scan remote: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan remote: %w", err)
+		}
+		remotes = append(remotes, remote)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "remotes"); closeErr != nil {
+			return nil, fmt.Errorf("iterate remotes: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate remotes: %w", err)
+	}
+	if err := CloseRows(rows, "remotes"); err != nil {
+		return nil, err
+	}
+	return remotes, nil
+}
+
+// TableLag compares the newest local change of a table with the newest one
+// acknowledged for a remote.
+type TableLag struct {
+	TableName string `json:"table"`
+	// LocalMaxAtNs is the newest at_ns of the rows and tombstones of the
+	// table, 0 when it has none.
+	LocalMaxAtNs int64 `json:"localMaxAtNs"`
+	// SyncedMaxAtNs is the newest at_ns in _sync for the table and remote,
+	// 0 when nothing has been exported to it.
+	SyncedMaxAtNs int64 `json:"syncedMaxAtNs"`
+	// LagNs is LocalMaxAtNs minus SyncedMaxAtNs, or 0 when the remote is
+	// not behind.
+	LagNs int64 `json:"lagNs"`
+}
+
+// SyncLag returns the lag of remote for each of tableNames, or for the
+// tables recorded in _proprdb_schema when tableNames is empty.
+func SyncLag(q DBTX, remote string, tableNames []string) ([]TableLag, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	if len(tableNames) == 0 {
+		descriptors, err := DiscoverTableDescriptors(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, descriptor := range descriptors {
+			if !descriptor.IsCore {
+				tableNames = append(tableNames, descriptor.TableName)
+			}
+		}
+	}
+	ctx := context.Background()
+	lags := make([]TableLag, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		lag := TableLag{TableName: tableName}
+		var localMaxAtNs, syncedMaxAtNs sql.NullInt64
+		selectLocalSQL := `SELECT MAX(at_ns) FROM (SELECT at_ns FROM "` + tableName + `" UNION ALL SELECT at_ns FROM ` + CoreTableDeletedName + ` WHERE table_name = ?)`
+		if err := q.QueryRowContext(ctx, selectLocalSQL, tableName).Scan(&localMaxAtNs); err != nil {
+			return nil, fmt.Errorf("select newest at_ns of %s: %w", tableName, err)
+		}
+		selectSyncedSQL := `SELECT MAX(at_ns) FROM ` + CoreTableSyncName + ` WHERE table_name = ? AND remote = ?`
+		if err := q.QueryRowContext(ctx, selectSyncedSQL, tableName, remote).Scan(&syncedMaxAtNs); err != nil {
+			return nil, fmt.Errorf("select newest synced at_ns of %s for %s: %w", tableName, remote, err)
+		}
+		lag.LocalMaxAtNs = localMaxAtNs.Int64
+		lag.SyncedMaxAtNs = syncedMaxAtNs.Int64
+		lag.LagNs = max(0, lag.LocalMaxAtNs-lag.SyncedMaxAtNs)
+		lags = append(lags, lag)
+	}
+	return lags, nil
+}
+
+// ForgetRemote deletes the _sync rows of remote, so that its next export
+// sends everything again. It returns how many rows it deleted.
+func ForgetRemote(q DBTX, remote string) (int64, error) {
+	if q == nil {
+		return 0, errors.New("nil DBTX")
+	}
+	result, err := q.ExecContext(context.Background(), `DELETE FROM `+CoreTableSyncName+` WHERE remote = ?`, remote)
+	if err != nil {
+		return 0, fmt.Errorf("forget remote %s: %w", remote, err)
+	}
+	forgotten, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("count forgotten sync rows of %s: %w", remote, err)
+	}
+	return forgotten, nil
+}
+
+// RenameRemote moves the _sync rows of from to to. It fails with
+// ErrRemoteExists when to already has rows, as merging them could skip
+// records neither remote has seen.
+func RenameRemote(q DBTX, from, to string) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if to == "" {
+		return errors.New("empty remote name")
+	}
+	if from == to {
+		return nil
+	}
+	return InTx(q, func(tx DBTX) error {
+		ctx := context.Background()
+		var existing int64
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+CoreTableSyncName+` WHERE remote = ?`, to).Scan(&existing); err != nil {
+			return fmt.Errorf("count sync rows of %s: %w", to, err)
+		}
+		if existing > 0 {
+			return fmt.Errorf("rename remote %s to %s: %w", from, to, ErrRemoteExists)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE `+CoreTableSyncName+` SET remote = ? WHERE remote = ?`, to, from); err != nil {
+			return fmt.Errorf("rename remote %s to %s: %w", from, to, err)
+		}
+		return nil
+	})
+}
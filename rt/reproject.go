@@ -0,0 +1,117 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ReprojectStateTableName holds the cursor of interrupted projection
+// rebuilds, so ReprojectTable resumes where it stopped.
+const ReprojectStateTableName = "_proprdb_reproject"
+
+// DefaultReprojectBatchSize is the number of rows ReprojectTable rebuilds
+// per batch when batchSize is not positive.
+const DefaultReprojectBatchSize = 1000
+
+// ReprojectBatchFunc rebuilds the projections of at most limit rows with
+// ids after afterID, in id order. It returns the last id it visited and the
+// number of rows visited; fewer than limit rows means the table is done.
+type ReprojectBatchFunc func(ctx context.Context, afterID string, limit int) (string, int, error)
+
+// ReprojectTable rebuilds the projections of tableName in batches of
+// batchSize rows through reprojectBatch, calling progress after each batch.
+// The cursor is stored after every batch: a later call for the same
+// projection schema continues after the last finished batch. Once all rows
+// are done the schema hash of the table is updated to projectionSchema.
+func ReprojectTable(ctx context.Context, q DBTX, tableName, projectionSchema string, batchSize int, progress func(done, total int64), reprojectBatch ReprojectBatchFunc) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultReprojectBatchSize
+	}
+	if _, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+ReprojectStateTableName+` (table_name TEXT PRIMARY KEY, schema_hash TEXT NOT NULL, last_id TEXT NOT NULL)`); err != nil {
+		return fmt.Errorf("create %s table: %w", ReprojectStateTableName, err)
+	}
+	var cursorSchema, lastID string
+	err := q.QueryRowContext(ctx, `SELECT schema_hash, last_id FROM `+ReprojectStateTableName+` WHERE table_name = ?`, tableName).Scan(&cursorSchema, &lastID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("select reprojection cursor for %s: %w", tableName, err)
+	}
+	if cursorSchema != projectionSchema {
+		// A rebuild for another schema left the cursor; start over.
+		lastID = ""
+	}
+	var total, done int64
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(*), COUNT(CASE WHEN id <= ? THEN 1 END) FROM "`+tableName+`"`, lastID).Scan(&total, &done); err != nil {
+		return fmt.Errorf("count rows for reprojection of %s: %w", tableName, err)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batchLastID, visited, err := reprojectBatch(ctx, lastID, batchSize)
+		if err != nil {
+			return err
+		}
+		if visited > 0 {
+			lastID = batchLastID
+			done += int64(visited)
+			if _, err := q.ExecContext(ctx, `INSERT INTO `+ReprojectStateTableName+` (table_name, schema_hash, last_id) VALUES (?, ?, ?) ON CONFLICT(table_name) DO UPDATE SET schema_hash = excluded.schema_hash, last_id = excluded.last_id`, tableName, projectionSchema, lastID); err != nil {
+				return fmt.Errorf("store reprojection cursor for %s: %w", tableName, err)
+			}
+			if progress != nil {
+				progress(done, max(total, done))
+			}
+		}
+		if visited < batchSize {
+			break
+		}
+	}
+	if err := StoreSchemaHash(q, tableName, projectionSchema); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM `+ReprojectStateTableName+` WHERE table_name = ?`, tableName); err != nil {
+		return fmt.Errorf("clear reprojection cursor for %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// SchemaChangeFunc is a hook registered with a generated table's
+// OnSchemaChange. It runs in tx when the projection schema of the table
+// changed from oldHash to newHash, e.g. to backfill data for new
+// projections as part of an upgrade.
+type SchemaChangeFunc func(oldHash, newHash string, tx DBTX) error
+
+// RunSchemaHooks runs hooks in one transaction when the stored schema hash
+// of tableName is neither missing nor projectionSchema. The generated
+// tables call it before rebuilding their projections, so the rebuild sees
+// the data the hooks wrote, and a failing hook leaves the schema hash alone
+// for the next attempt. Hooks run again when the rebuild fails after them,
+// so they must be idempotent.
+func RunSchemaHooks(ctx context.Context, q DBTX, tableName, projectionSchema string, hooks []SchemaChangeFunc) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	var oldHash string
+	err := q.QueryRowContext(ctx, `SELECT schema_hash FROM `+CoreTableSchemaStateName+` WHERE table_name = ?`, tableName).Scan(&oldHash)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// A new table has nothing to migrate.
+		return nil
+	case err != nil:
+		return fmt.Errorf("select schema hash for %s: %w", tableName, err)
+	case oldHash == projectionSchema:
+		return nil
+	}
+	return WithTxRetry(ctx, q, RetryPolicy{Attempts: 1}, func(tx DBTX) error {
+		for _, hook := range hooks {
+			if err := hook(oldHash, projectionSchema, tx); err != nil {
+				return fmt.Errorf("schema change hook for %s: %w", tableName, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,40 @@
+package proprdbrt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrRevisionMismatch is wrapped by the generated UpdateByIDIfRevision and
+// DeleteByIDIfRevision when the row was written since its revision was read.
+var ErrRevisionMismatch = errors.New("revision mismatch")
+
+// revisionHashBytes is how many bytes of the data hash a revision carries.
+const revisionHashBytes = 12
+
+// Revision returns the revision token of a row written at atNs with data.
+// Local writes give a row a new at_ns, but synced records can replace a row
+// keeping its at_ns, or even move it back to an older one, so the token also
+// covers a hash of the deterministic encoding of data. Tokens are opaque to
+// clients, which only send them back unchanged.
+func Revision(atNs int64, data proto.Message) string {
+	// Stored rows always encode; a failure hashes no bytes, which still
+	// differs from the token of any encodable content.
+	encoded, _ := proto.MarshalOptions{Deterministic: true}.Marshal(data)
+	sum := sha256.Sum256(encoded)
+	return strconv.FormatInt(atNs, 36) + "." + base64.RawURLEncoding.EncodeToString(sum[:revisionHashBytes])
+}
+
+// CheckRevision returns an error wrapping ErrRevisionMismatch unless
+// revision is the revision of tableName/id as written at atNs with data.
+func CheckRevision(tableName, id string, atNs int64, data proto.Message, revision string) error {
+	if current := Revision(atNs, data); revision != current {
+		return fmt.Errorf("%s/%s is at revision %s, not %q: %w", tableName, id, current, revision, ErrRevisionMismatch)
+	}
+	return nil
+}
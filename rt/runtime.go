@@ -23,6 +23,8 @@ const (
 	CoreTableSyncName        = "_sync"
 	CoreTableSchemaStateName = "_proprdb_schema"
 	CoreTableUnknownName     = "_unknown_types"
+	CoreTableRejectedName    = "_rejected"
+	CoreTableDescriptorsName = "_descriptors"
 	dataColumnName           = "data"
 )
 
@@ -32,11 +34,25 @@ type DBTX interface {
 	QueryRowContext(context.Context, string, ...any) *sql.Row
 }
 
+// IDsWhere returns a Select where clause matching any of ids, which must not
+// be empty, and its arguments.
+func IDsWhere(ids []string) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")", args
+}
+
 type JSONLRecord struct {
-	ID      string          `json:"id"`
-	Deleted bool            `json:"deleted,omitempty"`
-	AtNs    int64           `json:"atNs"`
-	Data    json.RawMessage `json:"data"`
+	// Version is the shape of the record, 0 for version 1; see
+	// JSONLRecordVersion.
+	Version       int             `json:"v,omitempty"`
+	ID            string          `json:"id"`
+	Deleted       bool            `json:"deleted,omitempty"`
+	AtNs          int64           `json:"atNs"`
+	VersionVector VersionVector   `json:"vv,omitempty"`
+	Data          json.RawMessage `json:"data"`
 }
 
 type GeneratedTableDescriptor struct {
@@ -52,26 +68,38 @@ type TableIntrospection struct {
 	DiskUsageBytes int64
 }
 
+// coreTables lists the core tables with their DDL, in creation order.
+var coreTables = []struct {
+	name      string
+	createSQL string
+}{
+	{CoreTableDeletedName, `CREATE TABLE IF NOT EXISTS ` + CoreTableDeletedName + ` (table_name TEXT NOT NULL, id TEXT NOT NULL, at_ns INTEGER NOT NULL, PRIMARY KEY (table_name, id))`},
+	{CoreTableSyncName, `CREATE TABLE IF NOT EXISTS ` + CoreTableSyncName + ` (object_id TEXT NOT NULL, table_name TEXT NOT NULL, at_ns INTEGER NOT NULL, remote TEXT NOT NULL, PRIMARY KEY (object_id, table_name, remote))`},
+	{CoreTableSchemaStateName, `CREATE TABLE IF NOT EXISTS ` + CoreTableSchemaStateName + ` (table_name TEXT PRIMARY KEY, schema_hash TEXT NOT NULL)`},
+	{CoreTableUnknownName, `CREATE TABLE IF NOT EXISTS ` + CoreTableUnknownName + ` (type_name TEXT NOT NULL, id TEXT NOT NULL, at_ns INTEGER NOT NULL, deleted INTEGER NOT NULL, data_json TEXT NOT NULL, PRIMARY KEY (type_name, id, at_ns))`},
+	{CoreTableRejectedName, `CREATE TABLE IF NOT EXISTS ` + CoreTableRejectedName + ` (id INTEGER PRIMARY KEY, remote TEXT NOT NULL, line TEXT NOT NULL, error TEXT NOT NULL, rejected_at_ns INTEGER NOT NULL)`},
+	{CoreTableDescriptorsName, `CREATE TABLE IF NOT EXISTS ` + CoreTableDescriptorsName + ` (file TEXT PRIMARY KEY, descriptor BLOB NOT NULL, at_ns INTEGER NOT NULL)`},
+}
+
+// CoreTablesSQL returns the DDL of the core tables shared by all generated
+// tables.
+func CoreTablesSQL() []string {
+	statements := make([]string, 0, len(coreTables))
+	for _, table := range coreTables {
+		statements = append(statements, table.createSQL)
+	}
+	return statements
+}
+
 func EnsureCoreTables(q DBTX) error {
 	if q == nil {
 		return errors.New("nil DBTX")
 	}
 	ctx := context.Background()
-	createDeletedTableSQL := `CREATE TABLE IF NOT EXISTS ` + CoreTableDeletedName + ` (table_name TEXT NOT NULL, id TEXT NOT NULL, at_ns INTEGER NOT NULL, PRIMARY KEY (table_name, id))`
-	if _, err := q.ExecContext(ctx, createDeletedTableSQL); err != nil {
-		return fmt.Errorf("create _deleted table: %w", err)
-	}
-	createSyncTableSQL := `CREATE TABLE IF NOT EXISTS ` + CoreTableSyncName + ` (object_id TEXT NOT NULL, table_name TEXT NOT NULL, at_ns INTEGER NOT NULL, remote TEXT NOT NULL, PRIMARY KEY (object_id, table_name, remote))`
-	if _, err := q.ExecContext(ctx, createSyncTableSQL); err != nil {
-		return fmt.Errorf("create _sync table: %w", err)
-	}
-	createSchemaStateTableSQL := `CREATE TABLE IF NOT EXISTS ` + CoreTableSchemaStateName + ` (table_name TEXT PRIMARY KEY, schema_hash TEXT NOT NULL)`
-	if _, err := q.ExecContext(ctx, createSchemaStateTableSQL); err != nil {
-		return fmt.Errorf("create _proprdb_schema table: %w", err)
-	}
-	createUnknownTableSQL := `CREATE TABLE IF NOT EXISTS ` + CoreTableUnknownName + ` (type_name TEXT NOT NULL, id TEXT NOT NULL, at_ns INTEGER NOT NULL, deleted INTEGER NOT NULL, data_json TEXT NOT NULL, PRIMARY KEY (type_name, id, at_ns))`
-	if _, err := q.ExecContext(ctx, createUnknownTableSQL); err != nil {
-		return fmt.Errorf("create _unknown_types table: %w", err)
+	for _, table := range coreTables {
+		if _, err := q.ExecContext(ctx, table.createSQL); err != nil {
+			return fmt.Errorf("create %s table: %w", table.name, err)
+		}
 	}
 	return nil
 }
@@ -170,22 +198,39 @@ func UUIDv7() (string, error) {
 func ValidateUUID(id string) error {
 	parts := strings.Split(id, "-")
 	if len(parts) != 5 {
-		return fmt.Errorf("invalid uuid %q: expected 5 parts", id)
+		return InvalidIDError(fmt.Errorf("invalid uuid %q: expected 5 parts", id))
 	}
 	lengths := []int{8, 4, 4, 4, 12}
 	for index, part := range parts {
 		if len(part) != lengths[index] {
-			return fmt.Errorf("invalid uuid %q: unexpected length for part %d", id, index+1)
+			return InvalidIDError(fmt.Errorf("invalid uuid %q: unexpected length for part %d", id, index+1))
 		}
 		if _, err := hex.DecodeString(part); err != nil {
-			return fmt.Errorf("invalid uuid %q: %w", id, err)
+			return InvalidIDError(fmt.Errorf("invalid uuid %q: %w", id, err))
 		}
 	}
 	return nil
 }
 
+// DefaultTypeURLPrefix is the prefix of the @type of records written
+// without Options.TypeURLPrefix or (proprdb.type_url_prefix).
+const DefaultTypeURLPrefix = "type.googleapis.com/"
+
 func TypeURL(typeName string) string {
-	return "type.googleapis.com/" + typeName
+	return TypeURLWithPrefix("", typeName)
+}
+
+// TypeURLWithPrefix returns the type URL of typeName under prefix, or under
+// DefaultTypeURLPrefix when prefix is empty. A slash separates prefix from
+// typeName unless prefix ends with one.
+func TypeURLWithPrefix(prefix, typeName string) string {
+	if prefix == "" {
+		prefix = DefaultTypeURLPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + typeName
 }
 
 func TypeNameFromURL(typeURL string) string {
@@ -200,10 +245,17 @@ func TypeNameFromURL(typeURL string) string {
 }
 
 func MarshalAnyJSON(message proto.Message) (json.RawMessage, error) {
+	return MarshalAnyJSONWithPrefix("", message)
+}
+
+// MarshalAnyJSONWithPrefix is MarshalAnyJSON with the type URL under
+// prefix; see TypeURLWithPrefix.
+func MarshalAnyJSONWithPrefix(prefix string, message proto.Message) (json.RawMessage, error) {
 	anyMessage, err := anypb.New(message)
 	if err != nil {
 		return nil, fmt.Errorf("marshal any wrapper: %w", err)
 	}
+	anyMessage.TypeUrl = TypeURLWithPrefix(prefix, string(message.ProtoReflect().Descriptor().FullName()))
 	dataJSON, err := protojson.Marshal(anyMessage)
 	if err != nil {
 		return nil, fmt.Errorf("marshal any as json: %w", err)
@@ -212,7 +264,13 @@ func MarshalAnyJSON(message proto.Message) (json.RawMessage, error) {
 }
 
 func MarshalTypeOnlyAnyJSON(typeName string) (json.RawMessage, error) {
-	anyMessage := &anypb.Any{TypeUrl: TypeURL(typeName)}
+	return MarshalTypeOnlyAnyJSONWithPrefix("", typeName)
+}
+
+// MarshalTypeOnlyAnyJSONWithPrefix is MarshalTypeOnlyAnyJSON with the type
+// URL under prefix; see TypeURLWithPrefix.
+func MarshalTypeOnlyAnyJSONWithPrefix(prefix, typeName string) (json.RawMessage, error) {
+	anyMessage := &anypb.Any{TypeUrl: TypeURLWithPrefix(prefix, typeName)}
 	dataJSON, err := protojson.Marshal(anyMessage)
 	if err != nil {
 		return nil, fmt.Errorf("marshal type-only any as json: %w", err)
@@ -220,22 +278,10 @@ func MarshalTypeOnlyAnyJSON(typeName string) (json.RawMessage, error) {
 	return json.RawMessage(dataJSON), nil
 }
 
+// ReadJSONL visits the records of r, which may be gzip compressed and
+// length-prefixed as written with JSONLOptions.
 func ReadJSONL(r io.Reader, visit func(JSONLRecord, int) error) error {
-	decoder := json.NewDecoder(r)
-	lineNumber := 0
-	for {
-		lineNumber++
-		var record JSONLRecord
-		if err := decoder.Decode(&record); err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil
-			}
-			return fmt.Errorf("decode jsonl line %d: %w", lineNumber, err)
-		}
-		if err := visit(record, lineNumber); err != nil {
-			return err
-		}
-	}
+	return ReadJSONLWithOptions(r, JSONLOptions{}, visit)
 }
 
 type anyTypeEnvelope struct {
@@ -0,0 +1,170 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// SchemaLock is the content of the <file>.proprdb.lock.json emitted by the
+// lock= plugin parameter.
+type SchemaLock struct {
+	Tables []SchemaLockTable `json:"tables"`
+}
+
+// SchemaLockTable is the schema of one generated table.
+type SchemaLockTable struct {
+	Table string `json:"table"`
+	Type  string `json:"type"`
+	// SchemaHash is the projection schema Init stores in _proprdb_schema.
+	SchemaHash string             `json:"schemaHash"`
+	Columns    []SchemaLockColumn `json:"columns"`
+	// IndexPrefix is the prefix of the generated index names.
+	IndexPrefix string   `json:"indexPrefix"`
+	Indexes     []string `json:"indexes"`
+}
+
+// SchemaLockColumn is a projected field: a field name, or the path of an
+// (proprdb.external_paths) column, and its column or map side table.
+type SchemaLockColumn struct {
+	Field  string `json:"field"`
+	Column string `json:"column"`
+	// SideTable is set when Column names the side table of a map.
+	SideTable bool `json:"sideTable,omitempty"`
+}
+
+// ReadSchemaLock decodes a schema lock file.
+func ReadSchemaLock(r io.Reader) (SchemaLock, error) {
+	var lock SchemaLock
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&lock); err != nil {
+		return SchemaLock{}, fmt.Errorf("decode schema lock: %w", err)
+	}
+	return lock, nil
+}
+
+// SchemaDrift is a difference between a database and a schema lock.
+type SchemaDrift struct {
+	Table  string
+	Detail string
+}
+
+// String renders d as "table: detail".
+func (d SchemaDrift) String() string {
+	return d.Table + ": " + d.Detail
+}
+
+// VerifySchemaLock compares the database with locks, reading it without
+// writing to it, and lists the drift: missing tables, columns, side tables
+// and indexes, generated indexes the locks do not list, projection schemas
+// other than the locked ones, and tables in _proprdb_schema that no lock
+// lists. Columns the locks no longer list are left alone, as Init keeps
+// them. An empty result means the database matches.
+func VerifySchemaLock(ctx context.Context, q DBTX, locks ...SchemaLock) ([]SchemaDrift, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	schemaStateExists, err := tableExists(q, CoreTableSchemaStateName)
+	if err != nil {
+		return nil, err
+	}
+	drift := make([]SchemaDrift, 0)
+	locked := make(map[string]bool)
+	for _, lock := range locks {
+		for _, table := range lock.Tables {
+			locked[table.Table] = true
+			tableDrift, err := verifyLockedTable(ctx, q, table, schemaStateExists)
+			if err != nil {
+				return nil, err
+			}
+			drift = append(drift, tableDrift...)
+		}
+	}
+	if !schemaStateExists {
+		return drift, nil
+	}
+	stored, err := queryNames(q, `SELECT table_name FROM `+CoreTableSchemaStateName)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", CoreTableSchemaStateName, err)
+	}
+	unlocked := make([]string, 0)
+	for tableName := range stored {
+		if !locked[tableName] {
+			unlocked = append(unlocked, tableName)
+		}
+	}
+	slices.Sort(unlocked)
+	for _, tableName := range unlocked {
+		drift = append(drift, SchemaDrift{Table: tableName, Detail: "table is not in the schema lock"})
+	}
+	return drift, nil
+}
+
+func verifyLockedTable(ctx context.Context, q DBTX, table SchemaLockTable, schemaStateExists bool) ([]SchemaDrift, error) {
+	exists, err := tableExists(q, table.Table)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []SchemaDrift{{Table: table.Table, Detail: "missing table"}}, nil
+	}
+	var drift []SchemaDrift
+	existingColumns, err := queryNames(q, `SELECT name FROM pragma_table_info(?)`, table.Table)
+	if err != nil {
+		return nil, fmt.Errorf("read columns for %s: %w", table.Table, err)
+	}
+	for _, column := range table.Columns {
+		if column.SideTable {
+			sideExists, err := tableExists(q, column.Column)
+			if err != nil {
+				return nil, err
+			}
+			if !sideExists {
+				drift = append(drift, SchemaDrift{Table: table.Table, Detail: fmt.Sprintf("missing side table %s of %s", column.Column, column.Field)})
+			}
+			continue
+		}
+		if !existingColumns[column.Column] {
+			drift = append(drift, SchemaDrift{Table: table.Table, Detail: fmt.Sprintf("missing column %s of %s", column.Column, column.Field)})
+		}
+	}
+	existingIndexes, err := queryNames(q, `SELECT name FROM pragma_index_list(?)`, table.Table)
+	if err != nil {
+		return nil, fmt.Errorf("read indexes for %s: %w", table.Table, err)
+	}
+	for _, indexName := range table.Indexes {
+		if !existingIndexes[indexName] {
+			drift = append(drift, SchemaDrift{Table: table.Table, Detail: "missing index " + indexName})
+		}
+	}
+	extraIndexes := make([]string, 0)
+	for indexName := range existingIndexes {
+		if table.IndexPrefix != "" && strings.HasPrefix(indexName, table.IndexPrefix) && !slices.Contains(table.Indexes, indexName) {
+			extraIndexes = append(extraIndexes, indexName)
+		}
+	}
+	slices.Sort(extraIndexes)
+	for _, indexName := range extraIndexes {
+		drift = append(drift, SchemaDrift{Table: table.Table, Detail: "index " + indexName + " is not in the schema lock"})
+	}
+	if !schemaStateExists {
+		return append(drift, SchemaDrift{Table: table.Table, Detail: "no schema hash"}), nil
+	}
+	var schemaHash string
+	err = q.QueryRowContext(ctx, `SELECT schema_hash FROM `+CoreTableSchemaStateName+` WHERE table_name = ?`, table.Table).Scan(&schemaHash)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		drift = append(drift, SchemaDrift{Table: table.Table, Detail: "no schema hash"})
+	case err != nil:
+		return nil, fmt.Errorf("select schema hash for %s: %w", table.Table, err)
+	case schemaHash != table.SchemaHash:
+		drift = append(drift, SchemaDrift{Table: table.Table, Detail: fmt.Sprintf("schema hash %q differs from the locked %q", schemaHash, table.SchemaHash)})
+	}
+	return drift, nil
+}
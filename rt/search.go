@@ -0,0 +1,95 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SearchIndexConsumer is the OutboxDrainer consumer generated
+// CRUD.SyncSearchIndex checkpoints.
+const SearchIndexConsumer = "search"
+
+// ErrNoSearchIndex is returned by generated SearchFullText methods when
+// Options.SearchIndex is nil.
+var ErrNoSearchIndex = errors.New("no search index configured")
+
+// SearchIndex is a full-text index over the (proprdb.search) fields of
+// generated tables, such as a Bleve index stored next to the SQLite file.
+// Adapters keep the documents of each table apart, e.g. with one index per
+// table or a table field in each document. This module does not depend on
+// any search engine.
+type SearchIndex interface {
+	// Index stores or replaces the document of row id, mapping field names
+	// to text. Repeated fields are joined with newlines.
+	Index(ctx context.Context, tableName, id string, fields map[string]string) error
+	// Delete removes the document of row id, if any.
+	Delete(ctx context.Context, tableName, id string) error
+	// Search returns up to limit ids of tableName matching query, best
+	// first. The query syntax is that of the index.
+	Search(ctx context.Context, tableName, query string, limit int) ([]SearchHit, error)
+}
+
+// SearchHit is one result of SearchIndex.Search.
+type SearchHit struct {
+	ID    string
+	Score float64
+}
+
+// SearchTable describes the searchable documents of one generated table.
+type SearchTable struct {
+	TableName string
+	// Document returns the searchable fields of row id, or false when the
+	// row is gone or soft-deleted.
+	Document func(ctx context.Context, id string) (map[string]string, bool, error)
+}
+
+// SearchPublisher is an OutboxPublisher mirroring the change feed of Tables
+// into Index. Run it under an OutboxDrainer to keep the index consistent
+// with the database; changes of other tables are skipped.
+type SearchPublisher struct {
+	Index  SearchIndex
+	Tables []SearchTable
+}
+
+// Publish indexes the current document of each changed row, or deletes it
+// from the index once the row is gone.
+func (p SearchPublisher) Publish(ctx context.Context, changes []Change) error {
+	if p.Index == nil {
+		return ErrNoSearchIndex
+	}
+	for _, change := range changes {
+		var table *SearchTable
+		for index := range p.Tables {
+			if p.Tables[index].TableName == change.TableName {
+				table = &p.Tables[index]
+				break
+			}
+		}
+		if table == nil {
+			continue
+		}
+		fields, found, err := table.Document(ctx, change.ID)
+		if err != nil {
+			return fmt.Errorf("load %s/%s: %w", change.TableName, change.ID, err)
+		}
+		if !found {
+			err = p.Index.Delete(ctx, change.TableName, change.ID)
+		} else {
+			err = p.Index.Index(ctx, change.TableName, change.ID, fields)
+		}
+		if err != nil {
+			return fmt.Errorf("index %s/%s: %w", change.TableName, change.ID, err)
+		}
+	}
+	return nil
+}
+
+// SearchIDs returns the ids of hits in order.
+func SearchIDs(hits []SearchHit) []string {
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
@@ -0,0 +1,84 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CoreTableRemoteSegmentsName holds the key of the last segment applied from
+// each remote whose transport is a SegmentTransport.
+const CoreTableRemoteSegmentsName = "_remote_segments"
+
+const remoteSegmentsTableSQL = `CREATE TABLE IF NOT EXISTS ` + CoreTableRemoteSegmentsName + ` (remote TEXT PRIMARY KEY, segment TEXT NOT NULL)`
+
+// SegmentTransport is a RemoteTransport whose segments have keys sorting in
+// push order, such as the directory and S3 transports of proprdb-syncd.
+// PullSegments pulls after the key of the last applied segment, so a
+// restarted replica does not apply every segment of the remote again.
+type SegmentTransport interface {
+	RemoteTransport
+	// PullAfter is Pull for the segments after the key after, which also
+	// passes the key of each segment to visit.
+	PullAfter(ctx context.Context, after string, visit func(key string, r io.Reader) error) error
+}
+
+// PullSegments pulls the segments of transport for remote and calls apply
+// for each. For a SegmentTransport it pulls after RemoteSegment(q, remote)
+// and passes apply the key of the segment, which apply records with
+// SetRemoteSegment in the transaction applying the segment. Other
+// transports pass an empty key.
+func PullSegments(ctx context.Context, q DBTX, remote string, transport RemoteTransport, apply func(key string, r io.Reader) error) error {
+	segments, ok := transport.(SegmentTransport)
+	if !ok {
+		return transport.Pull(ctx, func(r io.Reader) error {
+			return apply("", r)
+		})
+	}
+	after, err := RemoteSegment(q, remote)
+	if err != nil {
+		return err
+	}
+	return segments.PullAfter(ctx, after, apply)
+}
+
+// RemoteSegment returns the key of the last segment applied from remote, or
+// "" when there is none.
+func RemoteSegment(q DBTX, remote string) (string, error) {
+	if q == nil {
+		return "", errors.New("nil DBTX")
+	}
+	exists, err := tableExists(q, CoreTableRemoteSegmentsName)
+	if err != nil || !exists {
+		return "", err
+	}
+	var segment string
+	err = q.QueryRowContext(context.Background(), `SELECT segment FROM `+CoreTableRemoteSegmentsName+` WHERE remote = ?`, remote).Scan(&segment)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("read last segment of %s: %w", remote, err)
+	}
+	return segment, nil
+}
+
+// SetRemoteSegment records key as applied from remote. The recorded key only
+// moves forward, as transports may apply segments that appear late after
+// later ones.
+func SetRemoteSegment(q DBTX, remote, key string) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	ctx := context.Background()
+	if _, err := q.ExecContext(ctx, remoteSegmentsTableSQL); err != nil {
+		return fmt.Errorf("create %s: %w", CoreTableRemoteSegmentsName, err)
+	}
+	_, err := q.ExecContext(ctx, `INSERT INTO `+CoreTableRemoteSegmentsName+` (remote, segment) VALUES (?, ?) ON CONFLICT(remote) DO UPDATE SET segment = max(segment, excluded.segment)`, remote, key)
+	if err != nil {
+		return fmt.Errorf("record last segment of %s: %w", remote, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package proprdbrt
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OrderBy sorts selected rows by Column.
+type OrderBy struct {
+	Column     string
+	Descending bool
+}
+
+// SelectOptions sorts and pages the rows of generated SelectWithOptions.
+type SelectOptions struct {
+	// OrderBy columns must be listed in the SortColumns of the table.
+	OrderBy []OrderBy
+	// Limit caps the number of rows when positive.
+	Limit int
+	// Offset skips rows after sorting.
+	Offset int
+}
+
+// Clause returns the ORDER BY, LIMIT and OFFSET clauses of o, with a
+// leading space, after checking OrderBy against sortColumns.
+func (o SelectOptions) Clause(sortColumns []string) (string, error) {
+	if o.Limit < 0 {
+		return "", errors.New("negative limit")
+	}
+	if o.Offset < 0 {
+		return "", errors.New("negative offset")
+	}
+	builder := strings.Builder{}
+	for index, orderBy := range o.OrderBy {
+		if !slices.Contains(sortColumns, orderBy.Column) {
+			return "", fmt.Errorf("cannot order by %q", orderBy.Column)
+		}
+		if index == 0 {
+			builder.WriteString(" ORDER BY ")
+		} else {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(`"` + orderBy.Column + `"`)
+		if orderBy.Descending {
+			builder.WriteString(" DESC")
+		}
+	}
+	switch {
+	case o.Limit > 0:
+		builder.WriteString(" LIMIT " + strconv.Itoa(o.Limit))
+	case o.Offset > 0:
+		// SQLite only accepts OFFSET after LIMIT.
+		builder.WriteString(" LIMIT -1")
+	}
+	if o.Offset > 0 {
+		builder.WriteString(" OFFSET " + strconv.Itoa(o.Offset))
+	}
+	return builder.String(), nil
+}
@@ -0,0 +1,187 @@
+package proprdbrt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// RemoteTransport moves JSONL segments between a database and one remote.
+type RemoteTransport interface {
+	// Push stores one JSONL segment on the remote.
+	Push(ctx context.Context, segment []byte) error
+	// Pull calls visit for every segment not seen before. A segment only
+	// counts as seen when visit succeeds.
+	Pull(ctx context.Context, visit func(io.Reader) error) error
+}
+
+// SyncSummary counts the records a SyncSession moved.
+type SyncSummary struct {
+	// Sent counts records pushed to the remote.
+	Sent int64
+	// Received counts pulled records of known synced types.
+	Received int64
+	// Conflicts counts received records older than the local state, which
+	// were resolved by the conflict strategy of their type.
+	Conflicts int64
+	// Unknown counts received records kept in _unknown_types.
+	Unknown int64
+	// Quarantined counts pulled segments skipped for ErrJSONLSignature.
+	Quarantined int64
+}
+
+// SyncSession syncs a database with one remote: it pushes the local changes
+// for Remote to Transport, then pulls and applies the segments of the
+// remote. Pushing first means a session never sends back what it just
+// received.
+type SyncSession struct {
+	// DB is the database; sessions need a *sql.DB or *sql.Conn to run the
+	// push and each pulled segment in their own transaction.
+	DB DBTX
+	// NewBundle creates the generated CRUD wrapper, e.g. NewCRUDWithOptions.
+	NewBundle BundleFactory
+	// Options are passed to NewBundle, with Instrumentation wrapped to count
+	// the summary.
+	Options   Options
+	Remote    string
+	Transport RemoteTransport
+}
+
+// Run performs one push and pull. The push is only committed once the
+// transport accepted it, and each pulled segment is applied in its own
+// transaction. Segments failing with ErrJSONLSignature cannot succeed on
+// retry; they are skipped and left on the remote. Bundles with
+// WriteJSONLContext and ReadJSONLContext, such as generated CRUD wrappers,
+// stop once ctx is done. The summary counts what was committed before an
+// error.
+func (s SyncSession) Run(ctx context.Context) (SyncSummary, error) {
+	var summary SyncSummary
+	switch {
+	case s.DB == nil:
+		return summary, errors.New("nil DBTX")
+	case s.NewBundle == nil:
+		return summary, errors.New("nil bundle factory")
+	case s.Transport == nil:
+		return summary, errors.New("nil transport")
+	case s.Remote == "":
+		return summary, errors.New("empty remote name")
+	}
+	if _, ok := s.DB.(txBeginner); !ok {
+		return summary, errors.New("sync session needs a DBTX that begins transactions")
+	}
+
+	var pushed SyncSummary
+	err := s.inTx(ctx, &pushed, func(_ DBTX, bundle Bundle) error {
+		var segment bytes.Buffer
+		write := bundle.WriteJSONL
+		if contextual, ok := bundle.(contextBundle); ok {
+			write = func(remote string, w io.Writer) error { return contextual.WriteJSONLContext(ctx, remote, w) }
+		}
+		if err := write(s.Remote, &segment); err != nil {
+			return err
+		}
+		if segment.Len() == 0 {
+			return nil
+		}
+		return s.Transport.Push(ctx, segment.Bytes())
+	})
+	if err != nil {
+		return summary, fmt.Errorf("push to %s: %w", s.Remote, err)
+	}
+	summary.add(pushed)
+
+	err = PullSegments(ctx, s.DB, s.Remote, s.Transport, func(key string, r io.Reader) error {
+		var pulled SyncSummary
+		err := s.inTx(ctx, &pulled, func(tx DBTX, bundle Bundle) error {
+			read := bundle.ReadJSONL
+			if contextual, ok := bundle.(contextBundle); ok {
+				read = func(remote string, r io.Reader) error { return contextual.ReadJSONLContext(ctx, remote, r) }
+			}
+			if err := read(s.Remote, r); err != nil || key == "" {
+				return err
+			}
+			return SetRemoteSegment(tx, s.Remote, key)
+		})
+		if errors.Is(err, ErrJSONLSignature) {
+			summary.Quarantined++
+			if key == "" {
+				return nil
+			}
+			return SetRemoteSegment(s.DB, s.Remote, key)
+		}
+		if err != nil {
+			return err
+		}
+		summary.add(pulled)
+		return nil
+	})
+	if err != nil {
+		return summary, fmt.Errorf("pull from %s: %w", s.Remote, err)
+	}
+	return summary, nil
+}
+
+// contextBundle is implemented by generated CRUD wrappers, whose sync stops
+// once ctx is done.
+type contextBundle interface {
+	WriteJSONLContext(ctx context.Context, remote string, w io.Writer) error
+	ReadJSONLContext(ctx context.Context, remote string, r io.Reader) error
+}
+
+// inTx runs apply in a transaction with a bundle counting into counts.
+func (s SyncSession) inTx(ctx context.Context, counts *SyncSummary, apply func(tx DBTX, bundle Bundle) error) error {
+	return WithTxRetry(ctx, s.DB, RetryPolicy{Attempts: 1}, func(tx DBTX) error {
+		*counts = SyncSummary{}
+		opts := s.Options
+		opts.Instrumentation = &syncCounter{next: s.Options.Instrumentation, counts: counts}
+		return apply(tx, s.NewBundle(tx, opts))
+	})
+}
+
+func (s *SyncSummary) add(other SyncSummary) {
+	s.Sent += other.Sent
+	s.Received += other.Received
+	s.Conflicts += other.Conflicts
+	s.Unknown += other.Unknown
+	s.Quarantined += other.Quarantined
+}
+
+// syncCounter counts the sync metrics into a SyncSummary and forwards
+// everything to next, when set.
+type syncCounter struct {
+	next   Instrumentation
+	counts *SyncSummary
+}
+
+func (c *syncCounter) StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, func(err error)) {
+	if c.next == nil {
+		return ctx, func(error) {}
+	}
+	return c.next.StartSpan(ctx, name, attrs...)
+}
+
+func (c *syncCounter) AddInt64(ctx context.Context, name string, delta int64, attrs ...Attribute) {
+	switch name {
+	case MetricSyncLinesWritten:
+		atomic.AddInt64(&c.counts.Sent, delta)
+	case MetricSyncLinesRead:
+		atomic.AddInt64(&c.counts.Received, delta)
+	case MetricSyncConflicts:
+		atomic.AddInt64(&c.counts.Conflicts, delta)
+	case MetricSyncUnknown:
+		atomic.AddInt64(&c.counts.Unknown, delta)
+	}
+	if c.next != nil {
+		c.next.AddInt64(ctx, name, delta, attrs...)
+	}
+}
+
+func (c *syncCounter) RecordDuration(ctx context.Context, name string, duration time.Duration, attrs ...Attribute) {
+	if c.next != nil {
+		c.next.RecordDuration(ctx, name, duration, attrs...)
+	}
+}
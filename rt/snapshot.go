@@ -0,0 +1,345 @@
+package proprdbrt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+)
+
+// SnapshotFormatVersion is written into every snapshot header.
+const SnapshotFormatVersion = 1
+
+// SnapshotHeader is the first line of a snapshot. It lists every generated
+// table with its schema hash and the number of records that follow.
+type SnapshotHeader struct {
+	Version        int             `json:"version"`
+	CreatedAtNs    int64           `json:"createdAtNs"`
+	Tables         []SnapshotTable `json:"tables"`
+	UnknownRecords int64           `json:"unknownRecords"`
+	// CoreTables lists the AdminCoreTables of an admin snapshot, whose
+	// CoreRows rows follow the records.
+	CoreTables []string `json:"coreTables,omitempty"`
+	CoreRows   int64    `json:"coreRows,omitempty"`
+	// TypeURLPrefix is the prefix of the @type of the records, empty for
+	// DefaultTypeURLPrefix.
+	TypeURLPrefix string `json:"typeUrlPrefix,omitempty"`
+}
+
+// SnapshotTable describes the records of one table in a snapshot.
+type SnapshotTable struct {
+	TableName  string `json:"table"`
+	TypeName   string `json:"type"`
+	SchemaHash string `json:"schemaHash"`
+	Rows       int64  `json:"rows"`
+	Tombstones int64  `json:"tombstones"`
+}
+
+// Tombstone is a row of the _deleted table.
+type Tombstone struct {
+	ID   string
+	AtNs int64
+}
+
+type snapshotTrailer struct {
+	Records int64 `json:"records"`
+}
+
+type snapshotHeaderFrame struct {
+	Header *SnapshotHeader `json:"proprdbSnapshot"`
+}
+
+type snapshotTrailerFrame struct {
+	Trailer *snapshotTrailer `json:"proprdbSnapshotEnd"`
+}
+
+type snapshotRecordFrame struct {
+	JSONLRecord
+	Core    *CoreRow         `json:"proprdbCore,omitempty"`
+	Trailer *snapshotTrailer `json:"proprdbSnapshotEnd,omitempty"`
+}
+
+type snapshotCoreFrame struct {
+	Core CoreRow `json:"proprdbCore"`
+}
+
+func (h SnapshotHeader) totalRecords() int64 {
+	total := h.UnknownRecords
+	for _, table := range h.Tables {
+		total += table.Rows + table.Tombstones
+	}
+	return total
+}
+
+// SnapshotWriter writes the framed snapshot format: a header line, one JSONL
+// record per line and a trailer line with the record count.
+type SnapshotWriter struct {
+	encoder  *json.Encoder
+	header   SnapshotHeader
+	records  int64
+	coreRows int64
+}
+
+// NewSnapshotWriter writes header to w and returns a writer for the records.
+func NewSnapshotWriter(w io.Writer, header SnapshotHeader) (*SnapshotWriter, error) {
+	if w == nil {
+		return nil, errors.New("nil writer")
+	}
+	header.Version = SnapshotFormatVersion
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(snapshotHeaderFrame{Header: &header}); err != nil {
+		return nil, fmt.Errorf("write snapshot header: %w", err)
+	}
+	return &SnapshotWriter{encoder: encoder, header: header}, nil
+}
+
+// WriteRecord writes one record.
+func (s *SnapshotWriter) WriteRecord(record JSONLRecord) error {
+	if err := s.encoder.Encode(record); err != nil {
+		return fmt.Errorf("write snapshot record %s: %w", record.ID, err)
+	}
+	s.records++
+	return nil
+}
+
+// WriteTombstones writes deletion records of typeName.
+func (s *SnapshotWriter) WriteTombstones(typeName string, tombstones []Tombstone) error {
+	if len(tombstones) == 0 {
+		return nil
+	}
+	dataJSON, err := MarshalTypeOnlyAnyJSONWithPrefix(s.header.TypeURLPrefix, typeName)
+	if err != nil {
+		return fmt.Errorf("marshal tombstone type %s: %w", typeName, err)
+	}
+	for _, tombstone := range tombstones {
+		if err := s.WriteRecord(JSONLRecord{ID: tombstone.ID, Deleted: true, AtNs: tombstone.AtNs, Data: dataJSON}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCoreRow writes a row of one of the header CoreTables.
+func (s *SnapshotWriter) WriteCoreRow(row CoreRow) error {
+	if !slices.Contains(s.header.CoreTables, row.Table) {
+		return fmt.Errorf("snapshot header does not announce core table %s", row.Table)
+	}
+	if err := s.encoder.Encode(snapshotCoreFrame{Core: row}); err != nil {
+		return fmt.Errorf("write snapshot %s row: %w", row.Table, err)
+	}
+	s.coreRows++
+	return nil
+}
+
+// Close writes the trailer. It fails if the number of written records or
+// core rows does not match the header.
+func (s *SnapshotWriter) Close() error {
+	if expected := s.header.totalRecords(); s.records != expected {
+		return fmt.Errorf("snapshot wrote %d records, header announced %d", s.records, expected)
+	}
+	if s.coreRows != s.header.CoreRows {
+		return fmt.Errorf("snapshot wrote %d core rows, header announced %d", s.coreRows, s.header.CoreRows)
+	}
+	if err := s.encoder.Encode(snapshotTrailerFrame{Trailer: &snapshotTrailer{Records: s.records}}); err != nil {
+		return fmt.Errorf("write snapshot trailer: %w", err)
+	}
+	return nil
+}
+
+// ReadSnapshot reads a snapshot written by SnapshotWriter and calls visit for
+// every record. schemaHashes maps type names to the local projection schema;
+// differences are logged, since projections are recomputed on restore. The
+// record counts of the header and trailer are verified, so a truncated
+// snapshot returns an error (after its records have been visited). The core
+// rows of admin snapshots are skipped.
+func ReadSnapshot(r io.Reader, schemaHashes map[string]string, visit func(JSONLRecord, int) error) (SnapshotHeader, error) {
+	return ReadSnapshotWithCore(r, schemaHashes, visit, nil)
+}
+
+// ReadSnapshotWithCore is ReadSnapshot also restoring the core rows of an
+// admin snapshot into core when it is not nil: the CoreTables of the header
+// are cleared once it has been read, and refilled row by row.
+func ReadSnapshotWithCore(r io.Reader, schemaHashes map[string]string, visit func(JSONLRecord, int) error, core DBTX) (SnapshotHeader, error) {
+	if r == nil {
+		return SnapshotHeader{}, errors.New("nil reader")
+	}
+	decoder := json.NewDecoder(r)
+	headerFrame := snapshotHeaderFrame{}
+	if err := decoder.Decode(&headerFrame); err != nil {
+		return SnapshotHeader{}, fmt.Errorf("decode snapshot header: %w", err)
+	}
+	if headerFrame.Header == nil {
+		return SnapshotHeader{}, errors.New("missing snapshot header")
+	}
+	header := *headerFrame.Header
+	if header.Version != SnapshotFormatVersion {
+		return header, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	expectedRows := make(map[string]int64, len(header.Tables))
+	expectedTombstones := make(map[string]int64, len(header.Tables))
+	for _, table := range header.Tables {
+		expectedRows[table.TypeName] = table.Rows
+		expectedTombstones[table.TypeName] = table.Tombstones
+		if localHash, ok := schemaHashes[table.TypeName]; ok && localHash != table.SchemaHash {
+			slog.Warn("snapshot schema differs from local schema", "type", table.TypeName, "snapshot", table.SchemaHash, "local", localHash)
+		}
+	}
+
+	if core != nil {
+		if err := clearCoreTables(core, header.CoreTables); err != nil {
+			return header, err
+		}
+	}
+
+	lineNumber := 1
+	var records, unknownRecords, coreRows int64
+	rows := make(map[string]int64, len(header.Tables))
+	tombstones := make(map[string]int64, len(header.Tables))
+	for {
+		lineNumber++
+		frame := snapshotRecordFrame{}
+		if err := decoder.Decode(&frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				return header, errors.New("snapshot is truncated: missing trailer")
+			}
+			return header, fmt.Errorf("decode snapshot line %d: %w", lineNumber, err)
+		}
+		if frame.Trailer != nil {
+			if frame.Trailer.Records != records {
+				return header, fmt.Errorf("snapshot trailer announces %d records, read %d", frame.Trailer.Records, records)
+			}
+			break
+		}
+		if frame.Core != nil {
+			if !slices.Contains(header.CoreTables, frame.Core.Table) {
+				return header, fmt.Errorf("snapshot line %d has a row of unannounced core table %s", lineNumber, frame.Core.Table)
+			}
+			coreRows++
+			if core != nil {
+				if err := restoreCoreRow(core, *frame.Core); err != nil {
+					return header, fmt.Errorf("snapshot line %d: %w", lineNumber, err)
+				}
+			}
+			continue
+		}
+		typeName, err := TypeNameFromAnyJSON(frame.Data)
+		if err != nil {
+			return header, fmt.Errorf("read @type on snapshot line %d: %w", lineNumber, err)
+		}
+		switch _, known := expectedRows[typeName]; {
+		case !known:
+			unknownRecords++
+		case frame.Deleted:
+			tombstones[typeName]++
+		default:
+			rows[typeName]++
+		}
+		records++
+		if err := visit(frame.JSONLRecord, lineNumber); err != nil {
+			return header, err
+		}
+	}
+	if err := decoder.Decode(&json.RawMessage{}); !errors.Is(err, io.EOF) {
+		return header, errors.New("unexpected data after snapshot trailer")
+	}
+	for typeName, expected := range expectedRows {
+		if rows[typeName] != expected || tombstones[typeName] != expectedTombstones[typeName] {
+			return header, fmt.Errorf("snapshot has %d rows and %d tombstones for %s, header announced %d and %d", rows[typeName], tombstones[typeName], typeName, expected, expectedTombstones[typeName])
+		}
+	}
+	if unknownRecords != header.UnknownRecords {
+		return header, fmt.Errorf("snapshot has %d unknown records, header announced %d", unknownRecords, header.UnknownRecords)
+	}
+	if coreRows != header.CoreRows {
+		return header, fmt.Errorf("snapshot has %d core rows, header announced %d", coreRows, header.CoreRows)
+	}
+	return header, nil
+}
+
+// ListTombstones returns the tombstones of tableName ordered by id.
+func ListTombstones(q DBTX, tableName string) ([]Tombstone, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT id, at_ns FROM `+CoreTableDeletedName+` WHERE table_name = ? ORDER BY id`, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("select tombstones for %s: %w", tableName, err)
+	}
+	tombstones := make([]Tombstone, 0)
+	for rows.Next() {
+		tombstone := Tombstone{}
+		if err := rows.Scan(&tombstone.ID, &tombstone.AtNs); err != nil {
+			if closeErr := CloseRows(rows, "tombstones"); closeErr != nil {
+				return nil, fmt.Errorf("scan tombstone for %s: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("scan tombstone for %s: %w", tableName, err)
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "tombstones"); closeErr != nil {
+			return nil, fmt.Errorf("iterate tombstones for %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate tombstones for %s: %w", tableName, err)
+	}
+	if err := CloseRows(rows, "tombstones"); err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
+
+// ListUnknownRecords returns all rows of _unknown_types as JSONL records.
+func ListUnknownRecords(q DBTX) ([]JSONLRecord, error) {
+	if q == nil {
+		return nil, errors.New("nil DBTX")
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT id, at_ns, deleted, data_json FROM `+CoreTableUnknownName+` ORDER BY type_name, id, at_ns`)
+	if err != nil {
+		return nil, fmt.Errorf("select unknown rows: %w", err)
+	}
+	records := make([]JSONLRecord, 0)
+	for rows.Next() {
+		var record JSONLRecord
+		var deletedInt int
+		var dataJSON string
+		if err := rows.Scan(&record.ID, &record.AtNs, &deletedInt, &dataJSON); err != nil {
+			if closeErr := CloseRows(rows, "unknown rows"); closeErr != nil {
+				return nil, fmt.Errorf("scan unknown row: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan unknown row: %w", err)
+		}
+		record.Deleted = deletedInt != 0
+		record.Data = json.RawMessage(dataJSON)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "unknown rows"); closeErr != nil {
+			return nil, fmt.Errorf("iterate unknown rows: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate unknown rows: %w", err)
+	}
+	if err := CloseRows(rows, "unknown rows"); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// RestoreUnknown stores an unknown-type record unless an identical version
+// is already present.
+func RestoreUnknown(q DBTX, typeName string, record JSONLRecord) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	deletedInt := 0
+	if record.Deleted {
+		deletedInt = 1
+	}
+	restoreSQL := `INSERT OR IGNORE INTO ` + CoreTableUnknownName + ` (type_name, id, at_ns, deleted, data_json) VALUES (?, ?, ?, ?, ?)`
+	if _, err := q.ExecContext(context.Background(), restoreSQL, typeName, record.ID, record.AtNs, deletedInt, string(record.Data)); err != nil {
+		return fmt.Errorf("restore unknown row for %s/%s/%d: %w", typeName, record.ID, record.AtNs, err)
+	}
+	return nil
+}
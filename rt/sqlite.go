@@ -0,0 +1,89 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SynchronousMode is a value of PRAGMA synchronous.
+type SynchronousMode string
+
+const (
+	SynchronousOff    SynchronousMode = "OFF"
+	SynchronousNormal SynchronousMode = "NORMAL"
+	SynchronousFull   SynchronousMode = "FULL"
+	SynchronousExtra  SynchronousMode = "EXTRA"
+)
+
+// SQLiteOptions selects the PRAGMAs ConfigureSQLite applies. Zero fields
+// leave the SQLite defaults alone.
+type SQLiteOptions struct {
+	// WAL switches the database to write-ahead logging, so readers do not
+	// block writers. The mode is stored in the database file.
+	WAL bool
+	// BusyTimeout makes statements wait this long for locks held by other
+	// connections before failing with SQLITE_BUSY.
+	BusyTimeout time.Duration
+	// SynchronousMode sets how often SQLite syncs to disk.
+	SynchronousMode SynchronousMode
+	// ForeignKeys enables enforcement of foreign key constraints.
+	ForeignKeys bool
+}
+
+// ConcurrentSQLite is a profile for databases shared by concurrent readers
+// and writers: WAL, a five second busy timeout, NORMAL synchronous (durable
+// across application crashes in WAL mode) and foreign keys.
+var ConcurrentSQLite = SQLiteOptions{
+	WAL:             true,
+	BusyTimeout:     5 * time.Second,
+	SynchronousMode: SynchronousNormal,
+	ForeignKeys:     true,
+}
+
+// Pragmas returns the PRAGMA statements of o in the order ConfigureSQLite
+// runs them. Except journal_mode, PRAGMAs apply to one connection only, so
+// with a connection pool run them from a driver connect hook too.
+func (o SQLiteOptions) Pragmas() ([]string, error) {
+	pragmas := make([]string, 0, 4)
+	if o.WAL {
+		pragmas = append(pragmas, `PRAGMA journal_mode = WAL`)
+	}
+	if o.BusyTimeout < 0 {
+		return nil, fmt.Errorf("negative busy timeout %s", o.BusyTimeout)
+	}
+	if o.BusyTimeout > 0 {
+		pragmas = append(pragmas, `PRAGMA busy_timeout = `+strconv.FormatInt(o.BusyTimeout.Milliseconds(), 10))
+	}
+	switch o.SynchronousMode {
+	case "":
+	case SynchronousOff, SynchronousNormal, SynchronousFull, SynchronousExtra:
+		pragmas = append(pragmas, `PRAGMA synchronous = `+string(o.SynchronousMode))
+	default:
+		return nil, fmt.Errorf("unknown synchronous mode %q", o.SynchronousMode)
+	}
+	if o.ForeignKeys {
+		pragmas = append(pragmas, `PRAGMA foreign_keys = ON`)
+	}
+	return pragmas, nil
+}
+
+// ConfigureSQLite applies the PRAGMAs of opts to q. q must not be a
+// transaction, as SQLite cannot change the journal mode inside one.
+func ConfigureSQLite(q DBTX, opts SQLiteOptions) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	pragmas, err := opts.Pragmas()
+	if err != nil {
+		return err
+	}
+	for _, pragma := range pragmas {
+		if _, err := q.ExecContext(context.Background(), pragma); err != nil {
+			return fmt.Errorf("%s: %w", pragma, err)
+		}
+	}
+	return nil
+}
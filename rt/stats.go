@@ -0,0 +1,289 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// InitStateTableName holds the time of the last generated CRUD.Init.
+const InitStateTableName = "_proprdb_init"
+
+// RecordInit stores at as the time of the last Init.
+func RecordInit(q DBTX, at time.Time) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	ctx := context.Background()
+	if _, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+InitStateTableName+` (id INTEGER PRIMARY KEY CHECK (id = 1), at_ns INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("create %s: %w", InitStateTableName, err)
+	}
+	if _, err := q.ExecContext(ctx, `INSERT INTO `+InitStateTableName+` (id, at_ns) VALUES (1, ?) ON CONFLICT(id) DO UPDATE SET at_ns = excluded.at_ns`, at.UnixNano()); err != nil {
+		return fmt.Errorf("record init: %w", err)
+	}
+	return nil
+}
+
+// LastInit returns the time RecordInit stored, or the zero time when
+// nothing was recorded.
+func LastInit(q DBTX) (time.Time, error) {
+	if q == nil {
+		return time.Time{}, errors.New("nil DBTX")
+	}
+	exists, err := tableExists(q, InitStateTableName)
+	if err != nil || !exists {
+		return time.Time{}, err
+	}
+	var atNs int64
+	err = q.QueryRowContext(context.Background(), `SELECT at_ns FROM `+InitStateTableName+` WHERE id = 1`).Scan(&atNs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("select last init: %w", err)
+	}
+	return time.Unix(0, atNs), nil
+}
+
+// Stats summarizes the state of a database, as returned by generated
+// CRUD.Stats.
+type Stats struct {
+	Tables []TableStats `json:"tables"`
+	// UnknownRecords counts the rows of _unknown_types.
+	UnknownRecords int64 `json:"unknownRecords"`
+	// RejectedRecords counts the rows of _rejected.
+	RejectedRecords int64         `json:"rejectedRecords"`
+	Remotes         []RemoteStats `json:"remotes"`
+	// SchemaCurrent is false when Init would change the database.
+	SchemaCurrent bool      `json:"schemaCurrent"`
+	LastInit      time.Time `json:"lastInit,omitzero"`
+}
+
+// TableStats describes one table of Stats.
+type TableStats struct {
+	TableName      string `json:"table"`
+	TypeName       string `json:"type,omitempty"`
+	IsCore         bool   `json:"core"`
+	ObjectCount    int64  `json:"objects"`
+	DiskUsageBytes int64  `json:"diskUsageBytes"`
+	// Tombstones counts the rows of _deleted for the table.
+	Tombstones int64 `json:"tombstones"`
+	// SchemaCurrent is false when Init would change the table, e.g. after
+	// an upgrade with Options.DeferReprojection.
+	SchemaCurrent bool `json:"schemaCurrent"`
+}
+
+// RemoteStats is the sync lag of one remote of Stats.
+type RemoteStats struct {
+	Remote string `json:"remote"`
+	// LagNs is the largest lag of Tables.
+	LagNs  int64      `json:"lagNs"`
+	Tables []TableLag `json:"tables"`
+}
+
+// CollectStats gathers the Stats of descriptors. plan is what Init would
+// change, as returned by generated CRUD.PlanInit.
+func CollectStats(q DBTX, descriptors []GeneratedTableDescriptor, plan InitPlan) (Stats, error) {
+	if q == nil {
+		return Stats{}, errors.New("nil DBTX")
+	}
+	introspection, err := IntrospectTables(q, descriptors)
+	if err != nil {
+		return Stats{}, err
+	}
+	tombstones, err := countByTable(q, CoreTableDeletedName)
+	if err != nil {
+		return Stats{}, err
+	}
+	stale := make(map[string]bool, len(plan.Tables)+len(plan.CreateCoreTables))
+	for _, tableName := range plan.CreateCoreTables {
+		stale[tableName] = true
+	}
+	for _, tablePlan := range plan.Tables {
+		stale[tablePlan.TableName] = !tablePlan.Empty()
+	}
+	stats := Stats{Tables: []TableStats{}, Remotes: []RemoteStats{}, SchemaCurrent: plan.Empty()}
+	syncTables := make([]string, 0, len(descriptors))
+	for _, table := range introspection {
+		descriptor := table.Descriptor
+		stats.Tables = append(stats.Tables, TableStats{
+			TableName:      descriptor.TableName,
+			TypeName:       descriptor.TypeName,
+			IsCore:         descriptor.IsCore,
+			ObjectCount:    table.ObjectCount,
+			DiskUsageBytes: table.DiskUsageBytes,
+			Tombstones:     tombstones[descriptor.TableName],
+			SchemaCurrent:  !stale[descriptor.TableName],
+		})
+		if !descriptor.IsCore && descriptor.SyncEnabled {
+			syncTables = append(syncTables, descriptor.TableName)
+		}
+	}
+	if stats.UnknownRecords, err = countRows(q, CoreTableUnknownName); err != nil {
+		return Stats{}, err
+	}
+	if stats.RejectedRecords, err = countRows(q, CoreTableRejectedName); err != nil {
+		return Stats{}, err
+	}
+	remotes, err := ListRemotes(q)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, remote := range remotes {
+		remoteStats := RemoteStats{Remote: remote, Tables: []TableLag{}}
+		if len(syncTables) > 0 {
+			if remoteStats.Tables, err = SyncLag(q, remote, syncTables); err != nil {
+				return Stats{}, err
+			}
+		}
+		for _, lag := range remoteStats.Tables {
+			remoteStats.LagNs = max(remoteStats.LagNs, lag.LagNs)
+		}
+		stats.Remotes = append(stats.Remotes, remoteStats)
+	}
+	if stats.LastInit, err = LastInit(q); err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
+
+func countRows(q DBTX, tableName string) (int64, error) {
+	exists, err := tableExists(q, tableName)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var count int64
+	if err := q.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM `+quoteSQLiteIdentifier(tableName)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count rows of %s: %w", tableName, err)
+	}
+	return count, nil
+}
+
+// countByTable counts the rows of tableName per table_name column.
+func countByTable(q DBTX, tableName string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	exists, err := tableExists(q, tableName)
+	if err != nil || !exists {
+		return counts, err
+	}
+	rows, err := q.QueryContext(context.Background(), `SELECT table_name, COUNT(*) FROM `+quoteSQLiteIdentifier(tableName)+` GROUP BY table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("count rows of %s: %w", tableName, err)
+	}
+	for rows.Next() {
+		var name string
+		var count int64
+		if err := rows.Scan(&name, &count); err != nil {
+			if closeErr := CloseRows(rows, "count by table"); closeErr != nil {
+				return nil, fmt.Errorf("scan count of %s: %w (additionally, %v)", tableName, err, closeErr)
+			}
+			return nil, fmt.Errorf("scan count of %s: %w", tableName, err)
+		}
+		counts[name] = count
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "count by table"); closeErr != nil {
+			return nil, fmt.Errorf("count rows of %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return nil, fmt.Errorf("count rows of %s: %w", tableName, err)
+	}
+	if err := CloseRows(rows, "count by table"); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// NewStatsHandler serves the result of stats as JSON, or in the Prometheus
+// text format when the request accepts text/plain or OpenMetrics, as
+// Prometheus scrapes do, or asks for ?format=prometheus. Failing stats are
+// served as 503 Service Unavailable, so the handler doubles as a health
+// check.
+func NewStatsHandler(stats func() (Stats, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		current, err := stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		accept := r.Header.Get("Accept")
+		if r.URL.Query().Get("format") == "prometheus" || strings.Contains(accept, "text/plain") || strings.Contains(accept, "application/openmetrics-text") {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			_ = WritePrometheusStats(w, current)
+			return
+		}
+		WriteHTTPJSON(w, http.StatusOK, current)
+	})
+}
+
+// WritePrometheusStats writes stats as Prometheus text format metrics.
+func WritePrometheusStats(w io.Writer, stats Stats) error {
+	builder := strings.Builder{}
+	metric := func(name, help string, samples func(sample func(labels string, value float64))) {
+		fmt.Fprintf(&builder, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		samples(func(labels string, value float64) {
+			fmt.Fprintf(&builder, "%s%s %v\n", name, labels, value)
+		})
+	}
+	tableMetric := func(name, help string, value func(TableStats) float64) {
+		metric(name, help, func(sample func(string, float64)) {
+			for _, table := range stats.Tables {
+				sample(prometheusLabels("table", table.TableName), value(table))
+			}
+		})
+	}
+	tableMetric("proprdb_table_objects", "Rows of the table.", func(table TableStats) float64 { return float64(table.ObjectCount) })
+	tableMetric("proprdb_table_disk_usage_bytes", "Estimated bytes used by the table.", func(table TableStats) float64 { return float64(table.DiskUsageBytes) })
+	tableMetric("proprdb_table_tombstones", "Tombstones of the table in _deleted.", func(table TableStats) float64 { return float64(table.Tombstones) })
+	tableMetric("proprdb_table_schema_current", "1 when Init would not change the table.", func(table TableStats) float64 { return prometheusBool(table.SchemaCurrent) })
+	metric("proprdb_unknown_records", "Rows of _unknown_types.", func(sample func(string, float64)) {
+		sample("", float64(stats.UnknownRecords))
+	})
+	metric("proprdb_rejected_records", "Rows of _rejected.", func(sample func(string, float64)) {
+		sample("", float64(stats.RejectedRecords))
+	})
+	metric("proprdb_schema_current", "1 when Init would not change the database.", func(sample func(string, float64)) {
+		sample("", prometheusBool(stats.SchemaCurrent))
+	})
+	metric("proprdb_sync_lag_seconds", "How far the newest change acknowledged for a remote is behind the newest local one.", func(sample func(string, float64)) {
+		for _, remote := range stats.Remotes {
+			for _, lag := range remote.Tables {
+				sample(prometheusLabels("remote", remote.Remote, "table", lag.TableName), float64(lag.LagNs)/float64(time.Second))
+			}
+		}
+	})
+	if !stats.LastInit.IsZero() {
+		metric("proprdb_last_init_timestamp_seconds", "Unix time of the last Init.", func(sample func(string, float64)) {
+			sample("", float64(stats.LastInit.UnixNano())/float64(time.Second))
+		})
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func prometheusBool(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// prometheusLabels formats name/value pairs as a label set.
+func prometheusLabels(pairs ...string) string {
+	labels := make([]string, 0, len(pairs)/2)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for index := 0; index+1 < len(pairs); index += 2 {
+		labels = append(labels, pairs[index]+`="`+escaper.Replace(pairs[index+1])+`"`)
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
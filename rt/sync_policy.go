@@ -0,0 +1,125 @@
+package proprdbrt
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// SyncPolicy restricts what WriteJSONL exports to individual remotes.
+// Remotes without an entry receive every synced type.
+type SyncPolicy struct {
+	Remotes map[string]RemoteSyncFilter
+	// Switches pauses syncing of types at runtime. NewCRUDWithOptions sets
+	// it when nil; share one between CRUDs to pause them together.
+	Switches *SyncSwitches
+}
+
+// SyncSwitches enables and disables syncing per type name at runtime. Types
+// without an override follow the default, which starts enabled. It is safe
+// for concurrent use.
+type SyncSwitches struct {
+	mu                sync.RWMutex
+	disabledByDefault bool
+	overrides         map[string]bool
+}
+
+// Enable resumes syncing typeName.
+func (s *SyncSwitches) Enable(typeName string) {
+	s.set(typeName, true)
+}
+
+// Disable pauses syncing typeName.
+func (s *SyncSwitches) Disable(typeName string) {
+	s.set(typeName, false)
+}
+
+func (s *SyncSwitches) set(typeName string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.overrides == nil {
+		s.overrides = make(map[string]bool)
+	}
+	s.overrides[typeName] = enabled
+}
+
+// SetDefault sets whether types without an override sync.
+func (s *SyncSwitches) SetDefault(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disabledByDefault = !enabled
+}
+
+// Enabled reports whether typeName syncs. A nil SyncSwitches enables all
+// types.
+func (s *SyncSwitches) Enabled(typeName string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if enabled, ok := s.overrides[typeName]; ok {
+		return enabled
+	}
+	return !s.disabledByDefault
+}
+
+// RemoteSyncFilter selects the objects exported to one remote.
+type RemoteSyncFilter struct {
+	// Types limits the export to these type names. Empty means all types.
+	Types []string
+	// Where holds SQL conditions over projected columns by type name. They
+	// are combined with conditions from (proprdb.sync_filters).
+	Where map[string]string
+	// Predicates filter decoded objects by type name.
+	Predicates map[string]func(proto.Message) bool
+	// Tenants limits rows of types with a (proprdb.tenant_field) to these
+	// tenants. Empty means all tenants.
+	Tenants []string
+}
+
+// SyncIncludesType reports whether typeName is exported to remote at all;
+// paused types are not. Tombstones of included types are always exported,
+// as predicates cannot be evaluated for deleted objects.
+func SyncIncludesType(policy SyncPolicy, remote, typeName string) bool {
+	if !policy.Switches.Enabled(typeName) {
+		return false
+	}
+	filter, ok := policy.Remotes[remote]
+	if !ok || len(filter.Types) == 0 {
+		return true
+	}
+	return slices.Contains(filter.Types, typeName)
+}
+
+// SyncSelection returns the Select condition for exporting typeName to
+// remote. generatedWhere holds conditions from (proprdb.sync_filters) by
+// remote name. included is false when the type is filtered out entirely.
+func SyncSelection(policy SyncPolicy, remote, typeName string, generatedWhere map[string]string) (string, bool) {
+	if !SyncIncludesType(policy, remote, typeName) {
+		return "", false
+	}
+	conditions := make([]string, 0, 2)
+	if where := strings.TrimSpace(generatedWhere[remote]); where != "" {
+		conditions = append(conditions, where)
+	}
+	if where := strings.TrimSpace(policy.Remotes[remote].Where[typeName]); where != "" {
+		conditions = append(conditions, where)
+	}
+	switch len(conditions) {
+	case 0:
+		return "", true
+	case 1:
+		return conditions[0], true
+	default:
+		return "(" + strings.Join(conditions, ") AND (") + ")", true
+	}
+}
+
+// SyncAllows applies the runtime predicate of typeName for remote to data.
+func SyncAllows(policy SyncPolicy, remote, typeName string, data proto.Message) bool {
+	predicate := policy.Remotes[remote].Predicates[typeName]
+	return predicate == nil || predicate(data)
+}
@@ -0,0 +1,95 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrTenantRequired is returned by reads of tables with a
+	// (proprdb.tenant_field) not scoped with ForTenant, when
+	// Options.RequireTenant is set.
+	ErrTenantRequired = errors.New("tenant required")
+	// ErrTenantMismatch is returned by writes of a tenant scoped table
+	// touching rows of another tenant.
+	ErrTenantMismatch = errors.New("tenant mismatch")
+)
+
+// TenantScope is the tenant a generated table with a (proprdb.tenant_field)
+// is scoped to by ForTenant.
+type TenantScope struct {
+	Tenant string
+	// Bound is set for tables returned by ForTenant.
+	Bound bool
+	// All exempts generated sync and snapshot code from
+	// Options.RequireTenant.
+	All bool
+}
+
+// Where restricts where to the tenant of a bound scope. Unbound scopes fail
+// with ErrTenantRequired when require is set, unless All is set.
+func (s TenantScope) Where(column string, require bool, where string, args []any) (string, []any, error) {
+	if !s.Bound {
+		if err := s.Check(require); err != nil {
+			return "", nil, err
+		}
+		return where, args, nil
+	}
+	condition := `"` + column + `" = ?`
+	if strings.TrimSpace(where) != "" {
+		condition += " AND (" + where + ")"
+	}
+	return condition, append([]any{s.Tenant}, args...), nil
+}
+
+// Check fails with ErrTenantRequired for reads through an unbound scope when
+// require is set, unless All is set.
+func (s TenantScope) Check(require bool) error {
+	if require && !s.Bound && !s.All {
+		return ErrTenantRequired
+	}
+	return nil
+}
+
+// Allows reports whether a row of tenant is visible through the scope.
+func (s TenantScope) Allows(tenant string) bool {
+	return !s.Bound || tenant == s.Tenant
+}
+
+// CheckData verifies that data written through a bound scope belongs to its
+// tenant.
+func (s TenantScope) CheckData(tenant string) error {
+	if s.Bound && tenant != s.Tenant {
+		return fmt.Errorf("write tenant %q through table of tenant %q: %w", tenant, s.Tenant, ErrTenantMismatch)
+	}
+	return nil
+}
+
+// CheckOwner verifies that no row id of another tenant exists, before a
+// bound scope replaces or deletes it.
+func (s TenantScope) CheckOwner(q DBTX, tableName, column, id string) error {
+	if !s.Bound {
+		return nil
+	}
+	var count int
+	query := `SELECT COUNT(*) FROM "` + tableName + `" WHERE id = ? AND "` + column + `" <> ?`
+	if err := q.QueryRowContext(context.Background(), query, id, s.Tenant).Scan(&count); err != nil {
+		return fmt.Errorf("check tenant of %s/%s: %w", tableName, id, err)
+	}
+	if count > 0 {
+		return fmt.Errorf("%s/%s belongs to another tenant than %q: %w", tableName, id, s.Tenant, ErrTenantMismatch)
+	}
+	return nil
+}
+
+// SyncAllowsTenant reports whether rows of tenant are exported to remote.
+func SyncAllowsTenant(policy SyncPolicy, remote, tenant string) bool {
+	filter, ok := policy.Remotes[remote]
+	if !ok || len(filter.Tenants) == 0 {
+		return true
+	}
+	return slices.Contains(filter.Tenants, tenant)
+}
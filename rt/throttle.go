@@ -0,0 +1,54 @@
+package proprdbrt
+
+import (
+	"context"
+	"time"
+)
+
+// syncPacer spaces batches of records to JSONLOptions.MaxRecordsPerSecond
+// and stops at the first batch after ctx is done.
+type syncPacer struct {
+	ctx      context.Context
+	interval time.Duration
+	next     time.Time
+}
+
+func newSyncPacer(ctx context.Context, opts JSONLOptions) *syncPacer {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	pacer := &syncPacer{ctx: ctx}
+	if opts.MaxRecordsPerSecond > 0 {
+		pacer.interval = time.Duration(float64(time.Second) / opts.MaxRecordsPerSecond)
+	}
+	return pacer
+}
+
+// wait blocks until a batch of records may start, which is immediately
+// for the first one, and fails once ctx is done.
+func (p *syncPacer) wait(records int) error {
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	if p.interval == 0 {
+		return nil
+	}
+	now := time.Now()
+	if p.next.Before(now) {
+		// Time spent idle or elsewhere does not accumulate into a burst.
+		p.next = now
+	}
+	delay := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(records) * p.interval)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
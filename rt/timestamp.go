@@ -0,0 +1,52 @@
+package proprdbrt
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TimestampTextLayout formats RFC 3339 timestamp columns in UTC with fixed
+// width nanoseconds, so that text order matches time order.
+const TimestampTextLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// TimestampNs is the INTEGER column value of a projected Timestamp field.
+func TimestampNs(ts *timestamppb.Timestamp) int64 {
+	return ts.AsTime().UnixNano()
+}
+
+// TimestampText is the TEXT column value of a projected Timestamp field with
+// TIMESTAMP_FORMAT_RFC3339.
+func TimestampText(ts *timestamppb.Timestamp) string {
+	return FormatTimestampText(ts.AsTime())
+}
+
+// FormatTimestampText formats t like TimestampText, e.g. for comparisons
+// against RFC 3339 timestamp columns.
+func FormatTimestampText(t time.Time) string {
+	return t.UTC().Format(TimestampTextLayout)
+}
+
+// ProjectedTime converts the value scanned from a projected Timestamp
+// column, Unix nanoseconds or RFC 3339 text, to a time. NULL is nil.
+func ProjectedTime(value any) (*time.Time, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		t = time.Unix(0, v).UTC()
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("parse timestamp %q: %w", v, err)
+		}
+		t = parsed.UTC()
+	case []byte:
+		return ProjectedTime(string(v))
+	default:
+		return nil, fmt.Errorf("unexpected timestamp column type %T", value)
+	}
+	return &t, nil
+}
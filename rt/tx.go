@@ -0,0 +1,137 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how WithTxRetry retries transactions that fail
+// because the database is busy or locked. Zero fields use the values of
+// DefaultRetryPolicy.
+type RetryPolicy struct {
+	// Attempts is the total number of tries, including the first.
+	Attempts int
+	// InitialBackoff is the delay before the first retry. It doubles after
+	// every retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is used for zero RetryPolicy fields.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       5,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = DefaultRetryPolicy.Attempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	return p
+}
+
+// sqliteCodeError matches driver errors exposing the SQLite result code,
+// e.g. modernc.org/sqlite.
+type sqliteCodeError interface {
+	Code() int
+}
+
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// IsBusy reports whether err was caused by SQLITE_BUSY or SQLITE_LOCKED,
+// i.e. another connection holds a conflicting lock and retrying may succeed.
+func IsBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	var codeErr sqliteCodeError
+	if errors.As(err, &codeErr) {
+		primaryCode := codeErr.Code() & 0xff
+		return primaryCode == sqliteBusy || primaryCode == sqliteLocked
+	}
+	// github.com/mattn/go-sqlite3 exposes codes only as struct fields.
+	message := err.Error()
+	for _, marker := range []string{"database is locked", "database table is locked", "SQLITE_BUSY", "SQLITE_LOCKED"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTxRetry runs fn in a transaction begun on q, committing when fn
+// returns nil and rolling back otherwise. Attempts failing with IsBusy,
+// including at commit, are retried with exponential backoff, so fn may run
+// more than once and should have no effects outside the transaction.
+//
+// If q cannot begin transactions, e.g. because it is a *sql.Tx, fn runs on
+// q directly without retries and the caller owns the transaction.
+func WithTxRetry(ctx context.Context, q DBTX, policy RetryPolicy, fn func(DBTX) error) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if fn == nil {
+		return errors.New("nil fn")
+	}
+	beginner, ok := q.(txBeginner)
+	if !ok {
+		return fn(q)
+	}
+	policy = policy.withDefaults()
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, beginner, fn)
+		if err == nil || !IsBusy(err) || attempt >= policy.Attempts {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (additionally, %v)", err, ctx.Err())
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, policy.MaxBackoff)
+	}
+}
+
+// txBeginner is implemented by *sql.DB and *sql.Conn.
+type txBeginner interface {
+	BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+}
+
+func runTx(ctx context.Context, beginner txBeginner, fn func(DBTX) error) error {
+	tx, err := beginner.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (additionally, rollback: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// InTx runs fn in a transaction like WithTxRetry, but without retries.
+func InTx(q DBTX, fn func(DBTX) error) error {
+	return WithTxRetry(context.Background(), q, RetryPolicy{Attempts: 1}, fn)
+}
@@ -0,0 +1,185 @@
+package proprdbrt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// UnknownRetention bounds _unknown_types, which grows while peers run newer
+// schemas. Zero fields do not limit.
+type UnknownRetention struct {
+	// MaxRows keeps at most this many rows.
+	MaxRows int64
+	// MaxAge evicts rows whose at_ns is older than this.
+	MaxAge time.Duration
+	// MaxBytes keeps at most this many bytes of record data.
+	MaxBytes int64
+}
+
+// Limited reports whether r limits anything.
+func (r UnknownRetention) Limited() bool {
+	return r.MaxRows > 0 || r.MaxAge > 0 || r.MaxBytes > 0
+}
+
+// UnknownEviction reports the rows EvictUnknown removed.
+type UnknownEviction struct {
+	Rows  int64
+	Bytes int64
+	// Types counts the evicted rows per type name.
+	Types map[string]int64
+}
+
+// unknownBoundary is the newest evicted row; it and all older rows go.
+type unknownBoundary struct {
+	atNs  int64
+	rowID int64
+}
+
+const unknownEvictedCondition = `at_ns < ? OR (at_ns = ? AND rowid <= ?)`
+
+// EvictUnknown compacts _unknown_types and then evicts its oldest rows, by
+// at_ns, until retention holds at nowNs. When archive is not nil, the
+// evicted rows are first written to it as WriteUnknownJSONL does, so that
+// they can be imported again. It runs in a transaction unless q is one
+// already; a failed archive write evicts nothing.
+func EvictUnknown(q DBTX, retention UnknownRetention, nowNs int64, archive io.Writer, opts JSONLOptions) (UnknownEviction, error) {
+	eviction := UnknownEviction{Types: make(map[string]int64)}
+	if q == nil {
+		return eviction, errors.New("nil DBTX")
+	}
+	if !retention.Limited() {
+		return eviction, nil
+	}
+	err := InTx(q, func(tx DBTX) error {
+		if err := CompactUnknownLatest(tx); err != nil {
+			return err
+		}
+		boundary, err := findUnknownBoundary(tx, retention, nowNs, &eviction)
+		if err != nil || boundary == nil {
+			return err
+		}
+		args := []any{boundary.atNs, boundary.atNs, boundary.rowID}
+		if archive != nil {
+			pending, err := unknownPending(tx, unknownEvictedCondition, args...)
+			if err != nil {
+				return err
+			}
+			if err := WriteJSONLChunksWithOptions(nil, "", archive, pending, max(len(pending), 1), opts, nil); err != nil {
+				return fmt.Errorf("archive evicted unknown rows: %w", err)
+			}
+		}
+		if _, err := tx.ExecContext(context.Background(), `DELETE FROM `+CoreTableUnknownName+` WHERE `+unknownEvictedCondition, args...); err != nil {
+			return fmt.Errorf("evict unknown rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return UnknownEviction{Types: make(map[string]int64)}, err
+	}
+	return eviction, nil
+}
+
+// findUnknownBoundary walks _unknown_types from the newest row and returns
+// the first row retention evicts, counting it and all older rows into
+// eviction. It returns nil when everything is kept.
+func findUnknownBoundary(q DBTX, retention UnknownRetention, nowNs int64, eviction *UnknownEviction) (*unknownBoundary, error) {
+	selectSQL := `SELECT rowid, type_name, at_ns, length(CAST(data_json AS BLOB)) FROM ` + CoreTableUnknownName + ` ORDER BY at_ns DESC, rowid DESC`
+	rows, err := q.QueryContext(context.Background(), selectSQL)
+	if err != nil {
+		return nil, fmt.Errorf("select unknown rows: %w", err)
+	}
+	var boundary *unknownBoundary
+	var keptRows, keptBytes int64
+	for rows.Next() {
+		var rowID, atNs, size int64
+		var typeName string
+		if err := rows.Scan(&rowID, &typeName, &atNs, &size); err != nil {
+			if closeErr := CloseRows(rows, "unknown rows"); closeErr != nil {
+				return nil, fmt.Errorf("scan unknown row: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan unknown row: %w", err)
+		}
+		if boundary == nil {
+			switch {
+			case retention.MaxAge > 0 && atNs < nowNs-retention.MaxAge.Nanoseconds(),
+				retention.MaxRows > 0 && keptRows >= retention.MaxRows,
+				retention.MaxBytes > 0 && keptBytes+size > retention.MaxBytes:
+				boundary = &unknownBoundary{atNs: atNs, rowID: rowID}
+			default:
+				keptRows++
+				keptBytes += size
+				continue
+			}
+		}
+		eviction.Rows++
+		eviction.Bytes += size
+		eviction.Types[typeName]++
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "unknown rows"); closeErr != nil {
+			return nil, fmt.Errorf("iterate unknown rows: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate unknown rows: %w", err)
+	}
+	if err := CloseRows(rows, "unknown rows"); err != nil {
+		return nil, err
+	}
+	return boundary, nil
+}
+
+// WriteUnknownJSONL writes the rows of _unknown_types as JSONL per opts,
+// oldest first, without touching _sync. A replica that knows their types
+// imports them with ReadJSONL.
+func WriteUnknownJSONL(q DBTX, w io.Writer, opts JSONLOptions) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	pending, err := unknownPending(q, "")
+	if err != nil {
+		return err
+	}
+	return WriteJSONLChunksWithOptions(nil, "", w, pending, max(len(pending), 1), opts, nil)
+}
+
+// unknownPending selects the rows of _unknown_types matching where, or all
+// rows when it is empty, oldest first.
+func unknownPending(q DBTX, where string, args ...any) ([]PendingJSONLRecord, error) {
+	selectSQL := `SELECT id, at_ns, deleted, data_json FROM ` + CoreTableUnknownName
+	if where != "" {
+		selectSQL += ` WHERE ` + where
+	}
+	selectSQL += ` ORDER BY at_ns, rowid`
+	rows, err := q.QueryContext(context.Background(), selectSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select unknown rows: %w", err)
+	}
+	pending := make([]PendingJSONLRecord, 0)
+	for rows.Next() {
+		var record JSONLRecord
+		var deletedInt int
+		var dataJSON string
+		if err := rows.Scan(&record.ID, &record.AtNs, &deletedInt, &dataJSON); err != nil {
+			if closeErr := CloseRows(rows, "unknown rows"); closeErr != nil {
+				return nil, fmt.Errorf("scan unknown row: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan unknown row: %w", err)
+		}
+		record.Deleted = deletedInt != 0
+		record.Data = json.RawMessage(dataJSON)
+		pending = append(pending, PendingJSONLRecord{TableName: CoreTableUnknownName, Record: record})
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "unknown rows"); closeErr != nil {
+			return nil, fmt.Errorf("iterate unknown rows: %w (additionally, %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("iterate unknown rows: %w", err)
+	}
+	if err := CloseRows(rows, "unknown rows"); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
@@ -0,0 +1,116 @@
+package proprdbrt
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrInvalid is wrapped by errors of declarative field rules.
+var ErrInvalid = errors.New("invalid")
+
+// FieldRule holds the (proprdb.min), (proprdb.max), (proprdb.pattern) and
+// (proprdb.required) options of one field.
+type FieldRule struct {
+	Field    string
+	HasMin   bool
+	Min      float64
+	HasMax   bool
+	Max      float64
+	Pattern  string
+	Required bool
+}
+
+var fieldPatterns sync.Map
+
+// ValidateFieldRules checks message against rules. Min, max and pattern are
+// not checked for unset fields with presence, e.g. proto3 optional fields.
+func ValidateFieldRules(message proto.Message, rules []FieldRule) error {
+	reflected := message.ProtoReflect()
+	fields := reflected.Descriptor().Fields()
+	for _, rule := range rules {
+		field := fields.ByName(protoreflect.Name(rule.Field))
+		if field == nil {
+			return fmt.Errorf("validate unknown field %q", rule.Field)
+		}
+		isSet := reflected.Has(field)
+		if rule.Required && !isSet {
+			return fmt.Errorf("%w: %s is required", ErrInvalid, rule.Field)
+		}
+		if !isSet && field.HasPresence() {
+			continue
+		}
+		value := reflected.Get(field)
+		if rule.HasMin || rule.HasMax {
+			number, ok := numericFieldValue(field.Kind(), value)
+			if !ok {
+				return fmt.Errorf("validate %s: %s is not numeric", rule.Field, field.Kind())
+			}
+			if rule.HasMin && number < rule.Min {
+				return fmt.Errorf("%w: %s must be at least %v, got %v", ErrInvalid, rule.Field, rule.Min, number)
+			}
+			if rule.HasMax && number > rule.Max {
+				return fmt.Errorf("%w: %s must be at most %v, got %v", ErrInvalid, rule.Field, rule.Max, number)
+			}
+		}
+		if rule.Pattern != "" {
+			pattern, err := fieldPattern(rule.Pattern)
+			if err != nil {
+				return fmt.Errorf("validate %s: %w", rule.Field, err)
+			}
+			if !pattern.MatchString(value.String()) {
+				return fmt.Errorf("%w: %s must match %q", ErrInvalid, rule.Field, rule.Pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func numericFieldValue(kind protoreflect.Kind, value protoreflect.Value) (float64, bool) {
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return float64(value.Int()), true
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return float64(value.Uint()), true
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return value.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func fieldPattern(expr string) (*regexp.Regexp, error) {
+	if cached, ok := fieldPatterns.Load(expr); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("compile pattern %q: %w", expr, err)
+	}
+	fieldPatterns.Store(expr, pattern)
+	return pattern, nil
+}
+
+// ErrNoProtoValidator is returned by ProtoValidate without
+// Options.ProtoValidate, so buf.validate constraints are never skipped
+// silently.
+var ErrNoProtoValidator = errors.New("no Options.ProtoValidate for buf.validate constraints")
+
+// ProtoValidateFunc checks the buf.validate (protovalidate) constraints of
+// message, e.g. func(m proto.Message) error { return protovalidate.Validate(m) }.
+type ProtoValidateFunc func(message proto.Message) error
+
+// ProtoValidate checks message with opts.ProtoValidate. Tables generated
+// with protovalidate=true call it on writes of messages with buf.validate
+// constraints, after Valid and the field rules.
+func ProtoValidate(opts Options, message proto.Message) error {
+	if opts.ProtoValidate == nil {
+		return ErrNoProtoValidator
+	}
+	return opts.ProtoValidate(message)
+}
@@ -0,0 +1,165 @@
+package proprdbrt
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Neighbor is one result of NearestNeighbors.
+type Neighbor struct {
+	ID string
+	// Distance is the cosine distance to the query, from 0 for the same
+	// direction to 2 for the opposite one.
+	Distance float64
+}
+
+// EncodeVector encodes a (proprdb.vector) field as its column value: the
+// elements as little-endian float32, the format sqlite-vec uses.
+func EncodeVector[F float32 | float64](values []F) []byte {
+	blob := make([]byte, 0, 4*len(values))
+	for _, value := range values {
+		blob = binary.LittleEndian.AppendUint32(blob, math.Float32bits(float32(value)))
+	}
+	return blob
+}
+
+// DecodeVector decodes a column written by EncodeVector.
+func DecodeVector(blob []byte) ([]float32, error) {
+	if len(blob)%4 != 0 {
+		return nil, fmt.Errorf("vector of %d bytes is not float32 aligned", len(blob))
+	}
+	values := make([]float32, len(blob)/4)
+	for index := range values {
+		values[index] = math.Float32frombits(binary.LittleEndian.Uint32(blob[4*index:]))
+	}
+	return values, nil
+}
+
+// CosineDistance returns 1 minus the cosine similarity of a and b, which
+// must have the same length. Zero vectors have distance 1 to everything.
+func CosineDistance(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for index := range a {
+		dot += float64(a[index]) * float64(b[index])
+		normA += float64(a[index]) * float64(a[index])
+		normB += float64(b[index]) * float64(b[index])
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/math.Sqrt(normA*normB)
+}
+
+// NearestNeighbors returns the ids of up to k rows of tableName matching
+// where whose vector column is closest to query by cosine distance, nearest
+// first. Rows of another dimension, including empty vectors, are skipped.
+//
+// When the sqlite-vec extension is loaded, SQLite computes the distances
+// with vec_distance_cosine; otherwise every candidate vector is read and
+// compared in Go.
+func NearestNeighbors[F float32 | float64](q DBTX, tableName, column, where string, args []any, query []F, k int) ([]Neighbor, error) {
+	switch {
+	case q == nil:
+		return nil, errors.New("nil DBTX")
+	case len(query) == 0:
+		return nil, errors.New("empty query vector")
+	case k <= 0:
+		return nil, nil
+	}
+	encoded := EncodeVector(query)
+	conditions := `length(` + quoteSQLiteIdentifier(column) + `) = ?`
+	if strings.TrimSpace(where) != "" {
+		conditions += ` AND (` + where + `)`
+	}
+	conditionArgs := append([]any{len(encoded)}, args...)
+	neighbors, err := nearestNeighborsSQL(q, tableName, column, conditions, conditionArgs, encoded, k)
+	if err == nil || !strings.Contains(err.Error(), "no such function: vec_distance_cosine") {
+		return neighbors, err
+	}
+	vector, err := DecodeVector(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return nearestNeighborsScan(q, tableName, column, conditions, conditionArgs, vector, k)
+}
+
+func nearestNeighborsSQL(q DBTX, tableName, column, conditions string, args []any, encoded []byte, k int) ([]Neighbor, error) {
+	query := `SELECT id, vec_distance_cosine(` + quoteSQLiteIdentifier(column) + `, ?) AS distance FROM ` + quoteSQLiteIdentifier(tableName) +
+		` WHERE ` + conditions + ` ORDER BY distance, id LIMIT ?`
+	rows, err := q.QueryContext(context.Background(), query, append(append([]any{encoded}, args...), k)...)
+	if err != nil {
+		return nil, fmt.Errorf("nearest neighbors in %s: %w", tableName, err)
+	}
+	neighbors := make([]Neighbor, 0, k)
+	for rows.Next() {
+		var neighbor Neighbor
+		if err := rows.Scan(&neighbor.ID, &neighbor.Distance); err != nil {
+			if closeErr := CloseRows(rows, "nearest neighbors"); closeErr != nil {
+				return nil, fmt.Errorf("scan neighbor: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan neighbor: %w", err)
+		}
+		neighbors = append(neighbors, neighbor)
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "nearest neighbors"); closeErr != nil {
+			return nil, fmt.Errorf("nearest neighbors in %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return nil, fmt.Errorf("nearest neighbors in %s: %w", tableName, err)
+	}
+	return neighbors, CloseRows(rows, "nearest neighbors")
+}
+
+func nearestNeighborsScan(q DBTX, tableName, column, conditions string, args []any, query []float32, k int) ([]Neighbor, error) {
+	rows, err := q.QueryContext(context.Background(), `SELECT id, `+quoteSQLiteIdentifier(column)+` FROM `+quoteSQLiteIdentifier(tableName)+` WHERE `+conditions, args...)
+	if err != nil {
+		return nil, fmt.Errorf("nearest neighbors in %s: %w", tableName, err)
+	}
+	neighbors := make([]Neighbor, 0)
+	for rows.Next() {
+		var id string
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			if closeErr := CloseRows(rows, "nearest neighbors"); closeErr != nil {
+				return nil, fmt.Errorf("scan neighbor: %w (additionally, %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("scan neighbor: %w", err)
+		}
+		vector, err := DecodeVector(blob)
+		if err != nil {
+			if closeErr := CloseRows(rows, "nearest neighbors"); closeErr != nil {
+				return nil, fmt.Errorf("decode %s/%s: %w (additionally, %v)", tableName, id, err, closeErr)
+			}
+			return nil, fmt.Errorf("decode %s/%s: %w", tableName, id, err)
+		}
+		neighbors = append(neighbors, Neighbor{ID: id, Distance: CosineDistance(query, vector)})
+	}
+	if err := rows.Err(); err != nil {
+		if closeErr := CloseRows(rows, "nearest neighbors"); closeErr != nil {
+			return nil, fmt.Errorf("nearest neighbors in %s: %w (additionally, %v)", tableName, err, closeErr)
+		}
+		return nil, fmt.Errorf("nearest neighbors in %s: %w", tableName, err)
+	}
+	if err := CloseRows(rows, "nearest neighbors"); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(neighbors, func(a, b Neighbor) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), strings.Compare(a.ID, b.ID))
+	})
+	return neighbors[:min(k, len(neighbors))], nil
+}
+
+// NeighborIDs returns the ids of neighbors in order.
+func NeighborIDs(neighbors []Neighbor) []string {
+	ids := make([]string, 0, len(neighbors))
+	for _, neighbor := range neighbors {
+		ids = append(ids, neighbor.ID)
+	}
+	return ids
+}
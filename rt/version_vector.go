@@ -0,0 +1,235 @@
+package proprdbrt
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// VersionVectorColumn stores the JSON encoded version vector of a row in
+// tables generated with (proprdb.version_vector).
+const VersionVectorColumn = "vv"
+
+// VersionVector maps device ids to per-object edit counters.
+type VersionVector map[string]uint64
+
+// VersionOrder describes how two version vectors relate.
+type VersionOrder int
+
+const (
+	VersionEqual VersionOrder = iota
+	// VersionBefore means the receiver is dominated by the other vector.
+	VersionBefore
+	// VersionAfter means the receiver dominates the other vector.
+	VersionAfter
+	// VersionConcurrent means both vectors contain edits unknown to the other.
+	VersionConcurrent
+)
+
+func (o VersionOrder) String() string {
+	switch o {
+	case VersionEqual:
+		return "equal"
+	case VersionBefore:
+		return "before"
+	case VersionAfter:
+		return "after"
+	case VersionConcurrent:
+		return "concurrent"
+	default:
+		return fmt.Sprintf("VersionOrder(%d)", int(o))
+	}
+}
+
+// Compare orders v relative to other.
+func (v VersionVector) Compare(other VersionVector) VersionOrder {
+	less, greater := false, false
+	for deviceID, counter := range v {
+		otherCounter := other[deviceID]
+		if counter < otherCounter {
+			less = true
+		} else if counter > otherCounter {
+			greater = true
+		}
+	}
+	for deviceID, otherCounter := range other {
+		if _, ok := v[deviceID]; !ok && otherCounter > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return VersionConcurrent
+	case less:
+		return VersionBefore
+	case greater:
+		return VersionAfter
+	default:
+		return VersionEqual
+	}
+}
+
+// Merge returns the element-wise maximum of v and other.
+func (v VersionVector) Merge(other VersionVector) VersionVector {
+	merged := make(VersionVector, max(len(v), len(other)))
+	for deviceID, counter := range v {
+		merged[deviceID] = counter
+	}
+	for deviceID, counter := range other {
+		merged[deviceID] = max(merged[deviceID], counter)
+	}
+	return merged
+}
+
+// Increment returns a copy of v with the counter of deviceID advanced by one.
+func (v VersionVector) Increment(deviceID string) VersionVector {
+	incremented := v.Merge(nil)
+	incremented[deviceID]++
+	return incremented
+}
+
+// ConcurrentEdit describes two versions of an object that were changed
+// independently on different devices.
+type ConcurrentEdit struct {
+	TypeName      string
+	ID            string
+	Local         proto.Message
+	LocalAtNs     int64
+	LocalVersion  VersionVector
+	Remote        proto.Message
+	RemoteAtNs    int64
+	RemoteVersion VersionVector
+}
+
+// ConcurrentEditFunc resolves a concurrent edit by returning the object to
+// store. Returning edit.Local or edit.Remote keeps that version.
+type ConcurrentEditFunc func(edit ConcurrentEdit) (proto.Message, error)
+
+// ReadVersionVector returns the version vector stored for id. found is false
+// when no live row exists.
+func ReadVersionVector(q DBTX, tableName, id string) (VersionVector, bool, error) {
+	if q == nil {
+		return nil, false, errors.New("nil DBTX")
+	}
+	var encoded string
+	query := `SELECT ` + VersionVectorColumn + ` FROM ` + quoteSQLiteIdentifier(tableName) + ` WHERE id = ?`
+	err := q.QueryRowContext(context.Background(), query, id).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("select version vector for %s/%s: %w", tableName, id, err)
+	}
+	versionVector := VersionVector{}
+	if encoded != "" {
+		if err := json.Unmarshal([]byte(encoded), &versionVector); err != nil {
+			return nil, false, fmt.Errorf("decode version vector for %s/%s: %w", tableName, id, err)
+		}
+	}
+	return versionVector, true, nil
+}
+
+// WriteVersionVector replaces the version vector stored for id.
+func WriteVersionVector(q DBTX, tableName, id string, versionVector VersionVector) error {
+	if q == nil {
+		return errors.New("nil DBTX")
+	}
+	if versionVector == nil {
+		versionVector = VersionVector{}
+	}
+	encoded, err := json.Marshal(versionVector)
+	if err != nil {
+		return fmt.Errorf("encode version vector for %s/%s: %w", tableName, id, err)
+	}
+	query := `UPDATE ` + quoteSQLiteIdentifier(tableName) + ` SET ` + VersionVectorColumn + ` = ? WHERE id = ?`
+	if _, err := q.ExecContext(context.Background(), query, string(encoded), id); err != nil {
+		return fmt.Errorf("update version vector for %s/%s: %w", tableName, id, err)
+	}
+	return nil
+}
+
+// BumpVersionVector records a local edit of id by opts.DeviceID.
+func BumpVersionVector(q DBTX, opts Options, tableName, id string) error {
+	if opts.DeviceID == "" {
+		return fmt.Errorf("write %s/%s: version vectors require Options.DeviceID", tableName, id)
+	}
+	versionVector, _, err := ReadVersionVector(q, tableName, id)
+	if err != nil {
+		return err
+	}
+	return WriteVersionVector(q, tableName, id, versionVector.Increment(opts.DeviceID))
+}
+
+// VersionedResolution is the outcome of ResolveVersioned.
+type VersionedResolution struct {
+	Apply         bool
+	Data          proto.Message
+	AtNs          int64
+	VersionVector VersionVector
+}
+
+// ResolveVersioned decides what to store when a remote version of a live
+// local row arrives. Causally newer remote versions replace the local row,
+// older or equal ones are ignored. Concurrent edits are passed to
+// opts.OnConcurrentEdit when set, otherwise strategy resolves them as it
+// would without version vectors. A resolution that differs from the remote
+// version gets a newer at_ns so it is sent back; one that differs from both
+// versions also counts as a new edit by opts.DeviceID.
+func ResolveVersioned(opts Options, strategy ConflictStrategy, fieldMerges map[string]FieldMerge, edit ConcurrentEdit) (VersionedResolution, error) {
+	switch edit.LocalVersion.Compare(edit.RemoteVersion) {
+	case VersionEqual, VersionAfter:
+		return VersionedResolution{}, nil
+	case VersionBefore:
+		return VersionedResolution{
+			Apply:         true,
+			Data:          edit.Remote,
+			AtNs:          max(edit.LocalAtNs, edit.RemoteAtNs),
+			VersionVector: edit.RemoteVersion.Merge(edit.LocalVersion),
+		}, nil
+	}
+
+	var resolved proto.Message
+	var err error
+	switch {
+	case opts.OnConcurrentEdit != nil:
+		resolved, err = opts.OnConcurrentEdit(edit)
+		if err != nil {
+			return VersionedResolution{}, ConflictError(fmt.Errorf("resolve concurrent edit of %s %s: %w", edit.TypeName, edit.ID, err))
+		}
+		if resolved == nil {
+			return VersionedResolution{}, ConflictError(fmt.Errorf("resolve concurrent edit of %s %s: nil result", edit.TypeName, edit.ID))
+		}
+	case strategy == ConflictMerge:
+		resolved, _, err = MergeRemote(opts, edit.TypeName, fieldMerges, edit.Local, edit.LocalAtNs, edit.Remote, edit.RemoteAtNs)
+		if err != nil {
+			return VersionedResolution{}, err
+		}
+	case ShouldApplyRemote(strategy, edit.RemoteAtNs, edit.LocalAtNs, false):
+		resolved = edit.Remote
+	default:
+		resolved = edit.Local
+	}
+
+	resolution := VersionedResolution{
+		Apply:         true,
+		Data:          resolved,
+		AtNs:          max(edit.LocalAtNs, edit.RemoteAtNs),
+		VersionVector: edit.LocalVersion.Merge(edit.RemoteVersion),
+	}
+	if proto.Equal(resolved, edit.Remote) {
+		return resolution, nil
+	}
+	resolution.AtNs++
+	if proto.Equal(resolved, edit.Local) {
+		return resolution, nil
+	}
+	if opts.DeviceID == "" {
+		return VersionedResolution{}, fmt.Errorf("resolve concurrent edit of %s %s: version vectors require Options.DeviceID", edit.TypeName, edit.ID)
+	}
+	resolution.VersionVector = resolution.VersionVector.Increment(opts.DeviceID)
+	return resolution, nil
+}
@@ -0,0 +1,201 @@
+package proprdbrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrWriteCoordinatorClosed is returned by WriteCoordinator.Do after Close.
+var ErrWriteCoordinatorClosed = errors.New("write coordinator closed")
+
+// WriteCoordinatorOptions configures the batching of a WriteCoordinator.
+// Zero fields use the values of DefaultWriteCoordinatorOptions.
+type WriteCoordinatorOptions struct {
+	// MaxBatch is the maximum number of writes committed together.
+	MaxBatch int
+	// Interval is how long a batch collects writes after its first one
+	// before it is committed.
+	Interval time.Duration
+	// TxRetry controls how batches failing because the database is busy or
+	// locked are retried.
+	TxRetry RetryPolicy
+}
+
+// DefaultWriteCoordinatorOptions is used for zero WriteCoordinatorOptions
+// fields.
+var DefaultWriteCoordinatorOptions = WriteCoordinatorOptions{
+	MaxBatch: 256,
+	Interval: 5 * time.Millisecond,
+}
+
+type writeRequest struct {
+	fn    func(DBTX) error
+	err   error
+	reply chan error
+}
+
+// WriteCoordinator serializes writes through one goroutine and commits them
+// in groups: a transaction is begun for the first queued write and the
+// writes arriving within Interval, up to MaxBatch, join it. Each write runs
+// in a savepoint, so a failing write does not affect the others of its
+// batch. Set it as Options.WriteCoordinator to route the Insert, Update and
+// Delete methods of generated tables through it.
+type WriteCoordinator struct {
+	q        DBTX
+	opts     WriteCoordinatorOptions
+	mu       sync.RWMutex
+	closed   bool
+	requests chan *writeRequest
+	done     chan struct{}
+}
+
+// NewWriteCoordinator starts a coordinator writing through q, which should
+// be able to begin transactions, e.g. a *sql.DB. Close stops it.
+func NewWriteCoordinator(q DBTX, opts WriteCoordinatorOptions) *WriteCoordinator {
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = DefaultWriteCoordinatorOptions.MaxBatch
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWriteCoordinatorOptions.Interval
+	}
+	c := &WriteCoordinator{
+		q:        q,
+		opts:     opts,
+		requests: make(chan *writeRequest),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Do runs fn in the transaction of the next batch and returns once the batch
+// is committed: with the error of fn, or the error committing the batch. fn
+// may run more than once when a busy batch is retried. ctx only bounds the
+// wait for a place in a batch.
+func (c *WriteCoordinator) Do(ctx context.Context, fn func(tx DBTX) error) error {
+	if c == nil {
+		return errors.New("nil WriteCoordinator")
+	}
+	if fn == nil {
+		return errors.New("nil fn")
+	}
+	request := &writeRequest{fn: fn, reply: make(chan error, 1)}
+	c.mu.RLock()
+	if c.closed {
+		c.mu.RUnlock()
+		return ErrWriteCoordinatorClosed
+	}
+	select {
+	case c.requests <- request:
+		c.mu.RUnlock()
+	case <-ctx.Done():
+		c.mu.RUnlock()
+		return ctx.Err()
+	}
+	return <-request.reply
+}
+
+// CoordinateWrite is Do for writes returning a value.
+func CoordinateWrite[T any](c *WriteCoordinator, fn func(tx DBTX) (T, error)) (T, error) {
+	var result T
+	err := c.Do(context.Background(), func(tx DBTX) error {
+		var err error
+		result, err = fn(tx)
+		return err
+	})
+	return result, err
+}
+
+// Close commits the pending batch and stops the coordinator. Later calls to
+// Do fail with ErrWriteCoordinatorClosed.
+func (c *WriteCoordinator) Close() error {
+	if c == nil {
+		return errors.New("nil WriteCoordinator")
+	}
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.requests)
+	}
+	c.mu.Unlock()
+	<-c.done
+	return nil
+}
+
+func (c *WriteCoordinator) run() {
+	defer close(c.done)
+	for {
+		first, ok := <-c.requests
+		if !ok {
+			return
+		}
+		batch := []*writeRequest{first}
+		collected, closed := false, false
+		err := WithTxRetry(context.Background(), c.q, c.opts.TxRetry, func(tx DBTX) error {
+			for _, request := range batch {
+				if err := runSavepoint(tx, request); err != nil {
+					return err
+				}
+			}
+			if collected {
+				return nil
+			}
+			collected = true
+			timer := time.NewTimer(c.opts.Interval)
+			defer timer.Stop()
+			for len(batch) < c.opts.MaxBatch {
+				select {
+				case request, ok := <-c.requests:
+					if !ok {
+						closed = true
+						return nil
+					}
+					batch = append(batch, request)
+					if err := runSavepoint(tx, request); err != nil {
+						return err
+					}
+				case <-timer.C:
+					return nil
+				}
+			}
+			return nil
+		})
+		for _, request := range batch {
+			if err != nil {
+				request.reply <- err
+			} else {
+				request.reply <- request.err
+			}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// runSavepoint runs request in a savepoint of tx, rolling back to it when
+// the request fails. It only returns errors of the savepoint itself, and
+// busy errors of the request, which fail the whole batch so that it is
+// retried.
+func runSavepoint(tx DBTX, request *writeRequest) error {
+	ctx := context.Background()
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT proprdb_write`); err != nil {
+		return fmt.Errorf("begin write savepoint: %w", err)
+	}
+	request.err = request.fn(tx)
+	if IsBusy(request.err) {
+		return request.err
+	}
+	if request.err != nil {
+		if _, err := tx.ExecContext(ctx, `ROLLBACK TO proprdb_write`); err != nil {
+			return fmt.Errorf("roll back write savepoint: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `RELEASE proprdb_write`); err != nil {
+		return fmt.Errorf("release write savepoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package proprdbsyncd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	s3DefaultRegion   = "us-east-1"
+	s3SigningAlgo     = "AWS4-HMAC-SHA256"
+	s3AmzDateFormat   = "20060102T150405Z"
+	s3ScopeDateFormat = "20060102"
+)
+
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// s3Transport stores each pushed segment as an object below a key prefix of
+// an S3-compatible bucket. Requests use path-style addressing and are signed
+// with AWS Signature Version 4.
+type s3Transport struct {
+	endpoint    *url.URL
+	bucket      string
+	prefix      string
+	region      string
+	device      string
+	credentials s3Credentials
+	client      *http.Client
+	seen        map[string]bool
+}
+
+// newS3Transport handles s3://bucket/prefix URLs. The endpoint and region
+// query parameters default to AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) and
+// AWS_REGION (or AWS_DEFAULT_REGION); without an endpoint AWS S3 is used.
+// Credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+func newS3Transport(location *url.URL, device string) (RemoteTransport, error) {
+	if location.Host == "" {
+		return nil, errors.New("s3 remote without bucket")
+	}
+	query := location.Query()
+	region := firstNonEmpty(query.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), s3DefaultRegion)
+	endpointString := firstNonEmpty(query.Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"), "https://s3."+region+".amazonaws.com")
+	endpoint, err := url.Parse(endpointString)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpointString)
+	}
+	credentials := s3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, errors.New("s3 remote requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	prefix := strings.Trim(location.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3Transport{
+		endpoint:    endpoint,
+		bucket:      location.Host,
+		prefix:      prefix,
+		region:      region,
+		device:      device,
+		credentials: credentials,
+		client:      http.DefaultClient,
+		seen:        make(map[string]bool),
+	}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (t *s3Transport) Push(ctx context.Context, segment []byte) error {
+	name := segmentName(t.device)
+	key := t.prefix + name
+	request, err := t.newRequest(ctx, http.MethodPut, key, nil, segment)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	response, err := t.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("put s3 object %s: %w", key, err)
+	}
+	if err := closeResponse(response, "put s3 object "+key, nil); err != nil {
+		return err
+	}
+	t.seen[name] = true
+	return nil
+}
+
+func (t *s3Transport) Pull(ctx context.Context, visit func(io.Reader) error) error {
+	return t.PullAfter(ctx, "", func(_ string, r io.Reader) error {
+		return visit(r)
+	})
+}
+
+// PullAfter visits the segments of other devices named after after, less
+// segmentPullGrace, that this transport did not see yet, listing the bucket
+// from there. Their keys are the object keys without the prefix.
+func (t *s3Transport) PullAfter(ctx context.Context, after string, visit func(key string, r io.Reader) error) error {
+	start := segmentListStart(after)
+	forgetSegments(t.seen, start)
+	names, err := t.listSegments(ctx, after, start)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		key := t.prefix + name
+		request, err := t.newRequest(ctx, http.MethodGet, key, nil, nil)
+		if err != nil {
+			return err
+		}
+		response, err := t.client.Do(request)
+		if err != nil {
+			return fmt.Errorf("get s3 object %s: %w", key, err)
+		}
+		err = closeResponse(response, "get s3 object "+key, func(body io.Reader) error {
+			return visit(name, body)
+		})
+		if err != nil {
+			return err
+		}
+		t.seen[name] = true
+	}
+	return nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// listSegments returns the names of new segments of other devices in push
+// order, listing the objects after start.
+func (t *s3Transport) listSegments(ctx context.Context, after, start string) ([]string, error) {
+	names := make([]string, 0)
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {t.prefix}}
+		if start != "" {
+			query.Set("start-after", t.prefix+start)
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		request, err := t.newRequest(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		response, err := t.client.Do(request)
+		if err != nil {
+			return nil, fmt.Errorf("list s3 objects: %w", err)
+		}
+		result := s3ListBucketResult{}
+		err = closeResponse(response, "list s3 objects", func(body io.Reader) error {
+			return xml.NewDecoder(body).Decode(&result)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, t.prefix)
+			if !isNewSegment(name, after, start, t.seen) || !isForeignSegment(name, t.device) {
+				continue
+			}
+			names = append(names, name)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		continuationToken = result.NextContinuationToken
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// newRequest builds a signed request for key of the bucket; an empty key
+// addresses the bucket itself.
+func (t *s3Transport) newRequest(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Request, error) {
+	target := *t.endpoint
+	target.Path = strings.TrimSuffix(t.endpoint.Path, "/") + "/" + t.bucket
+	if key != "" {
+		target.Path += "/" + key
+	}
+	target.RawPath = s3EscapePath(target.Path)
+	target.RawQuery = s3CanonicalQuery(query)
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build s3 request: %w", err)
+	}
+	request.ContentLength = int64(len(body))
+	t.sign(request, target.RawPath, body)
+	return request, nil
+}
+
+// sign adds AWS Signature Version 4 headers to request.
+func (t *s3Transport) sign(request *http.Request, escapedPath string, body []byte) {
+	now := time.Now().UTC()
+	amzDate := now.Format(s3AmzDateFormat)
+	scopeDate := now.Format(s3ScopeDateFormat)
+	payloadHash := sha256Hex(body)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if t.credentials.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", t.credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		escapedPath,
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := scopeDate + "/" + t.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{s3SigningAlgo, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+t.credentials.SecretAccessKey), scopeDate)
+	for _, part := range []string{t.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	request.Header.Set("Authorization", s3SigningAlgo+" Credential="+t.credentials.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but unreserved characters, as
+// required for canonical requests.
+func s3Escape(value string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,359 @@
+package proprdbsyncd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+)
+
+// Mode selects the directions synchronized with a remote.
+type Mode string
+
+const (
+	ModePush     Mode = "push"
+	ModePull     Mode = "pull"
+	ModePushPull Mode = "pushpull"
+)
+
+const (
+	defaultInterval   = 30 * time.Second
+	maxBackoffFactor  = 16
+	remoteSpecExample = "name=alpha,url=/srv/sync/alpha,every=30s,mode=pushpull"
+)
+
+// RemoteConfig describes one remote of the daemon.
+type RemoteConfig struct {
+	Name     string
+	URL      string
+	Interval time.Duration
+	Mode     Mode
+}
+
+// ParseRemote parses a remote specification of comma separated key=value
+// pairs: name and url are required, every (a duration) and mode (push, pull
+// or pushpull) are optional.
+func ParseRemote(spec string) (RemoteConfig, error) {
+	remote := RemoteConfig{Interval: defaultInterval, Mode: ModePushPull}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return RemoteConfig{}, fmt.Errorf("remote %q: expected key=value, e.g. %s", spec, remoteSpecExample)
+		}
+		switch key {
+		case "name":
+			remote.Name = value
+		case "url":
+			remote.URL = value
+		case "every":
+			interval, err := time.ParseDuration(value)
+			if err != nil {
+				return RemoteConfig{}, fmt.Errorf("remote %q: parse every: %w", spec, err)
+			}
+			if interval <= 0 {
+				return RemoteConfig{}, fmt.Errorf("remote %q: every must be positive", spec)
+			}
+			remote.Interval = interval
+		case "mode":
+			switch Mode(value) {
+			case ModePush, ModePull, ModePushPull:
+				remote.Mode = Mode(value)
+			default:
+				return RemoteConfig{}, fmt.Errorf("remote %q: unsupported mode %q", spec, value)
+			}
+		default:
+			return RemoteConfig{}, fmt.Errorf("remote %q: unknown key %q", spec, key)
+		}
+	}
+	if remote.Name == "" || remote.URL == "" {
+		return RemoteConfig{}, fmt.Errorf("remote %q: name and url are required", spec)
+	}
+	return remote, nil
+}
+
+type daemonRemote struct {
+	config    RemoteConfig
+	transport RemoteTransport
+}
+
+// Daemon periodically pushes and pulls JSONL between a database and its
+// remotes. Sync passes are serialized, as they share one database.
+type Daemon struct {
+	db        *sql.DB
+	newBundle func(q rt.DBTX) rt.Bundle
+	logger    *slog.Logger
+	remotes   []daemonRemote
+	mu        sync.Mutex
+}
+
+// NewDaemon opens the transports of remotes and initializes the tables.
+func NewDaemon(db *sql.DB, newBundle func(q rt.DBTX) rt.Bundle, device string, remotes []RemoteConfig, logger *slog.Logger) (*Daemon, error) {
+	if db == nil {
+		return nil, errors.New("nil database")
+	}
+	if newBundle == nil {
+		return nil, errors.New("nil bundle factory")
+	}
+	if device == "" {
+		return nil, errors.New("empty device id")
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if err := newBundle(db).Init(); err != nil {
+		return nil, fmt.Errorf("init tables: %w", err)
+	}
+	daemon := &Daemon{db: db, newBundle: newBundle, logger: logger}
+	names := make(map[string]bool, len(remotes))
+	for _, remote := range remotes {
+		if names[remote.Name] {
+			return nil, fmt.Errorf("duplicate remote %q", remote.Name)
+		}
+		names[remote.Name] = true
+		transport, err := OpenTransport(remote.URL, device)
+		if err != nil {
+			return nil, fmt.Errorf("remote %s: %w", remote.Name, err)
+		}
+		daemon.remotes = append(daemon.remotes, daemonRemote{config: remote, transport: transport})
+	}
+	return daemon, nil
+}
+
+// SyncOnce runs one pass against every remote and returns the joined errors.
+func (d *Daemon) SyncOnce(ctx context.Context) error {
+	errs := make([]error, 0)
+	for _, remote := range d.remotes {
+		if err := d.syncRemote(ctx, remote); err != nil {
+			errs = append(errs, fmt.Errorf("remote %s: %w", remote.config.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run syncs every remote on its own schedule until ctx is done. Failed
+// passes are retried with exponential backoff, capped at 16 intervals.
+func (d *Daemon) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, remote := range d.remotes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runRemote(ctx, remote)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (d *Daemon) runRemote(ctx context.Context, remote daemonRemote) {
+	failures := 0
+	for {
+		delay := remote.config.Interval
+		if err := d.syncRemote(ctx, remote); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			delay = backoff(remote.config.Interval, failures)
+			d.logger.Error("sync failed", "remote", remote.config.Name, "failures", failures, "retry_in", delay, "error", err)
+		} else {
+			failures = 0
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func backoff(interval time.Duration, failures int) time.Duration {
+	factor := 1
+	for range failures {
+		if factor >= maxBackoffFactor {
+			break
+		}
+		factor *= 2
+	}
+	return interval * time.Duration(factor)
+}
+
+func (d *Daemon) syncRemote(ctx context.Context, remote daemonRemote) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if remote.config.Mode != ModePush {
+		pulled, quarantined := 0, 0
+		err := rt.PullSegments(ctx, d.db, remote.config.Name, remote.transport, func(key string, r io.Reader) error {
+			pulled++
+			err := d.inTx(ctx, func(tx rt.DBTX, bundle rt.Bundle) error {
+				if err := bundle.ReadJSONL(remote.config.Name, r); err != nil || key == "" {
+					return err
+				}
+				return rt.SetRemoteSegment(tx, remote.config.Name, key)
+			})
+			if errors.Is(err, rt.ErrJSONLSignature) {
+				// Retrying cannot fix the signature: skip the segment, leaving
+				// it on the remote for inspection.
+				quarantined++
+				d.logger.Warn("quarantined segment", "remote", remote.config.Name, "segment", key, "error", err)
+				if key == "" {
+					return nil
+				}
+				return rt.SetRemoteSegment(d.db, remote.config.Name, key)
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("pull: %w", err)
+		}
+		d.logger.Info("pulled", "remote", remote.config.Name, "segments", pulled, "quarantined", quarantined)
+	}
+	if remote.config.Mode != ModePull {
+		var records int
+		err := d.inTx(ctx, func(_ rt.DBTX, bundle rt.Bundle) error {
+			var segment bytes.Buffer
+			if err := bundle.WriteJSONL(remote.config.Name, &segment); err != nil {
+				return err
+			}
+			if segment.Len() == 0 {
+				return nil
+			}
+			records = bytes.Count(segment.Bytes(), []byte{'\n'})
+			return remote.transport.Push(ctx, segment.Bytes())
+		})
+		if err != nil {
+			return fmt.Errorf("push: %w", err)
+		}
+		d.logger.Info("pushed", "remote", remote.config.Name, "records", records)
+	}
+	return nil
+}
+
+// inTx runs apply in a transaction, so _sync bookkeeping is only committed
+// when the transport succeeded.
+func (d *Daemon) inTx(ctx context.Context, apply func(tx rt.DBTX, bundle rt.Bundle) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if err := apply(tx, d.newBundle(tx)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (additionally, rollback: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Config describes how the daemon opens databases and which generated code
+// it syncs. NewBundle is optional; without it the daemon uses the single
+// package registered with rt.RegisterTables.
+type Config struct {
+	DriverName string
+	NewBundle  func(q rt.DBTX) rt.Bundle
+}
+
+type remoteFlags []RemoteConfig
+
+func (f *remoteFlags) String() string {
+	names := make([]string, 0, len(*f))
+	for _, remote := range *f {
+		names = append(names, remote.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *remoteFlags) Set(value string) error {
+	remote, err := ParseRemote(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, remote)
+	return nil
+}
+
+// Run parses args (excluding the program name) and syncs until ctx is done.
+func Run(ctx context.Context, cfg Config, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("proprdb-syncd", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dbPath := flags.String("db", "", "path to the SQLite database file")
+	hostname, _ := os.Hostname()
+	device := flags.String("device", hostname, "device id of this replica")
+	once := flags.Bool("once", false, "run a single sync pass and exit")
+	var remotes remoteFlags
+	flags.Var(&remotes, "remote", "remote specification, e.g. "+remoteSpecExample+" (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dbPath == "" {
+		return errors.New("missing -db")
+	}
+	if len(remotes) == 0 {
+		return errors.New("missing -remote")
+	}
+	if cfg.DriverName == "" {
+		return errors.New("empty driver name")
+	}
+	newBundle, err := bundleFactory(cfg)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open(cfg.DriverName, *dbPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", *dbPath, err)
+	}
+	logger := slog.New(slog.NewJSONHandler(stderr, nil))
+	runErr := func() error {
+		daemon, err := NewDaemon(db, newBundle, *device, remotes, logger)
+		if err != nil {
+			return err
+		}
+		if *once {
+			return daemon.SyncOnce(ctx)
+		}
+		logger.Info("started", "db", *dbPath, "device", *device, "remotes", remotes.String())
+		if err := daemon.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	}()
+	if closeErr := db.Close(); closeErr != nil {
+		if runErr != nil {
+			return fmt.Errorf("%w (additionally, close database: %v)", runErr, closeErr)
+		}
+		return fmt.Errorf("close database: %w", closeErr)
+	}
+	return runErr
+}
+
+func bundleFactory(cfg Config) (func(q rt.DBTX) rt.Bundle, error) {
+	if cfg.NewBundle != nil {
+		return cfg.NewBundle, nil
+	}
+	registered := rt.RegisteredBundles()
+	switch len(registered) {
+	case 0:
+		return nil, errors.New("no generated tables available; build a binary that imports generated packages")
+	case 1:
+		return func(q rt.DBTX) rt.Bundle {
+			return registered[0].Factory(q, rt.Options{})
+		}, nil
+	default:
+		return nil, fmt.Errorf("%d generated packages registered; pass Config.NewBundle to choose", len(registered))
+	}
+}
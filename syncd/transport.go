@@ -0,0 +1,264 @@
+package proprdbsyncd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+)
+
+// RemoteTransport moves JSONL segments between the daemon and one remote.
+// Transports also serve rt.SyncSession.
+type RemoteTransport = rt.RemoteTransport
+
+// TransportFactory creates a transport for a remote URL. device identifies
+// the local replica, so transports can skip segments they pushed themselves.
+type TransportFactory func(location *url.URL, device string) (RemoteTransport, error)
+
+var (
+	transportsMu sync.Mutex
+	transports   = map[string]TransportFactory{
+		"file":  newDirTransport,
+		"http":  newHTTPTransport,
+		"https": newHTTPTransport,
+		"s3":    newS3Transport,
+	}
+)
+
+// RegisterTransport makes a transport available for URLs with scheme.
+func RegisterTransport(scheme string, factory TransportFactory) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[strings.ToLower(scheme)] = factory
+}
+
+// OpenTransport creates the transport for location. Plain paths use the
+// directory transport. device must not contain "/", as it is part of the
+// segment names.
+func OpenTransport(location, device string) (RemoteTransport, error) {
+	if strings.Contains(device, "/") {
+		return nil, fmt.Errorf("device id %q contains /", device)
+	}
+	parsed, err := url.Parse(location)
+	if err != nil || parsed.Scheme == "" || len(parsed.Scheme) == 1 {
+		parsed = &url.URL{Scheme: "file", Path: location}
+	}
+	transportsMu.Lock()
+	factory, ok := transports[strings.ToLower(parsed.Scheme)]
+	transportsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported remote scheme %q", parsed.Scheme)
+	}
+	return factory(parsed, device)
+}
+
+// dirTransport stores each pushed segment as a file in a shared directory.
+type dirTransport struct {
+	dir    string
+	device string
+	seen   map[string]bool
+}
+
+const segmentSuffix = ".jsonl"
+
+// segmentName names a new segment pushed by device. Names sort by push time.
+func segmentName(device string) string {
+	return strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + device + segmentSuffix
+}
+
+// segmentDevice returns the device that pushed the segment named name, and
+// false when name is not a <unixnano>-<device>.jsonl segment name.
+func segmentDevice(name string) (string, bool) {
+	stem, ok := strings.CutSuffix(name, segmentSuffix)
+	if !ok {
+		return "", false
+	}
+	pushedAtNs, device, ok := strings.Cut(stem, "-")
+	if !ok || device == "" || strings.Contains(device, "/") {
+		return "", false
+	}
+	if _, err := strconv.ParseInt(pushedAtNs, 10, 64); err != nil {
+		return "", false
+	}
+	return device, true
+}
+
+// isForeignSegment reports whether name is a segment pushed by another device.
+func isForeignSegment(name, device string) bool {
+	pushedBy, ok := segmentDevice(name)
+	return ok && pushedBy != device
+}
+
+// segmentPullGrace is how long before the last applied segment pulls list
+// segments again. Segments are named when their push starts, so the segment
+// of a slow push can appear after segments named later.
+const segmentPullGrace = time.Minute
+
+// segmentListStart returns the name after which pulls list segments, given
+// the name of the last applied segment: segmentPullGrace before its push
+// time, or "" without one.
+func segmentListStart(after string) string {
+	pushedAtNs, _, _ := strings.Cut(after, "-")
+	ns, err := strconv.ParseInt(pushedAtNs, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(max(ns-int64(segmentPullGrace), 0), 10)
+}
+
+// isNewSegment reports whether a segment named name is pulled after the
+// segment named after, which was applied before, given start, the
+// segmentListStart of after, and the segments seen by this transport.
+func isNewSegment(name, after, start string, seen map[string]bool) bool {
+	return name > start && name != after && !seen[name]
+}
+
+// forgetSegments drops the seen segments before start, which pulls no
+// longer list.
+func forgetSegments(seen map[string]bool, start string) {
+	for name := range seen {
+		if name <= start {
+			delete(seen, name)
+		}
+	}
+}
+
+func newDirTransport(location *url.URL, device string) (RemoteTransport, error) {
+	dir := location.Path
+	if location.Host != "" {
+		dir = location.Host + dir
+	}
+	if dir == "" {
+		return nil, errors.New("empty directory path")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create remote directory %s: %w", dir, err)
+	}
+	return &dirTransport{dir: dir, device: device, seen: make(map[string]bool)}, nil
+}
+
+func (t *dirTransport) Push(_ context.Context, segment []byte) error {
+	name := segmentName(t.device)
+	temporaryPath := filepath.Join(t.dir, "."+name+".tmp")
+	if err := os.WriteFile(temporaryPath, segment, 0o644); err != nil {
+		return fmt.Errorf("write segment %s: %w", temporaryPath, err)
+	}
+	if err := os.Rename(temporaryPath, filepath.Join(t.dir, name)); err != nil {
+		return fmt.Errorf("publish segment %s: %w", name, err)
+	}
+	t.seen[name] = true
+	return nil
+}
+
+func (t *dirTransport) Pull(ctx context.Context, visit func(io.Reader) error) error {
+	return t.PullAfter(ctx, "", func(_ string, r io.Reader) error {
+		return visit(r)
+	})
+}
+
+// PullAfter visits the segments of other devices named after after, less
+// segmentPullGrace, that this transport did not see yet. Their keys are the
+// file names.
+func (t *dirTransport) PullAfter(ctx context.Context, after string, visit func(key string, r io.Reader) error) error {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return fmt.Errorf("list remote directory %s: %w", t.dir, err)
+	}
+	start := segmentListStart(after)
+	forgetSegments(t.seen, start)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !isNewSegment(name, after, start, t.seen) || !isForeignSegment(name, t.device) {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Join(t.dir, name))
+		if err != nil {
+			return fmt.Errorf("open segment %s: %w", name, err)
+		}
+		visitErr := visit(name, file)
+		if closeErr := file.Close(); closeErr != nil && visitErr == nil {
+			visitErr = fmt.Errorf("close segment %s: %w", name, closeErr)
+		}
+		if visitErr != nil {
+			return fmt.Errorf("segment %s: %w", name, visitErr)
+		}
+		t.seen[name] = true
+	}
+	return nil
+}
+
+// httpTransport POSTs segments to a URL and GETs the JSONL to apply from it.
+type httpTransport struct {
+	location string
+	device   string
+	client   *http.Client
+}
+
+// DeviceHeader carries the device id in HTTP transport requests.
+const DeviceHeader = "X-Proprdb-Device"
+
+func newHTTPTransport(location *url.URL, device string) (RemoteTransport, error) {
+	return &httpTransport{location: location.String(), device: device, client: http.DefaultClient}, nil
+}
+
+func (t *httpTransport) Push(ctx context.Context, segment []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.location, bytes.NewReader(segment))
+	if err != nil {
+		return fmt.Errorf("build push request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	request.Header.Set(DeviceHeader, t.device)
+	response, err := t.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("push to %s: %w", t.location, err)
+	}
+	return closeResponse(response, "push", nil)
+}
+
+func (t *httpTransport) Pull(ctx context.Context, visit func(io.Reader) error) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, t.location, nil)
+	if err != nil {
+		return fmt.Errorf("build pull request: %w", err)
+	}
+	request.Header.Set("Accept", "application/x-ndjson")
+	request.Header.Set(DeviceHeader, t.device)
+	response, err := t.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("pull from %s: %w", t.location, err)
+	}
+	return closeResponse(response, "pull", visit)
+}
+
+func closeResponse(response *http.Response, operation string, visit func(io.Reader) error) error {
+	var resultErr error
+	switch {
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		resultErr = fmt.Errorf("%s: unexpected status %s", operation, response.Status)
+	case visit != nil:
+		resultErr = visit(response.Body)
+	}
+	if closeErr := response.Body.Close(); closeErr != nil && resultErr == nil {
+		resultErr = fmt.Errorf("%s: close response body: %w", operation, closeErr)
+	}
+	return resultErr
+}
@@ -43,18 +43,370 @@ func TestProtocPluginGolden(t *testing.T) {
 		"-I", protoDir,
 		"-I", repoRoot,
 		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative,http=true,sql=true,memdb=true,mock=true,openapi=true,graphql=true,lock="+generatedDir+":"+generatedDir,
+		protoFile,
+	)
+
+	for _, name := range []string{"system.proprdb.pb.go", "system.proprdb_http.pb.go", "system.proprdb.sql", "system.proprdb_memdb.pb.go", "system.proprdb_mock.pb.go", "system.proprdb.openapi.json", "system.proprdb_graphql.pb.go", "system.proprdb.graphql", "system.proprdb.lock.json"} {
+		content, err := os.ReadFile(filepath.Join(generatedDir, name))
+		assert.NilError(t, err)
+		golden.Assert(t, string(content), name+".golden", golden.FlagUpdate())
+	}
+}
+
+func TestProtocPluginRejectsNonExternalIndexField(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  option (com.github.fingon.proprdb.indexes) = {fields: "name"};
+  string name = 1;
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+	assert.NilError(t, err)
+
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative:"+generatedDir,
+		badProtoPath,
+	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "must be marked (com.github.fingon.proprdb.external)=true"))
+}
+
+func TestProtocPluginRejectsEmptyIndex(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  option (com.github.fingon.proprdb.indexes) = {};
+  string name = 1 [(com.github.fingon.proprdb.external) = true];
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+	assert.NilError(t, err)
+
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative:"+generatedDir,
+		badProtoPath,
+	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "must include at least one field"))
+}
+
+func TestProtocPluginRejectsNocaseOnNonStringIndexField(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  option (com.github.fingon.proprdb.indexes) = {
+    columns: {field: "age" collation: COLLATION_NOCASE}
+  };
+  int64 age = 1 [(com.github.fingon.proprdb.external) = true];
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+	assert.NilError(t, err)
+
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative:"+generatedDir,
+		badProtoPath,
+	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "NOCASE collation requires an unencrypted string column"))
+}
+
+func TestProtocPluginRejectsIndexExpressionOnData(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  option (com.github.fingon.proprdb.indexes) = {
+    columns: {expression: "length(data)"}
+  };
+  int64 age = 1 [(com.github.fingon.proprdb.external) = true];
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+	assert.NilError(t, err)
+
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative:"+generatedDir,
+		badProtoPath,
+	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "data holds the serialized protobuf"))
+}
+
+func TestProtocPluginRejectsEncryptedNonExternalField(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  string name = 1 [(com.github.fingon.proprdb.encrypted) = true];
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+	assert.NilError(t, err)
+
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative:"+generatedDir,
+		badProtoPath,
+	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "encrypted field must be marked (com.github.fingon.proprdb.external)=true"))
+}
+
+func TestProtocPluginRejectsMergeOnUnsupportedField(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  string name = 1 [(com.github.fingon.proprdb.merge) = MERGE_MAX];
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+	assert.NilError(t, err)
+
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative:"+generatedDir,
+		badProtoPath,
+	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "max merge requires a singular numeric or bool field"))
+}
+
+func TestProtocPluginRejectsSearchOnNonStringField(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  int64 age = 1 [(com.github.fingon.proprdb.search) = true];
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+	assert.NilError(t, err)
+
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
 		"--proprdb_out=paths=source_relative:"+generatedDir,
-		protoFile,
+		badProtoPath,
 	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "searchable field must be a string"))
+}
+
+func TestProtocPluginRejectsScalarVectorField(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
 
-	generatedFile := filepath.Join(generatedDir, "system.proprdb.pb.go")
-	content, err := os.ReadFile(generatedFile)
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  float score = 1 [(com.github.fingon.proprdb.vector) = true];
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
 	assert.NilError(t, err)
 
-	golden.Assert(t, string(content), "system.proprdb.pb.go.golden", golden.FlagUpdate())
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative:"+generatedDir,
+		badProtoPath,
+	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "vector field must be repeated float or double"))
 }
 
-func TestProtocPluginRejectsNonExternalIndexField(t *testing.T) {
+func TestProtocPluginRejectsNonExternalGeoField(t *testing.T) {
 	t.Helper()
 
 	if _, err := exec.LookPath("protoc"); err != nil {
@@ -81,8 +433,9 @@ package generatedtest.bad;
 import "proto/proprdb/options.proto";
 option go_package = "generatedtest/bad;bad";
 message Person {
-  option (com.github.fingon.proprdb.indexes) = {fields: "name"};
-  string name = 1;
+  option (com.github.fingon.proprdb.geo) = {latitude: "lat" longitude: "lng"};
+  double lat = 1 [(com.github.fingon.proprdb.external) = true];
+  double lng = 2;
 }`
 	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
 	assert.NilError(t, err)
@@ -95,10 +448,10 @@ message Person {
 		badProtoPath,
 	)
 	assert.Check(t, runErr != nil)
-	assert.Check(t, strings.Contains(output, "must be marked (com.github.fingon.proprdb.external)=true"))
+	assert.Check(t, strings.Contains(output, "\"lng\" must be an unencrypted external float or double field or external path"))
 }
 
-func TestProtocPluginRejectsEmptyIndex(t *testing.T) {
+func TestProtocPluginRejectsUnsupportedExternalMap(t *testing.T) {
 	t.Helper()
 
 	if _, err := exec.LookPath("protoc"); err != nil {
@@ -125,8 +478,7 @@ package generatedtest.bad;
 import "proto/proprdb/options.proto";
 option go_package = "generatedtest/bad;bad";
 message Person {
-  option (com.github.fingon.proprdb.indexes) = {};
-  string name = 1 [(com.github.fingon.proprdb.external) = true];
+  map<string, double> scores = 1 [(com.github.fingon.proprdb.external) = true];
 }`
 	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
 	assert.NilError(t, err)
@@ -139,7 +491,7 @@ message Person {
 		badProtoPath,
 	)
 	assert.Check(t, runErr != nil)
-	assert.Check(t, strings.Contains(output, "must include at least one field"))
+	assert.Check(t, strings.Contains(output, "external map field value must be string or int64"))
 }
 
 func TestProtocPluginSupportsProto3OptionalExternal(t *testing.T) {
@@ -218,3 +570,479 @@ func runCommandCapture(workDir string, extraEnv []string, name string, args ...s
 	output, err := command.CombinedOutput()
 	return string(output), err
 }
+
+func TestProtocPluginRejectsInvalidCustomNames(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	for _, testCase := range []struct {
+		messages string
+		want     string
+	}{
+		{
+			messages: `message Person {
+  string name = 1 [(com.github.fingon.proprdb.external) = true, (com.github.fingon.proprdb.column_name) = "at_ns"];
+}`,
+			want: `column name "at_ns" is reserved`,
+		},
+		{
+			messages: `message Person {
+  string name = 1 [(com.github.fingon.proprdb.external) = true, (com.github.fingon.proprdb.column_name) = "full name"];
+}`,
+			want: `must match [A-Za-z_][A-Za-z0-9_]*`,
+		},
+		{
+			messages: `message Person {
+  string name = 1 [(com.github.fingon.proprdb.column_name) = "label"];
+}`,
+			want: "column_name requires (com.github.fingon.proprdb.external)=true",
+		},
+		{
+			messages: `message Person {
+  string name = 1 [(com.github.fingon.proprdb.external) = true];
+  string nick = 2 [(com.github.fingon.proprdb.external) = true, (com.github.fingon.proprdb.column_name) = "name"];
+}`,
+			want: `column "name" is already used by another field`,
+		},
+		{
+			messages: `message Person {
+  option (com.github.fingon.proprdb.table_name) = "_people";
+}`,
+			want: "must not start with an underscore",
+		},
+		{
+			messages: `message Person {
+  option (com.github.fingon.proprdb.table_name) = "people";
+}
+message Human {
+  option (com.github.fingon.proprdb.table_name) = "people";
+}`,
+			want: `both use table "people"`,
+		},
+		{
+			messages: `message Person {
+  string name = 1 [(com.github.fingon.proprdb.renamed_from) = "nick"];
+  string nick = 2;
+}`,
+			want: `renamed_from "nick" is still a field of the message`,
+		},
+		{
+			messages: `message Person {
+  option (com.github.fingon.proprdb.migration) = {field: "age" copy_from: "name"};
+  string name = 1;
+  int64 age = 2;
+}`,
+			want: "cannot copy name to age of another type",
+		},
+		{
+			messages: `message Person {
+  option (com.github.fingon.proprdb.migration) = {field: "age" default_value: "true"};
+  int64 age = 1;
+}`,
+			want: "default value of age",
+		},
+	} {
+		badProtoPath := filepath.Join(tempDir, "bad.proto")
+		badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+` + testCase.messages
+		err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+		assert.NilError(t, err)
+
+		output, runErr := runCommandCapture(tempDir, nil, "protoc",
+			"-I", tempDir,
+			"-I", repoRoot,
+			"--plugin=protoc-gen-proprdb="+pluginPath,
+			"--proprdb_out=paths=source_relative:"+generatedDir,
+			badProtoPath,
+		)
+		assert.Check(t, runErr != nil, testCase.want)
+		assert.Check(t, strings.Contains(output, testCase.want), output)
+	}
+}
+
+func TestProtocPluginSchemaLock(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	generate := func(messages string) (string, error) {
+		protoPath := filepath.Join(tempDir, "locked.proto")
+		err := os.WriteFile(protoPath, []byte(`syntax = "proto3";
+package generatedtest.locked;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/locked;locked";
+`+messages), 0o644)
+		assert.NilError(t, err)
+		return runCommandCapture(tempDir, nil, "protoc",
+			"-I", tempDir,
+			"-I", repoRoot,
+			"--plugin=protoc-gen-proprdb="+pluginPath,
+			"--proprdb_out=paths=source_relative,lock="+generatedDir+":"+generatedDir,
+			protoPath,
+		)
+	}
+	_, err = generate(`message Person {
+  string name = 1 [(com.github.fingon.proprdb.external) = true];
+  string nick = 2 [(com.github.fingon.proprdb.external) = true];
+  map<string, string> labels = 3 [(com.github.fingon.proprdb.external) = true];
+}`)
+	assert.NilError(t, err)
+	lock, err := os.ReadFile(filepath.Join(generatedDir, "locked.proprdb.lock.json"))
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(string(lock), `"field": "nick"`), string(lock))
+	assert.Check(t, strings.Contains(string(lock), `"column": "generatedtest_locked_person__labels"`), string(lock))
+
+	output, err := generate(`message Person {
+  string name = 1 [(com.github.fingon.proprdb.external) = true];
+  string nick = 2;
+  map<string, string> labels = 3 [(com.github.fingon.proprdb.external) = true];
+}`)
+	assert.Check(t, err != nil)
+	assert.Check(t, strings.Contains(output, `no longer projects "nick"`), output)
+	assert.Check(t, strings.Contains(output, `{field: "nick" drop_projection: true}`), output)
+
+	output, err = generate(`message Person {
+  option (com.github.fingon.proprdb.migration) = {field: "nick" drop_projection: true};
+  string name = 1 [(com.github.fingon.proprdb.external) = true];
+  string nick = 2 [(com.github.fingon.proprdb.external) = true];
+  map<string, string> labels = 3 [(com.github.fingon.proprdb.external) = true];
+}`)
+	assert.Check(t, err != nil)
+	assert.Check(t, strings.Contains(output, `drops the projection of "nick", which is still projected`), output)
+
+	_, err = generate(`message Person {
+  option (com.github.fingon.proprdb.migration) = {field: "nick" drop_projection: true};
+  string full_name = 1 [(com.github.fingon.proprdb.external) = true, (com.github.fingon.proprdb.renamed_from) = "name"];
+  map<string, string> labels = 3 [(com.github.fingon.proprdb.external) = true];
+}`)
+	assert.NilError(t, err, "renamed and dropped projections pass")
+	lock, err = os.ReadFile(filepath.Join(generatedDir, "locked.proprdb.lock.json"))
+	assert.NilError(t, err)
+	assert.Check(t, !strings.Contains(string(lock), `"field": "nick"`), "the lock follows the schema")
+	assert.Check(t, strings.Contains(string(lock), `"field": "full_name"`), string(lock))
+
+	output, err = generate(`message Person {
+  option (com.github.fingon.proprdb.skip) = false;
+}`)
+	assert.Check(t, err != nil)
+	assert.Check(t, strings.Contains(output, `no longer projects "full_name"`), output)
+}
+
+func TestProtocPluginProtoValidate(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	// The part of buf/validate/validate.proto the messages use.
+	err := os.MkdirAll(filepath.Join(tempDir, "buf", "validate"), 0o755)
+	assert.NilError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, "buf", "validate", "validate.proto"), []byte(`syntax = "proto2";
+package buf.validate;
+option go_package = "buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate";
+import "google/protobuf/descriptor.proto";
+message StringRules { optional uint64 min_len = 2; }
+message FieldRules { optional StringRules string = 14; }
+message MessageRules { optional bool disabled = 1; }
+extend google.protobuf.FieldOptions { optional FieldRules field = 1159; }
+extend google.protobuf.MessageOptions { optional MessageRules message = 1159; }
+`), 0o644)
+	assert.NilError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, "validated.proto"), []byte(`syntax = "proto3";
+package generatedtest.validated;
+import "buf/validate/validate.proto";
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/validated;validated";
+message Person {
+  string name = 1 [(buf.validate.field).string.min_len = 1];
+}
+message Address {
+  string city = 1 [(buf.validate.field).string.min_len = 1];
+}
+message Company {
+  map<string, Address> offices = 1;
+}
+message Note {
+  string text = 1;
+}
+`), 0o644)
+	assert.NilError(t, err)
+
+	generate := func(parameters string) string {
+		generatedDir := t.TempDir()
+		runCommand(t, tempDir, nil, "protoc",
+			"-I", tempDir,
+			"-I", repoRoot,
+			"--plugin=protoc-gen-proprdb="+pluginPath,
+			"--proprdb_out="+parameters+"paths=source_relative,memdb=true:"+generatedDir,
+			filepath.Join(tempDir, "validated.proto"),
+		)
+		generated, err := os.ReadFile(filepath.Join(generatedDir, "validated.proprdb.pb.go"))
+		assert.NilError(t, err)
+		memdb, err := os.ReadFile(filepath.Join(generatedDir, "validated.proprdb_memdb.pb.go"))
+		assert.NilError(t, err)
+		return string(generated) + string(memdb)
+	}
+	// validateCalls counts the writes of typeName checked with protovalidate.
+	validateCalls := func(generated, typeName string) int {
+		return strings.Count(generated, "if err := rt.ProtoValidate(t.opts, data); err != nil {\n\t\treturn "+typeName+"Row{}")
+	}
+
+	generated := generate("")
+	assert.Check(t, !strings.Contains(generated, "rt.ProtoValidate("), "protovalidate is opt-in")
+
+	generated = generate("protovalidate=true,")
+	assert.Check(t, strings.Contains(generated, "options: memdb=true,protovalidate=true"))
+	assert.Check(t, validateCalls(generated, "Person") > 0, generated)
+	assert.Check(t, validateCalls(generated, "Company") > 0, "constraints of held messages count")
+	assert.Check(t, validateCalls(generated, "Note") == 0, "messages without constraints are not checked")
+	assert.Check(t, strings.Contains(generated, "if err := rt.ProtoValidate(c.opts, data); err != nil {"), "imports are checked")
+	assert.Check(t, strings.Contains(generated, "return rt.ProtoValidate(opts, data)"), "memdb tables are checked")
+}
+
+func TestProtocPluginMessageSelection(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	generate := func(t *testing.T, protoContent, parameters string) (string, error) {
+		t.Helper()
+		generatedDir := t.TempDir()
+		protoPath := filepath.Join(tempDir, "selection.proto")
+		assert.NilError(t, os.WriteFile(protoPath, []byte(`syntax = "proto3";
+package generatedtest.selection;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/selection;selection";
+`+protoContent), 0o644))
+		output, err := runCommandCapture(tempDir, nil, "protoc",
+			"-I", tempDir,
+			"-I", repoRoot,
+			"--plugin=protoc-gen-proprdb="+pluginPath,
+			"--proprdb_out="+parameters+"paths=source_relative:"+generatedDir,
+			protoPath,
+		)
+		if err != nil {
+			return output, err
+		}
+		generated, readErr := os.ReadFile(filepath.Join(generatedDir, "selection.proprdb.pb.go"))
+		if os.IsNotExist(readErr) {
+			return "", nil
+		}
+		assert.NilError(t, readErr)
+		return string(generated), nil
+	}
+	messages := `message Person {
+  string name = 1;
+}
+message Note {
+  option (com.github.fingon.proprdb.skip) = false;
+  string text = 1;
+}
+message Request {
+  option (com.github.fingon.proprdb.skip) = true;
+  string path = 1;
+}
+`
+
+	generated, err := generate(t, messages, "")
+	assert.NilError(t, err, generated)
+	assert.Check(t, strings.Contains(generated, "const PersonTableName"))
+	assert.Check(t, strings.Contains(generated, "const NoteTableName"))
+	assert.Check(t, !strings.Contains(generated, "const RequestTableName"), "skip excludes")
+
+	generated, err = generate(t, "option (com.github.fingon.proprdb.default_generate) = false;\n"+messages, "")
+	assert.NilError(t, err, generated)
+	assert.Check(t, !strings.Contains(generated, "const PersonTableName"), "default_generate=false excludes unmarked messages")
+	assert.Check(t, strings.Contains(generated, "const NoteTableName"), "skip=false opts in")
+	assert.Check(t, !strings.Contains(generated, "const RequestTableName"))
+
+	generated, err = generate(t, "option (com.github.fingon.proprdb.type_url_prefix) = \"types.example.com/\";\n"+messages, "")
+	assert.NilError(t, err, generated)
+	assert.Check(t, strings.Contains(generated, `return "types.example.com/"`), "the file option is the default prefix")
+	generated, err = generate(t, "option (com.github.fingon.proprdb.type_url_prefix) = \"types example\";\n"+messages, "")
+	assert.Check(t, err != nil)
+	assert.Check(t, strings.Contains(generated, "must be non-empty without spaces"), generated)
+
+	generated, err = generate(t, messages, "only=Person,generatedtest.selection.Request,")
+	assert.NilError(t, err, generated)
+	assert.Check(t, strings.Contains(generated, "const PersonTableName"))
+	assert.Check(t, !strings.Contains(generated, "const NoteTableName"), "only excludes unlisted messages")
+	assert.Check(t, !strings.Contains(generated, "const RequestTableName"), "skip wins over only")
+
+	generated, err = generate(t, messages, "only=Request,")
+	assert.NilError(t, err, generated)
+	assert.Check(t, generated == "", "no file without tables")
+
+	output, err := generate(t, messages, "only=Persons,")
+	assert.Check(t, err != nil)
+	assert.Check(t, strings.Contains(output, "only=Persons matches no message"), output)
+}
+
+func TestProtocPluginDeterministicOrder(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	generate := func(t *testing.T, messages []string, parameters string) string {
+		t.Helper()
+		generatedDir := t.TempDir()
+		protoPath := filepath.Join(tempDir, "ordered.proto")
+		assert.NilError(t, os.WriteFile(protoPath, []byte(`syntax = "proto3";
+package generatedtest.ordered;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/ordered;ordered";
+`+strings.Join(messages, "\n")), 0o644))
+		runCommand(t, tempDir, nil, "protoc",
+			"-I", tempDir,
+			"-I", repoRoot,
+			"--plugin=protoc-gen-proprdb="+pluginPath,
+			"--proprdb_out="+parameters+"paths=source_relative:"+generatedDir,
+			protoPath,
+		)
+		generated, err := os.ReadFile(filepath.Join(generatedDir, "ordered.proprdb.sql"))
+		assert.NilError(t, err)
+		return string(generated)
+	}
+	alpha := "message Alpha {\n  string name = 1 [(com.github.fingon.proprdb.external) = true];\n}\n"
+	beta := "message Beta {\n  string name = 1;\n}\n"
+
+	declared := generate(t, []string{beta, alpha}, "sql=true,")
+	assert.Check(t, strings.Contains(declared, "-- versions:"))
+	assert.Check(t, strings.Contains(declared, "-- \tprotoc-gen-proprdb v"))
+	assert.Check(t, strings.Contains(declared, "-- options: sql=true (fingerprint "))
+	assert.Check(t, strings.Index(declared, "generatedtest_ordered_beta") < strings.Index(declared, "generatedtest_ordered_alpha"))
+
+	sorted := generate(t, []string{beta, alpha}, "deterministic=true,sql=true,")
+	assert.Check(t, strings.Index(sorted, "generatedtest_ordered_alpha") < strings.Index(sorted, "generatedtest_ordered_beta"))
+	assert.Check(t, strings.Contains(sorted, "-- options: deterministic=true,sql=true (fingerprint "))
+	assert.Check(t, sorted == generate(t, []string{alpha, beta}, "sql=true,deterministic=true,"), "order of messages and parameters does not matter")
+}
+
+func TestProtocPluginRejectsStringBlobExternalField(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skipf("protoc not available: %v", err)
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	pluginPath := filepath.Join(tempDir, "protoc-gen-proprdb")
+	generatedDir := filepath.Join(tempDir, "gen")
+	err := os.MkdirAll(generatedDir, 0o755)
+	assert.NilError(t, err)
+
+	runCommand(t, repoRoot, nil, "go", "build", "-o", pluginPath, "./cmd/protoc-gen-proprdb")
+
+	badProtoPath := filepath.Join(tempDir, "bad.proto")
+	badProto := `syntax = "proto3";
+package generatedtest.bad;
+import "proto/proprdb/options.proto";
+option go_package = "generatedtest/bad;bad";
+message Person {
+  string photo = 1 [(com.github.fingon.proprdb.blob_external) = true];
+}`
+	err = os.WriteFile(badProtoPath, []byte(badProto), 0o644)
+	assert.NilError(t, err)
+
+	output, runErr := runCommandCapture(tempDir, nil, "protoc",
+		"-I", tempDir,
+		"-I", repoRoot,
+		"--plugin=protoc-gen-proprdb="+pluginPath,
+		"--proprdb_out=paths=source_relative:"+generatedDir,
+		badProtoPath,
+	)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "blob_external field must be a singular bytes field"))
+}
+
+func TestStockCLIExportImport(t *testing.T) {
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("determine current file path")
+	}
+	repoRoot := filepath.Dir(filepath.Dir(currentFile))
+
+	tempDir := t.TempDir()
+	cliPath := filepath.Join(tempDir, "proprdb")
+	runCommand(t, repoRoot, nil, "go", "build", "-o", cliPath, "./cmd/proprdb")
+	dbPath := filepath.Join(tempDir, "stock.db")
+
+	output, runErr := runCommandCapture(tempDir, nil, cliPath, "-db", dbPath)
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "  export "), output)
+	assert.Check(t, strings.Contains(output, "  import "), output)
+
+	// Without generated packages, the tables come from the database and
+	// their types from descriptors.
+	output, _ = runCommandCapture(tempDir, nil, cliPath, "-db", dbPath, "export", "-h")
+	assert.Check(t, strings.Contains(output, "-descriptors"), output)
+	output, runErr = runCommandCapture(tempDir, nil, cliPath, "-db", dbPath, "import", "-descriptors", filepath.Join(tempDir, "missing.binpb"))
+	assert.Check(t, runErr != nil)
+	assert.Check(t, strings.Contains(output, "read descriptors"), output)
+}
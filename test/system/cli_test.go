@@ -0,0 +1,137 @@
+package genexample
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	proprdbcli "github.com/fingon/proprdb/cli"
+	rt "github.com/fingon/proprdb/rt"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/proto"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestCLICommands(t *testing.T) {
+	tempDir := t.TempDir()
+	sourcePath := filepath.Join(tempDir, "source.db")
+	targetPath := filepath.Join(tempDir, "target.db")
+	exportPath := filepath.Join(tempDir, "export.jsonl")
+	cfg := proprdbcli.Config{
+		DriverName: "sqlite3",
+		NewBundle: func(q rt.DBTX) rt.Bundle {
+			return NewCRUD(q)
+		},
+	}
+	runCLI := func(cfg proprdbcli.Config, args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		err := proprdbcli.Run(cfg, args, &stdout, &stderr)
+		return stdout.String(), err
+	}
+
+	_, err := runCLI(cfg, "-db", sourcePath, "query", `INSERT INTO _deleted (table_name, id, at_ns) VALUES ('old', 'x', 1)`)
+	assert.ErrorContains(t, err, "no such table")
+
+	source := NewCRUD(openCLITestDB(t, sourcePath))
+	assert.NilError(t, source.Init())
+	_, err = source.Person.Insert(&Person{Name: "Cli", Age: 3})
+	assert.NilError(t, err)
+
+	inspectOutput, err := runCLI(cfg, "-db", sourcePath, "inspect")
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(inspectOutput, PersonTableName))
+	assert.Check(t, strings.Contains(inspectOutput, PersonTypeName))
+
+	registryInspectOutput, err := runCLI(proprdbcli.Config{DriverName: "sqlite3"}, "-db", sourcePath, "inspect")
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(registryInspectOutput, PersonTypeName))
+	assert.Check(t, strings.Contains(registryInspectOutput, rt.CoreTableSyncName))
+
+	registryOutput, err := runCLI(proprdbcli.Config{DriverName: "sqlite3"}, "-db", sourcePath, "export")
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(registryOutput, `"name":"Cli"`))
+
+	_, err = runCLI(cfg, "-db", sourcePath, "export", "-remote", "peer", "-o", exportPath)
+	assert.NilError(t, err)
+	exported, err := os.ReadFile(exportPath)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(string(exported), `"name":"Cli"`))
+
+	remotesOutput, err := runCLI(cfg, "-db", sourcePath, "remotes")
+	assert.NilError(t, err)
+	assert.Check(t, is.Contains(remotesOutput, "peer"))
+	assert.Check(t, is.Contains(remotesOutput, PersonTableName))
+	_, err = runCLI(cfg, "-db", sourcePath, "remotes", "-rename", "peer", "-to", "old-peer")
+	assert.NilError(t, err)
+	forgetOutput, err := runCLI(cfg, "-db", sourcePath, "remotes", "-forget", "old-peer")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(strings.TrimSpace(forgetOutput), "forgot 1 sync rows"))
+
+	_, err = runCLI(cfg, "-db", targetPath, "import", "-remote", "peer", "-i", exportPath)
+	assert.NilError(t, err)
+	queryOutput, err := runCLI(cfg, "-db", targetPath, "query", `SELECT name, age FROM "`+PersonTableName+`" WHERE name = ?`, "Cli")
+	assert.NilError(t, err)
+	queryLines := strings.Split(strings.TrimSpace(queryOutput), "\n")
+	assert.Check(t, is.Len(queryLines, 2))
+	assert.Check(t, is.DeepEqual(strings.Fields(queryLines[1]), []string{"Cli", "3"}))
+
+	_, err = runCLI(cfg, "-db", targetPath, "query", `INSERT INTO _deleted (table_name, id, at_ns) VALUES ('old', 'x', 1)`)
+	assert.NilError(t, err)
+	compactOutput, err := runCLI(cfg, "-db", targetPath, "compact", "-tombstone-retention", "1h")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(strings.TrimSpace(compactOutput), "purged 1 tombstones"))
+
+	_, err = runCLI(cfg, "-db", targetPath, "query", `INSERT INTO _unknown_types (type_name, id, at_ns, deleted, data_json) VALUES ('other.Thing', 'u1', 1, 0, '{"@type":"type.googleapis.com/other.Thing"}'), ('other.Thing', 'u2', 2, 0, '{"@type":"type.googleapis.com/other.Thing"}')`)
+	assert.NilError(t, err)
+	archivePath := filepath.Join(t.TempDir(), "unknown.jsonl")
+	compactOutput, err = runCLI(cfg, "-db", targetPath, "compact", "-unknown-max-rows", "1", "-unknown-archive", archivePath)
+	assert.NilError(t, err)
+	assert.Check(t, is.Contains(compactOutput, "evicted 1 unknown rows"))
+	archived, err := os.ReadFile(archivePath)
+	assert.NilError(t, err)
+	assert.Check(t, is.Contains(string(archived), `"id":"u1"`))
+	assert.Check(t, !strings.Contains(string(archived), `"id":"u2"`))
+
+	unknownOutput, err := runCLI(cfg, "-db", targetPath, "unknown")
+	assert.NilError(t, err)
+	assert.Check(t, is.Contains(unknownOutput, `{"@type":"type.googleapis.com/other.Thing"}`))
+	descriptorsPath := filepath.Join(t.TempDir(), "other.binpb")
+	serialized, err := proto.Marshal(otherThingDescriptorSet())
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(descriptorsPath, serialized, 0o644))
+	unknownOutput, err = runCLI(cfg, "-db", targetPath, "unknown", "-descriptors", descriptorsPath, "-type", "other.Thing")
+	assert.NilError(t, err)
+	unknownLines := strings.Split(strings.TrimSpace(unknownOutput), "\n")
+	assert.Assert(t, is.Len(unknownLines, 2))
+	assert.Check(t, is.DeepEqual(strings.Fields(unknownLines[1]), []string{"other.Thing", "u2", "2", "{}"}))
+
+	verifyOutput, err := runCLI(cfg, "-db", sourcePath, "verify", "system.proprdb.lock.json")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(verifyOutput, ""))
+	_, err = runCLI(cfg, "-db", sourcePath, "query", `DROP INDEX "idx_generatedtest_example_person__name"`)
+	assert.NilError(t, err)
+	verifyOutput, err = runCLI(cfg, "-db", sourcePath, "verify", "system.proprdb.lock.json")
+	assert.ErrorContains(t, err, "1 differences from the schema lock")
+	assert.Check(t, is.Equal(verifyOutput, PersonTableName+": missing index idx_generatedtest_example_person__name\n"))
+
+	_, err = runCLI(cfg, "-db", targetPath, "vacuum")
+	assert.NilError(t, err)
+
+	_, err = runCLI(cfg, "-db", targetPath, "bogus")
+	assert.ErrorContains(t, err, `unknown command "bogus"`)
+}
+
+func openCLITestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	return db
+}
@@ -0,0 +1,100 @@
+package genexample
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestGeneratedBlobExternal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := openCLITestDB(t, filepath.Join(dir, "blob.db"))
+	store := rt.DirBlobStore{Dir: filepath.Join(dir, "blobs")}
+	crud := NewCRUDWithOptions(db, rt.Options{DeviceID: "device-a", BlobStore: store, BlobMinSize: 16})
+	assert.NilError(t, crud.Init())
+	blobCount := func() int {
+		t.Helper()
+		blobs, err := store.List(ctx)
+		assert.NilError(t, err)
+		return len(blobs)
+	}
+	storedData := func(id string) []byte {
+		t.Helper()
+		var data []byte
+		assert.NilError(t, db.QueryRow(`SELECT data FROM `+DocumentTableName+` WHERE id = ?`, id).Scan(&data))
+		return data
+	}
+
+	photo := bytes.Repeat([]byte("photo"), 100)
+	first, err := crud.Document.Insert(&Document{Title: "first", Attachment: photo})
+	assert.NilError(t, err)
+	small, err := crud.Document.Insert(&Document{Title: "small", Attachment: []byte("tiny")})
+	assert.NilError(t, err)
+	assert.Check(t, !bytes.Contains(storedData(first.ID), photo), "large values leave the data column")
+	assert.Check(t, bytes.Contains(storedData(small.ID), []byte("tiny")), "small values stay inline")
+	assert.Check(t, is.Equal(blobCount(), 1))
+
+	row, err := crud.Document.GetByID(first.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(row.Data.Attachment, photo))
+	_, err = NewCRUD(db).Document.GetByID(first.ID)
+	assert.Check(t, is.ErrorContains(err, "no blob store configured"))
+
+	second, err := crud.Document.Insert(&Document{Title: "second", Attachment: photo})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(blobCount(), 1), "equal content is stored once")
+	_, err = crud.Document.UpdateByID(first.ID, &Document{Title: "first", Attachment: bytes.Repeat([]byte("video"), 100)})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(blobCount(), 2))
+
+	deleted, err := crud.CollectBlobGarbage(ctx, 0)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(deleted, 0), "both blobs are referenced")
+	assert.NilError(t, crud.Document.DeleteByID(second.ID))
+	deleted, err = crud.CollectBlobGarbage(ctx, time.Hour)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(deleted, 0), "recent blobs are kept")
+	deleted, err = crud.CollectBlobGarbage(ctx, 0)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(deleted, 1))
+	assert.Check(t, is.Equal(blobCount(), 1))
+	row, err = crud.Document.GetByID(first.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(row.Data.Attachment, bytes.Repeat([]byte("video"), 100)))
+}
+
+func TestGeneratedBlobExternalEncrypted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := openCLITestDB(t, filepath.Join(dir, "blob.db"))
+	cipher, err := rt.NewAESGCMCipher("k1", map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)})
+	assert.NilError(t, err)
+	store := rt.DirBlobStore{Dir: filepath.Join(dir, "blobs")}
+	crud := NewCRUDWithOptions(db, rt.Options{DeviceID: "device-a", Cipher: cipher, BlobStore: store, BlobMinSize: 16})
+	assert.NilError(t, crud.Init())
+
+	photo := bytes.Repeat([]byte("secret"), 100)
+	inserted, err := crud.Document.Insert(&Document{Title: "private", Attachment: photo})
+	assert.NilError(t, err)
+	blobs, err := store.List(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(blobs, 1))
+	content, err := os.ReadFile(filepath.Join(store.Dir, blobs[0].Hash[:2], blobs[0].Hash))
+	assert.NilError(t, err)
+	assert.Check(t, !bytes.Contains(content, []byte("secret")), "blobs are encrypted with the cipher")
+
+	row, err := crud.Document.GetByID(inserted.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(row.Data.Attachment, photo))
+	deleted, err := crud.CollectBlobGarbage(ctx, 0)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(deleted, 0), "references are found in encrypted rows")
+}
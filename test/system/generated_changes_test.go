@@ -0,0 +1,183 @@
+package genexample
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestGeneratedChangeFeed(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "changes.db"))
+	plain := NewCRUD(db)
+	assert.NilError(t, plain.Init())
+	_, err := rt.ReadChanges(db, 0, 0)
+	assert.Check(t, is.ErrorContains(err, "no such table"), "the feed is optional")
+
+	crud := NewCRUDWithOptions(db, rt.Options{ChangeFeed: true})
+	assert.NilError(t, crud.Init())
+	inserted, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	updated, err := crud.Person.UpdateByID(inserted.ID, &Person{Name: "Ada", Age: 37})
+	assert.NilError(t, err)
+	task, err := crud.Task.Insert(&Task{Title: "write"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Task.DeleteByID(task.ID))
+	var deletedAtNs int64
+	assert.NilError(t, db.QueryRow(`SELECT at_ns FROM _deleted WHERE id = ?`, task.ID).Scan(&deletedAtNs))
+
+	changes, err := rt.ReadChanges(db, 0, 0)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(changes, 4))
+	assert.Check(t, is.DeepEqual(changes, []rt.Change{
+		{Seq: 1, TableName: PersonTableName, ID: inserted.ID, Op: rt.ChangeInsert, AtNs: inserted.AtNs},
+		{Seq: 2, TableName: PersonTableName, ID: inserted.ID, Op: rt.ChangeUpdate, AtNs: updated.AtNs},
+		{Seq: 3, TableName: TaskTableName, ID: task.ID, Op: rt.ChangeInsert, AtNs: task.AtNs},
+		{Seq: 4, TableName: TaskTableName, ID: task.ID, Op: rt.ChangeDelete, AtNs: deletedAtNs},
+	}))
+
+	// Changes arriving through sync are recorded too.
+	peer := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "peer.db")))
+	assert.NilError(t, peer.Init())
+	grace, err := peer.Person.Insert(&Person{Name: "Grace", Age: 85})
+	assert.NilError(t, err)
+	var stream bytes.Buffer
+	assert.NilError(t, peer.WriteJSONL("", &stream))
+	assert.NilError(t, crud.ReadJSONL("peer", &stream))
+
+	tail, err := rt.ReadChanges(db, 4, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(tail, 1))
+	assert.Check(t, is.Equal(tail[0].ID, grace.ID))
+	assert.Check(t, is.Equal(tail[0].Op, rt.ChangeInsert))
+	assert.Check(t, is.Equal(tail[0].AtNs, grace.AtNs))
+	last := tail[0].Seq
+
+	trimmed, err := rt.TrimChanges(db, last)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(trimmed, int64(5)))
+	assert.NilError(t, rt.DisableChangeFeed(db, TaskTableName))
+	_, err = crud.Task.Insert(&Task{Title: "unrecorded"})
+	assert.NilError(t, err)
+	_, err = crud.Person.Insert(&Person{Name: "Linus", Age: 29})
+	assert.NilError(t, err)
+	changes, err = rt.ReadChanges(db, 0, 0)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(changes, 1))
+	assert.Check(t, changes[0].Seq > last, "seqs are not reused after trimming")
+	assert.Check(t, is.Equal(changes[0].TableName, PersonTableName))
+}
+
+func TestGeneratedChangeFeedSoftDelete(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "soft-changes.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{ChangeFeed: true})
+	assert.NilError(t, crud.Init())
+	// A feed enabled before soft deletes were recorded is upgraded by Init.
+	assert.NilError(t, rt.DisableChangeFeed(db, ArchiveTableName))
+	_, err := db.Exec(`CREATE TRIGGER "_changes_` + ArchiveTableName + `_update" AFTER UPDATE ON "` + ArchiveTableName + `" WHEN NEW.at_ns IS NOT OLD.at_ns BEGIN INSERT INTO _changes (table_name, id, op, at_ns) VALUES ('stale', NEW.id, 'update', NEW.at_ns); END`)
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Init())
+
+	inserted, err := crud.Archive.Insert(&Archive{Label: "old"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Archive.DeleteByID(inserted.ID))
+	var deletedAtNs int64
+	assert.NilError(t, db.QueryRow(`SELECT at_ns FROM _deleted WHERE id = ?`, inserted.ID).Scan(&deletedAtNs))
+	restored, err := crud.Archive.Restore(inserted.ID)
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Archive.DeleteByID(inserted.ID))
+	var redeletedAtNs int64
+	assert.NilError(t, db.QueryRow(`SELECT at_ns FROM _deleted WHERE id = ?`, inserted.ID).Scan(&redeletedAtNs))
+	// Purging the soft-deleted row is no second delete.
+	_, err = db.Exec(`DELETE FROM "`+ArchiveTableName+`" WHERE id = ?`, inserted.ID)
+	assert.NilError(t, err)
+
+	changes, err := rt.ReadChanges(db, 0, 0)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(changes, []rt.Change{
+		{Seq: 1, TableName: ArchiveTableName, ID: inserted.ID, Op: rt.ChangeInsert, AtNs: inserted.AtNs},
+		{Seq: 2, TableName: ArchiveTableName, ID: inserted.ID, Op: rt.ChangeDelete, AtNs: deletedAtNs},
+		{Seq: 3, TableName: ArchiveTableName, ID: inserted.ID, Op: rt.ChangeUpdate, AtNs: restored.AtNs},
+		{Seq: 4, TableName: ArchiveTableName, ID: inserted.ID, Op: rt.ChangeDelete, AtNs: redeletedAtNs},
+	}))
+}
+
+func TestGeneratedOutboxDrainer(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "outbox.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{ChangeFeed: true})
+	assert.NilError(t, crud.Init())
+	ids := make([]string, 0)
+	for _, name := range []string{"Ada", "Grace", "Linus"} {
+		row, err := crud.Person.Insert(&Person{Name: name, Age: 30})
+		assert.NilError(t, err)
+		ids = append(ids, row.ID)
+	}
+	// A rolled back write leaves no outbox entry.
+	errRollback := errors.New("rollback")
+	err := crud.WithTx(context.Background(), func(tx *CRUD) error {
+		if _, err := tx.Person.Insert(&Person{Name: "Rolled back", Age: 1}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	assert.Assert(t, is.ErrorIs(err, errRollback))
+
+	var batches [][]string
+	failing := true
+	indexer := rt.OutboxDrainer{
+		DB:       db,
+		Consumer: "indexer",
+		Publisher: rt.OutboxPublisherFunc(func(_ context.Context, changes []rt.Change) error {
+			if failing {
+				return errors.New("broker down")
+			}
+			batch := make([]string, 0, len(changes))
+			for _, change := range changes {
+				batch = append(batch, change.ID)
+			}
+			batches = append(batches, batch)
+			return nil
+		}),
+		BatchSize: 2,
+		Trim:      true,
+	}
+	published, err := indexer.Drain(context.Background())
+	assert.Check(t, is.ErrorContains(err, "broker down"))
+	assert.Check(t, is.Equal(published, int64(0)))
+	checkpoint, err := rt.OutboxCheckpoint(db, "indexer")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(checkpoint, int64(0)))
+
+	failing = false
+	published, err = indexer.Drain(context.Background())
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(published, int64(3)))
+	assert.Check(t, is.DeepEqual(batches, [][]string{ids[:2], ids[2:]}))
+	checkpoint, err = rt.OutboxCheckpoint(db, "indexer")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(checkpoint, int64(3)))
+	remaining, err := rt.ReadChanges(db, 0, 0)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(remaining, 0), "the only consumer published everything")
+
+	// A second consumer holds back trimming until it has published too.
+	assert.NilError(t, crud.Person.DeleteByID(ids[0]))
+	var audited []rt.Change
+	audit := rt.OutboxDrainer{DB: db, Consumer: "audit", Publisher: rt.OutboxPublisherFunc(func(_ context.Context, changes []rt.Change) error {
+		audited = append(audited, changes...)
+		return nil
+	})}
+	published, err = audit.Drain(context.Background())
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(published, int64(1)))
+	assert.Check(t, is.Equal(audited[0].Op, rt.ChangeDelete))
+	published, err = indexer.Drain(context.Background())
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(published, int64(1)))
+	assert.Check(t, is.DeepEqual(batches[2], ids[:1]))
+}
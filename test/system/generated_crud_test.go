@@ -1,12 +1,23 @@
 package genexample
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	rt "github.com/fingon/proprdb/rt"
 	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"gotest.tools/v3/assert"
 	is "gotest.tools/v3/assert/cmp"
 )
@@ -161,10 +172,22 @@ func TestGeneratedCRUDTableDescriptors(t *testing.T) {
 	expected := []rt.GeneratedTableDescriptor{
 		{TableName: PersonTableName, TypeName: PersonTypeName, IsCore: false, SyncEnabled: true},
 		{TableName: NoteTableName, TypeName: NoteTypeName, IsCore: false, SyncEnabled: false},
+		{TableName: TaskTableName, TypeName: TaskTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: TallyTableName, TypeName: TallyTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: DocumentTableName, TypeName: DocumentTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: ArchiveTableName, TypeName: ArchiveTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: EventTableName, TypeName: EventTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: SessionTableName, TypeName: SessionTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: TicketTableName, TypeName: TicketTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: SkuTableName, TypeName: SkuTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: InvoiceTableName, TypeName: InvoiceTypeName, IsCore: false, SyncEnabled: true},
+		{TableName: PageTableName, TypeName: PageTypeName, IsCore: false, SyncEnabled: true},
 		{TableName: rt.CoreTableDeletedName, TypeName: "", IsCore: true, SyncEnabled: false},
 		{TableName: rt.CoreTableSyncName, TypeName: "", IsCore: true, SyncEnabled: false},
 		{TableName: rt.CoreTableSchemaStateName, TypeName: "", IsCore: true, SyncEnabled: false},
 		{TableName: rt.CoreTableUnknownName, TypeName: "", IsCore: true, SyncEnabled: false},
+		{TableName: rt.CoreTableRejectedName, TypeName: "", IsCore: true, SyncEnabled: false},
+		{TableName: rt.CoreTableDescriptorsName, TypeName: "", IsCore: true, SyncEnabled: false},
 	}
 	assert.DeepEqual(t, descriptors, expected)
 
@@ -173,6 +196,117 @@ func TestGeneratedCRUDTableDescriptors(t *testing.T) {
 	assert.Check(t, is.Equal(descriptorsSecondRead[0].TableName, PersonTableName))
 }
 
+func TestGeneratedCRUDCompression(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-compression?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	longText := strings.Repeat("compressible ", 100)
+	insertedNote, err := crud.Note.Insert(&Note{Text: longText})
+	assert.NilError(t, err)
+	var storedNote []byte
+	err = db.QueryRowContext(ctx, "SELECT data FROM \""+NoteTableName+"\" WHERE id = ?", insertedNote.ID).Scan(&storedNote)
+	assert.NilError(t, err)
+	assert.Check(t, bytes.HasPrefix(storedNote, []byte{0x1f, 0x8b}))
+	assert.Check(t, len(storedNote) < len(longText))
+	notes, err := crud.Note.Select("id = ?", insertedNote.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(notes, 1))
+	assert.Check(t, is.Equal(notes[0].Data.GetText(), longText))
+
+	plainPerson, err := crud.Person.Insert(&Person{Name: "Plain", Age: 1})
+	assert.NilError(t, err)
+	compressedCRUD := NewCRUDWithOptions(db, rt.Options{DataCodec: rt.GzipDataCodec{}})
+	compressedPerson, err := compressedCRUD.Person.Insert(&Person{Name: "Compressed", Age: 2})
+	assert.NilError(t, err)
+	var storedPerson []byte
+	err = db.QueryRowContext(ctx, "SELECT data FROM \""+PersonTableName+"\" WHERE id = ?", compressedPerson.ID).Scan(&storedPerson)
+	assert.NilError(t, err)
+	assert.Check(t, bytes.HasPrefix(storedPerson, []byte{0x1f, 0x8b}))
+
+	for _, reader := range []*CRUD{crud, compressedCRUD} {
+		people, selectErr := reader.Person.Select("id IN (?, ?)", plainPerson.ID, compressedPerson.ID)
+		assert.NilError(t, selectErr)
+		assert.Check(t, is.Len(people, 2))
+	}
+}
+
+func TestGeneratedCRUDEncryption(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-encryption?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+
+	firstKey := bytes.Repeat([]byte{1}, 32)
+	secondKey := bytes.Repeat([]byte{2}, 32)
+	firstCipher, err := rt.NewAESGCMCipher("k1", map[string][]byte{"k1": firstKey})
+	assert.NilError(t, err)
+	crud := NewCRUDWithOptions(db, rt.Options{Cipher: firstCipher})
+	assert.NilError(t, crud.Init())
+
+	person, err := crud.Person.Insert(&Person{Name: "Secret", Age: 5})
+	assert.NilError(t, err)
+	note, err := crud.Note.Insert(&Note{Text: "hidden text"})
+	assert.NilError(t, err)
+
+	var storedPerson []byte
+	err = db.QueryRowContext(ctx, "SELECT data FROM \""+PersonTableName+"\" WHERE id = ?", person.ID).Scan(&storedPerson)
+	assert.NilError(t, err)
+	keyID, ok := rt.StoredKeyID(storedPerson)
+	assert.Check(t, ok)
+	assert.Check(t, is.Equal(keyID, "k1"))
+
+	var storedText []byte
+	err = db.QueryRowContext(ctx, "SELECT \"text\" FROM \""+NoteTableName+"\" WHERE id = ?", note.ID).Scan(&storedText)
+	assert.NilError(t, err)
+	assert.Check(t, !bytes.Contains(storedText, []byte("hidden text")))
+	decryptedText, err := rt.DecryptColumnValue(crud.Note.opts, storedText)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(decryptedText), "hidden text"))
+
+	people, err := crud.Person.Select("id = ?", person.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(people, 1))
+	assert.Check(t, is.Equal(people[0].Data.GetName(), "Secret"))
+
+	_, err = NewCRUD(db).Person.Select("id = ?", person.ID)
+	assert.Check(t, err != nil)
+
+	rotatedCipher, err := rt.NewAESGCMCipher("k2", map[string][]byte{"k1": firstKey, "k2": secondKey})
+	assert.NilError(t, err)
+	rotated := NewCRUDWithOptions(db, rt.Options{Cipher: rotatedCipher})
+	rewritten, err := rotated.Person.RotateEncryption()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(rewritten, int64(1)))
+	rewritten, err = rotated.Person.RotateEncryption()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(rewritten, int64(0)))
+	_, err = rotated.Note.RotateEncryption()
+	assert.NilError(t, err)
+
+	err = db.QueryRowContext(ctx, "SELECT data FROM \""+PersonTableName+"\" WHERE id = ?", person.ID).Scan(&storedPerson)
+	assert.NilError(t, err)
+	keyID, _ = rt.StoredKeyID(storedPerson)
+	assert.Check(t, is.Equal(keyID, "k2"))
+	err = db.QueryRowContext(ctx, "SELECT \"text\" FROM \""+NoteTableName+"\" WHERE id = ?", note.ID).Scan(&storedText)
+	assert.NilError(t, err)
+	keyID, _ = rt.StoredKeyID(storedText)
+	assert.Check(t, is.Equal(keyID, "k2"))
+
+	rotatedNotes, err := rotated.Note.Select("id = ?", note.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rotatedNotes, 1))
+	assert.Check(t, is.Equal(rotatedNotes[0].Data.GetText(), "hidden text"))
+}
+
 func tableIndexNamesByName(t *testing.T, ctx context.Context, db *sql.DB, tableName string) map[string]bool {
 	t.Helper()
 
@@ -192,3 +326,1910 @@ func tableIndexNamesByName(t *testing.T, ctx context.Context, db *sql.DB, tableN
 
 	return indexesByName
 }
+
+func TestGeneratedSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-soft-delete?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	kept, err := crud.Archive.Insert(&Archive{Label: "kept"})
+	assert.NilError(t, err)
+	removed, err := crud.Archive.Insert(&Archive{Label: "removed"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Archive.DeleteByID(removed.ID))
+
+	live, err := crud.Archive.Select(`label != '' ORDER BY label`)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(live, 1))
+	assert.Check(t, is.Equal(live[0].ID, kept.ID))
+	assert.Check(t, is.Equal(live[0].DeletedAtNs, int64(0)))
+
+	all, err := crud.Archive.SelectIncludingDeleted(`label = ?`, "removed")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(all, 1))
+	assert.Check(t, all[0].DeletedAtNs > 0)
+	var tombstones int
+	assert.NilError(t, db.QueryRowContext(ctx, countTombstoneByIDSQL, ArchiveTableName, removed.ID).Scan(&tombstones))
+	assert.Check(t, is.Equal(tombstones, 1))
+
+	var exported bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL("peer", &exported))
+	assert.Check(t, strings.Contains(exported.String(), `"id":"`+removed.ID+`","deleted":true`), exported.String())
+
+	_, err = crud.Archive.Restore(kept.ID)
+	assert.ErrorContains(t, err, "no soft-deleted row")
+	restored, err := crud.Archive.Restore(removed.ID)
+	assert.NilError(t, err)
+	assert.Check(t, restored.AtNs > all[0].DeletedAtNs)
+	live, err = crud.Archive.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(live, 2))
+	assert.NilError(t, db.QueryRowContext(ctx, countTombstoneByIDSQL, ArchiveTableName, removed.ID).Scan(&tombstones))
+	assert.Check(t, is.Equal(tombstones, 0))
+
+	// A peer soft-deletes its copy when the tombstone arrives.
+	peerDB, err := sql.Open("sqlite3", "file:crud-soft-delete-peer?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, peerDB.Close())
+	})
+	peer := NewCRUD(peerDB)
+	assert.NilError(t, peer.Init())
+	exported.Reset()
+	assert.NilError(t, crud.WriteJSONL("replica", &exported))
+	assert.NilError(t, peer.ReadJSONL("origin", &exported))
+	assert.NilError(t, crud.Archive.DeleteByID(kept.ID))
+	exported.Reset()
+	assert.NilError(t, crud.WriteJSONL("replica", &exported))
+	assert.NilError(t, peer.ReadJSONL("origin", &exported))
+	live, err = peer.Archive.Select("")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(live, 1))
+	assert.Check(t, is.Equal(live[0].ID, removed.ID))
+	all, err = peer.Archive.SelectIncludingDeleted(`id = ?`, kept.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(all, 1))
+	assert.Check(t, all[0].DeletedAtNs > 0)
+}
+
+func TestGeneratedTrackTimestamps(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:crud-track-timestamps?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	first, err := crud.Event.Insert(&Event{Kind: "first"})
+	assert.NilError(t, err)
+	second, err := crud.Event.Insert(&Event{Kind: "second"})
+	assert.NilError(t, err)
+	updated, err := crud.Event.UpdateByID(first.ID, &Event{Kind: "first-updated"})
+	assert.NilError(t, err)
+
+	created, err := crud.Event.Select(`created_at_ns >= ? ORDER BY created_at_ns`, first.AtNs)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(created, 2))
+	assert.Check(t, is.Equal(created[0].ID, first.ID))
+	assert.Check(t, is.Equal(created[1].ID, second.ID))
+
+	recent, err := crud.Event.Select(`updated_at_ns > ?`, second.AtNs)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(recent, 1))
+	assert.Check(t, is.Equal(recent[0].ID, first.ID))
+
+	var createdAtNs, updatedAtNs int64
+	assert.NilError(t, db.QueryRow(`SELECT created_at_ns, updated_at_ns FROM `+EventTableName+` WHERE id = ?`, first.ID).Scan(&createdAtNs, &updatedAtNs))
+	assert.Check(t, is.Equal(createdAtNs, first.AtNs))
+	assert.Check(t, is.Equal(updatedAtNs, updated.AtNs))
+}
+
+func TestGeneratedExpireStale(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-expire-stale?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	stale, err := crud.Session.Insert(&Session{User: "stale"})
+	assert.NilError(t, err)
+	fresh, err := crud.Session.Insert(&Session{User: "fresh"})
+	assert.NilError(t, err)
+	backdateSQL := `UPDATE ` + SessionTableName + ` SET at_ns = at_ns - ? WHERE id = ?`
+	_, err = db.ExecContext(ctx, backdateSQL, 2*SessionTTLSeconds*int64(time.Second), stale.ID)
+	assert.NilError(t, err)
+
+	expired, err := crud.ExpireStale()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(expired, int64(1)))
+	rows, err := crud.Session.Select("")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, fresh.ID))
+	var tombstones int
+	assert.NilError(t, db.QueryRowContext(ctx, countTombstoneByIDSQL, SessionTableName, stale.ID).Scan(&tombstones))
+	assert.Check(t, is.Equal(tombstones, 1))
+	var exported bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL("peer", &exported))
+	assert.Check(t, strings.Contains(exported.String(), `"id":"`+stale.ID+`","deleted":true`), exported.String())
+
+	_, err = db.ExecContext(ctx, backdateSQL, 2*SessionTTLSeconds*int64(time.Second), fresh.ID)
+	assert.NilError(t, err)
+	loopCtx, cancel := context.WithCancel(ctx)
+	done := rt.StartExpiryLoop(loopCtx, 10*time.Millisecond, crud)
+	for range 100 {
+		rows, err = crud.Session.Select("")
+		assert.NilError(t, err)
+		if len(rows) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	assert.Check(t, is.Len(rows, 0))
+}
+
+func TestGeneratedMapProjection(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:crud-map-projection?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	web, err := crud.Event.Insert(&Event{Kind: "deploy", Labels: map[string]string{"app": "web", "tier": "frontend"}, Counts: map[string]int64{"replicas": 3}})
+	assert.NilError(t, err)
+	api, err := crud.Event.Insert(&Event{Kind: "deploy", Labels: map[string]string{"app": "api", "tier": "backend"}})
+	assert.NilError(t, err)
+	_, err = crud.Event.Insert(&Event{Kind: "deploy"})
+	assert.NilError(t, err)
+
+	where, args := EventWhereLabels("app", "web")
+	rows, err := crud.Event.Select(where, args...)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, web.ID))
+
+	where, args = EventWhereLabels("app", "web", "api")
+	rows, err = crud.Event.Select(where+" ORDER BY id", args...)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 2))
+
+	where, args = EventWhereLabels("tier")
+	countsWhere, countsArgs := EventWhereCounts("replicas", 3)
+	rows, err = crud.Event.Select(where+" AND "+countsWhere, append(args, countsArgs...)...)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, web.ID))
+
+	_, err = crud.Event.UpdateByID(api.ID, &Event{Kind: "deploy", Labels: map[string]string{"app": "web"}})
+	assert.NilError(t, err)
+	where, args = EventWhereLabels("app", "web")
+	rows, err = crud.Event.Select(where, args...)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 2))
+	where, args = EventWhereLabels("tier", "backend")
+	rows, err = crud.Event.Select(where, args...)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 0))
+
+	assert.NilError(t, crud.Event.DeleteByID(web.ID))
+	var entries int
+	assert.NilError(t, db.QueryRow(`SELECT COUNT(*) FROM `+EventLabelsTableName+` WHERE id = ?`, web.ID).Scan(&entries))
+	assert.Check(t, is.Equal(entries, 0))
+}
+
+func TestGeneratedExternalPaths(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-external-paths?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	assert.Check(t, tableIndexNamesByName(t, ctx, db, PersonTableName)["idx_generatedtest_example_person__address_city"])
+
+	helsinki, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36, Address: &Person_Address{City: "Helsinki", Zip: 100}})
+	assert.NilError(t, err)
+	homeless, err := crud.Person.Insert(&Person{Name: "Bob", Age: 40})
+	assert.NilError(t, err)
+
+	rows, err := crud.Person.Select(`address_city = ?`, "Helsinki")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, helsinki.ID))
+
+	var city string
+	var zip int64
+	assert.NilError(t, db.QueryRowContext(ctx, `SELECT address_city, address_zip FROM `+PersonTableName+` WHERE id = ?`, homeless.ID).Scan(&city, &zip))
+	assert.Check(t, is.Equal(city, ""))
+	assert.Check(t, is.Equal(zip, int64(0)))
+
+	_, err = crud.Person.UpdateByID(homeless.ID, &Person{Name: "Bob", Age: 40, Address: &Person_Address{City: "Espoo", Zip: 200}})
+	assert.NilError(t, err)
+	rows, err = crud.Person.Select(`address_zip > ? ORDER BY address_zip`, 50)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 2))
+	assert.Check(t, is.Equal(rows[1].ID, homeless.ID))
+}
+
+func TestGeneratedTimestampProjection(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-timestamp-projection?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	assert.Check(t, tableIndexNamesByName(t, ctx, db, EventTableName)["idx_generatedtest_example_event__occurred_at"])
+
+	base := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	late, err := crud.Event.Insert(&Event{Kind: "late", OccurredAt: timestamppb.New(base.Add(2 * time.Hour)), ExpiresAt: timestamppb.New(base.Add(48 * time.Hour))})
+	assert.NilError(t, err)
+	early, err := crud.Event.Insert(&Event{Kind: "early", OccurredAt: timestamppb.New(base), ExpiresAt: timestamppb.New(base.Add(24 * time.Hour))})
+	assert.NilError(t, err)
+	unset, err := crud.Event.Insert(&Event{Kind: "unset"})
+	assert.NilError(t, err)
+
+	rows, err := crud.Event.SelectOccurredAtBetween(base, base.Add(3*time.Hour))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 2))
+	assert.Check(t, is.Equal(rows[0].ID, early.ID))
+	assert.Check(t, is.Equal(rows[1].ID, late.ID))
+	rows, err = crud.Event.SelectOccurredAtBetween(base, base.Add(time.Hour))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 1))
+
+	rows, err = crud.Event.SelectExpiresAtBetween(base.Add(36*time.Hour), base.Add(72*time.Hour))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, late.ID))
+
+	var occurredAt sql.NullInt64
+	var expiresAt sql.NullString
+	assert.NilError(t, db.QueryRowContext(ctx, `SELECT occurred_at, expires_at FROM `+EventTableName+` WHERE id = ?`, early.ID).Scan(&occurredAt, &expiresAt))
+	assert.Check(t, is.Equal(occurredAt.Int64, base.UnixNano()))
+	assert.Check(t, is.Equal(expiresAt.String, "2026-01-03T03:04:05.000000006Z"))
+	assert.NilError(t, db.QueryRowContext(ctx, `SELECT occurred_at, expires_at FROM `+EventTableName+` WHERE id = ?`, unset.ID).Scan(&occurredAt, &expiresAt))
+	assert.Check(t, !occurredAt.Valid)
+	assert.Check(t, !expiresAt.Valid)
+}
+
+func TestGeneratedFieldRules(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-field-rules?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	_, err = crud.Person.Insert(&Person{Name: "Young", Age: -1})
+	assert.Check(t, errors.Is(err, rt.ErrInvalid), "%v", err)
+	assert.ErrorContains(t, err, "age must be at least 0")
+	person, err := crud.Person.Insert(&Person{Name: "Ada", Age: 200})
+	assert.NilError(t, err)
+	_, err = crud.Person.UpdateByID(person.ID, &Person{Name: "Ada", Age: 201})
+	assert.ErrorContains(t, err, "age must be at most 200")
+
+	_, err = crud.Event.Insert(&Event{})
+	assert.ErrorContains(t, err, "kind is required")
+	_, err = crud.Event.Insert(&Event{Kind: "Deploy"})
+	assert.ErrorContains(t, err, `kind must match "^[a-z][a-z-]*$"`)
+	_, err = crud.Event.Insert(&Event{Kind: "deploy"})
+	assert.NilError(t, err)
+
+	// CHECK constraints also guard rows written around the generated code.
+	_, err = db.ExecContext(ctx, `UPDATE `+PersonTableName+` SET age = 500 WHERE id = ?`, person.ID)
+	assert.ErrorContains(t, err, "CHECK constraint failed")
+	_, err = db.ExecContext(ctx, `UPDATE `+EventTableName+` SET kind = ''`)
+	assert.ErrorContains(t, err, "CHECK constraint failed")
+}
+
+func TestGeneratedIndexOrderAndCollation(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-index-order?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	assert.Check(t, is.Contains(queryPlan(t, ctx, db, `SELECT id FROM `+PersonTableName+` WHERE name = ? COLLATE NOCASE`, "ada"),
+		"idx_generatedtest_example_person__name_nocase"))
+	assert.Check(t, is.Contains(queryPlan(t, ctx, db, `SELECT id FROM `+EventTableName+` WHERE kind = ? ORDER BY occurred_at DESC LIMIT 10`, "deploy"),
+		"idx_generatedtest_example_event__kind_occurred_at_desc"))
+	assert.Check(t, is.Contains(queryPlan(t, ctx, db, `SELECT id FROM `+PersonTableName+` WHERE lower(trim(name)) = ? ORDER BY age DESC`, "ada"),
+		"idx_generatedtest_example_person__expr_lower_trim_name_59a9bd13_age_desc"))
+
+	_, err = crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	rows, err := crud.Person.Select("name = ? COLLATE NOCASE", "ADA")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 1))
+}
+
+func queryPlan(t *testing.T, ctx context.Context, db *sql.DB, query string, args ...any) string {
+	t.Helper()
+
+	rows, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	assert.NilError(t, err)
+	defer func() {
+		assert.NilError(t, rows.Close())
+	}()
+	details := make([]string, 0)
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		assert.NilError(t, rows.Scan(&id, &parent, &notUsed, &detail))
+		details = append(details, detail)
+	}
+	assert.NilError(t, rows.Err())
+	return strings.Join(details, "\n")
+}
+
+func TestGeneratedWithTx(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-with-tx?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	err = crud.WithTx(ctx, func(txCRUD *CRUD) error {
+		if _, err := txCRUD.Person.Insert(&Person{Name: "Ada"}); err != nil {
+			return err
+		}
+		// Nested calls join the outer transaction.
+		return txCRUD.WithTx(ctx, func(nested *CRUD) error {
+			_, err := nested.Note.Insert(&Note{Text: "kept"})
+			return err
+		})
+	})
+	assert.NilError(t, err)
+
+	errRollback := errors.New("roll back")
+	err = crud.WithTx(ctx, func(txCRUD *CRUD) error {
+		if _, err := txCRUD.Person.Insert(&Person{Name: "Grace"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	assert.Check(t, errors.Is(err, errRollback))
+
+	people, err := crud.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(people, 1))
+	assert.Check(t, is.Equal(people[0].Data.GetName(), "Ada"))
+	notes, err := crud.Note.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(notes, 1))
+}
+
+func TestGeneratedWithTxRetriesBusy(t *testing.T) {
+	ctx := context.Background()
+	dsn := "file:" + filepath.Join(t.TempDir(), "busy.db") + "?_busy_timeout=0"
+	db, err := sql.Open("sqlite3", dsn)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	lockerDB, err := sql.Open("sqlite3", dsn)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, lockerDB.Close())
+	})
+	crud := NewCRUDWithOptions(db, rt.Options{TxRetry: rt.RetryPolicy{Attempts: 50, InitialBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}})
+	assert.NilError(t, crud.Init())
+
+	lockTx, err := lockerDB.BeginTx(ctx, nil)
+	assert.NilError(t, err)
+	_, err = lockTx.ExecContext(ctx, `INSERT INTO `+NoteTableName+` (id, at_ns, data, text) VALUES ('locker', 1, x'', '')`)
+	assert.NilError(t, err)
+	released := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		released <- lockTx.Commit()
+	}()
+
+	attempts := 0
+	err = crud.WithTx(ctx, func(txCRUD *CRUD) error {
+		attempts++
+		_, err := txCRUD.Person.Insert(&Person{Name: "Ada"})
+		return err
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, <-released)
+	assert.Check(t, attempts > 1, "attempts: %d", attempts)
+
+	assert.Check(t, rt.IsBusy(errors.New("insert: database is locked")))
+	assert.Check(t, !rt.IsBusy(errors.New("constraint failed")))
+}
+
+func TestGeneratedQueryObserver(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-query-observer?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	var mu sync.Mutex
+	var queries []string
+	var failed []error
+	observer := rt.QueryObserverFunc(func(query string, _ []any, duration time.Duration, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		queries = append(queries, query)
+		if err != nil {
+			failed = append(failed, err)
+		}
+		assert.Check(t, duration >= 0)
+	})
+	observed := func(prefix string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, query := range queries {
+			if strings.HasPrefix(query, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	crud := NewCRUDWithOptions(db, rt.Options{QueryObserver: observer})
+	assert.NilError(t, crud.Init())
+	assert.Check(t, observed(`CREATE TABLE IF NOT EXISTS "`+PersonTableName+`"`))
+
+	_, err = crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	assert.Check(t, observed(PersonInsertSQL))
+
+	_, err = crud.Note.Select("no_such_column = 1")
+	assert.Check(t, err != nil)
+	assert.Check(t, is.Len(failed, 1))
+
+	err = crud.WithTx(ctx, func(txCRUD *CRUD) error {
+		_, err := txCRUD.Task.Insert(&Task{Title: "in tx"})
+		return err
+	})
+	assert.NilError(t, err)
+	assert.Check(t, observed(TaskInsertSQL))
+
+	line := `{"id":"bulk-1","atNs":1,"data":{"@type":"` + typeURLPrefix + SessionTypeName + `","user":"ada"}}` + "\n"
+	assert.NilError(t, crud.ReadJSONLBulk("remote", strings.NewReader(line), 0))
+	assert.Check(t, observed(`INSERT INTO _sync`))
+}
+
+type recordingInstrumentation struct {
+	mu        sync.Mutex
+	spans     []string
+	failed    []string
+	counters  map[string]int64
+	durations int
+}
+
+func (i *recordingInstrumentation) StartSpan(ctx context.Context, name string, _ ...rt.Attribute) (context.Context, func(err error)) {
+	return ctx, func(err error) {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		i.spans = append(i.spans, name)
+		if err != nil {
+			i.failed = append(i.failed, name)
+		}
+	}
+}
+
+func (i *recordingInstrumentation) AddInt64(_ context.Context, name string, delta int64, _ ...rt.Attribute) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.counters == nil {
+		i.counters = map[string]int64{}
+	}
+	i.counters[name] += delta
+}
+
+func (i *recordingInstrumentation) RecordDuration(_ context.Context, name string, duration time.Duration, _ ...rt.Attribute) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if name == rt.MetricQueryDuration && duration >= 0 {
+		i.durations++
+	}
+}
+
+func TestGeneratedInstrumentation(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:crud-instrumentation?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	instrumentation := &recordingInstrumentation{}
+	crud := NewCRUDWithOptions(db, rt.Options{Instrumentation: instrumentation})
+	assert.NilError(t, crud.Init())
+	assert.Check(t, is.Contains(instrumentation.spans, "proprdb.init"))
+	assert.Check(t, instrumentation.durations > 0)
+
+	row, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	_, err = crud.Person.UpdateByID(row.ID, &Person{Name: "Ada L"})
+	assert.NilError(t, err)
+	_, err = crud.Note.Select("no_such_column = 1")
+	assert.Check(t, err != nil)
+	assert.Check(t, is.Contains(instrumentation.spans, "proprdb.insert"))
+	assert.Check(t, is.Contains(instrumentation.spans, "proprdb.update"))
+	assert.Check(t, is.DeepEqual(instrumentation.failed, []string{"proprdb.select"}))
+	assert.Check(t, is.Equal(instrumentation.counters[rt.MetricRowsWritten], int64(2)))
+
+	var out bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL("remote", &out))
+	assert.Check(t, is.Contains(instrumentation.spans, "proprdb.sync.write"))
+	assert.Check(t, is.Equal(instrumentation.counters[rt.MetricSyncLinesWritten], int64(strings.Count(out.String(), "\n"))))
+
+	line := `{"id":"` + row.ID + `","atNs":1,"data":{"@type":"` + typeURLPrefix + PersonTypeName + `","name":"stale"}}` + "\n"
+	assert.NilError(t, crud.ReadJSONL("other", strings.NewReader(line)))
+	assert.Check(t, is.Contains(instrumentation.spans, "proprdb.sync.read"))
+	assert.Check(t, is.Equal(instrumentation.counters[rt.MetricSyncLinesRead], int64(1)))
+	assert.Check(t, is.Equal(instrumentation.counters[rt.MetricSyncConflicts], int64(1)))
+}
+
+func TestGeneratedRowCache(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:crud-row-cache?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	var mu sync.Mutex
+	selects := 0
+	observer := rt.QueryObserverFunc(func(query string, _ []any, _ time.Duration, _ error) {
+		if strings.HasPrefix(query, `SELECT id, at_ns, data FROM "`+PersonTableName+`"`) {
+			mu.Lock()
+			defer mu.Unlock()
+			selects++
+		}
+	})
+	selectCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return selects
+	}
+	crud := NewCRUDWithOptions(db, rt.Options{QueryObserver: observer, Cache: rt.CacheOptions{Size: 2}})
+	assert.NilError(t, crud.Init())
+	getName := func(id string) string {
+		t.Helper()
+		row, err := crud.Person.GetByID(id)
+		if errors.Is(err, rt.ErrNotFound) {
+			return ""
+		}
+		assert.NilError(t, err)
+		return row.Data.GetName()
+	}
+
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	crud.Person.MustGetByID(ada.ID).Data.Name = "mutated"
+	assert.Check(t, is.Equal(getName(ada.ID), "Ada"))
+	assert.Check(t, is.Equal(selectCount(), 1))
+
+	_, err = crud.Person.UpdateByID(ada.ID, &Person{Name: "Ada L"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(getName(ada.ID), "Ada L"))
+	assert.Check(t, is.Equal(selectCount(), 2))
+
+	line := `{"id":"` + ada.ID + `","atNs":` + strconv.FormatInt(rt.NowNs()+int64(time.Hour), 10) + `,"data":{"@type":"` + typeURLPrefix + PersonTypeName + `","name":"Remote Ada"}}` + "\n"
+	assert.NilError(t, crud.ReadJSONL("remote", strings.NewReader(line)))
+	assert.Check(t, is.Equal(getName(ada.ID), "Remote Ada"))
+
+	err = crud.WithTx(ctx, func(txCRUD *CRUD) error {
+		_, err := txCRUD.Person.UpdateByID(ada.ID, &Person{Name: "Tx Ada"})
+		return err
+	})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(getName(ada.ID), "Tx Ada"))
+
+	grace, err := crud.Person.Insert(&Person{Name: "Grace"})
+	assert.NilError(t, err)
+	alan, err := crud.Person.Insert(&Person{Name: "Alan"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(getName(grace.ID), "Grace"))
+	assert.Check(t, is.Equal(getName(alan.ID), "Alan"))
+	before := selectCount()
+	assert.Check(t, is.Equal(getName(ada.ID), "Tx Ada"))
+	assert.Check(t, is.Equal(selectCount(), before+1), "least recently used row should have been evicted")
+
+	assert.NilError(t, crud.Person.DeleteByID(ada.ID))
+	assert.Check(t, is.Equal(getName(ada.ID), ""))
+}
+
+func TestGeneratedGetByID(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:crud-get-by-id?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	grace, err := crud.Person.Insert(&Person{Name: "Grace"})
+	assert.NilError(t, err)
+	missing, err := rt.UUIDv7()
+	assert.NilError(t, err)
+
+	row, err := crud.Person.GetByID(ada.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(row.Data.GetName(), "Ada"))
+	_, err = crud.Person.GetByID(missing)
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+	assert.Check(t, is.Equal(crud.Person.MustGetByID(grace.ID).Data.GetName(), "Grace"))
+	func() {
+		defer func() {
+			recovered := recover()
+			recoveredErr, ok := recovered.(error)
+			assert.Check(t, ok)
+			assert.Check(t, is.ErrorIs(recoveredErr, rt.ErrNotFound))
+		}()
+		crud.Person.MustGetByID(missing)
+	}()
+
+	rows, err := crud.Person.GetManyByID([]string{grace.ID, missing, ada.ID, grace.ID})
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 2))
+	assert.Check(t, is.Equal(rows[0].ID, grace.ID))
+	assert.Check(t, is.Equal(rows[1].ID, ada.ID))
+	rows, err = crud.Person.GetManyByID(nil)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 0))
+
+	archived, err := crud.Archive.Insert(&Archive{})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Archive.DeleteByID(archived.ID))
+	_, err = crud.Archive.GetByID(archived.ID)
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+}
+
+func TestGeneratedSelectWithOptions(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:crud-select-options?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	for _, person := range []*Person{{Name: "Cy", Age: 30}, {Name: "Ada", Age: 40}, {Name: "Bo", Age: 30}, {Name: "Di", Age: 10}} {
+		_, err := crud.Person.Insert(person)
+		assert.NilError(t, err)
+	}
+	names := func(rows []PersonRow) []string {
+		result := make([]string, 0, len(rows))
+		for _, row := range rows {
+			result = append(result, row.Data.GetName())
+		}
+		return result
+	}
+
+	rows, err := crud.Person.SelectWithOptions(rt.SelectOptions{
+		OrderBy: []rt.OrderBy{{Column: "age", Descending: true}, {Column: "name"}},
+	}, "")
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(names(rows), []string{"Ada", "Bo", "Cy", "Di"}))
+
+	rows, err = crud.Person.SelectWithOptions(rt.SelectOptions{
+		OrderBy: []rt.OrderBy{{Column: "name"}},
+		Limit:   2,
+		Offset:  1,
+	}, "age >= ?", 20)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(names(rows), []string{"Bo", "Cy"}))
+
+	rows, err = crud.Person.SelectWithOptions(rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: "name"}}, Offset: 3}, "")
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(names(rows), []string{"Di"}))
+
+	_, err = crud.Person.SelectWithOptions(rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: "data"}}}, "")
+	assert.Check(t, is.ErrorContains(err, `cannot order by "data"`))
+	_, err = crud.Person.SelectWithOptions(rt.SelectOptions{Limit: -1}, "")
+	assert.Check(t, is.ErrorContains(err, "negative limit"))
+
+	archived, err := crud.Archive.Insert(&Archive{})
+	assert.NilError(t, err)
+	_, err = crud.Archive.Insert(&Archive{})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Archive.DeleteByID(archived.ID))
+	archives, err := crud.Archive.SelectWithOptions(rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: "at_ns", Descending: true}}, Limit: 5}, "")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(archives, 1))
+}
+
+func TestGeneratedTypedErrors(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:crud-typed-errors?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+
+	missing, err := rt.UUIDv7()
+	assert.NilError(t, err)
+	_, err = crud.Person.GetByID(missing)
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+
+	_, err = crud.Person.UpdateByID("not-a-uuid", &Person{Name: "Ada"})
+	assert.Check(t, is.ErrorIs(err, rt.ErrInvalidID))
+	assert.Check(t, is.ErrorContains(err, `invalid uuid "not-a-uuid"`))
+	err = crud.Person.DeleteByID("")
+	assert.Check(t, is.ErrorIs(err, rt.ErrInvalidID))
+	assert.Check(t, is.Error(err, "empty id"))
+
+	_, err = crud.Person.Insert(&Person{Name: "Ada", Age: 300})
+	assert.Check(t, is.ErrorIs(err, rt.ErrValidation))
+	assert.Check(t, is.ErrorIs(err, rt.ErrInvalid))
+	_, err = db.Exec(`UPDATE "`+PersonTableName+`" SET age = ? WHERE id = ?`, 300, ada.ID)
+	assert.Check(t, is.ErrorIs(rt.ClassifySQLError(err), rt.ErrValidation))
+
+	_, err = crud.Person.InsertWithID(ada.ID, &Person{Name: "Twin"})
+	assert.Check(t, is.ErrorIs(err, rt.ErrUniqueViolation))
+	assert.Check(t, is.ErrorContains(err, "UNIQUE constraint failed"))
+}
+
+func TestGeneratedMutateWhere(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "mutate-where.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{Clock: &rt.StepClock{Start: 1000, Step: 1}})
+	assert.NilError(t, crud.Init())
+	const people = rt.DefaultMutateBatchSize*2 + 100
+	assert.NilError(t, crud.WithTx(context.Background(), func(txCRUD *CRUD) error {
+		for index := range people {
+			if _, err := txCRUD.Person.Insert(&Person{Name: "P" + strconv.Itoa(index), Age: int64(index % 100)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	countRows := func(query string, args ...any) int64 {
+		t.Helper()
+		var count int64
+		assert.NilError(t, db.QueryRow(query, args...).Scan(&count))
+		return count
+	}
+
+	updated, err := crud.Person.UpdateWhere("age < ?", func(person *Person) error {
+		person.Name += "!"
+		return nil
+	}, 50)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(updated, int64(people/2)))
+	assert.Check(t, is.Equal(countRows(`SELECT COUNT(*) FROM "`+PersonTableName+`" WHERE name LIKE '%!'`), int64(people/2)), "projections follow")
+	assert.Check(t, is.Equal(countRows(`SELECT COUNT(*) FROM "`+PersonTableName+`" WHERE age < 50 AND at_ns < ?`, people+1000), int64(0)), "at_ns is bumped")
+
+	deleted, err := crud.Person.DeleteWhere("age >= ?", 50)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(deleted, int64(people/2)))
+	assert.Check(t, is.Equal(countRows(`SELECT COUNT(*) FROM "`+PersonTableName+`"`), int64(people/2)))
+	assert.Check(t, is.Equal(countRows(`SELECT COUNT(*) FROM _deleted WHERE table_name = ?`, PersonTableName), int64(people/2)))
+
+	var lastName string
+	assert.NilError(t, db.QueryRow(`SELECT name FROM "`+PersonTableName+`" ORDER BY id DESC LIMIT 1`).Scan(&lastName))
+	failing, err := crud.Person.UpdateWhere("", func(person *Person) error {
+		if person.GetName() == lastName {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	assert.Check(t, is.ErrorContains(err, "boom"))
+	assert.Check(t, is.Equal(failing, int64(rt.DefaultMutateBatchSize)), "the batches before the failure are committed")
+
+	mem := NewMemCRUD()
+	_, err = mem.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	_, err = mem.Person.Insert(&Person{Name: "Grace", Age: 45})
+	assert.NilError(t, err)
+	updated, err = mem.Person.UpdateWhere("age > ?", func(person *Person) error {
+		person.Age++
+		return nil
+	}, 40)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(updated, int64(1)))
+	deleted, err = mem.Person.DeleteWhere("age = ?", 46)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(deleted, int64(1)))
+	assert.Check(t, is.Len(mem.Person.Tombstones(), 1))
+}
+
+func TestGeneratedUpdateFields(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "update-fields.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{Clock: &rt.StepClock{Start: 1000, Step: 1}})
+	assert.NilError(t, crud.Init())
+	inserted, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36, Address: &Person_Address{City: "London", Zip: 1}})
+	assert.NilError(t, err)
+
+	updated, err := crud.Person.UpdateFields(inserted.ID, &Person{Name: "Grace", Age: 99}, &fieldmaskpb.FieldMask{Paths: []string{"name", "address.city"}})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(updated.Data.GetName(), "Grace"))
+	assert.Check(t, is.Equal(updated.Data.GetAge(), int64(36)), "unmasked fields are kept")
+	assert.Check(t, is.Equal(updated.Data.GetAddress().GetCity(), ""), "masked fields unset in the patch are cleared")
+	assert.Check(t, is.Equal(updated.Data.GetAddress().GetZip(), int32(1)))
+	assert.Check(t, updated.AtNs > inserted.AtNs)
+	var name, city string
+	assert.NilError(t, db.QueryRow(`SELECT name, address_city FROM "`+PersonTableName+`" WHERE id = ?`, inserted.ID).Scan(&name, &city))
+	assert.Check(t, is.Equal(name, "Grace"), "projections follow")
+	assert.Check(t, is.Equal(city, ""))
+
+	_, err = crud.Person.UpdateFields(inserted.ID, &Person{}, &fieldmaskpb.FieldMask{Paths: []string{"nickname"}})
+	assert.Check(t, is.ErrorIs(err, rt.ErrInvalidFieldMask))
+	_, err = crud.Person.UpdateFields(inserted.ID, &Person{}, &fieldmaskpb.FieldMask{Paths: []string{"name.first"}})
+	assert.Check(t, is.ErrorIs(err, rt.ErrInvalidFieldMask))
+	_, err = crud.Person.UpdateFields(inserted.ID, &Person{}, nil)
+	assert.Check(t, is.ErrorIs(err, rt.ErrInvalidFieldMask))
+	_, err = crud.Person.UpdateFields(inserted.ID, &Person{Age: 500}, &fieldmaskpb.FieldMask{Paths: []string{"age"}})
+	assert.Check(t, err != nil, "the merged message is validated")
+	_, err = crud.Person.UpdateFields("missing", &Person{}, &fieldmaskpb.FieldMask{Paths: []string{"name"}})
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+	row, err := crud.Person.GetByID(inserted.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(row.Data.GetName(), "Grace"), "failed updates change nothing")
+	assert.Check(t, is.Equal(row.Data.GetAge(), int64(36)))
+
+	mem := NewMemCRUD()
+	memInserted, err := mem.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	memUpdated, err := mem.Person.UpdateFields(memInserted.ID, &Person{Age: 37}, &fieldmaskpb.FieldMask{Paths: []string{"age"}})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(memUpdated.Data.GetName(), "Ada"))
+	assert.Check(t, is.Equal(memUpdated.Data.GetAge(), int64(37)))
+	_, err = mem.Person.UpdateFields(memInserted.ID, &Person{}, &fieldmaskpb.FieldMask{Paths: []string{"nickname"}})
+	assert.Check(t, is.ErrorIs(err, rt.ErrInvalidFieldMask))
+}
+
+func TestGeneratedRevision(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "revision.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{Clock: &rt.StepClock{Start: 1000, Step: 1}})
+	assert.NilError(t, crud.Init())
+	inserted, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	read, err := crud.Person.GetByID(inserted.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(read.Revision(), inserted.Revision()))
+
+	updated, err := crud.Person.UpdateByIDIfRevision(inserted.ID, read.Revision(), &Person{Name: "Ada", Age: 37})
+	assert.NilError(t, err)
+	assert.Check(t, updated.Revision() != inserted.Revision())
+	_, err = crud.Person.UpdateByIDIfRevision(inserted.ID, read.Revision(), &Person{Name: "Lost", Age: 1})
+	assert.Check(t, is.ErrorIs(err, rt.ErrRevisionMismatch))
+	err = crud.Person.DeleteByIDIfRevision(inserted.ID, read.Revision())
+	assert.Check(t, is.ErrorIs(err, rt.ErrRevisionMismatch))
+	row, err := crud.Person.GetByID(inserted.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(row.Data.GetAge(), int64(37)), "stale writes change nothing")
+
+	// A synced record with the same at_ns replaces the content, and so the
+	// revision.
+	synced := `{"id":"` + inserted.ID + `","atNs":` + strconv.FormatInt(updated.AtNs, 10) + `,"data":{"@type":"` + typeURLPrefix + PersonTypeName + `","name":"Synced"}}` + "\n"
+	assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(synced)))
+	row, err = crud.Person.GetByID(inserted.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Equal(row.Data.GetName(), "Synced"))
+	assert.Check(t, is.Equal(row.AtNs, updated.AtNs))
+	_, err = crud.Person.UpdateByIDIfRevision(inserted.ID, updated.Revision(), &Person{Name: "Lost"})
+	assert.Check(t, is.ErrorIs(err, rt.ErrRevisionMismatch))
+	err = crud.Person.DeleteByIDIfRevision(inserted.ID, updated.Revision())
+	assert.Check(t, is.ErrorIs(err, rt.ErrRevisionMismatch))
+
+	assert.NilError(t, crud.Person.DeleteByIDIfRevision(inserted.ID, row.Revision()))
+	_, err = crud.Person.UpdateByIDIfRevision(inserted.ID, row.Revision(), &Person{Name: "Ada"})
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+
+	mem := NewMemCRUD()
+	memInserted, err := mem.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	memUpdated, err := mem.Person.UpdateByIDIfRevision(memInserted.ID, memInserted.Revision(), &Person{Name: "Ada", Age: 37})
+	assert.NilError(t, err)
+	err = mem.Person.DeleteByIDIfRevision(memInserted.ID, memInserted.Revision())
+	assert.Check(t, is.ErrorIs(err, rt.ErrRevisionMismatch))
+	assert.NilError(t, mem.Person.DeleteByIDIfRevision(memInserted.ID, memUpdated.Revision()))
+}
+
+func TestGeneratedInsertWithIDIfAbsent(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "if-absent.db"))
+	assert.NilError(t, NewCRUD(db).Init())
+	coordinator := rt.NewWriteCoordinator(db, rt.WriteCoordinatorOptions{MaxBatch: 1})
+	t.Cleanup(func() {
+		assert.NilError(t, coordinator.Close())
+	})
+	const eventID = "018f4f3f-6f9f-7a1b-8f55-1234567890ad"
+
+	for name, crud := range map[string]*CRUD{
+		"direct":      NewCRUD(db),
+		"coordinated": NewCRUDWithOptions(db, rt.Options{WriteCoordinator: coordinator}),
+	} {
+		row, inserted, err := crud.Person.InsertWithIDIfAbsent(eventID, &Person{Name: "Ada"})
+		assert.NilError(t, err, name)
+		assert.Check(t, inserted, name)
+		replayed, inserted, err := crud.Person.InsertWithIDIfAbsent(eventID, &Person{Name: "Replay"})
+		assert.NilError(t, err, name)
+		assert.Check(t, !inserted, name)
+		assert.Check(t, is.Equal(replayed.AtNs, row.AtNs), name)
+		assert.Check(t, is.Equal(replayed.Data.GetName(), "Ada"), "the existing row is kept: %s", name)
+
+		_, _, err = crud.Person.InsertWithIDIfAbsent(eventID, &Person{Name: "Ada", Age: 300})
+		assert.Check(t, err == nil, "data is not validated for existing rows: %s", name)
+		_, _, err = crud.Person.InsertWithIDIfAbsent("", &Person{Name: "Ada"})
+		assert.Check(t, is.ErrorIs(err, rt.ErrEmptyID), name)
+		assert.NilError(t, crud.Person.DeleteByID(eventID))
+	}
+
+	mem := NewMemCRUD()
+	_, inserted, err := mem.Person.InsertWithIDIfAbsent(eventID, &Person{Name: "Ada"})
+	assert.NilError(t, err)
+	assert.Check(t, inserted)
+	row, inserted, err := mem.Person.InsertWithIDIfAbsent(eventID, &Person{Name: "Replay"})
+	assert.NilError(t, err)
+	assert.Check(t, !inserted)
+	assert.Check(t, is.Equal(row.Data.GetName(), "Ada"))
+}
+
+func TestGeneratedPlanInit(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:crud-plan-init?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	tablePlan := func(plan rt.InitPlan, tableName string) rt.TablePlan {
+		t.Helper()
+		for _, table := range plan.Tables {
+			if table.TableName == tableName {
+				return table
+			}
+		}
+		t.Fatalf("no plan for %s", tableName)
+		return rt.TablePlan{}
+	}
+
+	plan, err := crud.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, !plan.Empty())
+	assert.Check(t, is.Len(plan.CreateCoreTables, 6))
+	personPlan := tablePlan(plan, PersonTableName)
+	assert.Check(t, personPlan.CreateTable)
+	assert.Check(t, is.Len(personPlan.CreateIndexes, 5))
+	var tables int
+	assert.NilError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&tables))
+	assert.Check(t, is.Equal(tables, 0), "PlanInit must not change the database")
+
+	assert.NilError(t, crud.Init())
+	plan, err = crud.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, plan.Empty(), plan.String())
+
+	_, err = db.Exec(`ALTER TABLE "` + PersonTableName + `" DROP COLUMN address_zip`)
+	assert.NilError(t, err)
+	_, err = db.Exec(`CREATE INDEX "` + personStaleIndex + `" ON "` + PersonTableName + `" (age)`)
+	assert.NilError(t, err)
+	_, err = db.Exec(`UPDATE _proprdb_schema SET schema_hash = 'old' WHERE table_name = ?`, PersonTableName)
+	assert.NilError(t, err)
+	_, err = db.Exec(`INSERT INTO _unknown_types (type_name, id, at_ns, deleted, data_json) VALUES (?, ?, 1, 1, '{}')`, PersonTypeName, "018f0000-0000-7000-8000-000000000001")
+	assert.NilError(t, err)
+
+	plan, err = crud.PlanInit()
+	assert.NilError(t, err)
+	personPlan = tablePlan(plan, PersonTableName)
+	assert.Check(t, is.DeepEqual(personPlan, rt.TablePlan{
+		TableName:          PersonTableName,
+		AddColumns:         []string{"address_zip"},
+		DropIndexes:        []string{personStaleIndex},
+		RebuildProjections: true,
+		DrainUnknownRows:   1,
+	}))
+	assert.Check(t, is.Contains(plan.String(), "add column "+PersonTableName+".address_zip\n"))
+	assert.Check(t, is.Contains(plan.String(), "drop index "+personStaleIndex+"\n"))
+	assert.Check(t, tablePlan(plan, NoteTableName).Empty())
+
+	assert.NilError(t, crud.Init())
+	plan, err = crud.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, plan.Empty(), plan.String())
+}
+
+func TestGeneratedVerifySchemaLock(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:crud-verify-lock?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	file, err := os.Open("system.proprdb.lock.json")
+	assert.NilError(t, err)
+	lock, err := rt.ReadSchemaLock(file)
+	assert.NilError(t, file.Close())
+	assert.NilError(t, err)
+	ctx := context.Background()
+
+	drift, err := rt.VerifySchemaLock(ctx, db, lock)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(drift, len(lock.Tables)))
+	assert.Check(t, slices.Contains(drift, rt.SchemaDrift{Table: PersonTableName, Detail: "missing table"}))
+
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	drift, err = rt.VerifySchemaLock(ctx, db, lock)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(drift, 0), "%v", drift)
+
+	_, err = db.Exec(`ALTER TABLE "` + PersonTableName + `" DROP COLUMN address_zip`)
+	assert.NilError(t, err)
+	_, err = db.Exec(`DROP INDEX "idx_generatedtest_example_person__name"`)
+	assert.NilError(t, err)
+	_, err = db.Exec(`CREATE INDEX "` + personStaleIndex + `" ON "` + PersonTableName + `" (age)`)
+	assert.NilError(t, err)
+	_, err = db.Exec(`UPDATE _proprdb_schema SET schema_hash = 'old' WHERE table_name = ?`, PersonTableName)
+	assert.NilError(t, err)
+	_, err = db.Exec(`INSERT INTO _proprdb_schema (table_name, schema_hash) VALUES ('other_table', '')`)
+	assert.NilError(t, err)
+
+	drift, err = rt.VerifySchemaLock(ctx, db, lock)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(drift, []rt.SchemaDrift{
+		{Table: PersonTableName, Detail: "missing column address_zip of address.zip"},
+		{Table: PersonTableName, Detail: "missing index idx_generatedtest_example_person__name"},
+		{Table: PersonTableName, Detail: "index " + personStaleIndex + " is not in the schema lock"},
+		{Table: PersonTableName, Detail: `schema hash "old" differs from the locked ` + strconv.Quote(PersonProjectionSchema)},
+		{Table: "other_table", Detail: "table is not in the schema lock"},
+	}))
+	assert.Check(t, is.Equal(drift[0].String(), PersonTableName+": missing column address_zip of address.zip"))
+}
+
+func TestGeneratedInitConcurrency(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "init.db")+"?_busy_timeout=5000&_journal_mode=WAL")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUDWithOptions(db, rt.Options{InitConcurrency: 4})
+	assert.NilError(t, crud.Init())
+	plan, err := crud.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, plan.Empty(), plan.String())
+	assert.NilError(t, crud.Init(), "Init stays idempotent")
+
+	var mu sync.Mutex
+	var running, peak int
+	locks := make(map[*rt.SchemaStateLock]bool)
+	table := func(name string, err error) rt.TableInit {
+		return rt.TableInit{Name: name, Init: func(schemaLock *rt.SchemaStateLock) error {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			locks[schemaLock] = true
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return err
+		}}
+	}
+	tables := []rt.TableInit{table("A", nil), table("B", errors.New("boom")), table("C", errors.New("bang")), table("D", nil)}
+	err = rt.InitTables(db, 2, tables)
+	assert.Check(t, is.ErrorContains(err, "init B table: boom"))
+	assert.Check(t, is.Equal(peak, 2))
+	assert.NilError(t, rt.InitTables(db, 2, tables[:1]))
+	assert.Check(t, is.Len(locks, 2), "each call has its own schema lock")
+	assert.Check(t, !locks[nil])
+
+	tx, err := db.Begin()
+	assert.NilError(t, err)
+	peak = 0
+	err = rt.InitTables(tx, 2, tables)
+	assert.Check(t, is.Error(err, "init B table: boom"), "a transaction initializes in order")
+	assert.Check(t, is.Equal(peak, 1))
+	assert.Check(t, locks[nil], "tables initialized in order need no schema lock")
+	assert.NilError(t, tx.Rollback())
+}
+
+func TestGeneratedSchemaSQLMatchesInit(t *testing.T) {
+	schemaSQL, err := os.ReadFile("system.proprdb.sql")
+	assert.NilError(t, err)
+	db, err := sql.Open("sqlite3", "file:crud-schema-sql?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	_, err = db.Exec(string(schemaSQL))
+	assert.NilError(t, err)
+
+	crud := NewCRUD(db)
+	plan, err := crud.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, plan.Empty(), plan.String())
+	assert.NilError(t, crud.Init())
+	_, err = crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+}
+
+// prefixedIDs is an IDGenerator using "person-<n>" ids.
+type prefixedIDs struct {
+	next int
+}
+
+func (p *prefixedIDs) NewID() (string, error) {
+	p.next++
+	return "person-" + strconv.Itoa(p.next), nil
+}
+
+func (p *prefixedIDs) ValidateID(id string) error {
+	if !strings.HasPrefix(id, "person-") {
+		return rt.InvalidIDError(errors.New("missing person- prefix"))
+	}
+	return nil
+}
+
+func TestGeneratedIDGeneratorAndClock(t *testing.T) {
+	opts := rt.Options{
+		IDGenerator: &rt.SequentialUUIDs{},
+		Clock:       &rt.StepClock{Start: 1000, Step: 10},
+	}
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "deterministic.db")), opts)
+	assert.NilError(t, crud.Init())
+
+	first, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(first.ID, "00000000-0000-7000-8000-000000000001"))
+	assert.Check(t, is.Equal(first.AtNs, int64(1000)))
+	second, err := crud.Person.Insert(&Person{Name: "Grace", Age: 40})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(second.ID, "00000000-0000-7000-8000-000000000002"))
+	assert.Check(t, is.Equal(second.AtNs, int64(1010)))
+	assert.NilError(t, crud.Person.DeleteByID(first.ID))
+	tombstones, err := rt.ListTombstones(crud.Person.q, PersonTableName)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(tombstones, 1))
+	assert.Check(t, is.Equal(tombstones[0].AtNs, int64(1020)))
+
+	memCRUD := NewMemCRUDWithOptions(rt.Options{IDGenerator: &rt.SequentialUUIDs{}, Clock: rt.ClockFunc(func() int64 { return 5 })})
+	memRow, err := memCRUD.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(memRow.ID, first.ID))
+	assert.Check(t, is.Equal(memRow.AtNs, int64(5)))
+
+	prefixed := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "prefixed.db")), rt.Options{IDGenerator: &prefixedIDs{}})
+	assert.NilError(t, prefixed.Init())
+	row, err := prefixed.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(row.ID, "person-1"))
+	assert.Check(t, is.Equal(prefixed.Person.MustGetByID("person-1").Data.GetName(), "Ada"))
+	_, err = prefixed.Person.UpdateByID("00000000-0000-7000-8000-000000000001", &Person{Name: "Ada"})
+	assert.Check(t, errors.Is(err, rt.ErrInvalidID))
+}
+
+func TestGeneratedIDFormats(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "idformat.db")))
+	assert.NilError(t, crud.Init())
+
+	ticket, err := crud.Ticket.Insert(&Ticket{Subject: "printer on fire"})
+	assert.NilError(t, err)
+	assert.NilError(t, rt.ValidateULID(ticket.ID))
+	assert.Check(t, rt.ValidateUUID(ticket.ID) != nil)
+	_, err = crud.Ticket.UpdateByID("00000000-0000-7000-8000-000000000001", &Ticket{Subject: "uuid"})
+	assert.Check(t, errors.Is(err, rt.ErrInvalidID))
+	_, err = crud.Ticket.UpdateByID(ticket.ID, &Ticket{Subject: "printer fixed"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(crud.Ticket.MustGetByID(ticket.ID).Data.GetSubject(), "printer fixed"))
+
+	_, err = crud.Sku.Insert(&Sku{Name: "widget"})
+	assert.Check(t, errors.Is(err, rt.ErrNoIDGenerator))
+	sku, err := crud.Sku.InsertWithID("WIDGET-42", &Sku{Name: "widget"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(crud.Sku.MustGetByID(sku.ID).Data.GetName(), "widget"))
+	_, err = crud.Sku.InsertWithID("", &Sku{Name: "nameless"})
+	assert.Check(t, errors.Is(err, rt.ErrEmptyID))
+
+	// An explicit IDGenerator takes precedence over the id format.
+	sequential := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "sequential.db")), rt.Options{IDGenerator: &rt.SequentialUUIDs{}})
+	assert.NilError(t, sequential.Init())
+	sku, err = sequential.Sku.Insert(&Sku{Name: "gadget"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(sku.ID, "00000000-0000-7000-8000-000000000001"))
+
+	memTicket, err := NewMemCRUD().Ticket.Insert(&Ticket{Subject: "memory"})
+	assert.NilError(t, err)
+	assert.NilError(t, rt.ValidateULID(memTicket.ID))
+}
+
+func TestGeneratedCustomTableAndColumnNames(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "names.db"))
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	assert.Check(t, is.Equal(TicketTableName, "tickets"))
+
+	ticket, err := crud.Ticket.Insert(&Ticket{Subject: "printer on fire"})
+	assert.NilError(t, err)
+	var subject string
+	assert.NilError(t, db.QueryRow(`SELECT subject_line FROM tickets WHERE id = ?`, ticket.ID).Scan(&subject))
+	assert.Check(t, is.Equal(subject, "printer on fire"))
+	rows, err := crud.Ticket.Select(`subject_line = ?`, "printer on fire")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, ticket.ID))
+	plan, err := crud.Ticket.Explain(`subject_line = ?`, "printer on fire")
+	assert.NilError(t, err)
+	assert.Check(t, is.Contains(plan.String(), "idx_tickets__subject_line"))
+}
+
+func invoiceNumbers(t *testing.T, invoices *InvoiceTable, where string, args ...any) []string {
+	t.Helper()
+
+	rows, err := invoices.Select(where, args...)
+	assert.NilError(t, err)
+	numbers := make([]string, 0, len(rows))
+	for _, row := range rows {
+		numbers = append(numbers, row.Data.GetNumber())
+	}
+	return numbers
+}
+
+func TestGeneratedTenants(t *testing.T) {
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "tenants.db")), rt.Options{
+		Cache:         rt.CacheOptions{Size: 16},
+		RequireTenant: true,
+		SyncPolicy: rt.SyncPolicy{Remotes: map[string]rt.RemoteSyncFilter{
+			"acme-only": {Tenants: []string{"acme"}},
+		}},
+	})
+	assert.NilError(t, crud.Init())
+	acme := crud.Invoice.ForTenant("acme")
+	globex := crud.Invoice.ForTenant("globex")
+
+	acmeInvoice, err := acme.Insert(&Invoice{Org: "acme", Number: "A-1"})
+	assert.NilError(t, err)
+	_, err = acme.Insert(&Invoice{Org: "acme", Number: "A-2"})
+	assert.NilError(t, err)
+	globexInvoice, err := globex.Insert(&Invoice{Org: "globex", Number: "G-1"})
+	assert.NilError(t, err)
+	_, err = acme.Insert(&Invoice{Org: "globex", Number: "G-2"})
+	assert.Check(t, errors.Is(err, rt.ErrTenantMismatch))
+
+	assert.Check(t, is.DeepEqual(invoiceNumbers(t, acme, ""), []string{"A-1", "A-2"}))
+	assert.Check(t, is.DeepEqual(invoiceNumbers(t, acme, "number = ? OR number = ?", "A-2", "G-1"), []string{"A-2"}))
+	assert.Check(t, is.DeepEqual(invoiceNumbers(t, globex, ""), []string{"G-1"}))
+	assert.Check(t, is.Equal(globex.MustGetByID(globexInvoice.ID).Data.GetNumber(), "G-1"))
+	_, err = acme.GetByID(globexInvoice.ID)
+	assert.Check(t, errors.Is(err, rt.ErrNotFound), "cached rows of other tenants stay hidden")
+
+	_, err = globex.UpdateByID(acmeInvoice.ID, &Invoice{Org: "globex", Number: "stolen"})
+	assert.Check(t, errors.Is(err, rt.ErrTenantMismatch))
+	assert.Check(t, errors.Is(globex.DeleteByID(acmeInvoice.ID), rt.ErrTenantMismatch))
+	_, err = acme.UpdateByID(acmeInvoice.ID, &Invoice{Org: "acme", Number: "A-1b"})
+	assert.NilError(t, err)
+
+	_, err = crud.Invoice.Select("")
+	assert.Check(t, errors.Is(err, rt.ErrTenantRequired))
+	_, err = crud.Invoice.GetByID(acmeInvoice.ID)
+	assert.Check(t, errors.Is(err, rt.ErrTenantRequired))
+
+	exportedIDs := func(remote string) []string {
+		t.Helper()
+		var buffer bytes.Buffer
+		assert.NilError(t, crud.WriteJSONL(remote, &buffer))
+		ids := make([]string, 0)
+		assert.NilError(t, rt.ReadJSONL(&buffer, func(record rt.JSONLRecord, _ int) error {
+			ids = append(ids, record.ID)
+			return nil
+		}))
+		return ids
+	}
+	assert.Check(t, is.Contains(exportedIDs("everyone"), globexInvoice.ID))
+	acmeIDs := exportedIDs("acme-only")
+	assert.Check(t, is.Contains(acmeIDs, acmeInvoice.ID))
+	assert.Check(t, !slices.Contains(acmeIDs, globexInvoice.ID))
+
+	var snapshot bytes.Buffer
+	assert.NilError(t, crud.WriteSnapshot(&snapshot))
+	restored := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "tenants-restored.db")))
+	assert.NilError(t, restored.Init())
+	assert.NilError(t, restored.ReadSnapshot(&snapshot))
+	assert.Check(t, is.Len(invoiceNumbers(t, restored.Invoice, ""), 3))
+}
+
+func checkPageHistory(t *testing.T, pages PageStore) {
+	t.Helper()
+
+	created, err := pages.Insert(&Page{})
+	assert.NilError(t, err)
+	renamed, err := pages.UpdateByID(created.ID, &Page{Title: "Renamed"})
+	assert.NilError(t, err)
+	assert.NilError(t, pages.DeleteByID(created.ID))
+
+	versions, err := pages.History(created.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(versions, 3))
+	assert.Check(t, is.Equal(versions[0].AtNs, created.AtNs))
+	assert.Check(t, versions[0].Data != nil, "empty messages are versions, not deletions")
+	assert.Check(t, is.Equal(versions[1].Data.GetTitle(), "Renamed"))
+	assert.Check(t, versions[2].AtNs > renamed.AtNs)
+	assert.Check(t, versions[2].Data == nil)
+
+	_, err = pages.GetAsOf(created.ID, created.AtNs-1)
+	assert.Check(t, errors.Is(err, rt.ErrNotFound))
+	asOf, err := pages.GetAsOf(created.ID, created.AtNs)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(asOf.Data.GetTitle(), ""))
+	asOf, err = pages.GetAsOf(created.ID, versions[2].AtNs-1)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(asOf.AtNs, renamed.AtNs))
+	assert.Check(t, is.Equal(asOf.Data.GetTitle(), "Renamed"))
+	_, err = pages.GetAsOf(created.ID, versions[2].AtNs)
+	assert.Check(t, errors.Is(err, rt.ErrNotFound))
+}
+
+func TestGeneratedHistory(t *testing.T) {
+	clock := &rt.StepClock{Start: 1000, Step: 10}
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "history.db")), rt.Options{Clock: clock})
+	assert.NilError(t, crud.Init())
+	checkPageHistory(t, crud.PageStore())
+	checkPageHistory(t, NewMemCRUDWithOptions(rt.Options{Clock: &rt.StepClock{Start: 1000, Step: 10}}).PageStore())
+
+	// Versions received through sync are recorded too.
+	page, err := crud.Page.Insert(&Page{Title: "Local"})
+	assert.NilError(t, err)
+	var buffer bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL(testRemoteA, &buffer))
+	replica := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "history-replica.db")))
+	assert.NilError(t, replica.Init())
+	assert.NilError(t, replica.ReadJSONL(testRemoteA, &buffer))
+	versions, err := replica.Page.History(page.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(versions, 1))
+	assert.Check(t, is.Equal(versions[0].AtNs, page.AtNs))
+
+	plan, err := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "history-plan.db"))).PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, is.Contains(plan.String(), "create table "+PageHistoryTableName))
+}
+
+func checkPageRevert(t *testing.T, pages PageStore) {
+	t.Helper()
+
+	draft, err := pages.Insert(&Page{Title: "Draft"})
+	assert.NilError(t, err)
+	published, err := pages.UpdateByID(draft.ID, &Page{Title: "Published"})
+	assert.NilError(t, err)
+	_, err = pages.UpdateByID(draft.ID, &Page{Title: "Vandalized"})
+	assert.NilError(t, err)
+
+	assert.NilError(t, pages.RevertTo(draft.ID, published.AtNs))
+	current := pages.MustGetByID(draft.ID)
+	assert.Check(t, is.Equal(current.Data.GetTitle(), "Published"))
+	assert.Check(t, current.AtNs > published.AtNs, "reverts are new writes")
+	assert.NilError(t, pages.RevertTo(draft.ID, published.AtNs))
+	versions, err := pages.History(draft.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(versions, 4), "reverting to an equal version writes nothing")
+
+	// Undo the revert by reverting to the version it replaced.
+	assert.NilError(t, pages.RevertTo(draft.ID, versions[2].AtNs))
+	assert.Check(t, is.Equal(pages.MustGetByID(draft.ID).Data.GetTitle(), "Vandalized"))
+
+	assert.NilError(t, pages.RevertTo(draft.ID, draft.AtNs-1))
+	_, err = pages.GetByID(draft.ID)
+	assert.Check(t, errors.Is(err, rt.ErrNotFound))
+
+	kept, err := pages.Insert(&Page{Title: "Kept"})
+	assert.NilError(t, err)
+	_, err = pages.UpdateByID(kept.ID, &Page{Title: "Changed"})
+	assert.NilError(t, err)
+	assert.NilError(t, pages.DeleteByID(draft.ID))
+	added, err := pages.Insert(&Page{Title: "Added"})
+	assert.NilError(t, err)
+	reverted, err := pages.RevertTableTo(kept.AtNs)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(reverted, int64(2)))
+	assert.Check(t, is.Equal(pages.MustGetByID(kept.ID).Data.GetTitle(), "Kept"))
+	_, err = pages.GetByID(added.ID)
+	assert.Check(t, errors.Is(err, rt.ErrNotFound))
+}
+
+func TestGeneratedRevert(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "revert.db")))
+	assert.NilError(t, crud.Init())
+	checkPageRevert(t, crud.PageStore())
+	checkPageRevert(t, NewMemCRUD().PageStore())
+
+	// A revert syncs as the newest version, overriding the reverted one on replicas.
+	replica := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "revert-replica.db")))
+	assert.NilError(t, replica.Init())
+	exportTo := func(t *testing.T, source, target *CRUD) {
+		t.Helper()
+		var buffer bytes.Buffer
+		assert.NilError(t, source.WriteJSONL(testRemoteA, &buffer))
+		assert.NilError(t, target.ReadJSONL(testRemoteA, &buffer))
+	}
+	page, err := crud.Page.Insert(&Page{Title: "Good"})
+	assert.NilError(t, err)
+	_, err = crud.Page.UpdateByID(page.ID, &Page{Title: "Bad"})
+	assert.NilError(t, err)
+	exportTo(t, crud, replica)
+	assert.Check(t, is.Equal(replica.Page.MustGetByID(page.ID).Data.GetTitle(), "Bad"))
+	assert.NilError(t, crud.Page.RevertTo(page.ID, page.AtNs))
+	exportTo(t, crud, replica)
+	assert.Check(t, is.Equal(replica.Page.MustGetByID(page.ID).Data.GetTitle(), "Good"))
+}
+
+func TestGeneratedReprojectTable(t *testing.T) {
+	ctx := context.Background()
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "reproject.db"))
+	people := NewPersonTable(db)
+	assert.NilError(t, people.Init())
+	for age := range 5 {
+		_, err := people.Insert(&Person{Name: "P" + strconv.Itoa(age), Age: int64(20 + age)})
+		assert.NilError(t, err)
+	}
+	_, err := db.ExecContext(ctx, `UPDATE "`+PersonTableName+`" SET "age" = 0`)
+	assert.NilError(t, err)
+	_, err = db.ExecContext(ctx, `UPDATE _proprdb_schema SET schema_hash = 'old' WHERE table_name = ?`, PersonTableName)
+	assert.NilError(t, err)
+
+	deferred := NewPersonTableWithOptions(db, rt.Options{DeferReprojection: true})
+	assert.NilError(t, deferred.Init())
+	stale, err := deferred.Select("age = 0")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(stale, 5), "Init leaves projections to ReprojectTable")
+	plan, err := deferred.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, plan.RebuildProjections)
+
+	// Interrupt the rebuild after its first batch.
+	interrupted, cancel := context.WithCancel(ctx)
+	progress := make([][2]int64, 0)
+	err = deferred.ReprojectTable(interrupted, 2, func(done, total int64) {
+		progress = append(progress, [2]int64{done, total})
+		cancel()
+	})
+	assert.Check(t, errors.Is(err, context.Canceled))
+	stale, err = deferred.Select("age = 0")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(stale, 3))
+
+	err = deferred.ReprojectTable(ctx, 2, func(done, total int64) {
+		progress = append(progress, [2]int64{done, total})
+	})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(progress, [][2]int64{{2, 5}, {4, 5}, {5, 5}}))
+	stale, err = deferred.Select("age = 0")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(stale, 0))
+	plan, err = deferred.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, plan.Empty(), plan.String())
+	var cursors int
+	assert.NilError(t, db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+rt.ReprojectStateTableName).Scan(&cursors))
+	assert.Check(t, is.Equal(cursors, 0))
+}
+
+func TestGeneratedOnSchemaChange(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "hooks.db"))
+	type call struct{ OldHash, NewHash string }
+	var calls []call
+	backfill := func(oldHash, newHash string, tx DBTX) error {
+		calls = append(calls, call{oldHash, newHash})
+		people := NewPersonTable(tx)
+		rows, err := people.Select("age = 0")
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			row.Data.Age = 42
+			if _, err := people.UpdateByID(row.ID, row.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	crud := NewCRUD(db)
+	crud.Person.OnSchemaChange(backfill)
+	assert.NilError(t, crud.Init())
+	assert.Check(t, is.Len(calls, 0), "new tables have nothing to migrate")
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	_, err = db.Exec(`UPDATE _proprdb_schema SET schema_hash = 'old' WHERE table_name = ?`, PersonTableName)
+	assert.NilError(t, err)
+
+	failing := NewCRUD(db)
+	failing.Person.OnSchemaChange(backfill)
+	failing.Person.OnSchemaChange(func(string, string, DBTX) error { return errors.New("boom") })
+	assert.Check(t, is.ErrorContains(failing.Init(), "boom"))
+	assert.Check(t, is.Equal(crud.Person.MustGetByID(ada.ID).Data.GetAge(), int64(0)), "a failing hook rolls back")
+	plan, err := failing.Person.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, plan.RebuildProjections, "a failing hook leaves the schema hash")
+
+	calls = nil
+	upgraded := NewCRUD(db)
+	upgraded.Person.OnSchemaChange(backfill)
+	assert.NilError(t, upgraded.Init())
+	assert.Check(t, is.DeepEqual(calls, []call{{"old", PersonProjectionSchema}}))
+	aged, err := upgraded.Person.Select("age = ?", 42)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(aged, 1))
+	assert.NilError(t, upgraded.Init())
+	assert.Check(t, is.Len(calls, 1), "hooks run once per schema change")
+}
+
+func TestGeneratedDeclarativeMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "migrations.db"))
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	old, err := crud.Sku.InsertWithID("sku-1", &Sku{Name: "Widget"})
+	assert.NilError(t, err)
+	kept, err := crud.Sku.InsertWithID("sku-2", &Sku{Name: "Gadget", Title: "Kept", Category: "tools"})
+	assert.NilError(t, err)
+	// Roll the table back to before the rename and the migrations.
+	_, err = db.ExecContext(ctx, `ALTER TABLE "`+SkuTableName+`" RENAME COLUMN "title" TO "display_label"`)
+	assert.NilError(t, err)
+	_, err = db.ExecContext(ctx, `UPDATE _proprdb_schema SET schema_hash = 'old' WHERE table_name = ?`, SkuTableName)
+	assert.NilError(t, err)
+
+	var hooked []string
+	upgraded := NewCRUD(db)
+	upgraded.Sku.OnSchemaChange(func(_, _ string, tx DBTX) error {
+		rows, err := NewSkuTable(tx).Select("")
+		for _, row := range rows {
+			hooked = append(hooked, row.Data.GetTitle())
+		}
+		return err
+	})
+	assert.NilError(t, upgraded.Init())
+	assert.Check(t, is.DeepEqual(hooked, []string{"Widget", "Kept"}), "migrations run before the hooks")
+	migrated := upgraded.Sku.MustGetByID(old.ID)
+	assert.Check(t, is.Equal(migrated.Data.GetTitle(), "Widget"))
+	assert.Check(t, is.Equal(migrated.Data.GetCategory(), "general"))
+	assert.Check(t, is.Equal(migrated.AtNs, old.AtNs), "migrations do not sync")
+	untouched := upgraded.Sku.MustGetByID(kept.ID)
+	assert.Check(t, is.Equal(untouched.Data.GetTitle(), "Kept"))
+	assert.Check(t, is.Equal(untouched.Data.GetCategory(), "tools"))
+	byTitle, err := upgraded.Sku.Select("title = ?", "Widget")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(byTitle, 1), "the renamed column is reprojected")
+
+	record := `{"id":"sku-3","atNs":100,"data":{"@type":"` + typeURLPrefix + SkuTypeName + `","name":"Old","displayLabel":"Labelled"}}` + "\n"
+	assert.NilError(t, upgraded.ReadJSONL(testRemoteA, strings.NewReader(record)))
+	assert.Check(t, is.Equal(upgraded.Sku.MustGetByID("sku-3").Data.GetTitle(), "Labelled"), "records of old peers use the old name")
+	record = `{"id":"sku-4","atNs":100,"data":{"@type":"` + typeURLPrefix + SkuTypeName + `","name":"Synced"}}` + "\n"
+	assert.NilError(t, upgraded.ReadJSONL(testRemoteA, strings.NewReader(record)))
+	synced := upgraded.Sku.MustGetByID("sku-4")
+	assert.Check(t, is.Equal(synced.Data.GetTitle(), "Synced"), "records of old peers are migrated")
+	assert.Check(t, is.Equal(synced.Data.GetCategory(), "general"))
+	record = `{"id":"sku-5","atNs":100,"data":{"@type":"` + typeURLPrefix + SkuTypeName + `","name":"Bulk"}}` + "\n"
+	assert.NilError(t, upgraded.ReadJSONLBulk(testRemoteA, strings.NewReader(record), 0))
+	assert.Check(t, is.Equal(upgraded.Sku.MustGetByID("sku-5").Data.GetTitle(), "Bulk"))
+
+	changed, err := rt.ApplyFieldMigrations(&Sku{}, []rt.FieldMigration{{Field: "category", DefaultJSON: "1"}})
+	assert.Check(t, is.ErrorContains(err, "default value of category"))
+	assert.Check(t, !changed)
+}
+
+func TestGeneratedReaderSplit(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "split.db")
+	writer := openCLITestDB(t, path)
+	writer.SetMaxOpenConns(1)
+	assert.NilError(t, NewCRUDWithOptions(writer, rt.Options{SQLite: rt.ConcurrentSQLite}).Init())
+	reader := openCLITestDB(t, "file:"+path+"?mode=ro")
+	crud := NewCRUDWithOptions(writer, rt.Options{Reader: reader})
+
+	ada, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	read, err := crud.Person.GetByID(ada.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(read.Data.GetName(), "Ada"))
+	page, err := crud.Page.Insert(&Page{Title: "Read from the pool"})
+	assert.NilError(t, err)
+	versions, err := crud.Page.History(page.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(versions, 1))
+
+	// A reader of another database shows which handle serves reads.
+	elsewhere := openCLITestDB(t, filepath.Join(t.TempDir(), "elsewhere.db"))
+	assert.NilError(t, NewCRUD(elsewhere).Init())
+	split := NewCRUDWithOptions(writer, rt.Options{Reader: elsewhere})
+	_, err = split.Person.GetByID(ada.ID)
+	assert.Check(t, errors.Is(err, rt.ErrNotFound), "reads use the reader")
+	err = split.WithTx(ctx, func(txCRUD *CRUD) error {
+		grace, err := txCRUD.Person.Insert(&Person{Name: "Grace", Age: 45})
+		if err != nil {
+			return err
+		}
+		rows, err := txCRUD.Person.GetManyByID([]string{ada.ID, grace.ID})
+		if err != nil {
+			return err
+		}
+		assert.Check(t, is.Len(rows, 2), "transactions read their own writes")
+		return nil
+	})
+	assert.NilError(t, err)
+}
+
+func TestGeneratedWriteCoordinator(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "coordinated.db"))
+	assert.NilError(t, NewCRUDWithOptions(db, rt.Options{SQLite: rt.ConcurrentSQLite}).Init())
+	existing, err := NewPersonTable(db).Insert(&Person{Name: "Existing", Age: 50})
+	assert.NilError(t, err)
+
+	// The batch only commits once it is full, so all writes share it.
+	coordinator := rt.NewWriteCoordinator(db, rt.WriteCoordinatorOptions{MaxBatch: 4, Interval: time.Hour})
+	crud := NewCRUDWithOptions(db, rt.Options{WriteCoordinator: coordinator})
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range 3 {
+		wg.Go(func() {
+			_, errs[i] = crud.Person.Insert(&Person{Name: "P" + strconv.Itoa(i), Age: int64(i)})
+		})
+	}
+	wg.Go(func() {
+		_, errs[3] = crud.Person.InsertWithID(existing.ID, &Person{Name: "Duplicate", Age: 1})
+	})
+	wg.Wait()
+	assert.Check(t, errs[0] == nil && errs[1] == nil && errs[2] == nil, "%v", errs)
+	assert.Check(t, errs[3] != nil, "a failing write only rolls back itself")
+	rows, err := crud.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 4))
+
+	assert.NilError(t, coordinator.Close())
+	_, err = crud.Person.Insert(&Person{Name: "Late", Age: 1})
+	assert.Check(t, errors.Is(err, rt.ErrWriteCoordinatorClosed))
+	err = crud.WithTx(context.Background(), func(txCRUD *CRUD) error {
+		_, err := txCRUD.Person.Insert(&Person{Name: "Transactional", Age: 1})
+		return err
+	})
+	assert.NilError(t, err, "WithTx bypasses the coordinator")
+}
+
+func TestGeneratedWriteCoordinatorRetriesBusy(t *testing.T) {
+	ctx := context.Background()
+	dsn := "file:" + filepath.Join(t.TempDir(), "coordinated-busy.db") + "?_busy_timeout=0"
+	db, err := sql.Open("sqlite3", dsn)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	lockerDB, err := sql.Open("sqlite3", dsn)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, lockerDB.Close())
+	})
+	assert.NilError(t, NewCRUD(db).Init())
+	coordinator := rt.NewWriteCoordinator(db, rt.WriteCoordinatorOptions{
+		MaxBatch: 1,
+		TxRetry:  rt.RetryPolicy{Attempts: 50, InitialBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond},
+	})
+	t.Cleanup(func() {
+		assert.NilError(t, coordinator.Close())
+	})
+
+	lockTx, err := lockerDB.BeginTx(ctx, nil)
+	assert.NilError(t, err)
+	_, err = lockTx.ExecContext(ctx, `INSERT INTO `+NoteTableName+` (id, at_ns, data, text) VALUES ('locker', 1, x'', '')`)
+	assert.NilError(t, err)
+	released := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		released <- lockTx.Commit()
+	}()
+
+	// The write hits the lock itself, so the batch is retried.
+	attempts := 0
+	err = coordinator.Do(ctx, func(tx DBTX) error {
+		attempts++
+		_, err := NewPersonTable(tx).Insert(&Person{Name: "Ada"})
+		return err
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, <-released)
+	assert.Check(t, attempts > 1, "attempts: %d", attempts)
+	rows, err := NewPersonTable(db).Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 1))
+}
+
+func TestGeneratedExplain(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "explain.db"))
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	plan, err := crud.Person.Explain("name = ?", "Ada")
+	assert.NilError(t, err)
+	assert.Check(t, !plan.FullScan, plan.String())
+	assert.Check(t, is.Len(plan.Suggestions, 0))
+
+	plan, err = crud.Person.Explain("age > ? AND address_zip <> 'age'", 30)
+	assert.NilError(t, err)
+	assert.Check(t, plan.FullScan, plan.String())
+	assert.Check(t, is.DeepEqual(plan.Suggestions, []string{"consider adding index on age", "consider adding index on address_zip"}))
+	assert.Check(t, is.Contains(plan.String(), "suggestion: consider adding index on age\n"))
+
+	plan, err = crud.Person.Explain("length(data) > ?", 10)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(plan.Suggestions, 1))
+	assert.Check(t, is.Contains(plan.Suggestions[0], "reads the data column"))
+
+	_, err = crud.Person.Explain("no_such_column = 1")
+	assert.ErrorContains(t, err, "no such column")
+}
+
+func TestGeneratedSelectByFields(t *testing.T) {
+	instrumentation := &recordingInstrumentation{}
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "fields.db")), rt.Options{Instrumentation: instrumentation})
+	assert.NilError(t, crud.Init())
+	tables := map[string]TaskStore{"sqlite": crud.TaskStore(), "memdb": NewMemCRUD().TaskStore()}
+	for name, table := range tables {
+		_, err := table.Insert(&Task{Title: "write", Done: true})
+		assert.NilError(t, err)
+		_, err = table.Insert(&Task{Title: "review"})
+		assert.NilError(t, err)
+		_, err = table.Insert(&Task{Title: "ship", Done: true})
+		assert.NilError(t, err)
+
+		// done is not projected.
+		rows, err := table.SelectByFields("done = ? AND title != ?", true, "ship")
+		assert.NilError(t, err, name)
+		assert.Check(t, is.Len(rows, 1), name)
+		assert.Check(t, is.Equal(rows[0].Data.GetTitle(), "write"), name)
+
+		_, err = table.SelectByFields("priority = ?", 1)
+		assert.Check(t, errors.Is(err, rt.ErrUnsupportedWhere), name)
+		assert.Check(t, is.ErrorContains(err, "has no field priority"), name)
+	}
+	assert.Check(t, is.Equal(instrumentation.counters[rt.MetricFieldScans], int64(1)))
+
+	_, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36, Address: &Person_Address{City: "London", Zip: 1234}})
+	assert.NilError(t, err)
+	rows, err := crud.Person.SelectByFields("address.zip >= ?", 1000)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 1))
+	_, err = crud.Person.SelectByFields("address = ?", "London")
+	assert.Check(t, is.ErrorContains(err, "is a message"))
+}
+
+func TestGeneratedSelectFilter(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "filter.db")))
+	assert.NilError(t, crud.Init())
+	for _, person := range []*Person{
+		{Name: "Ada", Age: 36, Address: &Person_Address{City: "London"}},
+		{Name: "Grace", Age: 45},
+		{Name: "Linus", Age: 17, Address: &Person_Address{City: "Helsinki"}},
+	} {
+		_, err := crud.Person.Insert(person)
+		assert.NilError(t, err)
+	}
+	names := func(filter string) []string {
+		t.Helper()
+		rows, err := crud.Person.SelectFilter(filter)
+		assert.NilError(t, err, filter)
+		result := make([]string, 0, len(rows))
+		for _, row := range rows {
+			result = append(result, row.Data.GetName())
+		}
+		slices.Sort(result)
+		return result
+	}
+	for filter, expected := range map[string][]string{
+		"":                                {"Ada", "Grace", "Linus"},
+		`age >= 18`:                       {"Ada", "Grace"},
+		`age >= 18 AND has(address.city)`: {"Ada"},
+		`age >= 18 NOT has(address.city)`: {"Grace"},
+		`age >= 18 address.city:*`:        {"Ada"},
+		`name = "Ada" OR name = 'Linus'`:  {"Ada", "Linus"},
+		`age < 40 AND name = Ada OR name = Grace`:   {"Ada"},
+		`(age < 40 AND name = Ada) OR name = Grace`: {"Ada", "Grace"},
+		`-name:Ada -(address.city = Helsinki)`:      {"Grace"},
+		`name != "Ada" age > 20`:                    {"Grace"},
+	} {
+		assert.Check(t, is.DeepEqual(names(filter), expected), filter)
+	}
+
+	for _, filter := range []string{
+		`nickname = Ada`,
+		`name`,
+		`age >= old`,
+		`name = "Ada`,
+		`(age > 1`,
+		`age > 1 AND`,
+		`name ! Ada`,
+	} {
+		_, err := crud.Person.SelectFilter(filter)
+		assert.Check(t, is.ErrorIs(err, rt.ErrInvalidFilter), filter)
+	}
+
+	_, err := crud.Event.Insert(&Event{Kind: "launch", OccurredAt: timestamppb.New(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))})
+	assert.NilError(t, err)
+	_, err = crud.Event.Insert(&Event{Kind: "undated"})
+	assert.NilError(t, err)
+	rows, err := crud.Event.SelectFilter(`occurred_at > "2024-01-01T00:00:00Z"`)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].Data.GetKind(), "launch"))
+	rows, err = crud.Event.SelectFilter(`NOT has(occurred_at)`)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].Data.GetKind(), "undated"))
+}
+
+func TestGeneratedSelectProjected(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "projected.db")))
+	assert.NilError(t, crud.Init())
+
+	ada, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36, Address: &Person_Address{City: "London", Zip: 1234}})
+	assert.NilError(t, err)
+	_, err = crud.Person.Insert(&Person{Name: "Bob", Age: 17})
+	assert.NilError(t, err)
+	people, err := crud.Person.SelectProjected("age >= ?", 18)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(people, []PersonProjectedRow{{ID: ada.ID, AtNs: ada.AtNs, Name: "Ada", Age: 36, AddressCity: "London", AddressZip: 1234}}))
+
+	base := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	event, err := crud.Event.Insert(&Event{Kind: "set", OccurredAt: timestamppb.New(base), ExpiresAt: timestamppb.New(base.Add(time.Hour))})
+	assert.NilError(t, err)
+	_, err = crud.Event.Insert(&Event{Kind: "unset"})
+	assert.NilError(t, err)
+	events, err := crud.Event.SelectProjected("kind = ?", "set")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(events, 1))
+	assert.Check(t, is.Equal(events[0].ID, event.ID))
+	assert.Check(t, events[0].OccurredAt.Equal(base))
+	assert.Check(t, events[0].ExpiresAt.Equal(base.Add(time.Hour)))
+	events, err = crud.Event.SelectProjected("kind = ?", "unset")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(events, 1))
+	assert.Check(t, events[0].OccurredAt == nil)
+	assert.Check(t, events[0].ExpiresAt == nil)
+}
+
+func TestGeneratedSelectLazy(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "lazy.db"))
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	ada, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	bob, err := crud.Person.Insert(&Person{Name: "Bob", Age: 17})
+	assert.NilError(t, err)
+	_, err = db.ExecContext(context.Background(), `UPDATE `+PersonTableName+` SET data = X'ff' WHERE id = ?`, bob.ID)
+	assert.NilError(t, err)
+
+	// Select decodes every row, so the broken row fails the whole call.
+	_, err = crud.Person.Select("")
+	assert.Check(t, err != nil)
+
+	rows, err := crud.Person.SelectLazyWithOptions(rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: "name"}}}, "")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 2))
+	assert.Check(t, is.Equal(rows[0].ID, ada.ID))
+	data, err := rows[0].Data()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(data.GetName(), "Ada"))
+	again, err := rows[0].Data()
+	assert.NilError(t, err)
+	assert.Check(t, data == again)
+
+	assert.Check(t, is.Equal(rows[1].ID, bob.ID))
+	_, err = rows[1].Data()
+	assert.Check(t, is.ErrorContains(err, "unmarshal Person row"))
+}
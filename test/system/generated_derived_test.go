@@ -0,0 +1,136 @@
+package genexample
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+// taskTally keeps the number of tasks in a Tally row, one change at a time.
+func taskTally(version string) rt.DerivedTable {
+	return rt.DerivedTable{
+		Name:    TallyTableName,
+		Sources: []string{TaskTableName},
+		Version: version,
+		Reduce: func(ctx context.Context, q rt.DBTX, change rt.Change) error {
+			delta := int64(0)
+			switch change.Op {
+			case rt.ChangeInsert:
+				delta = 1
+			case rt.ChangeDelete:
+				delta = -1
+			}
+			return bumpTaskTally(NewCRUD(q).Tally, delta)
+		},
+		Rebuild: func(ctx context.Context, q rt.DBTX) error {
+			var count int64
+			if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+TaskTableName).Scan(&count); err != nil {
+				return err
+			}
+			tallies := NewCRUD(q).Tally
+			rows, err := tallies.Select("")
+			if err != nil {
+				return err
+			}
+			for _, row := range rows {
+				if err := tallies.DeleteByID(row.ID); err != nil {
+					return err
+				}
+			}
+			_, err = tallies.Insert(&Tally{Name: "tasks", HighScore: count})
+			return err
+		},
+	}
+}
+
+func bumpTaskTally(tallies *TallyTable, delta int64) error {
+	rows, err := tallies.Select("")
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		_, err := tallies.Insert(&Tally{Name: "tasks", HighScore: delta})
+		return err
+	}
+	rows[0].Data.HighScore += delta
+	_, err = tallies.UpdateRow(rows[0])
+	return err
+}
+
+func taskCount(t *testing.T, crud *CRUD) int64 {
+	t.Helper()
+	rows, err := crud.Tally.Select("")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	return rows[0].Data.HighScore
+}
+
+func TestGeneratedDerivedTables(t *testing.T) {
+	ctx := context.Background()
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "derived.db"))
+	plain := NewCRUD(db)
+	assert.NilError(t, plain.Init())
+	_, err := plain.Task.Insert(&Task{Title: "before"})
+	assert.NilError(t, err)
+	_, err = plain.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+
+	adults := rt.DerivedTable{
+		Name:    "adult_count",
+		Sources: []string{PersonTableName},
+		Query:   `SELECT COUNT(*) AS adults FROM ` + PersonTableName + ` WHERE age >= 18`,
+	}
+	crud := NewCRUDWithOptions(db, rt.Options{DerivedTables: []rt.DerivedTable{adults, taskTally("1")}})
+	assert.NilError(t, crud.Init())
+	adultCount := func() int64 {
+		var count int64
+		assert.NilError(t, db.QueryRow(`SELECT adults FROM adult_count`).Scan(&count))
+		return count
+	}
+	assert.Check(t, is.Equal(adultCount(), int64(1)), "Init materializes the query")
+	assert.Check(t, is.Equal(taskCount(t, crud), int64(1)), "Init rebuilds new reduce tables")
+
+	_, err = crud.Person.Insert(&Person{Name: "Grace", Age: 85})
+	assert.NilError(t, err)
+	_, err = crud.Person.Insert(&Person{Name: "Kid", Age: 9})
+	assert.NilError(t, err)
+	task, err := crud.Task.Insert(&Task{Title: "write"})
+	assert.NilError(t, err)
+	_, err = crud.Task.Insert(&Task{Title: "review"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Task.DeleteByID(task.ID))
+	assert.Check(t, is.Equal(adultCount(), int64(1)), "derived tables change on refresh only")
+
+	applied, err := crud.RefreshDerived(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(applied, int64(5)), "2 person changes and 3 task changes")
+	assert.Check(t, is.Equal(adultCount(), int64(2)))
+	assert.Check(t, is.Equal(taskCount(t, crud), int64(2)))
+
+	applied, err = crud.RefreshDerived(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(applied, int64(0)))
+
+	// Tally writes made by the reducer are not task changes, and an
+	// unchanged definition keeps the table on the next Init.
+	reopened := NewCRUDWithOptions(db, rt.Options{DerivedTables: []rt.DerivedTable{adults, taskTally("1")}})
+	assert.NilError(t, reopened.Init())
+	assert.Check(t, is.Equal(taskCount(t, reopened), int64(2)))
+	checkpoint, err := rt.OutboxCheckpoint(db, rt.DerivedConsumerPrefix+TallyTableName)
+	assert.NilError(t, err)
+	assert.Check(t, checkpoint > 0)
+
+	// A new version rebuilds the table from scratch.
+	_, err = db.Exec(`DELETE FROM ` + TaskTableName)
+	assert.NilError(t, err)
+	rebuilt := NewCRUDWithOptions(db, rt.Options{DerivedTables: []rt.DerivedTable{taskTally("2")}})
+	assert.NilError(t, rebuilt.Init())
+	assert.Check(t, is.Equal(taskCount(t, rebuilt), int64(0)))
+
+	bad := NewCRUDWithOptions(db, rt.Options{DerivedTables: []rt.DerivedTable{{Name: "nothing", Sources: []string{PersonTableName}}}})
+	assert.Check(t, is.ErrorContains(bad.Init(), "neither Query nor Reduce"))
+}
@@ -0,0 +1,308 @@
+package genexample
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fingon/proprdb/rt/export"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestGeneratedParquetExport(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:export-parquet?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	ada, err := crud.Person.Insert(&Person{Name: "Ada", Age: 37, Address: &Person_Address{City: "London", Zip: 1}})
+	assert.NilError(t, err)
+	grace, err := crud.Person.Insert(&Person{Name: "Grace", Age: 85})
+	assert.NilError(t, err)
+	occurredAt := time.Unix(1700000000, 5).UTC()
+	timed, err := crud.Event.Insert(&Event{Kind: "timed", OccurredAt: timestamppb.New(occurredAt)})
+	assert.NilError(t, err)
+	untimed, err := crud.Event.Insert(&Event{Kind: "untimed"})
+	assert.NilError(t, err)
+	_, err = crud.Archive.Insert(&Archive{Label: "kept"})
+	assert.NilError(t, err)
+	removed, err := crud.Archive.Insert(&Archive{Label: "removed"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Archive.DeleteByID(removed.ID))
+
+	var out bytes.Buffer
+	rows, err := export.WriteParquet(db, &out, export.Table{Schema: PersonTableSchema, Type: (&Person{}).ProtoReflect().Type()}, export.Options{RowGroupRows: 1})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(rows, int64(2)))
+	file := readParquet(t, out.Bytes())
+	assert.Check(t, is.DeepEqual(file.columns, []string{"id", "at_ns", "name", "age", "address_city", "address_zip", "data"}))
+	assert.Check(t, is.Equal(file.rows, int64(2)))
+	assert.Check(t, is.Equal(len(file.groups), 2))
+	assert.Check(t, is.Equal(file.metadata["proprdb.type_name"], PersonTypeName))
+	ids := file.byteArrays(t, "id")
+	assert.Check(t, is.DeepEqual(byID(ids, file.byteArrays(t, "name")), map[string]string{ada.ID: "Ada", grace.ID: "Grace"}))
+	assert.Check(t, is.DeepEqual(byID(ids, file.byteArrays(t, "address_city")), map[string]string{ada.ID: "London", grace.ID: ""}))
+	assert.Check(t, is.DeepEqual(byID(ids, file.int64s(t, "age")), map[string]int64{ada.ID: 37, grace.ID: 85}))
+	assert.Check(t, is.DeepEqual(byID(ids, file.int64s(t, "at_ns")), map[string]int64{ada.ID: ada.AtNs, grace.ID: grace.AtNs}))
+	var exported Person
+	assert.NilError(t, protojson.Unmarshal([]byte(byID(ids, file.byteArrays(t, "data"))[ada.ID]), &exported))
+	assert.Check(t, is.Equal(exported.GetAddress().GetCity(), "London"))
+	assert.Check(t, is.Equal(exported.GetAge(), int64(37)))
+
+	dir := t.TempDir()
+	tables := []export.Table{
+		{Schema: EventTableSchema, Type: (&Event{}).ProtoReflect().Type()},
+		{Schema: ArchiveTableSchema, Type: (&Archive{}).ProtoReflect().Type()},
+	}
+	assert.NilError(t, export.WriteParquetFiles(db, dir, tables, export.Options{Gzip: true}))
+
+	eventFile := readParquet(t, readFile(t, filepath.Join(dir, EventTableName+export.FileExtension)))
+	assert.Check(t, is.DeepEqual(eventFile.columns, []string{"id", "at_ns", "kind", "occurred_at", "expires_at", "data"}))
+	eventIDs := eventFile.byteArrays(t, "id")
+	assert.Check(t, is.DeepEqual(byID(eventIDs, eventFile.byteArrays(t, "kind")), map[string]string{timed.ID: "timed", untimed.ID: "untimed"}))
+	assert.Check(t, is.DeepEqual(byID(eventIDs, eventFile.optionalInt64s(t, "occurred_at")), map[string]*int64{timed.ID: ptr(occurredAt.UnixNano()), untimed.ID: nil}))
+	assert.Check(t, is.DeepEqual(eventFile.optionalInt64s(t, "expires_at"), []*int64{nil, nil}))
+
+	archiveFile := readParquet(t, readFile(t, filepath.Join(dir, ArchiveTableName+export.FileExtension)))
+	assert.Check(t, is.Equal(archiveFile.rows, int64(1)))
+	assert.Check(t, is.DeepEqual(archiveFile.byteArrays(t, "label"), []string{"kept"}))
+
+	_, err = crud.Ticket.Insert(&Ticket{Subject: "printer on fire"})
+	assert.NilError(t, err)
+	out.Reset()
+	_, err = export.WriteParquet(db, &out, export.Table{Schema: TicketTableSchema, Type: (&Ticket{}).ProtoReflect().Type()}, export.Options{})
+	assert.NilError(t, err)
+	ticketFile := readParquet(t, out.Bytes())
+	assert.Check(t, is.DeepEqual(ticketFile.columns, []string{"id", "at_ns", "subject_line", "data"}))
+	assert.Check(t, is.DeepEqual(ticketFile.byteArrays(t, "subject_line"), []string{"printer on fire"}))
+}
+
+// byID maps the ids of exported rows to the values of another column.
+func byID[V any](ids []string, values []V) map[string]V {
+	mapped := make(map[string]V, len(ids))
+	for index, id := range ids {
+		mapped[id] = values[index]
+	}
+	return mapped
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	return data
+}
+
+// parquetFile is what the test reads back from a Parquet file: the schema,
+// metadata and the raw PLAIN pages of each column.
+type parquetFile struct {
+	data     []byte
+	columns  []string
+	optional map[string]bool
+	rows     int64
+	groups   []map[int16]any
+	metadata map[string]string
+}
+
+func readParquet(t *testing.T, data []byte) parquetFile {
+	t.Helper()
+	assert.Assert(t, len(data) > 12)
+	assert.Equal(t, string(data[:4]), "PAR1")
+	assert.Equal(t, string(data[len(data)-4:]), "PAR1")
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := &compactReader{data: data[len(data)-8-footerLength : len(data)-8]}
+	meta := footer.structValue()
+	assert.Equal(t, footer.pos, footerLength)
+
+	file := parquetFile{data: data, optional: make(map[string]bool), rows: meta[3].(int64), metadata: make(map[string]string)}
+	for index, element := range meta[2].([]any) {
+		fields := element.(map[int16]any)
+		if index == 0 {
+			assert.Equal(t, fields[5].(int64), int64(len(meta[2].([]any))-1))
+			continue
+		}
+		name := string(fields[4].([]byte))
+		file.columns = append(file.columns, name)
+		file.optional[name] = fields[3].(int64) == 1
+	}
+	for _, group := range meta[4].([]any) {
+		file.groups = append(file.groups, group.(map[int16]any))
+	}
+	for _, pair := range meta[5].([]any) {
+		fields := pair.(map[int16]any)
+		file.metadata[string(fields[1].([]byte))] = string(fields[2].([]byte))
+	}
+	return file
+}
+
+// values returns, per row, the PLAIN value bytes of column, nil for NULL.
+func (f parquetFile) values(t *testing.T, column string, width func([]byte) int) [][]byte {
+	t.Helper()
+	index := -1
+	for candidate, name := range f.columns {
+		if name == column {
+			index = candidate
+		}
+	}
+	assert.Assert(t, index >= 0, column)
+	values := make([][]byte, 0)
+	for _, group := range f.groups {
+		chunk := group[1].([]any)[index].(map[int16]any)[3].(map[int16]any)
+		reader := &compactReader{data: f.data[chunk[9].(int64):]}
+		header := reader.structValue()
+		page := reader.data[reader.pos : reader.pos+int(header[3].(int64))]
+		if chunk[4].(int64) == 2 {
+			unzipped, err := gzip.NewReader(bytes.NewReader(page))
+			assert.NilError(t, err)
+			page, err = io.ReadAll(unzipped)
+			assert.NilError(t, err)
+		}
+		count := int(header[5].(map[int16]any)[1].(int64))
+		present := make([]bool, 0, count)
+		if f.optional[column] {
+			levelsLength := int(binary.LittleEndian.Uint32(page))
+			levels := &compactReader{data: page[4 : 4+levelsLength]}
+			for levels.pos < len(levels.data) {
+				run := int(levels.uvarint() >> 1)
+				value := levels.data[levels.pos]
+				levels.pos++
+				for range run {
+					present = append(present, value == 1)
+				}
+			}
+			page = page[4+levelsLength:]
+		} else {
+			for range count {
+				present = append(present, true)
+			}
+		}
+		assert.Equal(t, len(present), count)
+		for _, isPresent := range present {
+			if !isPresent {
+				values = append(values, nil)
+				continue
+			}
+			size := width(page)
+			values = append(values, page[:size])
+			page = page[size:]
+		}
+		assert.Equal(t, len(page), 0)
+	}
+	return values
+}
+
+func (f parquetFile) byteArrays(t *testing.T, column string) []string {
+	t.Helper()
+	strings := make([]string, 0)
+	for _, value := range f.values(t, column, func(page []byte) int { return 4 + int(binary.LittleEndian.Uint32(page)) }) {
+		strings = append(strings, string(value[4:]))
+	}
+	return strings
+}
+
+func (f parquetFile) optionalInt64s(t *testing.T, column string) []*int64 {
+	t.Helper()
+	integers := make([]*int64, 0)
+	for _, value := range f.values(t, column, func([]byte) int { return 8 }) {
+		if value == nil {
+			integers = append(integers, nil)
+			continue
+		}
+		integers = append(integers, ptr(int64(binary.LittleEndian.Uint64(value))))
+	}
+	return integers
+}
+
+func (f parquetFile) int64s(t *testing.T, column string) []int64 {
+	t.Helper()
+	integers := make([]int64, 0)
+	for _, value := range f.optionalInt64s(t, column) {
+		integers = append(integers, *value)
+	}
+	return integers
+}
+
+// compactReader decodes the Thrift compact protocol into maps of field id
+// to value.
+type compactReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *compactReader) uvarint() uint64 {
+	value, size := binary.Uvarint(r.data[r.pos:])
+	r.pos += size
+	return value
+}
+
+func (r *compactReader) varint() int64 {
+	value, size := binary.Varint(r.data[r.pos:])
+	r.pos += size
+	return value
+}
+
+func (r *compactReader) structValue() map[int16]any {
+	fields := make(map[int16]any)
+	var id int16
+	for {
+		header := r.data[r.pos]
+		r.pos++
+		if header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.varint())
+		}
+		switch kind := header & 0x0f; kind {
+		case 1, 2:
+			fields[id] = kind == 1
+		default:
+			fields[id] = r.value(kind)
+		}
+	}
+}
+
+func (r *compactReader) value(kind byte) any {
+	switch kind {
+	case 5, 6:
+		return r.varint()
+	case 8:
+		size := int(r.uvarint())
+		r.pos += size
+		return r.data[r.pos-size : r.pos]
+	case 9:
+		header := r.data[r.pos]
+		r.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		items := make([]any, 0, size)
+		for range size {
+			items = append(items, r.value(header&0x0f))
+		}
+		return items
+	case 12:
+		return r.structValue()
+	}
+	panic("unsupported compact type")
+}
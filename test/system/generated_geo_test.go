@@ -0,0 +1,66 @@
+package genexample
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func skuIDs(rows []SkuRow) []string {
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids
+}
+
+func TestGeneratedGeoQueries(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "geo.db"))
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	for _, sku := range []struct {
+		id       string
+		lat, lng float64
+	}{
+		{"helsinki", 60.1699, 24.9384},
+		{"espoo", 60.2055, 24.6559},
+		{"tallinn", 59.4370, 24.7536},
+		{"stockholm", 59.3293, 18.0686},
+		{"suva", -18.1248, 178.4501},
+		{"apia", -13.8333, -171.7667},
+	} {
+		_, err := crud.Sku.InsertWithID(sku.id, &Sku{Name: sku.id, Lat: sku.lat, Lng: sku.lng})
+		assert.NilError(t, err)
+	}
+
+	rows, err := crud.Sku.SelectNear(60.1699, 24.9384, 100_000)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(skuIDs(rows), []string{"helsinki", "espoo", "tallinn"}), "nearest first")
+	rows, err = crud.Sku.SelectNear(60.1699, 24.9384, 10_000)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(skuIDs(rows), []string{"helsinki"}), "espoo is about 16 km away")
+	rows, err = crud.Sku.SelectNear(0, 0, 1)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 0))
+
+	// Suva and Apia are about 1150 km apart across the antimeridian.
+	rows, err = crud.Sku.SelectNear(-18.1248, 178.4501, 1_500_000)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(skuIDs(rows), []string{"suva", "apia"}))
+
+	rows, err = crud.Sku.SelectWithinBounds(rt.GeoBounds{MinLat: 59, MinLng: 20, MaxLat: 61, MaxLng: 30})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(slices.Sorted(slices.Values(skuIDs(rows))), []string{"espoo", "helsinki", "tallinn"}))
+	rows, err = crud.Sku.SelectWithinBounds(rt.GeoBounds{MinLat: -20, MinLng: 170, MaxLat: 0, MaxLng: -170})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(slices.Sorted(slices.Values(skuIDs(rows))), []string{"apia", "suva"}), "boxes may cross the antimeridian")
+
+	distance := rt.GeoDistanceMeters(60.1699, 24.9384, 59.4370, 24.7536)
+	assert.Check(t, distance > 81_000 && distance < 83_000, "Helsinki-Tallinn is about 82 km: %v", distance)
+	_, err = crud.Sku.SelectNear(0, 0, -1)
+	assert.Check(t, is.ErrorContains(err, "invalid radius"))
+}
@@ -0,0 +1,532 @@
+package genexample
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+type httpTestRow struct {
+	ID       string          `json:"id"`
+	AtNs     int64           `json:"atNs"`
+	Revision string          `json:"revision"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func doHTTPTestRequest(t *testing.T, server *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+
+	request, err := http.NewRequestWithContext(t.Context(), method, server.URL+path, strings.NewReader(body))
+	assert.NilError(t, err)
+	response, err := server.Client().Do(request)
+	assert.NilError(t, err)
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(response.Body)
+	assert.NilError(t, err)
+	return response.StatusCode, string(responseBody)
+}
+
+func TestGeneratedHTTPHandler(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "http.db")))
+	assert.NilError(t, crud.Init())
+	server := httptest.NewServer(NewHTTPHandler(crud))
+	defer server.Close()
+
+	status, body := doHTTPTestRequest(t, server, http.MethodPost, "/person", `{"name": "Ada", "age": "36"}`)
+	assert.Equal(t, status, http.StatusCreated, body)
+	created := httpTestRow{}
+	assert.NilError(t, json.Unmarshal([]byte(body), &created))
+	assert.Check(t, created.ID != "")
+	_, err := crud.Person.Insert(&Person{Name: "Grace", Age: 40})
+	assert.NilError(t, err)
+
+	status, body = doHTTPTestRequest(t, server, http.MethodGet, "/person?age=36", "")
+	assert.Equal(t, status, http.StatusOK, body)
+	listed := make([]httpTestRow, 0)
+	assert.NilError(t, json.Unmarshal([]byte(body), &listed))
+	assert.Assert(t, is.Len(listed, 1))
+	assert.Check(t, is.Equal(listed[0].ID, created.ID))
+	assert.Check(t, strings.Contains(string(listed[0].Data), `"name":"Ada"`))
+
+	status, body = doHTTPTestRequest(t, server, http.MethodGet, "/person?name=Ada&name=Grace", "")
+	assert.Equal(t, status, http.StatusOK, body)
+	assert.NilError(t, json.Unmarshal([]byte(body), &listed))
+	assert.Check(t, is.Len(listed, 2))
+
+	status, body = doHTTPTestRequest(t, server, http.MethodGet, "/person?name=Ada&name=Grace&filter="+url.QueryEscape(`age > 30 AND name != "Ada"`), "")
+	assert.Equal(t, status, http.StatusOK, body)
+	assert.NilError(t, json.Unmarshal([]byte(body), &listed))
+	assert.Assert(t, is.Len(listed, 1))
+	assert.Check(t, strings.Contains(string(listed[0].Data), `"name":"Grace"`))
+	status, body = doHTTPTestRequest(t, server, http.MethodGet, "/person?filter="+url.QueryEscape("nickname = x"), "")
+	assert.Check(t, is.Equal(status, http.StatusBadRequest))
+	assert.Check(t, strings.Contains(body, `unknown field \"nickname\"`), body)
+
+	status, body = doHTTPTestRequest(t, server, http.MethodPut, "/person/"+created.ID, `{"name": "Ada", "age": "37"}`)
+	assert.Equal(t, status, http.StatusOK, body)
+	rows, err := crud.Person.Select(`id = ?`, created.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].Data.GetAge(), int64(37)))
+
+	status, body = doHTTPTestRequest(t, server, http.MethodGet, "/person/"+created.ID, "")
+	assert.Equal(t, status, http.StatusOK, body)
+	assert.Check(t, strings.Contains(body, `"age":"37"`), body)
+
+	status, body = doHTTPTestRequest(t, server, http.MethodDelete, "/person/"+created.ID, "")
+	assert.Equal(t, status, http.StatusNoContent, body)
+	status, _ = doHTTPTestRequest(t, server, http.MethodGet, "/person/"+created.ID, "")
+	assert.Check(t, is.Equal(status, http.StatusNotFound))
+	status, _ = doHTTPTestRequest(t, server, http.MethodPut, "/person/"+created.ID, `{"name": "Ada"}`)
+	assert.Check(t, is.Equal(status, http.StatusNotFound))
+}
+
+func TestGeneratedHTTPIfMatch(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "http.db")))
+	assert.NilError(t, crud.Init())
+	server := httptest.NewServer(NewHTTPHandler(crud))
+	defer server.Close()
+	doConditional := func(method, path, ifMatch, body string) (int, http.Header) {
+		t.Helper()
+		request, err := http.NewRequestWithContext(t.Context(), method, server.URL+path, strings.NewReader(body))
+		assert.NilError(t, err)
+		if ifMatch != "" {
+			request.Header.Set("If-Match", ifMatch)
+		}
+		response, err := server.Client().Do(request)
+		assert.NilError(t, err)
+		defer response.Body.Close()
+		_, err = io.Copy(io.Discard, response.Body)
+		assert.NilError(t, err)
+		return response.StatusCode, response.Header
+	}
+
+	inserted, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	status, header := doConditional(http.MethodGet, "/person/"+inserted.ID, "", "")
+	assert.Equal(t, status, http.StatusOK)
+	etag := header.Get("ETag")
+	assert.Check(t, is.Equal(etag, `"`+inserted.Revision()+`"`))
+
+	status, header = doConditional(http.MethodPut, "/person/"+inserted.ID, `"stale", `+etag, `{"name": "Ada", "age": "37"}`)
+	assert.Equal(t, status, http.StatusOK)
+	assert.Check(t, header.Get("ETag") != etag)
+	status, _ = doConditional(http.MethodPut, "/person/"+inserted.ID, etag, `{"name": "Lost"}`)
+	assert.Check(t, is.Equal(status, http.StatusPreconditionFailed))
+	status, _ = doConditional(http.MethodPut, "/person/"+inserted.ID, "W/"+header.Get("ETag"), `{"name": "Lost"}`)
+	assert.Check(t, is.Equal(status, http.StatusPreconditionFailed), "weak tags never match")
+	status, _ = doConditional(http.MethodDelete, "/person/"+inserted.ID, etag, "")
+	assert.Check(t, is.Equal(status, http.StatusPreconditionFailed))
+	row, err := crud.Person.GetByID(inserted.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(row.Data.GetName(), "Ada"))
+
+	status, _ = doConditional(http.MethodDelete, "/person/"+inserted.ID, header.Get("ETag"), "")
+	assert.Check(t, is.Equal(status, http.StatusNoContent))
+	other, err := crud.Person.Insert(&Person{Name: "Grace"})
+	assert.NilError(t, err)
+	status, _ = doConditional(http.MethodDelete, "/person/"+other.ID, "*", "")
+	assert.Check(t, is.Equal(status, http.StatusNoContent))
+}
+
+func TestGeneratedHTTPHandlerRejectsBadRequests(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "http.db")))
+	assert.NilError(t, crud.Init())
+	server := httptest.NewServer(NewHTTPHandler(crud))
+	defer server.Close()
+
+	status, body := doHTTPTestRequest(t, server, http.MethodPost, "/person", `{"name": ""}`)
+	assert.Check(t, is.Equal(status, http.StatusBadRequest))
+	assert.Check(t, strings.Contains(body, "name is required"), body)
+
+	status, _ = doHTTPTestRequest(t, server, http.MethodPost, "/person", `{"unknown": 1}`)
+	assert.Check(t, is.Equal(status, http.StatusBadRequest))
+
+	status, body = doHTTPTestRequest(t, server, http.MethodPost, "/person", `{"name": "Old", "age": "201"}`)
+	assert.Check(t, is.Equal(status, http.StatusBadRequest))
+	assert.Check(t, strings.Contains(body, "age must be at most 200"), body)
+
+	status, body = doHTTPTestRequest(t, server, http.MethodGet, "/person?data=x", "")
+	assert.Check(t, is.Equal(status, http.StatusBadRequest))
+	assert.Check(t, strings.Contains(body, `unknown filter \"data\"`), body)
+
+	status, _ = doHTTPTestRequest(t, server, http.MethodGet, "/person?age=old", "")
+	assert.Check(t, is.Equal(status, http.StatusBadRequest))
+
+	status, _ = doHTTPTestRequest(t, server, http.MethodGet, "/note?text=secret", "")
+	assert.Check(t, is.Equal(status, http.StatusBadRequest))
+
+	status, _ = doHTTPTestRequest(t, server, http.MethodGet, "/person/not-a-uuid", "")
+	assert.Check(t, is.Equal(status, http.StatusNotFound))
+}
+
+func TestGeneratedHTTPOpenAPI(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "http.db")))
+	assert.NilError(t, crud.Init())
+	server := httptest.NewServer(NewHTTPHandler(crud))
+	defer server.Close()
+
+	status, body := doHTTPTestRequest(t, server, http.MethodGet, rt.OpenAPIPath, "")
+	assert.Equal(t, status, http.StatusOK, body)
+	generated, err := os.ReadFile("system.proprdb.openapi.json")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(body, string(generated)))
+
+	var document struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title string `json:"title"`
+		} `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	assert.NilError(t, json.Unmarshal([]byte(body), &document))
+	compact := func(raw json.RawMessage) string {
+		var buffer bytes.Buffer
+		assert.NilError(t, json.Compact(&buffer, raw))
+		return buffer.String()
+	}
+	assert.Check(t, is.Equal(document.OpenAPI, "3.0.3"))
+	assert.Check(t, is.Equal(document.Info.Title, "generatedtest.example"))
+	// A collection and an item path per table.
+	assert.Check(t, is.Len(document.Paths, 24))
+	assert.Check(t, is.Contains(document.Paths["/person"], "post"))
+	assert.Check(t, is.Contains(document.Paths["/person/{id}"], "delete"))
+	assert.Check(t, strings.Contains(compact(document.Paths["/person"]["get"]), `"name":"age"`))
+	assert.Check(t, strings.Contains(compact(document.Components.Schemas["generatedtest.example.Person"]), `"age":{"format":"int64","type":"string"}`))
+	assert.Check(t, strings.Contains(compact(document.Components.Schemas["generatedtest.example.Event"]), `"occurredAt":{"format":"date-time","type":"string"}`))
+	assert.Check(t, strings.Contains(compact(document.Components.Schemas["generatedtest.example.Event"]), `"labels":{"additionalProperties":{"type":"string"},"type":"object"}`))
+	assert.Check(t, is.Contains(document.Components.Schemas, "proprdb.Row.generatedtest.example.Person"))
+}
+
+func doGraphQLTestRequest(t *testing.T, server *httptest.Server, query string, variables map[string]any) (int, map[string]any) {
+	t.Helper()
+
+	body, err := json.Marshal(rt.GraphQLRequest{Query: query, Variables: variables})
+	assert.NilError(t, err)
+	status, responseBody := doHTTPTestRequest(t, server, http.MethodPost, "/", string(body))
+	response := make(map[string]any)
+	assert.NilError(t, json.Unmarshal([]byte(responseBody), &response), responseBody)
+	return status, response
+}
+
+func TestGeneratedGraphQLHandler(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "graphql.db")))
+	assert.NilError(t, crud.Init())
+	server := httptest.NewServer(NewGraphQLHandler(crud))
+	defer server.Close()
+
+	status, response := doGraphQLTestRequest(t, server, `mutation Add($data: PersonInput!) {
+		created: createPerson(data: $data) { id atNs data { name age address { city } } }
+	}`, map[string]any{"data": map[string]any{"name": "Ada", "age": "36", "address": map[string]any{"city": "London"}}})
+	assert.Equal(t, status, http.StatusOK)
+	assert.Check(t, is.Nil(response["errors"]))
+	created := response["data"].(map[string]any)["created"].(map[string]any)
+	id := created["id"].(string)
+	assert.Check(t, is.DeepEqual(created["data"], map[string]any{"name": "Ada", "age": "36", "address": map[string]any{"city": "London"}}))
+	_, err := crud.Person.Insert(&Person{Name: "Grace", Age: 40})
+	assert.NilError(t, err)
+
+	// Fields keep the order of the selection, and aliases name them.
+	status, body := doHTTPTestRequest(t, server, http.MethodGet, "/?query="+url.QueryEscape(`{ people: personList(age: [36, 40]) { __typename data { age name } } }`), "")
+	assert.Equal(t, status, http.StatusOK, body)
+	assert.Check(t, is.Equal(strings.TrimSpace(body), `{"data":{"people":[{"__typename":"PersonRow","data":{"age":"36","name":"Ada"}},{"__typename":"PersonRow","data":{"age":"40","name":"Grace"}}]}}`))
+
+	_, response = doGraphQLTestRequest(t, server, `query One($id: ID!) { person(id: $id) { data { name } } missing: person(id: "nope") { id } }`, map[string]any{"id": id})
+	assert.Check(t, is.DeepEqual(response["data"], map[string]any{
+		"person":  map[string]any{"data": map[string]any{"name": "Ada"}},
+		"missing": nil,
+	}))
+
+	_, response = doGraphQLTestRequest(t, server, `mutation { replacePerson(id: "`+id+`", data: {name: "Ada", age: 37}) { data { age } } }`, nil)
+	assert.Check(t, is.Nil(response["errors"]))
+	row, err := crud.Person.GetByID(id)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(row.Data.GetAge(), int64(37)))
+
+	_, response = doGraphQLTestRequest(t, server, `mutation { createPerson(data: {name: ""}) { id } }`, nil)
+	assert.Check(t, is.DeepEqual(response["data"], map[string]any{"createPerson": nil}))
+	assert.Check(t, strings.Contains(fmt.Sprint(response["errors"]), "name is required"), response)
+
+	_, response = doGraphQLTestRequest(t, server, `mutation { deletePerson(id: "`+id+`") }`, nil)
+	assert.Check(t, is.DeepEqual(response["data"], map[string]any{"deletePerson": true}))
+	_, err = crud.Person.GetByID(id)
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+
+	_, response = doGraphQLTestRequest(t, server, `{ personList { data { nickname } } }`, nil)
+	assert.Check(t, strings.Contains(fmt.Sprint(response["errors"]), `unknown field "nickname" on Person`), response)
+	_, response = doGraphQLTestRequest(t, server, `{ personList(data: "x") { id } }`, nil)
+	assert.Check(t, strings.Contains(fmt.Sprint(response["errors"]), `unknown filter "data"`), response)
+	_, response = doGraphQLTestRequest(t, server, `{ personList { id `, nil)
+	assert.Check(t, is.Nil(response["data"]))
+	assert.Check(t, strings.Contains(fmt.Sprint(response["errors"]), "syntax error"), response)
+
+	status, _ = doHTTPTestRequest(t, server, http.MethodGet, "/?query="+url.QueryEscape(`mutation { deletePerson(id: "x") }`), "")
+	assert.Check(t, is.Equal(status, http.StatusMethodNotAllowed))
+
+	generated, err := os.ReadFile("system.proprdb.graphql")
+	assert.NilError(t, err)
+	assert.Check(t, strings.HasSuffix(string(generated), NewGraphQLHandler(crud).Schema()+"\n"))
+}
+
+// graphQLTestIntrospectionQuery is the introspection query of graphql-js,
+// which GraphiQL and client generators send.
+const graphQLTestIntrospectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+fragment FullType on __Type {
+  kind
+  name
+  description
+  fields(includeDeprecated: true) {
+    name
+    description
+    args { ...InputValue }
+    type { ...TypeRef }
+    isDeprecated
+    deprecationReason
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+  possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue { name description type { ...TypeRef } defaultValue }
+fragment TypeRef on __Type {
+  kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } }
+}`
+
+type graphQLTestTypeRef struct {
+	Kind   string              `json:"kind"`
+	Name   *string             `json:"name"`
+	OfType *graphQLTestTypeRef `json:"ofType"`
+}
+
+func (r *graphQLTestTypeRef) String() string {
+	switch {
+	case r == nil:
+		return ""
+	case r.Kind == "NON_NULL":
+		return r.OfType.String() + "!"
+	case r.Kind == "LIST":
+		return "[" + r.OfType.String() + "]"
+	}
+	return *r.Name
+}
+
+type graphQLTestField struct {
+	Name string              `json:"name"`
+	Args []graphQLTestField  `json:"args"`
+	Type *graphQLTestTypeRef `json:"type"`
+}
+
+type graphQLTestType struct {
+	Kind        string             `json:"kind"`
+	Name        string             `json:"name"`
+	Description *string            `json:"description"`
+	Fields      []graphQLTestField `json:"fields"`
+	InputFields []graphQLTestField `json:"inputFields"`
+	EnumValues  []struct {
+		Name string `json:"name"`
+	} `json:"enumValues"`
+}
+
+func TestGeneratedGraphQLIntrospection(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "introspection.db")))
+	assert.NilError(t, crud.Init())
+	handler := NewGraphQLHandler(crud)
+
+	response := handler.Execute(rt.GraphQLRequest{Query: graphQLTestIntrospectionQuery})
+	assert.Assert(t, is.Len(response.Errors, 0), "%v", response.Errors)
+	encoded, err := json.Marshal(response.Data)
+	assert.NilError(t, err)
+	var data struct {
+		Schema struct {
+			QueryType        struct{ Name string } `json:"queryType"`
+			MutationType     struct{ Name string } `json:"mutationType"`
+			SubscriptionType struct{ Name string } `json:"subscriptionType"`
+			Types            []graphQLTestType     `json:"types"`
+			Directives       []any                 `json:"directives"`
+		} `json:"__schema"`
+	}
+	assert.NilError(t, json.Unmarshal(encoded, &data))
+	assert.Check(t, is.Equal(data.Schema.QueryType.Name, "Query"))
+	assert.Check(t, is.Equal(data.Schema.MutationType.Name, "Mutation"))
+	assert.Check(t, is.Equal(data.Schema.SubscriptionType.Name, "Subscription"))
+	assert.Check(t, is.Len(data.Schema.Directives, 0))
+	types := make(map[string]graphQLTestType)
+	for _, schemaType := range data.Schema.Types {
+		types[schemaType.Name] = schemaType
+	}
+	// Every type the SDL declares, and the built-in scalars it refers to.
+	for _, name := range []string{"Int64", "JSON", "Timestamp", "Query", "PersonRow", "PersonChange", "Person", "PersonInput", "ChangeOp", "String", "ID", "Boolean"} {
+		assert.Check(t, is.Contains(types, name))
+	}
+	assert.Check(t, is.Equal(*types["Int64"].Description, "An integer as protojson encodes it: a string for 64-bit fields, a number otherwise."))
+	fieldTypes := func(fields []graphQLTestField) map[string]string {
+		described := make(map[string]string, len(fields))
+		for _, field := range fields {
+			described[field.Name] = field.Type.String()
+		}
+		return described
+	}
+	assert.Check(t, is.DeepEqual(fieldTypes(types["PersonRow"].Fields), map[string]string{"id": "ID!", "atNs": "Int64!", "data": "Person!"}))
+	assert.Check(t, is.Equal(fieldTypes(types["Query"].Fields)["personList"], "[PersonRow!]"))
+	assert.Check(t, is.Equal(fieldTypes(types["PersonInput"].InputFields)["age"], "Int64"))
+	assert.Check(t, is.Equal(types["PersonInput"].Kind, "INPUT_OBJECT"))
+	for _, field := range types["Subscription"].Fields {
+		if field.Name == "personChanges" {
+			assert.Check(t, is.DeepEqual(fieldTypes(field.Args), map[string]string{"id": "ID", "since": "Int64"}))
+			assert.Check(t, is.Equal(field.Type.String(), "PersonChange!"))
+		}
+	}
+	assert.Check(t, is.Len(types["ChangeOp"].EnumValues, 3))
+
+	response = handler.Execute(rt.GraphQLRequest{
+		Query:     `query Type($name: String!) { __type(name: $name) { name ... on __Type { kind } fields { name } } missing: __type(name: "Nope") { name } }`,
+		Variables: map[string]any{"name": "PersonChange"},
+	})
+	assert.Assert(t, is.Len(response.Errors, 0), "%v", response.Errors)
+	encoded, err = json.Marshal(response.Data)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(encoded), `{"__type":{"name":"PersonChange","kind":"OBJECT","fields":[{"name":"seq"},{"name":"op"},{"name":"id"},{"name":"atNs"},{"name":"row"}]},"missing":null}`))
+
+	// Fragments also select from rows, on the type they name.
+	created, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	response = handler.Execute(rt.GraphQLRequest{Query: `{ person(id: "` + created.ID + `") { ...Row ... on NoteRow { atNs } } }
+		fragment Row on PersonRow { id data { name } data { age } }`})
+	assert.Assert(t, is.Len(response.Errors, 0), "%v", response.Errors)
+	encoded, err = json.Marshal(response.Data)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(encoded), `{"person":{"id":"`+created.ID+`","data":{"name":"Ada","age":"36"}}}`))
+	response = handler.Execute(rt.GraphQLRequest{Query: `{ personList { ...Missing } }`})
+	assert.Check(t, is.ErrorContains(errors.New(fmt.Sprint(response.Errors)), `unknown fragment "Missing"`))
+	response = handler.Execute(rt.GraphQLRequest{Query: `{ personList { ...A } } fragment A on PersonRow { ...A }`})
+	assert.Check(t, is.ErrorContains(errors.New(fmt.Sprint(response.Errors)), "fragment A spreads itself"))
+}
+
+func TestGeneratedGraphQLSubscription(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "subscription.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{ChangeFeed: true})
+	assert.NilError(t, crud.Init())
+	handler := NewGraphQLHandler(crud)
+	handler.PollInterval = time.Millisecond
+
+	since, _, err := rt.ChangeFeedPosition(db, 0)
+	assert.NilError(t, err)
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	bob, err := crud.Person.Insert(&Person{Name: "Bob"})
+	assert.NilError(t, err)
+	_, err = crud.Note.Insert(&Note{Text: "other tables are filtered"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Person.DeleteByID(bob.ID))
+
+	// Rows are read when a change is sent, so Bob is already gone.
+	errDone := errors.New("done")
+	var events []string
+	err = handler.Subscribe(t.Context(), rt.GraphQLRequest{
+		Query:     `subscription Follow($since: Int64) { personChanges(since: $since) { op id row { data { name } } } }`,
+		Variables: map[string]any{"since": strconv.FormatInt(since, 10)},
+	}, func(seq int64, response rt.GraphQLResponse) error {
+		encoded, err := json.Marshal(response)
+		assert.NilError(t, err)
+		events = append(events, string(encoded))
+		if len(events) == 3 {
+			return errDone
+		}
+		return nil
+	})
+	assert.Check(t, is.ErrorIs(err, errDone))
+	assert.Check(t, is.DeepEqual(events, []string{
+		`{"data":{"personChanges":{"op":"insert","id":"` + ada.ID + `","row":{"data":{"name":"Ada"}}}}}`,
+		`{"data":{"personChanges":{"op":"insert","id":"` + bob.ID + `","row":null}}}`,
+		`{"data":{"personChanges":{"op":"delete","id":"` + bob.ID + `","row":null}}}`,
+	}))
+
+	response := handler.Execute(rt.GraphQLRequest{Query: `subscription { personChanges { id } }`})
+	assert.Check(t, is.ErrorContains(errors.New(fmt.Sprint(response.Errors)), "subscriptions need a stream"))
+	err = NewGraphQLHandler(NewCRUD(db)).Subscribe(t.Context(), rt.GraphQLRequest{Query: `subscription { personChanges { id } }`}, nil)
+	assert.Check(t, is.ErrorContains(err, "GraphQL.Changes"))
+	err = handler.Subscribe(t.Context(), rt.GraphQLRequest{Query: `subscription { personChanges { id } noteChanges { id } }`}, nil)
+	assert.Check(t, is.ErrorContains(err, "exactly one field"))
+
+	// Over HTTP, subscriptions are server-sent events resuming with
+	// Last-Event-ID.
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	subscribe := func(lastEventID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(t.Context())
+		query := url.QueryEscape(`subscription { personChanges(id: "` + ada.ID + `") { op row { data { name } } } }`)
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/?query="+query, nil)
+		assert.NilError(t, err)
+		request.Header.Set("Accept", "text/event-stream")
+		if lastEventID != "" {
+			request.Header.Set("Last-Event-ID", lastEventID)
+		}
+		response, err := server.Client().Do(request)
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(response.Header.Get("Content-Type"), "text/event-stream"))
+		return bufio.NewReader(response.Body), func() {
+			cancel()
+			assert.NilError(t, response.Body.Close())
+		}
+	}
+	readEvent := func(reader *bufio.Reader) []string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			assert.NilError(t, err)
+			if line == "\n" {
+				return lines
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+	}
+	stream, closeStream := subscribe("")
+	_, err = crud.Person.UpdateByID(ada.ID, &Person{Name: "Ada Lovelace"})
+	assert.NilError(t, err)
+	event := readEvent(stream)
+	closeStream()
+	assert.Assert(t, is.Len(event, 3))
+	assert.Check(t, is.Equal(event[0], "event: next"))
+	assert.Check(t, strings.HasPrefix(event[1], "id: "))
+	assert.Check(t, is.Equal(event[2], `data: {"data":{"personChanges":{"op":"update","row":{"data":{"name":"Ada Lovelace"}}}}}`))
+	seq, err := strconv.ParseInt(strings.TrimPrefix(event[1], "id: "), 10, 64)
+	assert.NilError(t, err)
+
+	stream, closeStream = subscribe(strconv.FormatInt(seq-1, 10))
+	assert.Check(t, is.DeepEqual(readEvent(stream), event))
+	closeStream()
+
+	_, err = rt.TrimChanges(db, seq)
+	assert.NilError(t, err)
+	_, trimmed := doGraphQLTestRequest(t, server, `subscription { personChanges(since: 0) { id } }`, nil)
+	assert.Check(t, strings.Contains(fmt.Sprint(trimmed["errors"]), "no longer in the change feed"), trimmed)
+}
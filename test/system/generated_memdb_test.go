@@ -0,0 +1,148 @@
+package genexample
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+	"github.com/fingon/proprdb/rt/memdb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func memDBPersonNames(t *testing.T, table PersonStore, opts rt.SelectOptions, where string, args ...any) []string {
+	t.Helper()
+
+	rows, err := table.SelectWithOptions(opts, where, args...)
+	assert.NilError(t, err)
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, row.Data.GetName())
+	}
+	return names
+}
+
+func TestGeneratedMemTableMatchesSQLite(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "memdb.db")))
+	assert.NilError(t, crud.Init())
+	tables := map[string]PersonStore{"sqlite": crud.PersonStore(), "memdb": NewMemCRUD().PersonStore()}
+
+	results := make(map[string][][]string)
+	for name, table := range tables {
+		ada, err := table.Insert(&Person{Name: "Ada", Age: 36, Address: &Person_Address{City: "London"}})
+		assert.NilError(t, err)
+		_, err = table.Insert(&Person{Name: "Grace", Age: 40})
+		assert.NilError(t, err)
+		linus, err := table.Insert(&Person{Name: "Linus", Age: 12})
+		assert.NilError(t, err)
+		_, err = table.UpdateByID(ada.ID, &Person{Name: "Ada", Age: 37, Address: &Person_Address{City: "London"}})
+		assert.NilError(t, err)
+		assert.NilError(t, table.DeleteByID(linus.ID))
+
+		_, err = table.Insert(&Person{Name: "Bad", Age: 300})
+		assert.Check(t, errors.Is(err, rt.ErrValidation), name)
+		_, err = table.Insert(&Person{Age: 1})
+		assert.Check(t, errors.Is(err, rt.ErrValidation), name)
+
+		results[name] = [][]string{
+			memDBPersonNames(t, table, rt.SelectOptions{}, ""),
+			memDBPersonNames(t, table, rt.SelectOptions{}, `age > ?`, 36),
+			memDBPersonNames(t, table, rt.SelectOptions{}, `"address_city" = ? AND age >= ?`, "London", 18),
+			memDBPersonNames(t, table, rt.SelectOptions{}, `address_city IS NULL`),
+			memDBPersonNames(t, table, rt.SelectOptions{}, `name IN (?, ?)`, "Grace", "Linus"),
+			memDBPersonNames(t, table, rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: "age", Descending: true}}}, ""),
+			memDBPersonNames(t, table, rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: "name"}}, Limit: 1, Offset: 1}, ""),
+		}
+	}
+	assert.Check(t, is.DeepEqual(results["memdb"], results["sqlite"]))
+	assert.Check(t, is.DeepEqual(results["memdb"][0], []string{"Ada", "Grace"}))
+}
+
+func TestGeneratedMemTable(t *testing.T) {
+	crud := NewMemCRUD()
+
+	row, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	assert.NilError(t, rt.ValidateUUID(row.ID))
+	_, err = crud.Person.InsertWithID(row.ID, &Person{Name: "Ada", Age: 36})
+	assert.Check(t, errors.Is(err, rt.ErrUniqueViolation))
+	_, err = crud.Person.UpdateByID("not-a-uuid", &Person{Name: "Ada"})
+	assert.Check(t, errors.Is(err, rt.ErrInvalidID))
+
+	updated, err := crud.Person.UpdateByID(row.ID, &Person{Name: "Ada", Age: 37})
+	assert.NilError(t, err)
+	assert.Check(t, updated.AtNs > row.AtNs)
+	found := crud.Person.MustGetByID(row.ID)
+	assert.Check(t, is.Equal(found.Data.GetAge(), int64(37)))
+	found.Data.Age = 99
+	assert.Check(t, is.Equal(crud.Person.MustGetByID(row.ID).Data.GetAge(), int64(37)), "returned rows are copies")
+
+	assert.NilError(t, crud.Person.DeleteByID(row.ID))
+	_, err = crud.Person.GetByID(row.ID)
+	assert.Check(t, errors.Is(err, rt.ErrNotFound))
+	assert.Check(t, crud.Person.Tombstones()[row.ID] > updated.AtNs)
+	_, err = crud.Person.InsertWithID(row.ID, &Person{Name: "Ada"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(crud.Person.Tombstones(), 0))
+
+	_, err = crud.Person.Select(`name LIKE ?`, "A%")
+	assert.Check(t, errors.Is(err, memdb.ErrUnsupportedWhere))
+	_, err = crud.Person.SelectWithOptions(rt.SelectOptions{OrderBy: []rt.OrderBy{{Column: "nope"}}}, "")
+	assert.Check(t, err != nil)
+
+	archived, err := crud.Archive.Insert(&Archive{Label: "old"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Archive.DeleteByID(archived.ID))
+	rows, err := crud.Archive.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 0))
+	rows, err = crud.Archive.SelectIncludingDeleted(`deleted_at_ns IS NOT NULL`)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, rows[0].DeletedAtNs > 0)
+	restored, err := crud.Archive.Restore(archived.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(restored.Data.GetLabel(), "old"))
+	assert.Check(t, is.Equal(restored.DeletedAtNs, int64(0)))
+}
+
+func checkEventStoreRanges(t *testing.T, events EventStore) {
+	t.Helper()
+
+	base := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	late, err := events.Insert(&Event{Kind: "late", OccurredAt: timestamppb.New(base.Add(2 * time.Hour)), ExpiresAt: timestamppb.New(base.Add(48 * time.Hour))})
+	assert.NilError(t, err)
+	early, err := events.Insert(&Event{Kind: "early", OccurredAt: timestamppb.New(base), ExpiresAt: timestamppb.New(base.Add(24 * time.Hour))})
+	assert.NilError(t, err)
+	_, err = events.Insert(&Event{Kind: "unset"})
+	assert.NilError(t, err)
+	_, err = events.Insert(&Event{Kind: "Bad"})
+	assert.Check(t, errors.Is(err, rt.ErrValidation))
+
+	rows, err := events.SelectOccurredAtBetween(base, base.Add(3*time.Hour))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 2))
+	assert.Check(t, is.Equal(rows[0].ID, early.ID))
+	assert.Check(t, is.Equal(rows[1].ID, late.ID))
+	rows, err = events.SelectExpiresAtBetween(base.Add(25*time.Hour), base.Add(49*time.Hour))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, late.ID))
+}
+
+func TestGeneratedStoreInterfaces(t *testing.T) {
+	var store CRUDStore = NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "store.db")))
+	assert.NilError(t, store.Init())
+	checkEventStoreRanges(t, store.EventStore())
+	checkEventStoreRanges(t, NewMemCRUD().EventStore())
+
+	documents := NewMemCRUD().DocumentStore()
+	document, err := documents.Insert(&Document{})
+	assert.NilError(t, err)
+	versionVector, err := documents.VersionVector(document.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(versionVector, 0))
+}
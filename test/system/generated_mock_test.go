@@ -0,0 +1,55 @@
+package genexample
+
+import (
+	"errors"
+	"testing"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+// renamePerson stands in for downstream code that depends on a Store.
+func renamePerson(store PersonStore, id, name string) error {
+	row, err := store.GetByID(id)
+	if err != nil {
+		return err
+	}
+	row.Data.Name = name
+	_, err = store.UpdateRow(*row)
+	return err
+}
+
+func TestGeneratedMockStore(t *testing.T) {
+	errMissing := errors.New("missing")
+	mock := &MockPersonStore{
+		GetByIDFunc: func(id string) (*PersonRow, error) {
+			if id != "p1" {
+				return nil, errMissing
+			}
+			return &PersonRow{ID: id, AtNs: 1, Data: &Person{Name: "Ada"}}, nil
+		},
+	}
+
+	assert.NilError(t, renamePerson(mock, "p1", "Grace"))
+	assert.Check(t, is.ErrorIs(renamePerson(mock, "p2", "Grace"), errMissing))
+
+	updates := mock.CallsTo("UpdateRow")
+	assert.Assert(t, is.Len(updates, 1))
+	updated := updates[0].Args[0].(PersonRow)
+	assert.Check(t, is.Equal(updated.Data.GetName(), "Grace"))
+	methods := make([]string, 0)
+	for _, call := range mock.Calls() {
+		methods = append(methods, call.Method)
+	}
+	assert.Check(t, is.DeepEqual(methods, []string{"GetByID", "UpdateRow", "GetByID"}))
+	assert.Check(t, is.DeepEqual(mock.CallsTo("GetByID")[1], rt.MockCall{Method: "GetByID", Args: []any{"p2"}}))
+
+	rows, err := mock.Select("age > ?", 30)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 0), "unset functions return zero values")
+	assert.Check(t, is.DeepEqual(mock.CallsTo("Select")[0].Args, []any{"age > ?", []any{30}}))
+
+	mock.ResetCalls()
+	assert.Check(t, is.Len(mock.Calls(), 0))
+}
@@ -0,0 +1,120 @@
+package genexample
+
+import (
+	"cmp"
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+// termIndex is a toy rt.SearchIndex scoring documents by how often they
+// contain the query terms, standing in for a Bleve adapter.
+type termIndex struct {
+	mu   sync.Mutex
+	docs map[string]map[string]string
+}
+
+func newTermIndex() *termIndex {
+	return &termIndex{docs: make(map[string]map[string]string)}
+}
+
+func (x *termIndex) Index(_ context.Context, tableName, id string, fields map[string]string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.docs[tableName+"/"+id] = fields
+	return nil
+}
+
+func (x *termIndex) Delete(_ context.Context, tableName, id string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.docs, tableName+"/"+id)
+	return nil
+}
+
+func (x *termIndex) Search(_ context.Context, tableName, query string, limit int) ([]rt.SearchHit, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var hits []rt.SearchHit
+	for key, fields := range x.docs {
+		id, found := strings.CutPrefix(key, tableName+"/")
+		if !found {
+			continue
+		}
+		score := 0.0
+		for _, text := range fields {
+			for _, term := range strings.Fields(strings.ToLower(query)) {
+				score += float64(strings.Count(strings.ToLower(text), term))
+			}
+		}
+		if score > 0 {
+			hits = append(hits, rt.SearchHit{ID: id, Score: score})
+		}
+	}
+	slices.SortFunc(hits, func(a, b rt.SearchHit) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.ID, b.ID))
+	})
+	return hits[:min(limit, len(hits))], nil
+}
+
+func TestGeneratedSearchFullText(t *testing.T) {
+	ctx := context.Background()
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "search.db"))
+	plain := NewCRUD(db)
+	assert.NilError(t, plain.Init())
+	_, err := plain.Task.SearchFullText("anything", 10)
+	assert.Check(t, is.ErrorIs(err, rt.ErrNoSearchIndex))
+	old, err := plain.Task.Insert(&Task{Title: "old bug report"})
+	assert.NilError(t, err)
+
+	index := newTermIndex()
+	crud := NewCRUDWithOptions(db, rt.Options{SearchIndex: index})
+	assert.NilError(t, crud.Init())
+	indexed, err := crud.Task.ReindexSearch()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(indexed, int64(1)), "rows written before the index need a reindex")
+
+	bugs, err := crud.Task.Insert(&Task{Title: "fix bug after bug"})
+	assert.NilError(t, err)
+	docs, err := crud.Task.Insert(&Task{Title: "write docs"})
+	assert.NilError(t, err)
+	tally, err := crud.Tally.Insert(&Tally{Name: "scores", Tags: []string{"weekly", "bug bash"}})
+	assert.NilError(t, err)
+	_, err = crud.Person.Insert(&Person{Name: "bug hunter"})
+	assert.NilError(t, err)
+
+	ids, err := crud.Task.SearchFullText("bug", 10)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(ids, []string{old.ID}), "the index changes on sync only")
+
+	synced, err := crud.SyncSearchIndex(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(synced, int64(3)), "only searchable tables record changes")
+	ids, err = crud.Task.SearchFullText("bug", 10)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(ids, []string{bugs.ID, old.ID}), "ranked by relevance")
+	ids, err = crud.Task.SearchFullText("bug", 1)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(ids, []string{bugs.ID}))
+	ids, err = crud.Tally.SearchFullText("bash", 10)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(ids, []string{tally.ID}), "repeated fields are searchable")
+	assert.Check(t, is.Equal(index.docs[TallyTableName+"/"+tally.ID]["tags"], "weekly\nbug bash"))
+
+	_, err = crud.Task.UpdateByID(docs.ID, &Task{Title: "write bug docs"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Task.DeleteByID(bugs.ID))
+	_, err = crud.SyncSearchIndex(ctx)
+	assert.NilError(t, err)
+	ids, err = crud.Task.SearchFullText("bug", 10)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(ids, []string{min(docs.ID, old.ID), max(docs.ID, old.ID)}), "equal scores tie by id")
+	assert.Check(t, is.Len(index.docs, 3), "deleted rows leave the index")
+}
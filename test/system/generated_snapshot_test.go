@@ -0,0 +1,301 @@
+package genexample
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rt "github.com/fingon/proprdb/rt"
+	_ "github.com/mattn/go-sqlite3"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestGeneratedSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	sourceDB, err := sql.Open("sqlite3", "file:source-snapshot?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, sourceDB.Close())
+	})
+	targetDB, err := sql.Open("sqlite3", "file:target-snapshot?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, targetDB.Close())
+	})
+
+	source := NewCRUD(sourceDB)
+	assert.NilError(t, source.Init())
+	target := NewCRUD(targetDB)
+	assert.NilError(t, target.Init())
+
+	person, err := source.Person.Insert(&Person{Name: "Ada", Age: 37})
+	assert.NilError(t, err)
+	note, err := source.Note.Insert(&Note{Text: "not synced, but snapshotted"})
+	assert.NilError(t, err)
+	deletedPerson, err := source.Person.Insert(&Person{Name: "Gone", Age: 1})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Person.DeleteByID(deletedPerson.ID))
+	_, err = sourceDB.ExecContext(ctx, insertUnknownRowSQL, unknownTypeName, unknownID, 5, 0, `{"@type":"`+typeURLPrefix+unknownTypeName+`","value":"x"}`)
+	assert.NilError(t, err)
+
+	var snapshot bytes.Buffer
+	assert.NilError(t, source.WriteSnapshot(&snapshot))
+
+	firstLine, _, _ := strings.Cut(snapshot.String(), "\n")
+	var headerFrame struct {
+		Header rt.SnapshotHeader `json:"proprdbSnapshot"`
+	}
+	assert.NilError(t, json.Unmarshal([]byte(firstLine), &headerFrame))
+	header := headerFrame.Header
+	assert.Check(t, is.Equal(header.Version, rt.SnapshotFormatVersion))
+	assert.Check(t, is.Equal(header.UnknownRecords, int64(1)))
+	assert.Check(t, is.DeepEqual(header.Tables[0], rt.SnapshotTable{
+		TableName:  PersonTableName,
+		TypeName:   PersonTypeName,
+		SchemaHash: PersonProjectionSchema,
+		Rows:       1,
+		Tombstones: 1,
+	}))
+
+	snapshotText := snapshot.String()
+	assert.NilError(t, target.ReadSnapshot(strings.NewReader(snapshotText)))
+	assert.NilError(t, target.ReadSnapshot(strings.NewReader(snapshotText)))
+
+	people, err := target.Person.Select(selectByIDSQL, person.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(people, 1))
+	assert.Check(t, is.Equal(people[0].AtNs, person.AtNs))
+	assert.Check(t, is.Equal(people[0].Data.GetName(), "Ada"))
+
+	notes, err := target.Note.Select(selectByIDSQL, note.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(notes, 1))
+	assert.Check(t, is.Equal(notes[0].Data.GetText(), "not synced, but snapshotted"))
+
+	tombstones, err := rt.ListTombstones(targetDB, PersonTableName)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(tombstones, []rt.Tombstone{{ID: deletedPerson.ID, AtNs: tombstones[0].AtNs}}))
+
+	var unknownCount int
+	assert.NilError(t, targetDB.QueryRowContext(ctx, selectUnknownCountByIDSQL, unknownTypeName, unknownID).Scan(&unknownCount))
+	assert.Check(t, is.Equal(unknownCount, 1))
+
+	var syncCount int
+	assert.NilError(t, targetDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM _sync").Scan(&syncCount))
+	assert.Check(t, is.Equal(syncCount, 0))
+}
+
+func TestGeneratedSnapshotRejectsTruncatedInput(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:truncated-snapshot?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	_, err = crud.Person.Insert(&Person{Name: "Ada", Age: 37})
+	assert.NilError(t, err)
+
+	var snapshot bytes.Buffer
+	assert.NilError(t, crud.WriteSnapshot(&snapshot))
+	lines := strings.SplitAfter(snapshot.String(), "\n")
+
+	withoutTrailer := strings.Join(lines[:len(lines)-2], "")
+	assert.ErrorContains(t, crud.ReadSnapshot(strings.NewReader(withoutTrailer)), "missing trailer")
+
+	withoutRecord := lines[0] + `{"proprdbSnapshotEnd":{"records":0}}` + "\n"
+	assert.ErrorContains(t, crud.ReadSnapshot(strings.NewReader(withoutRecord)), "header announced")
+}
+
+func TestGeneratedAdminJSONLRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	sourceDB := openCLITestDB(t, filepath.Join(t.TempDir(), "source.db"))
+	source := NewCRUD(sourceDB)
+	assert.NilError(t, source.Init())
+	ada, err := source.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	assert.NilError(t, source.WriteJSONL(testRemoteA, io.Discard))
+	gone, err := source.Person.Insert(&Person{Name: "Gone", Age: 1})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Person.DeleteByID(gone.ID))
+	_, err = sourceDB.ExecContext(ctx, `INSERT INTO _rejected (remote, line, error, rejected_at_ns) VALUES ('peer', '{}', 'bad', 7)`)
+	assert.NilError(t, err)
+
+	var admin bytes.Buffer
+	assert.NilError(t, source.WriteAdminJSONL(&admin))
+	assert.Check(t, is.Contains(admin.String(), `"proprdbCore":{"table":"_sync"`))
+
+	targetDB := openCLITestDB(t, filepath.Join(t.TempDir(), "target.db"))
+	target := NewCRUD(targetDB)
+	assert.NilError(t, target.Init())
+	assert.NilError(t, target.ReadSnapshot(bytes.NewReader(admin.Bytes())))
+	var syncCount int
+	assert.NilError(t, targetDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM _sync").Scan(&syncCount))
+	assert.Check(t, is.Equal(syncCount, 0), "ReadSnapshot skips the core rows")
+
+	_, err = targetDB.ExecContext(ctx, `INSERT INTO _sync (object_id, table_name, at_ns, remote) VALUES ('stale', ?, 1, 'other')`, PersonTableName)
+	assert.NilError(t, err)
+	assert.NilError(t, target.ReadAdminJSONL(bytes.NewReader(admin.Bytes())))
+	sourceRows, err := rt.ListCoreRows(sourceDB)
+	assert.NilError(t, err)
+	targetRows, err := rt.ListCoreRows(targetDB)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(targetRows, sourceRows), "core tables are replaced")
+	tombstones, err := rt.ListTombstones(targetDB, PersonTableName)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(tombstones, 1))
+	adaRow, err := target.Person.GetByID(ada.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(adaRow.AtNs, ada.AtNs))
+
+	var replay bytes.Buffer
+	assert.NilError(t, target.WriteJSONL(testRemoteA, &replay))
+	assert.Check(t, !strings.Contains(replay.String(), ada.ID), "the remote already has the rows it had")
+	assert.Check(t, strings.Contains(replay.String(), gone.ID))
+}
+
+func TestGeneratedBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	sourceDB := openCLITestDB(t, filepath.Join(t.TempDir(), "source.db")+"?_journal_mode=WAL")
+	source := NewCRUD(sourceDB)
+	assert.NilError(t, source.Init())
+	ada, err := source.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	gone, err := source.Person.Insert(&Person{Name: "Gone", Age: 1})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Person.DeleteByID(gone.ID))
+	page, err := source.Page.Insert(&Page{Title: "Kept"})
+	assert.NilError(t, err)
+
+	// Writers keep going while the backup runs.
+	writerErr := make(chan error, 1)
+	go func() {
+		for range 20 {
+			if _, err := source.Task.Insert(&Task{Title: "concurrent"}); err != nil {
+				writerErr <- err
+				return
+			}
+		}
+		writerErr <- nil
+	}()
+	var backup bytes.Buffer
+	assert.NilError(t, source.Backup(ctx, &backup))
+	assert.NilError(t, <-writerErr)
+
+	targetDB := openCLITestDB(t, filepath.Join(t.TempDir(), "target.db"))
+	target := NewCRUDWithOptions(targetDB, rt.Options{Cache: rt.CacheOptions{Size: 8}})
+	assert.NilError(t, target.Init())
+	stale, err := target.Person.Insert(&Person{Name: "Stale", Age: 1})
+	assert.NilError(t, err)
+	staleRow, err := target.Person.GetByID(stale.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(staleRow.Data.GetName(), "Stale"))
+
+	assert.NilError(t, target.Restore(ctx, bytes.NewReader(backup.Bytes())))
+	_, err = target.Person.GetByID(stale.ID)
+	assert.Check(t, errors.Is(err, rt.ErrNotFound), "restore replaces the database and purges caches")
+	adaRow, err := target.Person.GetByID(ada.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(adaRow.Data.GetName(), "Ada"))
+	versions, err := target.Page.History(page.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(versions, 1), "history tables are part of backups")
+	tombstones, err := rt.ListTombstones(targetDB, PersonTableName)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(tombstones, 1))
+	plan, err := target.Person.PlanInit()
+	assert.NilError(t, err)
+	assert.Check(t, plan.Empty(), "indexes are restored: %s", plan.String())
+
+	err = target.Restore(ctx, strings.NewReader("not a database"))
+	assert.Check(t, errors.Is(err, rt.ErrNotADatabase))
+	adaRow, err = target.Person.GetByID(ada.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(adaRow.Data.GetName(), "Ada"))
+}
+
+const (
+	copyRevivedID = "01900000-0000-7000-8000-000000000001"
+	copyGoneID    = "01900000-0000-7000-8000-000000000002"
+	copySharedID  = "01900000-0000-7000-8000-000000000003"
+)
+
+func TestGeneratedCopyTables(t *testing.T) {
+	ctx := context.Background()
+	sourceDB := openCLITestDB(t, filepath.Join(t.TempDir(), "source.db"))
+	source := NewCRUD(sourceDB)
+	assert.NilError(t, source.Init())
+	targetDB := openCLITestDB(t, filepath.Join(t.TempDir(), "target.db"))
+	target := NewCRUD(targetDB)
+	assert.NilError(t, target.Init())
+
+	_, err := target.Person.InsertWithID(copyRevivedID, &Person{Name: "Dead"})
+	assert.NilError(t, err)
+	assert.NilError(t, target.Person.DeleteByID(copyRevivedID))
+	_, err = target.Person.InsertWithID(copyGoneID, &Person{Name: "Local"})
+	assert.NilError(t, err)
+
+	_, err = source.Person.InsertWithID(copySharedID, &Person{Name: "Old"})
+	assert.NilError(t, err)
+	ada, err := source.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	_, err = source.Person.InsertWithID(copyRevivedID, &Person{Name: "Revived"})
+	assert.NilError(t, err)
+	_, err = source.Person.InsertWithID(copyGoneID, &Person{Name: "Gone"})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Person.DeleteByID(copyGoneID))
+	event, err := source.Event.Insert(&Event{Kind: "copied", Labels: map[string]string{"env": "prod"}})
+	assert.NilError(t, err)
+	page, err := source.Page.Insert(&Page{Title: "Kept"})
+	assert.NilError(t, err)
+	_, err = sourceDB.ExecContext(ctx, `INSERT INTO _sync (object_id, table_name, at_ns, remote) VALUES (?, ?, ?, 'peer')`, ada.ID, PersonTableName, ada.AtNs)
+	assert.NilError(t, err)
+
+	_, err = target.Person.InsertWithID(copySharedID, &Person{Name: "Local newer"})
+	assert.NilError(t, err)
+
+	tables := []string{PersonTableName, EventTableName, PageTableName}
+	report, err := rt.CopyTables(sourceDB, targetDB, tables, 0)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(report, rt.CopyReport{Rows: 4, Tombstones: 1, SyncStates: 1}))
+
+	people, err := target.Person.Select(`1 = 1 ORDER BY name`)
+	assert.NilError(t, err)
+	names := make([]string, 0, len(people))
+	for _, person := range people {
+		names = append(names, person.Data.GetName())
+	}
+	assert.Check(t, is.DeepEqual(names, []string{"Ada", "Local newer", "Revived"}))
+	tombstones, err := rt.ListTombstones(targetDB, PersonTableName)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(tombstones, 1))
+	assert.Check(t, is.Equal(tombstones[0].ID, copyGoneID))
+	labeled, err := target.Event.Select(`id IN (SELECT id FROM `+EventLabelsTableName+` WHERE key = 'env' AND value = 'prod')`)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(labeled, 1))
+	assert.Check(t, is.Equal(labeled[0].ID, event.ID))
+	versions, err := target.Page.History(page.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(versions, 1))
+	var syncedAtNs int64
+	assert.NilError(t, targetDB.QueryRowContext(ctx, `SELECT at_ns FROM _sync WHERE object_id = ? AND remote = 'peer'`, ada.ID).Scan(&syncedAtNs))
+	assert.Check(t, is.Equal(syncedAtNs, ada.AtNs))
+
+	report, err = rt.CopyTables(sourceDB, targetDB, tables, 0)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(report, rt.CopyReport{}), "copying again changes nothing")
+
+	_, err = targetDB.ExecContext(ctx, `UPDATE _proprdb_schema SET schema_hash = 'other' WHERE table_name = ?`, PersonTableName)
+	assert.NilError(t, err)
+	_, err = rt.CopyTables(sourceDB, targetDB, tables, 0)
+	assert.Check(t, errors.Is(err, rt.ErrSchemaMismatch))
+	_, err = rt.CopyTables(sourceDB, sourceDB, tables, 0)
+	assert.Check(t, is.ErrorContains(err, "same database"))
+}
@@ -0,0 +1,95 @@
+package genexample
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func tableStats(t *testing.T, stats rt.Stats, tableName string) rt.TableStats {
+	t.Helper()
+	for _, table := range stats.Tables {
+		if table.TableName == tableName {
+			return table
+		}
+	}
+	t.Fatalf("no stats for %s", tableName)
+	return rt.TableStats{}
+}
+
+func TestGeneratedStats(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "stats.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{Clock: &rt.StepClock{Start: 1000, Step: 10}})
+	before := time.Now()
+	assert.NilError(t, crud.Init())
+
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	grace, err := crud.Person.Insert(&Person{Name: "Grace"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.WriteJSONL(testRemoteA, io.Discard))
+	assert.NilError(t, crud.Person.DeleteByID(grace.ID))
+	_, err = crud.Person.UpdateByID(ada.ID, &Person{Name: "Ada Lovelace"})
+	assert.NilError(t, err)
+	assert.NilError(t, rt.UnknownInsert(db, "future.Type", rt.JSONLRecord{ID: "x", AtNs: 1, Data: json.RawMessage(`{}`)}))
+
+	stats, err := crud.Stats()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(stats.Tables, len(crud.TableDescriptors())))
+	person := tableStats(t, stats, PersonTableName)
+	assert.Check(t, is.Equal(person.ObjectCount, int64(1)))
+	assert.Check(t, is.Equal(person.Tombstones, int64(1)))
+	assert.Check(t, person.SchemaCurrent)
+	assert.Check(t, is.Equal(stats.UnknownRecords, int64(1)))
+	assert.Check(t, stats.SchemaCurrent)
+	assert.Check(t, !stats.LastInit.Before(before))
+	assert.Assert(t, is.Len(stats.Remotes, 1))
+	assert.Check(t, is.Equal(stats.Remotes[0].Remote, testRemoteA))
+	assert.Check(t, is.Equal(stats.Remotes[0].LagNs, int64(20)), "the delete and update are not exported yet")
+
+	_, err = db.Exec(`UPDATE _proprdb_schema SET schema_hash = 'old' WHERE table_name = ?`, PersonTableName)
+	assert.NilError(t, err)
+	stats, err = crud.Stats()
+	assert.NilError(t, err)
+	assert.Check(t, !stats.SchemaCurrent)
+	assert.Check(t, !tableStats(t, stats, PersonTableName).SchemaCurrent)
+	assert.Check(t, tableStats(t, stats, TaskTableName).SchemaCurrent)
+
+	handler := rt.NewStatsHandler(crud.Stats)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	assert.Check(t, is.Equal(recorder.Code, http.StatusOK))
+	assert.Check(t, is.Equal(recorder.Header().Get("Content-Type"), "application/json"))
+	var decoded rt.Stats
+	assert.NilError(t, json.Unmarshal(recorder.Body.Bytes(), &decoded))
+	assert.Check(t, is.Equal(tableStats(t, decoded, PersonTableName).Tombstones, int64(1)))
+	assert.Check(t, is.Contains(recorder.Body.String(), `"lagNs":20`))
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Accept", "text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Check(t, is.Equal(recorder.Code, http.StatusOK))
+	assert.Check(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	metrics := recorder.Body.String()
+	assert.Check(t, is.Contains(metrics, "# TYPE proprdb_table_objects gauge\n"))
+	assert.Check(t, is.Contains(metrics, `proprdb_table_tombstones{table="`+PersonTableName+`"} 1`+"\n"))
+	assert.Check(t, is.Contains(metrics, `proprdb_sync_lag_seconds{remote="`+testRemoteA+`",table="`+PersonTableName+`"} 2e-08`+"\n"))
+	assert.Check(t, is.Contains(metrics, "proprdb_schema_current 0\n"))
+	assert.Check(t, is.Contains(metrics, "proprdb_unknown_records 1\n"))
+
+	failing := rt.NewStatsHandler(func() (rt.Stats, error) { return rt.Stats{}, errors.New("database is locked") })
+	recorder = httptest.NewRecorder()
+	failing.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	assert.Check(t, is.Equal(recorder.Code, http.StatusServiceUnavailable))
+}
@@ -2,13 +2,25 @@ package genexample
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/ed25519"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	rt "github.com/fingon/proprdb/rt"
 	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
 	"gotest.tools/v3/assert"
 	is "gotest.tools/v3/assert/cmp"
 )
@@ -125,15 +137,17 @@ func TestGeneratedJSONLSync(t *testing.T) {
 		targetPeople[0].AtNs+1,
 		typeURLPrefix+PersonTypeName,
 	)
-	if err := target.ReadJSONL(testRemoteA, strings.NewReader(invalidByValidateLine)); err != nil {
-		t.Fatalf("read invalid-by-valid line into target: %v", err)
-	}
+	err = target.ReadJSONL(testRemoteA, strings.NewReader(invalidByValidateLine))
+	assert.Check(t, is.ErrorContains(err, "name is required"))
 	targetPeople, err = target.Person.Select(selectByIDSQL, personRow.ID)
 	if err != nil {
 		t.Fatalf("select target person after invalid-by-valid import: %v", err)
 	}
 	assert.Check(t, is.Len(targetPeople, 1))
-	assert.Check(t, is.Equal(targetPeople[0].Data.GetName(), ""))
+	assert.Check(t, is.Equal(targetPeople[0].Data.GetName(), "Ada Updated"))
+	rejected, err := target.ListRejected()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rejected, 0))
 
 	localNewer, err := target.Person.UpdateByID(personRow.ID, &Person{Name: "Local Newer", Age: 99})
 	if err != nil {
@@ -301,3 +315,1509 @@ func TestGeneratedInitDrainsUnknownRowsForKnownType(t *testing.T) {
 	assert.NilError(t, err)
 	assert.Check(t, is.Equal(unknownRowCount, 0))
 }
+
+func TestGeneratedJSONLConflictStrategies(t *testing.T) {
+	const conflictID = "018f4f3f-6f9f-7a1b-8f55-1234567890ad"
+	personLine := func(atNs int64, name string) string {
+		return fmt.Sprintf("{\"id\":%q,\"atNs\":%d,\"data\":{\"@type\":%q,\"name\":%q}}\n", conflictID, atNs, typeURLPrefix+PersonTypeName, name)
+	}
+	taskLine := func(atNs int64, title string, done bool) string {
+		return fmt.Sprintf("{\"id\":%q,\"atNs\":%d,\"data\":{\"@type\":%q,\"title\":%q,\"done\":%t}}\n", conflictID, atNs, typeURLPrefix+TaskTypeName, title, done)
+	}
+	openCRUD := func(t *testing.T, name string, opts rt.Options) *CRUD {
+		t.Helper()
+		db, err := sql.Open("sqlite3", "file:"+name+"?mode=memory&cache=shared")
+		assert.NilError(t, err)
+		t.Cleanup(func() {
+			assert.NilError(t, db.Close())
+		})
+		crud := NewCRUDWithOptions(db, opts)
+		assert.NilError(t, crud.Init())
+		return crud
+	}
+	personName := func(t *testing.T, crud *CRUD) string {
+		t.Helper()
+		rows, err := crud.Person.Select(selectByIDSQL, conflictID)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(rows, 1))
+		return rows[0].Data.GetName()
+	}
+
+	t.Run("last writer wins by default", func(t *testing.T) {
+		crud := openCRUD(t, "conflict-lww", rt.Options{})
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(personLine(200, "local"))))
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(personLine(100, "older"))))
+		assert.Check(t, is.Equal(personName(t, crud), "local"))
+	})
+
+	t.Run("remote wins", func(t *testing.T) {
+		opts := rt.WithConflictStrategy(rt.Options{}, PersonTypeName, rt.ConflictRemoteWins)
+		crud := openCRUD(t, "conflict-remote-wins", opts)
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(personLine(200, "local"))))
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(personLine(100, "remote"))))
+		assert.Check(t, is.Equal(personName(t, crud), "remote"))
+	})
+
+	t.Run("local wins", func(t *testing.T) {
+		opts := rt.WithConflictStrategy(rt.Options{}, PersonTypeName, rt.ConflictLocalWins)
+		crud := openCRUD(t, "conflict-local-wins", opts)
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(personLine(100, "first"))))
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(personLine(200, "newer"))))
+		assert.Check(t, is.Equal(personName(t, crud), "first"))
+	})
+
+	t.Run("merge without merger fails", func(t *testing.T) {
+		crud := openCRUD(t, "conflict-merge-missing", rt.Options{})
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(taskLine(100, "local", false))))
+		err := crud.ReadJSONL(testRemoteA, strings.NewReader(taskLine(200, "remote", true)))
+		assert.ErrorContains(t, err, "no merge function")
+		assert.Check(t, is.ErrorIs(err, rt.ErrConflict))
+	})
+
+	t.Run("merge of another type fails", func(t *testing.T) {
+		opts := rt.WithMerge(rt.Options{}, TaskTypeName, func(local, _ *Person) (*Person, error) {
+			return local, nil
+		})
+		crud := openCRUD(t, "conflict-merge-type", opts)
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(taskLine(100, "local", false))))
+		err := crud.ReadJSONL(testRemoteA, strings.NewReader(taskLine(200, "remote", true)))
+		assert.Check(t, is.ErrorIs(err, rt.ErrUnknownType))
+		assert.Check(t, is.ErrorIs(err, rt.ErrConflict))
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		opts := rt.WithMerge(rt.Options{}, TaskTypeName, func(local, remote *Task) (*Task, error) {
+			return &Task{
+				Title: local.GetTitle() + "+" + remote.GetTitle(),
+				Done:  local.GetDone() || remote.GetDone(),
+			}, nil
+		})
+		crud := openCRUD(t, "conflict-merge", opts)
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(taskLine(100, "local", true))))
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(taskLine(50, "remote", false))))
+		rows, err := crud.Task.Select(selectByIDSQL, conflictID)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(rows, 1))
+		assert.Check(t, is.Equal(rows[0].Data.GetTitle(), "local+remote"))
+		assert.Check(t, rows[0].Data.GetDone())
+		assert.Check(t, rows[0].AtNs > 100)
+	})
+}
+
+func TestGeneratedJSONLFieldMergeIsOrderIndependent(t *testing.T) {
+	const tallyID = "018f4f3f-6f9f-7a1b-8f55-1234567890ae"
+	lineA := fmt.Sprintf("{\"id\":%q,\"atNs\":100,\"data\":{\"@type\":%q,\"name\":\"a\",\"highScore\":\"7\",\"plays\":{\"replica-a\":\"3\"},\"tags\":[\"red\",\"blue\"]}}\n", tallyID, typeURLPrefix+TallyTypeName)
+	lineB := fmt.Sprintf("{\"id\":%q,\"atNs\":200,\"data\":{\"@type\":%q,\"name\":\"b\",\"highScore\":\"5\",\"plays\":{\"replica-a\":\"1\",\"replica-b\":\"2\"},\"tags\":[\"green\",\"red\"]}}\n", tallyID, typeURLPrefix+TallyTypeName)
+
+	results := make([]TallyRow, 0, 2)
+	for index, lines := range [][]string{{lineA, lineB}, {lineB, lineA}} {
+		db, err := sql.Open("sqlite3", fmt.Sprintf("file:tally-merge-%d?mode=memory&cache=shared", index))
+		assert.NilError(t, err)
+		t.Cleanup(func() {
+			assert.NilError(t, db.Close())
+		})
+		crud := NewCRUD(db)
+		assert.NilError(t, crud.Init())
+		for _, line := range lines {
+			assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(line)))
+		}
+		rows, err := crud.Tally.Select(selectByIDSQL, tallyID)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(rows, 1))
+		results = append(results, rows[0])
+	}
+
+	for _, row := range results {
+		assert.Check(t, is.Equal(row.Data.GetName(), "b"))
+		assert.Check(t, is.Equal(row.Data.GetHighScore(), int64(7)))
+		assert.Check(t, is.DeepEqual(row.Data.GetPlays(), map[string]int64{"replica-a": 3, "replica-b": 2}))
+		assert.Check(t, is.Equal(rt.CounterTotal(row.Data.GetPlays()), int64(5)))
+		assert.Check(t, is.DeepEqual(row.Data.GetTags(), []string{"blue", "green", "red"}))
+		assert.Check(t, is.Equal(row.AtNs, int64(201)))
+	}
+}
+
+func TestGeneratedJSONLVersionVectors(t *testing.T) {
+	openCRUD := func(t *testing.T, name string, opts rt.Options) *CRUD {
+		t.Helper()
+		db, err := sql.Open("sqlite3", "file:"+name+"?mode=memory&cache=shared")
+		assert.NilError(t, err)
+		t.Cleanup(func() {
+			assert.NilError(t, db.Close())
+		})
+		crud := NewCRUDWithOptions(db, opts)
+		assert.NilError(t, crud.Init())
+		return crud
+	}
+	exportTo := func(t *testing.T, source, target *CRUD) {
+		t.Helper()
+		var buffer bytes.Buffer
+		assert.NilError(t, source.WriteJSONL(testRemoteA, &buffer))
+		assert.NilError(t, target.ReadJSONL(testRemoteA, &buffer))
+	}
+
+	t.Run("requires device id", func(t *testing.T) {
+		crud := openCRUD(t, "vv-no-device", rt.Options{})
+		_, err := crud.Document.Insert(&Document{Title: "draft"})
+		assert.ErrorContains(t, err, "Options.DeviceID")
+	})
+
+	t.Run("causally newer wins despite clock skew", func(t *testing.T) {
+		crud := openCRUD(t, "vv-skew", rt.Options{DeviceID: "local"})
+		row, err := crud.Document.Insert(&Document{Title: "local"})
+		assert.NilError(t, err)
+		line := fmt.Sprintf("{\"id\":%q,\"atNs\":1,\"vv\":{\"local\":1,\"remote\":1},\"data\":{\"@type\":%q,\"title\":\"remote\"}}\n", row.ID, typeURLPrefix+DocumentTypeName)
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(line)))
+		rows, err := crud.Document.Select(selectByIDSQL, row.ID)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(rows, 1))
+		assert.Check(t, is.Equal(rows[0].Data.GetTitle(), "remote"))
+		versionVector, err := crud.Document.VersionVector(row.ID)
+		assert.NilError(t, err)
+		assert.Check(t, is.DeepEqual(versionVector, rt.VersionVector{"local": 1, "remote": 1}))
+
+		stale := fmt.Sprintf("{\"id\":%q,\"atNs\":%d,\"vv\":{\"local\":1},\"data\":{\"@type\":%q,\"title\":\"stale\"}}\n", row.ID, row.AtNs+1000, typeURLPrefix+DocumentTypeName)
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(stale)))
+		rows, err = crud.Document.Select(selectByIDSQL, row.ID)
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(rows[0].Data.GetTitle(), "remote"))
+	})
+
+	t.Run("concurrent edits reach callback", func(t *testing.T) {
+		var edits []rt.ConcurrentEdit
+		deviceA := openCRUD(t, "vv-device-a", rt.Options{DeviceID: "a"})
+		deviceB := openCRUD(t, "vv-device-b", rt.Options{
+			DeviceID: "b",
+			OnConcurrentEdit: func(edit rt.ConcurrentEdit) (proto.Message, error) {
+				edits = append(edits, edit)
+				local := edit.Local.(*Document)
+				remote := edit.Remote.(*Document)
+				return &Document{Title: local.GetTitle() + "|" + remote.GetTitle()}, nil
+			},
+		})
+
+		row, err := deviceA.Document.Insert(&Document{Title: "base"})
+		assert.NilError(t, err)
+		exportTo(t, deviceA, deviceB)
+
+		_, err = deviceA.Document.UpdateByID(row.ID, &Document{Title: "from-a"})
+		assert.NilError(t, err)
+		_, err = deviceB.Document.UpdateByID(row.ID, &Document{Title: "from-b"})
+		assert.NilError(t, err)
+		exportTo(t, deviceA, deviceB)
+
+		assert.Assert(t, is.Len(edits, 1))
+		assert.Check(t, is.Equal(edits[0].TypeName, DocumentTypeName))
+		assert.Check(t, is.DeepEqual(edits[0].LocalVersion, rt.VersionVector{"a": 1, "b": 1}))
+		assert.Check(t, is.DeepEqual(edits[0].RemoteVersion, rt.VersionVector{"a": 2}))
+
+		rows, err := deviceB.Document.Select(selectByIDSQL, row.ID)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(rows, 1))
+		assert.Check(t, is.Equal(rows[0].Data.GetTitle(), "from-b|from-a"))
+		versionVector, err := deviceB.Document.VersionVector(row.ID)
+		assert.NilError(t, err)
+		assert.Check(t, is.DeepEqual(versionVector, rt.VersionVector{"a": 2, "b": 2}))
+
+		exportTo(t, deviceB, deviceA)
+		rows, err = deviceA.Document.Select(selectByIDSQL, row.ID)
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(rows[0].Data.GetTitle(), "from-b|from-a"))
+		assert.Check(t, is.Len(edits, 1))
+	})
+}
+
+func TestGeneratedJSONLSyncFilters(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:sync-filters?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUDWithOptions(db, rt.Options{SyncPolicy: rt.SyncPolicy{Remotes: map[string]rt.RemoteSyncFilter{
+		"alpha": {
+			Types: []string{PersonTypeName},
+			Where: map[string]string{PersonTypeName: "age > 5"},
+			Predicates: map[string]func(proto.Message) bool{
+				PersonTypeName: func(message proto.Message) bool {
+					return strings.HasPrefix(message.(*Person).GetName(), "A")
+				},
+			},
+		},
+	}}})
+	assert.NilError(t, crud.Init())
+
+	adult, err := crud.Person.Insert(&Person{Name: "Ada", Age: 37})
+	assert.NilError(t, err)
+	_, err = crud.Person.Insert(&Person{Name: "Kid", Age: 10})
+	assert.NilError(t, err)
+	_, err = crud.Person.Insert(&Person{Name: "Al", Age: 3})
+	assert.NilError(t, err)
+	task, err := crud.Task.Insert(&Task{Title: "not for alpha"})
+	assert.NilError(t, err)
+	deletedTask, err := crud.Task.Insert(&Task{Title: "deleted"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Task.DeleteByID(deletedTask.ID))
+
+	exportedIDs := func(remote string) []string {
+		t.Helper()
+		var buffer bytes.Buffer
+		assert.NilError(t, crud.WriteJSONL(remote, &buffer))
+		ids := make([]string, 0)
+		assert.NilError(t, rt.ReadJSONL(&buffer, func(record rt.JSONLRecord, _ int) error {
+			ids = append(ids, record.ID)
+			return nil
+		}))
+		return ids
+	}
+
+	assert.Check(t, is.DeepEqual(exportedIDs("alpha"), []string{adult.ID}))
+
+	adultsIDs := exportedIDs("adults")
+	assert.Check(t, is.Len(adultsIDs, 3))
+	assert.Check(t, is.Equal(adultsIDs[0], adult.ID))
+	assert.Check(t, is.Contains(adultsIDs, task.ID))
+	assert.Check(t, is.Contains(adultsIDs, deletedTask.ID))
+
+	assert.Check(t, is.Len(exportedIDs(testRemoteA), 5))
+}
+
+type failingWriter struct {
+	writes    int
+	failAfter int
+	buffer    bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.writes >= w.failAfter {
+		return 0, fmt.Errorf("connection lost")
+	}
+	w.writes++
+	return w.buffer.Write(p)
+}
+
+func TestGeneratedJSONLChunksResume(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:chunked-sync?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	for index := range 5 {
+		_, err := crud.Person.Insert(&Person{Name: fmt.Sprintf("person-%d", index), Age: int64(index)})
+		assert.NilError(t, err)
+	}
+
+	type progressCall struct{ Sent, Total int64 }
+	var calls []progressCall
+	recordProgress := func(sent, total int64) {
+		calls = append(calls, progressCall{sent, total})
+	}
+
+	interrupted := &failingWriter{failAfter: 1}
+	err = crud.WriteJSONLChunks(testRemoteA, interrupted, 2, recordProgress)
+	assert.ErrorContains(t, err, "connection lost")
+	assert.Check(t, is.Len(strings.Split(strings.TrimSpace(interrupted.buffer.String()), "\n"), 2))
+	assert.Check(t, is.DeepEqual(calls, []progressCall{{2, 5}}))
+
+	calls = nil
+	var resumed bytes.Buffer
+	assert.NilError(t, crud.WriteJSONLChunks(testRemoteA, &resumed, 2, recordProgress))
+	assert.Check(t, is.Len(strings.Split(strings.TrimSpace(resumed.String()), "\n"), 3))
+	assert.Check(t, is.DeepEqual(calls, []progressCall{{2, 3}, {3, 3}}))
+
+	assert.ErrorContains(t, crud.WriteJSONLChunks(testRemoteA, &resumed, 0, nil), "invalid chunk size")
+}
+
+// passthroughZstd stands in for a zstd library: it only writes the zstd
+// magic, which is all ReadJSONL detects.
+type passthroughZstd struct{}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (passthroughZstd) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, err := w.Write([]byte{0x28, 0xb5, 0x2f, 0xfd}); err != nil {
+		return nil, err
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func (passthroughZstd) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, r, 4); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(r), nil
+}
+
+func TestGeneratedJSONLCompressionAndFraming(t *testing.T) {
+	for _, jsonl := range []rt.JSONLOptions{
+		{Compression: rt.JSONLCompressionGzip},
+		{Framing: rt.JSONLFramingLengthPrefixed},
+		{Compression: rt.JSONLCompressionGzip, Framing: rt.JSONLFramingLengthPrefixed},
+		{Compression: rt.JSONLCompressionZstd, Zstd: passthroughZstd{}},
+	} {
+		name := fmt.Sprintf("%s-%s", jsonl.Compression, jsonl.Framing)
+		source := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "source.db")), rt.Options{JSONL: jsonl})
+		assert.NilError(t, source.Init())
+		for index := range 5 {
+			_, err := source.Person.Insert(&Person{Name: strings.Repeat("person ", 20), Age: int64(index)})
+			assert.NilError(t, err)
+		}
+		var stream bytes.Buffer
+		assert.NilError(t, source.WriteJSONLChunks(testRemoteA, &stream, 2, nil), name)
+		switch jsonl.Compression {
+		case rt.JSONLCompressionGzip:
+			assert.Check(t, bytes.HasPrefix(stream.Bytes(), []byte{0x1f, 0x8b}), name)
+		case rt.JSONLCompressionNone:
+			assert.Check(t, is.Equal(stream.Bytes()[0], byte(0)), name)
+		}
+
+		// Compression and framing are detected; only zstd needs the codec.
+		target := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "target.db")))
+		assert.NilError(t, target.Init())
+		err := target.ReadJSONL(testRemoteA, bytes.NewReader(stream.Bytes()))
+		if jsonl.Zstd != nil {
+			assert.Check(t, is.ErrorContains(err, "without JSONLOptions.Zstd"), name)
+			target = NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "zstd.db")), rt.Options{JSONL: rt.JSONLOptions{Zstd: passthroughZstd{}}})
+			assert.NilError(t, target.Init())
+			err = target.ReadJSONL(testRemoteA, bytes.NewReader(stream.Bytes()))
+		}
+		assert.NilError(t, err, name)
+		rows, err := target.Person.Select("")
+		assert.NilError(t, err)
+		assert.Check(t, is.Len(rows, 5), name)
+	}
+
+	var stream bytes.Buffer
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "plain.db")), rt.Options{JSONL: rt.JSONLOptions{Compression: "lz4"}})
+	assert.NilError(t, crud.Init())
+	assert.Check(t, is.ErrorContains(crud.WriteJSONL(testRemoteA, &stream), `unknown jsonl compression "lz4"`))
+	assert.Check(t, is.ErrorContains(crud.ReadJSONL(testRemoteA, bytes.NewReader([]byte{0, 0, 0, 9, '{'})), "read jsonl frame 1"))
+}
+
+func TestGeneratedJSONLManifest(t *testing.T) {
+	source := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "source.db")), rt.Options{JSONL: rt.JSONLOptions{Manifest: true}})
+	assert.NilError(t, source.Init())
+	for index := range 3 {
+		_, err := source.Person.Insert(&Person{Name: fmt.Sprintf("person-%d", index), Age: int64(index)})
+		assert.NilError(t, err)
+	}
+	_, err := source.Task.Insert(&Task{Title: "write"})
+	assert.NilError(t, err)
+	var stream bytes.Buffer
+	assert.NilError(t, source.WriteJSONL(testRemoteEmpty, &stream))
+	lines := strings.SplitAfter(stream.String(), "\n")
+	assert.Assert(t, is.Len(lines, 6))
+	assert.Check(t, is.Contains(lines[4], `"records":4`))
+	assert.Check(t, is.Contains(lines[4], `"`+PersonTypeName+`":3`))
+
+	read := func(opts rt.JSONLOptions, data string) (*CRUD, error) {
+		t.Helper()
+		crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "target.db")), rt.Options{JSONL: opts})
+		assert.NilError(t, crud.Init())
+		return crud, crud.ReadJSONL(testRemoteA, strings.NewReader(data))
+	}
+	count := func(crud *CRUD) int {
+		t.Helper()
+		rows, err := crud.Person.Select("")
+		assert.NilError(t, err)
+		return len(rows)
+	}
+
+	crud, err := read(rt.JSONLOptions{RequireManifest: true}, stream.String())
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(count(crud), 3))
+
+	// A truncated stream lacks the manifest.
+	truncated := strings.Join(lines[:2], "")
+	crud, err = read(rt.JSONLOptions{}, truncated)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(count(crud), 2))
+	crud, err = read(rt.JSONLOptions{RequireManifest: true}, truncated)
+	assert.Check(t, errors.Is(err, rt.ErrJSONLManifest))
+	assert.Check(t, is.Equal(count(crud), 0))
+
+	// A dropped record mismatches the manifest even when it is not required.
+	dropped := lines[0] + strings.Join(lines[2:], "")
+	_, err = read(rt.JSONLOptions{}, dropped)
+	assert.Check(t, errors.Is(err, rt.ErrJSONLManifest))
+	assert.Check(t, is.ErrorContains(err, "read 3 records, manifest lists 4"))
+
+	corrupted := strings.Replace(stream.String(), "person-1", "person-X", 1)
+	crud, err = read(rt.JSONLOptions{RequireManifest: true}, corrupted)
+	assert.Check(t, is.ErrorContains(err, "content hash"))
+	assert.Check(t, is.Equal(count(crud), 0))
+}
+
+func TestGeneratedJSONLSignatures(t *testing.T) {
+	trustedKey, signingKey, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+	source := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "source.db")), rt.Options{JSONL: rt.JSONLOptions{SigningKey: signingKey}})
+	assert.NilError(t, source.Init())
+	_, err = source.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	var signed bytes.Buffer
+	assert.NilError(t, source.WriteJSONL(testRemoteEmpty, &signed))
+	assert.Check(t, is.Contains(signed.String(), `"signature":"`))
+	unsigned := strings.SplitAfter(signed.String(), "\n")[0]
+
+	read := func(data string) error {
+		t.Helper()
+		crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "target.db")), rt.Options{JSONL: rt.JSONLOptions{TrustedKeys: []ed25519.PublicKey{trustedKey}}})
+		assert.NilError(t, crud.Init())
+		err := crud.ReadJSONL(testRemoteA, strings.NewReader(data))
+		rows, selectErr := crud.Person.Select("")
+		assert.NilError(t, selectErr)
+		if err != nil {
+			assert.Check(t, is.Len(rows, 0))
+		}
+		return err
+	}
+	assert.NilError(t, read(signed.String()))
+	assert.Check(t, is.ErrorIs(read(unsigned), rt.ErrJSONLSignature))
+	assert.Check(t, is.ErrorContains(read(strings.Replace(signed.String(), `"records":1`, `"records":2`, 1)), "not signed by a trusted key"))
+
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "other.db")), rt.Options{JSONL: rt.JSONLOptions{TrustedKeys: []ed25519.PublicKey{otherKey}}})
+	assert.NilError(t, crud.Init())
+	assert.Check(t, is.ErrorIs(crud.ReadJSONL(testRemoteA, strings.NewReader(signed.String())), rt.ErrJSONLSignature))
+}
+
+func TestGeneratedSyncSwitches(t *testing.T) {
+	source := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "source.db")))
+	assert.NilError(t, source.Init())
+	person, err := source.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	_, err = source.Task.Insert(&Task{Title: "write"})
+	assert.NilError(t, err)
+
+	source.DisableSync(PersonTypeName)
+	assert.Check(t, !source.SyncEnabled(PersonTypeName))
+	var paused bytes.Buffer
+	assert.NilError(t, source.WriteJSONL(testRemoteA, &paused))
+	assert.Check(t, is.Len(strings.Split(strings.TrimSpace(paused.String()), "\n"), 1))
+	assert.Check(t, is.Contains(paused.String(), TaskTypeName))
+
+	// Rows skipped while paused stay pending.
+	assert.NilError(t, source.EnableSync(PersonTypeName))
+	var resumed bytes.Buffer
+	assert.NilError(t, source.WriteJSONL(testRemoteA, &resumed))
+	assert.Check(t, is.Contains(resumed.String(), person.ID))
+	assert.Check(t, !strings.Contains(resumed.String(), TaskTypeName))
+
+	// Person keeps its override; transactions share the switches of their
+	// CRUD.
+	assert.NilError(t, source.SetSyncDefault(false))
+	_, err = source.Task.Insert(&Task{Title: "review"})
+	assert.NilError(t, err)
+	_, err = source.Person.Insert(&Person{Name: "Grace"})
+	assert.NilError(t, err)
+	assert.NilError(t, source.WithTx(context.Background(), func(tx *CRUD) error {
+		var stream bytes.Buffer
+		if err := tx.WriteJSONL(testRemoteA, &stream); err != nil {
+			return err
+		}
+		assert.Check(t, is.Contains(stream.String(), "Grace"))
+		assert.Check(t, !strings.Contains(stream.String(), "review"))
+		return nil
+	}))
+
+	target := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "target.db")))
+	assert.NilError(t, target.Init())
+	target.DisableSync(PersonTypeName)
+	assert.NilError(t, target.ReadJSONL(testRemoteA, strings.NewReader(resumed.String())))
+	rows, err := target.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 0))
+	// Init keeps parked records while the type is paused.
+	assert.NilError(t, target.Init())
+	parked, err := rt.ListUnknownRecords(target.Person.q)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(parked, 1))
+
+	assert.NilError(t, target.EnableSync(PersonTypeName))
+	rows, err = target.Person.Select("")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, person.ID))
+	parked, err = rt.ListUnknownRecords(target.Person.q)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(parked, 0))
+}
+
+func TestGeneratedJSONLPreviewAndDiff(t *testing.T) {
+	source := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "source.db")))
+	assert.NilError(t, source.Init())
+	target := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "target.db")))
+	assert.NilError(t, target.Init())
+	ada, err := source.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	bob, err := source.Person.Insert(&Person{Name: "Bob"})
+	assert.NilError(t, err)
+	carl, err := source.Person.Insert(&Person{Name: "Carl"})
+	assert.NilError(t, err)
+	var initial bytes.Buffer
+	assert.NilError(t, source.WriteJSONL(testRemoteA, &initial))
+	assert.NilError(t, target.ReadJSONL(testRemoteA, &initial))
+
+	_, err = source.Person.UpdateByID(ada.ID, &Person{Name: "Ada Lovelace"})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Person.DeleteByID(bob.ID))
+	dan, err := source.Person.Insert(&Person{Name: "Dan"})
+	assert.NilError(t, err)
+	_, err = target.Person.UpdateByID(carl.ID, &Person{Name: "Carl (local)"})
+	assert.NilError(t, err)
+
+	preview, err := source.PreviewJSONL(testRemoteA)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(preview, map[string]int64{PersonTableName: 3}))
+
+	// Exporting everything also resends Carl, which is older than the target's.
+	var stream bytes.Buffer
+	assert.NilError(t, source.WriteJSONL(testRemoteEmpty, &stream))
+	stream.WriteString(fmt.Sprintf("{\"id\":%q,\"atNs\":100,\"data\":{\"@type\":%q}}\n", unknownID, typeURLPrefix+unknownTypeName))
+	expected := rt.JSONLDiff{
+		Tables:  map[string]rt.SyncChanges{PersonTableName: {Creates: 1, Updates: 1, Deletes: 1, Conflicts: 1}},
+		Unknown: 1,
+	}
+	diff, err := target.DiffJSONL(bytes.NewReader(stream.Bytes()))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(diff, expected))
+	assert.Check(t, is.Contains(diff.String(), PersonTableName+": 1 creates, 1 updates, 1 deletes, 1 conflicts"))
+
+	// Within a transaction the diff rolls back to a savepoint.
+	assert.NilError(t, target.WithTx(context.Background(), func(tx *CRUD) error {
+		diff, err := tx.DiffJSONL(bytes.NewReader(stream.Bytes()))
+		assert.Check(t, is.DeepEqual(diff, expected))
+		return err
+	}))
+
+	_, err = target.Person.GetByID(dan.ID)
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+	parked, err := rt.ListUnknownRecords(target.Person.q)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(parked, 0))
+	preview, err = source.PreviewJSONL(testRemoteA)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(preview, 1))
+}
+
+func TestGeneratedJSONLQuarantine(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "quarantine.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{
+		Clock:  rt.ClockFunc(func() int64 { return 42 }),
+		Import: rt.ImportOptions{OnError: rt.ImportQuarantine},
+	})
+	assert.NilError(t, crud.Init())
+	personLine := func(id, name string) string {
+		return fmt.Sprintf("{\"id\":%q,\"atNs\":100,\"data\":{\"@type\":%q,\"name\":%s}}\n", id, typeURLPrefix+PersonTypeName, name)
+	}
+	importData := personLine("p1", `"Ada"`) + personLine("p2", "1") + personLine("p3", `""`) +
+		"{\"id\":\"p4\",\"atNs\":100,\"data\":{}}\n"
+	assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(importData)))
+	assert.NilError(t, crud.ReadJSONLBulk(testRemoteEmpty, strings.NewReader(personLine("p5", "true")), 0))
+
+	people, err := crud.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(people, 1))
+	rejected, err := crud.ListRejected()
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rejected, 4))
+	assert.Check(t, is.Equal(rejected[0].Remote, testRemoteA))
+	assert.Check(t, is.Equal(string(rejected[0].Line), strings.TrimSpace(personLine("p2", "1"))))
+	assert.Check(t, is.Contains(rejected[0].Error, "unmarshal jsonl data on line 2"))
+	assert.Check(t, is.Equal(rejected[0].RejectedAtNs, int64(42)))
+	assert.Check(t, is.Contains(rejected[1].Error, "name is required"))
+	assert.Check(t, is.Contains(rejected[2].Error, "read @type on line 4"))
+	assert.Check(t, is.Equal(rejected[3].Remote, testRemoteEmpty))
+
+	// A record rejected by an earlier, stricter version imports on retry.
+	_, err = db.Exec(`INSERT INTO _rejected (remote, line, error, rejected_at_ns) VALUES (?, ?, ?, ?)`,
+		testRemoteA, strings.TrimSpace(personLine("p6", `"Bob"`)), "too strict", 1)
+	assert.NilError(t, err)
+	retried, err := crud.RetryRejected()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(retried, 1))
+	bob, err := crud.Person.GetByID("p6")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(bob.Data.GetName(), "Bob"))
+	var syncCount int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM _sync WHERE object_id = ? AND remote = ?", "p6", testRemoteA).Scan(&syncCount))
+	assert.Check(t, is.Equal(syncCount, 1))
+
+	rejected, err = crud.ListRejected()
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rejected, 4))
+	assert.Check(t, is.Contains(rejected[0].Error, "unmarshal jsonl data on line 1"))
+	purged, err := crud.PurgeRejected(rejected[0].ID, rejected[1].ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(purged, int64(2)))
+	purged, err = crud.PurgeRejected()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(purged, int64(2)))
+	rejected, err = crud.ListRejected()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rejected, 0))
+}
+
+func TestGeneratedJSONLImportErrorPolicies(t *testing.T) {
+	importData := fmt.Sprintf("{\"id\":\"p1\",\"atNs\":100,\"data\":{\"@type\":%q,\"name\":\"Ada\"}}\n", typeURLPrefix+PersonTypeName) +
+		fmt.Sprintf("{\"id\":\"p2\",\"atNs\":100,\"data\":{\"@type\":%q,\"name\":1}}\n", typeURLPrefix+PersonTypeName) +
+		"{\"id\":5,\"atNs\":100,\"data\":{}}\n" +
+		fmt.Sprintf("{\"id\":\"\",\"atNs\":100,\"data\":{\"@type\":%q,\"name\":\"Eve\"}}\n", typeURLPrefix+PersonTypeName) +
+		fmt.Sprintf("{\"id\":\"p3\",\"atNs\":100,\"data\":{\"@type\":%q,\"name\":\"Bob\"}}\n", typeURLPrefix+PersonTypeName)
+	open := func(t *testing.T, opts rt.Options) *CRUD {
+		crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "policy.db")), opts)
+		assert.NilError(t, crud.Init())
+		return crud
+	}
+	count := func(t *testing.T, crud *CRUD) (int, int) {
+		people, err := crud.Person.Select("")
+		assert.NilError(t, err)
+		rejected, err := crud.ListRejected()
+		assert.NilError(t, err)
+		return len(people), len(rejected)
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		crud := open(t, rt.Options{})
+		report, err := crud.ReadJSONLWithOptions(testRemoteA, strings.NewReader(importData), rt.ImportOptions{OnError: rt.ImportSkip})
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(report.Errors, 3))
+		assert.Check(t, is.Equal(report.Errors[0].Line, 2))
+		assert.Check(t, is.Equal(report.Errors[0].ID, "p2"))
+		assert.Check(t, is.ErrorContains(report.Errors[1], "decode jsonl line 3"))
+		assert.Check(t, is.ErrorContains(report.Err(), "jsonl line 4 has empty id"))
+		people, rejected := count(t, crud)
+		assert.Check(t, is.Equal(people, 2))
+		assert.Check(t, is.Equal(rejected, 0))
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		crud := open(t, rt.Options{})
+		report, err := crud.ReadJSONLBulkWithOptions(testRemoteA, strings.NewReader(importData), 0, rt.ImportOptions{OnError: rt.ImportQuarantine})
+		assert.NilError(t, err)
+		assert.Check(t, is.Len(report.Errors, 3))
+		people, rejected := count(t, crud)
+		assert.Check(t, is.Equal(people, 2))
+		assert.Check(t, is.Equal(rejected, 3))
+		records, err := crud.ListRejected()
+		assert.NilError(t, err)
+		// Queued records are only decoded when their batch is written.
+		assert.Check(t, is.Equal(string(records[0].Line), "{\"id\":5,\"atNs\":100,\"data\":{}}"))
+		assert.Check(t, is.Contains(records[2].Error, "unmarshal jsonl data on line 2"))
+	})
+
+	t.Run("fail fast by default", func(t *testing.T) {
+		crud := open(t, rt.Options{})
+		assert.ErrorContains(t, crud.ReadJSONL(testRemoteA, strings.NewReader(importData)), "unmarshal jsonl data on line 2")
+		people, rejected := count(t, crud)
+		assert.Check(t, is.Equal(people, 1))
+		assert.Check(t, is.Equal(rejected, 0))
+	})
+
+	t.Run("max errors", func(t *testing.T) {
+		crud := open(t, rt.Options{Import: rt.ImportOptions{OnError: rt.ImportQuarantine, MaxErrors: 2}})
+		err := crud.ReadJSONLBulk(testRemoteA, strings.NewReader(importData), 0)
+		assert.Check(t, is.ErrorIs(err, rt.ErrTooManyImportErrors))
+		people, rejected := count(t, crud)
+		assert.Check(t, is.Equal(people, 0))
+		assert.Check(t, is.Equal(rejected, 0))
+	})
+}
+
+func TestGeneratedJSONLRecordVersion(t *testing.T) {
+	personLine := func(id, version string) string {
+		return fmt.Sprintf("{%s\"id\":%q,\"atNs\":100,\"data\":{\"@type\":%q,\"name\":\"Ada\"}}\n", version, id, typeURLPrefix+PersonTypeName)
+	}
+	importData := personLine("p1", "") + personLine("p2", `"v":1,`) + personLine("p3", `"v":2,`)
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "version.db"))
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	report, err := crud.ReadJSONLWithOptions(testRemoteA, strings.NewReader(importData), rt.ImportOptions{OnError: rt.ImportQuarantine})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(report.Errors, 1))
+	assert.Check(t, is.Equal(report.Errors[0].ID, "p3"))
+	assert.Check(t, is.ErrorIs(report.Errors[0].Err, rt.ErrJSONLRecordVersion))
+	people, err := crud.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(people, 2))
+	rejected, err := crud.ListRejected()
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rejected, 1), "newer records wait in _rejected for an upgrade")
+	assert.Check(t, is.Equal(string(rejected[0].Line), strings.TrimSpace(personLine("p3", `"v":2,`))))
+
+	var current bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL(testRemoteEmpty, &current))
+	assert.Check(t, !strings.Contains(current.String(), `"v":`), "version 1 records are written as before versioning")
+	pinned := NewCRUDWithOptions(db, rt.Options{JSONL: rt.JSONLOptions{RecordVersion: rt.JSONLRecordVersion + 1}})
+	assert.Check(t, is.ErrorIs(pinned.WriteJSONL(testRemoteA, io.Discard), rt.ErrJSONLRecordVersion))
+}
+
+func TestGeneratedJSONLOrder(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "order.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{Clock: &rt.StepClock{Start: 1000, Step: 1}, ChangeFeed: true})
+	assert.NilError(t, crud.Init())
+	// Interleave the tables and write out of id order.
+	for index := range 3 {
+		task, err := crud.Task.Insert(&Task{Title: "T" + strconv.Itoa(index)})
+		assert.NilError(t, err)
+		_, err = crud.Person.InsertWithID(fmt.Sprintf("018f4f3f-6f9f-7a1b-8f55-00000000000%d", 3-index), &Person{Name: "P" + strconv.Itoa(index)})
+		assert.NilError(t, err)
+		if index == 0 {
+			assert.NilError(t, crud.Task.DeleteByID(task.ID))
+		}
+	}
+	var first, second bytes.Buffer
+	assert.NilError(t, crud.WriteJSONLSince(-1, &first))
+	assert.NilError(t, crud.WriteJSONLSince(-1, &second))
+	assert.Check(t, is.Equal(first.String(), second.String()), "exports of the same data are identical")
+
+	type position struct {
+		typeName string
+		atNs     int64
+	}
+	var read []position
+	assert.NilError(t, rt.ReadJSONL(&first, func(record rt.JSONLRecord, _ int) error {
+		typeName, err := rt.TypeNameFromAnyJSON(record.Data)
+		read = append(read, position{typeName, record.AtNs})
+		return err
+	}))
+	assert.Assert(t, is.Len(read, 6))
+	assert.Check(t, slices.IsSortedFunc(read, func(a, b position) int {
+		if a.typeName != b.typeName {
+			// generatedtest_example_person sorts before ..._task.
+			return strings.Compare(a.typeName, b.typeName)
+		}
+		return cmp.Compare(a.atNs, b.atNs)
+	}), "%v", read)
+
+	sectioned := NewCRUDWithOptions(db, rt.Options{JSONL: rt.JSONLOptions{SectionHeaders: true, Manifest: true}})
+	var output bytes.Buffer
+	assert.NilError(t, sectioned.WriteJSONLChunks(testRemoteA, &output, 2, nil))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Assert(t, is.Len(lines, 9))
+	assert.Check(t, is.Equal(lines[0], `{"section":{"table":"`+PersonTableName+`","records":3}}`))
+	assert.Check(t, is.Equal(lines[4], `{"section":{"table":"`+TaskTableName+`","records":3}}`))
+	assert.Check(t, strings.HasPrefix(lines[8], `{"manifest":`))
+
+	imported := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "imported.db")))
+	assert.NilError(t, imported.Init())
+	assert.NilError(t, imported.ReadJSONL(testRemoteA, &output), "readers skip sections")
+	tasks, err := imported.Task.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(tasks, 2))
+}
+
+func TestGeneratedJSONLProgress(t *testing.T) {
+	crud := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "progress.db")))
+	assert.NilError(t, crud.Init())
+	for index := range 3 {
+		_, err := crud.Person.Insert(&Person{Name: "P" + strconv.Itoa(index)})
+		assert.NilError(t, err)
+		_, err = crud.Task.Insert(&Task{Title: "T" + strconv.Itoa(index)})
+		assert.NilError(t, err)
+	}
+
+	var written []rt.JSONLProgress
+	var output bytes.Buffer
+	assert.NilError(t, crud.WriteJSONLWithOptions(testRemoteA, &output, rt.ExportOptions{
+		ChunkSize: 4,
+		Progress:  func(progress rt.JSONLProgress) { written = append(written, progress) },
+	}))
+	assert.Assert(t, is.Len(written, 2))
+	assert.Check(t, is.DeepEqual(written[0], rt.JSONLProgress{Records: 4, Total: 6, Bytes: written[0].Bytes, Type: TaskTypeName}))
+	assert.Check(t, is.DeepEqual(written[1], rt.JSONLProgress{Records: 6, Total: 6, Bytes: int64(output.Len()), Type: TaskTypeName}))
+	assert.Check(t, written[0].Bytes > 0 && written[0].Bytes < written[1].Bytes)
+	assert.Check(t, is.ErrorContains(crud.WriteJSONLWithOptions("remote-b", &output, rt.ExportOptions{ChunkSize: -1}), "chunk size"))
+
+	var read []rt.JSONLProgress
+	imported := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "imported.db")))
+	assert.NilError(t, imported.Init())
+	_, err := imported.ReadJSONLWithOptions(testRemoteA, bytes.NewReader(output.Bytes()), rt.ImportOptions{
+		Progress: func(progress rt.JSONLProgress) { read = append(read, progress) },
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(read, 6))
+	assert.Check(t, is.DeepEqual(read[0], rt.JSONLProgress{Records: 1, Bytes: read[0].Bytes, Type: PersonTypeName}))
+	assert.Check(t, is.DeepEqual(read[5], rt.JSONLProgress{Records: 6, Bytes: int64(output.Len()), Type: TaskTypeName}))
+
+	// Cancelling from the callback stops before the next chunk or record.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	written = nil
+	var cancelled bytes.Buffer
+	err = crud.WriteJSONLWithOptionsContext(ctx, "remote-b", &cancelled, rt.ExportOptions{
+		ChunkSize: 2,
+		Progress: func(progress rt.JSONLProgress) {
+			written = append(written, progress)
+			cancel()
+		},
+	})
+	assert.Check(t, is.ErrorIs(err, context.Canceled))
+	assert.Check(t, is.Len(written, 1))
+	assert.Check(t, is.Equal(strings.Count(cancelled.String(), "\n"), 2))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	read = nil
+	partial := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "partial.db")))
+	assert.NilError(t, partial.Init())
+	_, err = partial.ReadJSONLWithOptionsContext(ctx, testRemoteA, bytes.NewReader(output.Bytes()), rt.ImportOptions{
+		Progress: func(progress rt.JSONLProgress) {
+			read = append(read, progress)
+			if progress.Records == 3 {
+				cancel()
+			}
+		},
+	})
+	assert.Check(t, is.ErrorIs(err, context.Canceled))
+	assert.Check(t, is.Len(read, 3))
+}
+
+func TestGeneratedJSONLTables(t *testing.T) {
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "tables.db")), rt.Options{ChangeFeed: true})
+	assert.NilError(t, crud.Init())
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	task, err := crud.Task.Insert(&Task{Title: "T"})
+	assert.NilError(t, err)
+
+	assert.Check(t, is.ErrorContains(crud.WriteJSONLTables(testRemoteA, io.Discard, []string{"missing"}), `unknown jsonl table "missing"`))
+	assert.Check(t, is.ErrorContains(crud.WriteJSONLTables(testRemoteA, io.Discard, nil), "no tables"))
+	var people bytes.Buffer
+	assert.NilError(t, crud.WriteJSONLTables(testRemoteA, &people, []string{PersonTableName}))
+	assert.Check(t, is.Equal(strings.Count(people.String(), "\n"), 1))
+	assert.Check(t, strings.Contains(people.String(), ada.ID))
+	var rest bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL(testRemoteA, &rest))
+	assert.Check(t, is.Equal(strings.Count(rest.String(), "\n"), 1), "other tables stay pending")
+	assert.Check(t, strings.Contains(rest.String(), task.ID))
+
+	var all bytes.Buffer
+	assert.NilError(t, crud.WriteJSONLSince(-1, &all))
+	importedDB := openCLITestDB(t, filepath.Join(t.TempDir(), "imported.db"))
+	imported := NewCRUD(importedDB)
+	assert.NilError(t, imported.Init())
+	assert.Check(t, is.ErrorContains(imported.ReadJSONLTables(testRemoteA, bytes.NewReader(all.Bytes()), []string{"missing"}), "unknown jsonl table"))
+	assert.NilError(t, imported.ReadJSONLTables(testRemoteA, bytes.NewReader(all.Bytes()), []string{TaskTableName}))
+	importedPeople, err := imported.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(importedPeople, 0))
+	importedTasks, err := imported.Task.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(importedTasks, 1))
+	var unknown int
+	assert.NilError(t, importedDB.QueryRow("SELECT COUNT(*) FROM _unknown_types").Scan(&unknown))
+	assert.Check(t, is.Equal(unknown, 0), "skipped records are not parked")
+
+	_, err = imported.ReadJSONLBulkWithOptions(testRemoteA, bytes.NewReader(all.Bytes()), 10, rt.ImportOptions{Tables: []string{PersonTableName}})
+	assert.NilError(t, err)
+	importedPeople, err = imported.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(importedPeople, 1))
+}
+
+func TestGeneratedTypeURLPrefix(t *testing.T) {
+	const customPrefix = "types.example.com/"
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "prefix.db")), rt.Options{TypeURLPrefix: customPrefix})
+	assert.NilError(t, crud.Init())
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	gone, err := crud.Person.Insert(&Person{Name: "Gone"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Person.DeleteByID(gone.ID))
+
+	var exported bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL(testRemoteA, &exported))
+	personURL := `"@type":"` + customPrefix + PersonTypeName + `"`
+	assert.Check(t, is.Equal(strings.Count(exported.String(), personURL), 2), exported.String())
+	assert.Check(t, !strings.Contains(exported.String(), typeURLPrefix))
+	var snapshot bytes.Buffer
+	assert.NilError(t, crud.WriteSnapshot(&snapshot))
+	assert.Check(t, strings.Contains(snapshot.String(), `"typeUrlPrefix":"`+customPrefix+`"`))
+	assert.Check(t, is.Equal(strings.Count(snapshot.String(), personURL), 2), snapshot.String())
+
+	imported := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "imported.db")))
+	assert.NilError(t, imported.Init())
+	other := `{"id":"018f4f3f-6f9f-7a1b-8f55-1234567890ad","atNs":1,"data":{"@type":"registry.example.org/types/` + PersonTypeName + `","name":"Other"}}` + "\n"
+	assert.NilError(t, imported.ReadJSONL(testRemoteA, strings.NewReader(exported.String()+other)))
+	people, err := imported.Person.Select("")
+	assert.NilError(t, err)
+	names := make([]string, 0, len(people))
+	for _, person := range people {
+		names = append(names, person.Data.GetName())
+	}
+	slices.Sort(names)
+	assert.Check(t, is.DeepEqual(names, []string{"Ada", "Other"}), "any prefix is accepted")
+	assert.Check(t, slices.ContainsFunc(people, func(person PersonRow) bool { return person.ID == ada.ID }))
+
+	restored := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "restored.db")))
+	assert.NilError(t, restored.Init())
+	assert.NilError(t, restored.ReadSnapshot(bytes.NewReader(snapshot.Bytes())))
+	restoredAda, err := restored.Person.GetByID(ada.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(restoredAda.Data.GetName(), "Ada"))
+
+	assert.Check(t, is.Equal(rt.TypeURLWithPrefix("types.example.com", PersonTypeName), customPrefix+PersonTypeName))
+	assert.Check(t, is.Equal(rt.TypeURL(PersonTypeName), typeURLPrefix+PersonTypeName))
+}
+
+func TestGeneratedJSONLLimits(t *testing.T) {
+	personLine := func(id, name string) string {
+		return fmt.Sprintf("{\"id\":%q,\"atNs\":100,\"data\":{\"@type\":%q,\"name\":%q}}\n", id, typeURLPrefix+PersonTypeName, name)
+	}
+	first := personLine("p1", "Ada")
+	open := func(t *testing.T, jsonl rt.JSONLOptions) *CRUD {
+		crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "limits.db")), rt.Options{JSONL: jsonl})
+		assert.NilError(t, crud.Init())
+		return crud
+	}
+	huge := first + personLine("p2", strings.Repeat("x", 1000)) + personLine("p3", "Bob")
+
+	for _, scanLines := range []bool{false, true} {
+		crud := open(t, rt.JSONLOptions{MaxRecordSize: 200, ScanLines: scanLines})
+		err := crud.ReadJSONL(testRemoteA, strings.NewReader(huge))
+		assert.Check(t, is.ErrorIs(err, rt.ErrJSONLLimit))
+		assert.Check(t, is.ErrorContains(err, fmt.Sprintf("jsonl line 2 at byte %d is larger than 200 bytes", len(first))), "scan lines %v", scanLines)
+	}
+
+	var framed bytes.Buffer
+	source := open(t, rt.JSONLOptions{Framing: rt.JSONLFramingLengthPrefixed})
+	_, err := source.Person.Insert(&Person{Name: strings.Repeat("x", 1000)})
+	assert.NilError(t, err)
+	assert.NilError(t, source.WriteJSONL(testRemoteA, &framed))
+	err = open(t, rt.JSONLOptions{MaxRecordSize: 200}).ReadJSONL(testRemoteA, bytes.NewReader(framed.Bytes()))
+	assert.Check(t, is.ErrorIs(err, rt.ErrJSONLLimit))
+	assert.Check(t, is.ErrorContains(err, "jsonl frame 1 at byte 0"))
+
+	err = open(t, rt.JSONLOptions{MaxRecords: 2}).ReadJSONL(testRemoteA, strings.NewReader(first+personLine("p2", "Grace")+personLine("p3", "Bob")))
+	assert.Check(t, is.ErrorIs(err, rt.ErrJSONLLimit))
+	assert.Check(t, is.ErrorContains(err, "jsonl line 3 at byte"))
+
+	// Scanning lines rejects a malformed line and reads on; decoding JSON
+	// values cannot resynchronize after one.
+	garbled := first + "\n{\"id\":\"p2\",\"atNs\n" + personLine("p3", "Bob")
+	assert.Check(t, is.ErrorContains(open(t, rt.JSONLOptions{}).ReadJSONL(testRemoteA, strings.NewReader(garbled)), "decode jsonl line 2"))
+	crud := open(t, rt.JSONLOptions{ScanLines: true})
+	_, err = crud.ReadJSONLWithOptions(testRemoteA, strings.NewReader(garbled), rt.ImportOptions{OnError: rt.ImportQuarantine})
+	assert.NilError(t, err)
+	people, err := crud.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(people, 2))
+	rejected, err := crud.ListRejected()
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rejected, 1))
+	assert.Check(t, is.Contains(rejected[0].Error, fmt.Sprintf("decode jsonl line 3 at byte %d", len(first)+1)))
+}
+
+type closingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closingBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestGeneratedWriteJSONLMulti(t *testing.T) {
+	const remoteB, remoteC = "remote-b", "remote-c"
+	var personScans int
+	observer := rt.QueryObserverFunc(func(query string, _ []any, _ time.Duration, _ error) {
+		if strings.HasPrefix(query, "SELECT") && strings.Contains(query, `FROM "`+PersonTableName+`"`) {
+			personScans++
+		}
+	})
+	crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "multi.db")), rt.Options{
+		QueryObserver: observer,
+		SyncPolicy: rt.SyncPolicy{Remotes: map[string]rt.RemoteSyncFilter{
+			remoteB: {Types: []string{PersonTypeName}},
+			remoteC: {Where: map[string]string{PersonTypeName: "age >= 30"}},
+		}},
+	})
+	assert.NilError(t, crud.Init())
+	ada, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	bob, err := crud.Person.Insert(&Person{Name: "Bob", Age: 20})
+	assert.NilError(t, err)
+	carl, err := crud.Person.Insert(&Person{Name: "Carl", Age: 40})
+	assert.NilError(t, err)
+	task, err := crud.Task.Insert(&Task{Title: "write"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.WriteJSONL(testRemoteA, io.Discard))
+	_, err = crud.Person.UpdateByID(bob.ID, &Person{Name: "Bob", Age: 21})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Person.DeleteByID(carl.ID))
+
+	remotes := []string{testRemoteA, remoteB, remoteC}
+	outputs := make(map[string]*closingBuffer)
+	open := func(remote string) (io.WriteCloser, error) {
+		outputs[remote] = &closingBuffer{}
+		return outputs[remote], nil
+	}
+	personScans = 0
+	assert.NilError(t, crud.WriteJSONLMulti(remotes, open))
+	// remote-a and remote-b share a scan; remote-c filters Person rows.
+	assert.Check(t, is.Equal(personScans, 2))
+
+	ids := func(remote string) []string {
+		assert.Check(t, outputs[remote].closed)
+		var ids []string
+		assert.NilError(t, rt.ReadJSONL(&outputs[remote].Buffer, func(record rt.JSONLRecord, _ int) error {
+			if record.Deleted {
+				ids = append(ids, "-"+record.ID)
+			} else {
+				ids = append(ids, record.ID)
+			}
+			return nil
+		}))
+		return ids
+	}
+	assert.Check(t, is.DeepEqual(ids(testRemoteA), []string{bob.ID, "-" + carl.ID}))
+	assert.Check(t, is.DeepEqual(ids(remoteB), []string{ada.ID, bob.ID, "-" + carl.ID}))
+	assert.Check(t, is.DeepEqual(ids(remoteC), []string{ada.ID, "-" + carl.ID, task.ID}), "records are ordered by table, at_ns and id")
+
+	// Everything has been acknowledged per remote.
+	assert.NilError(t, crud.WriteJSONLMulti(remotes, open))
+	for _, remote := range remotes {
+		assert.Check(t, is.Len(ids(remote), 0), remote)
+	}
+
+	assert.ErrorContains(t, crud.WriteJSONLMulti([]string{remoteB, remoteB}, open), "duplicate remote")
+	openErr := errors.New("peer offline")
+	err = crud.WriteJSONLMulti(remotes, func(string) (io.WriteCloser, error) { return nil, openErr })
+	assert.Check(t, is.ErrorIs(err, openErr))
+}
+
+func TestGeneratedWriteJSONLSince(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "since.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{Clock: &rt.StepClock{Start: 1000, Step: 10}, ChangeFeed: true})
+	assert.NilError(t, crud.Init())
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	bob, err := crud.Person.Insert(&Person{Name: "Bob"})
+	assert.NilError(t, err)
+	export := func(cursor int64) ([]string, rt.ImportReport) {
+		t.Helper()
+		var out bytes.Buffer
+		assert.NilError(t, crud.WriteJSONLSince(cursor, &out))
+		var ids []string
+		receiver := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "receiver.db")))
+		assert.NilError(t, receiver.Init())
+		raw := out.Bytes()
+		assert.NilError(t, rt.ReadJSONL(bytes.NewReader(raw), func(record rt.JSONLRecord, _ int) error {
+			if record.Deleted {
+				ids = append(ids, "-"+record.ID)
+			} else {
+				ids = append(ids, record.ID)
+			}
+			return nil
+		}))
+		report, err := receiver.ReadJSONLWithOptions(testRemoteA, bytes.NewReader(raw), rt.ImportOptions{})
+		assert.NilError(t, err)
+		assert.Check(t, report.HasCursor)
+		return ids, report
+	}
+
+	ids, report := export(-1)
+	assert.Check(t, is.DeepEqual(ids, []string{ada.ID, bob.ID}))
+	cursor := report.Cursor
+	assert.Check(t, is.Equal(cursor, int64(2)))
+
+	_, err = crud.Person.UpdateByID(bob.ID, &Person{Name: "Bob Updated"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Person.DeleteByID(ada.ID))
+	ids, report = export(cursor)
+	assert.Check(t, is.DeepEqual(ids, []string{bob.ID, "-" + ada.ID}))
+	assert.Check(t, report.Cursor > cursor)
+
+	// Nothing changed after the new cursor, which carries over.
+	ids, next := export(report.Cursor)
+	assert.Check(t, is.Len(ids, 0))
+	assert.Check(t, is.Equal(next.Cursor, report.Cursor))
+
+	// Records synced from elsewhere keep their older at_ns but are still
+	// changes after the cursor.
+	cursor = report.Cursor
+	remote := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), "remote.db")), rt.Options{Clock: &rt.StepClock{Start: 1, Step: 1}})
+	assert.NilError(t, remote.Init())
+	old, err := remote.Person.Insert(&Person{Name: "Old"})
+	assert.NilError(t, err)
+	var synced bytes.Buffer
+	assert.NilError(t, remote.WriteJSONL(testRemoteA, &synced))
+	assert.NilError(t, crud.ReadJSONL(testRemoteA, &synced))
+	ids, report = export(cursor)
+	assert.Check(t, is.DeepEqual(ids, []string{old.ID}))
+
+	// A cursor whose changes were trimmed starts over.
+	_, err = rt.TrimChanges(db, report.Cursor)
+	assert.NilError(t, err)
+	ids, _ = export(cursor)
+	assert.Check(t, is.DeepEqual(ids, []string{old.ID, bob.ID, "-" + ada.ID}))
+
+	// Since exports keep no _sync state; the remote is the one imported from.
+	remotes, err := rt.ListRemotes(db)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(remotes, []string{testRemoteA}))
+
+	plain := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "plain.db")))
+	assert.NilError(t, plain.Init())
+	assert.Check(t, is.ErrorContains(plain.WriteJSONLSince(-1, io.Discard), "Options.ChangeFeed"))
+}
+
+func TestGeneratedJSONLSyncLimits(t *testing.T) {
+	const remoteB = "remote-b"
+	newCRUD := func(name string, jsonl rt.JSONLOptions) *CRUD {
+		t.Helper()
+		crud := NewCRUDWithOptions(openCLITestDB(t, filepath.Join(t.TempDir(), name)), rt.Options{JSONL: jsonl})
+		assert.NilError(t, crud.Init())
+		return crud
+	}
+	source := newCRUD("source.db", rt.JSONLOptions{})
+	for _, name := range []string{"Ada", "Bob", "Carl"} {
+		_, err := source.Person.Insert(&Person{Name: name})
+		assert.NilError(t, err)
+	}
+	var export bytes.Buffer
+	assert.NilError(t, source.WriteJSONL(testRemoteA, &export))
+
+	flushes := func(jsonl rt.JSONLOptions) int {
+		t.Helper()
+		crud := newCRUD("flush.db", jsonl)
+		_, err := crud.ReadJSONLWithOptions(testRemoteA, bytes.NewReader(export.Bytes()), rt.ImportOptions{})
+		assert.NilError(t, err)
+		var calls int
+		assert.NilError(t, crud.WriteJSONLChunks(remoteB, io.Discard, 100, func(int64, int64) { calls++ }))
+		return calls
+	}
+	assert.Check(t, is.Equal(flushes(rt.JSONLOptions{}), 1))
+	// Every record exceeds one byte and is flushed alone.
+	assert.Check(t, is.Equal(flushes(rt.JSONLOptions{MaxBytesPerFlush: 1}), 3))
+
+	paced := newCRUD("paced.db", rt.JSONLOptions{MaxRecordsPerSecond: 50})
+	start := time.Now()
+	assert.NilError(t, paced.ReadJSONL(testRemoteA, bytes.NewReader(export.Bytes())))
+	assert.NilError(t, paced.WriteJSONL(remoteB, io.Discard))
+	// Three records each way start 20ms apart.
+	assert.Check(t, time.Since(start) >= 80*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := source.WriteJSONLContext(ctx, remoteB, io.Discard)
+	assert.Check(t, is.ErrorIs(err, context.Canceled))
+	pending, err := source.PreviewJSONL(remoteB)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(pending[PersonTableName], int64(3)))
+
+	receiver := newCRUD("receiver.db", rt.JSONLOptions{})
+	err = receiver.ReadJSONLContext(ctx, testRemoteA, bytes.NewReader(export.Bytes()))
+	assert.Check(t, is.ErrorIs(err, context.Canceled))
+	err = receiver.ReadJSONLBulkContext(ctx, testRemoteA, bytes.NewReader(export.Bytes()), 10)
+	assert.Check(t, is.ErrorIs(err, context.Canceled))
+	rows, err := receiver.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 0))
+}
+
+func TestGeneratedUnknownRetention(t *testing.T) {
+	var unknown bytes.Buffer
+	for i := 1; i <= 4; i++ {
+		fmt.Fprintf(&unknown, `{"id":"u%d","atNs":%d,"data":{"@type":"type.googleapis.com/other.Thing"}}`+"\n", i, i*100)
+	}
+	newCRUD := func(retention rt.UnknownRetention, instrumentation rt.Instrumentation) (*CRUD, *sql.DB) {
+		t.Helper()
+		db := openCLITestDB(t, filepath.Join(t.TempDir(), "unknown.db"))
+		crud := NewCRUDWithOptions(db, rt.Options{
+			Clock:            &rt.StepClock{Start: 1000, Step: 1},
+			UnknownRetention: retention,
+			Instrumentation:  instrumentation,
+		})
+		assert.NilError(t, crud.Init())
+		assert.NilError(t, crud.ReadJSONL(testRemoteA, bytes.NewReader(unknown.Bytes())))
+		return crud, db
+	}
+	ids := func(r io.Reader) []string {
+		t.Helper()
+		var ids []string
+		assert.NilError(t, rt.ReadJSONL(r, func(record rt.JSONLRecord, _ int) error {
+			ids = append(ids, record.ID)
+			return nil
+		}))
+		return ids
+	}
+	remaining := func(db *sql.DB) []string {
+		t.Helper()
+		records, err := rt.ListUnknownRecords(db)
+		assert.NilError(t, err)
+		ids := make([]string, len(records))
+		for i, record := range records {
+			ids[i] = record.ID
+		}
+		return ids
+	}
+
+	instrumentation := &recordingInstrumentation{}
+	crud, db := newCRUD(rt.UnknownRetention{MaxRows: 2}, instrumentation)
+	var exported bytes.Buffer
+	assert.NilError(t, crud.WriteUnknownJSONL(&exported))
+	assert.Check(t, is.DeepEqual(ids(&exported), []string{"u1", "u2", "u3", "u4"}))
+	var archive bytes.Buffer
+	eviction, err := crud.EvictUnknown(&archive)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(eviction.Rows, int64(2)))
+	assert.Check(t, is.DeepEqual(eviction.Types, map[string]int64{"other.Thing": 2}))
+	assert.Check(t, is.DeepEqual(ids(&archive), []string{"u1", "u2"}))
+	assert.Check(t, is.DeepEqual(remaining(db), []string{"u3", "u4"}))
+	assert.Check(t, is.Equal(instrumentation.counters[rt.MetricUnknownEvicted], int64(2)))
+	assert.Check(t, is.Equal(instrumentation.counters[rt.MetricUnknownEvictedBytes], eviction.Bytes))
+	// Within retention, nothing more goes.
+	eviction, err = crud.EvictUnknown(nil)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(eviction.Rows, int64(0)))
+
+	// The clock reads about 1000, so rows before 350 are too old.
+	crud, db = newCRUD(rt.UnknownRetention{MaxAge: 650}, nil)
+	_, err = crud.EvictUnknown(nil)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(remaining(db), []string{"u4"}))
+
+	size := int64(len(`{"@type":"type.googleapis.com/other.Thing"}`))
+	crud, db = newCRUD(rt.UnknownRetention{MaxBytes: 3*size + 1}, nil)
+	eviction, err = crud.EvictUnknown(nil)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(eviction.Bytes, size))
+	assert.Check(t, is.DeepEqual(remaining(db), []string{"u2", "u3", "u4"}))
+
+	// A failed archive write evicts nothing.
+	crud, db = newCRUD(rt.UnknownRetention{MaxRows: 1}, nil)
+	_, err = crud.EvictUnknown(&failingWriter{})
+	assert.ErrorContains(t, err, "connection lost")
+	assert.Check(t, is.Len(remaining(db), 4))
+}
+
+func TestGeneratedEmbeddedDescriptors(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "descriptors.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{JSONL: rt.JSONLOptions{EmbedDescriptors: true, Manifest: true}})
+	assert.NilError(t, crud.Init())
+	_, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	var out bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL(testRemoteA, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Assert(t, is.Len(lines, 3))
+	assert.Check(t, strings.HasPrefix(lines[0], `{"descriptors":`))
+	assert.Check(t, is.Contains(lines[2], `"descriptorsSha256":`))
+
+	receiverDB := openCLITestDB(t, filepath.Join(t.TempDir(), "receiver.db"))
+	receiver := NewCRUD(receiverDB)
+	assert.NilError(t, receiver.Init())
+	report, err := receiver.ReadJSONLWithOptions(testRemoteA, bytes.NewReader(out.Bytes()), rt.ImportOptions{})
+	assert.NilError(t, err)
+	assert.Assert(t, report.Descriptors != nil)
+	assert.Check(t, report.Descriptors.Knows(string((&Person{}).ProtoReflect().Descriptor().FullName())))
+	stored, err := rt.StoredDescriptors(receiverDB)
+	assert.NilError(t, err)
+	storedFiles := make([]string, 0, len(stored.GetFile()))
+	for _, file := range stored.GetFile() {
+		storedFiles = append(storedFiles, file.GetName())
+	}
+	assert.Check(t, is.Contains(storedFiles, (&Person{}).ProtoReflect().Descriptor().ParentFile().Path()))
+	people, err := receiver.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(people, 1))
+
+	// The manifest covers the descriptors record.
+	stripped := strings.Join(lines[1:], "\n") + "\n"
+	_, err = NewCRUD(receiverDB).ReadJSONLWithOptions(testRemoteA, strings.NewReader(stripped), rt.ImportOptions{})
+	assert.Check(t, is.ErrorIs(err, rt.ErrJSONLManifest))
+	// Descriptors after a record are rejected.
+	misplaced := lines[1] + "\n" + lines[0] + "\n"
+	_, err = NewCRUD(receiverDB).ReadJSONLWithOptions(testRemoteA, strings.NewReader(misplaced), rt.ImportOptions{})
+	assert.Check(t, is.ErrorContains(err, "do not start the stream"))
+
+	// Unknown types arriving with descriptors decode from the stored files.
+	thing := rt.JSONLRecord{ID: "t1", AtNs: 5, Data: []byte(`{"@type":"type.googleapis.com/other.Thing","label":"first"}`)}
+	var unknownOut bytes.Buffer
+	assert.NilError(t, rt.WriteJSONLChunksWithOptions(nil, "", &unknownOut, []rt.PendingJSONLRecord{{Record: thing}}, 1, rt.JSONLOptions{EmbedDescriptors: true, Descriptors: otherThingDescriptorSet()}, nil))
+	_, err = receiver.ReadJSONLWithOptions(testRemoteA, bytes.NewReader(unknownOut.Bytes()), rt.ImportOptions{})
+	assert.NilError(t, err)
+	types, err := rt.StoredDynamicTypes(receiverDB)
+	assert.NilError(t, err)
+	decoded, err := types.DecodeUnknown(receiverDB, "other.Thing")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(decoded, 1))
+	assert.NilError(t, decoded[0].Err)
+	assert.Check(t, is.Equal(decoded[0].Message.ProtoReflect().Get(decoded[0].Message.ProtoReflect().Descriptor().Fields().ByName("label")).String(), "first"))
+
+	// Descriptors that change the type of a known field fail the read.
+	changed := protodesc.ToFileDescriptorProto((&Person{}).ProtoReflect().Descriptor().ParentFile())
+	for _, message := range changed.GetMessageType() {
+		if message.GetName() != "Person" {
+			continue
+		}
+		for _, field := range message.GetField() {
+			if field.GetName() == "name" {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+			}
+		}
+	}
+	set := rt.DescriptorSetOf(&Person{})
+	for i, file := range set.GetFile() {
+		if file.GetName() == changed.GetName() {
+			set.File[i] = changed
+		}
+	}
+	var mismatched bytes.Buffer
+	bob := rt.JSONLRecord{ID: "p-bob", AtNs: 7, Data: []byte(`{"@type":"type.googleapis.com/` + string((&Person{}).ProtoReflect().Descriptor().FullName()) + `","name":"Bob"}`)}
+	assert.NilError(t, rt.WriteJSONLChunksWithOptions(nil, "", &mismatched, []rt.PendingJSONLRecord{{Record: bob}}, 1, rt.JSONLOptions{EmbedDescriptors: true, Descriptors: set}, nil))
+	_, err = receiver.ReadJSONLWithOptions(testRemoteA, bytes.NewReader(mismatched.Bytes()), rt.ImportOptions{})
+	assert.Check(t, is.ErrorIs(err, rt.ErrSchemaMismatch))
+	assert.Check(t, is.ErrorContains(err, ".name is string, remote has int64"))
+	_, err = receiver.Person.GetByID("p-bob")
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+}
+
+func TestGeneratedRemoteBookkeeping(t *testing.T) {
+	const remoteB, remoteC = "remote-b", "remote-c"
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "remotes.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{Clock: &rt.StepClock{Start: 1000, Step: 10}})
+	assert.NilError(t, crud.Init())
+	ada, err := crud.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	_, err = crud.Task.Insert(&Task{Title: "write"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.WriteJSONL(testRemoteA, io.Discard))
+	assert.NilError(t, crud.WriteJSONL(remoteB, io.Discard))
+	_, err = crud.Person.UpdateByID(ada.ID, &Person{Name: "Ada Lovelace"})
+	assert.NilError(t, err)
+
+	remotes, err := rt.ListRemotes(db)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(remotes, []string{testRemoteA, remoteB}))
+
+	lags, err := rt.SyncLag(db, testRemoteA, []string{PersonTableName, TaskTableName})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(lags, []rt.TableLag{
+		{TableName: PersonTableName, LocalMaxAtNs: 1020, SyncedMaxAtNs: 1000, LagNs: 20},
+		{TableName: TaskTableName, LocalMaxAtNs: 1010, SyncedMaxAtNs: 1010},
+	}))
+	lags, err = rt.SyncLag(db, testRemoteA, nil)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(lags, len(crud.TableDescriptors())-6))
+
+	assert.NilError(t, rt.RenameRemote(db, testRemoteA, remoteC))
+	assert.Check(t, is.ErrorIs(rt.RenameRemote(db, remoteB, remoteC), rt.ErrRemoteExists))
+	remotes, err = rt.ListRemotes(db)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(remotes, []string{remoteB, remoteC}))
+
+	forgotten, err := rt.ForgetRemote(db, remoteC)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(forgotten, int64(2)))
+	var export bytes.Buffer
+	assert.NilError(t, crud.WriteJSONL(remoteC, &export))
+	assert.Check(t, is.Equal(strings.Count(export.String(), "\n"), 2))
+}
+
+func TestGeneratedJSONLBulkMatchesReadJSONL(t *testing.T) {
+	ctx := context.Background()
+	const (
+		localID   = "018f4f3f-6f9f-7a1b-8f55-1234567890b1"
+		updatedID = "018f4f3f-6f9f-7a1b-8f55-1234567890b2"
+		deletedID = "018f4f3f-6f9f-7a1b-8f55-1234567890b3"
+	)
+	personLine := func(id string, atNs int64, name string) string {
+		return fmt.Sprintf("{\"id\":%q,\"atNs\":%d,\"data\":{\"@type\":%q,\"name\":%q}}\n", id, atNs, typeURLPrefix+PersonTypeName, name)
+	}
+	deleteLine := func(id, typeName string, atNs int64) string {
+		return fmt.Sprintf("{\"id\":%q,\"deleted\":true,\"atNs\":%d,\"data\":{\"@type\":%q}}\n", id, atNs, typeURLPrefix+typeName)
+	}
+	importData := personLine(updatedID, 100, "first") +
+		personLine(updatedID, 90, "stale") +
+		personLine(deletedID, 100, "deleted later") +
+		personLine(localID, 50, "older than local") +
+		deleteLine(deletedID, PersonTypeName, 110) +
+		fmt.Sprintf("{\"id\":\"event-1\",\"atNs\":100,\"data\":{\"@type\":%q,\"kind\":\"deploy\",\"labels\":{\"app\":\"web\"}}}\n", typeURLPrefix+EventTypeName) +
+		fmt.Sprintf("{\"id\":\"session-1\",\"atNs\":100,\"data\":{\"@type\":%q,\"user\":\"ada\"}}\n", typeURLPrefix+SessionTypeName) +
+		fmt.Sprintf("{\"id\":%q,\"atNs\":100,\"data\":{\"@type\":%q}}\n", unknownID, typeURLPrefix+unknownTypeName) +
+		personLine(updatedID, 120, "second") +
+		deleteLine("never-existed", PersonTypeName, 10)
+
+	dump := func(db *sql.DB) []string {
+		t.Helper()
+		lines := make([]string, 0)
+		for _, query := range []string{
+			// Rows written locally have wall-clock timestamps.
+			`SELECT id || ':' || CASE WHEN at_ns < 1000 THEN at_ns ELSE 'now' END || ':' || name FROM ` + PersonTableName + ` ORDER BY id`,
+			`SELECT table_name || ':' || id || ':' || at_ns FROM _deleted ORDER BY table_name, id`,
+			`SELECT table_name || ':' || object_id || ':' || at_ns || ':' || remote FROM _sync ORDER BY table_name, object_id`,
+			`SELECT id || ':' || kind FROM ` + EventTableName + ` ORDER BY id`,
+			`SELECT id || ':' || key || ':' || value FROM ` + EventLabelsTableName + ` ORDER BY id, key`,
+			`SELECT id || ':' || user FROM ` + SessionTableName + ` ORDER BY id`,
+			`SELECT type_name || ':' || id FROM _unknown_types ORDER BY type_name, id`,
+		} {
+			rows, err := db.QueryContext(ctx, query)
+			assert.NilError(t, err)
+			for rows.Next() {
+				var line string
+				assert.NilError(t, rows.Scan(&line))
+				lines = append(lines, line)
+			}
+			assert.NilError(t, rows.Err())
+			assert.NilError(t, rows.Close())
+		}
+		return lines
+	}
+	load := func(name string, bulk bool) []string {
+		t.Helper()
+		db, err := sql.Open("sqlite3", "file:"+name+"?mode=memory&cache=shared")
+		assert.NilError(t, err)
+		t.Cleanup(func() {
+			assert.NilError(t, db.Close())
+		})
+		crud := NewCRUD(db)
+		assert.NilError(t, crud.Init())
+		_, err = crud.Person.InsertWithID(localID, &Person{Name: "local"})
+		assert.NilError(t, err)
+		if bulk {
+			assert.NilError(t, crud.ReadJSONLBulk(testRemoteA, strings.NewReader(importData), 2))
+		} else {
+			assert.NilError(t, crud.ReadJSONL(testRemoteA, strings.NewReader(importData)))
+		}
+		return dump(db)
+	}
+
+	expected := load("jsonl-rowwise", false)
+	assert.Check(t, is.Contains(expected, PersonTableName+":"+deletedID+":110"))
+	assert.Check(t, is.Contains(expected, updatedID+":120:second"))
+	assert.Check(t, is.Contains(expected, localID+":now:local"))
+	assert.Check(t, is.Contains(expected, "event-1:app:web"))
+	assert.DeepEqual(t, load("jsonl-bulk", true), expected)
+}
+
+func TestGeneratedJSONLBulkRollsBackOnError(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:jsonl-bulk-rollback?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+
+	importData := fmt.Sprintf("{\"id\":\"p1\",\"atNs\":100,\"data\":{\"@type\":%q,\"name\":\"Ada\"}}\n", typeURLPrefix+PersonTypeName) +
+		fmt.Sprintf("{\"id\":\"p2\",\"atNs\":100,\"data\":{\"@type\":%q,\"name\":1}}\n", typeURLPrefix+PersonTypeName)
+	err = crud.ReadJSONLBulk(testRemoteA, strings.NewReader(importData), 1)
+	assert.ErrorContains(t, err, "unmarshal jsonl data on line 2")
+
+	people, err := crud.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(people, 0))
+	var syncCount int
+	assert.NilError(t, db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM _sync").Scan(&syncCount))
+	assert.Check(t, is.Equal(syncCount, 0))
+}
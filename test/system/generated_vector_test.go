@@ -0,0 +1,59 @@
+package genexample
+
+import (
+	"path/filepath"
+	"testing"
+
+	rt "github.com/fingon/proprdb/rt"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestGeneratedNearestNeighbors(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "vector.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{DeviceID: "device-a"})
+	assert.NilError(t, crud.Init())
+
+	insert := func(title string, embedding ...float32) string {
+		t.Helper()
+		row, err := crud.Document.Insert(&Document{Title: title, Embedding: embedding})
+		assert.NilError(t, err)
+		return row.ID
+	}
+	east := insert("east", 1, 0)
+	northEast := insert("north-east", 1, 1)
+	north := insert("north", 0, 1)
+	west := insert("west", -1, 0)
+	insert("no embedding")
+	insert("other dimension", 1, 0, 0)
+
+	neighbors, err := crud.Document.NearestNeighbors([]float32{2, 0.1}, 3)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(rt.NeighborIDs(neighbors), []string{east, northEast, north}))
+	assert.Check(t, neighbors[0].Distance < 0.01)
+	assert.Check(t, neighbors[0].Distance <= neighbors[1].Distance && neighbors[1].Distance <= neighbors[2].Distance)
+
+	neighbors, err = crud.Document.NearestNeighbors([]float32{-1, 0}, 10)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(neighbors, 4), "rows of another dimension are skipped")
+	assert.Check(t, is.Equal(neighbors[0].ID, west))
+	assert.Check(t, is.Equal(neighbors[3].ID, east))
+	assert.Check(t, is.Equal(neighbors[3].Distance, 2.0))
+
+	// Updates reproject the vector.
+	_, err = crud.Document.UpdateByID(west, &Document{Title: "west", Embedding: []float32{1, 0.05}})
+	assert.NilError(t, err)
+	neighbors, err = crud.Document.NearestNeighbors([]float32{1, 0}, 2)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(rt.NeighborIDs(neighbors), []string{east, west}))
+
+	rows, err := crud.Document.SelectProjected("id = ?", northEast)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	stored, err := rt.DecodeVector(rows[0].Embedding)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(stored, []float32{1, 1}))
+
+	_, err = crud.Document.NearestNeighbors(nil, 3)
+	assert.Check(t, is.ErrorContains(err, "empty query vector"))
+}
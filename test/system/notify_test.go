@@ -0,0 +1,100 @@
+package genexample
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+	"github.com/fingon/proprdb/rt/notify"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestNotifyWebhooks(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "notify.db"))
+	crud := NewCRUDWithOptions(db, rt.Options{ChangeFeed: true})
+	assert.NilError(t, crud.Init())
+
+	secret := []byte("s3cret")
+	var mu sync.Mutex
+	var events []notify.Event
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		assert.Check(t, err)
+		if !notify.Verify(secret, body, r.Header.Get(notify.SignatureHeader)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		var event notify.Event
+		assert.Check(t, json.Unmarshal(body, &event))
+		events = append(events, event)
+	}))
+	t.Cleanup(server.Close)
+
+	person, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	_, err = crud.Note.Insert(&Note{Text: "not watched"})
+	assert.NilError(t, err)
+	task, err := crud.Task.Insert(&Task{Title: "write"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Task.DeleteByID(task.ID))
+
+	drainer := rt.OutboxDrainer{DB: db, Consumer: "webhooks", Publisher: &notify.Notifier{
+		DB: db,
+		Webhooks: map[string][]notify.Webhook{
+			PersonTableName: {{URL: server.URL, Secret: secret}},
+			TaskTableName:   {{URL: server.URL, Secret: secret}},
+		},
+		Backoff: time.Millisecond,
+	}}
+	published, err := drainer.Drain(context.Background())
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(published, int64(4)))
+
+	mu.Lock()
+	assert.Check(t, is.Equal(requests, 4), "the first attempt was retried")
+	assert.Assert(t, is.Len(events, 3))
+	assert.Check(t, is.Equal(events[0].TypeName, PersonTypeName))
+	assert.Check(t, is.Equal(events[0].ID, person.ID))
+	assert.Check(t, is.Equal(events[0].Op, rt.ChangeInsert))
+	assert.Check(t, is.Equal(events[0].AtNs, person.AtNs))
+	var data map[string]any
+	assert.NilError(t, json.Unmarshal(events[0].Data, &data))
+	assert.Check(t, is.Equal(data["name"], "Ada"))
+	assert.Check(t, is.Equal(events[2].ID, task.ID))
+	assert.Check(t, is.Equal(events[2].Op, rt.ChangeDelete))
+	assert.Check(t, is.Len(events[2].Data, 0), "deleted rows have no data")
+	mu.Unlock()
+
+	// Failing deliveries leave the checkpoint in place.
+	_, err = crud.Person.Insert(&Person{Name: "Grace", Age: 85})
+	assert.NilError(t, err)
+	failing := rt.OutboxDrainer{DB: db, Consumer: "webhooks", Publisher: &notify.Notifier{
+		DB:          db,
+		Webhooks:    map[string][]notify.Webhook{PersonTableName: {{URL: server.URL, Secret: []byte("wrong")}}},
+		MaxAttempts: 5,
+	}}
+	_, err = failing.Drain(context.Background())
+	assert.Check(t, is.ErrorContains(err, "401"))
+	checkpoint, err := rt.OutboxCheckpoint(db, "webhooks")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(checkpoint, int64(4)))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Check(t, is.Equal(requests, 5), "client errors are not retried")
+}
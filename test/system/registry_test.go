@@ -0,0 +1,199 @@
+package genexample
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	rt "github.com/fingon/proprdb/rt"
+	_ "github.com/mattn/go-sqlite3"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestRegistryContainsGeneratedTables(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:registry?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+
+	var registered *rt.RegisteredBundle
+	for _, bundle := range rt.RegisteredBundles() {
+		if bundle.GoImportPath == "generatedtest/gen" {
+			registered = &bundle
+		}
+	}
+	assert.Assert(t, registered != nil)
+	assert.Check(t, is.DeepEqual(registered.Descriptors, NewCRUD(nil).TableDescriptors()))
+
+	descriptors := rt.RegisteredTableDescriptors()
+	tableCounts := make(map[string]int)
+	for _, descriptor := range descriptors {
+		tableCounts[descriptor.TableName]++
+	}
+	assert.Check(t, is.Equal(tableCounts[PersonTableName], 1))
+	assert.Check(t, is.Equal(tableCounts[rt.CoreTableDeletedName], 1))
+
+	bundle := registered.Factory(db, rt.Options{})
+	assert.NilError(t, bundle.Init())
+	crud, ok := bundle.(*CRUD)
+	assert.Assert(t, ok)
+	_, err = crud.Person.Insert(&Person{Name: "Registered", Age: 1})
+	assert.NilError(t, err)
+
+	assert.Check(t, is.Panics(func() {
+		rt.RegisterTables("duplicate/gen", crudGeneratedTableDescriptors, registered.Factory)
+	}))
+}
+
+// stubBundle stands in for the generated CRUD of another package.
+type stubBundle struct {
+	q     rt.DBTX
+	inits int
+	read  []rt.JSONLRecord
+}
+
+const (
+	stubTypeName  = "generatedtest.other.Stub"
+	stubTableName = "generatedtest_other_stub"
+)
+
+// registeredStub is registered as package generatedtest/other by
+// registerStub. The CLI tests run before and rely on generatedtest/gen being
+// the only registered package.
+var (
+	registeredStub   = &stubBundle{}
+	registerStubOnce sync.Once
+)
+
+func registerStub() {
+	registerStubOnce.Do(func() {
+		rt.RegisterTables("generatedtest/other", registeredStub.TableDescriptors(), func(q rt.DBTX, _ rt.Options) rt.Bundle {
+			registeredStub.q = q
+			return registeredStub
+		})
+	})
+}
+
+func (b *stubBundle) Init() error {
+	b.inits++
+	if b.q == nil {
+		return nil
+	}
+	_, err := b.q.ExecContext(context.Background(), `INSERT OR IGNORE INTO `+rt.CoreTableSchemaStateName+` (table_name, schema_hash) VALUES (?, '')`, stubTableName)
+	return err
+}
+
+func (b *stubBundle) TableDescriptors() []rt.GeneratedTableDescriptor {
+	return []rt.GeneratedTableDescriptor{
+		{TableName: stubTableName, TypeName: stubTypeName, SyncEnabled: true},
+		{TableName: rt.CoreTableDeletedName, IsCore: true},
+	}
+}
+
+func (b *stubBundle) WriteJSONL(_ string, w io.Writer) error {
+	_, err := io.WriteString(w, `{"id":"stub-1","atNs":5,"data":{"@type":"type.googleapis.com/`+stubTypeName+`"}}`+"\n")
+	return err
+}
+
+func (b *stubBundle) ReadJSONL(_ string, r io.Reader) error {
+	return rt.ReadJSONLWithOptions(r, rt.JSONLOptions{}, func(record rt.JSONLRecord, _ int) error {
+		b.read = append(b.read, record)
+		return nil
+	})
+}
+
+func TestComposeCRUD(t *testing.T) {
+	source := NewCRUD(openCLITestDB(t, filepath.Join(t.TempDir(), "source.db")))
+	sourceStub := &stubBundle{}
+	composed, err := rt.ComposeCRUD(rt.JSONLOptions{Manifest: true}, source, sourceStub)
+	assert.NilError(t, err)
+	assert.NilError(t, composed.Init())
+	assert.Check(t, is.Equal(sourceStub.inits, 1))
+
+	tableCounts := make(map[string]int)
+	for _, descriptor := range composed.TableDescriptors() {
+		tableCounts[descriptor.TableName]++
+	}
+	assert.Check(t, is.Equal(tableCounts[PersonTableName], 1))
+	assert.Check(t, is.Equal(tableCounts[stubTableName], 1))
+	assert.Check(t, is.Equal(tableCounts[rt.CoreTableDeletedName], 1))
+
+	person, err := source.Person.Insert(&Person{Name: "Composed", Age: 3})
+	assert.NilError(t, err)
+	var stream bytes.Buffer
+	assert.NilError(t, composed.WriteJSONL("peer", &stream))
+	assert.Check(t, is.Equal(strings.Count(stream.String(), `"manifest"`), 1), stream.String())
+
+	targetDB := openCLITestDB(t, filepath.Join(t.TempDir(), "target.db"))
+	target := NewCRUD(targetDB)
+	targetStub := &stubBundle{}
+	composed, err = rt.ComposeCRUD(rt.JSONLOptions{RequireManifest: true}, target, targetStub)
+	assert.NilError(t, err)
+	assert.NilError(t, composed.Init())
+	assert.NilError(t, composed.ReadJSONL("peer", bytes.NewReader(stream.Bytes())))
+	got, err := target.Person.GetByID(person.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(got.Data.GetName(), "Composed"))
+	assert.Assert(t, is.Len(targetStub.read, 1))
+	assert.Check(t, is.Equal(targetStub.read[0].ID, "stub-1"))
+	var parked int
+	assert.NilError(t, targetDB.QueryRow(`SELECT COUNT(*) FROM `+rt.CoreTableUnknownName).Scan(&parked))
+	assert.Check(t, is.Equal(parked, 0), "records of other bundles are not parked")
+
+	truncated := stream.String()[:strings.Index(stream.String(), `{"manifest"`)]
+	assert.Check(t, is.ErrorIs(composed.ReadJSONL("peer", strings.NewReader(truncated)), rt.ErrJSONLManifest))
+
+	_, err = rt.ComposeCRUD(rt.JSONLOptions{}, source, target)
+	assert.Check(t, is.ErrorContains(err, "in both bundle 0 and 1"))
+}
+
+func TestReadJSONLDispatchesRegisteredTypes(t *testing.T) {
+	registerStub()
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "dispatch.db"))
+	crud := NewCRUD(db)
+	assert.NilError(t, crud.Init())
+	stream := func(stubID string) io.Reader {
+		return strings.NewReader(`{"id":"` + stubID + `","atNs":5,"data":{"@type":"type.googleapis.com/` + stubTypeName + `"}}` + "\n" +
+			`{"id":"later-` + stubID + `","atNs":5,"data":{"@type":"type.googleapis.com/generatedtest.later.Type"}}` + "\n")
+	}
+	parked := func(typeName string) int {
+		var count int
+		assert.NilError(t, db.QueryRow(`SELECT COUNT(*) FROM `+rt.CoreTableUnknownName+` WHERE type_name = ?`, typeName).Scan(&count))
+		return count
+	}
+
+	// The table of the other package is not initialized, so the record is
+	// parked as before.
+	assert.NilError(t, crud.ReadJSONL("peer", stream("stub-parked")))
+	assert.Check(t, is.Equal(parked(stubTypeName), 1))
+
+	var registered *rt.RegisteredBundle
+	for _, bundle := range rt.RegisteredBundles() {
+		if bundle.GoImportPath == "generatedtest/other" {
+			registered = &bundle
+		}
+	}
+	assert.Assert(t, registered != nil)
+	assert.NilError(t, registered.Factory(db, rt.Options{}).Init())
+	registeredStub.read = nil
+
+	assert.NilError(t, crud.ReadJSONL("peer", stream("stub-1")))
+	assert.NilError(t, crud.ReadJSONLBulk("peer", stream("stub-2"), 0))
+	assert.Assert(t, is.Len(registeredStub.read, 2))
+	assert.Check(t, is.Equal(registeredStub.read[0].ID, "stub-1"))
+	assert.Check(t, is.Equal(registeredStub.read[1].ID, "stub-2"))
+	assert.Check(t, is.Equal(parked(stubTypeName), 1))
+	assert.Check(t, is.Equal(parked("generatedtest.later.Type"), 3))
+
+	diff, err := crud.DiffJSONL(stream("stub-3"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(diff.Unknown, int64(2)))
+	assert.Check(t, is.Len(registeredStub.read, 2))
+}
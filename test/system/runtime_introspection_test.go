@@ -1,13 +1,22 @@
 package genexample
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
 	rt "github.com/fingon/proprdb/rt"
 	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
 	"gotest.tools/v3/assert"
 	is "gotest.tools/v3/assert/cmp"
 )
@@ -90,3 +99,175 @@ func TestRTIntrospectTablesMissingTableErrors(t *testing.T) {
 	assert.Assert(t, err != nil)
 	assert.Check(t, strings.Contains(err.Error(), "count objects for table missing_table"))
 }
+
+func TestRTConfigureSQLite(t *testing.T) {
+	db := openCLITestDB(t, filepath.Join(t.TempDir(), "pragmas.db"))
+	// Connection-scoped PRAGMAs are only visible on the configured connection.
+	db.SetMaxOpenConns(1)
+	crud := NewCRUDWithOptions(db, rt.Options{SQLite: rt.ConcurrentSQLite})
+	assert.NilError(t, crud.Init())
+
+	var journalMode string
+	assert.NilError(t, db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode))
+	assert.Check(t, is.Equal(journalMode, "wal"))
+	var busyTimeout, synchronous, foreignKeys int
+	assert.NilError(t, db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout))
+	assert.Check(t, is.Equal(busyTimeout, 5000))
+	assert.NilError(t, db.QueryRow(`PRAGMA synchronous`).Scan(&synchronous))
+	assert.Check(t, is.Equal(synchronous, 1), "NORMAL")
+	assert.NilError(t, db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys))
+	assert.Check(t, is.Equal(foreignKeys, 1))
+
+	invalid := NewCRUDWithOptions(db, rt.Options{SQLite: rt.SQLiteOptions{SynchronousMode: "SOMETIMES"}})
+	assert.ErrorContains(t, invalid.Init(), `unknown synchronous mode "SOMETIMES"`)
+}
+
+// otherThingDescriptorSet defines other.Thing, a type not compiled into
+// this package, importing the linked-in google.protobuf.Timestamp.
+func otherThingDescriptorSet() *descriptorpb.FileDescriptorSet {
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:       proto.String("other/thing.proto"),
+		Package:    proto.String("other"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Thing"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("label"), JsonName: proto.String("label"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+				{Name: proto.String("seen"), JsonName: proto.String("seen"), Number: proto.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".google.protobuf.Timestamp")},
+			},
+		}},
+	}}}
+}
+
+func TestRTDynamicTypesDecodeUnknown(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:rt-dynamic-unknown?mode=memory&cache=shared")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, db.Close())
+	})
+	assert.NilError(t, rt.EnsureCoreTables(db))
+	thing := func(label string) []byte {
+		return []byte(`{"@type":"type.googleapis.com/other.Thing","label":"` + label + `","seen":"2024-01-02T03:04:05Z"}`)
+	}
+	for _, row := range []struct {
+		typeName string
+		record   rt.JSONLRecord
+	}{
+		{"other.Thing", rt.JSONLRecord{ID: "t1", AtNs: 1, Data: thing("first")}},
+		{"other.Thing", rt.JSONLRecord{ID: "t2", AtNs: 2, Deleted: true, Data: thing("gone")}},
+		{"other.Gone", rt.JSONLRecord{ID: "g1", AtNs: 3, Data: []byte(`{"@type":"type.googleapis.com/other.Gone"}`)}},
+	} {
+		assert.NilError(t, rt.UnknownInsert(db, row.typeName, row.record))
+	}
+
+	types, err := rt.NewDynamicTypes(otherThingDescriptorSet())
+	assert.NilError(t, err)
+	assert.Check(t, types.Knows("other.Thing"))
+	assert.Check(t, !types.Knows("other.Gone"))
+	decoded, err := types.DecodeUnknown(db, "")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(decoded, 3))
+	assert.Check(t, is.Equal(decoded[0].TypeName, "other.Gone"))
+	assert.Check(t, decoded[0].Err != nil)
+	assert.Check(t, is.Nil(decoded[0].Message))
+
+	first := decoded[1]
+	assert.NilError(t, first.Err)
+	assert.Check(t, is.Equal(first.Record.ID, "t1"))
+	fields := first.Message.ProtoReflect().Descriptor().Fields()
+	assert.Check(t, is.Equal(first.Message.ProtoReflect().Get(fields.ByName("label")).String(), "first"))
+	seen := first.Message.ProtoReflect().Get(fields.ByName("seen")).Message()
+	assert.Check(t, is.Equal(seen.Get(seen.Descriptor().Fields().ByName("seconds")).Int(), int64(1704164645)))
+	assert.Check(t, decoded[2].Record.Deleted)
+	assert.Check(t, is.Nil(decoded[2].Message))
+
+	onlyThings, err := types.DecodeUnknown(db, "other.Thing")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(onlyThings, 2))
+
+	// Descriptors of compiled types travel as a serialized set.
+	serialized, err := proto.Marshal(rt.DescriptorSetOf(&Person{}))
+	assert.NilError(t, err)
+	loaded, err := rt.LoadDynamicTypes(serialized)
+	assert.NilError(t, err)
+	data, err := protojson.Marshal(mustAny(t, &Person{Name: "Ada"}))
+	assert.NilError(t, err)
+	message, err := loaded.Decode(data)
+	assert.NilError(t, err)
+	_, isDynamic := message.(*dynamicpb.Message)
+	assert.Check(t, isDynamic)
+	assert.Check(t, is.Equal(message.ProtoReflect().Get(message.ProtoReflect().Descriptor().Fields().ByName("name")).String(), "Ada"))
+}
+
+func TestRTDynamicBundle(t *testing.T) {
+	source := openCLITestDB(t, filepath.Join(t.TempDir(), "source.db"))
+	crud := NewCRUD(source)
+	assert.NilError(t, crud.Init())
+	ada, err := crud.Person.Insert(&Person{Name: "Ada", Age: 36, Address: &Person_Address{City: "London", Zip: 1815}})
+	assert.NilError(t, err)
+	bob, err := crud.Person.Insert(&Person{Name: "Bob"})
+	assert.NilError(t, err)
+	assert.NilError(t, crud.Person.DeleteByID(bob.ID))
+	ticket, err := crud.Ticket.Insert(&Ticket{Subject: "Printer"})
+	assert.NilError(t, err)
+
+	// The types come from descriptors, not from the generated package.
+	types, err := rt.NewDynamicTypes(rt.DescriptorSetOf(&Person{}, &Ticket{}, &Task{}))
+	assert.NilError(t, err)
+	bundle := rt.NewDynamicBundle(source, types)
+	assert.NilError(t, bundle.Init())
+	assert.Check(t, slices.ContainsFunc(bundle.TableDescriptors(), func(descriptor rt.GeneratedTableDescriptor) bool {
+		return descriptor.TableName == TicketTableName && descriptor.TypeName == TicketTypeName && descriptor.SyncEnabled
+	}))
+	var out bytes.Buffer
+	assert.NilError(t, bundle.WriteJSONL(testRemoteA, &out))
+	var again bytes.Buffer
+	assert.NilError(t, bundle.WriteJSONL(testRemoteA, &again))
+	assert.Check(t, is.Equal(again.Len(), 0), "written records are marked in _sync")
+
+	target := openCLITestDB(t, filepath.Join(t.TempDir(), "target.db"))
+	imported := NewCRUD(target)
+	assert.NilError(t, imported.Init())
+	targetBundle := rt.NewDynamicBundle(target, types)
+	assert.NilError(t, targetBundle.Init())
+	assert.NilError(t, targetBundle.ReadJSONL(testRemoteA, bytes.NewReader(out.Bytes())))
+	people, err := imported.Person.Select("address_city = ? AND age = ?", "London", 36)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(people, 1))
+	assert.Check(t, is.Equal(people[0].ID, ada.ID))
+	assert.Check(t, is.Equal(people[0].AtNs, ada.AtNs))
+	assert.Check(t, proto.Equal(people[0].Data, ada.Data))
+	tickets, err := imported.Ticket.Select("subject_line = ?", "Printer")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(tickets, 1))
+	assert.Check(t, is.Equal(tickets[0].ID, ticket.ID))
+	tombstones, err := rt.ListTombstones(target, PersonTableName)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(tombstones, []rt.Tombstone{{ID: bob.ID, AtNs: tombstones[0].AtNs}}))
+
+	// Field rules hold for dynamic imports too.
+	invalid, err := rt.MarshalAnyJSON(&Person{Name: "Old", Age: 500})
+	assert.NilError(t, err)
+	line, err := json.Marshal(rt.JSONLRecord{ID: "018f4f3f-6f9f-7a1b-8f55-000000000001", AtNs: 1, Data: invalid})
+	assert.NilError(t, err)
+	err = targetBundle.ReadJSONL(testRemoteA, bytes.NewReader(append(line, '\n')))
+	assert.Check(t, is.ErrorContains(err, "age must be at most 200"))
+	_, err = imported.Person.GetByID("018f4f3f-6f9f-7a1b-8f55-000000000001")
+	assert.Check(t, is.ErrorIs(err, rt.ErrNotFound))
+
+	// Tables whose records need generated code are exported only.
+	_, err = crud.Task.Insert(&Task{Title: "Merge me"})
+	assert.NilError(t, err)
+	var tasks bytes.Buffer
+	assert.NilError(t, bundle.WriteJSONL(testRemoteA, &tasks))
+	err = targetBundle.ReadJSONL(testRemoteA, bytes.NewReader(tasks.Bytes()))
+	assert.Check(t, is.ErrorContains(err, "merge conflict strategy"))
+}
+
+func mustAny(t *testing.T, message proto.Message) *anypb.Any {
+	t.Helper()
+	packed, err := anypb.New(message)
+	assert.NilError(t, err)
+	return packed
+}
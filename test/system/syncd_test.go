@@ -0,0 +1,410 @@
+package genexample
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	rt "github.com/fingon/proprdb/rt"
+	proprdbsyncd "github.com/fingon/proprdb/syncd"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func newSyncdTestDaemon(t *testing.T, path, device string, remotes ...proprdbsyncd.RemoteConfig) (*proprdbsyncd.Daemon, *CRUD) {
+	t.Helper()
+
+	db := openCLITestDB(t, path)
+	newBundle := func(q rt.DBTX) rt.Bundle {
+		return NewCRUD(q)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	daemon, err := proprdbsyncd.NewDaemon(db, newBundle, device, remotes, logger)
+	assert.NilError(t, err)
+	return daemon, NewCRUD(db)
+}
+
+func TestSyncdReplicatesThroughDirectory(t *testing.T) {
+	tempDir := t.TempDir()
+	remote := proprdbsyncd.RemoteConfig{Name: "shared", URL: filepath.Join(tempDir, "remote"), Interval: time.Second, Mode: proprdbsyncd.ModePushPull}
+	daemonA, crudA := newSyncdTestDaemon(t, filepath.Join(tempDir, "a.db"), "a", remote)
+	daemonB, crudB := newSyncdTestDaemon(t, filepath.Join(tempDir, "b.db"), "b", remote)
+	ctx := context.Background()
+
+	inserted, err := crudA.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	assert.NilError(t, daemonA.SyncOnce(ctx))
+	assert.NilError(t, daemonB.SyncOnce(ctx))
+
+	rows, err := crudB.Person.Select("")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].ID, inserted.ID))
+	assert.Check(t, is.Equal(rows[0].Data.GetName(), "Ada"))
+
+	_, err = crudB.Person.UpdateByID(inserted.ID, &Person{Name: "Ada", Age: 37})
+	assert.NilError(t, err)
+	assert.NilError(t, daemonB.SyncOnce(ctx))
+	assert.NilError(t, daemonA.SyncOnce(ctx))
+
+	rows, err = crudA.Person.Select("")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].Data.GetAge(), int64(37)))
+
+	// Nothing new: a further pass must not publish another segment.
+	matches, err := filepath.Glob(filepath.Join(remote.URL, "*.jsonl"))
+	assert.NilError(t, err)
+	assert.NilError(t, daemonA.SyncOnce(ctx))
+	after, err := filepath.Glob(filepath.Join(remote.URL, "*.jsonl"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(after, len(matches)))
+}
+
+func TestSyncdRetriesFailedPush(t *testing.T) {
+	failing := true
+	var received bytes.Buffer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, err := io.Copy(&received, r.Body)
+		assert.Check(t, err)
+		assert.Check(t, is.Equal(r.Header.Get(proprdbsyncd.DeviceHeader), "a"))
+	}))
+	defer server.Close()
+
+	remote := proprdbsyncd.RemoteConfig{Name: "http", URL: server.URL, Interval: time.Second, Mode: proprdbsyncd.ModePush}
+	daemon, crud := newSyncdTestDaemon(t, filepath.Join(t.TempDir(), "a.db"), "a", remote)
+	_, err := crud.Person.Insert(&Person{Name: "Grace", Age: 40})
+	assert.NilError(t, err)
+
+	assert.ErrorContains(t, daemon.SyncOnce(context.Background()), "503")
+	failing = false
+	assert.NilError(t, daemon.SyncOnce(context.Background()))
+	assert.Check(t, bytes.Contains(received.Bytes(), []byte(`"name":"Grace"`)))
+}
+
+func TestSyncdParseRemote(t *testing.T) {
+	remote, err := proprdbsyncd.ParseRemote("name=alpha,url=s3://bucket/prefix,every=5m,mode=pull")
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(remote, proprdbsyncd.RemoteConfig{Name: "alpha", URL: "s3://bucket/prefix", Interval: 5 * time.Minute, Mode: proprdbsyncd.ModePull}))
+
+	_, err = proprdbsyncd.ParseRemote("name=alpha")
+	assert.ErrorContains(t, err, "name and url are required")
+	_, err = proprdbsyncd.ParseRemote("name=alpha,url=/tmp/x,mode=sideways")
+	assert.ErrorContains(t, err, "unsupported mode")
+	_, err = proprdbsyncd.ParseRemote("name=alpha,url=/tmp/x,every=-1s")
+	assert.ErrorContains(t, err, "every must be positive")
+	_, err = proprdbsyncd.OpenTransport("ftp://host/prefix", "a")
+	assert.ErrorContains(t, err, "unsupported remote scheme")
+}
+
+func TestSyncdSegmentDevices(t *testing.T) {
+	ctx := context.Background()
+	remoteDir := t.TempDir()
+	pulled := func(device string) []string {
+		t.Helper()
+		transport, err := proprdbsyncd.OpenTransport(remoteDir, device)
+		assert.NilError(t, err)
+		segments := make([]string, 0)
+		assert.NilError(t, transport.Pull(ctx, func(segment io.Reader) error {
+			content, err := io.ReadAll(segment)
+			segments = append(segments, string(content))
+			return err
+		}))
+		return segments
+	}
+	for _, device := range []string{"laptop", "work-laptop"} {
+		transport, err := proprdbsyncd.OpenTransport(remoteDir, device)
+		assert.NilError(t, err)
+		assert.NilError(t, transport.Push(ctx, []byte(device)))
+	}
+
+	// A device whose id ends in another's still gets its segments.
+	assert.Check(t, is.DeepEqual(pulled("laptop"), []string{"work-laptop"}))
+	assert.Check(t, is.DeepEqual(pulled("work-laptop"), []string{"laptop"}))
+	assert.Check(t, is.DeepEqual(pulled("phone"), []string{"laptop", "work-laptop"}))
+
+	_, err := proprdbsyncd.OpenTransport(remoteDir, "work/laptop")
+	assert.ErrorContains(t, err, "contains /")
+}
+
+func TestSyncdResumesFromLastSegment(t *testing.T) {
+	tempDir := t.TempDir()
+	remoteDir := filepath.Join(tempDir, "remote")
+	remote := proprdbsyncd.RemoteConfig{Name: "shared", URL: remoteDir, Interval: time.Second, Mode: proprdbsyncd.ModePull}
+	dbPath := filepath.Join(tempDir, "b.db")
+	daemon, crud := newSyncdTestDaemon(t, dbPath, "b", remote)
+	ctx := context.Background()
+	writeSegment := func(pushedAtNs, atNs int64, id, name string) string {
+		t.Helper()
+		segmentName := strconv.FormatInt(pushedAtNs, 10) + "-c.jsonl"
+		record := `{"id":"` + id + `","atNs":` + strconv.FormatInt(atNs, 10) + `,"data":{"@type":"` + typeURLPrefix + PersonTypeName + `","name":"` + name + `"}}` + "\n"
+		assert.NilError(t, os.WriteFile(filepath.Join(remoteDir, segmentName), []byte(record), 0o644))
+		return segmentName
+	}
+	pushedAtNs := time.Now().Add(-time.Hour).UnixNano()
+	first := writeSegment(pushedAtNs-int64(time.Hour), 1, "p1", "Ada")
+	last := writeSegment(pushedAtNs, 1, "p2", "Grace")
+	assert.NilError(t, daemon.SyncOnce(ctx))
+	db := openCLITestDB(t, dbPath)
+	applied, err := rt.RemoteSegment(db, remote.Name)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(applied, last))
+
+	// Newer content in the applied segments shows whether a restarted
+	// daemon applies them again; a late segment pushed just before the last
+	// one is still pulled.
+	writeSegment(pushedAtNs-int64(time.Hour), 2, "p1", "Ada again")
+	writeSegment(pushedAtNs, 2, "p2", "Grace again")
+	writeSegment(pushedAtNs-int64(time.Second), 1, "p3", "Late")
+	restarted, _ := newSyncdTestDaemon(t, dbPath, "b", remote)
+	assert.NilError(t, restarted.SyncOnce(ctx))
+	rows, err := crud.Person.Select("")
+	assert.NilError(t, err)
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, row.Data.GetName())
+	}
+	slices.Sort(names)
+	assert.Check(t, is.DeepEqual(names, []string{"Ada", "Grace", "Late"}))
+	applied, err = rt.RemoteSegment(db, remote.Name)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(applied, last), "the last segment only moves forward")
+	assert.Check(t, first < applied)
+}
+
+// fakeS3 implements the path-style PutObject, GetObject and ListObjectsV2
+// calls used by the S3 transport, listing one object per page.
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+	// startAfter is the start-after of the last listing.
+	startAfter string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authorization := r.Header.Get("Authorization")
+	assert.Check(f.t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=test-key/"), authorization)
+	assert.Check(f.t, strings.Contains(authorization, "/eu-north-1/s3/aws4_request"), authorization)
+	body, err := io.ReadAll(r.Body)
+	assert.Check(f.t, err)
+	sum := sha256.Sum256(body)
+	assert.Check(f.t, is.Equal(r.Header.Get("X-Amz-Content-Sha256"), hex.EncodeToString(sum[:])))
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != "bucket" {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case key != "":
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		_, err := w.Write(object)
+		assert.Check(f.t, err)
+	default:
+		assert.Check(f.t, is.Equal(r.URL.Query().Get("list-type"), "2"))
+		f.startAfter = r.URL.Query().Get("start-after")
+		keys := make([]string, 0, len(f.objects))
+		for objectKey := range f.objects {
+			if strings.HasPrefix(objectKey, r.URL.Query().Get("prefix")) && objectKey > f.startAfter {
+				keys = append(keys, objectKey)
+			}
+		}
+		slices.Sort(keys)
+		start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+		response := "<ListBucketResult>"
+		if start < len(keys) {
+			response += "<Contents><Key>" + keys[start] + "</Key></Contents>"
+		}
+		if start+1 < len(keys) {
+			response += "<IsTruncated>true</IsTruncated><NextContinuationToken>" + strconv.Itoa(start+1) + "</NextContinuationToken>"
+		}
+		_, err := io.WriteString(w, response+"</ListBucketResult>")
+		assert.Check(f.t, err)
+	}
+}
+
+func TestSyncdReplicatesThroughS3(t *testing.T) {
+	bucket := &fakeS3{t: t, objects: make(map[string][]byte)}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	tempDir := t.TempDir()
+	remote := proprdbsyncd.RemoteConfig{
+		Name:     "bucket",
+		URL:      "s3://bucket/devices/shared?region=eu-north-1&endpoint=" + url.QueryEscape(server.URL),
+		Interval: time.Second,
+		Mode:     proprdbsyncd.ModePushPull,
+	}
+	daemonA, crudA := newSyncdTestDaemon(t, filepath.Join(tempDir, "a.db"), "a", remote)
+	daemonB, crudB := newSyncdTestDaemon(t, filepath.Join(tempDir, "b.db"), "b", remote)
+	daemonC, crudC := newSyncdTestDaemon(t, filepath.Join(tempDir, "c.db"), "c", remote)
+	ctx := context.Background()
+
+	_, err := crudA.Person.Insert(&Person{Name: "Ada", Age: 36})
+	assert.NilError(t, err)
+	_, err = crudB.Person.Insert(&Person{Name: "Grace", Age: 40})
+	assert.NilError(t, err)
+	assert.NilError(t, daemonA.SyncOnce(ctx))
+	assert.NilError(t, daemonB.SyncOnce(ctx))
+	assert.NilError(t, daemonC.SyncOnce(ctx))
+
+	bucket.mu.Lock()
+	keys := make([]string, 0, len(bucket.objects))
+	for key := range bucket.objects {
+		keys = append(keys, key)
+	}
+	bucket.mu.Unlock()
+	assert.Assert(t, is.Len(keys, 2))
+	for _, key := range keys {
+		assert.Check(t, strings.HasPrefix(key, "devices/shared/"), key)
+	}
+
+	rows, err := crudC.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 2))
+	assert.NilError(t, daemonA.SyncOnce(ctx))
+	rows, err = crudA.Person.Select("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rows, 2))
+
+	// A restarted daemon lists the bucket from its last applied segment.
+	restartedC, _ := newSyncdTestDaemon(t, filepath.Join(tempDir, "c.db"), "c", remote)
+	assert.NilError(t, restartedC.SyncOnce(ctx))
+	bucket.mu.Lock()
+	startAfter := bucket.startAfter
+	bucket.mu.Unlock()
+	assert.Check(t, strings.HasPrefix(startAfter, "devices/shared/1"), startAfter)
+}
+
+func TestSyncdQuarantinesUntrustedSegments(t *testing.T) {
+	trustedKey, signingKey, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+	_, untrustedKey, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+
+	tempDir := t.TempDir()
+	remote := proprdbsyncd.RemoteConfig{Name: "shared", URL: filepath.Join(tempDir, "remote"), Interval: time.Second, Mode: proprdbsyncd.ModePushPull}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newDaemon := func(device string, jsonl rt.JSONLOptions) (*proprdbsyncd.Daemon, *CRUD) {
+		t.Helper()
+		db := openCLITestDB(t, filepath.Join(tempDir, device+".db"))
+		opts := rt.Options{JSONL: jsonl}
+		daemon, err := proprdbsyncd.NewDaemon(db, func(q rt.DBTX) rt.Bundle { return NewCRUDWithOptions(q, opts) }, device, []proprdbsyncd.RemoteConfig{remote}, logger)
+		assert.NilError(t, err)
+		return daemon, NewCRUDWithOptions(db, opts)
+	}
+	daemonA, crudA := newDaemon("a", rt.JSONLOptions{SigningKey: signingKey})
+	daemonB, crudB := newDaemon("b", rt.JSONLOptions{SigningKey: untrustedKey})
+	daemonC, crudC := newDaemon("c", rt.JSONLOptions{TrustedKeys: []ed25519.PublicKey{trustedKey}})
+	ctx := context.Background()
+
+	_, err = crudA.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	_, err = crudB.Person.Insert(&Person{Name: "Mallory"})
+	assert.NilError(t, err)
+	assert.NilError(t, daemonA.SyncOnce(ctx))
+	assert.NilError(t, daemonB.SyncOnce(ctx))
+	assert.NilError(t, daemonC.SyncOnce(ctx))
+	assert.NilError(t, daemonC.SyncOnce(ctx))
+
+	rows, err := crudC.Person.Select("")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(rows, 1))
+	assert.Check(t, is.Equal(rows[0].Data.GetName(), "Ada"))
+}
+
+func TestSyncSessionSummary(t *testing.T) {
+	tempDir := t.TempDir()
+	remoteDir := filepath.Join(tempDir, "remote")
+	ctx := context.Background()
+	newSession := func(device string) (rt.SyncSession, *CRUD) {
+		t.Helper()
+		db := openCLITestDB(t, filepath.Join(tempDir, device+".db"))
+		crud := NewCRUD(db)
+		assert.NilError(t, crud.Init())
+		transport, err := proprdbsyncd.OpenTransport(remoteDir, device)
+		assert.NilError(t, err)
+		return rt.SyncSession{
+			DB:        db,
+			NewBundle: func(q rt.DBTX, opts rt.Options) rt.Bundle { return NewCRUDWithOptions(q, opts) },
+			Remote:    "shared",
+			Transport: transport,
+		}, crud
+	}
+	sessionA, crudA := newSession("a")
+	sessionB, crudB := newSession("b")
+
+	ada, err := crudA.Person.Insert(&Person{Name: "Ada"})
+	assert.NilError(t, err)
+	_, err = crudA.Task.Insert(&Task{Title: "write"})
+	assert.NilError(t, err)
+	summary, err := sessionA.Run(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(summary, rt.SyncSummary{Sent: 2}))
+	summary, err = sessionB.Run(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(summary, rt.SyncSummary{Received: 2}))
+
+	// Both replicas update Ada, b last; a third device publishes a type
+	// neither knows.
+	_, err = crudA.Person.UpdateByID(ada.ID, &Person{Name: "Ada A"})
+	assert.NilError(t, err)
+	_, err = crudB.Person.UpdateByID(ada.ID, &Person{Name: "Ada B"})
+	assert.NilError(t, err)
+	transportC, err := proprdbsyncd.OpenTransport(remoteDir, "c")
+	assert.NilError(t, err)
+	assert.NilError(t, transportC.Push(ctx, []byte(`{"id":"x1","atNs":1,"data":{"@type":"type.googleapis.com/other.Thing"}}`+"\n")))
+
+	summary, err = sessionA.Run(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(summary, rt.SyncSummary{Sent: 1, Unknown: 1}))
+	summary, err = sessionB.Run(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(summary, rt.SyncSummary{Sent: 1, Received: 1, Conflicts: 1, Unknown: 1}))
+	row, err := crudB.Person.GetByID(ada.ID)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(row.Data.GetName(), "Ada B"))
+
+	// a receives b's newer update.
+	summary, err = sessionA.Run(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(summary, rt.SyncSummary{Received: 1}))
+
+	_, err = rt.SyncSession{DB: sessionA.DB, NewBundle: sessionA.NewBundle, Remote: "shared"}.Run(ctx)
+	assert.ErrorContains(t, err, "nil transport")
+}
@@ -10,6 +10,7 @@ import (
 	_ "github.com/fingon/proprdb/proto/proprdb"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -26,6 +27,7 @@ type Person struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	Age           int64                  `protobuf:"varint,2,opt,name=age,proto3" json:"age,omitempty"`
+	Address       *Person_Address        `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -74,6 +76,13 @@ func (x *Person) GetAge() int64 {
 	return 0
 }
 
+func (x *Person) GetAddress() *Person_Address {
+	if x != nil {
+		return x.Address
+	}
+	return nil
+}
+
 type Note struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Text          string                 `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
@@ -118,6 +127,194 @@ func (x *Note) GetText() string {
 	return ""
 }
 
+type Task struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Title         string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Done          bool                   `protobuf:"varint,2,opt,name=done,proto3" json:"done,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Task) Reset() {
+	*x = Task{}
+	mi := &file_system_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Task) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Task) ProtoMessage() {}
+
+func (x *Task) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Task.ProtoReflect.Descriptor instead.
+func (*Task) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Task) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Task) GetDone() bool {
+	if x != nil {
+		return x.Done
+	}
+	return false
+}
+
+type Tally struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	HighScore     int64                  `protobuf:"varint,2,opt,name=high_score,json=highScore,proto3" json:"high_score,omitempty"`
+	Plays         map[string]int64       `protobuf:"bytes,3,rep,name=plays,proto3" json:"plays,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	Tags          []string               `protobuf:"bytes,4,rep,name=tags,proto3" json:"tags,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Tally) Reset() {
+	*x = Tally{}
+	mi := &file_system_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Tally) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tally) ProtoMessage() {}
+
+func (x *Tally) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tally.ProtoReflect.Descriptor instead.
+func (*Tally) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Tally) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Tally) GetHighScore() int64 {
+	if x != nil {
+		return x.HighScore
+	}
+	return 0
+}
+
+func (x *Tally) GetPlays() map[string]int64 {
+	if x != nil {
+		return x.Plays
+	}
+	return nil
+}
+
+func (x *Tally) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+type Document struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Title         string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Body          string                 `protobuf:"bytes,2,opt,name=body,proto3" json:"body,omitempty"`
+	Embedding     []float32              `protobuf:"fixed32,3,rep,packed,name=embedding,proto3" json:"embedding,omitempty"`
+	Attachment    []byte                 `protobuf:"bytes,4,opt,name=attachment,proto3" json:"attachment,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Document) Reset() {
+	*x = Document{}
+	mi := &file_system_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Document) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Document) ProtoMessage() {}
+
+func (x *Document) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Document.ProtoReflect.Descriptor instead.
+func (*Document) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Document) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Document) GetBody() string {
+	if x != nil {
+		return x.Body
+	}
+	return ""
+}
+
+func (x *Document) GetEmbedding() []float32 {
+	if x != nil {
+		return x.Embedding
+	}
+	return nil
+}
+
+func (x *Document) GetAttachment() []byte {
+	if x != nil {
+		return x.Attachment
+	}
+	return nil
+}
+
 type Hidden struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Text          string                 `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
@@ -127,7 +324,7 @@ type Hidden struct {
 
 func (x *Hidden) Reset() {
 	*x = Hidden{}
-	mi := &file_system_proto_msgTypes[2]
+	mi := &file_system_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -139,7 +336,7 @@ func (x *Hidden) String() string {
 func (*Hidden) ProtoMessage() {}
 
 func (x *Hidden) ProtoReflect() protoreflect.Message {
-	mi := &file_system_proto_msgTypes[2]
+	mi := &file_system_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -152,7 +349,7 @@ func (x *Hidden) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Hidden.ProtoReflect.Descriptor instead.
 func (*Hidden) Descriptor() ([]byte, []int) {
-	return file_system_proto_rawDescGZIP(), []int{2}
+	return file_system_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *Hidden) GetText() string {
@@ -162,21 +359,521 @@ func (x *Hidden) GetText() string {
 	return ""
 }
 
+type Archive struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Label         string                 `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Archive) Reset() {
+	*x = Archive{}
+	mi := &file_system_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Archive) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Archive) ProtoMessage() {}
+
+func (x *Archive) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Archive.ProtoReflect.Descriptor instead.
+func (*Archive) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Archive) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Kind          string                 `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Counts        map[string]int64       `protobuf:"bytes,3,rep,name=counts,proto3" json:"counts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	OccurredAt    *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=occurred_at,json=occurredAt,proto3" json:"occurred_at,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_system_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Event) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Event) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Event) GetCounts() map[string]int64 {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+func (x *Event) GetOccurredAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.OccurredAt
+	}
+	return nil
+}
+
+func (x *Event) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+type Session struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Session) Reset() {
+	*x = Session{}
+	mi := &file_system_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Session) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Session) ProtoMessage() {}
+
+func (x *Session) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Session.ProtoReflect.Descriptor instead.
+func (*Session) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Session) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+type Ticket struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Subject       string                 `protobuf:"bytes,1,opt,name=subject,proto3" json:"subject,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ticket) Reset() {
+	*x = Ticket{}
+	mi := &file_system_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ticket) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ticket) ProtoMessage() {}
+
+func (x *Ticket) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ticket.ProtoReflect.Descriptor instead.
+func (*Ticket) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Ticket) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+type Sku struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Lat           float64                `protobuf:"fixed64,2,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lng           float64                `protobuf:"fixed64,3,opt,name=lng,proto3" json:"lng,omitempty"`
+	Title         string                 `protobuf:"bytes,4,opt,name=title,proto3" json:"title,omitempty"`
+	Category      string                 `protobuf:"bytes,5,opt,name=category,proto3" json:"category,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Sku) Reset() {
+	*x = Sku{}
+	mi := &file_system_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Sku) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sku) ProtoMessage() {}
+
+func (x *Sku) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sku.ProtoReflect.Descriptor instead.
+func (*Sku) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Sku) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Sku) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *Sku) GetLng() float64 {
+	if x != nil {
+		return x.Lng
+	}
+	return 0
+}
+
+func (x *Sku) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Sku) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+type Invoice struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Org           string                 `protobuf:"bytes,1,opt,name=org,proto3" json:"org,omitempty"`
+	Number        string                 `protobuf:"bytes,2,opt,name=number,proto3" json:"number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Invoice) Reset() {
+	*x = Invoice{}
+	mi := &file_system_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Invoice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Invoice) ProtoMessage() {}
+
+func (x *Invoice) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Invoice.ProtoReflect.Descriptor instead.
+func (*Invoice) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Invoice) GetOrg() string {
+	if x != nil {
+		return x.Org
+	}
+	return ""
+}
+
+func (x *Invoice) GetNumber() string {
+	if x != nil {
+		return x.Number
+	}
+	return ""
+}
+
+type Page struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Title         string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Page) Reset() {
+	*x = Page{}
+	mi := &file_system_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Page) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Page) ProtoMessage() {}
+
+func (x *Page) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Page.ProtoReflect.Descriptor instead.
+func (*Page) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Page) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+type Person_Address struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	City          string                 `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	Zip           int32                  `protobuf:"varint,2,opt,name=zip,proto3" json:"zip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Person_Address) Reset() {
+	*x = Person_Address{}
+	mi := &file_system_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Person_Address) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Person_Address) ProtoMessage() {}
+
+func (x *Person_Address) ProtoReflect() protoreflect.Message {
+	mi := &file_system_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Person_Address.ProtoReflect.Descriptor instead.
+func (*Person_Address) Descriptor() ([]byte, []int) {
+	return file_system_proto_rawDescGZIP(), []int{0, 0}
+}
+
+func (x *Person_Address) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *Person_Address) GetZip() int32 {
+	if x != nil {
+		return x.Zip
+	}
+	return 0
+}
+
 var File_system_proto protoreflect.FileDescriptor
 
 const file_system_proto_rawDesc = "" +
 	"\n" +
-	"\fsystem.proto\x12\x15generatedtest.example\x1a\x1bproto/proprdb/options.proto\"]\n" +
+	"\fsystem.proto\x12\x15generatedtest.example\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1bproto/proprdb/options.proto\"\xe4\x02\n" +
 	"\x06Person\x12\x18\n" +
-	"\x04name\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x04name\x12\x16\n" +
-	"\x03age\x18\x02 \x01(\x03B\x04\x88\xb5\x18\x01R\x03age:!\xa0\xb5\x18\x01\xa8\xb5\x18\x01\xb2\xb5\x18\x06\n" +
+	"\x04name\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x04name\x12,\n" +
+	"\x03age\x18\x02 \x01(\x03B\x1a\x88\xb5\x18\x01\x91\xb6\x18\x00\x00\x00\x00\x00\x00\x00\x00\x99\xb6\x18\x00\x00\x00\x00\x00\x00i@R\x03age\x12?\n" +
+	"\aaddress\x18\x03 \x01(\v2%.generatedtest.example.Person.AddressR\aaddress\x1a5\n" +
+	"\aAddress\x12\x12\n" +
+	"\x04city\x18\x01 \x01(\tR\x04city\x12\x10\n" +
+	"\x03zip\x18\x02 \x01(\x05R\x03zip:\x04\x90\xb5\x18\x01:\x99\x01\xa0\xb5\x18\x01\xa8\xb5\x18\x01\xb2\xb5\x18\x06\n" +
 	"\x04name\xb2\xb5\x18\v\n" +
 	"\x04name\n" +
-	"\x03age\"&\n" +
-	"\x04Note\x12\x18\n" +
-	"\x04text\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x04text:\x04\x98\xb5\x18\x01\"(\n" +
+	"\x03age\xb2\xb5\x18\x0e\n" +
+	"\faddress.city\xb2\xb5\x18\n" +
+	"\x12\b\n" +
+	"\x04name\x18\x01\xb2\xb5\x18\x1e\x12\x13\"\x11lower(trim(name))\x12\a\n" +
+	"\x03age\x10\x01\xe2\xb5\x18\x13\n" +
+	"\x06adults\x12\tage >= 18\x82\xb6\x18\faddress.city\x82\xb6\x18\vaddress.zip\".\n" +
+	"\x04Note\x12\x1c\n" +
+	"\x04text\x18\x01 \x01(\tB\b\x88\xb5\x18\x01\xc0\xb5\x18\x01R\x04text:\b\x98\xb5\x18\x01\xb8\xb5\x18\x01\"@\n" +
+	"\x04Task\x12\x1e\n" +
+	"\x05title\x18\x01 \x01(\tB\b\x88\xb5\x18\x01\xe8\xb6\x18\x01R\x05title\x12\x12\n" +
+	"\x04done\x18\x02 \x01(\bR\x04done:\x04ȵ\x18\x03\"\xdd\x01\n" +
+	"\x05Tally\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12#\n" +
+	"\n" +
+	"high_score\x18\x02 \x01(\x03B\x04е\x18\x01R\thighScore\x12C\n" +
+	"\x05plays\x18\x03 \x03(\v2'.generatedtest.example.Tally.PlaysEntryB\x04е\x18\x02R\x05plays\x12\x1c\n" +
+	"\x04tags\x18\x04 \x03(\tB\bе\x18\x03\xe8\xb6\x18\x01R\x04tags\x1a8\n" +
+	"\n" +
+	"PlaysEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\"\x8a\x01\n" +
+	"\bDocument\x12\x1a\n" +
+	"\x05title\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x05title\x12\x12\n" +
+	"\x04body\x18\x02 \x01(\tR\x04body\x12\"\n" +
+	"\tembedding\x18\x03 \x03(\x02B\x04\xf0\xb6\x18\x01R\tembedding\x12$\n" +
+	"\n" +
+	"attachment\x18\x04 \x01(\fB\x04\x80\xb7\x18\x01R\n" +
+	"attachment:\x04ص\x18\x01\"(\n" +
 	"\x06Hidden\x12\x18\n" +
-	"\x04text\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x04text:\x04\x90\xb5\x18\x01B\x1eZ\x1cgeneratedtest/gen;genexampleb\x06proto3"
+	"\x04text\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x04text:\x04\x90\xb5\x18\x01\"+\n" +
+	"\aArchive\x12\x1a\n" +
+	"\x05label\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x05label:\x04\xe8\xb5\x18\x01\"\xf9\x03\n" +
+	"\x05Event\x12.\n" +
+	"\x04kind\x18\x01 \x01(\tB\x1a\x88\xb5\x18\x01\xa2\xb6\x18\x0e^[a-z][a-z-]*$\xa8\xb6\x18\x01R\x04kind\x12F\n" +
+	"\x06labels\x18\x02 \x03(\v2(.generatedtest.example.Event.LabelsEntryB\x04\x88\xb5\x18\x01R\x06labels\x12F\n" +
+	"\x06counts\x18\x03 \x03(\v2(.generatedtest.example.Event.CountsEntryB\x04\x88\xb5\x18\x01R\x06counts\x12A\n" +
+	"\voccurred_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampB\x04\x88\xb5\x18\x01R\n" +
+	"occurredAt\x12C\n" +
+	"\n" +
+	"expires_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampB\b\x88\xb5\x18\x01\x88\xb6\x18\x01R\texpiresAt\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a9\n" +
+	"\vCountsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01:2\xb2\xb5\x18\r\n" +
+	"\voccurred_at\xb2\xb5\x18\x19\x12\x06\n" +
+	"\x04kind\x12\x0f\n" +
+	"\voccurred_at\x10\x01\xf0\xb5\x18\x01\"*\n" +
+	"\aSession\x12\x18\n" +
+	"\x04user\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x04user:\x05\xf8\xb5\x18\x90\x1c\"V\n" +
+	"\x06Ticket\x12.\n" +
+	"\asubject\x18\x01 \x01(\tB\x14\x88\xb5\x18\x01Ҷ\x18\fsubject_lineR\asubject:\x1c\xb2\xb5\x18\t\n" +
+	"\asubject\xb0\xb6\x18\x01ʶ\x18\atickets\"\xd6\x01\n" +
+	"\x03Sku\x12\x18\n" +
+	"\x04name\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x04name\x12\x16\n" +
+	"\x03lat\x18\x02 \x01(\x01B\x04\x88\xb5\x18\x01R\x03lat\x12\x16\n" +
+	"\x03lng\x18\x03 \x01(\x01B\x04\x88\xb5\x18\x01R\x03lng\x12+\n" +
+	"\x05title\x18\x04 \x01(\tB\x15\x88\xb5\x18\x01\x8a\xb7\x18\rdisplay_labelR\x05title\x12\x1a\n" +
+	"\bcategory\x18\x05 \x01(\tR\bcategory:<\xb0\xb6\x18\x02\xfa\xb6\x18\n" +
+	"\n" +
+	"\x03lat\x12\x03lng\x8a\xb7\x18\r\n" +
+	"\x05title\x12\x04name\x8a\xb7\x18\x15\n" +
+	"\bcategory\x1a\t\"general\"\"C\n" +
+	"\aInvoice\x12\x1a\n" +
+	"\x03org\x18\x01 \x01(\tB\b\x88\xb5\x18\x01\xb8\xb6\x18\x01R\x03org\x12\x1c\n" +
+	"\x06number\x18\x02 \x01(\tB\x04\x88\xb5\x18\x01R\x06number\",\n" +
+	"\x04Page\x12\x1e\n" +
+	"\x05title\x18\x01 \x01(\tB\b\x88\xb5\x18\x01\xe8\xb6\x18\x01R\x05title:\x04\xc0\xb6\x18\x01B\x1eZ\x1cgeneratedtest/gen;genexampleb\x06proto3"
 
 var (
 	file_system_proto_rawDescOnce sync.Once
@@ -190,18 +887,39 @@ func file_system_proto_rawDescGZIP() []byte {
 	return file_system_proto_rawDescData
 }
 
-var file_system_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_system_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
 var file_system_proto_goTypes = []any{
-	(*Person)(nil), // 0: generatedtest.example.Person
-	(*Note)(nil),   // 1: generatedtest.example.Note
-	(*Hidden)(nil), // 2: generatedtest.example.Hidden
+	(*Person)(nil),                // 0: generatedtest.example.Person
+	(*Note)(nil),                  // 1: generatedtest.example.Note
+	(*Task)(nil),                  // 2: generatedtest.example.Task
+	(*Tally)(nil),                 // 3: generatedtest.example.Tally
+	(*Document)(nil),              // 4: generatedtest.example.Document
+	(*Hidden)(nil),                // 5: generatedtest.example.Hidden
+	(*Archive)(nil),               // 6: generatedtest.example.Archive
+	(*Event)(nil),                 // 7: generatedtest.example.Event
+	(*Session)(nil),               // 8: generatedtest.example.Session
+	(*Ticket)(nil),                // 9: generatedtest.example.Ticket
+	(*Sku)(nil),                   // 10: generatedtest.example.Sku
+	(*Invoice)(nil),               // 11: generatedtest.example.Invoice
+	(*Page)(nil),                  // 12: generatedtest.example.Page
+	(*Person_Address)(nil),        // 13: generatedtest.example.Person.Address
+	nil,                           // 14: generatedtest.example.Tally.PlaysEntry
+	nil,                           // 15: generatedtest.example.Event.LabelsEntry
+	nil,                           // 16: generatedtest.example.Event.CountsEntry
+	(*timestamppb.Timestamp)(nil), // 17: google.protobuf.Timestamp
 }
 var file_system_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	13, // 0: generatedtest.example.Person.address:type_name -> generatedtest.example.Person.Address
+	14, // 1: generatedtest.example.Tally.plays:type_name -> generatedtest.example.Tally.PlaysEntry
+	15, // 2: generatedtest.example.Event.labels:type_name -> generatedtest.example.Event.LabelsEntry
+	16, // 3: generatedtest.example.Event.counts:type_name -> generatedtest.example.Event.CountsEntry
+	17, // 4: generatedtest.example.Event.occurred_at:type_name -> google.protobuf.Timestamp
+	17, // 5: generatedtest.example.Event.expires_at:type_name -> google.protobuf.Timestamp
+	6,  // [6:6] is the sub-list for method output_type
+	6,  // [6:6] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_system_proto_init() }
@@ -215,7 +933,7 @@ func file_system_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_system_proto_rawDesc), len(file_system_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   17,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
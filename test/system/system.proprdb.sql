@@ -0,0 +1 @@
+../testdata/system.proprdb.sql.golden
\ No newline at end of file
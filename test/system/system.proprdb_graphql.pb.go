@@ -0,0 +1 @@
+../testdata/system.proprdb_graphql.pb.go.golden
\ No newline at end of file
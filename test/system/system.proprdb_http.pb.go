@@ -0,0 +1 @@
+../testdata/system.proprdb_http.pb.go.golden
\ No newline at end of file
@@ -0,0 +1 @@
+../testdata/system.proprdb_memdb.pb.go.golden
\ No newline at end of file
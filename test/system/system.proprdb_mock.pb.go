@@ -0,0 +1 @@
+../testdata/system.proprdb_mock.pb.go.golden
\ No newline at end of file